@@ -0,0 +1,205 @@
+package build
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Backblaze/blazer/b2"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// Scheme of the image archives stored in B2: b2://<bucket>/<object>
+const B2Scheme = "b2://"
+
+// Suffix of the tag reference objects written next to the B2 image archives
+const b2RefSuffix = ".ref.txt"
+
+// FetchArtifact loads an image in the docker daemon of the service and returns the loaded tags.
+// A b2://<bucket>/<object> reference is downloaded from B2 with the B2 config of the service: an
+// image archive, or a tag reference (<tag>.ref.txt) pointing to the archive whose digest is then
+// verified. Any other reference is pulled from its registry.
+func (s *BuildService) FetchArtifact(ctx context.Context, ref string) ([]string, error) {
+	return FetchImageArtifact(ctx, s.backendFor(ctx), s.b2Config, ref)
+}
+
+// FetchImageArtifact is FetchArtifact for any docker daemon, b2Config is only required by the b2:// references
+func FetchImageArtifact(ctx context.Context, docker client.ImageAPIClient, b2Config *B2Config, ref string) ([]string, error) {
+	if !strings.HasPrefix(ref, B2Scheme) {
+		return pullArtifact(ctx, docker, ref)
+	}
+
+	bucketName, objectName, ok := strings.Cut(strings.TrimPrefix(ref, B2Scheme), "/")
+	if !ok || bucketName == "" || objectName == "" {
+		return nil, fmt.Errorf("invalid B2 reference '%s', expected b2://<bucket>/<object>", ref)
+	}
+	if b2Config == nil {
+		return nil, fmt.Errorf("no B2 configuration to fetch '%s'", ref)
+	}
+	b2Client, err := b2.NewClient(ctx, b2Config.AccountID, b2Config.ApplicationKey, b2.UserAgent("build-service"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the B2 client: %w", err)
+	}
+	bucket, err := b2Client.Bucket(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access the B2 bucket '%s': %w", bucketName, err)
+	}
+
+	var digest string
+	if strings.HasSuffix(objectName, b2RefSuffix) {
+		refReader := bucket.Object(objectName).NewReader(ctx)
+		objectName, digest, err = parseB2Ref(refReader)
+		refReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the tag reference '%s': %w", ref, err)
+		}
+	}
+
+	reader := bucket.Object(objectName).NewReader(ctx)
+	defer reader.Close()
+	hash := sha256.New()
+	tags, err := LoadImage(ctx, docker, io.TeeReader(reader, hash))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the image archive '%s%s/%s': %w", B2Scheme, bucketName, objectName, err)
+	}
+	if digest != "" {
+		// The daemon may stop reading before the end of the archive
+		if _, err := io.Copy(hash, reader); err != nil {
+			return nil, fmt.Errorf("cannot read the image archive '%s': %w", objectName, err)
+		}
+		if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+			return tags, fmt.Errorf("digest mismatch for the image archive '%s': expected %s, got %s", objectName, digest, actual)
+		}
+	}
+	return tags, nil
+}
+
+// parseB2Ref reads the main object and the digest of a tag reference written by exportAndUploadImage
+func parseB2Ref(r io.Reader) (string, string, error) {
+	var mainObject, digest string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch key {
+		case "MainObject":
+			mainObject = value
+		case "Digest":
+			digest = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if mainObject == "" {
+		return "", "", fmt.Errorf("no MainObject in the tag reference")
+	}
+	return path.Clean(mainObject), digest, nil
+}
+
+// pullArtifact pulls an image with the registry credentials of the context
+func pullArtifact(ctx context.Context, docker client.ImageAPIClient, ref string) ([]string, error) {
+	registryAuth, err := registryAuthsFrom(ctx).EncodedForImage(ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the registry credentials of the image '%s': %w", ref, err)
+	}
+	reader, err := docker.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return nil, fmt.Errorf("cannot pull the image '%s': %w", ref, err)
+	}
+	defer reader.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(reader, io.Discard, 0, false, nil); err != nil {
+		return nil, fmt.Errorf("cannot pull the image '%s': %w", ref, err)
+	}
+	return []string{ref}, nil
+}
+
+// LoadImage sends an image archive (tar, gzip or zstd) to the daemon and returns the loaded
+// references, the tags first then the IDs of the untagged images
+func LoadImage(ctx context.Context, docker client.ImageAPIClient, archive io.Reader) ([]string, error) {
+	resp, err := docker.ImageLoad(ctx, archive)
+	if err != nil {
+		return nil, fmt.Errorf("error during the image loading: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tags, ids []string
+	collect := func(line string) {
+		line = strings.TrimSpace(line)
+		if ref, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+			tags = append(tags, ref)
+		} else if id, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	if !resp.JSON {
+		// Daemons answering in plain text, one line by loaded image
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			collect(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading the image load response: %w", err)
+		}
+		return append(tags, ids...), nil
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("error decoding the image load response: %w", err)
+		}
+		if msg.Error != nil {
+			return nil, fmt.Errorf("image load failed: %s", msg.Error.Message)
+		}
+		collect(msg.Stream)
+	}
+	return append(tags, ids...), nil
+}
+
+// ArchiveRepoTags returns the tags recorded in the manifest.json of an image archive (tar, gzip or zstd),
+// in the order of its images. They are the tags given to the images by the daemon loading the archive.
+func ArchiveRepoTags(archive io.Reader) ([]string, error) {
+	reader, err := newDecompressReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress the image archive: %w", err)
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no manifest.json in the image archive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the image archive: %w", err)
+		}
+		if path.Clean(header.Name) != "manifest.json" {
+			continue
+		}
+		var manifest []struct {
+			RepoTags []string `json:"RepoTags"`
+		}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest.json in the image archive: %w", err)
+		}
+		var tags []string
+		for _, entry := range manifest {
+			tags = append(tags, entry.RepoTags...)
+		}
+		return tags, nil
+	}
+}
@@ -0,0 +1,100 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadClient answers the image loads with a canned response
+type loadClient struct {
+	client.ImageAPIClient
+	response string
+	plain    bool // Answers in plain text rather than in JSON messages
+	loaded   []byte
+}
+
+func (c *loadClient) ImageLoad(ctx context.Context, input io.Reader, _ ...client.ImageLoadOption) (image.LoadResponse, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return image.LoadResponse{}, err
+	}
+	c.loaded = data
+	return image.LoadResponse{Body: io.NopCloser(strings.NewReader(c.response)), JSON: !c.plain}, nil
+}
+
+func TestLoadImage(t *testing.T) {
+	docker := &loadClient{response: `{"stream":"Loaded image ID: sha256:abc\n"}
+{"stream":"Loaded image: shop/api:1.0\n"}
+`}
+	tags, err := LoadImage(context.Background(), docker, strings.NewReader("archive"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop/api:1.0", "sha256:abc"}, tags)
+	assert.Equal(t, "archive", string(docker.loaded))
+
+	docker.response = `{"errorDetail":{"message":"invalid tar header"},"error":"invalid tar header"}`
+	_, err = LoadImage(context.Background(), docker, strings.NewReader("archive"))
+	assert.ErrorContains(t, err, "invalid tar header")
+
+	docker = &loadClient{plain: true, response: "Loaded image: shop/api:1.0\nLoaded image: shop/api:latest\n"}
+	tags, err = LoadImage(context.Background(), docker, strings.NewReader("archive"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop/api:1.0", "shop/api:latest"}, tags)
+}
+
+// imageArchive returns a `docker save` like tar holding a manifest.json
+func imageArchive(t *testing.T, manifest string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{"blobs/sha256/abc": "layer", "manifest.json": manifest} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestArchiveRepoTags(t *testing.T) {
+	archive := imageArchive(t, `[{"Config":"blobs/sha256/abc","RepoTags":["shop/api:1.0","shop/api:latest"]},{"RepoTags":["shop/worker:1.0"]}]`)
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		var compressed bytes.Buffer
+		_, _, err := writeImageArchive(&compressed, bytes.NewReader(archive), compression, 0)
+		require.NoError(t, err)
+		tags, err := ArchiveRepoTags(&compressed)
+		require.NoError(t, err, compression)
+		assert.Equal(t, []string{"shop/api:1.0", "shop/api:latest", "shop/worker:1.0"}, tags, compression)
+	}
+
+	_, err := ArchiveRepoTags(bytes.NewReader(imageArchive(t, "{")))
+	assert.ErrorContains(t, err, "invalid manifest.json")
+	_, err = ArchiveRepoTags(strings.NewReader("not an archive"))
+	assert.Error(t, err)
+}
+
+func TestParseB2Ref(t *testing.T) {
+	ref := "ImageID: abc\nTag: shop/api:1.0\nVersion: 1.0\nServiceName: api\nMainObject: images/api-1.0.tar.zst\nDigest: sha256:0123\n"
+	object, digest, err := parseB2Ref(strings.NewReader(ref))
+	require.NoError(t, err)
+	assert.Equal(t, "images/api-1.0.tar.zst", object)
+	assert.Equal(t, "sha256:0123", digest)
+
+	_, _, err = parseB2Ref(strings.NewReader("Tag: shop/api:1.0\n"))
+	assert.Error(t, err)
+}
+
+func TestFetchImageArtifact_InvalidB2Ref(t *testing.T) {
+	_, err := FetchImageArtifact(context.Background(), &loadClient{}, &B2Config{}, "b2://bucket-only")
+	assert.Error(t, err)
+	_, err = FetchImageArtifact(context.Background(), &loadClient{}, nil, "b2://bucket/images/api-1.0.tar")
+	assert.ErrorContains(t, err, "no B2 configuration")
+}
@@ -0,0 +1,152 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Container engines supported by the builds
+const (
+	BackendDocker = "docker"
+	BackendPodman = "podman"
+)
+
+// ContainerBackend is the container engine building and storing the images of the builds.
+// The Docker engine API is the common ground: Podman serves it too (`podman system service`),
+// the backends differ by the daemon they reach and by the CLI running the BuildKit session builds.
+type ContainerBackend interface {
+	client.ImageAPIClient
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerRemove(ctx context.Context, container string, options container.RemoveOptions) error
+	CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, container.PathStat, error)
+	Ping(ctx context.Context) (types.Ping, error)
+	Close() error
+
+	// Engine returns the name of the engine, BackendDocker or BackendPodman
+	Engine() string
+	// BuildCommand returns the CLI command running a build against the daemon of the backend, args
+	// are the `docker build` arguments and configDir a docker config directory holding the registry
+	// credentials ("" keeps the config of the user)
+	BuildCommand(ctx context.Context, args []string, configDir string) *exec.Cmd
+}
+
+// NewContainerBackend returns the backend of an engine, "" is docker
+func NewContainerBackend(engine string) (ContainerBackend, error) {
+	switch engine {
+	case "", BackendDocker:
+		return NewDockerBackend()
+	case BackendPodman:
+		return NewPodmanBackend("")
+	}
+	return nil, fmt.Errorf("unknown container backend '%s', expected '%s' or '%s'", engine, BackendDocker, BackendPodman)
+}
+
+// dockerBackend is a docker daemon, the one of the environment or a remote one (NewRemoteDockerBackend)
+type dockerBackend struct {
+	*client.Client
+	cliFlags []string // TLS flags of the CLI for a remote daemon
+	remote   bool     // The TLS settings of the environment don't apply
+}
+
+// NewDockerBackend connects to the docker daemon of the environment (DOCKER_HOST, DOCKER_TLS_VERIFY, DOCKER_CERT_PATH)
+func NewDockerBackend() (ContainerBackend, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("error during the Docker client initialization: %w", err)
+	}
+	return &dockerBackend{Client: cli}, nil
+}
+
+func (b *dockerBackend) Engine() string { return BackendDocker }
+
+func (b *dockerBackend) BuildCommand(ctx context.Context, args []string, configDir string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "docker", append(append([]string{}, b.cliFlags...), args...)...)
+	env := os.Environ()
+	if b.remote {
+		env = withoutEnv(env, "DOCKER_TLS_VERIFY", "DOCKER_CERT_PATH", "DOCKER_CONTEXT")
+	}
+	cmd.Env = append(env, "DOCKER_BUILDKIT=1", "DOCKER_HOST="+b.DaemonHost())
+	if configDir != "" {
+		cmd.Env = append(cmd.Env, "DOCKER_CONFIG="+configDir)
+	}
+	return cmd
+}
+
+// withoutEnv removes variables from an environment
+func withoutEnv(env []string, names ...string) []string {
+	kept := make([]string, 0, len(env))
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if !slices.Contains(names, name) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// podmanBackend is the Docker compatible API of Podman, no Docker daemon is needed on the host
+type podmanBackend struct {
+	*client.Client
+}
+
+// NewPodmanBackend connects to the Podman API socket at host (unix:///run/podman/podman.sock),
+// "" for $CONTAINER_HOST or else the socket of the user. The socket is served by the
+// podman.socket systemd unit or by `podman system service`.
+func NewPodmanBackend(host string) (ContainerBackend, error) {
+	if host == "" {
+		host = defaultPodmanHost()
+	}
+	if !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "tcp://") {
+		return nil, fmt.Errorf("unsupported Podman host '%s', expected unix:// or tcp://", host)
+	}
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("error during the Podman client initialization: %w", err)
+	}
+	return &podmanBackend{Client: cli}, nil
+}
+
+// defaultPodmanHost returns $CONTAINER_HOST, the rootful socket for root and the rootless one of the user otherwise
+func defaultPodmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if os.Geteuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Geteuid())
+	}
+	return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+}
+
+func (b *podmanBackend) Engine() string { return BackendPodman }
+
+// BuildCommand runs `podman --remote build` so the image is stored by the service behind the
+// socket. Podman has no --progress flag and reads the credentials from an auth file.
+func (b *podmanBackend) BuildCommand(ctx context.Context, args []string, configDir string) *exec.Cmd {
+	podmanArgs := []string{"--remote", "--url", b.DaemonHost()}
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--progress") {
+			continue
+		}
+		podmanArgs = append(podmanArgs, arg)
+		if i == 0 && configDir != "" {
+			podmanArgs = append(podmanArgs, "--authfile", filepath.Join(configDir, "config.json"))
+		}
+	}
+	return exec.CommandContext(ctx, "podman", podmanArgs...)
+}
@@ -0,0 +1,48 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewContainerBackend(t *testing.T) {
+	t.Setenv("CONTAINER_HOST", "unix:///tmp/podman.sock")
+
+	backend, err := NewContainerBackend("")
+	require.NoError(t, err)
+	assert.Equal(t, BackendDocker, backend.Engine())
+
+	backend, err = NewContainerBackend(BackendPodman)
+	require.NoError(t, err)
+	assert.Equal(t, BackendPodman, backend.Engine())
+
+	_, err = NewContainerBackend("rkt")
+	assert.Error(t, err)
+	_, err = NewPodmanBackend("ssh://core@host/run/podman/podman.sock")
+	assert.Error(t, err)
+}
+
+func TestBuildCommand(t *testing.T) {
+	args := []string{"build", "--progress=plain", "--iidfile", "/tmp/s/iid", "-t", "app:1.0", "/ctx"}
+
+	docker, err := NewDockerBackend()
+	require.NoError(t, err)
+	cmd := docker.BuildCommand(context.Background(), args, "/tmp/s")
+	assert.Equal(t, append([]string{"docker"}, args...), cmd.Args)
+	assert.Contains(t, cmd.Env, "DOCKER_CONFIG=/tmp/s")
+	assert.Contains(t, cmd.Env, "DOCKER_BUILDKIT=1")
+
+	podman, err := NewPodmanBackend("unix:///tmp/podman.sock")
+	require.NoError(t, err)
+	cmd = podman.BuildCommand(context.Background(), args, "/tmp/s")
+	assert.Equal(t, []string{
+		"podman", "--remote", "--url", "unix:///tmp/podman.sock",
+		"build", "--authfile", "/tmp/s/config.json", "--iidfile", "/tmp/s/iid", "-t", "app:1.0", "/ctx",
+	}, cmd.Args)
+
+	cmd = podman.BuildCommand(context.Background(), args, "")
+	assert.NotContains(t, cmd.Args, "--authfile")
+}
@@ -256,6 +256,49 @@ services:
 	assert.Equal(t, "db", *apiService.Environment["DB_HOST"])
 }
 
+func TestResolveComposeEnvFiles(t *testing.T) {
+	composeDir := t.TempDir()
+	createTempFile(t, composeDir, "common.env", "LOG_LEVEL=info\nDB_HOST=from-common\n")
+	createTempFile(t, composeDir, "web.env", "DB_HOST=from-web\n")
+	composeData := `
+services:
+  web:
+    image: web:latest
+    env_file:
+      - common.env
+      - path: web.env
+      - path: optional.env
+        required: false
+    environment:
+      LOG_LEVEL: debug
+  worker:
+    image: worker:latest
+    env_file: common.env
+  broken:
+    image: broken:latest
+    env_file: missing.env
+`
+	project, err := LoadComposeFile([]byte(composeData))
+	require.NoError(t, err)
+	require.Len(t, project.Services["worker"].EnvFile, 1)
+	assert.True(t, project.Services["worker"].EnvFile[0].Required)
+	assert.False(t, project.Services["web"].EnvFile[2].Required)
+
+	_, err = ResolveComposeEnvFiles(project, composeDir)
+	require.Error(t, err) // missing.env is required
+	assert.Contains(t, err.Error(), "broken")
+
+	delete(project.Services, "broken")
+	consumed, err := ResolveComposeEnvFiles(project, composeDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(composeDir, "common.env"), filepath.Join(composeDir, "web.env")}, consumed["web"])
+
+	web := project.Services["web"]
+	assert.Equal(t, "debug", *web.Environment["LOG_LEVEL"])  // environment wins over env_file
+	assert.Equal(t, "from-web", *web.Environment["DB_HOST"]) // the later file wins
+	assert.Equal(t, "from-common", *project.Services["worker"].Environment["DB_HOST"])
+}
+
 func TestGenerateRunYAML_SimpleDocker(t *testing.T) {
 	spec := &BuildSpec{
 		Name:    "my-app",
@@ -381,7 +424,7 @@ services:
 	assert.Equal(t, "web_val", webSvc.Environment["WEB_VAR"])
 	assert.Equal(t, "on", webSvc.Environment["GLOBAL"]) // Variable globale héritée
 	assert.Contains(t, webSvc.Ports, "80:80")
-	assert.Contains(t, webSvc.DependsOn, "api")
+	assert.Contains(t, webSvc.DependsOn.Services(), "api")
 
 	apiSvc := runYAML.Services["api"]
 	assert.Equal(t, "compose-proj_api.tar", apiSvc.Image)
@@ -542,6 +585,7 @@ CMD ["cat", "content.txt"]
 	// Assertions
 	require.NoError(t, err, "Build error message: %s", result.ErrorMessage) // Afficher le message d'erreur si le test échoue
 	require.True(t, result.Success, "Build should be successful")
+	assert.True(t, strings.HasPrefix(result.BuildID, spec.Name+"-"+spec.Version+"-"), "Build ID should start with the name and the version")
 	require.NotEmpty(t, result.ImageIDs[spec.Name], "Image ID should not be empty")
 	assert.True(t, result.ImageSizes[spec.Name] > 0, "Image size should be positive")
 	assert.Contains(t, result.Logs, "Successfully built", "Build logs should indicate success")
@@ -817,3 +861,25 @@ CMD cat /app/resource.txt
 }
 
 // TODO: Ajouter TestIntegration_BuildWithSteps (plus complexe à mettre en place)
+
+func TestBuildCLIArgs(t *testing.T) {
+	spec := &BuildSpec{BuildConfig: BuildConfig{
+		Tags:       []string{"app:1.0"},
+		Args:       map[string]string{"B": "2", "A": "1"},
+		Target:     "prod",
+		SSHForward: []string{"default"},
+		Labels:     map[string]string{LabelRevision: "abc123"},
+	}}
+	args := buildCLIArgs("/ctx", "/ctx/sub/Dockerfile", spec, map[string]string{"npm_token": "/tmp/s/secret-0"}, "/tmp/s/iid")
+	assert.Equal(t, []string{
+		"build", "--progress=plain", "--iidfile", "/tmp/s/iid", "-f", "/ctx/Dockerfile",
+		"-t", "app:1.0", "--build-arg", "A=1", "--build-arg", "B=2",
+		"--label", "org.opencontainers.image.revision=abc123", "--target", "prod",
+		"--secret", "id=npm_token,src=/tmp/s/secret-0", "--ssh", "default", "/ctx",
+	}, args)
+
+	ctx := context.Background()
+	assert.False(t, needsBuildKitSession(ctx, &BuildSpec{}))
+	assert.True(t, needsBuildKitSession(withBuildSecrets(ctx, map[string]string{"npm_token": "x"}), &BuildSpec{}))
+	assert.True(t, needsBuildKitSession(ctx, spec))
+}
@@ -7,8 +7,10 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -43,22 +45,28 @@ type HealthCheck struct {
 	Timeout     string   `yaml:"timeout,omitempty"`
 	Retries     *int     `yaml:"retries,omitempty"`
 	StartPeriod string   `yaml:"start_period,omitempty"`
+	Disable     bool     `yaml:"disable,omitempty"`
 }
 
 // --- Service Initialization ---
 
 // Create a new instance of the build service
 func NewBuildService(workDir string, inMemory bool, secretFetcher SecretFetcher) (*BuildService, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	backend, err := NewDockerBackend()
 	if err != nil {
-		return nil, fmt.Errorf("error during the Docker client initialization: %w", err)
+		return nil, err
 	}
+	return NewBuildServiceWithBackend(workDir, inMemory, secretFetcher, backend)
+}
 
+// NewBuildServiceWithBackend creates the service with another container engine than the docker
+// daemon of the environment, see NewContainerBackend
+func NewBuildServiceWithBackend(workDir string, inMemory bool, secretFetcher SecretFetcher, backend ContainerBackend) (*BuildService, error) {
 	// Creating the working directory
 	effectiveWorkDir := workDir
 	if inMemory && workDir == "" {
 		// Memory mode creating the working temp dir
-		tmpDir, err := os.MkdirTemp("", "buildservice-work-")
+		tmpDir, err := os.MkdirTemp("", tempWorkDirPrefix)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create the temp working dir: %w", err)
 		}
@@ -71,7 +79,7 @@ func NewBuildService(workDir string, inMemory bool, secretFetcher SecretFetcher)
 	}
 
 	return &BuildService{
-		dockerClient:  cli,
+		backend:       backend,
 		workDir:       effectiveWorkDir,
 		inMemory:      inMemory,
 		secretFetcher: secretFetcher, // Inject the secret fetcher
@@ -95,11 +103,16 @@ func (s *BuildService) SetB2Config(config *B2Config) {
 
 // --- Core Build Logic ---
 
-// Running the build based on the provided spec
+// Running the build based on the provided spec.
+// Each build works in its own directory so several builds can run at the same time (see Submit).
 func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	return s.BuildWithEvents(ctx, spec, nil)
+}
 
+// BuildWithEvents runs the build and sends its events (phases, logs, warnings, artifacts) to the channel.
+// The channel is closed when the build returns and must be drained by the caller, nil disables the stream.
+// BuildResult.Logs still holds the logs flattened from the events.
+func (s *BuildService) BuildWithEvents(ctx context.Context, spec *BuildSpec, eventsCh chan<- BuildEvent) (*BuildResult, error) {
 	startTime := time.Now()
 	result := &BuildResult{
 		Artifacts:       make(map[string][]byte), // Legacy, might remove
@@ -108,20 +121,57 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		ImageSizes:      make(map[string]int64),
 		LocalImagePaths: make(map[string]string),
 		ServiceOutputs:  make(map[string]ServiceOutput),
+		Codebases:       make(map[string]CodebaseRevision),
+		ArchiveDigests:  make(map[string]string),
 	}
-	var overallLogs strings.Builder // Collect logs from all steps
+	buildID := fmt.Sprintf("%s-%s-%d", spec.Name, spec.Version, time.Now().UnixNano())
+	result.BuildID = buildID
+	logger := s.Logger().With(LogKeyBuildID, buildID)
+	ctx = withLogger(ctx, logger)
+	events := newEventStream(eventsCh)
+	events.SetLogger(logger)
+	defer func() {
+		events.Close(result.ErrorMessage)
+		result.Timings = events.Timings()
+	}()
 
 	// --- 1. Setup Build Environment ---
-	buildID := fmt.Sprintf("%s-%s-%d", spec.Name, spec.Version, time.Now().UnixNano())
+	events.StartPhase(PhaseSetup)
+	// Two builds of the same name and version would write the same outputs and tags
+	unlock, err := s.lockBuild(ctx, spec, events.Logf)
+	if err != nil {
+		code := CodeSetup
+		if errors.Is(err, ErrBuildInProgress) {
+			code = CodeBuildInProgress
+		}
+		return failBuild(result, events, code, err.Error(), err)
+	}
+	defer unlock()
 	buildDir := filepath.Join(s.workDir, buildID) // Main directory for this build
 
 	if err := os.MkdirAll(buildDir, 0755); err != nil {
-		result.Success = false
-		result.ErrorMessage = fmt.Sprintf("cannot create the build dir '%s': %v", buildDir, err)
-		return result, fmt.Errorf("error during the run: \n %s", result.ErrorMessage)
+		return failBuild(result, events, CodeSetup, fmt.Sprintf("cannot create the build dir '%s': %v", buildDir, err), err)
 	}
-	// Cleanup build directory unless OutputTarget is local and no path is specified
-	shouldCleanup := !(spec.BuildConfig.OutputTarget == "local" && spec.BuildConfig.LocalPath == "")
+	// The journal lets RecoverOrphans clean the resources of this build if the process dies before the end
+	journal := s.openJournal(buildID)
+	defer journal.Close()
+	journal.Track(ResourceDir, buildDir)
+	ctx = withJournal(ctx, journal)
+
+	// A remote daemon dedicated to the builds replaces the backend of the service
+	if spec.DockerHost != nil {
+		backend, err := connectDockerHost(ctx, *spec.DockerHost)
+		if err != nil {
+			return failBuild(result, events, CodeSetup, fmt.Sprintf("cannot connect to the docker host: %v", err), err)
+		}
+		defer backend.Close()
+		ctx = withBackend(ctx, backend)
+		events.Logf("Building on the docker host %s", spec.DockerHost.Host)
+	}
+
+	// Cleanup build directory unless the local outputs are written in it
+	outputBasePath := s.outputBasePath(spec, buildDir)
+	shouldCleanup := !(spec.BuildConfig.OutputTarget == "local" && outputBasePath == buildDir)
 	if shouldCleanup {
 		defer func() {
 			// Add some robustness: Check if buildDir still exists
@@ -130,9 +180,10 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 			}
 		}()
 	}
-	overallLogs.WriteString(fmt.Sprintf("Using build directory: %s\n", buildDir))
+	events.Logf("Using build directory: %s", buildDir)
 
 	// --- 2. Load Environment Variables ---
+	events.StartPhase(PhaseEnv)
 	mergedEnv := make(map[string]string)
 	// Load from EnvFiles first
 	for _, envFile := range spec.EnvFiles {
@@ -144,7 +195,7 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		}
 		envMap, err := godotenv.Read(envFilePath)
 		if err != nil {
-			overallLogs.WriteString(fmt.Sprintf("Warning: cannot read env file '%s': %v\n", envFile, err))
+			events.Warnf("cannot read env file '%s': %v", envFile, err)
 		} else {
 			for k, v := range envMap {
 				if _, exists := mergedEnv[k]; !exists { // Avoid overriding already set vars from earlier files
@@ -157,31 +208,65 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 	for k, v := range spec.Env {
 		mergedEnv[k] = v
 	}
-	overallLogs.WriteString(fmt.Sprintf("Loaded %d environment variables\n", len(mergedEnv)))
+	// Project level variables come below the spec values
+	if added := s.mergeProjectEnv(spec, mergedEnv); added > 0 {
+		events.Logf("Added %d project environment variables", added)
+	}
+	events.Logf("Loaded %d environment variables", len(mergedEnv))
 
 	// --- 3. Fetch Secrets (Placeholder) ---
+	events.StartPhase(PhaseSecrets)
 	runtimeSecrets := make(map[string]string) // Secrets for runtime (.run.yml)
-	if s.secretFetcher != nil && len(spec.Secrets) > 0 {
-		overallLogs.WriteString("Fetching secrets...\n")
-		for _, secretSpec := range spec.Secrets {
-			if secretSpec.InjectMethod == "" || secretSpec.InjectMethod == "env" {
-				secretValue, err := s.secretFetcher.GetSecret(ctx, secretSpec.Source)
+	buildSecrets := make(map[string]string)   // Secrets mounted during the image builds only
+	var secretFiles []RunSecretFile           // Secrets mounted as files at run time
+	secretSpecs := s.effectiveSecrets(spec)
+	if s.secretFetcher != nil && len(secretSpecs) > 0 {
+		events.Log("Fetching secrets...")
+		for _, secretSpec := range secretSpecs {
+			switch secretSpec.InjectMethod {
+			case "", InjectEnv, InjectBuild, InjectFile:
+				value, err := resolveSecret(ctx, s.secretFetcher, secretSpec)
 				if err != nil {
 					errMsg := fmt.Sprintf("error during the secret creation '%s' (source: %s): %v", secretSpec.Name, secretSpec.Source, err)
-					overallLogs.WriteString(errMsg + "\n")
-					result.Success = false
-					result.ErrorMessage = errMsg
-					result.Logs = overallLogs.String()
-					return result, fmt.Errorf("error during the run: \n %s", errMsg)
+					events.Log(errMsg)
+					return failBuild(result, events, CodeSecretFetch, errMsg, err)
 				}
-				runtimeSecrets[secretSpec.Name] = secretValue
-				overallLogs.WriteString(fmt.Sprintf("Secret '%s' fetched successfully.\n", secretSpec.Name))
-			} else {
-				overallLogs.WriteString(fmt.Sprintf("Warning: Secret injection method '%s' for '%s' not yet supported.\n", secretSpec.InjectMethod, secretSpec.Name))
+				secretValue := string(value)
+				switch secretSpec.InjectMethod {
+				case InjectBuild:
+					buildSecrets[secretSpec.Name] = secretValue
+				case InjectFile:
+					secretFiles = append(secretFiles, newRunSecretFile(secretSpec, value))
+				default:
+					runtimeSecrets[secretSpec.Name] = secretValue
+				}
+				events.AddSecret(secretValue)
+				events.Logf("Secret '%s' fetched successfully.", secretSpec.Name)
+			default:
+				events.Warnf("Secret injection method '%s' for '%s' not yet supported.", secretSpec.InjectMethod, secretSpec.Name)
 			}
 		}
 	}
 
+	if len(buildSecrets) > 0 {
+		ctx = withBuildSecrets(ctx, buildSecrets)
+	}
+
+	// Registry credentials for the base images, the cache sources and the pushes
+	auths, err := resolveRegistryAuths(ctx, s.secretFetcher, spec.Registries)
+	if err != nil {
+		errMsg := fmt.Sprintf("error during the registry credentials fetching: %v", err)
+		events.Log(errMsg)
+		return failBuild(result, events, CodeSecretFetch, errMsg, err)
+	}
+	for _, secret := range auths.Secrets() {
+		events.AddSecret(secret)
+	}
+	if len(spec.Registries) > 0 {
+		events.Logf("Loaded the credentials of %d registries", len(spec.Registries))
+	}
+	ctx = withRegistryAuths(ctx, auths)
+
 	// Combine regular envs and secret envs for runtime config
 	finalRuntimeEnv := make(map[string]string)
 	for k, v := range mergedEnv {
@@ -192,50 +277,45 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 	}
 
 	// --- 4. Download Resources ---
-	overallLogs.WriteString("Downloading resources...\n")
+	events.StartPhase(PhaseResources)
+	events.Log("Downloading resources...")
 	for _, res := range spec.Resources {
-		overallLogs.WriteString(fmt.Sprintf("Downloading %s to %s...\n", res.URL, res.TargetPath))
+		events.Logf("Downloading %s to %s...", res.URL, res.TargetPath)
 		targetFullPath := filepath.Join(buildDir, res.TargetPath)
 		targetDir := filepath.Dir(targetFullPath)
 		if err := os.MkdirAll(targetDir, 0755); err != nil {
 			errMsg := fmt.Sprintf("error during the resource target directory creation '%s': %v", targetFullPath, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeResourceDownload, errMsg, err)
 		}
 
 		err := s.downloadFile(ctx, res.URL, targetFullPath)
 		if err != nil {
 			errMsg := fmt.Sprintf("error during the resource downloading '%s': %v", res.URL, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeResourceDownload, errMsg, err)
 		}
 
 		if res.Extract {
-			overallLogs.WriteString(fmt.Sprintf("Extracting %s...\n", targetFullPath))
+			events.Logf("Extracting %s...", targetFullPath)
 			// Extract needs to place files inside targetDir, not create a new subdir named after the archive
 			err := s.extractArchive(targetFullPath, targetDir)
 			if err != nil {
 				errMsg := fmt.Sprintf("error during the archive extraction '%s': %v", targetFullPath, err)
 				// Log warning but continue? Or fail? Let's fail for now.
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeResourceDownload, errMsg, err)
 			}
 			// Optionally remove the archive after extraction
 			os.Remove(targetFullPath)
-			overallLogs.WriteString(fmt.Sprintf("Extracted %s successfully.\n", res.TargetPath))
+			events.Logf("Extracted %s successfully.", res.TargetPath)
 		}
 	}
 
 	// --- 5. Prepare Codebases ---
-	overallLogs.WriteString("Fetching codebases...\n")
+	events.StartPhase(PhaseCodebases)
+	events.Log("Fetching codebases...")
 	codebaseMap := make(map[string]CodebaseConfig) // For easy lookup by name
-	for _, codebase := range spec.Codebases {
+	codebaseDirs := make(map[string]string)        // Directory of each codebase in buildDir
+	for i, codebase := range spec.Codebases {
+		events.Progressf(codebase.Name, i+1, len(spec.Codebases), "Fetching codebase '%s'", codebase.Name)
 		codebaseMap[codebase.Name] = codebase
 		var destDir string
 		// If TargetInHost is specified, place it there relative to buildDir
@@ -246,28 +326,38 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 			destDir = filepath.Join(buildDir, codebase.Name)
 		}
 
-		overallLogs.WriteString(fmt.Sprintf("Fetching codebase '%s' (%s: %s) into %s\n", codebase.Name, codebase.SourceType, codebase.Source, destDir))
+		events.Logf("Fetching codebase '%s' (%s: %s) into %s", codebase.Name, codebase.SourceType, codebase.Source, destDir)
 		if err := s.fetchCodebase(ctx, codebase, destDir); err != nil {
 			errMsg := fmt.Sprintf("error during the codebase fetching '%s': %v", codebase.Name, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeCodebaseFetch, errMsg, err)
+		}
+		codebaseDirs[codebase.Name] = destDir
+
+		revision, err := readCodebaseRevision(codebase, destDir)
+		if err != nil {
+			events.Warnf("cannot resolve the revision of the codebase '%s': %v", codebase.Name, err)
+		} else if revision != nil {
+			result.Codebases[codebase.Name] = *revision
+			events.Logf("Codebase '%s' is at commit %s", codebase.Name, revision.Commit)
 		}
 	}
 
 	// --- 6. Execute Build Steps (Sequential Build & Binary Handling) ---
+	events.StartPhase(PhaseSteps)
 	extractedBinaries := make(map[string][]byte) // Map step name -> binary data
-	overallLogs.WriteString("Executing build steps...\n")
-	for _, step := range spec.BuildSteps {
-		overallLogs.WriteString(fmt.Sprintf("--- Build Step: %s ---\n", step.Name))
+	var stepTags []string                        // Temporary images of the steps, removed when the build ends
+	if !spec.BuildConfig.KeepStepImages {
+		defer func() { s.pruneStepImages(context.WithoutCancel(ctx), stepTags, events) }()
+	}
+	events.Log("Executing build steps...")
+	for i, step := range spec.BuildSteps {
+		events.Progressf(step.Name, i+1, len(spec.BuildSteps), "Building step '%s'", step.Name)
+		events.ServiceLogf(step.Name, "--- Build Step: %s ---", step.Name)
+		stepStarted := time.Now()
 		cb, ok := codebaseMap[step.CodebaseName]
 		if !ok {
 			errMsg := fmt.Sprintf("build step '%s' referencing a non existent codebase: '%s'", step.Name, step.CodebaseName)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeBuildStep, errMsg, nil)
 		}
 
 		stepBuildDir := filepath.Join(buildDir, cb.Name) // Assume codebase is in its named dir
@@ -277,35 +367,23 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 			binaryData, exists := extractedBinaries[step.UseBinaryFromStep]
 			if !exists {
 				errMsg := fmt.Sprintf("build step '%s' require a binary for the step '%s', but it's not found", step.Name, step.UseBinaryFromStep)
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeBuildStep, errMsg, nil)
 			}
 			if step.BinaryTargetPath == "" {
 				errMsg := fmt.Sprintf("build step '%s' uses a 'binary_target_path' not defined", step.Name)
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeBuildStep, errMsg, nil)
 			}
 
 			targetBinaryPath := filepath.Join(stepBuildDir, step.BinaryTargetPath)
 			targetBinaryDir := filepath.Dir(targetBinaryPath)
-			overallLogs.WriteString(fmt.Sprintf("Injecting binary from step '%s' to '%s'\n", step.UseBinaryFromStep, targetBinaryPath))
+			events.Logf("Injecting binary from step '%s' to '%s'", step.UseBinaryFromStep, targetBinaryPath)
 			if err := os.MkdirAll(targetBinaryDir, 0755); err != nil {
 				errMsg := fmt.Sprintf("error during the repertory '%s' creation for the injected binary: %v", targetBinaryDir, err)
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeBuildStep, errMsg, err)
 			}
 			if err := os.WriteFile(targetBinaryPath, binaryData, 0755); err != nil { // Make executable
 				errMsg := fmt.Sprintf("error during the binary writing '%s': %v", targetBinaryPath, err)
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeBuildStep, errMsg, err)
 			}
 		}
 
@@ -314,11 +392,14 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		stepDockerfilePath := filepath.Join(stepBuildDir, "Dockerfile") // Default assumption
 		// Allow overriding Dockerfile path via CodebaseConfig or BuildStep? For now, default.
 		if _, err := os.Stat(stepDockerfilePath); os.IsNotExist(err) {
-			errMsg := fmt.Sprintf("No Dockerfile founded '%s' in the build step '%s' (waiting path: %s)", cb.Name, step.Name, stepDockerfilePath)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			// Without Dockerfile, the one of the template of the detected ecosystem is used
+			generated, genErr := generateDockerfile(stepBuildDir, "", nil)
+			if genErr != nil {
+				errMsg := fmt.Sprintf("No Dockerfile founded '%s' in the build step '%s' (waiting path: %s) and none could be generated: %v", cb.Name, step.Name, stepDockerfilePath, genErr)
+				return failBuild(result, events, CodeBuildStep, errMsg, genErr)
+			}
+			stepDockerfilePath = generated.Path
+			events.ServiceLogf(step.Name, "Generated a Dockerfile for the %s (%s) codebase '%s':\n%s", generated.Ecosystem.Language, generated.Ecosystem.PackageManager, cb.Name, generated.Content)
 		}
 
 		// Create a temporary BuildSpec for this step
@@ -331,74 +412,64 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 				NoCache: spec.BuildConfig.NoCache,
 				Tags:    []string{fmt.Sprintf("%s-%s-step-%s:latest", spec.Name, spec.Version, step.Name)}, // Temporary tag
 				Pull:    spec.BuildConfig.Pull,
+				// The steps can clone private dependencies too
+				SSHForward: spec.BuildConfig.SSHForward,
+				Labels:     imageLabels(buildID, nil, nil, "", nil),
 			},
 		}
+		stepTags = append(stepTags, stepSpec.BuildConfig.Tags...)
 
 		// Build the image for the step
 		stepImageID, stepLogs, err := s.buildSingleImage(ctx, stepBuildDir, stepDockerfilePath, stepSpec)
-		overallLogs.WriteString(fmt.Sprintf("Logs for step %s:\n%s\n", step.Name, stepLogs))
+		events.ServiceLogf(step.Name, "Logs for step %s:\n%s", step.Name, stepLogs)
 		if err != nil {
 			errMsg := fmt.Sprintf("error during the step build '%s': %v", step.Name, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeBuildStep, errMsg, err)
 		}
-		overallLogs.WriteString(fmt.Sprintf("Step '%s' built successfully, ImageID: %s\n", step.Name, stepImageID))
+		events.ServiceLogf(step.Name, "Step '%s' built successfully, ImageID: %s", step.Name, stepImageID)
 
 		// Extract binary if needed
 		if step.OutputsBinaryPath != "" {
-			overallLogs.WriteString(fmt.Sprintf("Extracting binary '%s' from step '%s' image %s\n", step.OutputsBinaryPath, step.Name, stepImageID))
+			events.ServiceLogf(step.Name, "Extracting binary '%s' from step '%s' image %s", step.OutputsBinaryPath, step.Name, stepImageID)
 			binaryData, err := s.extractFromContainer(ctx, stepImageID, step.OutputsBinaryPath)
 			if err != nil {
 				errMsg := fmt.Sprintf("erro during the extraction of the binary '%s' in the step '%s': %v", step.OutputsBinaryPath, step.Name, err)
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeBuildStep, errMsg, err)
 			}
 			extractedBinaries[step.Name] = binaryData
-			overallLogs.WriteString(fmt.Sprintf("Binary extracted successfully (%d bytes).\n", len(binaryData)))
+			events.Logf("Binary extracted successfully (%d bytes).", len(binaryData))
+			events.Artifact(ArtifactStepBinary, step.Name, step.OutputsBinaryPath)
 		}
-		overallLogs.WriteString(fmt.Sprintf("--- End Build Step: %s ---\n", step.Name))
+		events.Time(timingKey(PhaseSteps, step.Name), time.Since(stepStarted))
+		events.ServiceLogf(step.Name, "--- End Build Step: %s ---", step.Name)
 	} // End of build steps loop
 
 	// --- 7. Main Build Execution ---
-	overallLogs.WriteString("--- Starting Main Build ---\n")
+	events.StartPhase(PhaseBuild)
+	events.Log("--- Starting Main Build ---")
 
 	if spec.BuildConfig.ComposeFile != "" {
 		// --- 7a. Build using Docker Compose ---
-		overallLogs.WriteString(fmt.Sprintf("Building using Compose file: %s\n", spec.BuildConfig.ComposeFile))
+		events.Logf("Building using Compose file: %s", spec.BuildConfig.ComposeFile)
 		composeFilePath := filepath.Join(buildDir, spec.BuildConfig.ComposeFile)
 		composeData, err := os.ReadFile(composeFilePath)
 		if err != nil {
 			errMsg := fmt.Sprintf("error during the compose file reading '%s': %v", composeFilePath, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeComposeFile, errMsg, err)
 		}
 
-		// Use the provided LoadComposeFile function (assuming it's adapted for compose-go v2)
-		composeProject, err := LoadComposeFile(composeData)
+		composeProject, err := s.loadComposeProject(composeData, composeFilePath, mergedEnv, events)
 		if err != nil {
 			errMsg := fmt.Sprintf("error during the compose file parsing '%s': %v", spec.BuildConfig.ComposeFile, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeComposeFile, errMsg, err)
 		}
 
-		buildErrs := s.buildComposeProject(ctx, buildDir, composeProject, spec, result, &overallLogs)
+		buildErrs := s.buildComposeProject(ctx, buildID, buildDir, composeProject, spec, codebaseDirs, result, events)
 		if len(buildErrs) > 0 {
-			errMsg := fmt.Sprintf("errors during the compose project building: %v", buildErrs)
-			result.Success = false
-			result.ErrorMessage = strings.Join(buildErrs, "; ")
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeDockerBuild, strings.Join(buildErrs, "; "), nil)
 		}
 		// Note: ImageID in result might remain empty if compose file only defines services with existing images
-		overallLogs.WriteString("Compose project built successfully.\n")
+		events.Log("Compose project built successfully.")
 
 	} else {
 		// --- 7b. Build using Dockerfile ---
@@ -412,18 +483,15 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 				dockerfilePath = filepath.Join(buildDir, "Dockerfile.inline")
 				if err := os.WriteFile(dockerfilePath, []byte(spec.BuildConfig.Dockerfile), 0644); err != nil {
 					errMsg := fmt.Sprintf("error during the inline Dockerfile creation: %v", err)
-					result.Success = false
-					result.ErrorMessage = errMsg
-					result.Logs = overallLogs.String()
-					return result, fmt.Errorf("error during the run: \n %s", errMsg)
+					return failBuild(result, events, CodeDockerBuild, errMsg, err)
 				}
-				overallLogs.WriteString("Using inline Dockerfile.\n")
+				events.Log("Using inline Dockerfile.")
 			} else {
 				// Path to Dockerfile relative to buildDir
 				dockerfilePath = filepath.Join(buildDir, spec.BuildConfig.Dockerfile)
 				// The build context might need adjustment if the Dockerfile is not at the root
 				buildContextDir = filepath.Dir(dockerfilePath)
-				overallLogs.WriteString(fmt.Sprintf("Using Dockerfile at path: %s\n", spec.BuildConfig.Dockerfile))
+				events.Logf("Using Dockerfile at path: %s", spec.BuildConfig.Dockerfile)
 			}
 		} else {
 			// Auto-detect Dockerfile (simple case: look for Dockerfile at the root)
@@ -431,7 +499,7 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 			if _, err := os.Stat(dfPath); err == nil {
 				dockerfilePath = dfPath
 				buildContextDir = buildDir
-				overallLogs.WriteString("Auto-detected Dockerfile at build root.\n")
+				events.Log("Auto-detected Dockerfile at build root.")
 			} else {
 				// Try finding in the first codebase dir (legacy behavior, might need refinement)
 				if len(spec.Codebases) > 0 {
@@ -440,7 +508,21 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 					if _, err := os.Stat(dfPath); err == nil {
 						dockerfilePath = dfPath
 						buildContextDir = firstCodebaseDir // Context is the codebase dir
-						overallLogs.WriteString(fmt.Sprintf("Auto-detected Dockerfile in first codebase: %s\n", spec.Codebases[0].Name))
+						events.Logf("Auto-detected Dockerfile in first codebase: %s", spec.Codebases[0].Name)
+					} else {
+						// No Dockerfile at all, the one of the template of the detected ecosystem is used
+						generated, genErr := generateDockerfile(firstCodebaseDir, spec.BuildConfig.Ecosystem, spec.BuildConfig.DockerfileTemplate)
+						if genErr != nil {
+							errMsg := fmt.Sprintf("not found/provided Dockerfile for the build and none could be generated: %v", genErr)
+							return failBuild(result, events, CodeDockerBuild, errMsg, genErr)
+						}
+						dockerfilePath = generated.Path
+						buildContextDir = firstCodebaseDir
+						result.ExposedPort = generated.Data.Port
+						for _, warning := range generated.Report.Warnings {
+							events.Warnf("ecosystem detection: %s", warning)
+						}
+						events.Logf("Generated a Dockerfile for the %s (%s) codebase '%s':\n%s", generated.Ecosystem.Language, generated.Ecosystem.PackageManager, spec.Codebases[0].Name, generated.Content)
 					}
 				}
 			}
@@ -448,21 +530,19 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 
 		if dockerfilePath == "" {
 			errMsg := "not found/provided Dockerfile for the build"
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeDockerBuild, errMsg, nil)
 		}
 
-		// Perform the build for the single Dockerfile
-		imageID, logs, err := s.buildSingleImage(ctx, buildContextDir, dockerfilePath, spec)
-		overallLogs.WriteString(fmt.Sprintf("Dockerfile Build Logs:\n%s\n", logs))
+		// Perform the build for the single Dockerfile, labelled with the revision of its codebase
+		mainSpec := *spec
+		mainSpec.BuildConfig.Labels = imageLabels(buildID, result.Codebases, codebaseDirs, buildContextDir, spec.BuildConfig.Labels)
+		buildStarted := time.Now()
+		imageID, logs, err := s.buildSingleImage(ctx, buildContextDir, dockerfilePath, &mainSpec)
+		events.Time(timingKey(PhaseBuild, spec.Name), time.Since(buildStarted))
+		events.Logf("Dockerfile Build Logs:\n%s", logs)
 		if err != nil {
 			errMsg := fmt.Sprintf("erreur lors du build Docker: %v", err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeDockerBuild, errMsg, err)
 		}
 
 		// Store result for the single image build
@@ -471,7 +551,7 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		if err == nil {
 			result.ImageSize = imageSize
 		} else {
-			overallLogs.WriteString(fmt.Sprintf("Warning: could not get size for image %s: %v\n", imageID, err))
+			events.Warnf("could not get size for image %s: %v", imageID, err)
 		}
 		// Add to ServiceOutputs as a pseudo-service if needed for consistency
 		mainServiceName := spec.Name // Use build name as service name
@@ -483,21 +563,18 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		result.ImageIDs[mainServiceName] = imageID
 		result.ImageSizes[mainServiceName] = imageSize
 
-		overallLogs.WriteString(fmt.Sprintf("Dockerfile build successful. ImageID: %s, Size: %d\n", imageID, imageSize))
+		events.Logf("Dockerfile build successful. ImageID: %s, Size: %d", imageID, imageSize)
+		events.Artifact(ArtifactImage, mainServiceName, imageID)
 	}
 
 	// --- 8. Handle Build Outputs (Save/Upload Images) ---
-	outputBasePath := buildDir // Default base for local output
-	if spec.BuildConfig.OutputTarget == "local" && spec.BuildConfig.LocalPath != "" {
-		outputBasePath = spec.BuildConfig.LocalPath
-		if err := os.MkdirAll(outputBasePath, 0755); err != nil {
+	events.StartPhase(PhaseTag)
+	if outputBasePath != buildDir {
+		if err := s.OutputOptions().MkdirAll(outputBasePath); err != nil {
 			errMsg := fmt.Sprintf("cannot create the output base directory '%s': %v", outputBasePath, err)
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeOutputUpload, errMsg, err)
 		}
-		overallLogs.WriteString(fmt.Sprintf("Using custom local output path: %s\n", outputBasePath))
+		events.Logf("Using output directory: %s", outputBasePath)
 	}
 
 	finalImageTags := make(map[string][]string) // serviceName -> tags
@@ -512,10 +589,10 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 			// We could potentially read custom tags from the compose file's build section
 			// Apply tags to the image
 			for _, tag := range finalImageTags[serviceName] {
-				if err := s.dockerClient.ImageTag(ctx, serviceOutput.ImageID, tag); err != nil {
-					overallLogs.WriteString(fmt.Sprintf("Warning: Failed to tag image %s for service %s with tag %s: %v\n", serviceOutput.ImageID, serviceName, tag, err))
+				if err := s.backendFor(ctx).ImageTag(ctx, serviceOutput.ImageID, tag); err != nil {
+					events.Warnf("Failed to tag image %s for service %s with tag %s: %v", serviceOutput.ImageID, serviceName, tag, err)
 				} else {
-					overallLogs.WriteString(fmt.Sprintf("Tagged image %s for service %s with %s\n", serviceOutput.ImageID, serviceName, tag))
+					events.ServiceLogf(serviceName, "Tagged image %s for service %s with %s", serviceOutput.ImageID, serviceName, tag)
 				}
 			}
 		}
@@ -530,70 +607,88 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		}
 		// Apply tags
 		for _, tag := range finalImageTags[mainServiceName] {
-			if err := s.dockerClient.ImageTag(ctx, result.ImageID, tag); err != nil {
-				overallLogs.WriteString(fmt.Sprintf("Warning: Failed to tag image %s with tag %s: %v\n", result.ImageID, tag, err))
+			if err := s.backendFor(ctx).ImageTag(ctx, result.ImageID, tag); err != nil {
+				events.Warnf("Failed to tag image %s with tag %s: %v", result.ImageID, tag, err)
 			} else {
-				overallLogs.WriteString(fmt.Sprintf("Tagged image %s with %s\n", result.ImageID, tag))
+				events.Logf("Tagged image %s with %s", result.ImageID, tag)
+			}
+		}
+	}
+
+	if spec.BuildConfig.Push {
+		for serviceName, tags := range finalImageTags {
+			for _, tag := range tags {
+				events.ServiceLogf(serviceName, "Pushing %s for service %s...", tag, serviceName)
+				if err := s.pushImage(ctx, tag, events); err != nil {
+					errMsg := fmt.Sprintf("error during the image push '%s': %v", tag, err)
+					return failBuild(result, events, CodeImagePush, errMsg, err)
+				}
+				events.Artifact(ArtifactPushedImage, serviceName, tag)
 			}
 		}
 	}
 
 	// Save or upload based on OutputTarget
-	overallLogs.WriteString(fmt.Sprintf("Handling build output target: %s\n", spec.BuildConfig.OutputTarget))
+	events.StartPhase(PhaseOutput)
+	events.Logf("Handling build output target: %s", spec.BuildConfig.OutputTarget)
+	b2Objects := make(map[string][]string) // serviceName -> B2 object names, for the manifest
 	switch spec.BuildConfig.OutputTarget {
 	case "b2":
 		if s.b2Config == nil {
 			errMsg := "OutputTarget is 'b2' but no config is defined"
-			result.Success = false
-			result.ErrorMessage = errMsg
-			result.Logs = overallLogs.String()
-			return result, fmt.Errorf("error during the run: \n %s", errMsg)
+			return failBuild(result, events, CodeOutputUpload, errMsg, nil)
 		}
 		for serviceName, serviceOutput := range result.ServiceOutputs {
 			tags := finalImageTags[serviceName] // Get the tags we just applied
-			overallLogs.WriteString(fmt.Sprintf("Exporting and uploading image for service '%s' (ID: %s) to B2...\n", serviceName, serviceOutput.ImageID))
+			events.ServiceLogf(serviceName, "Exporting and uploading image for service '%s' (ID: %s) to B2...", serviceName, serviceOutput.ImageID)
 			// Adapt exportAndUploadImage to handle multiple tags per image
-			objectNames, err := s.exportAndUploadImage(ctx, serviceOutput.ImageID, serviceName, spec.Version, tags)
+			uploadStarted := time.Now()
+			objectNames, digest, err := s.exportAndUploadImage(ctx, serviceOutput.ImageID, serviceName, spec.Version, tags, spec.BuildConfig)
+			events.Time(timingKey(PhaseOutput, serviceName), time.Since(uploadStarted))
 			if err != nil {
-				overallLogs.WriteString(fmt.Sprintf("Warning: Failed to export/upload image for service '%s' to B2: %v\n", serviceName, err))
+				events.Warnf("Failed to export/upload image for service '%s' to B2: %v", serviceName, err)
 				// Continue with other images? Or fail? Let's continue but log.
 			} else {
 				result.B2ObjectNames = append(result.B2ObjectNames, objectNames...)
-				overallLogs.WriteString(fmt.Sprintf("Service '%s' image uploaded to B2: %v\n", serviceName, objectNames))
+				b2Objects[serviceName] = objectNames
+				result.ArchiveDigests[serviceName] = digest
+				events.ServiceLogf(serviceName, "Service '%s' image uploaded to B2: %v (%s)", serviceName, objectNames, digest)
+				for _, objectName := range objectNames {
+					events.Artifact(ArtifactB2Object, serviceName, objectName)
+				}
 			}
 		}
 
 	case "local":
 		for serviceName, serviceOutput := range result.ServiceOutputs {
-			imageFileName := fmt.Sprintf("%s_%s.tar", spec.Name, serviceName) // Consistent naming
+			imageFileName := fmt.Sprintf("%s_%s%s", spec.Name, serviceName, ImageArchiveExt(spec.BuildConfig.Compression)) // Consistent naming
 			localImagePath := filepath.Join(outputBasePath, imageFileName)
-			overallLogs.WriteString(fmt.Sprintf("Saving image for service '%s' (ID: %s) locally to %s...\n", serviceName, serviceOutput.ImageID, localImagePath))
+			events.ServiceLogf(serviceName, "Saving image for service '%s' (ID: %s) locally to %s...", serviceName, serviceOutput.ImageID, localImagePath)
 
-			err := s.saveImageLocally(ctx, serviceOutput.ImageID, localImagePath)
+			saveStarted := time.Now()
+			digest, err := s.saveImageLocally(ctx, serviceOutput.ImageID, localImagePath, spec.BuildConfig)
+			events.Time(timingKey(PhaseOutput, serviceName), time.Since(saveStarted))
 			if err != nil {
 				errMsg := fmt.Sprintf("error during the service image saving locally '%s': %v", serviceName, err)
-				result.Success = false
-				result.ErrorMessage = errMsg
-				result.Logs = overallLogs.String()
-				return result, fmt.Errorf("error during the run: \n %s", errMsg)
+				return failBuild(result, events, CodeOutputUpload, errMsg, err)
 			}
 			result.LocalImagePaths[serviceName] = localImagePath
-			overallLogs.WriteString(fmt.Sprintf("Service '%s' image saved successfully.\n", serviceName))
+			result.ArchiveDigests[serviceName] = digest
+			events.ServiceLogf(serviceName, "Service '%s' image saved successfully (%s).", serviceName, digest)
+			events.Artifact(ArtifactImageTar, serviceName, localImagePath)
 		}
 	case "docker":
 		// Images are already in the local Docker daemon, tagged. Nothing more to do here.
-		overallLogs.WriteString("Output target is 'docker', images are available in local daemon.\n")
+		events.Log("Output target is 'docker', images are available in local daemon.")
 	default:
 		errMsg := fmt.Sprintf("OutputTarget not supported: %s", spec.BuildConfig.OutputTarget)
-		result.Success = false
-		result.ErrorMessage = errMsg
-		result.Logs = overallLogs.String()
-		return result, fmt.Errorf("error during the run: \n %s", errMsg)
+		return failBuild(result, events, CodeInvalidSpec, errMsg, nil)
 	}
 
 	// --- 9. Generate *.run.yml ---
 	if spec.RunConfigDef.Generate {
-		overallLogs.WriteString("Generating *.run.yml file...\n")
+		events.StartPhase(PhaseRunConfig)
+		events.Log("Generating *.run.yml file...")
 		runConfigPath := filepath.Join(outputBasePath, fmt.Sprintf("%s-%s.run.yml", spec.Name, spec.Version))
 
 		// Loading the project if it's compose
@@ -602,12 +697,20 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 			composeFilePath := filepath.Join(buildDir, spec.BuildConfig.ComposeFile) // Chemin dans le contexte de build temporaire
 			composeData, err := os.ReadFile(composeFilePath)
 			if err != nil {
-				overallLogs.WriteString(fmt.Sprintf("Warning: Failed to read compose file '%s' for run.yml generation: %v\n", composeFilePath, err))
+				events.Warnf("Failed to read compose file '%s' for run.yml generation: %v", composeFilePath, err)
 			} else {
-				parsedComposeProject, err = LoadComposeFile(composeData)
+				parsedComposeProject, err = s.loadComposeProject(composeData, composeFilePath, mergedEnv, nil)
 				if err != nil {
-					overallLogs.WriteString(fmt.Sprintf("Warning: Failed to parse compose file for run.yml generation: %v\n", err))
+					events.Warnf("Failed to parse compose file for run.yml generation: %v", err)
 					parsedComposeProject = nil
+				} else {
+					consumed, err := ResolveComposeEnvFiles(parsedComposeProject, filepath.Dir(composeFilePath))
+					for serviceName, files := range consumed {
+						events.Logf("Service '%s': loaded env_file %s", serviceName, strings.Join(files, ", "))
+					}
+					if err != nil {
+						events.Warnf("%v", err)
+					}
 				}
 			}
 		}
@@ -615,33 +718,68 @@ func (s *BuildService) Build(ctx context.Context, spec *BuildSpec) (*BuildResult
 		runYAML, err := s.generateRunYAML(ctx, spec, result, finalRuntimeEnv, finalImageTags, parsedComposeProject)
 		if err != nil {
 			errMsg := fmt.Sprintf("error during the run.yml generating: %v", err)
-			overallLogs.WriteString(fmt.Sprintf("Warning: %s\n", errMsg))
+			events.Warnf("%s", errMsg)
 		} else if runYAML != nil && len(runYAML.Services) > 0 {
+			attachSecretFiles(runYAML, secretFiles)
+			if spec.RunConfigDef.SecretsEnvFile {
+				s.writeSecretsEnvFile(spec, runYAML, runtimeSecrets, outputBasePath, result, events)
+			}
 			yamlData, err := yaml.Marshal(runYAML)
 			if err != nil {
-				overallLogs.WriteString(fmt.Sprintf("Warning: Failed to parse run file for run.yml generation: %v\n", err))
+				events.Warnf("Failed to parse run file for run.yml generation: %v", err)
+			}
+			if err := s.OutputOptions().WriteFile(runConfigPath, yamlData); err != nil {
+				events.Warnf("Failed to write the run file '%s': %v", runConfigPath, err)
+			} else {
+				result.RunConfigPath = runConfigPath
+				events.Artifact(ArtifactRunConfig, "", runConfigPath)
+			}
+			if spec.RunConfigDef.GenerateCompose {
+				s.writeGeneratedCompose(spec, runYAML, finalImageTags, parsedComposeProject, outputBasePath, result, events)
 			}
-			os.WriteFile(runConfigPath, yamlData, 0755)
 		} else {
-			overallLogs.WriteString("Skipping writing run.yml as no services were generated.\n")
+			events.Log("Skipping writing run.yml as no services were generated.")
 		}
 	}
 
-	// --- 10. Finalize ---
+	// --- 10. Write the artifact manifest ---
+	manifestPath := filepath.Join(outputBasePath, fmt.Sprintf("%s-%s.manifest.json", spec.Name, spec.Version))
+	manifest := newArtifactManifest(spec, result, finalImageTags, b2Objects)
+	if err := s.writeManifest(manifestPath, manifest); err != nil {
+		events.Warnf("%v", err)
+	} else {
+		result.ManifestPath = manifestPath
+		events.Artifact(ArtifactManifest, "", manifestPath)
+	}
+
+	// --- 11. Finalize ---
 	result.Success = true
 	result.BuildTime = time.Since(startTime).Seconds()
-	result.Logs = overallLogs.String() // Assign collected logs
-
-	// Clean up temporary build step images (optional)
-	// Could add logic here to remove images tagged like *-step-*
-
-	overallLogs.WriteString(fmt.Sprintf("Build finished successfully in %.2f seconds.\n", result.BuildTime))
+	events.Logf("Build finished successfully in %.2f seconds.", result.BuildTime)
+	result.Logs = events.Render() // Assign collected logs
 
 	return result, nil
 }
 
 // --- Helper Functions ---
 
+// loadComposeProject parses a compose file with its ${VAR} references substituted from the build
+// environment and the .env file of the compose directory. The unset variables are reported to events if not nil.
+func (s *BuildService) loadComposeProject(data []byte, composeFilePath string, env map[string]string, events *eventStream) (*ComposeProject, error) {
+	interpolationEnv, err := ComposeInterpolationEnv(env, filepath.Dir(composeFilePath))
+	if err != nil {
+		return nil, err
+	}
+	project, missing, err := LoadComposeFileWithEnv(data, interpolationEnv)
+	if err != nil {
+		return nil, err
+	}
+	if events != nil && len(missing) > 0 {
+		events.Warnf("The compose variables %s are not set, defaulting to a blank string", strings.Join(missing, ", "))
+	}
+	return project, nil
+}
+
 // fetching codebase from the provided source type and config
 func (s *BuildService) fetchCodebase(ctx context.Context, config CodebaseConfig, destDir string) error {
 	// Ensure the parent directory exists, but destDir itself should not exist for git clone
@@ -709,7 +847,7 @@ func (s *BuildService) fetchGitRepoWithGoGit(ctx context.Context, config Codebas
 		return fmt.Errorf("cannot create the parent dir '%s': %w", parentDir, err)
 	}
 	if _, err := os.Stat(destDir); err == nil {
-		fmt.Printf("Removing existing directory before clone: %s\n", destDir)
+		s.loggerFor(ctx).Info("Removing existing directory before clone", "dir", destDir)
 		if err := os.RemoveAll(destDir); err != nil {
 			return fmt.Errorf("failed to remove the dest dir before cloning the repository '%s': %w", destDir, err)
 		}
@@ -717,7 +855,7 @@ func (s *BuildService) fetchGitRepoWithGoGit(ctx context.Context, config Codebas
 		return fmt.Errorf("error during the dest repertory verification '%s': %w", destDir, err)
 	}
 
-	fmt.Printf("Cloning repository %s to %s...\n", config.Source, destDir)
+	s.loggerFor(ctx).Info("Cloning repository", "repository", config.Source, "dir", destDir)
 	repo, err := git.PlainCloneContext(ctx, destDir, false, options)
 	if err != nil {
 		// Handle specific errors
@@ -729,11 +867,11 @@ func (s *BuildService) fetchGitRepoWithGoGit(ctx context.Context, config Codebas
 		}
 		return fmt.Errorf("error during the repository cloning '%s' (branch: %s): %w", config.Source, config.Branch, err)
 	}
-	fmt.Printf("Repository cloned successfully.\n")
+	s.loggerFor(ctx).Info("Repository cloned successfully", "repository", config.Source)
 
 	// If a specific commit is requested, check it out
 	if config.Commit != "" {
-		fmt.Printf("Attempting to checkout commit %s...\n", config.Commit)
+		s.loggerFor(ctx).Info("Attempting to checkout commit", "commit", config.Commit)
 		w, err := repo.Worktree()
 		if err != nil {
 			return fmt.Errorf("cannot get the repository work tree '%s' after cloning: %w", config.Source, err)
@@ -747,7 +885,7 @@ func (s *BuildService) fetchGitRepoWithGoGit(ctx context.Context, config Codebas
 
 		err = w.Checkout(checkoutOptions)
 		if err != nil {
-			fmt.Printf("Initial checkout failed for commit %s (%v), attempting fetch...\n", config.Commit, err)
+			s.loggerFor(ctx).Info("Initial checkout failed, attempting fetch", "commit", config.Commit, "error", err)
 			// Try fetching explicitly, making sure to fetch all heads and tags
 			// which should bring in the necessary commit object if it exists remotely.
 			fetchOpts := &git.FetchOptions{
@@ -766,24 +904,24 @@ func (s *BuildService) fetchGitRepoWithGoGit(ctx context.Context, config Codebas
 			// git.NoErrAlreadyUpToDate is expected if the commit was already there but checkout failed for other reasons
 			if errFetch != nil && errFetch != git.NoErrAlreadyUpToDate {
 				// Log the fetch error, but the primary error is still the checkout failure if retry also fails.
-				fmt.Printf("Fetch failed: %v\n", errFetch)
+				s.loggerFor(ctx).Warn("Fetch failed", "error", errFetch)
 				// Return combined error information
 				return fmt.Errorf("error during the checkout of the commit '%s' (%w) and fetch also failed (%v)", config.Commit, err, errFetch)
 			} else if errFetch == git.NoErrAlreadyUpToDate {
-				fmt.Println("Fetch reported remote is already up-to-date.")
+				s.loggerFor(ctx).Info("Fetch reported remote is already up-to-date")
 			} else {
-				fmt.Println("Fetch completed successfully.")
+				s.loggerFor(ctx).Info("Fetch completed successfully")
 			}
 
 			// Retry checkout after fetch
-			fmt.Printf("Retrying checkout of commit %s after fetch...\n", config.Commit)
+			s.loggerFor(ctx).Info("Retrying checkout after fetch", "commit", config.Commit)
 			err = w.Checkout(checkoutOptions)
 			if err != nil {
 				// If it still fails after fetch, the commit might be invalid or unreachable
 				return fmt.Errorf("error during the checkout of the commit '%s' (after fetch): %w", config.Commit, err)
 			}
 		}
-		fmt.Printf("Successfully checked out commit %s\n", config.Commit)
+		s.loggerFor(ctx).Info("Successfully checked out commit", "commit", config.Commit)
 	}
 
 	return nil
@@ -959,10 +1097,10 @@ func extractTar(tr *tar.Reader, destDir string) error {
 			}
 		case tar.TypeLink:
 			// Handle hard links (less common, might require mapping) - Skip for now
-			fmt.Printf("Warning: Hard link extraction not fully supported (from %s to %s)\n", header.Name, header.Linkname)
+			slog.Warn("Hard link extraction not fully supported", "name", header.Name, "link", header.Linkname)
 		default:
 			// Skip other types (char device, block device, fifo)
-			fmt.Printf("Warning: Skipping unsupported tar entry type %c for %s\n", header.Typeflag, header.Name)
+			slog.Warn("Skipping unsupported tar entry type", "type", string(header.Typeflag), "name", header.Name)
 		}
 	}
 	return nil
@@ -1055,6 +1193,10 @@ func (s *BuildService) downloadFile(ctx context.Context, url, targetPath string)
 
 // Build a single image from a context and a specific Config
 func (s *BuildService) buildSingleImage(ctx context.Context, buildContextDir string, dockerfilePath string, spec *BuildSpec) (string, string, error) {
+	if needsBuildKitSession(ctx, spec) {
+		return s.buildWithCLI(ctx, buildContextDir, dockerfilePath, spec)
+	}
+
 	var logBuffer bytes.Buffer
 
 	// Créer le contexte de build en mémoire (tar)
@@ -1074,6 +1216,10 @@ func (s *BuildService) buildSingleImage(ctx context.Context, buildContextDir str
 		NoCache:     spec.BuildConfig.NoCache,
 		BuildArgs:   make(map[string]*string),
 		PullParent:  spec.BuildConfig.Pull, // Tenter de pull l'image de base
+		Labels:      spec.BuildConfig.Labels,
+		CacheFrom:   spec.BuildConfig.CacheFrom,
+		// Credentials of the private base images and cache sources
+		AuthConfigs: registryAuthsFrom(ctx).All(),
 		Version:     types.BuilderBuildKit, // Préférer BuildKit si disponible
 		// TODO: Add Platform handling spec.BuildConfig.Platforms
 	}
@@ -1100,13 +1246,13 @@ func (s *BuildService) buildSingleImage(ctx context.Context, buildContextDir str
 
 	// Exécuter le build
 	fmt.Fprintf(&logBuffer, "Starting Docker build with context: %s, Dockerfile: %s\n", buildContextDir, dockerfilePath)
-	buildResponse, err := s.dockerClient.ImageBuild(ctx, buildContextTar, buildOptions)
+	buildResponse, err := s.backendFor(ctx).ImageBuild(ctx, buildContextTar, buildOptions)
 	if err != nil {
 		// Try falling back to legacy builder if BuildKit failed?
 		if spec.BuildConfig.BuildKit && strings.Contains(err.Error(), "BuildKit") {
 			fmt.Fprintf(&logBuffer, "BuildKit build failed, trying legacy builder...\n")
 			buildOptions.Version = types.BuilderV1
-			buildResponse, err = s.dockerClient.ImageBuild(ctx, buildContextTar, buildOptions)
+			buildResponse, err = s.backendFor(ctx).ImageBuild(ctx, buildContextTar, buildOptions)
 		}
 		if err != nil {
 			logBuffer.WriteString(fmt.Sprintf("\nDocker build command failed: %v\n", err))
@@ -1199,30 +1345,40 @@ func (s *BuildService) buildSingleImage(ctx context.Context, buildContextDir str
 	imageID = strings.TrimPrefix(imageID, "sha256:")
 
 	fmt.Fprintf(&logBuffer, "\nBuild successful. Final Image ID: %s\n", imageID)
+	journalFrom(ctx).Track(ResourceImage, imageID)
 	return imageID, logBuffer.String(), nil
 }
 
 // buildComposeProject itère sur les services d'un projet Compose et les construit
-func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string, project *ComposeProject, spec *BuildSpec, result *BuildResult, overallLogs *strings.Builder) []string {
+// The images are labelled with the revision of the codebase holding their context, codebaseDirs maps the codebases to their directory.
+func (s *BuildService) buildComposeProject(ctx context.Context, buildID, buildDir string, project *ComposeProject, spec *BuildSpec, codebaseDirs map[string]string, result *BuildResult, events *eventStream) []string {
 	var buildErrors []string
 	composeFileDir := filepath.Dir(filepath.Join(buildDir, spec.BuildConfig.ComposeFile)) // Directory containing the compose file
+	total, current := 0, 0 // Services with a build section, reported by the progress events
+	for _, service := range project.Services {
+		if service.Build != nil {
+			total++
+		}
+	}
 
 	for Name, service := range project.Services {
 		if service.Build == nil {
 			// Service uses an existing image, maybe pull it?
 			if service.Image != "" {
-				overallLogs.WriteString(fmt.Sprintf("Service '%s' uses image '%s'. Pulling...\n", Name, service.Image))
-				if err := s.pullImage(ctx, service.Image, overallLogs); err != nil {
-					overallLogs.WriteString(fmt.Sprintf("Warning: Failed to pull image '%s' for service '%s': %v\n", service.Image, Name, err))
+				events.ServiceLogf(Name, "Service '%s' uses image '%s'. Pulling...", Name, service.Image)
+				if err := s.pullImage(ctx, service.Image, events); err != nil {
+					events.Warnf("Failed to pull image '%s' for service '%s': %v", service.Image, Name, err)
 					// Continue or fail? Let's continue.
 				}
 			} else {
-				overallLogs.WriteString(fmt.Sprintf("Service '%s' has no 'build' section and no 'image' specified. Skipping build.\n", Name))
+				events.Logf("Service '%s' has no 'build' section and no 'image' specified. Skipping build.", Name)
 			}
 			continue
 		}
 
-		overallLogs.WriteString(fmt.Sprintf("--- Building Service: %s ---\n", Name))
+		current++
+		events.Progressf(Name, current, total, "Building service '%s'", Name)
+		events.Logf("--- Building Service: %s ---", Name)
 
 		// Determine build context and Dockerfile path relative to the compose file directory
 		contextPath := service.Build.Context
@@ -1241,7 +1397,7 @@ func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string,
 		// Dockerfile path is relative to the context path
 		fullDockerfilePath := filepath.Join(contextPath, dockerfilePath)
 
-		overallLogs.WriteString(fmt.Sprintf("Service '%s': Context='%s', Dockerfile='%s'\n", Name, contextPath, fullDockerfilePath))
+		events.ServiceLogf(Name, "Service '%s': Context='%s', Dockerfile='%s'", Name, contextPath, fullDockerfilePath)
 
 		// Create a temporary BuildSpec for this service build
 		serviceSpec := &BuildSpec{
@@ -1254,7 +1410,10 @@ func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string,
 				Pull:    spec.BuildConfig.Pull,                    // Inherit Pull setting
 				Tags:    []string{fmt.Sprintf("%s:latest", Name)}, // Default tag for the service image
 				// Use buildkit setting from main spec?
-				BuildKit: spec.BuildConfig.BuildKit,
+				BuildKit:   spec.BuildConfig.BuildKit,
+				SSHForward: spec.BuildConfig.SSHForward,
+				CacheFrom:  service.Build.CacheFrom,
+				Labels:     imageLabels(buildID, result.Codebases, codebaseDirs, contextPath, service.Build.Labels),
 			},
 		}
 
@@ -1279,13 +1438,15 @@ func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string,
 		}
 
 		// Build the image for the service
+		buildStarted := time.Now()
 		imageID, logs, err := s.buildSingleImage(ctx, contextPath, fullDockerfilePath, serviceSpec)
-		overallLogs.WriteString(fmt.Sprintf("Logs for service %s:\n%s\n", Name, logs))
+		events.Time(timingKey(PhaseBuild, Name), time.Since(buildStarted))
+		events.ServiceLogf(Name, "Logs for service %s:\n%s", Name, logs)
 
 		if err != nil {
 			errMsg := fmt.Sprintf("erreur lors du build du service '%s': %v", Name, err)
 			buildErrors = append(buildErrors, errMsg)
-			overallLogs.WriteString(errMsg + "\n")
+			events.Log(errMsg)
 			// Store partial results?
 			result.ServiceOutputs[Name] = ServiceOutput{Logs: logs}
 			continue // Continue to build other services even if one fails
@@ -1293,7 +1454,7 @@ func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string,
 
 		imageSize, sizeErr := s.getImageSize(ctx, imageID)
 		if sizeErr != nil {
-			overallLogs.WriteString(fmt.Sprintf("Warning: could not get size for image %s (service %s): %v\n", imageID, Name, sizeErr))
+			events.Warnf("could not get size for image %s (service %s): %v", imageID, Name, sizeErr)
 		}
 
 		// Store results for this service
@@ -1304,8 +1465,9 @@ func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string,
 			ImageSize: imageSize,
 			Logs:      logs,
 		}
-		overallLogs.WriteString(fmt.Sprintf("Service '%s' built successfully. ImageID: %s, Size: %d\n", Name, imageID, imageSize))
-		overallLogs.WriteString(fmt.Sprintf("--- Finished Service: %s ---\n", Name))
+		events.ServiceLogf(Name, "Service '%s' built successfully. ImageID: %s, Size: %d", Name, imageID, imageSize)
+		events.Artifact(ArtifactImage, Name, imageID)
+		events.Logf("--- Finished Service: %s ---", Name)
 
 	} // End loop over services
 
@@ -1315,7 +1477,7 @@ func (s *BuildService) buildComposeProject(ctx context.Context, buildDir string,
 // pullImage pulls a Docker image if it doesn't exist locally
 func (s *BuildService) pullImage(ctx context.Context, imageName string, logs io.Writer) error {
 	// Check if image exists locally first to avoid unnecessary pulls
-	_, _, err := s.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	_, _, err := s.backendFor(ctx).ImageInspectWithRaw(ctx, imageName)
 	if err == nil {
 		fmt.Fprintf(logs, "Image '%s' already exists locally.\n", imageName)
 		return nil // Image found
@@ -1327,7 +1489,11 @@ func (s *BuildService) pullImage(ctx context.Context, imageName string, logs io.
 
 	// Image not found, proceed to pull
 	fmt.Fprintf(logs, "Pulling image '%s'...\n", imageName)
-	reader, err := s.dockerClient.ImagePull(ctx, imageName, image.PullOptions{})
+	registryAuth, err := registryAuthsFrom(ctx).EncodedForImage(imageName)
+	if err != nil {
+		return fmt.Errorf("cannot encode the registry credentials of the image '%s': %w", imageName, err)
+	}
+	reader, err := s.backendFor(ctx).ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: registryAuth})
 	if err != nil {
 		return fmt.Errorf("erreur lors du lancement du pull de l'image '%s': %w", imageName, err)
 	}
@@ -1344,10 +1510,31 @@ func (s *BuildService) pullImage(ctx context.Context, imageName string, logs io.
 	return nil
 }
 
+// pushImage pushes a tag to its registry with the credentials of the build
+func (s *BuildService) pushImage(ctx context.Context, tag string, logs io.Writer) error {
+	registryAuth, err := registryAuthsFrom(ctx).EncodedForImage(tag)
+	if err != nil {
+		return fmt.Errorf("cannot encode the registry credentials of the image '%s': %w", tag, err)
+	}
+	reader, err := s.backendFor(ctx).ImagePush(ctx, tag, image.PushOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return fmt.Errorf("cannot push the image '%s': %w", tag, err)
+	}
+	defer reader.Close()
+
+	// The push errors are reported in the stream, DisplayJSONMessagesStream returns them
+	termFd, isTerm := term.GetFdInfo(logs)
+	if err := jsonmessage.DisplayJSONMessagesStream(reader, logs, termFd, isTerm, nil); err != nil {
+		return fmt.Errorf("cannot push the image '%s': %w", tag, err)
+	}
+	fmt.Fprintf(logs, "Image '%s' pushed successfully.\n", tag)
+	return nil
+}
+
 // getImageSize récupère la taille d'une image Docker
 func (s *BuildService) getImageSize(ctx context.Context, imageID string) (int64, error) {
 	// Use the image ID (which should be sha256 or short ID) for inspection
-	summary, _, err := s.dockerClient.ImageInspectWithRaw(ctx, imageID)
+	summary, _, err := s.backendFor(ctx).ImageInspectWithRaw(ctx, imageID)
 	if err != nil {
 		return 0, fmt.Errorf("erreur d'inspection de l'image '%s': %w", imageID, err)
 	}
@@ -1356,7 +1543,7 @@ func (s *BuildService) getImageSize(ctx context.Context, imageID string) (int64,
 
 // getImageInfoByTag récupère les infos d'une image par son tag
 func (s *BuildService) getImageInfoByTag(ctx context.Context, imageTag string) (*types.ImageInspect, error) {
-	summary, _, err := s.dockerClient.ImageInspectWithRaw(ctx, imageTag)
+	summary, _, err := s.backendFor(ctx).ImageInspectWithRaw(ctx, imageTag)
 	if err != nil {
 		return nil, fmt.Errorf("erreur d'inspection de l'image taggée '%s': %w", imageTag, err)
 	}
@@ -1364,38 +1551,41 @@ func (s *BuildService) getImageInfoByTag(ctx context.Context, imageTag string) (
 }
 
 // saveImageLocally sauvegarde une image Docker dans un fichier .tar local
-func (s *BuildService) saveImageLocally(ctx context.Context, imageID string, targetPath string) error {
-	reader, err := s.dockerClient.ImageSave(ctx, []string{imageID})
+// The archive is compressed as set in the build config, the digest of the written file is returned.
+func (s *BuildService) saveImageLocally(ctx context.Context, imageID string, targetPath string, config BuildConfig) (string, error) {
+	reader, err := s.backendFor(ctx).ImageSave(ctx, []string{imageID})
 	if err != nil {
-		return fmt.Errorf("erreur lors de l'export de l'image '%s': %w", imageID, err)
+		return "", fmt.Errorf("erreur lors de l'export de l'image '%s': %w", imageID, err)
 	}
 	defer reader.Close()
 
-	file, err := os.Create(targetPath)
+	file, err := s.OutputOptions().Create(targetPath)
 	if err != nil {
-		return fmt.Errorf("impossible de créer le fichier image local '%s': %w", targetPath, err)
+		return "", fmt.Errorf("impossible de créer le fichier image local '%s': %w", targetPath, err)
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, reader)
+	digest, _, err := writeImageArchive(file, reader, config.Compression, config.CompressionLevel)
 	if err != nil {
-		return fmt.Errorf("erreur lors de l'écriture dans le fichier image local '%s': %w", targetPath, err)
+		return "", fmt.Errorf("erreur lors de l'écriture dans le fichier image local '%s': %w", targetPath, err)
 	}
 
-	return nil
+	return digest, nil
 }
 
 // exportAndUploadImage exporte une image Docker et l'upload vers B2 (modifié pour nom/version/tags)
-func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, serviceName, version string, tags []string) ([]string, error) {
+// The archive is compressed as set in the build config, its digest is returned with the object names.
+func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, serviceName, version string, tags []string, config BuildConfig) ([]string, string, error) {
 	if s.b2Config == nil {
-		return nil, fmt.Errorf("configuration B2 non définie pour upload")
+		return nil, "", fmt.Errorf("configuration B2 non définie pour upload")
 	}
 
 	// Créer un reader pour l'image exportée
-	reader, err := s.dockerClient.ImageSave(ctx, []string{imageID}) // Use the actual image ID
+	reader, err := s.backendFor(ctx).ImageSave(ctx, []string{imageID}) // Use the actual image ID
 	if err != nil {
-		return nil, fmt.Errorf("erreur lors de l'export de l'image ID '%s': %w", imageID, err)
+		return nil, "", fmt.Errorf("erreur lors de l'export de l'image ID '%s': %w", imageID, err)
 	}
+	imageName := fmt.Sprintf("%s-%s%s", serviceName, version, ImageArchiveExt(config.Compression))
 	defer reader.Close()
 
 	// Utiliser io.Pipe pour streamer directement vers B2 sans charger en mémoire (plus efficace pour grosses images)
@@ -1423,13 +1613,13 @@ func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, servic
 		}
 
 		// Nom d'objet principal basé sur service et version
-		imageName := fmt.Sprintf("%s-%s.tar", serviceName, version)
 		objectPath := filepath.Join(s.b2Config.BasePath, imageName)
 
 		obj := bucket.Object(objectPath)
 		writer := obj.NewWriter(ctx)
+		journalFrom(ctx).Track(ResourceB2Upload, objectPath)
 
-		fmt.Printf("Starting B2 upload to %s...\n", objectPath) // Log start
+		s.loggerFor(ctx).Info("Starting B2 upload", LogKeyService, serviceName, "object", objectPath)
 		_, err = io.Copy(writer, pr)                            // Lire depuis le pipe et écrire vers B2
 		if err != nil {
 			writer.Close() // Important to close writer even on error
@@ -1442,15 +1632,17 @@ func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, servic
 			uploadErr = fmt.Errorf("erreur lors de la finalisation de l'upload B2 (%s): %w", objectPath, err)
 			return
 		}
-		fmt.Printf("Finished B2 upload to %s.\n", objectPath) // Log success
+		journalFrom(ctx).Release(ResourceB2Upload, objectPath)
+		s.loggerFor(ctx).Info("Finished B2 upload", LogKeyService, serviceName, "object", objectPath)
 		// Upload successful for the main object path
 	}()
 
 	// Goroutine pour copier depuis Docker save vers le pipe writer
 	var copyErr error
+	var digest string
 	go func() {
 		defer pw.Close() // Fermer le writer quand la copie est finie ou échoue
-		_, copyErr = io.Copy(pw, reader)
+		digest, _, copyErr = writeImageArchive(pw, reader, config.Compression, config.CompressionLevel)
 	}()
 
 	// Attendre la fin de l'upload
@@ -1458,27 +1650,27 @@ func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, servic
 
 	// Vérifier les erreurs
 	if copyErr != nil {
-		return nil, fmt.Errorf("erreur lors de la lecture des données de l'image Docker: %w", copyErr)
+		return nil, "", fmt.Errorf("erreur lors de la lecture des données de l'image Docker: %w", copyErr)
 	}
 	if uploadErr != nil {
-		return nil, fmt.Errorf("erreur lors de l'upload vers B2: %w", uploadErr)
+		return nil, "", fmt.Errorf("erreur lors de l'upload vers B2: %w", uploadErr)
 	}
 
 	// L'upload principal a réussi. Maintenant, gérer les tags comme des références (petits fichiers texte).
 	// Note: B2 ne supporte pas les liens symboliques directs. On crée des fichiers de ref.
-	objectNames := []string{filepath.Join(s.b2Config.BasePath, fmt.Sprintf("%s-%s.tar", serviceName, version))} // Start with the main path
+	objectNames := []string{filepath.Join(s.b2Config.BasePath, imageName)} // Start with the main path
 
 	// Re-init client/bucket for tag uploads (ou réutiliser si possible)
 	b2Client, err := b2.NewClient(ctx, s.b2Config.AccountID, s.b2Config.ApplicationKey, b2.UserAgent("build-service"))
 	if err != nil {
 		// Log error mais on a déjà réussi l'upload principal
-		fmt.Printf("Warning: Failed to re-init B2 client for tag refs: %v\n", err)
-		return objectNames, nil // Return only the main object name
+		s.loggerFor(ctx).Warn("Failed to re-init B2 client for tag refs", LogKeyService, serviceName, "error", err)
+		return objectNames, digest, nil // Return only the main object name
 	}
 	bucket, err := b2Client.Bucket(ctx, s.b2Config.BucketName)
 	if err != nil {
-		fmt.Printf("Warning: Failed to get B2 bucket for tag refs: %v\n", err)
-		return objectNames, nil
+		s.loggerFor(ctx).Warn("Failed to get B2 bucket for tag refs", LogKeyService, serviceName, "error", err)
+		return objectNames, digest, nil
 	}
 
 	for _, tag := range tags {
@@ -1487,8 +1679,8 @@ func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, servic
 		tagFileName := fmt.Sprintf("%s.ref.txt", cleanTag)
 		tagPath := filepath.Join(s.b2Config.BasePath, tagFileName)
 
-		refContent := fmt.Sprintf("ImageID: %s\nTag: %s\nVersion: %s\nServiceName: %s\nMainObject: %s\n",
-			imageID, tag, version, serviceName, objectNames[0])
+		refContent := fmt.Sprintf("ImageID: %s\nTag: %s\nVersion: %s\nServiceName: %s\nMainObject: %s\nDigest: %s\n",
+			imageID, tag, version, serviceName, objectNames[0], digest)
 
 		refObj := bucket.Object(tagPath)
 		refWriter := refObj.NewWriter(ctx)
@@ -1496,32 +1688,37 @@ func (s *BuildService) exportAndUploadImage(ctx context.Context, imageID, servic
 		_, err = refWriter.Write([]byte(refContent))
 		if err != nil {
 			refWriter.Close()
-			fmt.Printf("Warning: Failed to write B2 ref file for tag '%s' (%s): %v\n", tag, tagPath, err)
+			s.loggerFor(ctx).Warn("Failed to write B2 ref file", LogKeyService, serviceName, "tag", tag, "object", tagPath, "error", err)
 			continue // Continue with other tags
 		}
 		err = refWriter.Close()
 		if err != nil {
-			fmt.Printf("Warning: Failed to close B2 ref file for tag '%s' (%s): %v\n", tag, tagPath, err)
+			s.loggerFor(ctx).Warn("Failed to close B2 ref file", LogKeyService, serviceName, "tag", tag, "object", tagPath, "error", err)
 			continue
 		}
 		objectNames = append(objectNames, tagPath)
 	}
 
-	return objectNames, nil
+	return objectNames, digest, nil
 }
 
 // extractFromContainer copie un fichier/dossier depuis un conteneur temporaire
 func (s *BuildService) extractFromContainer(ctx context.Context, imageID, containerPath string) ([]byte, error) {
 	// Créer un conteneur temporaire basé sur l'image
-	resp, err := s.dockerClient.ContainerCreate(ctx, &container.Config{Image: imageID}, nil, nil, nil, "")
+	resp, err := s.backendFor(ctx).ContainerCreate(ctx, &container.Config{Image: imageID}, nil, nil, nil, "")
 	if err != nil {
 		return nil, fmt.Errorf("erreur lors de la création du conteneur temporaire pour l'extraction: %w", err)
 	}
 	containerID := resp.ID
-	defer s.dockerClient.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true}) // Cleanup
+	journalFrom(ctx).Track(ResourceContainer, containerID)
+	defer func() { // Cleanup
+		if err := s.backendFor(ctx).ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true}); err == nil {
+			journalFrom(ctx).Release(ResourceContainer, containerID)
+		}
+	}()
 
 	// Copier le fichier/dossier depuis le conteneur
-	readCloser, _, err := s.dockerClient.CopyFromContainer(ctx, containerID, containerPath)
+	readCloser, _, err := s.backendFor(ctx).CopyFromContainer(ctx, containerID, containerPath)
 	if err != nil {
 		return nil, fmt.Errorf("erreur lors de la copie depuis le conteneur '%s' (path: %s): %w", containerID, containerPath, err)
 	}
@@ -1574,7 +1771,7 @@ func (s *BuildService) generateRunYAML(ctx context.Context, spec *BuildSpec, res
 			// if isBuildOnly { continue }
 
 			runService := RunService{
-				Image:       s.getImageRefForRun(serviceName, spec.RunConfigDef.ArtifactStorage, result, finalImageTags),
+				Image:       s.getImageRefForRun(ctx, serviceName, spec.RunConfigDef.ArtifactStorage, result, finalImageTags),
 				Command:     service.Command,
 				Entrypoint:  service.Entrypoint,
 				Environment: make(map[string]string),
@@ -1582,6 +1779,17 @@ func (s *BuildService) generateRunYAML(ctx context.Context, spec *BuildSpec, res
 				Volumes:     service.Volumes, // Directement []string maintenant
 				Restart:     service.Restart,
 				DependsOn:   service.DependsOn, // Directement []string maintenant
+				HealthCheck: service.HealthCheck,
+				Networks:    service.Networks,
+				NetworkMode: service.NetworkMode,
+				Labels:      service.Labels,
+				User:        service.User,
+				WorkingDir:  service.WorkingDir,
+				Tmpfs:       service.Tmpfs,
+				Profiles:    service.Profiles,
+			}
+			if service.Deploy != nil {
+				runService.Resources = service.Deploy.Resources
 			}
 
 			// Combine env vars: Global runtime env puis Service-specific
@@ -1600,33 +1808,41 @@ func (s *BuildService) generateRunYAML(ctx context.Context, spec *BuildSpec, res
 					}
 				}
 			}
-			// Copier d'autres champs si définis dans RunService (ex: HealthCheck, Labels)
-			// runService.HealthCheck = service.HealthCheck // Si RunService a un HealthCheck
 
 			runYAML.Services[serviceName] = runService
 		}
+		if networks := runNetworks(composeProject); len(networks) > 0 {
+			runYAML.Networks = networks
+		}
+		if volumes := runVolumes(composeProject); len(volumes) > 0 {
+			runYAML.Volumes = volumes
+		}
 
 	} else {
 		// Single service based on the main build spec name (non-compose build)
 		mainServiceName := spec.Name
 		// Vérifier si cette image existe (au cas où le build a échoué mais on génère quand même)
 		if _, ok := result.ImageIDs[mainServiceName]; !ok && spec.RunConfigDef.ArtifactStorage != "local" {
-			fmt.Printf("Warning: Image for main service '%s' not found in results, skipping run.yml generation for it.\n", mainServiceName)
+			s.loggerFor(ctx).Warn("Image for main service not found in results, skipping run.yml generation for it", LogKeyService, mainServiceName)
 			// Retourner un run.yml vide ou une erreur? Retournons le runYAML potentiellement vide.
 		} else {
 			runService := RunService{
-				Image:       s.getImageRefForRun(mainServiceName, spec.RunConfigDef.ArtifactStorage, result, finalImageTags),
+				Image:       s.getImageRefForRun(ctx, mainServiceName, spec.RunConfigDef.ArtifactStorage, result, finalImageTags),
 				Environment: runtimeEnv,
 				Command:     spec.RunConfigDef.Commands, // Utiliser les commandes globales définies
 				// Ajouter d'autres champs par défaut si nécessaire
 			}
+			// Le port du Dockerfile généré est publié sur le même port de l'hôte
+			if result.ExposedPort > 0 {
+				runService.Ports = []string{fmt.Sprintf("%d:%d", result.ExposedPort, result.ExposedPort)}
+			}
 			runYAML.Services[mainServiceName] = runService
 		}
 	}
 
 	// Vérifier si aucun service n'a été ajouté (peut arriver si build compose échoue complètement)
 	if len(runYAML.Services) == 0 {
-		fmt.Println("Warning: No services could be added to run.yml.")
+		s.loggerFor(ctx).Warn("No services could be added to run.yml")
 		// Retourner une erreur ou un succès avec un fichier vide ? Succès vide pour l'instant.
 	}
 
@@ -1634,7 +1850,7 @@ func (s *BuildService) generateRunYAML(ctx context.Context, spec *BuildSpec, res
 }
 
 // getImageRefForRun détermine la référence d'image à utiliser dans run.yml
-func (s *BuildService) getImageRefForRun(serviceName, storageType string, result *BuildResult, finalImageTags map[string][]string) string {
+func (s *BuildService) getImageRefForRun(ctx context.Context, serviceName, storageType string, result *BuildResult, finalImageTags map[string][]string) string {
 	switch storageType {
 	case "local":
 		if path, ok := result.LocalImagePaths[serviceName]; ok && path != "" {
@@ -1642,7 +1858,20 @@ func (s *BuildService) getImageRefForRun(serviceName, storageType string, result
 			return filepath.Base(path)
 		}
 		// Fallback si chemin non trouvé
-		fmt.Printf("Warning: Local image path not found for service '%s' in build result.\n", serviceName)
+		s.loggerFor(ctx).Warn("Local image path not found in build result", LogKeyService, serviceName)
+		return fmt.Sprintf("local:%s_image_not_found.tar", serviceName)
+
+	case "b2":
+		// Référence b2://<bucket>/<archive>, résolue par FetchArtifact
+		if s.b2Config != nil {
+			prefix := filepath.Join(s.b2Config.BasePath, serviceName) + "-"
+			for _, objectName := range result.B2ObjectNames {
+				if strings.HasPrefix(objectName, prefix) && IsImageArchive(objectName) {
+					return B2Scheme + s.b2Config.BucketName + "/" + objectName
+				}
+			}
+		}
+		s.loggerFor(ctx).Warn("B2 archive not found in build result", LogKeyService, serviceName)
 		return fmt.Sprintf("local:%s_image_not_found.tar", serviceName)
 
 	case "docker":
@@ -1651,11 +1880,11 @@ func (s *BuildService) getImageRefForRun(serviceName, storageType string, result
 			return tags[0] // Utilise le premier tag appliqué
 		}
 		// Fallback si aucun tag trouvé (ne devrait pas arriver si build a réussi et taggé)
-		fmt.Printf("Warning: No Docker tags found for service '%s' in finalImageTags map.\n", serviceName)
+		s.loggerFor(ctx).Warn("No Docker tags found in finalImageTags map", LogKeyService, serviceName)
 		// En dernier recours, utiliser l'ID si disponible ? Ou un tag par défaut ? Utilisons un tag par défaut.
 		if result.ImageIDs != nil {
 			if imgID, ok := result.ImageIDs[serviceName]; ok && imgID != "" {
-				fmt.Printf("Warning: Falling back to default tag for service '%s' as no specific tags were found.\n", serviceName)
+				s.loggerFor(ctx).Warn("Falling back to default tag as no specific tags were found", LogKeyService, serviceName)
 				// Construire un tag par défaut plausible (peut nécessiter le nom du projet)
 				// Ceci est un fallback, la logique de tagging dans Build() devrait être la source principale.
 				return fmt.Sprintf("%s:latest", serviceName) // Simple fallback
@@ -1664,7 +1893,7 @@ func (s *BuildService) getImageRefForRun(serviceName, storageType string, result
 		return fmt.Sprintf("docker:%s_image_or_tag_not_found", serviceName)
 
 	default: // Cas inconnu ou ""
-		fmt.Printf("Warning: Unknown artifact storage type '%s'. Falling back to default behavior.\n", storageType)
+		s.loggerFor(ctx).Warn("Unknown artifact storage type, falling back to default behavior", "storage", storageType)
 		// Comportement par défaut : essayer tag docker, puis id, puis fallback
 		if tags, ok := finalImageTags[serviceName]; ok && len(tags) > 0 && tags[0] != "" {
 			return tags[0]
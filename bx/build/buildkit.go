@@ -0,0 +1,135 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type buildSecretsContextKey struct{}
+
+// withBuildSecrets makes the build-time secrets available to the image builds
+func withBuildSecrets(ctx context.Context, secrets map[string]string) context.Context {
+	return context.WithValue(ctx, buildSecretsContextKey{}, secrets)
+}
+
+// buildSecretsFrom returns the build-time secrets of the build running with ctx
+func buildSecretsFrom(ctx context.Context) map[string]string {
+	secrets, _ := ctx.Value(buildSecretsContextKey{}).(map[string]string)
+	return secrets
+}
+
+// needsBuildKitSession reports whether the build uses secret or ssh mounts. Those need a BuildKit
+// session between the client and the daemon, which the docker CLI provides.
+func needsBuildKitSession(ctx context.Context, spec *BuildSpec) bool {
+	return len(buildSecretsFrom(ctx)) > 0 || len(spec.BuildConfig.SSHForward) > 0
+}
+
+// buildWithCLI builds an image with the CLI of the backend (`docker build`, `podman build`), exposing the build secrets and the ssh agents
+// to the Dockerfile mounts. The secret values are written in a private temporary directory removed
+// after the build, they never reach the build context nor the image layers.
+func (s *BuildService) buildWithCLI(ctx context.Context, buildContextDir, dockerfilePath string, spec *BuildSpec) (string, string, error) {
+	var logBuffer bytes.Buffer
+
+	tmpDir, err := os.MkdirTemp("", "bx-build-secrets-")
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create the build secrets directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	secretFiles := make(map[string]string)
+	for name, value := range buildSecretsFrom(ctx) {
+		path := filepath.Join(tmpDir, "secret-"+fmt.Sprint(len(secretFiles)))
+		if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+			return "", "", fmt.Errorf("cannot write the build secret '%s': %w", name, err)
+		}
+		secretFiles[name] = path
+	}
+	iidFile := filepath.Join(tmpDir, "iid")
+	// The registries of the spec are given to the CLI through a docker config of the build
+	hasConfig, err := registryAuthsFrom(ctx).writeDockerConfig(tmpDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	args := buildCLIArgs(buildContextDir, dockerfilePath, spec, secretFiles, iidFile)
+	fmt.Fprintf(&logBuffer, "Starting BuildKit build with context: %s, Dockerfile: %s (%d secrets, %d ssh forwards)\n",
+		buildContextDir, dockerfilePath, len(secretFiles), len(spec.BuildConfig.SSHForward))
+
+	configDir := ""
+	if hasConfig {
+		configDir = tmpDir
+	}
+	cmd := s.backendFor(ctx).BuildCommand(ctx, args, configDir)
+	cmd.Stdout = &logBuffer
+	cmd.Stderr = &logBuffer
+	if err := cmd.Run(); err != nil {
+		return "", logBuffer.String(), fmt.Errorf("%s build failed: %w", s.backendFor(ctx).Engine(), err)
+	}
+
+	data, err := os.ReadFile(iidFile)
+	if err != nil {
+		return "", logBuffer.String(), fmt.Errorf("cannot read the built image ID: %w", err)
+	}
+	imageID := strings.TrimPrefix(strings.TrimSpace(string(data)), "sha256:")
+	if imageID == "" {
+		return "", logBuffer.String(), fmt.Errorf("%s build did not report an image ID", s.backendFor(ctx).Engine())
+	}
+	fmt.Fprintf(&logBuffer, "\nBuild successful. Final Image ID: %s\n", imageID)
+	journalFrom(ctx).Track(ResourceImage, imageID)
+	return imageID, logBuffer.String(), nil
+}
+
+// buildCLIArgs returns the `docker build` arguments of a spec, secretFiles maps the secret ids to their file
+func buildCLIArgs(buildContextDir, dockerfilePath string, spec *BuildSpec, secretFiles map[string]string, iidFile string) []string {
+	config := spec.BuildConfig
+	args := []string{"build", "--progress=plain", "--iidfile", iidFile,
+		"-f", filepath.Join(buildContextDir, filepath.Base(dockerfilePath))}
+	for _, tag := range config.Tags {
+		args = append(args, "-t", tag)
+	}
+	argNames := make([]string, 0, len(config.Args))
+	for name := range config.Args {
+		argNames = append(argNames, name)
+	}
+	sort.Strings(argNames)
+	for _, name := range argNames {
+		args = append(args, "--build-arg", name+"="+config.Args[name])
+	}
+	labelNames := make([]string, 0, len(config.Labels))
+	for name := range config.Labels {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+	for _, name := range labelNames {
+		args = append(args, "--label", name+"="+config.Labels[name])
+	}
+	if config.Target != "" {
+		args = append(args, "--target", config.Target)
+	}
+	if config.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if config.Pull {
+		args = append(args, "--pull")
+	}
+	for _, cacheFrom := range config.CacheFrom {
+		args = append(args, "--cache-from", cacheFrom)
+	}
+	secretNames := make([]string, 0, len(secretFiles))
+	for name := range secretFiles {
+		secretNames = append(secretNames, name)
+	}
+	sort.Strings(secretNames)
+	for _, name := range secretNames {
+		args = append(args, "--secret", fmt.Sprintf("id=%s,src=%s", name, secretFiles[name]))
+	}
+	for _, forward := range config.SSHForward {
+		args = append(args, "--ssh", forward)
+	}
+	return append(args, buildContextDir)
+}
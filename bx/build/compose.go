@@ -0,0 +1,290 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Name given to compose-go for the files without `name`, it requires a project name
+const defaultComposeProjectName = "bx"
+
+// Name of the network compose adds to the services without networks
+const defaultComposeNetwork = "default"
+
+// LoadComposeFile parses a compose file with compose-go: the file is validated against the compose
+// specification and every syntax (short and long ports, volumes, depends_on, env_file...) is normalized.
+// The services of every profile are loaded.
+// The ${VAR} references are not interpolated, see LoadComposeFileWithEnv. The relative paths are kept
+// relative to the compose file and the `include` entries are ignored.
+func LoadComposeFile(data []byte) (*ComposeProject, error) {
+	var named struct {
+		Name string `yaml:"name"`
+	}
+	if err := yaml.Unmarshal(data, &named); err != nil {
+		return nil, fmt.Errorf("error during the compose YAML file parsing: %w", err)
+	}
+
+	details := types.ConfigDetails{
+		ConfigFiles: []types.ConfigFile{{Filename: "docker-compose.yml", Content: data}},
+		Environment: types.Mapping{},
+	}
+	project, err := loader.LoadWithContext(context.Background(), details, func(o *loader.Options) {
+		o.SetProjectName(defaultComposeProjectName, false)
+		o.SkipInterpolation = true
+		o.SkipResolveEnvironment = true // env_file is resolved relative to the build directory, see ResolveComposeEnvFiles
+		o.SkipInclude = true
+		o.ResolvePaths = false
+		o.Profiles = []string{"*"} // The services of every profile are built, bx run --profile selects them
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during the compose file loading: %w", err)
+	}
+	if len(project.Services) == 0 {
+		return nil, fmt.Errorf("no service section found in the compose file config")
+	}
+
+	composeProject := &ComposeProject{
+		Name:     named.Name,
+		Services: make(map[string]ComposeService, len(project.Services)),
+		Volumes:  make(map[string]interface{}, len(project.Volumes)),
+		Networks: make(map[string]interface{}, len(project.Networks)),
+	}
+	// The names defaulted by compose-go (<project>_<key>) are cleared, the runners prefix with their own project
+	for name, volume := range project.Volumes {
+		if !volume.External && volume.Name == project.Name+"_"+name {
+			volume.Name = ""
+		}
+		composeProject.Volumes[name] = volume
+	}
+	for name, network := range project.Networks {
+		if !network.External && network.Name == project.Name+"_"+name {
+			network.Name = ""
+		}
+		composeProject.Networks[name] = network
+	}
+	for name, service := range project.Services {
+		composeProject.Services[name] = composeServiceFrom(service)
+	}
+	return composeProject, nil
+}
+
+// composeServiceFrom converts a compose-go service to the bx representation
+func composeServiceFrom(service types.ServiceConfig) ComposeService {
+	converted := ComposeService{
+		Image:       service.Image,
+		Command:     service.Command,
+		Entrypoint:  service.Entrypoint,
+		Environment: make(map[string]*string, len(service.Environment)),
+		Restart:     service.Restart,
+		Labels:      service.Labels,
+		Expose:      service.Expose,
+		NetworkMode: service.NetworkMode,
+		User:        service.User,
+		WorkingDir:  service.WorkingDir,
+		Tmpfs:       service.Tmpfs,
+		Profiles:    service.Profiles,
+	}
+	for key, value := range service.Environment {
+		converted.Environment[key] = value
+	}
+	for _, envFile := range service.EnvFiles {
+		converted.EnvFile = append(converted.EnvFile, ComposeEnvFile{Path: envFile.Path, Required: envFile.Required})
+	}
+	if service.StopGracePeriod != nil {
+		converted.StopGracePeriod = service.StopGracePeriod.String()
+	}
+
+	if service.Build != nil {
+		converted.Build = &ComposeBuild{
+			Context:    service.Build.Context,
+			Dockerfile: service.Build.Dockerfile,
+			Args:       make(map[string]*string, len(service.Build.Args)),
+			Target:     service.Build.Target,
+			CacheFrom:  service.Build.CacheFrom,
+			Labels:     service.Build.Labels,
+			Network:    service.Build.Network,
+		}
+		for key, value := range service.Build.Args {
+			converted.Build.Args[key] = value
+		}
+	}
+
+	for _, port := range service.Ports {
+		converted.Ports = append(converted.Ports, composePortSpec(port))
+	}
+	for _, volume := range service.Volumes {
+		if volume.Type == types.VolumeTypeTmpfs {
+			converted.Tmpfs = append(converted.Tmpfs, volume.Target)
+			continue
+		}
+		converted.Volumes = append(converted.Volumes, composeVolumeSpec(volume))
+	}
+
+	for dependency, config := range service.DependsOn {
+		converted.DependsOn = append(converted.DependsOn, Dependency{Service: dependency, Condition: config.Condition})
+	}
+	sort.Slice(converted.DependsOn, func(i, j int) bool { return converted.DependsOn[i].Service < converted.DependsOn[j].Service })
+	for _, network := range service.NetworksByPriority() {
+		if network != defaultComposeNetwork {
+			converted.Networks = append(converted.Networks, network)
+		}
+	}
+
+	if service.HealthCheck != nil {
+		converted.HealthCheck = composeHealthCheck(service.HealthCheck)
+	}
+	converted.Deploy = composeDeploy(service)
+	if converted.Restart == "" && service.Deploy != nil && service.Deploy.RestartPolicy != nil {
+		converted.Restart = restartFromPolicy(service.Deploy.RestartPolicy.Condition)
+	}
+	return converted
+}
+
+// composePortSpec formats a port in the docker CLI syntax: [ip:][published:]target[/protocol]
+func composePortSpec(port types.ServicePortConfig) string {
+	spec := fmt.Sprint(port.Target)
+	if port.Published != "" {
+		spec = port.Published + ":" + spec
+		if port.HostIP != "" {
+			spec = port.HostIP + ":" + spec
+		}
+	}
+	if port.Protocol != "" && port.Protocol != "tcp" {
+		spec += "/" + port.Protocol
+	}
+	return spec
+}
+
+// composeVolumeSpec formats a bind or volume mount in the docker CLI syntax: [source:]target[:ro]
+func composeVolumeSpec(volume types.ServiceVolumeConfig) string {
+	spec := volume.Target
+	if volume.Source != "" {
+		spec = volume.Source + ":" + spec
+	}
+	var options []string
+	if volume.ReadOnly {
+		options = append(options, "ro")
+	}
+	if volume.Bind != nil && volume.Bind.SELinux != "" {
+		options = append(options, volume.Bind.SELinux)
+	}
+	if volume.Volume != nil && volume.Volume.NoCopy {
+		options = append(options, "nocopy")
+	}
+	if len(options) > 0 && volume.Source != "" {
+		spec += ":" + strings.Join(options, ",")
+	}
+	return spec
+}
+
+// runNetworks returns the networks of a compose project for the run.yml, the implicit default network excluded
+func runNetworks(project *ComposeProject) map[string]RunNetwork {
+	networks := make(map[string]RunNetwork)
+	for name, value := range project.Networks {
+		network, ok := value.(types.NetworkConfig)
+		if !ok || name == defaultComposeNetwork {
+			continue
+		}
+		networks[name] = RunNetwork{
+			Driver:     network.Driver,
+			DriverOpts: network.DriverOpts,
+			Internal:   network.Internal,
+			External:   bool(network.External),
+			Name:       network.Name,
+			Labels:     network.Labels,
+		}
+	}
+	return networks
+}
+
+// runVolumes returns the named volumes of a compose project for the run.yml
+func runVolumes(project *ComposeProject) map[string]RunVolume {
+	volumes := make(map[string]RunVolume)
+	for name, value := range project.Volumes {
+		volume, ok := value.(types.VolumeConfig)
+		if !ok {
+			continue
+		}
+		volumes[name] = RunVolume{
+			Driver:     volume.Driver,
+			DriverOpts: volume.DriverOpts,
+			External:   bool(volume.External),
+			Name:       volume.Name,
+			Labels:     volume.Labels,
+		}
+	}
+	return volumes
+}
+
+func composeHealthCheck(config *types.HealthCheckConfig) *HealthCheck {
+	check := &HealthCheck{
+		Test:    config.Test,
+		Disable: config.Disable,
+	}
+	if config.Interval != nil {
+		check.Interval = config.Interval.String()
+	}
+	if config.Timeout != nil {
+		check.Timeout = config.Timeout.String()
+	}
+	if config.StartPeriod != nil {
+		check.StartPeriod = config.StartPeriod.String()
+	}
+	if config.Retries != nil {
+		retries := int(*config.Retries)
+		check.Retries = &retries
+	}
+	return check
+}
+
+// composeDeploy keeps the replicas and the resource limits of a service, from the deploy section
+// or from the legacy cpus and mem_limit attributes
+func composeDeploy(service types.ServiceConfig) *ComposeDeploy {
+	resources := ServiceResources{
+		CPUs:              float64(service.CPUS),
+		Memory:            int64(service.MemLimit),
+		MemoryReservation: int64(service.MemReservation),
+	}
+	var replicas *int
+	if service.Deploy != nil {
+		replicas = service.Deploy.Replicas
+		if limits := service.Deploy.Resources.Limits; limits != nil {
+			if limits.NanoCPUs != 0 {
+				resources.CPUs = float64(limits.NanoCPUs)
+			}
+			if limits.MemoryBytes != 0 {
+				resources.Memory = int64(limits.MemoryBytes)
+			}
+		}
+		if reservations := service.Deploy.Resources.Reservations; reservations != nil && reservations.MemoryBytes != 0 {
+			resources.MemoryReservation = int64(reservations.MemoryBytes)
+		}
+	}
+	if replicas == nil && resources == (ServiceResources{}) {
+		return nil
+	}
+	deploy := &ComposeDeploy{Replicas: replicas}
+	if resources != (ServiceResources{}) {
+		deploy.Resources = &resources
+	}
+	return deploy
+}
+
+// restartFromPolicy converts a deploy restart condition to a docker restart policy
+func restartFromPolicy(condition string) string {
+	switch condition {
+	case "none":
+		return "no"
+	case "on-failure":
+		return "on-failure"
+	case "any", "":
+		return "always"
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadComposeFile_LongSyntax(t *testing.T) {
+	compose := `
+name: shop
+services:
+  api:
+    build:
+      context: ./api
+      args:
+        - VERSION=1.2
+    ports:
+      - target: 8080
+        published: "80"
+        host_ip: 127.0.0.1
+      - target: 53
+        published: "5353"
+        protocol: udp
+      - "9000"
+    volumes:
+      - type: bind
+        source: ./config
+        target: /etc/api
+        read_only: true
+      - type: volume
+        source: data
+        target: /var/lib/api
+      - type: tmpfs
+        target: /tmp
+      - /cache
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost:8080/health"]
+      interval: 30s
+      timeout: 5s
+      retries: 3
+      start_period: 1m30s
+    deploy:
+      replicas: 2
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 512M
+        reservations:
+          memory: 128M
+      restart_policy:
+        condition: on-failure
+    networks:
+      back:
+        aliases: [api.internal]
+    depends_on:
+      db:
+        condition: service_healthy
+    user: "1000:1000"
+    working_dir: /app
+    stop_grace_period: 20s
+  db:
+    image: postgres:16
+    networks: [back]
+    mem_limit: 1g
+networks:
+  back: {}
+volumes:
+  data: {}
+`
+	project, err := LoadComposeFile([]byte(compose))
+	require.NoError(t, err)
+	assert.Equal(t, "shop", project.Name)
+	assert.Contains(t, project.Networks, "back")
+	assert.Contains(t, project.Volumes, "data")
+
+	api := project.Services["api"]
+	require.NotNil(t, api.Build)
+	assert.Equal(t, "./api", api.Build.Context)
+	assert.Equal(t, "1.2", *api.Build.Args["VERSION"])
+	assert.Equal(t, []string{"127.0.0.1:80:8080", "5353:53/udp", "9000"}, api.Ports)
+	assert.Equal(t, []string{"./config:/etc/api:ro", "data:/var/lib/api", "/cache"}, api.Volumes)
+	assert.Equal(t, []string{"/tmp"}, api.Tmpfs)
+	assert.Equal(t, &HealthCheck{
+		Test:        []string{"CMD", "curl", "-f", "http://localhost:8080/health"},
+		Interval:    "30s",
+		Timeout:     "5s",
+		Retries:     intPtr(3),
+		StartPeriod: "1m30s",
+	}, api.HealthCheck)
+	require.NotNil(t, api.Deploy)
+	assert.Equal(t, intPtr(2), api.Deploy.Replicas)
+	assert.Equal(t, &ServiceResources{CPUs: 0.5, Memory: 512 << 20, MemoryReservation: 128 << 20}, api.Deploy.Resources)
+	assert.Equal(t, "on-failure", api.Restart)
+	assert.Equal(t, []string{"back"}, api.Networks)
+	assert.Equal(t, Dependencies{{Service: "db", Condition: ConditionServiceHealthy}}, api.DependsOn)
+	assert.Equal(t, "1000:1000", api.User)
+	assert.Equal(t, "/app", api.WorkingDir)
+	assert.Equal(t, "20s", api.StopGracePeriod)
+	assert.NotNil(t, api.Environment)
+
+	db := project.Services["db"]
+	assert.Nil(t, db.Build)
+	assert.Equal(t, []string{"back"}, db.Networks)
+	assert.Equal(t, int64(1<<30), db.Deploy.Resources.Memory)
+}
+
+func TestLoadComposeFile_Invalid(t *testing.T) {
+	// Unknown attributes and dangling references are reported instead of being dropped
+	_, err := LoadComposeFile([]byte("services:\n  api:\n    image: api\n    portz: [\"80\"]\n"))
+	assert.Error(t, err)
+	_, err = LoadComposeFile([]byte("services:\n  api:\n    image: api\n    depends_on: [db]\n"))
+	assert.Error(t, err)
+	_, err = LoadComposeFile([]byte("name: empty\n"))
+	assert.Error(t, err)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestLoadComposeFile_Profiles(t *testing.T) {
+	project, err := LoadComposeFile([]byte(`
+services:
+  web:
+    image: web
+  adminer:
+    image: adminer
+    profiles: [debug]
+`))
+	require.NoError(t, err)
+	require.Contains(t, project.Services, "adminer")
+	assert.Equal(t, []string{"debug"}, project.Services["adminer"].Profiles)
+	assert.Empty(t, project.Services["web"].Profiles)
+}
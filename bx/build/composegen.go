@@ -0,0 +1,153 @@
+package build
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// generatedCompose is the docker-compose file written next to the run.yml, for `docker compose up`
+type generatedCompose struct {
+	Name     string                             `yaml:"name"`
+	Services map[string]generatedComposeService `yaml:"services"`
+	Networks map[string]RunNetwork              `yaml:"networks,omitempty"`
+	Volumes  map[string]RunVolume               `yaml:"volumes,omitempty"`
+}
+
+// generatedComposeService uses the short compose attributes (cpus, mem_limit) rather than the deploy section
+type generatedComposeService struct {
+	Image          string            `yaml:"image"`
+	Command        []string          `yaml:"command,omitempty"`
+	Entrypoint     []string          `yaml:"entrypoint,omitempty"`
+	EnvFile        []string          `yaml:"env_file,omitempty"`
+	Environment    map[string]string `yaml:"environment,omitempty"`
+	Ports          []string          `yaml:"ports,omitempty"`
+	Volumes        []string          `yaml:"volumes,omitempty"`
+	DependsOn      Dependencies      `yaml:"depends_on,omitempty"`
+	Restart        string            `yaml:"restart,omitempty"`
+	HealthCheck    *HealthCheck      `yaml:"healthcheck,omitempty"`
+	Networks       []string          `yaml:"networks,omitempty"`
+	NetworkMode    string            `yaml:"network_mode,omitempty"`
+	Labels         map[string]string `yaml:"labels,omitempty"`
+	User           string            `yaml:"user,omitempty"`
+	WorkingDir     string            `yaml:"working_dir,omitempty"`
+	Tmpfs          []string          `yaml:"tmpfs,omitempty"`
+	CPUs           float64           `yaml:"cpus,omitempty"`
+	MemLimit       int64             `yaml:"mem_limit,omitempty"`
+	MemReservation int64             `yaml:"mem_reservation,omitempty"`
+	Profiles       []string          `yaml:"profiles,omitempty"`
+}
+
+var invalidComposeNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// newGeneratedCompose converts a run.yml to a compose file. The services use the tags of the built
+// images, loaded from the archives for the "local" and "b2" storages, and the image of the
+// compose file for the others. It returns the approximations as warnings.
+func newGeneratedCompose(spec *BuildSpec, runYAML *RunYAML, finalImageTags map[string][]string, composeProject *ComposeProject) (*generatedCompose, []string) {
+	compose := &generatedCompose{
+		Name:     strings.Trim(invalidComposeNameChars.ReplaceAllString(strings.ToLower(spec.Name), "-"), "-_"),
+		Services: make(map[string]generatedComposeService, len(runYAML.Services)),
+	}
+	if len(runYAML.Networks) > 0 {
+		compose.Networks = make(map[string]RunNetwork, len(runYAML.Networks))
+		for name, network := range runYAML.Networks {
+			compose.Networks[name] = network
+		}
+	}
+	if len(runYAML.Volumes) > 0 {
+		compose.Volumes = make(map[string]RunVolume, len(runYAML.Volumes))
+		for name, volume := range runYAML.Volumes {
+			compose.Volumes[name] = volume
+		}
+	}
+	var warnings []string
+	for serviceName, service := range runYAML.Services {
+		image := service.Image
+		if tags := finalImageTags[serviceName]; len(tags) > 0 && tags[0] != "" {
+			image = tags[0]
+		} else if composeProject != nil && composeProject.Services[serviceName].Image != "" {
+			image = composeProject.Services[serviceName].Image
+		}
+		if IsImageArchive(image) || strings.HasPrefix(image, B2Scheme) {
+			warnings = append(warnings, fmt.Sprintf("the service '%s' has no image tag, the compose file references the archive '%s'", serviceName, image))
+		}
+		if len(service.SecretFiles) > 0 {
+			warnings = append(warnings, fmt.Sprintf("the secret files of the service '%s' are not written in the compose file", serviceName))
+		}
+
+		generated := generatedComposeService{
+			Image:       image,
+			Command:     service.Command,
+			Entrypoint:  service.Entrypoint,
+			Environment: service.Environment,
+			Ports:       service.Ports,
+			Volumes:     service.Volumes,
+			DependsOn:   service.DependsOn,
+			Restart:     service.Restart,
+			HealthCheck: service.HealthCheck,
+			Networks:    service.Networks,
+			NetworkMode: service.NetworkMode,
+			Labels:      service.Labels,
+			User:        service.User,
+			WorkingDir:  service.WorkingDir,
+			Tmpfs:       service.Tmpfs,
+			Profiles:    service.Profiles,
+		}
+		if resources := service.Resources; resources != nil {
+			generated.CPUs = resources.CPUs
+			generated.MemLimit = resources.Memory
+			generated.MemReservation = resources.MemoryReservation
+		}
+		if len(service.SecretEnv) > 0 && runYAML.EnvFile != "" {
+			// Next to the compose file, docker compose reads the secret values from it
+			generated.EnvFile = []string{runYAML.EnvFile}
+		}
+		compose.Services[serviceName] = generated
+
+		// The networks and the named volumes must be declared at the top level
+		for _, network := range service.Networks {
+			if compose.Networks == nil {
+				compose.Networks = make(map[string]RunNetwork)
+			}
+			if _, declared := compose.Networks[network]; !declared {
+				compose.Networks[network] = RunNetwork{}
+			}
+		}
+		for _, volume := range service.Volumes {
+			source, _, ok := strings.Cut(volume, ":")
+			if !ok || filepath.IsAbs(source) || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~") {
+				continue
+			}
+			if compose.Volumes == nil {
+				compose.Volumes = make(map[string]RunVolume)
+			}
+			if _, declared := compose.Volumes[source]; !declared {
+				compose.Volumes[source] = RunVolume{}
+			}
+		}
+	}
+	return compose, warnings
+}
+
+// writeGeneratedCompose writes the compose file of a run.yml in the output dir, a failure is only a warning
+func (s *BuildService) writeGeneratedCompose(spec *BuildSpec, runYAML *RunYAML, finalImageTags map[string][]string, composeProject *ComposeProject, outputBasePath string, result *BuildResult, events *eventStream) {
+	compose, warnings := newGeneratedCompose(spec, runYAML, finalImageTags, composeProject)
+	for _, warning := range warnings {
+		events.Warnf("%s", warning)
+	}
+	data, err := yaml.Marshal(compose)
+	if err != nil {
+		events.Warnf("cannot encode the compose file: %v", err)
+		return
+	}
+	composePath := filepath.Join(outputBasePath, fmt.Sprintf("%s-%s.compose.yml", spec.Name, spec.Version))
+	if err := s.OutputOptions().WriteFile(composePath, data); err != nil {
+		events.Warnf("cannot write the compose file '%s': %v", composePath, err)
+		return
+	}
+	result.ComposeFilePath = composePath
+	events.Artifact(ArtifactComposeFile, "", composePath)
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNewGeneratedCompose(t *testing.T) {
+	spec := &BuildSpec{Name: "My Shop", Version: "1.2.0"}
+	runYAML := &RunYAML{Networks: map[string]RunNetwork{"backend": {Internal: true}}, Services: map[string]RunService{
+		"api": {
+			Image:       "shop-api-1.2.0.tar.zst",
+			Environment: map[string]string{"DB_HOST": "db"},
+			Ports:       []string{"8080:80"},
+			Volumes:     []string{"uploads:/srv/uploads", "./config:/etc/api:ro"},
+			DependsOn:   Dependencies{{Service: "db", Condition: ConditionServiceHealthy}},
+			Networks:    []string{"backend"},
+			Resources:   &ServiceResources{CPUs: 0.5, Memory: 256 << 20},
+			SecretFiles: []RunSecretFile{{Name: "tls_key", Target: "/run/secrets/tls_key", Content: "a2V5"}},
+		},
+		"db": {Image: "docker:db_image_or_tag_not_found", Networks: []string{"backend"}},
+	}}
+	tags := map[string][]string{"api": {"shop-api:1.2.0"}}
+	project := &ComposeProject{Services: map[string]ComposeService{"db": {Image: "postgres:16"}}}
+
+	compose, warnings := newGeneratedCompose(spec, runYAML, tags, project)
+	assert.Equal(t, "my-shop", compose.Name)
+	assert.Equal(t, "shop-api:1.2.0", compose.Services["api"].Image)
+	assert.Equal(t, "postgres:16", compose.Services["db"].Image)
+	assert.Equal(t, int64(256<<20), compose.Services["api"].MemLimit)
+	assert.Equal(t, map[string]RunNetwork{"backend": {Internal: true}}, compose.Networks)
+	assert.Equal(t, map[string]RunVolume{"uploads": {}}, compose.Volumes)
+	assert.Len(t, warnings, 1)
+
+	// The generated file is a valid compose file
+	data, err := yaml.Marshal(compose)
+	require.NoError(t, err)
+	loaded, _, err := LoadComposeFileWithEnv(data, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Dependencies{{Service: "db", Condition: ConditionServiceHealthy}}, loaded.Services["api"].DependsOn)
+	assert.Equal(t, []string{"8080:80"}, loaded.Services["api"].Ports)
+	assert.True(t, runNetworks(loaded)["backend"].Internal)
+}
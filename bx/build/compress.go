@@ -0,0 +1,152 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of the image archives written by the local and B2 outputs
+const (
+	CompressionNone = "none" // Raw `docker save` tar (default)
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Extensions of the image archives by compression, `docker load` reads all of them
+var imageArchiveExts = map[string]string{
+	CompressionNone: ".tar",
+	CompressionGzip: ".tar.gz",
+	CompressionZstd: ".tar.zst",
+}
+
+// ImageArchiveExt returns the file extension of the image archives with a compression
+func ImageArchiveExt(compression string) string {
+	if ext, ok := imageArchiveExts[compression]; ok {
+		return ext
+	}
+	return imageArchiveExts[CompressionNone]
+}
+
+// IsImageArchive reports whether an image reference of a run.yml is the path of an image archive
+func IsImageArchive(imageRef string) bool {
+	return TrimImageArchiveExt(imageRef) != imageRef
+}
+
+// TrimImageArchiveExt removes the archive extension of an image archive path
+func TrimImageArchiveExt(path string) string {
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// validateCompression checks the compression of a build config, the level 0 is the default of the algorithm
+func validateCompression(config BuildConfig) error {
+	switch config.Compression {
+	case "", CompressionNone:
+		if config.CompressionLevel != 0 {
+			return fmt.Errorf("'compression_level' requires a 'compression'")
+		}
+	case CompressionGzip:
+		if config.CompressionLevel < 0 || config.CompressionLevel > gzip.BestCompression {
+			return fmt.Errorf("the gzip compression level must be between 1 and %d", gzip.BestCompression)
+		}
+	case CompressionZstd:
+		if config.CompressionLevel < 0 || config.CompressionLevel > 22 {
+			return fmt.Errorf("the zstd compression level must be between 1 and 22")
+		}
+	default:
+		return fmt.Errorf("unknown compression '%s', expected '%s', '%s' or '%s'", config.Compression, CompressionNone, CompressionGzip, CompressionZstd)
+	}
+	return nil
+}
+
+// newCompressWriter returns a writer compressing to w, it must be closed to flush the compressed data
+func newCompressWriter(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch compression {
+	case "", CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, options...)
+	}
+	return nil, fmt.Errorf("unknown compression '%s'", compression)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// writeImageArchive compresses an image archive from src to dst and returns the digest
+// ("sha256:<hex>") and the size of the written archive
+func writeImageArchive(dst io.Writer, src io.Reader, compression string, level int) (string, int64, error) {
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(dst, hash)}
+	compressor, err := newCompressWriter(counter, compression, level)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := io.Copy(compressor, src); err != nil {
+		compressor.Close()
+		return "", 0, err
+	}
+	if err := compressor.Close(); err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), counter.n, nil
+}
+
+// Magic numbers of the compressed image archives
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// newDecompressReader returns a reader of the tar of an image archive, its compression is
+// detected from its first bytes like `docker load` does
+func newDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,57 @@
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteImageArchive(t *testing.T) {
+	archive := bytes.Repeat([]byte("layer data "), 4096)
+	decompress := map[string]func(io.Reader) (io.Reader, error){
+		CompressionNone: func(r io.Reader) (io.Reader, error) { return r, nil },
+		CompressionGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		CompressionZstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for compression, reader := range decompress {
+		t.Run(compression, func(t *testing.T) {
+			var out bytes.Buffer
+			digest, size, err := writeImageArchive(&out, bytes.NewReader(archive), compression, 3)
+			require.NoError(t, err)
+
+			sum := sha256.Sum256(out.Bytes())
+			assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), digest)
+			assert.Equal(t, int64(out.Len()), size)
+			if compression != CompressionNone {
+				assert.Less(t, size, int64(len(archive)))
+			}
+
+			r, err := reader(&out)
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, archive, data)
+		})
+	}
+}
+
+func TestImageArchiveNames(t *testing.T) {
+	assert.Equal(t, ".tar", ImageArchiveExt(""))
+	assert.Equal(t, ".tar.zst", ImageArchiveExt(CompressionZstd))
+	assert.True(t, IsImageArchive("app_web.tar.gz"))
+	assert.True(t, IsImageArchive("/out/app_web.tar"))
+	assert.False(t, IsImageArchive("app/web:1.0"))
+	assert.Equal(t, "app_web", TrimImageArchiveExt("app_web.tar.zst"))
+
+	assert.NoError(t, validateCompression(BuildConfig{Compression: CompressionZstd, CompressionLevel: 19}))
+	assert.Error(t, validateCompression(BuildConfig{Compression: CompressionGzip, CompressionLevel: 12}))
+	assert.Error(t, validateCompression(BuildConfig{Compression: "xz"}))
+	assert.Error(t, validateCompression(BuildConfig{CompressionLevel: 3}))
+}
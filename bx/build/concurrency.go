@@ -0,0 +1,79 @@
+package build
+
+import (
+	"context"
+	"fmt"
+)
+
+// Behaviours of a build started while another build of the same name and version runs on the service
+const (
+	ConcurrencyAllow  = "allow"  // Both builds run (default)
+	ConcurrencyQueue  = "queue"  // The build waits for the end of the running one
+	ConcurrencyReject = "reject" // The build fails with ErrBuildInProgress
+)
+
+// buildLock serializes the builds of a name and version, it is removed when no build holds or waits for it
+type buildLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+// validateConcurrency checks the concurrency of a build config
+func validateConcurrency(config BuildConfig) error {
+	switch config.Concurrency {
+	case "", ConcurrencyAllow, ConcurrencyQueue, ConcurrencyReject:
+		return nil
+	}
+	return fmt.Errorf("unknown concurrency '%s', expected '%s', '%s' or '%s'", config.Concurrency, ConcurrencyAllow, ConcurrencyQueue, ConcurrencyReject)
+}
+
+// lockBuild applies the concurrency of the spec: with "queue" it waits for the running build of the
+// same name and version, with "reject" it fails if there is one. The returned func releases the lock.
+func (s *BuildService) lockBuild(ctx context.Context, spec *BuildSpec, logf func(format string, args ...any)) (func(), error) {
+	mode := spec.BuildConfig.Concurrency
+	if mode == "" || mode == ConcurrencyAllow {
+		return func() {}, nil
+	}
+	key := spec.Name + "@" + spec.Version
+
+	s.mutex.Lock()
+	if s.buildLocks == nil {
+		s.buildLocks = make(map[string]*buildLock)
+	}
+	lock, ok := s.buildLocks[key]
+	if !ok {
+		lock = &buildLock{sem: make(chan struct{}, 1)}
+		s.buildLocks[key] = lock
+	}
+	lock.refs++
+	s.mutex.Unlock()
+
+	unref := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(s.buildLocks, key)
+		}
+	}
+
+	select {
+	case lock.sem <- struct{}{}:
+	default:
+		if mode == ConcurrencyReject {
+			unref()
+			return nil, fmt.Errorf("a build of '%s' is already running: %w", key, ErrBuildInProgress)
+		}
+		logf("Waiting for the running build of %s", key)
+		select {
+		case lock.sem <- struct{}{}:
+		case <-ctx.Done():
+			unref()
+			return nil, fmt.Errorf("cannot wait for the running build of '%s': %w", key, ctx.Err())
+		}
+	}
+	return func() {
+		<-lock.sem
+		unref()
+	}, nil
+}
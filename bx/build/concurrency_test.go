@@ -0,0 +1,85 @@
+package build
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockBuild(t *testing.T) {
+	s := &BuildService{}
+	noLog := func(string, ...any) {}
+	spec := func(concurrency string) *BuildSpec {
+		return &BuildSpec{Name: "shop", Version: "1.0.0", BuildConfig: BuildConfig{Concurrency: concurrency}}
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		unlock, err := s.lockBuild(context.Background(), spec(ConcurrencyReject), noLog)
+		require.NoError(t, err)
+		_, err = s.lockBuild(context.Background(), spec(ConcurrencyReject), noLog)
+		assert.ErrorIs(t, err, ErrBuildInProgress)
+
+		// Another version is not locked
+		other := spec(ConcurrencyReject)
+		other.Version = "1.0.1"
+		unlockOther, err := s.lockBuild(context.Background(), other, noLog)
+		require.NoError(t, err)
+		unlockOther()
+
+		unlock()
+		unlock, err = s.lockBuild(context.Background(), spec(ConcurrencyReject), noLog)
+		require.NoError(t, err)
+		unlock()
+		assert.Empty(t, s.buildLocks)
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		unlock, err := s.lockBuild(context.Background(), spec(ConcurrencyQueue), noLog)
+		require.NoError(t, err)
+
+		acquired := make(chan struct{})
+		go func() {
+			unlockQueued, err := s.lockBuild(context.Background(), spec(ConcurrencyQueue), noLog)
+			if err == nil {
+				close(acquired)
+				unlockQueued()
+			}
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("the queued build started before the end of the running one")
+		case <-time.After(50 * time.Millisecond):
+		}
+		unlock()
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("the queued build did not start")
+		}
+
+		unlock, err = s.lockBuild(context.Background(), spec(ConcurrencyQueue), noLog)
+		require.NoError(t, err)
+		defer unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = s.lockBuild(ctx, spec(ConcurrencyQueue), noLog)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		unlock, err := s.lockBuild(context.Background(), spec(""), noLog)
+		require.NoError(t, err)
+		defer unlock()
+		unlock2, err := s.lockBuild(context.Background(), spec(ConcurrencyAllow), noLog)
+		require.NoError(t, err)
+		unlock2()
+	})
+}
+
+func TestValidateConcurrency(t *testing.T) {
+	assert.NoError(t, validateConcurrency(BuildConfig{Concurrency: ConcurrencyQueue}))
+	assert.Error(t, validateConcurrency(BuildConfig{Concurrency: "wait"}))
+}
@@ -0,0 +1,97 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Conditions of a dependency, as in the compose long syntax of depends_on
+const (
+	ConditionServiceStarted               = "service_started"
+	ConditionServiceHealthy               = "service_healthy"
+	ConditionServiceCompletedSuccessfully = "service_completed_successfully"
+)
+
+// Dependency is a service started before the depending service, with the state it must reach first
+type Dependency struct {
+	Service   string
+	Condition string // ConditionServiceStarted when empty
+}
+
+// Dependencies is the depends_on of a service, written as a list of services (`depends_on: [db]`)
+// or as a map of the services to their condition (`depends_on: {db: {condition: service_healthy}}`)
+type Dependencies []Dependency
+
+type dependencyCondition struct {
+	Condition string `yaml:"condition,omitempty"`
+}
+
+// Services returns the names of the dependencies
+func (d Dependencies) Services() []string {
+	services := make([]string, 0, len(d))
+	for _, dependency := range d {
+		services = append(services, dependency.Service)
+	}
+	return services
+}
+
+// Waits reports whether the depending service waits for more than the start of the dependency
+func (d Dependency) Waits() bool {
+	return d.Condition != "" && d.Condition != ConditionServiceStarted
+}
+
+func (d *Dependencies) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var services []string
+		if err := node.Decode(&services); err != nil {
+			return err
+		}
+		dependencies := make(Dependencies, 0, len(services))
+		for _, service := range services {
+			dependencies = append(dependencies, Dependency{Service: service})
+		}
+		*d = dependencies
+	case yaml.MappingNode:
+		var conditions map[string]dependencyCondition
+		if err := node.Decode(&conditions); err != nil {
+			return err
+		}
+		dependencies := make(Dependencies, 0, len(conditions))
+		for service, condition := range conditions {
+			switch condition.Condition {
+			case "", ConditionServiceStarted, ConditionServiceHealthy, ConditionServiceCompletedSuccessfully:
+			default:
+				return fmt.Errorf("invalid condition '%s' for the dependency '%s'", condition.Condition, service)
+			}
+			dependencies = append(dependencies, Dependency{Service: service, Condition: condition.Condition})
+		}
+		sort.Slice(dependencies, func(i, j int) bool { return dependencies[i].Service < dependencies[j].Service })
+		*d = dependencies
+	default:
+		return fmt.Errorf("depends_on must be a list or a map, line %d", node.Line)
+	}
+	return nil
+}
+
+// MarshalYAML writes the short syntax unless a dependency has a condition to wait for
+func (d Dependencies) MarshalYAML() (interface{}, error) {
+	waits := false
+	for _, dependency := range d {
+		waits = waits || dependency.Waits()
+	}
+	if !waits {
+		return d.Services(), nil
+	}
+	conditions := make(map[string]dependencyCondition, len(d))
+	for _, dependency := range d {
+		condition := dependency.Condition
+		if condition == "" {
+			condition = ConditionServiceStarted
+		}
+		conditions[dependency.Service] = dependencyCondition{Condition: condition}
+	}
+	return conditions, nil
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDependenciesYAML(t *testing.T) {
+	var service RunService
+	require.NoError(t, yaml.Unmarshal([]byte("depends_on: [db, cache]"), &service))
+	assert.Equal(t, Dependencies{{Service: "db"}, {Service: "cache"}}, service.DependsOn)
+
+	data, err := yaml.Marshal(service)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "depends_on:\n    - db\n    - cache\n")
+
+	require.NoError(t, yaml.Unmarshal([]byte(`
+depends_on:
+  migrate:
+    condition: service_completed_successfully
+  db:
+    condition: service_healthy
+  cache: {}
+`), &service))
+	assert.Equal(t, Dependencies{
+		{Service: "cache"},
+		{Service: "db", Condition: ConditionServiceHealthy},
+		{Service: "migrate", Condition: ConditionServiceCompletedSuccessfully},
+	}, service.DependsOn)
+	assert.Equal(t, []string{"cache", "db", "migrate"}, service.DependsOn.Services())
+
+	// The long syntax is kept, the default condition written explicitly
+	data, err = yaml.Marshal(service)
+	require.NoError(t, err)
+	var roundTrip RunService
+	require.NoError(t, yaml.Unmarshal(data, &roundTrip))
+	assert.Equal(t, ConditionServiceStarted, roundTrip.DependsOn[0].Condition)
+	assert.Equal(t, service.DependsOn[1:], roundTrip.DependsOn[1:])
+
+	err = yaml.Unmarshal([]byte("depends_on:\n  db:\n    condition: service_ready\n"), &service)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid condition 'service_ready'")
+}
@@ -1,10 +1,13 @@
 package build
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -17,11 +20,14 @@ var (
 // DetectedEcosystem holds language/ecosystem detection details
 // Compatible with extensible language addition.
 type DetectedEcosystem struct {
-	Language       string
-	Ecosystem      string
-	PackageManager string
-	RootPath       string
-	MainMarkerFile string
+	Language        string
+	Ecosystem       string
+	PackageManager  string
+	RootPath        string
+	MainMarkerFile  string
+	LanguageVersion string // Version required by the project files (go.mod, .nvmrc, .python-version...), empty if none
+	Framework       string // Framework found in the dependencies (Next.js, Django, Spring Boot, Rails), empty if none
+	BuilderImage    string // Image of the devcontainer.json used for the build stage, empty if none
 }
 
 type detectionCandidate struct {
@@ -60,33 +66,46 @@ func DetectEcosystem(codebasePath string) (*DetectedEcosystem, error) {
 		return nil, err
 	}
 
-	postDetectionTweaks(absPath, entries, detected, secondaryMarkers)
+	completeDetection(absPath, entries, detected, secondaryMarkers)
 	fmt.Printf("Detected ecosystem: %s (%s) using %s in %s\n", detected.Language, detected.Ecosystem, detected.PackageManager, detected.RootPath)
 	return detected, nil
 }
 
+// completeDetection sets the package manager of the lockfiles, the language version and the framework
+func completeDetection(path string, entries []os.DirEntry, detected *DetectedEcosystem, secondary map[string]struct{ PackageManager, Ecosystem string }) {
+	postDetectionTweaks(path, entries, detected, secondary)
+	detected.LanguageVersion = detectLanguageVersion(path, detected)
+	applyDevcontainer(path, detected)
+	detected.Framework = detectFramework(path, detected)
+}
+
 func loadPrimaryMarkers() map[string]detectionCandidate {
 	return map[string]detectionCandidate{
-		"go.work":              {DetectedEcosystem{"Go", "Workspaces", "go", "", ""}, 10},
-		"go.mod":               {DetectedEcosystem{"Go", "Modules", "go", "", ""}, 9},
-		"Cargo.toml":           {DetectedEcosystem{"Rust", "Cargo", "cargo", "", ""}, 9},
-		"package.json":         {DetectedEcosystem{"JavaScript", "Node", "npm", "", ""}, 8},
-		"pom.xml":              {DetectedEcosystem{"Java", "Maven", "mvn", "", ""}, 9},
-		"build.gradle":         {DetectedEcosystem{"Java", "Gradle", "gradle", "", ""}, 9},
-		"build.gradle.kts":     {DetectedEcosystem{"Java", "Gradle", "gradle", "", ""}, 9},
-		"requirements.txt":     {DetectedEcosystem{"Python", "Pip", "pip", "", ""}, 8},
-		"pyproject.toml":       {DetectedEcosystem{"Python", "Poetry/Pip", "pip", "", ""}, 9},
-		"composer.json":        {DetectedEcosystem{"PHP", "Composer", "composer", "", ""}, 9},
-		"Gemfile":              {DetectedEcosystem{"Ruby", "Bundler", "bundle", "", ""}, 9},
-		"*.csproj":             {DetectedEcosystem{"C#", "MSBuild", "dotnet", "", ""}, 9},
-		"Package.swift":        {DetectedEcosystem{"Swift", "SwiftPM", "swift", "", ""}, 9},
-		"build.gradle.kts.kts": {DetectedEcosystem{"Kotlin", "Gradle", "gradle", "", ""}, 9},
+		"go.work":              {DetectedEcosystem{Language: "Go", Ecosystem: "Workspaces", PackageManager: "go"}, 10},
+		"go.mod":               {DetectedEcosystem{Language: "Go", Ecosystem: "Modules", PackageManager: "go"}, 9},
+		"Cargo.toml":           {DetectedEcosystem{Language: "Rust", Ecosystem: "Cargo", PackageManager: "cargo"}, 9},
+		"package.json":         {DetectedEcosystem{Language: "JavaScript", Ecosystem: "Node", PackageManager: "npm"}, 8},
+		"pom.xml":              {DetectedEcosystem{Language: "Java", Ecosystem: "Maven", PackageManager: "mvn"}, 9},
+		"build.gradle":         {DetectedEcosystem{Language: "Java", Ecosystem: "Gradle", PackageManager: "gradle"}, 9},
+		"build.gradle.kts":     {DetectedEcosystem{Language: "Java", Ecosystem: "Gradle", PackageManager: "gradle"}, 9},
+		"requirements.txt":     {DetectedEcosystem{Language: "Python", Ecosystem: "Pip", PackageManager: "pip"}, 8},
+		"pyproject.toml":       {DetectedEcosystem{Language: "Python", Ecosystem: "Poetry/Pip", PackageManager: "pip"}, 9},
+		"composer.json":        {DetectedEcosystem{Language: "PHP", Ecosystem: "Composer", PackageManager: "composer"}, 9},
+		"Gemfile":              {DetectedEcosystem{Language: "Ruby", Ecosystem: "Bundler", PackageManager: "bundle"}, 9},
+		"*.csproj":             {DetectedEcosystem{Language: "C#", Ecosystem: "MSBuild", PackageManager: "dotnet"}, 9},
+		"Package.swift":        {DetectedEcosystem{Language: "Swift", Ecosystem: "SwiftPM", PackageManager: "swift"}, 9},
+		"mix.exs":              {DetectedEcosystem{Language: "Elixir", Ecosystem: "Mix", PackageManager: "mix"}, 9},
+		"deno.json":            {DetectedEcosystem{Language: "JavaScript", Ecosystem: "Deno", PackageManager: "deno"}, 9},
+		"deno.jsonc":           {DetectedEcosystem{Language: "JavaScript", Ecosystem: "Deno", PackageManager: "deno"}, 9},
+		"build.gradle.kts.kts": {DetectedEcosystem{Language: "Kotlin", Ecosystem: "Gradle", PackageManager: "gradle"}, 9},
 	}
 }
 
 func loadSecondaryMarkers() map[string]struct{ PackageManager, Ecosystem string } {
 	return map[string]struct{ PackageManager, Ecosystem string }{
 		"pnpm-lock.yaml": {"pnpm", "PNPM"},
+		"bun.lockb":      {"bun", "Bun"},
+		"bun.lock":       {"bun", "Bun"},
 		"yarn.lock":      {"yarn", "Yarn"},
 	}
 }
@@ -138,7 +157,7 @@ func scanMarkers(path string, entries []os.DirEntry, primary map[string]detectio
 func postDetectionTweaks(path string, entries []os.DirEntry, detected *DetectedEcosystem, secondary map[string]struct{ PackageManager, Ecosystem string }) {
 	if detected.MainMarkerFile == "package.json" {
 		bestLock := -1
-		lockPriority := map[string]int{"pnpm-lock.yaml": 2, "yarn.lock": 1}
+		lockPriority := map[string]int{"bun.lock": 3, "bun.lockb": 3, "pnpm-lock.yaml": 2, "yarn.lock": 1}
 		for _, entry := range entries {
 			name := entry.Name()
 			if val, ok := secondary[name]; ok && lockPriority[name] > bestLock {
@@ -156,3 +175,138 @@ func postDetectionTweaks(path string, entries []os.DirEntry, detected *DetectedE
 		}
 	}
 }
+
+// versionPattern matches the first version of a constraint (">=3.10", "^20.1.0", "18.x")
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
+var (
+	targetFrameworkPattern = regexp.MustCompile(`<TargetFramework>net(\d+\.\d+)</TargetFramework>`)
+	mixElixirPattern       = regexp.MustCompile(`elixir:\s*"([^"]+)"`)
+	mixAppPattern          = regexp.MustCompile(`app:\s*:(\w+)`)
+	assemblyNamePattern    = regexp.MustCompile(`<AssemblyName>([^<]+)</AssemblyName>`)
+)
+
+// detectLanguageVersion reads the version of the language required by the project: the go directive of
+// go.mod or go.work, .nvmrc or the engines of package.json, .python-version or pyproject.toml, .ruby-version,
+// the TargetFramework of the .csproj, the elixir requirement of mix.exs, the php requirement of composer.json
+func detectLanguageVersion(path string, detected *DetectedEcosystem) string {
+	switch detected.Language {
+	case "Go":
+		return readGoDirective(filepath.Join(path, detected.MainMarkerFile))
+	case "JavaScript":
+		// The Node versions are not the versions of the Deno and Bun images
+		if detected.PackageManager == "deno" || detected.PackageManager == "bun" {
+			return ""
+		}
+		if version := readVersionFile(filepath.Join(path, ".nvmrc")); version != "" {
+			return version
+		}
+		var pkg struct {
+			Engines struct {
+				Node string `json:"node"`
+			} `json:"engines"`
+		}
+		if data, err := os.ReadFile(filepath.Join(path, "package.json")); err == nil && json.Unmarshal(data, &pkg) == nil {
+			return constraintVersion(pkg.Engines.Node, 1)
+		}
+	case "Python":
+		if version := readVersionFile(filepath.Join(path, ".python-version")); version != "" {
+			return version
+		}
+		return readPyprojectPython(filepath.Join(path, "pyproject.toml"))
+	case "Ruby":
+		return readVersionFile(filepath.Join(path, ".ruby-version"))
+	case "C#":
+		if data, err := os.ReadFile(filepath.Join(path, detected.MainMarkerFile)); err == nil {
+			if match := targetFrameworkPattern.FindSubmatch(data); match != nil {
+				return string(match[1])
+			}
+		}
+	case "Elixir":
+		if data, err := os.ReadFile(filepath.Join(path, "mix.exs")); err == nil {
+			if match := mixElixirPattern.FindSubmatch(data); match != nil {
+				return constraintVersion(string(match[1]), 2)
+			}
+		}
+	case "PHP":
+		var composer struct {
+			Require map[string]string `json:"require"`
+		}
+		if data, err := os.ReadFile(filepath.Join(path, "composer.json")); err == nil && json.Unmarshal(data, &composer) == nil {
+			return constraintVersion(composer.Require["php"], 2)
+		}
+	}
+	return ""
+}
+
+// readGoDirective returns the version of the go directive of a go.mod or a go.work
+func readGoDirective(goModPath string) string {
+	file, err := os.Open(goModPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if version, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "go "); ok {
+			return strings.TrimSpace(version)
+		}
+	}
+	return ""
+}
+
+// readVersionFile returns the version written in a .nvmrc or a .python-version, empty for the
+// aliases ("lts/*", "system") which are not image tags
+func readVersionFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	version := strings.TrimPrefix(strings.TrimSpace(line), "v")
+	if version == "" || versionPattern.FindString(version) != version {
+		return ""
+	}
+	return version
+}
+
+// readPyprojectPython returns the python version of requires-python or of the poetry dependencies
+func readPyprojectPython(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if (section == "[project]" && key == "requires-python") || (section == "[tool.poetry.dependencies]" && key == "python") {
+			return constraintVersion(strings.Trim(strings.TrimSpace(value), `"'`), 2)
+		}
+	}
+	return ""
+}
+
+// constraintVersion returns the first version of a constraint, truncated to its first parts
+// (1 for "node:20", 2 for "python:3.11") since a lower bound is not an exact version
+func constraintVersion(constraint string, parts int) string {
+	version := versionPattern.FindString(constraint)
+	if version == "" {
+		return ""
+	}
+	split := strings.Split(version, ".")
+	if len(split) > parts {
+		split = split[:parts]
+	}
+	return strings.Join(split, ".")
+}
@@ -0,0 +1,50 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectEcosystem_LanguageVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		version string
+	}{
+		{"go.mod", map[string]string{"go.mod": "module example.com/app\n\ngo 1.22.4\n"}, "1.22.4"},
+		{"go.work", map[string]string{"go.work": "go 1.23\n\nuse ./api\n"}, "1.23"},
+		{"nvmrc", map[string]string{"package.json": `{"engines": {"node": ">=18"}}`, ".nvmrc": "v20.11.0\n"}, "20.11.0"},
+		{"nvmrc alias", map[string]string{"package.json": `{"engines": {"node": "^18.17.0"}}`, ".nvmrc": "lts/*\n"}, "18"},
+		{"engines", map[string]string{"package.json": `{"engines": {"node": "20.x"}}`}, "20"},
+		{"python-version", map[string]string{"requirements.txt": "flask\n", ".python-version": "3.12.1\n"}, "3.12.1"},
+		{"requires-python", map[string]string{"pyproject.toml": "[project]\nname = \"app\"\nrequires-python = \">=3.10\"\n"}, "3.10"},
+		{"poetry", map[string]string{"pyproject.toml": "[tool.poetry]\nname = \"app\"\n\n[tool.poetry.dependencies]\npython = \"^3.11.2\"\n"}, "3.11"},
+		{"none", map[string]string{"requirements.txt": "flask\n"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+			}
+			ecosystem, err := DetectEcosystem(dir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.version, ecosystem.LanguageVersion)
+		})
+	}
+}
+
+func TestRenderDockerfile_DetectedVersion(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0644))
+	ecosystem, err := DetectEcosystem(dir)
+	require.NoError(t, err)
+
+	content, err := RenderDockerfile(ecosystem, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, "FROM golang:1.22-alpine AS builder")
+}
@@ -0,0 +1,214 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Files of the development container of a project, in the order they are looked up
+var devcontainerFiles = []string{filepath.Join(".devcontainer", "devcontainer.json"), ".devcontainer.json"}
+
+// Languages whose build stage can run in the devcontainer image: they copy a self-contained binary
+// to the final stage. The dependencies of the interpreted languages are installed in paths of the
+// image and would not run in the final stage of the template.
+var devcontainerBuilderLanguages = map[string]bool{"Go": true, "C#": true}
+
+// Tags of the features and of the images which are not versions
+var devcontainerVersionKeywords = map[string]bool{"": true, "latest": true, "lts": true, "none": true, "os-provided": true, "current": true}
+
+var imageTagVersionPattern = regexp.MustCompile(`^v?\d+(\.\d+)*$`)
+
+// devcontainerConfig holds the fields of a devcontainer.json describing the tools of the project
+type devcontainerConfig struct {
+	Image    string                     `json:"image"`
+	Features map[string]json.RawMessage `json:"features"` // Options of each feature, or its version in the legacy syntax
+}
+
+// readDevcontainer reads the devcontainer.json of a project directory, nil if there is none. The
+// returned file is relative to the directory.
+func readDevcontainer(dir string) (*devcontainerConfig, string, error) {
+	for _, file := range devcontainerFiles {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, file, fmt.Errorf("cannot read '%s': %w", file, err)
+		}
+		var config devcontainerConfig
+		if err := json.Unmarshal(stripJSONComments(data), &config); err != nil {
+			return nil, file, fmt.Errorf("cannot parse '%s': %w", file, err)
+		}
+		return &config, file, nil
+	}
+	return nil, "", nil
+}
+
+// devcontainerToolNames returns the names of the images and of the features of a language
+func devcontainerToolNames(detected *DetectedEcosystem) []string {
+	switch detected.Language {
+	case "Go":
+		return []string{"go", "golang"}
+	case "JavaScript":
+		if detected.PackageManager == "deno" || detected.PackageManager == "bun" {
+			return []string{detected.PackageManager}
+		}
+		return []string{"node", "javascript-node", "typescript-node"}
+	case "C#":
+		return []string{"dotnet"}
+	}
+	return []string{strings.ToLower(detected.Language)}
+}
+
+// featureVersion returns the version option of the feature of one of the tools, empty if none
+func (c *devcontainerConfig) featureVersion(tools []string) string {
+	for id, raw := range c.Features {
+		name := path.Base(id)
+		if i := strings.IndexAny(name, ":@"); i >= 0 {
+			name = name[:i]
+		}
+		if !slices.Contains(tools, name) {
+			continue
+		}
+		var version string
+		if json.Unmarshal(raw, &version) != nil {
+			var options struct {
+				Version any `json:"version"`
+			}
+			if json.Unmarshal(raw, &options) != nil || options.Version == nil {
+				continue
+			}
+			version = fmt.Sprint(options.Version)
+		}
+		if !devcontainerVersionKeywords[strings.ToLower(version)] {
+			return version
+		}
+	}
+	return ""
+}
+
+// imageVersion returns the version of the tool in the tag of the image and whether the image is the
+// one of a tool. The tags of the devcontainers images start with the version of the image
+// ("mcr.microsoft.com/devcontainers/go:1-1.22-bookworm").
+func (c *devcontainerConfig) imageVersion(tools []string) (string, bool) {
+	image, _, _ := strings.Cut(c.Image, "@")
+	repository, tag := image, ""
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repository, tag = image[:i], image[i+1:]
+	}
+	if repository == "" || !slices.Contains(tools, path.Base(repository)) {
+		return "", false
+	}
+	var versions []string
+	for _, part := range strings.Split(tag, "-") {
+		if !imageTagVersionPattern.MatchString(part) {
+			break
+		}
+		versions = append(versions, strings.TrimPrefix(part, "v"))
+	}
+	switch {
+	case len(versions) == 0:
+		return "", true
+	case len(versions) > 1 && strings.Contains(repository, "/devcontainers/"):
+		return versions[1], true
+	}
+	return versions[0], true
+}
+
+// applyDevcontainer seeds the ecosystem with the devcontainer.json of the project: the version of the
+// tool of the language when the project files have none, and the image of the build stage
+func applyDevcontainer(path string, detected *DetectedEcosystem) {
+	detected.BuilderImage = ""
+	config, _, err := readDevcontainer(path)
+	if err != nil || config == nil {
+		return
+	}
+	tools := devcontainerToolNames(detected)
+	imageVersion, isToolImage := config.imageVersion(tools)
+	if detected.LanguageVersion == "" {
+		detected.LanguageVersion = config.featureVersion(tools)
+		if detected.LanguageVersion == "" {
+			detected.LanguageVersion = imageVersion
+		}
+	}
+	if isToolImage && devcontainerBuilderLanguages[detected.Language] && versionsAgree(detected.LanguageVersion, imageVersion) {
+		detected.BuilderImage = config.Image
+	}
+}
+
+// versionsAgree reports whether a version is a prefix of the other one ("1.22" and "1.22.3"), an
+// empty version agrees with any version
+func versionsAgree(a, b string) bool {
+	if a == "" || b == "" || a == b {
+		return true
+	}
+	return strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// builderStagePattern matches the FROM of the build stage of the templates
+var builderStagePattern = regexp.MustCompile(`(?m)^FROM \S+ AS builder$`)
+
+// useBuilderImage replaces the image of the build stage of a rendered Dockerfile
+func useBuilderImage(content, image string) string {
+	if image == "" {
+		return content
+	}
+	return builderStagePattern.ReplaceAllLiteralString(content, "FROM "+image+" AS builder")
+}
+
+// stripJSONComments removes the comments and the trailing commas of a JSON with comments, the
+// format of the devcontainer.json and deno.jsonc files
+func stripJSONComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+			continue
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+			continue
+		case c == '}' || c == ']':
+			// Drops the comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && (out[j] == ' ' || out[j] == '\t' || out[j] == '\n' || out[j] == '\r') {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package build
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	data := []byte(`{
+	// The image of the project
+	"image": "golang:1.22", /* inline */
+	"url": "http://example.com/*not a comment*/",
+	"features": {"a": [1, 2,],},
+}`)
+	var config map[string]any
+	require.NoError(t, json.Unmarshal(stripJSONComments(data), &config))
+	assert.Equal(t, "golang:1.22", config["image"])
+	assert.Equal(t, "http://example.com/*not a comment*/", config["url"])
+}
+
+func TestDevcontainerImageVersion(t *testing.T) {
+	goTools := devcontainerToolNames(&DetectedEcosystem{Language: "Go"})
+	for image, expected := range map[string]string{
+		"mcr.microsoft.com/devcontainers/go:1-1.22-bookworm": "1.22",
+		"mcr.microsoft.com/devcontainers/go:1.23":            "1.23",
+		"golang:1.21-alpine":                                 "1.21",
+		"golang":                                             "",
+		"registry.local:5000/golang:1.20@sha256:abcd":        "1.20",
+	} {
+		version, ok := (&devcontainerConfig{Image: image}).imageVersion(goTools)
+		assert.True(t, ok, image)
+		assert.Equal(t, expected, version, image)
+	}
+	_, ok := (&devcontainerConfig{Image: "mcr.microsoft.com/devcontainers/base:ubuntu"}).imageVersion(goTools)
+	assert.False(t, ok)
+}
+
+func TestDetectEcosystem_Devcontainer(t *testing.T) {
+	// The feature seeds the version of a project without one
+	dir := writeProjectFiles(t, map[string]string{
+		"package.json": `{"name": "web"}`,
+		".devcontainer/devcontainer.json": `{
+			"image": "mcr.microsoft.com/devcontainers/base:ubuntu",
+			// Node from a feature
+			"features": {"ghcr.io/devcontainers/features/node:1": {"version": "20"}},
+		}`,
+	})
+	detected, err := DetectEcosystem(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "20", detected.LanguageVersion)
+	assert.Empty(t, detected.BuilderImage)
+
+	// The Go image is used for the build stage
+	dir = writeProjectFiles(t, map[string]string{
+		"go.mod":             "module example.com/api\n\ngo 1.22.3\n",
+		".devcontainer.json": `{"image": "mcr.microsoft.com/devcontainers/go:1-1.22-bookworm"}`,
+	})
+	detected, err = DetectEcosystem(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "1.22.3", detected.LanguageVersion)
+	assert.Equal(t, "mcr.microsoft.com/devcontainers/go:1-1.22-bookworm", detected.BuilderImage)
+	content, err := RenderDockerfile(detected, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, "FROM mcr.microsoft.com/devcontainers/go:1-1.22-bookworm AS builder")
+	assert.Contains(t, content, "FROM alpine:latest AS final")
+
+	// An image older than the project is not used
+	dir = writeProjectFiles(t, map[string]string{
+		"go.mod":             "module example.com/api\n\ngo 1.23\n",
+		".devcontainer.json": `{"image": "golang:1.21"}`,
+	})
+	detected, err = DetectEcosystem(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "1.23", detected.LanguageVersion)
+	assert.Empty(t, detected.BuilderImage)
+
+	// An invalid file is reported and ignored
+	dir = writeProjectFiles(t, map[string]string{
+		"go.mod":             "module example.com/api\n",
+		".devcontainer.json": `{"image": `,
+	})
+	report, err := DetectEcosystemReport(dir, "")
+	require.NoError(t, err)
+	assert.Empty(t, report.Ecosystem.BuilderImage)
+	assert.Contains(t, report.Warnings[len(report.Warnings)-1], "devcontainer ignored")
+}
@@ -0,0 +1,340 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Name of the Dockerfile written in a codebase without one, next to the files of the codebase
+const generatedDockerfileName = "Dockerfile.generated"
+
+// DockerfileTemplateData holds the variables of the Dockerfile templates. The values come from the
+// defaults of the template, then from the detection, then from the dockerfile_template of the spec.
+type DockerfileTemplateData struct {
+	LanguageVersion string   `json:"language_version,omitempty" yaml:"language_version,omitempty"` // Tag of the language image ("1.22", "20", "3.12")
+	BinaryName      string   `json:"binary_name,omitempty" yaml:"binary_name,omitempty"`           // Binary built by the compiled languages
+	Entrypoint      string   `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`             // Script run by the interpreted languages
+	Port            int      `json:"port,omitempty" yaml:"port,omitempty"`                         // Exposed port
+	BuildCommand    string   `json:"build_command,omitempty" yaml:"build_command,omitempty"`       // Replaces the build command of the template
+	StartCommand    []string `json:"start_command,omitempty" yaml:"start_command,omitempty"`       // Replaces the CMD of the template
+	MainPackage     string   `json:"main_package,omitempty" yaml:"main_package,omitempty"`         // Go package of the binary ("./cmd/api"), "." by default
+	BuilderImage    string   `json:"builder_image,omitempty" yaml:"builder_image,omitempty"`       // Replaces the image of the build stage
+}
+
+// generatedDockerfile is a Dockerfile rendered for a codebase without one
+type generatedDockerfile struct {
+	Path      string
+	Content   string
+	Ecosystem *DetectedEcosystem
+	Data      DockerfileTemplateData // Values the template was rendered with, Data.Port is published in the run.yml
+	Report    *DetectionReport
+}
+
+// merge sets the non empty values of other
+func (d *DockerfileTemplateData) merge(other DockerfileTemplateData) {
+	if other.LanguageVersion != "" {
+		d.LanguageVersion = other.LanguageVersion
+	}
+	if other.BinaryName != "" {
+		d.BinaryName = other.BinaryName
+	}
+	if other.Entrypoint != "" {
+		d.Entrypoint = other.Entrypoint
+	}
+	if other.Port != 0 {
+		d.Port = other.Port
+	}
+	if other.BuildCommand != "" {
+		d.BuildCommand = other.BuildCommand
+	}
+	if len(other.StartCommand) > 0 {
+		d.StartCommand = other.StartCommand
+	}
+	if other.MainPackage != "" {
+		d.MainPackage = other.MainPackage
+	}
+	if other.BuilderImage != "" {
+		d.BuilderImage = other.BuilderImage
+	}
+}
+
+// DockerfileTemplateKey returns the key of the Dockerfile template of an ecosystem, looked up by
+// "Framework-PackageManager" and "Framework" when a framework is detected, then by
+// "Language-PackageManager" and "Language-Ecosystem"
+func DockerfileTemplateKey(ecosystem *DetectedEcosystem) (string, error) {
+	keys := append(frameworkTemplateKeys(ecosystem), ecosystem.Language+"-"+ecosystem.PackageManager, ecosystem.Language+"-"+ecosystem.Ecosystem)
+	for _, key := range keys {
+		if _, ok := DockerfileTemplates[key]; ok {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s (%s, %s)", ErrNoTemplateFound, ecosystem.Language, ecosystem.Ecosystem, ecosystem.PackageManager)
+}
+
+// RenderDockerfile renders the Dockerfile template of an ecosystem with the values detected in its
+// codebase, overridden by the non empty values of overrides
+func RenderDockerfile(ecosystem *DetectedEcosystem, overrides *DockerfileTemplateData) (string, error) {
+	key, data, err := resolveTemplateData(ecosystem, overrides)
+	if err != nil {
+		return "", err
+	}
+	return renderDockerfileTemplate(key, data)
+}
+
+// DockerfileTemplateKeys returns the keys of the Dockerfile templates, sorted
+func DockerfileTemplateKeys() []string {
+	keys := make([]string, 0, len(DockerfileTemplates))
+	for key := range DockerfileTemplates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FindDockerfileTemplate returns the key of the template named name, ignoring the case: the key
+// itself ("Go-go", "Django"), or its language ("go") or its package manager ("pnpm") when a single
+// template has it
+func FindDockerfileTemplate(name string) (string, error) {
+	var matches []string
+	for _, key := range DockerfileTemplateKeys() {
+		if strings.EqualFold(key, name) {
+			return key, nil
+		}
+		language, packageManager, _ := strings.Cut(key, "-")
+		if strings.EqualFold(language, name) || strings.EqualFold(packageManager, name) {
+			matches = append(matches, key)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("unknown Dockerfile template '%s' (available: %s)", name, strings.Join(DockerfileTemplateKeys(), ", "))
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("ambiguous Dockerfile template '%s', one of: %s", name, strings.Join(matches, ", "))
+}
+
+// DockerfileTemplateDefaults returns the values a template is rendered with when nothing overrides them
+func DockerfileTemplateDefaults(key string) DockerfileTemplateData {
+	return dockerfileTemplateDefaults[key]
+}
+
+// RenderDockerfileTemplate renders a template by its key, without detection: the defaults of the
+// template overridden by the non empty values of overrides
+func RenderDockerfileTemplate(key string, overrides *DockerfileTemplateData) (string, error) {
+	if _, ok := DockerfileTemplates[key]; !ok {
+		return "", fmt.Errorf("unknown Dockerfile template '%s'", key)
+	}
+	data := dockerfileTemplateDefaults[key]
+	if overrides != nil {
+		data.merge(*overrides)
+	}
+	return renderDockerfileTemplate(key, data)
+}
+
+// Set sets a value by the name of its field in the dockerfile_template of a spec ("language_version",
+// "port"...), "version" and "binary" being short for language_version and binary_name. start_command
+// is a JSON array or split on the spaces.
+func (d *DockerfileTemplateData) Set(name, value string) error {
+	switch name {
+	case "language_version", "version":
+		d.LanguageVersion = value
+	case "binary_name", "binary":
+		d.BinaryName = value
+	case "entrypoint":
+		d.Entrypoint = value
+	case "port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port '%s'", value)
+		}
+		d.Port = port
+	case "build_command":
+		d.BuildCommand = value
+	case "start_command":
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			if err := json.Unmarshal([]byte(value), &d.StartCommand); err != nil {
+				return fmt.Errorf("invalid start_command '%s': %w", value, err)
+			}
+			return nil
+		}
+		d.StartCommand = strings.Fields(value)
+	case "main_package":
+		d.MainPackage = value
+	case "builder_image":
+		d.BuilderImage = value
+	default:
+		return fmt.Errorf("unknown template value '%s' (available: language_version, binary_name, entrypoint, port, build_command, start_command, main_package, builder_image)", name)
+	}
+	return nil
+}
+
+// resolveTemplateData returns the template of an ecosystem and its values: the defaults of the
+// template, then the detected values, then the overrides
+func resolveTemplateData(ecosystem *DetectedEcosystem, overrides *DockerfileTemplateData) (string, DockerfileTemplateData, error) {
+	key, err := DockerfileTemplateKey(ecosystem)
+	if err != nil {
+		return "", DockerfileTemplateData{}, err
+	}
+	data := dockerfileTemplateDefaults[key]
+	data.merge(detectTemplateData(ecosystem))
+	if overrides != nil {
+		data.merge(*overrides)
+	}
+	return key, data, nil
+}
+
+// dockerfileTemplateFuncs are the functions of the Dockerfile templates, json writes the exec form of CMD
+var dockerfileTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// renderDockerfileTemplate executes the Dockerfile template of the key with data
+func renderDockerfileTemplate(key string, data DockerfileTemplateData) (string, error) {
+	tmpl, err := template.New(key).Funcs(dockerfileTemplateFuncs).Option("missingkey=error").Parse(DockerfileTemplates[key])
+	if err != nil {
+		return "", fmt.Errorf("cannot parse the Dockerfile template '%s': %w", key, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("cannot render the Dockerfile template '%s': %w", key, err)
+	}
+	return useBuilderImage(out.String(), data.BuilderImage), nil
+}
+
+// detectTemplateData returns the detected language version, reads the binary name and the
+// entrypoint in the marker files of the codebase, and infers the listen port and the start command
+func detectTemplateData(ecosystem *DetectedEcosystem) DockerfileTemplateData {
+	data := DockerfileTemplateData{LanguageVersion: ecosystem.LanguageVersion, BuilderImage: ecosystem.BuilderImage}
+	switch ecosystem.Language {
+	case "Go":
+		if module := readGoModule(filepath.Join(ecosystem.RootPath, "go.mod")); module != "" {
+			data.BinaryName = path.Base(module)
+		}
+		if mainPackage := detectGoMainPackage(ecosystem.RootPath); mainPackage != "" && mainPackage != "." {
+			data.MainPackage = mainPackage
+			data.BinaryName = path.Base(mainPackage)
+		}
+	case "Rust":
+		data.BinaryName = readCargoPackageName(filepath.Join(ecosystem.RootPath, "Cargo.toml"))
+	case "JavaScript":
+		if ecosystem.PackageManager == "deno" {
+			data.Entrypoint = firstExistingFile(ecosystem.RootPath, "main.ts", "mod.ts", "server.ts", "main.js")
+			break
+		}
+		var pkg struct {
+			Main   string `json:"main"`
+			Module string `json:"module"` // Entry point written by `bun init`
+		}
+		if content, err := os.ReadFile(filepath.Join(ecosystem.RootPath, "package.json")); err == nil && json.Unmarshal(content, &pkg) == nil {
+			data.Entrypoint = pkg.Main
+			if data.Entrypoint == "" && ecosystem.PackageManager == "bun" {
+				data.Entrypoint = pkg.Module
+			}
+		}
+	case "C#":
+		data.BinaryName = strings.TrimSuffix(ecosystem.MainMarkerFile, ".csproj")
+		if content, err := os.ReadFile(filepath.Join(ecosystem.RootPath, ecosystem.MainMarkerFile)); err == nil {
+			if match := assemblyNamePattern.FindSubmatch(content); match != nil {
+				data.BinaryName = strings.TrimSpace(string(match[1]))
+			}
+		}
+	case "Elixir":
+		if content, err := os.ReadFile(filepath.Join(ecosystem.RootPath, "mix.exs")); err == nil {
+			if match := mixAppPattern.FindSubmatch(content); match != nil {
+				data.BinaryName = string(match[1])
+			}
+		}
+	case "Python":
+		if ecosystem.Framework == FrameworkDjango {
+			data.Entrypoint = detectDjangoProject(ecosystem.RootPath)
+		}
+	}
+	data.Port = detectListenPort(ecosystem.RootPath)
+	data.StartCommand = detectStartCommand(ecosystem, data.Port)
+	return data
+}
+
+// firstExistingFile returns the first of the files found in dir, empty if none
+func firstExistingFile(dir string, names ...string) string {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// readGoModule returns the module path of a go.mod, empty if it cannot be read
+func readGoModule(goModPath string) string {
+	file, err := os.Open(goModPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`)
+		}
+	}
+	return ""
+}
+
+// readCargoPackageName returns the name of the [package] of a Cargo.toml, empty if it cannot be read
+func readCargoPackageName(cargoPath string) string {
+	file, err := os.Open(cargoPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	inPackage := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inPackage = line == "[package]"
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if inPackage && ok && strings.TrimSpace(key) == "name" {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return ""
+}
+
+// generateDockerfile detects the ecosystem of a codebase without Dockerfile and writes the Dockerfile
+// rendered from its template in the codebase directory. A non empty ecosystem replaces the detected one.
+func generateDockerfile(codebaseDir, ecosystemOverride string, overrides *DockerfileTemplateData) (*generatedDockerfile, error) {
+	report, err := DetectEcosystemReport(codebaseDir, ecosystemOverride)
+	if err != nil {
+		return nil, fmt.Errorf("cannot detect the ecosystem of '%s': %w", codebaseDir, err)
+	}
+	ecosystem := report.Ecosystem
+	key, data, err := resolveTemplateData(ecosystem, overrides)
+	if err != nil {
+		return nil, err
+	}
+	content, err := renderDockerfileTemplate(key, data)
+	if err != nil {
+		return nil, err
+	}
+	dockerfilePath := filepath.Join(codebaseDir, generatedDockerfileName)
+	if err := os.WriteFile(dockerfilePath, []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("cannot write the generated Dockerfile '%s': %w", dockerfilePath, err)
+	}
+	return &generatedDockerfile{Path: dockerfilePath, Content: content, Ecosystem: ecosystem, Data: data, Report: report}, nil
+}
@@ -0,0 +1,150 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerfileTemplateKey(t *testing.T) {
+	key, err := DockerfileTemplateKey(&DetectedEcosystem{Language: "Go", Ecosystem: "Modules", PackageManager: "go"})
+	require.NoError(t, err)
+	assert.Equal(t, "Go-go", key)
+
+	// Looked up by ecosystem when there is no template for the package manager
+	key, err = DockerfileTemplateKey(&DetectedEcosystem{Language: "Python", Ecosystem: "Pip", PackageManager: "pip"})
+	require.NoError(t, err)
+	assert.Equal(t, "Python-Pip", key)
+
+	_, err = DockerfileTemplateKey(&DetectedEcosystem{Language: "Swift", Ecosystem: "SwiftPM", PackageManager: "swift"})
+	assert.ErrorIs(t, err, ErrNoTemplateFound)
+}
+
+func TestFindDockerfileTemplate(t *testing.T) {
+	for name, want := range map[string]string{"go": "Go-go", "Django": "Django", "pnpm": "JavaScript-pnpm", "rust-CARGO": "Rust-cargo"} {
+		key, err := FindDockerfileTemplate(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, key, name)
+	}
+	_, err := FindDockerfileTemplate("javascript")
+	assert.ErrorContains(t, err, "ambiguous")
+	_, err = FindDockerfileTemplate("swift")
+	assert.ErrorContains(t, err, "unknown Dockerfile template")
+}
+
+func TestRenderDockerfileTemplate(t *testing.T) {
+	var overrides DockerfileTemplateData
+	require.NoError(t, overrides.Set("version", "1.22"))
+	require.NoError(t, overrides.Set("port", "9000"))
+	require.NoError(t, overrides.Set("start_command", `["./api", "--listen", ":9000"]`))
+	assert.Error(t, overrides.Set("port", "http"))
+	assert.Error(t, overrides.Set("colour", "blue"))
+
+	content, err := RenderDockerfileTemplate("Go-go", &overrides)
+	require.NoError(t, err)
+	assert.Contains(t, content, "FROM golang:1.22-alpine")
+	assert.Contains(t, content, "EXPOSE 9000")
+	assert.Contains(t, content, `CMD ["./api","--listen",":9000"]`)
+	assert.Contains(t, content, "-o /app/main", "the defaults fill the values not set")
+
+	require.NoError(t, overrides.Set("start_command", "node server.js"))
+	assert.Equal(t, []string{"node", "server.js"}, overrides.StartCommand)
+	_, err = RenderDockerfileTemplate("Swift", nil)
+	assert.Error(t, err)
+}
+
+func TestRenderDockerfile_AllTemplates(t *testing.T) {
+	for key := range DockerfileTemplates {
+		t.Run(key, func(t *testing.T) {
+			data, ok := dockerfileTemplateDefaults[key]
+			require.True(t, ok, "no defaults for the template")
+			content, err := renderDockerfileTemplate(key, data)
+			require.NoError(t, err)
+			assert.NotContains(t, content, "{{")
+			assert.Contains(t, content, "EXPOSE ")
+		})
+	}
+}
+
+func TestRenderDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Cargo.toml"), []byte("[package]\nname = \"shop-api\"\nversion = \"0.1.0\"\n\n[dependencies]\nname = \"other\"\n"), 0644))
+	ecosystem := &DetectedEcosystem{Language: "Rust", Ecosystem: "Cargo", PackageManager: "cargo", RootPath: dir}
+
+	content, err := RenderDockerfile(ecosystem, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, "FROM rust:1.70-slim AS builder")
+	assert.Contains(t, content, "COPY --from=builder /app/target/release/shop-api ./")
+	assert.Contains(t, content, `CMD ["./shop-api"]`)
+	assert.Contains(t, content, "EXPOSE 8000")
+
+	// The values of the spec override the detection and the defaults
+	content, err = RenderDockerfile(ecosystem, &DockerfileTemplateData{LanguageVersion: "1.78", Port: 9000, BuildCommand: "cargo build --release --bin shop-api"})
+	require.NoError(t, err)
+	assert.Contains(t, content, "FROM rust:1.78-slim AS builder")
+	assert.Contains(t, content, "EXPOSE 9000")
+	assert.Contains(t, content, "RUN cargo build --release --bin shop-api\n")
+	assert.Contains(t, content, `CMD ["./shop-api"]`)
+}
+
+func TestDetectTemplateData(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module github.com/acme/shop\n\ngo 1.22\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"main": "dist/server.js"}`), 0644))
+
+	assert.Equal(t, "shop", detectTemplateData(&DetectedEcosystem{Language: "Go", RootPath: dir}).BinaryName)
+	assert.Equal(t, "dist/server.js", detectTemplateData(&DetectedEcosystem{Language: "JavaScript", RootPath: dir}).Entrypoint)
+}
+
+func TestGenerateDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n"), 0644))
+
+	generated, err := generateDockerfile(dir, "", &DockerfileTemplateData{Port: 9090})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, generatedDockerfileName), generated.Path)
+	assert.Equal(t, "Go", generated.Ecosystem.Language)
+	assert.Equal(t, 9090, generated.Data.Port)
+	assert.Contains(t, generated.Content, "-o /app/app .")
+	assert.Contains(t, generated.Content, "EXPOSE 9090")
+	written, err := os.ReadFile(generated.Path)
+	require.NoError(t, err)
+	assert.Equal(t, generated.Content, string(written))
+
+	_, err = generateDockerfile(t.TempDir(), "", nil)
+	assert.ErrorIs(t, err, ErrNoEcosystemFound)
+}
+
+func TestRenderDockerfile_MoreEcosystems(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		key      string
+		contains []string
+	}{
+		{"gradle", map[string]string{"build.gradle": "plugins { id 'java' }\n"}, "Java-gradle", []string{"./gradlew build --no-daemon -x test"}},
+		{"dotnet", map[string]string{"Shop.Api.csproj": "<Project><PropertyGroup><TargetFramework>net9.0</TargetFramework></PropertyGroup></Project>"}, "C#-dotnet", []string{"FROM mcr.microsoft.com/dotnet/sdk:9.0 AS builder", `CMD ["dotnet", "Shop.Api.dll"]`}},
+		{"php", map[string]string{"composer.json": `{"require": {"php": "^8.2"}}`}, "PHP-composer", []string{"FROM php:8.2-apache AS final", "EXPOSE 80"}},
+		{"ruby", map[string]string{"Gemfile": "gem 'sinatra'\n", "config.ru": "run App\n"}, "Ruby-bundle", []string{`"rackup", "--host", "0.0.0.0", "--port", "9292"`}},
+		{"elixir", map[string]string{"mix.exs": "def project do\n  [app: :shop, version: \"0.1.0\", elixir: \"~> 1.15\"]\nend\n"}, "Elixir-mix", []string{"FROM elixir:1.15 AS builder", `CMD ["/app/bin/shop", "start"]`}},
+		{"deno", map[string]string{"deno.json": `{"tasks": {"start": "deno run -A server.ts"}}`, "server.ts": ""}, "JavaScript-deno", []string{"RUN deno cache server.ts", `CMD ["deno","task","start"]`}},
+		{"bun", map[string]string{"package.json": `{"module": "src/index.ts", "engines": {"node": ">=20"}}`, "bun.lockb": ""}, "JavaScript-bun", []string{"FROM oven/bun:1.1 AS builder", `CMD ["bun", "run", "src/index.ts"]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ecosystem, err := DetectEcosystem(writeProjectFiles(t, tt.files))
+			require.NoError(t, err)
+			key, err := DockerfileTemplateKey(ecosystem)
+			require.NoError(t, err)
+			assert.Equal(t, tt.key, key)
+			content, err := RenderDockerfile(ecosystem, nil)
+			require.NoError(t, err)
+			for _, s := range tt.contains {
+				assert.Contains(t, content, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package build
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+type backendContextKey struct{}
+
+// withBackend makes the images of the build running with ctx go to another backend than the one of the service
+func withBackend(ctx context.Context, backend ContainerBackend) context.Context {
+	return context.WithValue(ctx, backendContextKey{}, backend)
+}
+
+// backendFor returns the backend of the build running with ctx, the one of the service by default
+func (s *BuildService) backendFor(ctx context.Context) ContainerBackend {
+	if backend, ok := ctx.Value(backendContextKey{}).(ContainerBackend); ok {
+		return backend
+	}
+	return s.backend
+}
+
+// tlsFiles returns the CA, the certificate and the key of a docker host, the files given one by
+// one win over the ones of CertPath
+func (c DockerHostConfig) tlsFiles() (string, string, string) {
+	caCert, cert, key := c.CACert, c.Cert, c.Key
+	if c.CertPath != "" {
+		if caCert == "" {
+			caCert = filepath.Join(c.CertPath, "ca.pem")
+		}
+		if cert == "" {
+			cert = filepath.Join(c.CertPath, "cert.pem")
+		}
+		if key == "" {
+			key = filepath.Join(c.CertPath, "key.pem")
+		}
+	}
+	return caCert, cert, key
+}
+
+// validateDockerHost checks the docker host of a spec, nil is the daemon of the service
+func validateDockerHost(config *DockerHostConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.Host == "" {
+		return fmt.Errorf("the field 'host' is required in the docker_host")
+	}
+	if !strings.HasPrefix(config.Host, "tcp://") && !strings.HasPrefix(config.Host, "unix://") {
+		return fmt.Errorf("unsupported docker host '%s', expected tcp:// or unix://", config.Host)
+	}
+	caCert, cert, key := config.tlsFiles()
+	if (cert == "") != (key == "") {
+		return fmt.Errorf("the docker host '%s' needs both a client certificate and a key", config.Host)
+	}
+	if config.TLSVerify && caCert == "" {
+		return fmt.Errorf("the docker host '%s' needs a CA certificate to verify the daemon", config.Host)
+	}
+	return nil
+}
+
+// NewRemoteDockerBackend connects to a docker daemon of another machine, with mutual TLS when the
+// config has certificates. The CLI builds reach it with the same host and certificates.
+func NewRemoteDockerBackend(config DockerHostConfig) (ContainerBackend, error) {
+	if err := validateDockerHost(&config); err != nil {
+		return nil, err
+	}
+	opts := []client.Opt{client.WithAPIVersionNegotiation()}
+	var cliFlags []string
+	caCert, cert, key := config.tlsFiles()
+	if config.TLSVerify || cert != "" {
+		tlsConfig, err := dockerHostTLSConfig(config.TLSVerify, caCert, cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the TLS configuration of the docker host '%s': %w", config.Host, err)
+		}
+		opts = append(opts, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}))
+		cliFlags = append(cliFlags, "--tls")
+		if config.TLSVerify {
+			cliFlags = append(cliFlags, "--tlsverify", "--tlscacert", caCert)
+		}
+		if cert != "" {
+			cliFlags = append(cliFlags, "--tlscert", cert, "--tlskey", key)
+		}
+	}
+	// After the HTTP client, the host configures its transport
+	opts = append(opts, client.WithHost(config.Host))
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error during the Docker client initialization for '%s': %w", config.Host, err)
+	}
+	return &dockerBackend{Client: cli, cliFlags: cliFlags, remote: true}, nil
+}
+
+func dockerHostTLSConfig(verify bool, caCert, cert, key string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: !verify}
+	if verify {
+		data, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in '%s'", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cert != "" {
+		keyPair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{keyPair}
+	}
+	return tlsConfig, nil
+}
+
+// connectDockerHost opens the backend of the docker host of a spec and checks the daemon answers
+func connectDockerHost(ctx context.Context, config DockerHostConfig) (ContainerBackend, error) {
+	backend, err := NewRemoteDockerBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := backend.Ping(ctx); err != nil {
+		backend.Close()
+		return nil, fmt.Errorf("docker daemon not reachable at '%s': %w", config.Host, err)
+	}
+	return backend, nil
+}
@@ -0,0 +1,52 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDockerHost(t *testing.T) {
+	assert.NoError(t, validateDockerHost(nil))
+	assert.NoError(t, validateDockerHost(&DockerHostConfig{Host: "tcp://builder-1:2375"}))
+	assert.NoError(t, validateDockerHost(&DockerHostConfig{Host: "tcp://builder-1:2376", TLSVerify: true, CertPath: "/certs"}))
+
+	assert.Error(t, validateDockerHost(&DockerHostConfig{}))
+	assert.Error(t, validateDockerHost(&DockerHostConfig{Host: "ssh://builder-1"}))
+	assert.Error(t, validateDockerHost(&DockerHostConfig{Host: "tcp://builder-1:2376", TLSVerify: true}))
+	assert.Error(t, validateDockerHost(&DockerHostConfig{Host: "tcp://builder-1:2376", Cert: "/certs/cert.pem"}))
+}
+
+func TestDockerHostTLSFiles(t *testing.T) {
+	caCert, cert, key := DockerHostConfig{CertPath: "/certs", Key: "/keys/builder.pem"}.tlsFiles()
+	assert.Equal(t, "/certs/ca.pem", caCert)
+	assert.Equal(t, "/certs/cert.pem", cert)
+	assert.Equal(t, "/keys/builder.pem", key)
+}
+
+func TestNewRemoteDockerBackend(t *testing.T) {
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	backend, err := NewRemoteDockerBackend(DockerHostConfig{Host: "tcp://builder-1:2375"})
+	require.NoError(t, err)
+	cmd := backend.BuildCommand(context.Background(), []string{"build", "/ctx"}, "")
+	assert.Equal(t, []string{"docker", "build", "/ctx"}, cmd.Args)
+	assert.Contains(t, cmd.Env, "DOCKER_HOST=tcp://builder-1:2375")
+	assert.NotContains(t, cmd.Env, "DOCKER_TLS_VERIFY=1")
+
+	_, err = NewRemoteDockerBackend(DockerHostConfig{Host: "tcp://builder-1:2376", TLSVerify: true, CertPath: t.TempDir()})
+	assert.ErrorContains(t, err, "CA certificate")
+}
+
+func TestBackendFor(t *testing.T) {
+	local, err := NewDockerBackend()
+	require.NoError(t, err)
+	remote, err := NewRemoteDockerBackend(DockerHostConfig{Host: "tcp://builder-1:2375"})
+	require.NoError(t, err)
+
+	s := &BuildService{backend: local}
+	ctx := context.Background()
+	assert.Same(t, local, s.backendFor(ctx))
+	assert.Same(t, remote, s.backendFor(withBackend(ctx, remote)))
+}
@@ -0,0 +1,200 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits of the scan of the sources for the listen port
+const (
+	maxPortScanFiles    = 500
+	maxPortScanFileSize = 256 << 10
+)
+
+// Extensions of the files scanned for the listen port
+var portScanExts = map[string]bool{
+	".go": true, ".js": true, ".mjs": true, ".cjs": true, ".ts": true, ".py": true, ".rb": true,
+	".properties": true, ".yml": true, ".yaml": true,
+}
+
+// Port of a start command: "-p 5000", "--port=5000", "--bind 0.0.0.0:5000", "-b :5000"
+var commandPortPattern = regexp.MustCompile(`(?:-p|--port)[ =](\d{2,5})\b|(?:-b|--bind)[ =][\w.\[\]]*:(\d{2,5})\b`)
+
+// Listen port of the sources, the group 1 is the port
+var sourcePortPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`process\.env\.PORT\s*(?:\|\||\?\?)\s*["']?(\d{2,5})`),
+	regexp.MustCompile(`(?:getenv|environ\.get|ENV\.fetch)\(\s*["']PORT["']\s*,\s*["']?(\d{2,5})`),
+	regexp.MustCompile(`ENV\.fetch\(\s*["']PORT["']\s*\)\s*\{\s*(\d{2,5})`),
+	regexp.MustCompile(`(?:ListenAndServe|ListenAndServeTLS|\.Run|\.Listen|\.Start)\(\s*"[\w.]*:(\d{2,5})"`),
+	regexp.MustCompile(`Addr:\s*"[\w.]*:(\d{2,5})"`),
+	regexp.MustCompile(`\.listen\(\s*(\d{2,5})`),
+	regexp.MustCompile(`\.run\([^)]*port\s*=\s*(\d{2,5})`),
+	regexp.MustCompile(`server\.port\s*[=:]\s*(\d{2,5})`),
+}
+
+// goPackageMainPattern matches the package clause of a main package
+var goPackageMainPattern = regexp.MustCompile(`(?m)^package main\s*$`)
+
+// readProcfileWeb returns the command of the web process of the Procfile, empty if none
+func readProcfileWeb(path string) string {
+	file, err := os.Open(filepath.Join(path, "Procfile"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if command, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "web:"); ok {
+			return strings.TrimSpace(command)
+		}
+	}
+	return ""
+}
+
+// readPackageScripts returns the scripts of the package.json
+func readPackageScripts(path string) map[string]string {
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, err := os.ReadFile(filepath.Join(path, "package.json"))
+	if err != nil || json.Unmarshal(data, &pkg) != nil {
+		return nil
+	}
+	return pkg.Scripts
+}
+
+// commandPort returns the port given to a start command, 0 if none
+func commandPort(command string) int {
+	match := commandPortPattern.FindStringSubmatch(command)
+	if match == nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(match[1] + match[2])
+	return port
+}
+
+// detectListenPort infers the port the application listens on: from the web process of the Procfile,
+// the start script of package.json, then from the defaults of the PORT variable and the listen calls of
+// the sources. It returns 0 when nothing is found.
+func detectListenPort(path string) int {
+	if port := commandPort(readProcfileWeb(path)); port != 0 {
+		return port
+	}
+	if port := commandPort(readPackageScripts(path)["start"]); port != 0 {
+		return port
+	}
+
+	var files []string
+	filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if file != path && (strings.HasPrefix(d.Name(), ".") || skippedEcosystemDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(files) >= maxPortScanFiles {
+			return filepath.SkipAll
+		}
+		if portScanExts[filepath.Ext(file)] && !strings.HasSuffix(file, "_test.go") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	for _, file := range files {
+		if info, err := os.Stat(file); err != nil || info.Size() > maxPortScanFileSize {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, pattern := range sourcePortPatterns {
+			if match := pattern.FindSubmatch(data); match != nil {
+				if port, err := strconv.Atoi(string(match[1])); err == nil && port > 0 && port < 65536 {
+					return port
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// detectStartCommand infers the command starting the application: the web process of the Procfile,
+// with $PORT replaced by the port, or the start script of package.json or task of deno.json. It
+// returns nil to keep the command of the template.
+func detectStartCommand(ecosystem *DetectedEcosystem, port int) []string {
+	if command := readProcfileWeb(ecosystem.RootPath); command != "" {
+		if port != 0 {
+			p := strconv.Itoa(port)
+			command = strings.NewReplacer("${PORT}", p, "$PORT", p).Replace(command)
+		}
+		return []string{"sh", "-c", command}
+	}
+	// The standalone server of Next.js replaces `next start`
+	if ecosystem.Language != "JavaScript" || ecosystem.Framework == FrameworkNextJS {
+		return nil
+	}
+	switch ecosystem.PackageManager {
+	case "deno":
+		var config struct {
+			Tasks map[string]any `json:"tasks"`
+		}
+		data, err := os.ReadFile(filepath.Join(ecosystem.RootPath, ecosystem.MainMarkerFile))
+		if err == nil && json.Unmarshal(stripJSONComments(data), &config) == nil {
+			if _, ok := config.Tasks["start"]; ok {
+				return []string{"deno", "task", "start"}
+			}
+		}
+	case "bun":
+		if _, ok := readPackageScripts(ecosystem.RootPath)["start"]; ok {
+			return []string{"bun", "run", "start"}
+		}
+	default:
+		// npm is in the final image of the yarn and pnpm templates too
+		if _, ok := readPackageScripts(ecosystem.RootPath)["start"]; ok {
+			return []string{"npm", "start"}
+		}
+	}
+	return nil
+}
+
+// detectGoMainPackage returns the main package of a Go module: "." when the root is a main
+// package, else the first ./cmd/<name> with a main.go. It returns an empty string if none.
+func detectGoMainPackage(path string) string {
+	if goPackageIsMain(path) {
+		return "."
+	}
+	matches, _ := filepath.Glob(filepath.Join(path, "cmd", "*", "main.go"))
+	sort.Strings(matches)
+	for _, match := range matches {
+		if goPackageIsMain(filepath.Dir(match)) {
+			return "./cmd/" + filepath.Base(filepath.Dir(match))
+		}
+	}
+	return ""
+}
+
+// goPackageIsMain reports whether a Go file of the directory declares the main package
+func goPackageIsMain(dir string) bool {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		if data, err := os.ReadFile(file); err == nil && goPackageMainPattern.Match(data) {
+			return true
+		}
+	}
+	return false
+}
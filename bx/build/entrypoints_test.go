@@ -0,0 +1,81 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectListenPort(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		port  int
+	}{
+		{"procfile", map[string]string{"Procfile": "release: ./migrate\nweb: gunicorn app:app --bind 0.0.0.0:5001\n", "app.py": "app.run(port=5000)"}, 5001},
+		{"start script", map[string]string{"package.json": `{"scripts": {"start": "next start -p 4000"}}`}, 4000},
+		{"node env", map[string]string{"package.json": `{}`, "src/server.js": "app.listen(process.env.PORT || 3100)"}, 3100},
+		{"go", map[string]string{"go.mod": "module app\n", "main.go": "package main\nfunc main() { http.ListenAndServe(\":9000\", nil) }"}, 9000},
+		{"python getenv", map[string]string{"main.py": "port = int(os.environ.get('PORT', 8050))"}, 8050},
+		{"rails puma", map[string]string{"config/puma.rb": `port ENV.fetch("PORT") { 3001 }`}, 3001},
+		{"spring", map[string]string{"src/main/resources/application.properties": "server.port=8181\n"}, 8181},
+		{"dependencies ignored", map[string]string{"node_modules/x/index.js": "app.listen(1234)"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.port, detectListenPort(writeProjectFiles(t, tt.files)))
+		})
+	}
+}
+
+func TestDetectStartCommand(t *testing.T) {
+	dir := writeProjectFiles(t, map[string]string{"Procfile": "web: uvicorn main:app --port $PORT\n"})
+	assert.Equal(t, []string{"sh", "-c", "uvicorn main:app --port 8000"}, detectStartCommand(&DetectedEcosystem{Language: "Python", RootPath: dir}, 8000))
+
+	dir = writeProjectFiles(t, map[string]string{"package.json": `{"scripts": {"start": "node dist/index.js"}}`})
+	assert.Equal(t, []string{"npm", "start"}, detectStartCommand(&DetectedEcosystem{Language: "JavaScript", RootPath: dir}, 0))
+	assert.Nil(t, detectStartCommand(&DetectedEcosystem{Language: "JavaScript", Framework: FrameworkNextJS, RootPath: dir}, 0))
+}
+
+func TestDetectGoMainPackage(t *testing.T) {
+	dir := writeProjectFiles(t, map[string]string{
+		"go.mod":               "module example.com/shop\n",
+		"shop.go":              "package shop\n",
+		"cmd/api/main.go":      "package main\n",
+		"cmd/api/main_test.go": "package main_test\n",
+	})
+	assert.Equal(t, "./cmd/api", detectGoMainPackage(dir))
+
+	ecosystem, err := DetectEcosystem(dir)
+	require.NoError(t, err)
+	content, err := RenderDockerfile(ecosystem, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, "-o /app/api ./cmd/api")
+	assert.Contains(t, content, `CMD ["./api"]`)
+}
+
+func TestRenderDockerfile_DetectedPortAndCommand(t *testing.T) {
+	dir := writeProjectFiles(t, map[string]string{
+		"requirements.txt": "fastapi\n",
+		"Procfile":         "web: uvicorn main:app --host 0.0.0.0 --port 7000\n",
+	})
+	ecosystem, err := DetectEcosystem(dir)
+	require.NoError(t, err)
+	content, err := RenderDockerfile(ecosystem, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, "EXPOSE 7000")
+	assert.Contains(t, content, `CMD ["sh","-c","uvicorn main:app --host 0.0.0.0 --port 7000"]`)
+}
+
+func TestGenerateRunYAML_ExposedPort(t *testing.T) {
+	spec := &BuildSpec{Name: "my-app", Version: "1.0.0", RunConfigDef: RunConfigDef{Generate: true, ArtifactStorage: "docker"}}
+	result := &BuildResult{ImageIDs: map[string]string{"my-app": "sha256:abc"}, ExposedPort: 7000}
+	service, err := NewBuildService(t.TempDir(), true, nil)
+	require.NoError(t, err)
+
+	runYAML, err := service.generateRunYAML(context.Background(), spec, result, nil, map[string][]string{"my-app": {"my-app:1.0.0"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"7000:7000"}, runYAML.Services["my-app"].Ports)
+}
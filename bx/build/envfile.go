@@ -0,0 +1,177 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Mode of the env file holding the secret values, readable by its owner only
+const secretsEnvFileMode os.FileMode = 0600
+
+// splitSecretEnv moves the secret values out of the environment of the services: the variables
+// are listed by name in SecretEnv and their values are returned for the env file. A variable
+// overridden by the service with another value is left in its environment.
+func splitSecretEnv(runYAML *RunYAML, secrets map[string]string) map[string]string {
+	values := make(map[string]string)
+	for serviceName, service := range runYAML.Services {
+		for name, secret := range secrets {
+			if value, ok := service.Environment[name]; !ok || value != secret {
+				continue
+			}
+			delete(service.Environment, name)
+			service.SecretEnv = append(service.SecretEnv, name)
+			values[name] = secret
+		}
+		sort.Strings(service.SecretEnv)
+		runYAML.Services[serviceName] = service
+	}
+	return values
+}
+
+// writeSecretsEnvFile writes the secret values of the run.yml to <name>-<version>.env, referenced by
+// the env_file of the run.yml. On failure the secrets are left in the environment of the services.
+func (s *BuildService) writeSecretsEnvFile(spec *BuildSpec, runYAML *RunYAML, secrets map[string]string, outputBasePath string, result *BuildResult, events *eventStream) {
+	if len(secrets) == 0 {
+		return
+	}
+	// The environment of the services is shared with the runtime env, the split works on copies
+	split := &RunYAML{Services: make(map[string]RunService, len(runYAML.Services))}
+	for serviceName, service := range runYAML.Services {
+		environment := make(map[string]string, len(service.Environment))
+		for k, v := range service.Environment {
+			environment[k] = v
+		}
+		service.Environment = environment
+		service.SecretEnv = append([]string(nil), service.SecretEnv...)
+		split.Services[serviceName] = service
+	}
+	values := splitSecretEnv(split, secrets)
+	if len(values) == 0 {
+		return
+	}
+	data, err := godotenv.Marshal(values)
+	if err != nil {
+		events.Warnf("cannot encode the secrets env file: %v", err)
+		return
+	}
+
+	envFileName := fmt.Sprintf("%s-%s.env", spec.Name, spec.Version)
+	envFilePath := filepath.Join(outputBasePath, envFileName)
+	output := s.OutputOptions()
+	output.FileMode = secretsEnvFileMode
+	if err := output.WriteFile(envFilePath, []byte(data+"\n")); err != nil {
+		events.Warnf("cannot write the secrets env file '%s', the secrets stay in the run.yml: %v", envFilePath, err)
+		return
+	}
+	runYAML.Services = split.Services
+	runYAML.EnvFile = envFileName
+	result.EnvFilePath = envFilePath
+	events.Artifact(ArtifactEnvFile, "", envFilePath)
+	events.Logf("Wrote %d secret variables to %s", len(values), envFilePath)
+}
+
+// LoadEnvFile reads the env file of the run.yml, relative to baseDir, and sets the secret
+// variables of each service in its environment
+func (r *RunYAML) LoadEnvFile(baseDir string) error {
+	if r.EnvFile == "" {
+		for serviceName, service := range r.Services {
+			if len(service.SecretEnv) > 0 {
+				return fmt.Errorf("the service '%s' has secret variables but the run file has no env_file", serviceName)
+			}
+		}
+		return nil
+	}
+	path := r.EnvFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return fmt.Errorf("cannot read the env file '%s': %w", path, err)
+	}
+	for serviceName, service := range r.Services {
+		for _, name := range service.SecretEnv {
+			value, ok := values[name]
+			if !ok {
+				return fmt.Errorf("the variable '%s' of the service '%s' is missing from the env file '%s'", name, serviceName, path)
+			}
+			if service.Environment == nil {
+				service.Environment = make(map[string]string)
+			}
+			service.Environment[name] = value
+		}
+		r.Services[serviceName] = service
+	}
+	return nil
+}
+
+// EnvOverride is a variable set in the environment of a service of a run.yml when it is run,
+// in the environment of every service if Service is empty
+type EnvOverride struct {
+	Service string
+	Name    string
+	Value   string
+}
+
+// ParseEnvOverride parses an override written "<service>.<NAME>=<value>", or "<NAME>=<value>"
+// for every service
+func ParseEnvOverride(value string) (EnvOverride, error) {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return EnvOverride{}, fmt.Errorf("invalid variable '%s', expected [service.]NAME=value", value)
+	}
+	override := EnvOverride{Name: key, Value: val}
+	if service, name, ok := strings.Cut(key, "."); ok {
+		override.Service, override.Name = service, name
+	}
+	if override.Service == "" && strings.Contains(key, ".") || override.Name == "" {
+		return EnvOverride{}, fmt.Errorf("invalid variable '%s', expected [service.]NAME=value", value)
+	}
+	return override, nil
+}
+
+// ReadEnvOverrides reads a dotenv file as overrides of every service
+func ReadEnvOverrides(path string) ([]EnvOverride, error) {
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the env file '%s': %w", path, err)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	overrides := make([]EnvOverride, 0, len(names))
+	for _, name := range names {
+		overrides = append(overrides, EnvOverride{Name: name, Value: values[name]})
+	}
+	return overrides, nil
+}
+
+// ApplyEnvOverrides sets the variables in the environment of the services, the later overrides
+// winning over the earlier ones
+func (r *RunYAML) ApplyEnvOverrides(overrides []EnvOverride) error {
+	for _, override := range overrides {
+		if override.Service != "" {
+			if _, ok := r.Services[override.Service]; !ok {
+				return fmt.Errorf("cannot set '%s': no service '%s' in the run file", override.Name, override.Service)
+			}
+		}
+		for serviceName, service := range r.Services {
+			if override.Service != "" && override.Service != serviceName {
+				continue
+			}
+			if service.Environment == nil {
+				service.Environment = make(map[string]string)
+			}
+			service.Environment[override.Name] = override.Value
+			r.Services[serviceName] = service
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteSecretsEnvFile(t *testing.T) {
+	outputDir := t.TempDir()
+	runtimeEnv := map[string]string{"MODE": "prod", "DB_PASSWORD": "hunter2 \"quoted\"\nline"}
+	runYAML := &RunYAML{Services: map[string]RunService{
+		"api":    {Image: "api:1", Environment: runtimeEnv},
+		"worker": {Image: "worker:1", Environment: map[string]string{"MODE": "prod", "DB_PASSWORD": "other"}},
+	}}
+	secrets := map[string]string{"DB_PASSWORD": runtimeEnv["DB_PASSWORD"]}
+
+	s := &BuildService{}
+	result := &BuildResult{}
+	s.writeSecretsEnvFile(&BuildSpec{Name: "app", Version: "1.0"}, runYAML, secrets, outputDir, result, newEventStream(nil))
+
+	envFilePath := filepath.Join(outputDir, "app-1.0.env")
+	assert.Equal(t, envFilePath, result.EnvFilePath)
+	assert.Equal(t, "app-1.0.env", runYAML.EnvFile)
+	info, err := os.Stat(envFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, secretsEnvFileMode, info.Mode().Perm())
+
+	api := runYAML.Services["api"]
+	assert.Equal(t, map[string]string{"MODE": "prod"}, api.Environment)
+	assert.Equal(t, []string{"DB_PASSWORD"}, api.SecretEnv)
+	assert.Contains(t, runtimeEnv, "DB_PASSWORD") // The shared runtime env is not modified
+	// The service overriding the secret keeps its own value
+	assert.Equal(t, "other", runYAML.Services["worker"].Environment["DB_PASSWORD"])
+	assert.Empty(t, runYAML.Services["worker"].SecretEnv)
+
+	// The run.yml written without the secrets loads them back from the env file
+	data, err := yaml.Marshal(runYAML)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	var loaded RunYAML
+	require.NoError(t, yaml.Unmarshal(data, &loaded))
+	require.NoError(t, loaded.LoadEnvFile(outputDir))
+	assert.Equal(t, runtimeEnv, loaded.Services["api"].Environment)
+
+	require.NoError(t, os.WriteFile(envFilePath, []byte("OTHER=1\n"), 0600))
+	err = loaded.LoadEnvFile(outputDir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'DB_PASSWORD' of the service 'api' is missing")
+
+	loaded.EnvFile = ""
+	require.Error(t, loaded.LoadEnvFile(outputDir))
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env.local")
+	require.NoError(t, os.WriteFile(envFile, []byte("MODE=dev\nDEBUG=1\n"), 0644))
+	fromFile, err := ReadEnvOverrides(envFile)
+	require.NoError(t, err)
+	set, err := ParseEnvOverride("web.PORT=9000")
+	require.NoError(t, err)
+	assert.Equal(t, EnvOverride{Service: "web", Name: "PORT", Value: "9000"}, set)
+	all, err := ParseEnvOverride("DEBUG=a=b")
+	require.NoError(t, err)
+	assert.Equal(t, EnvOverride{Name: "DEBUG", Value: "a=b"}, all)
+	for _, invalid := range []string{"PORT", "=1", ".PORT=1", "web.=1"} {
+		_, err := ParseEnvOverride(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	runYAML := &RunYAML{Services: map[string]RunService{
+		"web":    {Image: "web:1", Environment: map[string]string{"MODE": "prod", "PORT": "80"}},
+		"worker": {Image: "worker:1"},
+	}}
+	require.NoError(t, runYAML.ApplyEnvOverrides(append(fromFile, set, all)))
+	assert.Equal(t, map[string]string{"MODE": "dev", "DEBUG": "a=b", "PORT": "9000"}, runYAML.Services["web"].Environment)
+	assert.Equal(t, map[string]string{"MODE": "dev", "DEBUG": "a=b"}, runYAML.Services["worker"].Environment)
+
+	err = runYAML.ApplyEnvOverrides([]EnvOverride{{Service: "db", Name: "PORT", Value: "1"}})
+	assert.ErrorContains(t, err, "no service 'db'")
+}
@@ -0,0 +1,104 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is the machine readable code of a build failure, set in BuildResult.ErrorCode
+// and in the status sent to the socket clients
+type ErrorCode string
+
+const (
+	CodeInvalidSpec      ErrorCode = "invalid_spec"
+	CodeSetup            ErrorCode = "setup"
+	CodeSecretFetch      ErrorCode = "secret_fetch"
+	CodeResourceDownload ErrorCode = "resource_download"
+	CodeCodebaseFetch    ErrorCode = "codebase_fetch"
+	CodeBuildStep        ErrorCode = "build_step"
+	CodeComposeFile      ErrorCode = "compose_file"
+	CodeDockerBuild      ErrorCode = "docker_build"
+	CodeImagePush        ErrorCode = "image_push"
+	CodeOutputUpload     ErrorCode = "output_upload"
+	CodeBuildInProgress  ErrorCode = "build_in_progress"
+	CodeInternal         ErrorCode = "internal"
+)
+
+// Build failures, matched with errors.Is on the errors returned by the builds
+var (
+	ErrInvalidSpec      = errors.New("invalid build spec")
+	ErrSetup            = errors.New("build setup failed")
+	ErrSecretFetch      = errors.New("secret fetch failed")
+	ErrResourceDownload = errors.New("resource download failed")
+	ErrCodebaseFetch    = errors.New("codebase fetch failed")
+	ErrBuildStep        = errors.New("build step failed")
+	ErrComposeFile      = errors.New("invalid compose file")
+	ErrDockerBuild      = errors.New("docker build failed")
+	ErrImagePush        = errors.New("image push failed")
+	ErrOutputUpload     = errors.New("output upload failed")
+	ErrBuildInProgress  = errors.New("build already in progress")
+	ErrInternal         = errors.New("internal build error")
+)
+
+var codeErrors = map[ErrorCode]error{
+	CodeInvalidSpec:      ErrInvalidSpec,
+	CodeSetup:            ErrSetup,
+	CodeSecretFetch:      ErrSecretFetch,
+	CodeResourceDownload: ErrResourceDownload,
+	CodeCodebaseFetch:    ErrCodebaseFetch,
+	CodeBuildStep:        ErrBuildStep,
+	CodeComposeFile:      ErrComposeFile,
+	CodeDockerBuild:      ErrDockerBuild,
+	CodeImagePush:        ErrImagePush,
+	CodeOutputUpload:     ErrOutputUpload,
+	CodeBuildInProgress:  ErrBuildInProgress,
+	CodeInternal:         ErrInternal,
+}
+
+// BuildError is a build failure with its code. It matches the Err* value of its code with
+// errors.Is and unwraps to its cause, if any.
+type BuildError struct {
+	Code    ErrorCode
+	Message string // Message of the failure, as set in BuildResult.ErrorMessage
+	Err     error  // Cause of the failure, nil when the message is enough
+}
+
+func newBuildError(code ErrorCode, err error) *BuildError {
+	return &BuildError{Code: code, Message: err.Error(), Err: err}
+}
+
+func (e *BuildError) Error() string {
+	return e.Message
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BuildError) Is(target error) bool {
+	return target != nil && codeErrors[e.Code] == target
+}
+
+// ErrorCode returns the code of the failure, for the socket status payloads
+func (e *BuildError) ErrorCode() string {
+	return string(e.Code)
+}
+
+// ErrorCodeOf returns the code of a build failure, empty if the error has none
+func ErrorCodeOf(err error) ErrorCode {
+	var buildErr *BuildError
+	if errors.As(err, &buildErr) {
+		return buildErr.Code
+	}
+	return ""
+}
+
+// failBuild records a failure in the result and returns the error of the build, which keeps
+// the historical "error during the run" message and wraps a *BuildError
+func failBuild(result *BuildResult, events *eventStream, code ErrorCode, errMsg string, cause error) (*BuildResult, error) {
+	result.Success = false
+	result.ErrorMessage = errMsg
+	result.ErrorCode = code
+	result.Logs = events.Render()
+	return result, fmt.Errorf("error during the run: \n %w", &BuildError{Code: code, Message: errMsg, Err: cause})
+}
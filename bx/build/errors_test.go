@@ -0,0 +1,49 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailBuild(t *testing.T) {
+	result := &BuildResult{Success: true}
+	events := newEventStream(nil)
+	events.Log("fetching")
+
+	cause := fmt.Errorf("clone failed: %w", context.DeadlineExceeded)
+	_, err := failBuild(result, events, CodeCodebaseFetch, "error during the codebase fetching 'api': "+cause.Error(), cause)
+	require.Error(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, CodeCodebaseFetch, result.ErrorCode)
+	assert.Equal(t, "fetching\n", result.Logs)
+	assert.Equal(t, "error during the run: \n error during the codebase fetching 'api': clone failed: context deadline exceeded", err.Error())
+
+	assert.ErrorIs(t, err, ErrCodebaseFetch)
+	assert.NotErrorIs(t, err, ErrDockerBuild)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, CodeCodebaseFetch, ErrorCodeOf(err))
+
+	var buildErr *BuildError
+	require.True(t, errors.As(err, &buildErr))
+	assert.Equal(t, "codebase_fetch", buildErr.ErrorCode())
+}
+
+func TestErrorCodeOf(t *testing.T) {
+	assert.Empty(t, ErrorCodeOf(errors.New("boom")))
+	assert.Empty(t, ErrorCodeOf(nil))
+	err := fmt.Errorf("queued build: %w", newBuildError(CodeImagePush, errors.New("denied")))
+	assert.Equal(t, CodeImagePush, ErrorCodeOf(err))
+	assert.ErrorIs(t, err, ErrImagePush)
+	assert.Equal(t, "queued build: denied", err.Error())
+}
+
+func TestErrorCodesHaveErrors(t *testing.T) {
+	for code, err := range codeErrors {
+		assert.ErrorIs(t, &BuildError{Code: code}, err, code)
+	}
+}
@@ -0,0 +1,236 @@
+package build
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BuildEventType is the kind of a BuildEvent
+type BuildEventType string
+
+const (
+	EventPhaseStarted  BuildEventType = "phase_started"
+	EventPhaseFinished BuildEventType = "phase_finished"
+	EventLog           BuildEventType = "log"
+	EventWarning       BuildEventType = "warning"
+	EventArtifact      BuildEventType = "artifact"
+	EventProgress      BuildEventType = "progress"
+)
+
+// Phases of a build reported by the phase events
+const (
+	PhaseSetup     = "setup"
+	PhaseEnv       = "env"
+	PhaseSecrets   = "secrets"
+	PhaseResources = "resources"
+	PhaseCodebases = "codebases"
+	PhaseSteps     = "build_steps"
+	PhaseBuild     = "build"
+	PhaseTag       = "tag"
+	PhaseOutput    = "output"
+	PhaseRunConfig = "run_config"
+)
+
+// Kinds of the artifacts reported by the artifact events
+const (
+	ArtifactImage       = "image"        // Ref is the image ID
+	ArtifactImageTar    = "image_tar"    // Ref is the local path of the saved image
+	ArtifactPushedImage = "pushed_image" // Ref is the pushed tag
+	ArtifactB2Object    = "b2_object"    // Ref is the B2 object name
+	ArtifactRunConfig   = "run_config"   // Ref is the path of the generated *.run.yml
+	ArtifactManifest    = "manifest"     // Ref is the path of the artifact manifest
+	ArtifactComposeFile = "compose_file" // Ref is the path of the generated docker-compose file
+	ArtifactEnvFile     = "env_file"     // Ref is the path of the env file holding the secrets of the run.yml
+	ArtifactStepBinary  = "step_binary"  // Ref is the path of the binary in the step image
+)
+
+// BuildEvent is a typed entry of the event stream of a build
+type BuildEvent struct {
+	Type     BuildEventType `json:"type"`
+	Time     time.Time      `json:"time"`
+	Phase    string         `json:"phase,omitempty"`    // Current phase of the build
+	Message  string         `json:"message,omitempty"`  // Log or warning line, without the trailing new line
+	Service  string         `json:"service,omitempty"`  // Service or step concerned by an artifact or a log line
+	Artifact string         `json:"artifact,omitempty"` // Artifact kind (Artifact* constants)
+	Ref      string         `json:"ref,omitempty"`      // Artifact reference (image ID, path, object name...)
+	Error    string         `json:"error,omitempty"`    // Set on a phase_finished event when the phase failed
+	Duration float64        `json:"duration,omitempty"` // Phase duration in seconds, on phase_finished events
+	Current  int            `json:"current,omitempty"`  // 1 based index of the codebase, step or service in the phase, on progress events
+	Total    int            `json:"total,omitempty"`    // Codebases, steps or services of the phase, on progress events
+}
+
+// eventStream dispatches the events of one build to the caller channel
+// and keeps the flattened logs for BuildResult.Logs. The messages are redacted before being emitted.
+type eventStream struct {
+	mu           sync.Mutex
+	ch           chan<- BuildEvent
+	redactor     *Redactor
+	logs         strings.Builder
+	phase        string
+	phaseStarted time.Time
+	timings      map[string]float64
+	logger       *slog.Logger // Receives every event as a log record when set
+}
+
+func newEventStream(ch chan<- BuildEvent) *eventStream {
+	return &eventStream{ch: ch, redactor: NewRedactor()}
+}
+
+func (e *eventStream) emit(event BuildEvent) {
+	event.Time = time.Now()
+	if event.Phase == "" {
+		event.Phase = e.phase
+	}
+	event.Message = e.redactor.Redact(event.Message)
+	event.Error = e.redactor.Redact(event.Error)
+	e.render(event)
+	if e.logger != nil {
+		logEvent(e.logger, event)
+	}
+	if e.ch != nil {
+		e.ch <- event
+	}
+}
+
+// render flattens an event in the compatibility logs. The phase events are not rendered
+// so the logs keep their historical format.
+func (e *eventStream) render(event BuildEvent) {
+	switch event.Type {
+	case EventLog:
+		e.logs.WriteString(event.Message + "\n")
+	case EventWarning:
+		e.logs.WriteString("Warning: " + event.Message + "\n")
+	}
+}
+
+// StartPhase finishes the current phase successfully and starts a new one
+func (e *eventStream) StartPhase(phase string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.finishPhaseLocked("")
+	e.phase = phase
+	e.phaseStarted = time.Now()
+	e.emit(BuildEvent{Type: EventPhaseStarted})
+}
+
+// finishPhaseLocked emits the end of the current phase, if any. e.mu must be held.
+func (e *eventStream) finishPhaseLocked(errMsg string) {
+	if e.phase == "" {
+		return
+	}
+	duration := time.Since(e.phaseStarted)
+	e.emit(BuildEvent{Type: EventPhaseFinished, Error: errMsg, Duration: duration.Seconds()})
+	e.addTimingLocked(e.phase, duration)
+	e.phase = ""
+}
+
+// timingKey returns the key of the timing of a step or a service within a phase ("build/api")
+func timingKey(phase, name string) string {
+	return phase + "/" + name
+}
+
+// Time adds a duration to a timing, a phase or a part of it (see timingKey)
+func (e *eventStream) Time(key string, duration time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.addTimingLocked(key, duration)
+}
+
+func (e *eventStream) addTimingLocked(key string, duration time.Duration) {
+	if e.timings == nil {
+		e.timings = make(map[string]float64)
+	}
+	e.timings[key] += duration.Seconds()
+}
+
+// Timings returns the durations in seconds recorded so far, by phase and by timing key
+func (e *eventStream) Timings() map[string]float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	timings := make(map[string]float64, len(e.timings))
+	for key, seconds := range e.timings {
+		timings[key] = seconds
+	}
+	return timings
+}
+
+// Close finishes the current phase, with the error message if the build failed, and closes the channel
+func (e *eventStream) Close(errMsg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.finishPhaseLocked(errMsg)
+	if e.ch != nil {
+		close(e.ch)
+		e.ch = nil
+	}
+}
+
+// SetLogger writes the next events to a structured logger
+func (e *eventStream) SetLogger(logger *slog.Logger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logger = logger
+}
+
+// AddSecret masks a secret value in the next events
+func (e *eventStream) AddSecret(value string) {
+	e.redactor.AddSecret(value)
+}
+
+// Log emits a log line
+func (e *eventStream) Log(message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(BuildEvent{Type: EventLog, Message: strings.TrimSuffix(message, "\n")})
+}
+
+// Logf emits a formatted log line
+func (e *eventStream) Logf(format string, args ...any) {
+	e.Log(fmt.Sprintf(format, args...))
+}
+
+// ServiceLogf emits a formatted log line about a service or a step
+func (e *eventStream) ServiceLogf(service, format string, args ...any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(BuildEvent{Type: EventLog, Service: service, Message: strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")})
+}
+
+// Warnf emits a formatted warning
+func (e *eventStream) Warnf(format string, args ...any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(BuildEvent{Type: EventWarning, Message: strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")})
+}
+
+// Progressf reports the codebase, step or service the phase is processing, the current one of total
+func (e *eventStream) Progressf(service string, current, total int, format string, args ...any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(BuildEvent{Type: EventProgress, Service: service, Current: current, Total: total, Message: fmt.Sprintf(format, args...)})
+}
+
+// Artifact reports an artifact produced by the build
+func (e *eventStream) Artifact(kind, service, ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(BuildEvent{Type: EventArtifact, Artifact: kind, Service: service, Ref: ref})
+}
+
+// Write implements io.Writer, each line written becomes a log event
+func (e *eventStream) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		e.Log(line)
+	}
+	return len(p), nil
+}
+
+// Render returns the logs flattened from the events so far
+func (e *eventStream) Render() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.logs.String()
+}
@@ -0,0 +1,85 @@
+package build
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/socket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream_ChannelAndRenderer(t *testing.T) {
+	ch := make(chan BuildEvent, 32)
+	events := newEventStream(ch)
+
+	events.StartPhase(PhaseEnv)
+	events.Logf("Loaded %d environment variables", 2)
+	events.Warnf("cannot read env file '%s'", ".env")
+	events.StartPhase(PhaseBuild)
+	events.Write([]byte("Step 1/2\nStep 2/2\n"))
+	events.Artifact(ArtifactImage, "api", "sha256:abc")
+	events.Close("boom")
+
+	var received []BuildEvent
+	for event := range ch {
+		received = append(received, event)
+	}
+	types := make([]BuildEventType, 0, len(received))
+	for _, event := range received {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []BuildEventType{
+		EventPhaseStarted, EventLog, EventWarning, EventPhaseFinished,
+		EventPhaseStarted, EventLog, EventLog, EventArtifact, EventPhaseFinished,
+	}, types)
+
+	require.Len(t, received, 9)
+	assert.Equal(t, PhaseEnv, received[1].Phase)
+	assert.Empty(t, received[3].Error)
+	assert.Equal(t, "api", received[7].Service)
+	assert.Equal(t, PhaseBuild, received[8].Phase)
+	assert.Equal(t, "boom", received[8].Error)
+
+	assert.Equal(t, "Loaded 2 environment variables\nWarning: cannot read env file '.env'\nStep 1/2\nStep 2/2\n", events.Render())
+}
+
+func TestEventStream_Timings(t *testing.T) {
+	events := newEventStream(nil)
+	events.StartPhase(PhaseSteps)
+	events.Time(timingKey(PhaseSteps, "compile"), 1500*time.Millisecond)
+	events.Time(timingKey(PhaseSteps, "compile"), 500*time.Millisecond)
+	events.StartPhase(PhaseBuild)
+	events.Close("")
+
+	timings := events.Timings()
+	assert.Equal(t, 2.0, timings["build_steps/compile"])
+	assert.Contains(t, timings, PhaseSteps)
+	assert.Contains(t, timings, PhaseBuild)
+	assert.NotContains(t, timings, PhaseOutput)
+
+	// The returned map is a copy
+	timings[PhaseBuild] = 42
+	assert.NotEqual(t, 42.0, events.Timings()[PhaseBuild])
+}
+
+func TestEventStream_Progress(t *testing.T) {
+	ch := make(chan BuildEvent, 8)
+	events := newEventStream(ch)
+	events.StartPhase(PhaseCodebases)
+	events.Progressf("api", 2, 3, "Fetching codebase '%s'", "api")
+	events.Close("")
+
+	var progress []socket.BuildProgressPayload
+	for event := range ch {
+		if payload, ok := progressFromEvent("build-1", event); ok {
+			progress = append(progress, payload)
+		}
+	}
+	assert.Equal(t, []socket.BuildProgressPayload{
+		{BuildID: "build-1", Phase: PhaseCodebases},
+		{BuildID: "build-1", Phase: PhaseCodebases, Service: "api", Current: 2, Total: 3, Message: "Fetching codebase 'api'"},
+	}, progress)
+	assert.Empty(t, events.Render(), "the progress events are not rendered in the logs")
+}
@@ -0,0 +1,99 @@
+package build
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Frameworks found by the detection, they select the specialized Dockerfile templates
+const (
+	FrameworkNextJS     = "Next.js"
+	FrameworkDjango     = "Django"
+	FrameworkSpringBoot = "Spring Boot"
+	FrameworkRails      = "Rails"
+)
+
+// railsGemPattern matches the rails gem of a Gemfile, not the gems named rails-*
+var railsGemPattern = regexp.MustCompile(`(?m)^\s*gem\s+["']rails["']`)
+
+// detectFramework looks for a framework in the dependencies and the config files of the project
+func detectFramework(path string, detected *DetectedEcosystem) string {
+	switch detected.Language {
+	case "JavaScript":
+		var pkg struct {
+			Dependencies map[string]string `json:"dependencies"`
+		}
+		if data, err := os.ReadFile(filepath.Join(path, "package.json")); err == nil && json.Unmarshal(data, &pkg) == nil {
+			if _, ok := pkg.Dependencies["next"]; ok {
+				return FrameworkNextJS
+			}
+		}
+	case "Python":
+		if _, err := os.Stat(filepath.Join(path, "manage.py")); err == nil {
+			return FrameworkDjango
+		}
+		if fileContains(filepath.Join(path, detected.MainMarkerFile), "django") {
+			return FrameworkDjango
+		}
+	case "Java":
+		if fileContains(filepath.Join(path, detected.MainMarkerFile), "spring-boot", "org.springframework.boot") {
+			return FrameworkSpringBoot
+		}
+	case "Ruby":
+		if data, err := os.ReadFile(filepath.Join(path, "Gemfile")); err == nil && railsGemPattern.Match(data) {
+			return FrameworkRails
+		}
+	}
+	return ""
+}
+
+// frameworkTemplateKeys returns the keys of the templates of the framework of an ecosystem, by priority
+func frameworkTemplateKeys(ecosystem *DetectedEcosystem) []string {
+	switch ecosystem.Framework {
+	case "":
+		return nil
+	case FrameworkNextJS:
+		// The template runs the standalone server, which must be enabled in next.config
+		if !nextStandaloneOutput(ecosystem.RootPath) {
+			return nil
+		}
+	}
+	return []string{ecosystem.Framework + "-" + ecosystem.PackageManager, ecosystem.Framework}
+}
+
+// nextStandaloneOutput reports whether the next.config of the project sets `output: "standalone"`
+func nextStandaloneOutput(path string) bool {
+	for _, name := range []string{"next.config.js", "next.config.mjs", "next.config.ts"} {
+		if fileContains(filepath.Join(path, name), `"standalone"`, `'standalone'`) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectDjangoProject returns the module of the WSGI application of a Django project, the directory of its wsgi.py
+func detectDjangoProject(path string) string {
+	matches, _ := filepath.Glob(filepath.Join(path, "*", "wsgi.py"))
+	if len(matches) == 0 {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(matches[0])) + ".wsgi:application"
+}
+
+// fileContains reports whether the file contains one of the values, case insensitively
+func fileContains(path string, values ...string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	content := strings.ToLower(string(data))
+	for _, value := range values {
+		if strings.Contains(content, strings.ToLower(value)) {
+			return true
+		}
+	}
+	return false
+}
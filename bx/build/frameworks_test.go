@@ -0,0 +1,84 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeProjectFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestDetectFramework(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		framework string
+	}{
+		{"next", map[string]string{"package.json": `{"dependencies": {"next": "14.2.0", "react": "18.3.0"}}`}, FrameworkNextJS},
+		{"node", map[string]string{"package.json": `{"dependencies": {"express": "4.19.0"}}`}, ""},
+		{"django manage.py", map[string]string{"requirements.txt": "gunicorn\n", "manage.py": ""}, FrameworkDjango},
+		{"django requirements", map[string]string{"requirements.txt": "Django==5.0\n"}, FrameworkDjango},
+		{"spring maven", map[string]string{"pom.xml": "<parent><groupId>org.springframework.boot</groupId><artifactId>spring-boot-starter-parent</artifactId></parent>"}, FrameworkSpringBoot},
+		{"spring gradle", map[string]string{"build.gradle": "plugins { id 'org.springframework.boot' version '3.2.0' }"}, FrameworkSpringBoot},
+		{"rails", map[string]string{"Gemfile": "source \"https://rubygems.org\"\ngem \"rails\", \"~> 7.1\"\n"}, FrameworkRails},
+		{"rails plugin only", map[string]string{"Gemfile": "gem 'rails-html-sanitizer'\n"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ecosystem, err := DetectEcosystem(writeProjectFiles(t, tt.files))
+			require.NoError(t, err)
+			assert.Equal(t, tt.framework, ecosystem.Framework)
+		})
+	}
+}
+
+func TestRenderDockerfile_Frameworks(t *testing.T) {
+	// Next.js uses the standalone template only when the output is enabled
+	dir := writeProjectFiles(t, map[string]string{
+		"package.json":   `{"dependencies": {"next": "14.2.0"}}`,
+		"next.config.js": "module.exports = { output: 'standalone' }\n",
+	})
+	ecosystem, err := DetectEcosystem(dir)
+	require.NoError(t, err)
+	key, err := DockerfileTemplateKey(ecosystem)
+	require.NoError(t, err)
+	assert.Equal(t, "Next.js", key)
+	require.NoError(t, os.Remove(filepath.Join(dir, "next.config.js")))
+	key, err = DockerfileTemplateKey(ecosystem)
+	require.NoError(t, err)
+	assert.Equal(t, "JavaScript-npm", key)
+
+	dir = writeProjectFiles(t, map[string]string{"requirements.txt": "django\n", "manage.py": "", "shop/wsgi.py": ""})
+	ecosystem, err = DetectEcosystem(dir)
+	require.NoError(t, err)
+	content, err := RenderDockerfile(ecosystem, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, `CMD ["gunicorn", "--bind", "0.0.0.0:8000", "shop.wsgi:application"]`)
+
+	dir = writeProjectFiles(t, map[string]string{"build.gradle.kts": `plugins { id("org.springframework.boot") version "3.2.0" }`})
+	ecosystem, err = DetectEcosystem(dir)
+	require.NoError(t, err)
+	key, err = DockerfileTemplateKey(ecosystem)
+	require.NoError(t, err)
+	assert.Equal(t, "Spring Boot-gradle", key)
+
+	dir = writeProjectFiles(t, map[string]string{"Gemfile": "gem 'rails'\n", ".ruby-version": "3.2.2\n"})
+	ecosystem, err = DetectEcosystem(dir)
+	require.NoError(t, err)
+	content, err = RenderDockerfile(ecosystem, nil)
+	require.NoError(t, err)
+	assert.Contains(t, content, "FROM ruby:3.2.2-slim AS builder")
+	assert.Contains(t, content, `CMD ["./bin/rails", "server", "-b", "0.0.0.0", "-p", "3000"]`)
+}
@@ -0,0 +1,311 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+)
+
+// GCPolicy selects the work dirs and the images removed by CollectGarbage. The limits set to zero
+// are disabled, the oldest resources are removed first to get under the size limits.
+type GCPolicy struct {
+	MaxAge          time.Duration // Resources older than this are removed
+	MaxWorkDirBytes int64         // Total size of the build dirs kept in the working dir
+	MaxImageBytes   int64         // Total size of the images built by bx kept in the daemon
+	DryRun          bool          // Report what would be removed without removing it
+}
+
+// CollectedResource is a build dir or an image removed by the garbage collection
+type CollectedResource struct {
+	Kind    ResourceKind `json:"kind"`
+	ID      string       `json:"id"`
+	Size    int64        `json:"size"`
+	Created time.Time    `json:"created"`
+	Error   string       `json:"error,omitempty"` // Set when the removal failed, an image used by a container for instance
+}
+
+// GCReport lists what CollectGarbage removed
+type GCReport struct {
+	Removed    []CollectedResource `json:"removed"`
+	Failed     []CollectedResource `json:"failed"`
+	FreedBytes int64               `json:"freed_bytes"`
+}
+
+// CollectGarbage removes the old build dirs of the working dir and the old images built by bx.
+// The resources of the running builds and of the interrupted ones (left to RecoverOrphans) are kept.
+func (s *BuildService) CollectGarbage(ctx context.Context, policy GCPolicy) (*GCReport, error) {
+	report := &GCReport{}
+	if err := s.collectWorkDirs(policy, report); err != nil {
+		return report, err
+	}
+	if err := s.collectImages(ctx, policy, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// CollectWorkDirs removes the old build dirs of the working dir only, the images are kept
+func (s *BuildService) CollectWorkDirs(policy GCPolicy) (*GCReport, error) {
+	report := &GCReport{}
+	return report, s.collectWorkDirs(policy, report)
+}
+
+// Prefix of the working dirs created in the temp dir by the in-memory services
+const tempWorkDirPrefix = "buildservice-work-"
+
+// CollectTempWorkDirs removes the working dirs of the in-memory services left in the temp dir by the
+// interrupted processes, those whose content was not modified for longer than policy.MaxAge, all of them
+// if zero. The age is the one of the latest change in the whole tree: the top directory only changes
+// when its direct entries do, not while a build of another process writes in its build dir. The size
+// limits are ignored.
+func CollectTempWorkDirs(policy GCPolicy) (*GCReport, error) {
+	report := &GCReport{}
+	tempDir := os.TempDir()
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return report, fmt.Errorf("cannot read the temp dir '%s': %w", tempDir, err)
+	}
+	var dirs []CollectedResource
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempWorkDirPrefix) {
+			continue
+		}
+		path := filepath.Join(tempDir, entry.Name())
+		size, modified := dirStats(path)
+		if modified.IsZero() {
+			continue // Removed meanwhile
+		}
+		dirs = append(dirs, CollectedResource{Kind: ResourceDir, ID: path, Size: size, Created: modified})
+	}
+	for _, dir := range dirs {
+		if policy.MaxAge > 0 && time.Since(dir.Created) <= policy.MaxAge {
+			continue
+		}
+		if !policy.DryRun {
+			if err := os.RemoveAll(dir.ID); err != nil {
+				dir.Error = err.Error()
+				report.Failed = append(report.Failed, dir)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, dir)
+		report.FreedBytes += dir.Size
+	}
+	return report, nil
+}
+
+// CollectStepImages removes the temporary images of the build steps (<name>-<version>-step-<step>)
+// left by the interrupted builds, those created for longer than policy.MaxAge, all of them if zero.
+// The images of the running builds are kept.
+func (s *BuildService) CollectStepImages(ctx context.Context, policy GCPolicy) (*GCReport, error) {
+	report := &GCReport{}
+	active, err := s.activeBuilds()
+	if err != nil {
+		return report, err
+	}
+	summaries, err := s.backendFor(ctx).ImageList(ctx, image.ListOptions{Filters: filters.NewArgs(filters.Arg("label", LabelBuild))})
+	if err != nil {
+		return report, fmt.Errorf("cannot list the images built by bx: %w", err)
+	}
+	for _, summary := range summaries {
+		if active[summary.Labels[LabelBuild]] || !slices.ContainsFunc(summary.RepoTags, isStepImageTag) {
+			continue
+		}
+		img := CollectedResource{Kind: ResourceImage, ID: summary.ID, Size: summary.Size, Created: time.Unix(summary.Created, 0)}
+		if policy.MaxAge > 0 && time.Since(img.Created) <= policy.MaxAge {
+			continue
+		}
+		if !policy.DryRun {
+			_, err := s.backendFor(ctx).ImageRemove(ctx, img.ID, image.RemoveOptions{Force: true, PruneChildren: true})
+			if err != nil && !errdefs.IsNotFound(err) {
+				img.Error = err.Error()
+				report.Failed = append(report.Failed, img)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, img)
+		report.FreedBytes += img.Size
+	}
+	return report, nil
+}
+
+// isStepImageTag tells whether a tag is the temporary tag of a build step image
+func isStepImageTag(tag string) bool {
+	repository, _, _ := strings.Cut(tag, ":")
+	return strings.Contains(repository, "-step-") && !strings.Contains(repository, "/")
+}
+
+// activeBuilds returns the IDs of the builds with a journal: running or interrupted
+func (s *BuildService) activeBuilds() (map[string]bool, error) {
+	active := make(map[string]bool)
+	if s.workDir == "" {
+		return active, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(s.workDir, journalDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return active, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the journal directory: %w", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".jsonl") {
+			active[strings.TrimSuffix(entry.Name(), ".jsonl")] = true
+		}
+	}
+	return active, nil
+}
+
+func (s *BuildService) collectWorkDirs(policy GCPolicy, report *GCReport) error {
+	active, err := s.activeBuilds()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.workDir)
+	if err != nil {
+		return fmt.Errorf("cannot read the working dir '%s': %w", s.workDir, err)
+	}
+
+	var dirs []CollectedResource
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || active[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed meanwhile
+		}
+		path := filepath.Join(s.workDir, entry.Name())
+		dirs = append(dirs, CollectedResource{Kind: ResourceDir, ID: path, Size: dirSize(path), Created: info.ModTime()})
+	}
+
+	for _, dir := range selectGarbage(dirs, policy.MaxAge, policy.MaxWorkDirBytes) {
+		if !policy.DryRun {
+			if err := os.RemoveAll(dir.ID); err != nil {
+				dir.Error = err.Error()
+				report.Failed = append(report.Failed, dir)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, dir)
+		report.FreedBytes += dir.Size
+	}
+	return nil
+}
+
+func (s *BuildService) collectImages(ctx context.Context, policy GCPolicy, report *GCReport) error {
+	if policy.MaxAge == 0 && policy.MaxImageBytes == 0 {
+		return nil
+	}
+	active, err := s.activeBuilds()
+	if err != nil {
+		return err
+	}
+	summaries, err := s.backendFor(ctx).ImageList(ctx, image.ListOptions{Filters: filters.NewArgs(filters.Arg("label", LabelBuild))})
+	if err != nil {
+		return fmt.Errorf("cannot list the images built by bx: %w", err)
+	}
+
+	var images []CollectedResource
+	for _, summary := range summaries {
+		if active[summary.Labels[LabelBuild]] {
+			continue
+		}
+		images = append(images, CollectedResource{Kind: ResourceImage, ID: summary.ID, Size: summary.Size, Created: time.Unix(summary.Created, 0)})
+	}
+
+	for _, img := range selectGarbage(images, policy.MaxAge, policy.MaxImageBytes) {
+		if !policy.DryRun {
+			// Not forced: the images used by a container are kept
+			_, err := s.backendFor(ctx).ImageRemove(ctx, img.ID, image.RemoveOptions{PruneChildren: true})
+			if err != nil && !errdefs.IsNotFound(err) {
+				img.Error = err.Error()
+				report.Failed = append(report.Failed, img)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, img)
+		report.FreedBytes += img.Size
+	}
+	return nil
+}
+
+// selectGarbage returns the resources older than maxAge, then the oldest ones until the total
+// size of the others is under maxBytes
+func selectGarbage(resources []CollectedResource, maxAge time.Duration, maxBytes int64) []CollectedResource {
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Created.Before(resources[j].Created) })
+	var total int64
+	for _, resource := range resources {
+		total += resource.Size
+	}
+
+	var garbage []CollectedResource
+	for _, resource := range resources {
+		expired := maxAge > 0 && time.Since(resource.Created) > maxAge
+		oversized := maxBytes > 0 && total > maxBytes
+		if !expired && !oversized {
+			break // Sorted by age: the next ones are younger and the size is under the limit
+		}
+		garbage = append(garbage, resource)
+		total -= resource.Size
+	}
+	return garbage
+}
+
+// dirSize returns the size of the regular files under a directory
+func dirSize(path string) int64 {
+	size, _ := dirStats(path)
+	return size
+}
+
+// dirStats returns the size of the regular files under a directory and the latest modification time
+// of the directory and of its content, zero when it cannot be read
+func dirStats(path string) (int64, time.Time) {
+	var size int64
+	var modified time.Time
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			size += info.Size()
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+	return size, modified
+}
+
+// pruneStepImages removes the temporary images of the build steps once the build ended, the
+// binaries they produced were already extracted
+func (s *BuildService) pruneStepImages(ctx context.Context, tags []string, events *eventStream) {
+	journal := journalFrom(ctx)
+	for _, tag := range tags {
+		deleted, err := s.backendFor(ctx).ImageRemove(ctx, tag, image.RemoveOptions{PruneChildren: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			events.Warnf("cannot remove the step image '%s': %v", tag, err)
+			continue
+		}
+		for _, item := range deleted {
+			journal.Release(ResourceImage, strings.TrimPrefix(item.Deleted, "sha256:"))
+		}
+		events.Logf("Removed the step image %s", tag)
+	}
+}
@@ -0,0 +1,88 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectWorkDirs(t *testing.T) {
+	workDir := t.TempDir()
+	s := &BuildService{workDir: workDir}
+	now := time.Now()
+	newBuildDir := func(name string, size int, age time.Duration) string {
+		dir := filepath.Join(workDir, name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "image.tar"), make([]byte, size), 0644))
+		require.NoError(t, os.Chtimes(dir, now.Add(-age), now.Add(-age)))
+		return dir
+	}
+	expired := newBuildDir("app-1.0.0-1", 10, 72*time.Hour)
+	oldest := newBuildDir("app-1.0.0-2", 100, 10*time.Hour)
+	recent := newBuildDir("app-1.0.0-3", 100, time.Hour)
+	running := newBuildDir("app-1.0.0-4", 500, 96*time.Hour)
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, journalDirName), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, journalDirName, "app-1.0.0-4.jsonl"), nil, 0644))
+
+	policy := GCPolicy{MaxAge: 48 * time.Hour, MaxWorkDirBytes: 150, DryRun: true}
+	// The images need a docker daemon, only the work dirs are collected here
+	report := &GCReport{}
+	require.NoError(t, s.collectWorkDirs(policy, report))
+	var removed []string
+	for _, resource := range report.Removed {
+		removed = append(removed, resource.ID)
+	}
+	assert.Equal(t, []string{expired, oldest}, removed)
+	assert.Equal(t, int64(110), report.FreedBytes)
+	assert.DirExists(t, expired)
+
+	policy.DryRun = false
+	require.NoError(t, s.collectWorkDirs(policy, &GCReport{}))
+	assert.NoDirExists(t, expired)
+	assert.NoDirExists(t, oldest)
+	assert.DirExists(t, recent)
+	assert.DirExists(t, running)
+	assert.DirExists(t, filepath.Join(workDir, journalDirName))
+}
+
+func TestIsStepImageTag(t *testing.T) {
+	assert.True(t, isStepImageTag("app-1.0.0-step-compile:latest"))
+	assert.False(t, isStepImageTag("app:1.0.0"))
+	assert.False(t, isStepImageTag("ghcr.io/acme/build-step-runner:latest"))
+}
+
+func TestCollectTempWorkDirs(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	now := time.Now()
+	old := filepath.Join(tempDir, tempWorkDirPrefix+"1")
+	recent := filepath.Join(tempDir, tempWorkDirPrefix+"2")
+	other := filepath.Join(tempDir, "other")
+	for _, dir := range []string{old, recent, other} {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(old, "image.tar"), make([]byte, 10), 0644))
+	// A build of another process writes in the build dir, the top directory is not modified
+	require.NoError(t, os.MkdirAll(filepath.Join(recent, "build-1"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(recent, "build-1", "Dockerfile"), nil, 0644))
+	for _, path := range []string{filepath.Join(old, "image.tar"), old, other, filepath.Join(recent, "build-1"), recent} {
+		require.NoError(t, os.Chtimes(path, now.Add(-2*time.Hour), now.Add(-2*time.Hour)))
+	}
+
+	report, err := CollectTempWorkDirs(GCPolicy{MaxAge: time.Hour, DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, report.Removed, 1)
+	assert.Equal(t, old, report.Removed[0].ID)
+	assert.Equal(t, int64(10), report.FreedBytes)
+	assert.DirExists(t, old)
+
+	_, err = CollectTempWorkDirs(GCPolicy{MaxAge: time.Hour})
+	require.NoError(t, err)
+	assert.NoDirExists(t, old)
+	assert.DirExists(t, recent)
+	assert.DirExists(t, other)
+}
@@ -0,0 +1,305 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of the nodes of a SpecGraph
+const (
+	GraphCodebase = "codebase"
+	GraphResource = "resource"
+	GraphStep     = "step"
+	GraphService  = "service"
+	GraphImage    = "image"
+)
+
+// GraphNode is a codebase, a resource, a build step, a compose service or the image of a spec
+type GraphNode struct {
+	ID     string `json:"id"` // "<kind>:<name>"
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"` // Source of a codebase, URL of a resource, image of a service...
+}
+
+// GraphEdge goes from a node to the node needing it
+type GraphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"` // "source", "binary", "context", "depends_on"...
+}
+
+// SpecGraph is the dependency graph of a spec: the codebases and the resources feed the build steps,
+// the compose services and the image, the steps pass their binaries to the next ones and the
+// services start after their depends_on.
+type SpecGraph struct {
+	Name     string      `json:"name"`
+	Version  string      `json:"version"`
+	Nodes    []GraphNode `json:"nodes"`
+	Edges    []GraphEdge `json:"edges"`
+	Warnings []string    `json:"warnings,omitempty"` // Parts of the graph which could not be resolved
+}
+
+// NewSpecGraph returns the graph of a spec. The compose file is read from its codebase when the codebase
+// is local, the relative local sources being resolved against baseDir (the working directory if empty).
+func NewSpecGraph(spec *BuildSpec, baseDir string) *SpecGraph {
+	g := &SpecGraph{Name: spec.Name, Version: spec.Version, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, codebase := range spec.Codebases {
+		detail := codebase.SourceType
+		if codebase.Source != "" {
+			detail += " " + codebase.Source
+		}
+		if codebase.Branch != "" {
+			detail += "@" + codebase.Branch
+		}
+		g.addNode(GraphCodebase, codebase.Name, detail)
+	}
+	for _, resource := range spec.Resources {
+		id := g.addNode(GraphResource, resource.TargetPath, resource.URL)
+		if codebase, _, ok := codebaseOfBuildPath(spec, resource.TargetPath); ok {
+			g.addEdge(id, graphID(GraphCodebase, codebase.Name), "resource")
+		}
+	}
+
+	for _, step := range spec.BuildSteps {
+		id := g.addNode(GraphStep, step.Name, step.OutputsBinaryPath)
+		if step.CodebaseName != "" {
+			g.addEdge(graphID(GraphCodebase, step.CodebaseName), id, "source")
+		}
+		if step.UseBinaryFromStep != "" {
+			g.addEdge(graphID(GraphStep, step.UseBinaryFromStep), id, "binary "+step.BinaryTargetPath)
+		}
+	}
+
+	if spec.BuildConfig.ComposeFile != "" {
+		g.addComposeServices(spec, baseDir)
+		return g.check()
+	}
+	image := spec.Name + ":" + spec.Version
+	if len(spec.BuildConfig.Tags) > 0 {
+		image = strings.Join(spec.BuildConfig.Tags, ", ")
+	}
+	id := g.addNode(GraphImage, spec.Name, image)
+	contextPath := ""
+	if dockerfile := spec.BuildConfig.Dockerfile; dockerfile != "" && !strings.Contains(dockerfile, "\n") {
+		contextPath = filepath.Dir(dockerfile)
+	}
+	if codebase, _, ok := codebaseOfBuildPath(spec, contextPath); ok {
+		g.addEdge(graphID(GraphCodebase, codebase.Name), id, "context")
+	} else if len(spec.Codebases) > 0 {
+		// The Dockerfile of the build root, or else the one of the first codebase
+		g.addEdge(graphID(GraphCodebase, spec.Codebases[0].Name), id, "context")
+	}
+	return g.check()
+}
+
+// addComposeServices adds the services of the compose file, built from their codebase
+func (g *SpecGraph) addComposeServices(spec *BuildSpec, baseDir string) {
+	composePath := spec.BuildConfig.ComposeFile
+	codebase, rel, ok := codebaseOfBuildPath(spec, composePath)
+	if !ok {
+		g.warnf("the compose file '%s' is not in a codebase, its services are not shown", composePath)
+		return
+	}
+	if codebase.SourceType != "local" {
+		g.warnf("the compose file '%s' is in the %s codebase '%s', its services are not shown", composePath, codebase.SourceType, codebase.Name)
+		return
+	}
+	source := codebase.Source
+	if !filepath.IsAbs(source) && baseDir != "" {
+		source = filepath.Join(baseDir, source)
+	}
+	data, err := os.ReadFile(filepath.Join(source, rel))
+	if err != nil {
+		g.warnf("cannot read the compose file '%s': %v", composePath, err)
+		return
+	}
+	project, err := LoadComposeFile(data)
+	if err != nil {
+		g.warnf("cannot load the compose file '%s': %v", composePath, err)
+		return
+	}
+
+	names := make([]string, 0, len(project.Services))
+	for name := range project.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		service := project.Services[name]
+		id := g.addNode(GraphService, name, service.Image)
+		if service.Build == nil {
+			continue
+		}
+		contextPath := filepath.Join(filepath.Dir(composePath), service.Build.Context)
+		if codebase, _, ok := codebaseOfBuildPath(spec, contextPath); ok {
+			g.addEdge(graphID(GraphCodebase, codebase.Name), id, "context")
+		} else {
+			g.warnf("the build context '%s' of the service '%s' is not in a codebase", service.Build.Context, name)
+		}
+	}
+	for _, name := range names {
+		for _, dependency := range project.Services[name].DependsOn {
+			label := "depends_on"
+			if dependency.Condition != "" && dependency.Condition != ConditionServiceStarted {
+				label += " " + dependency.Condition
+			}
+			g.addEdge(graphID(GraphService, dependency.Service), graphID(GraphService, name), label)
+		}
+	}
+}
+
+// codebaseOfBuildPath returns the codebase holding a path of the build directory, with the path
+// relative to the codebase. The codebases are in <target_in_host> or else in <name>.
+func codebaseOfBuildPath(spec *BuildSpec, path string) (CodebaseConfig, string, bool) {
+	path = filepath.Clean(path)
+	var best CodebaseConfig
+	bestDir, found := "", false
+	for _, codebase := range spec.Codebases {
+		dir := filepath.Clean(codebase.Name)
+		if codebase.TargetInHost != "" {
+			dir = filepath.Clean(codebase.TargetInHost)
+		}
+		if dir != "." && path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue
+		}
+		if !found || len(dir) > len(bestDir) {
+			best, bestDir, found = codebase, dir, true
+		}
+	}
+	if !found {
+		return CodebaseConfig{}, "", false
+	}
+	rel, _ := filepath.Rel(bestDir, path)
+	return best, rel, true
+}
+
+func graphID(kind, name string) string {
+	return kind + ":" + name
+}
+
+func (g *SpecGraph) addNode(kind, name, detail string) string {
+	id := graphID(kind, name)
+	g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: kind, Name: name, Detail: detail})
+	return id
+}
+
+func (g *SpecGraph) addEdge(from, to, label string) {
+	g.Edges = append(g.Edges, GraphEdge{From: from, To: to, Label: strings.TrimSpace(label)})
+}
+
+func (g *SpecGraph) warnf(format string, args ...any) {
+	g.Warnings = append(g.Warnings, fmt.Sprintf(format, args...))
+}
+
+// check drops the edges whose ends are unknown, reported as warnings
+func (g *SpecGraph) check() *SpecGraph {
+	known := make(map[string]bool, len(g.Nodes))
+	for _, node := range g.Nodes {
+		known[node.ID] = true
+	}
+	edges := g.Edges[:0]
+	for _, edge := range g.Edges {
+		for _, end := range []string{edge.From, edge.To} {
+			if !known[end] {
+				g.warnf("unknown %s referenced (%s)", strings.Replace(end, ":", " '", 1)+"'", edge.Label)
+			}
+		}
+		if known[edge.From] && known[edge.To] {
+			edges = append(edges, edge)
+		}
+	}
+	g.Edges = edges
+	return g
+}
+
+// Tree draws the graph as a tree from the nodes needing nothing. A node reached again is written
+// with "(see above)" instead of its subtree.
+func (g *SpecGraph) Tree() string {
+	nodes := make(map[string]GraphNode, len(g.Nodes))
+	children := make(map[string][]GraphEdge)
+	hasParent := make(map[string]bool)
+	for _, node := range g.Nodes {
+		nodes[node.ID] = node
+	}
+	for _, edge := range g.Edges {
+		children[edge.From] = append(children[edge.From], edge)
+		hasParent[edge.To] = true
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", g.Name, g.Version)
+	var roots []GraphEdge
+	for _, node := range g.Nodes {
+		if !hasParent[node.ID] {
+			roots = append(roots, GraphEdge{To: node.ID})
+		}
+	}
+	printed := make(map[string]bool)
+	var walk func(edges []GraphEdge, indent string)
+	walk = func(edges []GraphEdge, indent string) {
+		for i, edge := range edges {
+			branch, next := "├── ", "│   "
+			if i == len(edges)-1 {
+				branch, next = "└── ", "    "
+			}
+			line := nodes[edge.To].label()
+			if edge.Label != "" {
+				line += " [" + edge.Label + "]"
+			}
+			if printed[edge.To] {
+				fmt.Fprintf(&b, "%s%s%s (see above)\n", indent, branch, line)
+				continue
+			}
+			printed[edge.To] = true
+			fmt.Fprintf(&b, "%s%s%s\n", indent, branch, line)
+			walk(children[edge.To], indent+next)
+		}
+	}
+	walk(roots, "")
+	// The nodes of a cycle have parents only, they are written from their first node
+	for _, node := range g.Nodes {
+		if !printed[node.ID] {
+			walk([]GraphEdge{{To: node.ID}}, "")
+		}
+	}
+	return b.String()
+}
+
+func (n GraphNode) label() string {
+	if n.Detail == "" {
+		return n.Kind + " " + n.Name
+	}
+	return fmt.Sprintf("%s %s (%s)", n.Kind, n.Name, n.Detail)
+}
+
+// Shapes of the node kinds in the DOT output
+var graphShapes = map[string]string{
+	GraphCodebase: "folder",
+	GraphResource: "note",
+	GraphStep:     "box",
+	GraphService:  "component",
+	GraphImage:    "box3d",
+}
+
+// DOT writes the graph in the Graphviz language, rendered with `dot -Tsvg`
+func (g *SpecGraph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", g.Name+" "+g.Version)
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes {
+		label := node.Kind + "\n" + node.Name
+		if node.Detail != "" {
+			label += "\n" + node.Detail
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, shape=%s];\n", node.ID, label, graphShapes[node.Kind])
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.From, edge.To, edge.Label)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
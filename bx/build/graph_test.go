@@ -0,0 +1,92 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSpecGraph_Steps(t *testing.T) {
+	spec := &BuildSpec{
+		Name:    "cli",
+		Version: "1.0",
+		Codebases: []CodebaseConfig{
+			{Name: "tool", SourceType: "git", Source: "https://example.com/tool.git", Branch: "main"},
+			{Name: "app", SourceType: "local", Source: "./app"},
+		},
+		Resources: []ResourceConfig{{URL: "https://example.com/data.tgz", TargetPath: "app/data"}},
+		BuildSteps: []BuildStep{
+			{Name: "compile", CodebaseName: "tool", OutputsBinaryPath: "/out/tool"},
+			{Name: "package", CodebaseName: "app", UseBinaryFromStep: "compile", BinaryTargetPath: "bin/tool"},
+			{Name: "broken", UseBinaryFromStep: "missing"},
+		},
+		BuildConfig: BuildConfig{Dockerfile: "app/Dockerfile"},
+	}
+	g := NewSpecGraph(spec, "")
+
+	assert.Contains(t, g.Edges, GraphEdge{From: "codebase:tool", To: "step:compile", Label: "source"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "step:compile", To: "step:package", Label: "binary bin/tool"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "resource:app/data", To: "codebase:app", Label: "resource"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "codebase:app", To: "image:cli", Label: "context"})
+	assert.Equal(t, []string{"unknown step 'missing' referenced (binary)"}, g.Warnings)
+
+	tree := g.Tree()
+	assert.Contains(t, tree, "cli 1.0\n")
+	assert.Contains(t, tree, "├── codebase tool (git https://example.com/tool.git@main)\n│   └── step compile (/out/tool) [source]\n│       └── step package [binary bin/tool]\n")
+	assert.Contains(t, tree, "step package [source] (see above)")
+
+	dot := g.DOT()
+	assert.Contains(t, dot, "digraph \"cli 1.0\" {")
+	assert.Contains(t, dot, "\"step:compile\" -> \"step:package\" [label=\"binary bin/tool\"];")
+	assert.Contains(t, dot, "\"codebase:app\" [label=\"codebase\\napp\\nlocal ./app\", shape=folder];")
+}
+
+func TestNewSpecGraph_Compose(t *testing.T) {
+	base := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "stack"), 0755))
+	compose := `
+services:
+  api:
+    build: ../api
+    depends_on:
+      db:
+        condition: service_healthy
+  web:
+    build:
+      context: ./web
+    depends_on: [api]
+  db:
+    image: postgres:16
+`
+	require.NoError(t, os.WriteFile(filepath.Join(base, "stack", "compose.yml"), []byte(compose), 0644))
+	spec := &BuildSpec{
+		Name:    "shop",
+		Version: "2.0",
+		Codebases: []CodebaseConfig{
+			{Name: "stack", SourceType: "local", Source: "stack", TargetInHost: "deploy"},
+			{Name: "api", SourceType: "git", Source: "https://example.com/api.git"},
+			{Name: "web", SourceType: "git", Source: "https://example.com/web.git", TargetInHost: "deploy/web"},
+		},
+		BuildConfig: BuildConfig{ComposeFile: "deploy/compose.yml"},
+	}
+	g := NewSpecGraph(spec, base)
+
+	assert.Empty(t, g.Warnings)
+	assert.Contains(t, g.Nodes, GraphNode{ID: "service:db", Kind: GraphService, Name: "db", Detail: "postgres:16"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "codebase:api", To: "service:api", Label: "context"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "codebase:web", To: "service:web", Label: "context"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "service:db", To: "service:api", Label: "depends_on service_healthy"})
+	assert.Contains(t, g.Edges, GraphEdge{From: "service:api", To: "service:web", Label: "depends_on"})
+	for _, node := range g.Nodes {
+		assert.NotEqual(t, GraphImage, node.Kind)
+	}
+
+	// The compose file of a remote codebase is not read
+	spec.Codebases[0].SourceType = "git"
+	g = NewSpecGraph(spec, base)
+	require.Len(t, g.Warnings, 1)
+	assert.Contains(t, g.Warnings[0], "git codebase 'stack'")
+}
@@ -0,0 +1,216 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadComposeFileWithEnv loads a compose file after substituting its ${VAR} references like docker compose:
+// $VAR, ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?error}, ${VAR?error}, ${VAR:+alt}, ${VAR+alt}
+// and $$ for a literal $. Only the values are interpolated, not the keys.
+// It returns the names of the referenced variables which are not set, they are replaced by an empty string.
+func LoadComposeFileWithEnv(data []byte, env map[string]string) (*ComposeProject, []string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, nil, fmt.Errorf("error during the compose YAML file parsing: %w", err)
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	missing := make(map[string]bool)
+	if err := interpolateNode(&document, lookup, missing); err != nil {
+		return nil, nil, err
+	}
+	interpolated, err := yaml.Marshal(&document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode the interpolated compose file: %w", err)
+	}
+	project, err := LoadComposeFile(interpolated)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return project, names, nil
+}
+
+// ComposeInterpolationEnv returns the variables available to the interpolation of a compose file:
+// the build environment, completed by the .env file next to the compose file like docker compose does
+func ComposeInterpolationEnv(env map[string]string, composeDir string) (map[string]string, error) {
+	merged := make(map[string]string, len(env))
+	dotEnv, err := godotenv.Read(filepath.Join(composeDir, ".env"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read the .env file of the compose project: %w", err)
+	}
+	for k, v := range dotEnv {
+		merged[k] = v
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+func interpolateNode(node *yaml.Node, lookup func(string) (string, bool), missing map[string]bool) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, lookup, missing); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], lookup, missing); err != nil {
+				return fmt.Errorf("%s: %w", node.Content[i-1].Value, err)
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return nil
+		}
+		value, err := Interpolate(node.Value, lookup, missing)
+		if err != nil {
+			return err
+		}
+		node.Value = value
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = "" // Let the decoder resolve the type of the substituted value ("8080", "true")
+		}
+	}
+	return nil
+}
+
+// Interpolate substitutes the variable references of a value. The unset variables without
+// default are reported in missing (when not nil) and replaced by an empty string.
+func Interpolate(value string, lookup func(string) (string, bool), missing map[string]bool) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '$' || i+1 == len(value) {
+			out.WriteByte(c)
+			continue
+		}
+		next := value[i+1]
+		switch {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+		case next == '{':
+			end := matchingBrace(value, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("invalid interpolation format in '%s': missing '}'", value)
+			}
+			substituted, err := substitute(value[i+2:end], lookup, missing)
+			if err != nil {
+				return "", fmt.Errorf("invalid interpolation in '%s': %w", value, err)
+			}
+			out.WriteString(substituted)
+			i = end
+		case isNameStart(next):
+			end := i + 1
+			for end < len(value) && isNameChar(value[end]) {
+				end++
+			}
+			out.WriteString(lookupVariable(value[i+1:end], lookup, missing))
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+// substitute resolves the content of a ${...} reference
+func substitute(expr string, lookup func(string) (string, bool), missing map[string]bool) (string, error) {
+	end := 0
+	for end < len(expr) && isNameChar(expr[end]) {
+		end++
+	}
+	name, modifier := expr[:end], expr[end:]
+	if name == "" || !isNameStart(name[0]) {
+		return "", fmt.Errorf("invalid variable name in '${%s}'", expr)
+	}
+	if modifier == "" {
+		return lookupVariable(name, lookup, missing), nil
+	}
+
+	value, set := lookup(name)
+	operator := modifier[:1]
+	emptyIsUnset := false
+	if operator == ":" && len(modifier) > 1 {
+		operator = modifier[:2]
+		emptyIsUnset = true
+	}
+	arg := modifier[len(operator):]
+	usable := set && !(emptyIsUnset && value == "")
+
+	switch strings.TrimPrefix(operator, ":") {
+	case "-":
+		if usable {
+			return value, nil
+		}
+		return Interpolate(arg, lookup, missing)
+	case "?":
+		if usable {
+			return value, nil
+		}
+		message, err := Interpolate(arg, lookup, missing)
+		if err != nil {
+			return "", err
+		}
+		if message == "" {
+			message = "not set"
+		}
+		return "", fmt.Errorf("required variable %s is missing a value: %s", name, message)
+	case "+":
+		if usable {
+			return Interpolate(arg, lookup, missing)
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported modifier '%s' in '${%s}'", modifier, expr)
+}
+
+func lookupVariable(name string, lookup func(string) (string, bool), missing map[string]bool) string {
+	value, ok := lookup(name)
+	if !ok && missing != nil {
+		missing[name] = true
+	}
+	return value
+}
+
+// matchingBrace returns the index of the '}' closing the '{' at open, -1 if none
+func matchingBrace(value string, open int) int {
+	depth := 0
+	for i := open; i < len(value); i++ {
+		switch value[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
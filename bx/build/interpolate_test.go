@@ -0,0 +1,80 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"TAG": "1.2", "EMPTY": "", "HOST": "db"}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	cases := map[string]string{
+		"app:$TAG":                 "app:1.2",
+		"app:${TAG}":               "app:1.2",
+		"${EMPTY:-fallback}":       "fallback",
+		"${EMPTY-fallback}":        "",
+		"${UNSET-fallback}":        "fallback",
+		"${UNSET:-${HOST}:5432}":   "db:5432",
+		"${TAG:+set}/${UNSET+set}": "set/",
+		"cost: $$5 and $${TAG}":    "cost: $5 and ${TAG}",
+		"${EMPTY?}x${TAG?missing}": "x1.2",
+	}
+	for input, expected := range cases {
+		value, err := Interpolate(input, lookup, nil)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, value, input)
+	}
+
+	_, err := Interpolate("${UNSET:?the database host is required}", lookup, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the database host is required")
+	_, err = Interpolate("${EMPTY:?}", lookup, nil)
+	assert.Error(t, err)
+	_, err = Interpolate("${TAG", lookup, nil)
+	assert.Error(t, err)
+
+	missing := map[string]bool{}
+	value, err := Interpolate("$UNSET-${OTHER}", lookup, missing)
+	require.NoError(t, err)
+	assert.Equal(t, "-", value)
+	assert.Equal(t, map[string]bool{"UNSET": true, "OTHER": true}, missing)
+}
+
+func TestLoadComposeFileWithEnv(t *testing.T) {
+	composeDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(composeDir, ".env"), []byte("PORT=9000\nTAG=from-dotenv\n"), 0644))
+	env, err := ComposeInterpolationEnv(map[string]string{"TAG": "2.0"}, composeDir)
+	require.NoError(t, err)
+
+	compose := `
+services:
+  api:
+    image: "registry.local/api:${TAG}"
+    ports:
+      - "${PORT:-8080}:80"
+    environment:
+      DB_URL: postgres://${DB_HOST:-db}/app
+      PRICE: $$10
+      ${KEY_NOT_INTERPOLATED}: x
+`
+	project, missing, err := LoadComposeFileWithEnv([]byte(compose), env)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+	api := project.Services["api"]
+	assert.Equal(t, "registry.local/api:2.0", api.Image)
+	assert.Equal(t, []string{"9000:80"}, api.Ports)
+	assert.Equal(t, "postgres://db/app", *api.Environment["DB_URL"])
+	assert.Equal(t, "$10", *api.Environment["PRICE"])
+	assert.Contains(t, api.Environment, "${KEY_NOT_INTERPOLATED}")
+
+	_, _, err = LoadComposeFileWithEnv([]byte("services:\n  api:\n    image: ${IMAGE:?set IMAGE}\n"), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "set IMAGE")
+}
@@ -0,0 +1,263 @@
+package build
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Backblaze/blazer/b2"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+)
+
+// Directory of the build journals, relative to the working dir
+const journalDirName = ".journal"
+
+// ResourceKind is the type of a resource tracked by a build journal
+type ResourceKind string
+
+const (
+	ResourceContainer ResourceKind = "container" // ID is the container ID
+	ResourceImage     ResourceKind = "image"     // ID is the image ID
+	ResourceDir       ResourceKind = "dir"       // ID is the directory path
+	ResourceB2Upload  ResourceKind = "b2_upload" // ID is the object name of an upload in progress
+)
+
+// journalEntry is one line of a build journal
+type journalEntry struct {
+	Op   string       `json:"op"` // "track" or "release"
+	Kind ResourceKind `json:"kind"`
+	ID   string       `json:"id"`
+	Time time.Time    `json:"time"`
+}
+
+// buildJournal records the resources created by a build so they can be cleaned
+// if the process dies before the build ends. A nil journal ignores every call.
+type buildJournal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// RecoveredResource is an orphaned resource found in the journal of an interrupted build
+type RecoveredResource struct {
+	BuildID string       `json:"build_id"`
+	Kind    ResourceKind `json:"kind"`
+	ID      string       `json:"id"`
+	Error   string       `json:"error,omitempty"` // Set when the cleanup failed, the journal is then kept for the next recovery
+}
+
+// RecoveryReport lists what RecoverOrphans cleaned
+type RecoveryReport struct {
+	Builds    []string            `json:"builds"` // Interrupted builds found in the journals
+	Recovered []RecoveredResource `json:"recovered"`
+	Failed    []RecoveredResource `json:"failed"`
+}
+
+type journalContextKey struct{}
+
+// withJournal makes the journal available to the helpers creating resources
+func withJournal(ctx context.Context, journal *buildJournal) context.Context {
+	return context.WithValue(ctx, journalContextKey{}, journal)
+}
+
+// journalFrom returns the journal of the build running with ctx, nil if none
+func journalFrom(ctx context.Context) *buildJournal {
+	journal, _ := ctx.Value(journalContextKey{}).(*buildJournal)
+	return journal
+}
+
+// openJournal starts the journal of a build. A failure only disables the crash recovery of this build.
+func (s *BuildService) openJournal(buildID string) *buildJournal {
+	dir := filepath.Join(s.workDir, journalDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.Logger().Warn("cannot create the journal directory", LogKeyBuildID, buildID, "dir", dir, "error", err)
+		return nil
+	}
+	path := filepath.Join(dir, buildID+".jsonl")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		s.Logger().Warn("cannot open the build journal", LogKeyBuildID, buildID, "path", path, "error", err)
+		return nil
+	}
+	return &buildJournal{path: path, file: file}
+}
+
+// Track records a resource created by the build
+func (j *buildJournal) Track(kind ResourceKind, id string) {
+	j.write("track", kind, id)
+}
+
+// Release records that a resource was cleaned or must be kept
+func (j *buildJournal) Release(kind ResourceKind, id string) {
+	j.write("release", kind, id)
+}
+
+func (j *buildJournal) write(op string, kind ResourceKind, id string) {
+	if j == nil || id == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return
+	}
+	data, _ := json.Marshal(journalEntry{Op: op, Kind: kind, ID: id, Time: time.Now()})
+	// Synced so the entry survives a crash right after the resource creation
+	if _, err := j.file.Write(append(data, '\n')); err == nil {
+		j.file.Sync()
+	}
+}
+
+// Close ends the journal of a build which returned normally, its remaining resources are kept on purpose
+func (j *buildJournal) Close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	os.Remove(j.path)
+}
+
+// readJournal returns the resources tracked and not released, in creation order
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tracked []journalEntry
+	released := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Partially written last line
+		}
+		key := string(entry.Kind) + "/" + entry.ID
+		switch entry.Op {
+		case "track":
+			tracked = append(tracked, entry)
+			delete(released, key)
+		case "release":
+			released[key] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var orphans []journalEntry
+	seen := make(map[string]bool)
+	for _, entry := range tracked {
+		key := string(entry.Kind) + "/" + entry.ID
+		if !released[key] && !seen[key] {
+			orphans = append(orphans, entry)
+			seen[key] = true
+		}
+	}
+	return orphans, nil
+}
+
+// RecoverOrphans cleans the resources left by the builds interrupted by a crash of a previous process.
+// It must be called before any build is started on this working dir.
+func (s *BuildService) RecoverOrphans(ctx context.Context) (*RecoveryReport, error) {
+	report := &RecoveryReport{}
+	dir := filepath.Join(s.workDir, journalDirName)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the journal directory '%s': %w", dir, err)
+	}
+
+	for _, dirEntry := range entries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".jsonl") {
+			continue
+		}
+		buildID := strings.TrimSuffix(dirEntry.Name(), ".jsonl")
+		path := filepath.Join(dir, dirEntry.Name())
+		orphans, err := readJournal(path)
+		if err != nil {
+			return report, fmt.Errorf("cannot read the build journal '%s': %w", path, err)
+		}
+		report.Builds = append(report.Builds, buildID)
+
+		failed := false
+		// Newest first: the containers go before their images, the uploads before their dirs
+		for i := len(orphans) - 1; i >= 0; i-- {
+			resource := RecoveredResource{BuildID: buildID, Kind: orphans[i].Kind, ID: orphans[i].ID}
+			if err := s.cleanResource(ctx, resource.Kind, resource.ID); err != nil {
+				resource.Error = err.Error()
+				report.Failed = append(report.Failed, resource)
+				failed = true
+				continue
+			}
+			report.Recovered = append(report.Recovered, resource)
+		}
+		if !failed {
+			os.Remove(path)
+		}
+	}
+	return report, nil
+}
+
+func (s *BuildService) cleanResource(ctx context.Context, kind ResourceKind, id string) error {
+	switch kind {
+	case ResourceContainer:
+		err := s.backendFor(ctx).ContainerRemove(ctx, id, container.RemoveOptions{Force: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	case ResourceImage:
+		_, err := s.backendFor(ctx).ImageRemove(ctx, id, image.RemoveOptions{Force: true, PruneChildren: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	case ResourceDir:
+		return os.RemoveAll(id)
+	case ResourceB2Upload:
+		return s.cancelB2Upload(ctx, id)
+	default:
+		return fmt.Errorf("unknown resource kind '%s'", kind)
+	}
+	return nil
+}
+
+// cancelB2Upload cancels the unfinished large file uploads of an object
+func (s *BuildService) cancelB2Upload(ctx context.Context, objectName string) error {
+	if s.b2Config == nil {
+		return fmt.Errorf("no B2 configuration to cancel the upload")
+	}
+	b2Client, err := b2.NewClient(ctx, s.b2Config.AccountID, s.b2Config.ApplicationKey, b2.UserAgent("build-service"))
+	if err != nil {
+		return fmt.Errorf("cannot create the B2 client: %w", err)
+	}
+	bucket, err := b2Client.Bucket(ctx, s.b2Config.BucketName)
+	if err != nil {
+		return fmt.Errorf("cannot access the B2 bucket '%s': %w", s.b2Config.BucketName, err)
+	}
+	iter := bucket.List(ctx, b2.ListUnfinished(), b2.ListPrefix(objectName))
+	for iter.Next() {
+		if obj := iter.Object(); obj.Name() == objectName {
+			if err := obj.Cancel(ctx); err != nil {
+				return fmt.Errorf("cannot cancel the upload of '%s': %w", objectName, err)
+			}
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverOrphans_CleansInterruptedBuilds(t *testing.T) {
+	workDir := t.TempDir()
+	s := &BuildService{workDir: workDir}
+
+	// An interrupted build: the journal is never closed
+	orphanDir := filepath.Join(workDir, "app-1.0-1")
+	require.NoError(t, os.MkdirAll(orphanDir, 0755))
+	keptDir := filepath.Join(workDir, "kept")
+	require.NoError(t, os.MkdirAll(keptDir, 0755))
+	crashed := s.openJournal("app-1.0-1")
+	require.NotNil(t, crashed)
+	crashed.Track(ResourceDir, orphanDir)
+	crashed.Track(ResourceDir, keptDir)
+	crashed.Release(ResourceDir, keptDir)
+	crashed.file.Close() // Simulates the process death
+
+	// A build which ended normally leaves no journal
+	finished := s.openJournal("app-1.0-2")
+	finished.Track(ResourceDir, filepath.Join(workDir, "app-1.0-2"))
+	finished.Close()
+
+	report, err := s.RecoverOrphans(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-1.0-1"}, report.Builds)
+	require.Len(t, report.Recovered, 1)
+	assert.Equal(t, orphanDir, report.Recovered[0].ID)
+	assert.Empty(t, report.Failed)
+
+	assert.NoDirExists(t, orphanDir)
+	assert.DirExists(t, keptDir)
+	entries, err := os.ReadDir(filepath.Join(workDir, journalDirName))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the recovered journals are removed")
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
 )
 
@@ -59,29 +60,68 @@ func LoadBuildSpecFromBytes(data []byte, format string) (*BuildSpec, error) {
 	if spec.BuildConfig.Dockerfile != "" && spec.BuildConfig.ComposeFile != "" {
 		return nil, fmt.Errorf("don't specify 'dockerfile' et 'compose_file' in the build_config")
 	}
+	if err := validateCompression(spec.BuildConfig); err != nil {
+		return nil, err
+	}
+	if err := validateConcurrency(spec.BuildConfig); err != nil {
+		return nil, err
+	}
+	if err := validateDockerHost(spec.DockerHost); err != nil {
+		return nil, err
+	}
+	for _, registry := range spec.Registries {
+		if registry.Host == "" {
+			return nil, fmt.Errorf("the field 'host' is required in the registries")
+		}
+		if registry.Password != "" && registry.Username == "" {
+			return nil, fmt.Errorf("the registry '%s' has a password but no username", registry.Host)
+		}
+		if registry.Password != "" && registry.Token != "" {
+			return nil, fmt.Errorf("don't specify 'password' and 'token' for the registry '%s'", registry.Host)
+		}
+	}
 
 	return &spec, nil
 }
 
-// parse a compose file
-func LoadComposeFile(data []byte) (*ComposeProject, error) {
-	var project ComposeProject
-	err := yaml.Unmarshal(data, &project)
-	if err != nil {
-		return nil, fmt.Errorf("error during the compose YAML file parsing: %w", err)
-	}
-	if len(project.Services) == 0 {
-		return nil, fmt.Errorf("no service section found in the compose file config")
-	}
-	// Initializing the maps/slices nil to avoid the nil pointer panics
-	for _, service := range project.Services {
+// ResolveComposeEnvFiles loads the env_file entries of every service, relative to composeDir, and merges
+// their variables in the service environment. The environment section wins, then the later files.
+// It returns the files consumed by service. A missing optional file is skipped.
+func ResolveComposeEnvFiles(project *ComposeProject, composeDir string) (map[string][]string, error) {
+	consumed := make(map[string][]string)
+	for name, service := range project.Services {
+		if len(service.EnvFile) == 0 {
+			continue
+		}
+		fromFiles := make(map[string]string)
+		for _, envFile := range service.EnvFile {
+			path := envFile.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(composeDir, path)
+			}
+			values, err := godotenv.Read(path)
+			if err != nil {
+				if os.IsNotExist(err) && !envFile.Required {
+					continue
+				}
+				return consumed, fmt.Errorf("cannot read the env_file '%s' of the service '%s': %w", envFile.Path, name, err)
+			}
+			for k, v := range values {
+				fromFiles[k] = v
+			}
+			consumed[name] = append(consumed[name], path)
+		}
+
 		if service.Environment == nil {
 			service.Environment = make(map[string]*string)
 		}
-		if service.Build != nil && service.Build.Args == nil {
-			service.Build.Args = make(map[string]*string)
+		for k, v := range fromFiles {
+			if _, exists := service.Environment[k]; !exists {
+				value := v
+				service.Environment[k] = &value
+			}
 		}
-		// TODO: do this for other map slice...
+		project.Services[name] = service
 	}
-	return &project, nil
+	return consumed, nil
 }
@@ -0,0 +1,78 @@
+package build
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Attributes of the log records of the builds
+const (
+	LogKeyBuildID = "build_id"
+	LogKeyPhase   = "phase"
+	LogKeyService = "service"
+)
+
+type loggerContextKey struct{}
+
+// SetLogger sends the events of the builds to a structured logger, with the build_id, phase
+// and service attributes, so they can be shipped to a centralized logging. The events still
+// fill BuildResult.Logs and the event channels. slog.Default() is used when nil.
+func (s *BuildService) SetLogger(logger *slog.Logger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.logger = logger
+}
+
+// Logger returns the logger of the service
+func (s *BuildService) Logger() *slog.Logger {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.logger == nil {
+		return slog.Default()
+	}
+	return s.logger
+}
+
+// withLogger makes the helpers of the build running with ctx log with the attributes of the build
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// loggerFor returns the logger of the build running with ctx, the one of the service by default
+func (s *BuildService) loggerFor(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return s.Logger()
+}
+
+// logEvent writes an event of the stream as a log record: the logs and the artifacts at the info
+// level, the warnings at the warn level, the phases at the debug level unless they failed
+func logEvent(logger *slog.Logger, event BuildEvent) {
+	var attrs []any
+	if event.Phase != "" {
+		attrs = append(attrs, LogKeyPhase, event.Phase)
+	}
+	if event.Service != "" {
+		attrs = append(attrs, LogKeyService, event.Service)
+	}
+	switch event.Type {
+	case EventLog:
+		logger.Info(event.Message, attrs...)
+	case EventWarning:
+		logger.Warn(event.Message, attrs...)
+	case EventArtifact:
+		logger.Info("artifact", append(attrs, "artifact", event.Artifact, "ref", event.Ref)...)
+	case EventProgress:
+		logger.Debug(event.Message, append(attrs, "current", event.Current, "total", event.Total)...)
+	case EventPhaseStarted:
+		logger.Debug("phase started", attrs...)
+	case EventPhaseFinished:
+		attrs = append(attrs, "duration", event.Duration)
+		if event.Error != "" {
+			logger.Error("phase failed", append(attrs, "error", event.Error)...)
+		} else {
+			logger.Debug("phase finished", attrs...)
+		}
+	}
+}
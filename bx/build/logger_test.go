@@ -0,0 +1,59 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With(LogKeyBuildID, "app-1")
+	events := newEventStream(nil)
+	events.SetLogger(logger)
+	events.AddSecret("hunter2")
+
+	events.StartPhase(PhaseBuild)
+	events.ServiceLogf("api", "Building with password %s", "hunter2")
+	events.Warnf("cache miss")
+	events.Artifact(ArtifactImage, "api", "sha256:abc")
+	events.Close("docker build failed")
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 5)
+	assert.Equal(t, "DEBUG", records[0]["level"])
+	assert.Equal(t, "Building with password [REDACTED]", records[1]["msg"])
+	assert.Equal(t, "api", records[1][LogKeyService])
+	assert.Equal(t, PhaseBuild, records[1][LogKeyPhase])
+	assert.Equal(t, "app-1", records[1][LogKeyBuildID])
+	assert.Equal(t, "WARN", records[2]["level"])
+	assert.Equal(t, "sha256:abc", records[3]["ref"])
+	assert.Equal(t, "ERROR", records[4]["level"])
+	assert.Equal(t, "docker build failed", records[4]["error"])
+
+	// The logs of the result keep their format
+	assert.Equal(t, "Building with password [REDACTED]\nWarning: cache miss\n", events.Render())
+}
+
+func TestLoggerFor(t *testing.T) {
+	s := &BuildService{}
+	assert.Equal(t, slog.Default(), s.loggerFor(context.Background()))
+
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	s.SetLogger(logger)
+	assert.Equal(t, logger, s.loggerFor(context.Background()))
+
+	buildLogger := logger.With(LogKeyBuildID, "app-1")
+	assert.Equal(t, buildLogger, s.loggerFor(withLogger(context.Background(), buildLogger)))
+}
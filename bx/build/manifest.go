@@ -0,0 +1,85 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Version of the manifest format, raised on incompatible changes
+const manifestVersion = "1"
+
+// BuildManifest lists the images produced by a build, written as JSON next to the outputs
+// (<name>-<version>.manifest.json) for the tools which consume the builds
+type BuildManifest struct {
+	ManifestVersion string                      `json:"manifest_version"`
+	Name            string                      `json:"name"`
+	Version         string                      `json:"version"`
+	CreatedAt       time.Time                   `json:"created_at"`
+	OutputTarget    string                      `json:"output_target"`
+	Compression     string                      `json:"compression,omitempty"` // Compression of the archives of the "local" and "b2" outputs
+	RunConfigPath   string                      `json:"run_config_path,omitempty"`
+	Codebases       map[string]CodebaseRevision `json:"codebases,omitempty"`
+	Images          []ManifestImage             `json:"images"` // Sorted by service
+}
+
+// ManifestImage is an image of the manifest
+type ManifestImage struct {
+	Service       string   `json:"service"`
+	ImageID       string   `json:"image_id"`
+	Tags          []string `json:"tags,omitempty"`
+	Size          int64    `json:"size"`                     // Size of the image in the daemon
+	ArchivePath   string   `json:"archive_path,omitempty"`   // For OutputTarget="local"
+	ArchiveDigest string   `json:"archive_digest,omitempty"` // sha256 of the archive, local or B2
+	B2Objects     []string `json:"b2_objects,omitempty"`     // Archive and tag references, for OutputTarget="b2"
+}
+
+// newArtifactManifest builds the manifest of a successful build, b2Objects lists the B2 objects of each service
+func newArtifactManifest(spec *BuildSpec, result *BuildResult, finalImageTags map[string][]string, b2Objects map[string][]string) *BuildManifest {
+	manifest := &BuildManifest{
+		ManifestVersion: manifestVersion,
+		Name:            spec.Name,
+		Version:         spec.Version,
+		CreatedAt:       time.Now().UTC(),
+		OutputTarget:    spec.BuildConfig.OutputTarget,
+		RunConfigPath:   result.RunConfigPath,
+		Codebases:       result.Codebases,
+		Images:          make([]ManifestImage, 0, len(result.ServiceOutputs)),
+	}
+	if spec.BuildConfig.OutputTarget == "local" || spec.BuildConfig.OutputTarget == "b2" {
+		manifest.Compression = spec.BuildConfig.Compression
+		if manifest.Compression == "" {
+			manifest.Compression = CompressionNone
+		}
+	}
+
+	for serviceName, output := range result.ServiceOutputs {
+		if output.ImageID == "" {
+			continue
+		}
+		manifest.Images = append(manifest.Images, ManifestImage{
+			Service:       serviceName,
+			ImageID:       output.ImageID,
+			Tags:          finalImageTags[serviceName],
+			Size:          output.ImageSize,
+			ArchivePath:   result.LocalImagePaths[serviceName],
+			ArchiveDigest: result.ArchiveDigests[serviceName],
+			B2Objects:     b2Objects[serviceName],
+		})
+	}
+	sort.Slice(manifest.Images, func(i, j int) bool { return manifest.Images[i].Service < manifest.Images[j].Service })
+	return manifest
+}
+
+// writeManifest writes the manifest with the output permissions
+func (s *BuildService) writeManifest(path string, manifest *BuildManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode the artifact manifest: %w", err)
+	}
+	if err := s.OutputOptions().WriteFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("cannot write the artifact manifest '%s': %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package build
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewArtifactManifest(t *testing.T) {
+	spec := &BuildSpec{Name: "app", Version: "1.2.0", BuildConfig: BuildConfig{OutputTarget: "local", Compression: CompressionZstd}}
+	result := &BuildResult{
+		RunConfigPath: "/out/app-1.2.0.run.yml",
+		ServiceOutputs: map[string]ServiceOutput{
+			"web":    {ImageID: "sha256:bbb", ImageSize: 200},
+			"api":    {ImageID: "sha256:aaa", ImageSize: 100},
+			"broken": {},
+		},
+		LocalImagePaths: map[string]string{"api": "/out/app-api-1.2.0.tar.zst", "web": "/out/app-web-1.2.0.tar.zst"},
+		ArchiveDigests:  map[string]string{"api": "sha256:111"},
+	}
+	tags := map[string][]string{"api": {"app-api:1.2.0"}, "web": {"app-web:1.2.0"}}
+
+	manifest := newArtifactManifest(spec, result, tags, nil)
+	assert.Equal(t, manifestVersion, manifest.ManifestVersion)
+	assert.Equal(t, CompressionZstd, manifest.Compression)
+	assert.Equal(t, "/out/app-1.2.0.run.yml", manifest.RunConfigPath)
+	require.Len(t, manifest.Images, 2)
+	assert.Equal(t, ManifestImage{
+		Service:       "api",
+		ImageID:       "sha256:aaa",
+		Tags:          []string{"app-api:1.2.0"},
+		Size:          100,
+		ArchivePath:   "/out/app-api-1.2.0.tar.zst",
+		ArchiveDigest: "sha256:111",
+	}, manifest.Images[0])
+	assert.Equal(t, "web", manifest.Images[1].Service)
+
+	spec.BuildConfig = BuildConfig{OutputTarget: "docker"}
+	assert.Empty(t, newArtifactManifest(spec, result, tags, nil).Compression)
+}
+
+func TestWriteManifest(t *testing.T) {
+	s := &BuildService{}
+	path := filepath.Join(t.TempDir(), "out", "app-1.0.manifest.json")
+	manifest := newArtifactManifest(
+		&BuildSpec{Name: "app", Version: "1.0", BuildConfig: BuildConfig{OutputTarget: "b2"}},
+		&BuildResult{ServiceOutputs: map[string]ServiceOutput{"api": {ImageID: "sha256:aaa"}}},
+		nil,
+		map[string][]string{"api": {"app/api.tar", "app/api.ref.txt"}},
+	)
+	require.NoError(t, s.writeManifest(path, manifest))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded BuildManifest
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "app", decoded.Name)
+	assert.Equal(t, CompressionNone, decoded.Compression)
+	require.Len(t, decoded.Images, 1)
+	assert.Equal(t, []string{"app/api.tar", "app/api.ref.txt"}, decoded.Images[0].B2Objects)
+}
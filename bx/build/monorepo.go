@@ -0,0 +1,113 @@
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Depth of the subdirectories scanned by DetectEcosystems
+const maxEcosystemDepth = 4
+
+// Directories of dependencies, outputs and tools, never the root of a project
+var skippedEcosystemDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"target":       true,
+	"dist":         true,
+	"build":        true,
+	"__pycache__":  true,
+	"venv":         true,
+}
+
+// DetectEcosystems returns the ecosystems of a monorepo, found in the directory and its subdirectories,
+// with their root paths. A directory with several languages gives one ecosystem per language, where
+// DetectEcosystem fails with ErrAmbiguousEcosystem. The projects of the language of a parent directory
+// (members of a go.work, packages of a workspace) are part of the parent and are not listed.
+func DetectEcosystems(path string) ([]DetectedEcosystem, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve absolute path for %s: %w", path, err)
+	}
+	primaryMarkers := loadPrimaryMarkers()
+	secondaryMarkers := loadSecondaryMarkers()
+
+	var detected []DetectedEcosystem
+	languagesOf := make(map[string]map[string]bool) // Languages detected in each directory and its parents
+	err = filepath.WalkDir(absPath, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if dir != absPath {
+			rel, _ := filepath.Rel(absPath, dir)
+			if strings.HasPrefix(d.Name(), ".") || skippedEcosystemDirs[d.Name()] || strings.Count(rel, string(filepath.Separator)) >= maxEcosystemDepth {
+				return filepath.SkipDir
+			}
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("cannot read directory %s: %w", dir, err)
+		}
+
+		languages := make(map[string]bool)
+		for language := range languagesOf[filepath.Dir(dir)] {
+			languages[language] = true
+		}
+		for _, ecosystem := range scanAllMarkers(dir, entries, primaryMarkers) {
+			if languages[ecosystem.Language] {
+				continue
+			}
+			completeDetection(dir, entries, &ecosystem, secondaryMarkers)
+			detected = append(detected, ecosystem)
+			languages[ecosystem.Language] = true
+		}
+		languagesOf[dir] = languages
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(detected) == 0 {
+		return nil, ErrNoEcosystemFound
+	}
+	return detected, nil
+}
+
+// scanAllMarkers returns the ecosystem of each language found in a directory, with the marker of
+// the highest priority, sorted by language
+func scanAllMarkers(path string, entries []os.DirEntry, primary map[string]detectionCandidate) []DetectedEcosystem {
+	best := make(map[string]detectionCandidate)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		key := name
+		if strings.Contains(name, ".csproj") {
+			key = "*.csproj"
+		}
+		candidate, ok := primary[key]
+		if !ok {
+			continue
+		}
+		if current, ok := best[candidate.ecosystem.Language]; ok && current.priority >= candidate.priority {
+			continue
+		}
+		candidate.ecosystem.RootPath = path
+		candidate.ecosystem.MainMarkerFile = name
+		best[candidate.ecosystem.Language] = candidate
+	}
+
+	ecosystems := make([]DetectedEcosystem, 0, len(best))
+	for _, candidate := range best {
+		ecosystems = append(ecosystems, candidate.ecosystem)
+	}
+	sort.Slice(ecosystems, func(i, j int) bool { return ecosystems[i].Language < ecosystems[j].Language })
+	return ecosystems
+}
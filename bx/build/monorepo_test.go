@@ -0,0 +1,51 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectEcosystems(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"package.json":                             `{"workspaces": ["packages/*"]}`,
+		"pnpm-lock.yaml":                           "",
+		"packages/ui/package.json":                 `{}`,
+		"services/api/go.mod":                      "module example.com/api\n\ngo 1.22\n",
+		"services/worker/Cargo.toml":               "[package]\nname = \"worker\"\n",
+		"services/worker/requirements.txt":         "celery\n",
+		"services/api/node_modules/x/package.json": `{}`,
+		".git/go.mod":                              "module ignored\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	ecosystems, err := DetectEcosystems(root)
+	require.NoError(t, err)
+	var found []string
+	for _, ecosystem := range ecosystems {
+		rel, err := filepath.Rel(root, ecosystem.RootPath)
+		require.NoError(t, err)
+		found = append(found, rel+":"+ecosystem.Language+":"+ecosystem.PackageManager)
+	}
+	assert.ElementsMatch(t, []string{
+		".:JavaScript:pnpm",
+		"services/api:Go:go",
+		"services/worker:Python:pip",
+		"services/worker:Rust:cargo",
+	}, found)
+
+	// DetectEcosystem still fails on the directory with two languages
+	_, err = DetectEcosystem(filepath.Join(root, "services/worker"))
+	assert.ErrorIs(t, err, ErrAmbiguousEcosystem)
+
+	_, err = DetectEcosystems(t.TempDir())
+	assert.ErrorIs(t, err, ErrNoEcosystemFound)
+}
@@ -0,0 +1,36 @@
+package build
+
+import (
+	"context"
+
+	"github.com/Treefle-labs/Anexis/bx/notify"
+)
+
+// SetNotifier sends a notification to the dispatcher channels when a queued build finishes
+func (s *BuildService) SetNotifier(dispatcher *notify.Dispatcher) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notifier = dispatcher
+}
+
+// notifyFinished is the onFinish callback of the build queue
+func (s *BuildService) notifyFinished(status BuildStatus) {
+	s.mutex.Lock()
+	dispatcher := s.notifier
+	s.mutex.Unlock()
+	if dispatcher == nil {
+		return
+	}
+
+	n := notify.Notification{
+		BuildID: status.BuildID,
+		Project: status.Name,
+		Branch:  status.Branch,
+		Status:  string(status.State),
+		Message: status.Error,
+	}
+	if status.FinishedAt != nil {
+		n.Time = *status.FinishedAt
+	}
+	dispatcher.Notify(context.Background(), n)
+}
@@ -0,0 +1,194 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Modes used when OutputOptions leaves them empty
+const (
+	DefaultOutputFileMode os.FileMode = 0644
+	DefaultOutputDirMode  os.FileMode = 0755
+)
+
+// Owner is the uid/gid set on the outputs
+type Owner struct {
+	UID int
+	GID int
+}
+
+// OutputOptions controls where and with which permissions the outputs meant for the users
+// of the service (run.yml, image archives, logs) are written. The modes are applied explicitly
+// so the result does not depend on the umask of the process. The zero value keeps the defaults.
+type OutputOptions struct {
+	Dir      string      // Base directory of the outputs when the spec has no local_path, the build dir if empty
+	FileMode os.FileMode // Mode of the written files, DefaultOutputFileMode if zero
+	DirMode  os.FileMode // Mode of the created directories, DefaultOutputDirMode if zero
+	Owner    *Owner      // Owner of the written files and created directories, the service user if nil
+}
+
+// ParseOwner parses an owner written "uid:gid", "uid" or ":gid". The missing part is left unchanged (-1).
+func ParseOwner(value string) (*Owner, error) {
+	if value == "" {
+		return nil, nil
+	}
+	uidPart, gidPart, _ := strings.Cut(value, ":")
+	owner := &Owner{UID: -1, GID: -1}
+	var err error
+	if uidPart != "" {
+		if owner.UID, err = strconv.Atoi(uidPart); err != nil || owner.UID < 0 {
+			return nil, fmt.Errorf("invalid uid '%s' in the owner '%s'", uidPart, value)
+		}
+	}
+	if gidPart != "" {
+		if owner.GID, err = strconv.Atoi(gidPart); err != nil || owner.GID < 0 {
+			return nil, fmt.Errorf("invalid gid '%s' in the owner '%s'", gidPart, value)
+		}
+	}
+	if owner.UID < 0 && owner.GID < 0 {
+		return nil, fmt.Errorf("invalid owner '%s', expected uid:gid", value)
+	}
+	return owner, nil
+}
+
+// ParseFileMode parses an octal permission such as "0664" or "775"
+func ParseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("invalid file mode '%s', expected an octal value like 0644", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (o OutputOptions) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return DefaultOutputFileMode
+	}
+	return o.FileMode
+}
+
+func (o OutputOptions) dirMode() os.FileMode {
+	if o.DirMode == 0 {
+		return DefaultOutputDirMode
+	}
+	return o.DirMode
+}
+
+// Resolve returns the path relative to the output directory, absolute paths are kept
+func (o OutputOptions) Resolve(path string) string {
+	if o.Dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(o.Dir, path)
+}
+
+// MkdirAll creates a directory and its missing parents with the output mode and owner.
+// The directories which already exist are left untouched.
+func (o OutputOptions) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("'%s' exists and is not a directory", dir)
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := o.MkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, o.dirMode()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return o.Apply(dir, true)
+}
+
+// WriteFile writes an output file, creating its directory if needed
+func (o OutputOptions) WriteFile(path string, data []byte) error {
+	f, err := o.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Create creates or truncates an output file, creating its directory if needed
+func (o OutputOptions) Create(path string) (*os.File, error) {
+	return o.open(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+}
+
+// OpenAppend opens an output file for appending, creating it and its directory if needed
+func (o OutputOptions) OpenAppend(path string) (*os.File, error) {
+	return o.open(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+}
+
+func (o OutputOptions) open(path string, flag int) (*os.File, error) {
+	if err := o.MkdirAll(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("cannot create the output directory of '%s': %w", path, err)
+	}
+	f, err := os.OpenFile(path, flag, o.fileMode())
+	if err != nil {
+		return nil, err
+	}
+	if err := o.Apply(path, false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Apply sets the output mode and owner on an existing file or directory
+func (o OutputOptions) Apply(path string, isDir bool) error {
+	mode := o.fileMode()
+	if isDir {
+		mode = o.dirMode()
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("cannot set the mode of '%s': %w", path, err)
+	}
+	if o.Owner != nil {
+		if err := os.Lchown(path, o.Owner.UID, o.Owner.GID); err != nil {
+			return fmt.Errorf("cannot change the owner of '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// --- BuildService integration ---
+
+// SetOutputOptions configures the location and the permissions of the build outputs
+func (s *BuildService) SetOutputOptions(opts OutputOptions) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.output = opts
+}
+
+// OutputOptions returns the output configuration of the service
+func (s *BuildService) OutputOptions() OutputOptions {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.output
+}
+
+// outputBasePath returns the directory receiving the outputs of a build:
+// the spec local_path (relative to the output dir), else the output dir, else the build dir
+func (s *BuildService) outputBasePath(spec *BuildSpec, buildDir string) string {
+	opts := s.OutputOptions()
+	if spec.BuildConfig.OutputTarget == "local" && spec.BuildConfig.LocalPath != "" {
+		return opts.Resolve(spec.BuildConfig.LocalPath)
+	}
+	if opts.Dir != "" {
+		return opts.Dir
+	}
+	return buildDir
+}
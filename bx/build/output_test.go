@@ -0,0 +1,67 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOwner(t *testing.T) {
+	owner, err := ParseOwner("1000:1001")
+	require.NoError(t, err)
+	assert.Equal(t, &Owner{UID: 1000, GID: 1001}, owner)
+
+	owner, err = ParseOwner(":50")
+	require.NoError(t, err)
+	assert.Equal(t, &Owner{UID: -1, GID: 50}, owner)
+
+	owner, err = ParseOwner("")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+
+	_, err = ParseOwner("root:1")
+	assert.Error(t, err)
+	_, err = ParseOwner(":")
+	assert.Error(t, err)
+}
+
+func TestOutputOptions_ModesIgnoreUmask(t *testing.T) {
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+
+	fileMode, err := ParseFileMode("0664")
+	require.NoError(t, err)
+	dirMode, err := ParseFileMode("775")
+	require.NoError(t, err)
+	opts := OutputOptions{Dir: t.TempDir(), FileMode: fileMode, DirMode: dirMode}
+
+	path := opts.Resolve(filepath.Join("cache", "app-1.0.run.yml"))
+	require.NoError(t, opts.WriteFile(path, []byte("version: \"1.0\"\n")))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0664), info.Mode().Perm())
+	info, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0775), info.Mode().Perm())
+
+	_, err = ParseFileMode("0999")
+	assert.Error(t, err)
+}
+
+func TestOutputBasePath(t *testing.T) {
+	s := &BuildService{}
+	spec := &BuildSpec{BuildConfig: BuildConfig{OutputTarget: "local"}}
+	assert.Equal(t, "/work/build", s.outputBasePath(spec, "/work/build"))
+
+	s.SetOutputOptions(OutputOptions{Dir: "/cache"})
+	assert.Equal(t, "/cache", s.outputBasePath(spec, "/work/build"))
+	spec.BuildConfig.LocalPath = "images"
+	assert.Equal(t, "/cache/images", s.outputBasePath(spec, "/work/build"))
+	spec.BuildConfig.LocalPath = "/srv/images"
+	assert.Equal(t, "/srv/images", s.outputBasePath(spec, "/work/build"))
+}
@@ -0,0 +1,65 @@
+package build
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// AllProfiles enables the services of every profile
+const AllProfiles = "*"
+
+// SelectProfiles removes from the run.yml the services whose profiles are not enabled, the services
+// without profiles are always kept. The dependencies of the kept services are kept whatever their
+// profiles, like docker compose starts them. It returns the removed services, sorted, and an error
+// when no service has one of the profiles.
+func (r *RunYAML) SelectProfiles(profiles []string) ([]string, error) {
+	known := make(map[string]bool)
+	for _, service := range r.Services {
+		for _, profile := range service.Profiles {
+			known[profile] = true
+		}
+	}
+	for _, profile := range profiles {
+		if profile != AllProfiles && !known[profile] {
+			return nil, fmt.Errorf("no service has the profile '%s'", profile)
+		}
+	}
+
+	kept := make(map[string]bool, len(r.Services))
+	var pending []string
+	for name, service := range r.Services {
+		if service.enabledBy(profiles) {
+			kept[name] = true
+			pending = append(pending, name)
+		}
+	}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dependency := range r.Services[name].DependsOn.Services() {
+			if _, ok := r.Services[dependency]; ok && !kept[dependency] {
+				kept[dependency] = true
+				pending = append(pending, dependency)
+			}
+		}
+	}
+
+	var removed []string
+	for name := range r.Services {
+		if !kept[name] {
+			removed = append(removed, name)
+			delete(r.Services, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// enabledBy tells whether the service is started with the profiles
+func (s RunService) enabledBy(profiles []string) bool {
+	if len(s.Profiles) == 0 || slices.Contains(profiles, AllProfiles) {
+		return true
+	}
+	return slices.ContainsFunc(s.Profiles, func(profile string) bool { return slices.Contains(profiles, profile) })
+}
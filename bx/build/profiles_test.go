@@ -0,0 +1,48 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectProfiles(t *testing.T) {
+	newRunYAML := func() *RunYAML {
+		return &RunYAML{Services: map[string]RunService{
+			"web":     {Image: "web:1", DependsOn: Dependencies{{Service: "db"}}},
+			"db":      {Image: "postgres:16"},
+			"adminer": {Image: "adminer", Profiles: []string{"debug"}, DependsOn: Dependencies{{Service: "cache"}}},
+			"cache":   {Image: "redis", Profiles: []string{"cache"}},
+			"worker":  {Image: "worker:1", Profiles: []string{"workers", "full"}},
+		}}
+	}
+	servicesOf := func(r *RunYAML) []string {
+		names := make(map[string]bool, len(r.Services))
+		for name := range r.Services {
+			names[name] = true
+		}
+		return sortedKeys(names)
+	}
+
+	r := newRunYAML()
+	removed, err := r.SelectProfiles(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"adminer", "cache", "worker"}, removed)
+	assert.Equal(t, []string{"db", "web"}, servicesOf(r))
+
+	// The dependencies of an enabled service are kept whatever their profiles
+	r = newRunYAML()
+	_, err = r.SelectProfiles([]string{"debug"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"adminer", "cache", "db", "web"}, servicesOf(r))
+
+	r = newRunYAML()
+	removed, err = r.SelectProfiles([]string{AllProfiles})
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.Len(t, r.Services, 5)
+
+	_, err = newRunYAML().SelectProfiles([]string{"dbug"})
+	assert.ErrorContains(t, err, "no service has the profile 'dbug'")
+}
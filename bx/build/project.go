@@ -0,0 +1,288 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrProjectNotFound = errors.New("project not found")
+
+// Valid names for the project variables and secret references
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ProjectConfig holds the variables and secret references merged into every build of a project.
+// The project is matched with BuildSpec.Name and the spec values always win.
+type ProjectConfig struct {
+	Name      string            `json:"name"`
+	Env       map[string]string `json:"env,omitempty"`
+	Secrets   []SecretSpec      `json:"secrets,omitempty"` // Only the references are stored, the values are fetched during the build
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ProjectStore keeps the project configurations, persisted in a JSON file when a path is given
+type ProjectStore struct {
+	mu       sync.RWMutex
+	path     string
+	projects map[string]*ProjectConfig
+}
+
+// NewProjectStore loads the store from path. An empty path keeps the store in memory only.
+func NewProjectStore(path string) (*ProjectStore, error) {
+	store := &ProjectStore{path: path, projects: make(map[string]*ProjectConfig)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the projects file '%s': %w", path, err)
+	}
+	var projects []*ProjectConfig
+	if err := json.Unmarshal(data, &projects); err != nil {
+		return nil, fmt.Errorf("projects file parsing failed '%s': %w", path, err)
+	}
+	for _, project := range projects {
+		store.projects[project.Name] = project
+	}
+	return store, nil
+}
+
+// List returns a copy of every project configuration, sorted by name
+func (ps *ProjectStore) List() []ProjectConfig {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	projects := make([]ProjectConfig, 0, len(ps.projects))
+	for _, project := range ps.projects {
+		projects = append(projects, project.clone())
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Name < projects[j].Name })
+	return projects
+}
+
+// Get returns a copy of the configuration of a project
+func (ps *ProjectStore) Get(name string) (*ProjectConfig, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	project, ok := ps.projects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, name)
+	}
+	config := project.clone()
+	return &config, nil
+}
+
+// SetVariable creates or updates a project variable, the project is created if needed
+func (ps *ProjectStore) SetVariable(projectName, key, value string) error {
+	if !envNamePattern.MatchString(key) {
+		return fmt.Errorf("invalid variable name '%s'", key)
+	}
+	return ps.update(projectName, true, func(project *ProjectConfig) error {
+		if project.Env == nil {
+			project.Env = make(map[string]string)
+		}
+		project.Env[key] = value
+		return nil
+	})
+}
+
+// DeleteVariable removes a project variable
+func (ps *ProjectStore) DeleteVariable(projectName, key string) error {
+	return ps.update(projectName, false, func(project *ProjectConfig) error {
+		if _, ok := project.Env[key]; !ok {
+			return fmt.Errorf("variable '%s' not defined in the project '%s'", key, projectName)
+		}
+		delete(project.Env, key)
+		return nil
+	})
+}
+
+// SetSecret creates or updates a secret reference, the project is created if needed
+func (ps *ProjectStore) SetSecret(projectName string, secret SecretSpec) error {
+	if !envNamePattern.MatchString(secret.Name) {
+		return fmt.Errorf("invalid secret name '%s'", secret.Name)
+	}
+	if secret.Source == "" {
+		return fmt.Errorf("the source of the secret '%s' is required", secret.Name)
+	}
+	return ps.update(projectName, true, func(project *ProjectConfig) error {
+		for i := range project.Secrets {
+			if project.Secrets[i].Name == secret.Name {
+				project.Secrets[i] = secret
+				return nil
+			}
+		}
+		project.Secrets = append(project.Secrets, secret)
+		return nil
+	})
+}
+
+// DeleteSecret removes a secret reference
+func (ps *ProjectStore) DeleteSecret(projectName, name string) error {
+	return ps.update(projectName, false, func(project *ProjectConfig) error {
+		for i := range project.Secrets {
+			if project.Secrets[i].Name == name {
+				project.Secrets = append(project.Secrets[:i], project.Secrets[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("secret '%s' not defined in the project '%s'", name, projectName)
+	})
+}
+
+// Delete removes a project and all its configuration
+func (ps *ProjectStore) Delete(projectName string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.projects[projectName]; !ok {
+		return fmt.Errorf("%w: %s", ErrProjectNotFound, projectName)
+	}
+	delete(ps.projects, projectName)
+	return ps.saveLocked()
+}
+
+// update applies a change to a project and persists the store, rolling back on a save error
+func (ps *ProjectStore) update(projectName string, create bool, change func(project *ProjectConfig) error) error {
+	if projectName == "" {
+		return fmt.Errorf("the project name is required")
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	previous, exists := ps.projects[projectName]
+	if !exists && !create {
+		return fmt.Errorf("%w: %s", ErrProjectNotFound, projectName)
+	}
+	project := &ProjectConfig{Name: projectName}
+	if exists {
+		clone := previous.clone()
+		project = &clone
+	}
+	if err := change(project); err != nil {
+		return err
+	}
+	project.UpdatedAt = time.Now()
+	ps.projects[projectName] = project
+	if err := ps.saveLocked(); err != nil {
+		if exists {
+			ps.projects[projectName] = previous
+		} else {
+			delete(ps.projects, projectName)
+		}
+		return err
+	}
+	return nil
+}
+
+// saveLocked writes the store atomically. ps.mu must be held.
+func (ps *ProjectStore) saveLocked() error {
+	if ps.path == "" {
+		return nil
+	}
+	projects := make([]*ProjectConfig, 0, len(ps.projects))
+	for _, project := range ps.projects {
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Name < projects[j].Name })
+	data, err := json.MarshalIndent(projects, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode the projects: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(ps.path), 0755); err != nil {
+		return fmt.Errorf("cannot create the projects directory: %w", err)
+	}
+	tmpPath := ps.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("cannot write the projects file '%s': %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, ps.path); err != nil {
+		return fmt.Errorf("cannot replace the projects file '%s': %w", ps.path, err)
+	}
+	return nil
+}
+
+func (p *ProjectConfig) clone() ProjectConfig {
+	config := *p
+	if p.Env != nil {
+		config.Env = make(map[string]string, len(p.Env))
+		for k, v := range p.Env {
+			config.Env[k] = v
+		}
+	}
+	config.Secrets = append([]SecretSpec(nil), p.Secrets...)
+	return config
+}
+
+// --- BuildService integration ---
+
+// SetProjectStore enables the project level configuration for the builds of this service
+func (s *BuildService) SetProjectStore(store *ProjectStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.projects = store
+}
+
+// Projects returns the project store, nil if none is configured
+func (s *BuildService) Projects() *ProjectStore {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.projects
+}
+
+// mergeProjectEnv adds the project variables which are not already set by the spec
+func (s *BuildService) mergeProjectEnv(spec *BuildSpec, env map[string]string) int {
+	project := s.projectConfig(spec.Name)
+	if project == nil {
+		return 0
+	}
+	added := 0
+	for k, v := range project.Env {
+		if _, exists := env[k]; !exists {
+			env[k] = v
+			added++
+		}
+	}
+	return added
+}
+
+// effectiveSecrets returns the spec secrets followed by the project ones the spec does not override
+func (s *BuildService) effectiveSecrets(spec *BuildSpec) []SecretSpec {
+	project := s.projectConfig(spec.Name)
+	if project == nil || len(project.Secrets) == 0 {
+		return spec.Secrets
+	}
+	secrets := append([]SecretSpec(nil), spec.Secrets...)
+	defined := make(map[string]bool, len(spec.Secrets))
+	for _, secret := range spec.Secrets {
+		defined[secret.Name] = true
+	}
+	for _, secret := range project.Secrets {
+		if !defined[secret.Name] {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+func (s *BuildService) projectConfig(name string) *ProjectConfig {
+	store := s.Projects()
+	if store == nil {
+		return nil
+	}
+	project, err := store.Get(name)
+	if err != nil {
+		return nil
+	}
+	return project
+}
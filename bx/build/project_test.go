@@ -0,0 +1,48 @@
+package build
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectStore_PersistAndMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	store, err := NewProjectStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetVariable("shop", "LOG_LEVEL", "info"))
+	require.NoError(t, store.SetVariable("shop", "REGION", "eu-west"))
+	require.NoError(t, store.SetSecret("shop", SecretSpec{Name: "DB_PASSWORD", Source: "vault:shop/db"}))
+	require.NoError(t, store.SetSecret("shop", SecretSpec{Name: "API_TOKEN", Source: "vault:shop/api"}))
+	assert.Error(t, store.SetVariable("shop", "BAD-NAME", "x"))
+	assert.ErrorIs(t, store.DeleteVariable("unknown", "LOG_LEVEL"), ErrProjectNotFound)
+
+	// Reload from the disk
+	store, err = NewProjectStore(path)
+	require.NoError(t, err)
+	project, err := store.Get("shop")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west", project.Env["REGION"])
+	assert.Len(t, project.Secrets, 2)
+
+	s := &BuildService{}
+	s.SetProjectStore(store)
+	spec := &BuildSpec{
+		Name:    "shop",
+		Secrets: []SecretSpec{{Name: "API_TOKEN", Source: "vault:override"}},
+	}
+	env := map[string]string{"LOG_LEVEL": "debug"} // Spec level value
+	assert.Equal(t, 1, s.mergeProjectEnv(spec, env))
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "debug", "REGION": "eu-west"}, env)
+
+	secrets := s.effectiveSecrets(spec)
+	require.Len(t, secrets, 2)
+	assert.Equal(t, "vault:override", secrets[0].Source)
+	assert.Equal(t, "DB_PASSWORD", secrets[1].Name)
+
+	require.NoError(t, store.Delete("shop"))
+	assert.Empty(t, store.List())
+}
@@ -0,0 +1,531 @@
+package build
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of finished builds kept in memory for the status queries
+const maxFinishedBuilds = 100
+
+// Number of recent build durations averaged by the wait estimates
+const maxRecentDurations = 20
+
+var (
+	ErrBuildNotFound = errors.New("build not found")
+	ErrBuildFinished = errors.New("the build is already finished")
+	ErrBuildCanceled = errors.New("the build was canceled")
+	ErrQueueStopped  = errors.New("the build queue is stopped")
+)
+
+// BuildState is the lifecycle state of a build submitted to the queue
+type BuildState string
+
+const (
+	BuildStateQueued   BuildState = "queued"
+	BuildStateRunning  BuildState = "running"
+	BuildStateSuccess  BuildState = "success"
+	BuildStateFailure  BuildState = "failure"
+	BuildStateCanceled BuildState = "canceled"
+)
+
+// IsFinal reports if the build will not change state anymore
+func (st BuildState) IsFinal() bool {
+	return st == BuildStateSuccess || st == BuildStateFailure || st == BuildStateCanceled
+}
+
+// BuildStatus is a snapshot of a build known by the queue
+type BuildStatus struct {
+	BuildID     string       `json:"build_id"`
+	Name        string       `json:"name"`
+	Version     string       `json:"version"`
+	Branch      string       `json:"branch,omitempty"` // Branch of the first codebase, if any
+	Priority    int          `json:"priority"`
+	State       BuildState   `json:"state"`
+	Position    int          `json:"position,omitempty"` // 1 based position in the queue while the build is queued
+	SubmittedAt time.Time    `json:"submitted_at"`
+	StartedAt   *time.Time   `json:"started_at,omitempty"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	ErrorCode   ErrorCode    `json:"error_code,omitempty"` // Code of the failure when the build returned a *BuildError
+	Result      *BuildResult `json:"result,omitempty"`     // Only for the builds submitted with Submit
+}
+
+// buildJob is a queued unit of work
+type buildJob struct {
+	status BuildStatus
+	seq    uint64 // Submission order, keeps the queue FIFO between equal priorities
+	index  int    // Position in the heap, -1 when not pending
+	run    func(ctx context.Context) (*BuildResult, error)
+	done   chan struct{}
+	cancel context.CancelFunc // Interrupts the running job, nil while pending
+	// Set by cancelBuild: the running job ends as canceled whatever its error
+	canceled bool
+
+	onCanceled func(status BuildStatus) // Called when the job is canceled before its start, nil to skip
+
+	onQueued func(position int, estimatedWait time.Duration) // Called when the position changes, nil to skip
+	notified int                                             // Last position passed to onQueued
+}
+
+// jobHeap orders the pending jobs by priority (highest first) then submission order
+type jobHeap []*buildJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].status.Priority != h[j].status.Priority {
+		return h[i].status.Priority > h[j].status.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *jobHeap) Push(x any) {
+	job := x.(*buildJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+func (h *jobHeap) Pop() any {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	job.index = -1
+	*h = old[:n-1]
+	return job
+}
+
+// buildQueue runs the submitted builds on a fixed number of workers
+type buildQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  jobHeap
+	jobs     map[string]*buildJob
+	finished []string // Finished build IDs, oldest first
+	seq      uint64
+	stopped  bool
+	onFinish func(status BuildStatus) // Called outside of the lock once a build is finished
+	workers  int
+	idle     int             // Workers without job
+	recent   []time.Duration // Durations of the last builds, oldest first
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBuildQueue(workers int) *buildQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &buildQueue{
+		jobs:    make(map[string]*buildJob),
+		workers: workers,
+		idle:    workers,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return q
+}
+
+// enqueue adds a job to the queue. onQueued and onCanceled, when not nil, are called when the position
+// of the pending job changes and when it is canceled before its start, by cancelBuild or stop.
+func (q *buildQueue) enqueue(buildID, name, version, branch string, priority int, onQueued func(position int, estimatedWait time.Duration), onCanceled func(status BuildStatus), run func(ctx context.Context) (*BuildResult, error)) error {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return ErrQueueStopped
+	}
+	if _, exists := q.jobs[buildID]; exists {
+		q.mu.Unlock()
+		return fmt.Errorf("a build with the ID '%s' is already known by the queue", buildID)
+	}
+	q.seq++
+	job := &buildJob{
+		status: BuildStatus{
+			BuildID:     buildID,
+			Name:        name,
+			Version:     version,
+			Branch:      branch,
+			Priority:    priority,
+			State:       BuildStateQueued,
+			SubmittedAt: time.Now(),
+		},
+		seq:        q.seq,
+		run:        run,
+		done:       make(chan struct{}),
+		onQueued:   onQueued,
+		onCanceled: onCanceled,
+	}
+	q.jobs[buildID] = job
+	heap.Push(&q.pending, job)
+	q.cond.Signal()
+	updates := q.positionUpdatesLocked()
+	q.mu.Unlock()
+
+	for _, update := range updates {
+		update()
+	}
+	return nil
+}
+
+// positionUpdatesLocked returns the notifications of the pending jobs which moved in the queue,
+// except the ones an idle worker is about to start. q.mu must be held.
+func (q *buildQueue) positionUpdatesLocked() []func() {
+	var updates []func()
+	for _, job := range q.pending {
+		if job.onQueued == nil {
+			continue
+		}
+		position := q.positionLocked(job)
+		if position <= q.idle || position == job.notified {
+			continue
+		}
+		job.notified = position
+		onQueued, wait := job.onQueued, q.estimatedWaitLocked(position)
+		updates = append(updates, func() { onQueued(position, wait) })
+	}
+	return updates
+}
+
+// estimatedWaitLocked estimates the wait of the job at a position from the average duration of the
+// recent builds, 0 without history. q.mu must be held.
+func (q *buildQueue) estimatedWaitLocked(position int) time.Duration {
+	if len(q.recent) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, duration := range q.recent {
+		total += duration
+	}
+	// The jobs ahead are run by all the workers, a build at least is running on each
+	rounds := (position + q.workers - 1) / q.workers
+	return total / time.Duration(len(q.recent)) * time.Duration(rounds)
+}
+
+func (q *buildQueue) worker() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mu.Unlock()
+			return
+		}
+		job := heap.Pop(&q.pending).(*buildJob)
+		q.idle--
+		startedAt := time.Now()
+		job.status.State = BuildStateRunning
+		job.status.StartedAt = &startedAt
+		ctx, cancel := context.WithCancel(q.ctx)
+		job.cancel = cancel
+		updates := q.positionUpdatesLocked()
+		q.mu.Unlock()
+
+		for _, update := range updates {
+			update()
+		}
+
+		result, err := q.runJob(ctx, job)
+		cancel()
+
+		q.mu.Lock()
+		finishedAt := time.Now()
+		job.status.FinishedAt = &finishedAt
+		job.status.Result = result
+		q.idle++
+		q.recent = append(q.recent, finishedAt.Sub(startedAt))
+		if len(q.recent) > maxRecentDurations {
+			q.recent = q.recent[1:]
+		}
+		switch {
+		case job.canceled:
+			job.status.State = BuildStateCanceled
+			job.status.Error = ErrBuildCanceled.Error()
+		case err != nil && q.ctx.Err() != nil:
+			job.status.State = BuildStateCanceled
+			job.status.Error = err.Error()
+		case err != nil:
+			job.status.State = BuildStateFailure
+			job.status.Error = err.Error()
+			job.status.ErrorCode = ErrorCodeOf(err)
+		default:
+			job.status.State = BuildStateSuccess
+		}
+		q.markFinishedLocked(job)
+		finalStatus := job.status
+		onFinish := q.onFinish
+		q.mu.Unlock()
+
+		if onFinish != nil {
+			onFinish(finalStatus)
+		}
+	}
+}
+
+// runJob isolates the workers from a panicking build
+func (q *buildQueue) runJob(ctx context.Context, job *buildJob) (result *BuildResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic during build: %v", r)
+		}
+	}()
+	return job.run(ctx)
+}
+
+// cancelBuild removes a pending build from the queue or interrupts a running one, which is marked
+// canceled once its run returns. The returned status is taken at the cancellation: canceled for a
+// pending build, still running for a running one.
+func (q *buildQueue) cancelBuild(buildID string) (*BuildStatus, error) {
+	q.mu.Lock()
+	job, ok := q.jobs[buildID]
+	if !ok {
+		q.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrBuildNotFound, buildID)
+	}
+	switch job.status.State {
+	case BuildStateRunning:
+		job.canceled = true
+		job.cancel()
+		status := job.status
+		q.mu.Unlock()
+		return &status, nil
+	case BuildStateQueued:
+	default:
+		q.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s is %s", ErrBuildFinished, buildID, job.status.State)
+	}
+
+	heap.Remove(&q.pending, job.index)
+	now := time.Now()
+	job.status.State = BuildStateCanceled
+	job.status.FinishedAt = &now
+	job.status.Error = ErrBuildCanceled.Error()
+	q.markFinishedLocked(job)
+	status := job.status
+	updates := q.positionUpdatesLocked()
+	onCanceled, onFinish := job.onCanceled, q.onFinish
+	q.mu.Unlock()
+
+	for _, update := range updates {
+		update()
+	}
+	if onCanceled != nil {
+		onCanceled(status)
+	}
+	if onFinish != nil {
+		onFinish(status)
+	}
+	return &status, nil
+}
+
+// markFinishedLocked closes the job and trims the history. q.mu must be held.
+func (q *buildQueue) markFinishedLocked(job *buildJob) {
+	close(job.done)
+	q.finished = append(q.finished, job.status.BuildID)
+	for len(q.finished) > maxFinishedBuilds {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// positionLocked computes the 1 based position of a pending job. q.mu must be held.
+func (q *buildQueue) positionLocked(job *buildJob) int {
+	if job.index < 0 {
+		return 0
+	}
+	position := 1
+	for _, other := range q.pending {
+		if other != job && q.pending.Less(other.index, job.index) {
+			position++
+		}
+	}
+	return position
+}
+
+func (q *buildQueue) status(buildID string) (*BuildStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[buildID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBuildNotFound, buildID)
+	}
+	status := job.status
+	status.Position = q.positionLocked(job)
+	return &status, nil
+}
+
+func (q *buildQueue) list() []BuildStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	statuses := make([]BuildStatus, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		status := job.status
+		status.Position = q.positionLocked(job)
+		status.Result = nil // Keep the listing light, use GetStatus for the details
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].SubmittedAt.Before(statuses[j].SubmittedAt)
+	})
+	return statuses
+}
+
+func (q *buildQueue) wait(ctx context.Context, buildID string) (*BuildStatus, error) {
+	q.mu.Lock()
+	job, ok := q.jobs[buildID]
+	q.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBuildNotFound, buildID)
+	}
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := job.status
+	return &status, nil
+}
+
+// stop cancels the pending builds, interrupts the running ones and waits for the workers
+func (q *buildQueue) stop() {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	q.stopped = true
+	now := time.Now()
+	var canceled []func()
+	for len(q.pending) > 0 {
+		job := heap.Pop(&q.pending).(*buildJob)
+		job.status.State = BuildStateCanceled
+		job.status.FinishedAt = &now
+		job.status.Error = ErrQueueStopped.Error()
+		q.markFinishedLocked(job)
+		if job.onCanceled != nil {
+			onCanceled, status := job.onCanceled, job.status
+			canceled = append(canceled, func() { onCanceled(status) })
+		}
+	}
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	for _, onCanceled := range canceled {
+		onCanceled()
+	}
+	q.cancel()
+	q.wg.Wait()
+}
+
+// --- BuildService queue API ---
+
+// SetQueueWorkers sets the number of builds processed simultaneously by the queue (1 by default).
+// It must be called before the first submission.
+func (s *BuildService) SetQueueWorkers(workers int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queueWorkers = workers
+}
+
+// getQueue starts the queue on first use
+func (s *BuildService) getQueue() *buildQueue {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.queue == nil {
+		s.queue = newBuildQueue(s.queueWorkers)
+		s.queue.onFinish = s.notifyFinished
+	}
+	return s.queue
+}
+
+// Submit queues a build and returns its ID immediately. Builds with a higher priority are started first.
+func (s *BuildService) Submit(spec *BuildSpec, priority int) (string, error) {
+	return s.SubmitWithEvents(spec, priority, nil)
+}
+
+// SubmitWithEvents queues a build like Submit and sends its events to the channel, see BuildWithEvents.
+// Once the build is queued the channel is always closed, also when the build is canceled before its start.
+func (s *BuildService) SubmitWithEvents(spec *BuildSpec, priority int, eventsCh chan<- BuildEvent) (string, error) {
+	buildID := fmt.Sprintf("%s-%s-%d", spec.Name, spec.Version, time.Now().UnixNano())
+	var onCanceled func(status BuildStatus)
+	if eventsCh != nil {
+		onCanceled = func(status BuildStatus) { close(eventsCh) }
+	}
+	err := s.getQueue().enqueue(buildID, spec.Name, spec.Version, specBranch(spec), priority, nil, onCanceled, func(ctx context.Context) (*BuildResult, error) {
+		return s.BuildWithEvents(ctx, spec, eventsCh)
+	})
+	if err != nil {
+		return "", err
+	}
+	return buildID, nil
+}
+
+// specBranch returns the branch of the first codebase, used to filter the notifications
+func specBranch(spec *BuildSpec) string {
+	if len(spec.Codebases) == 0 {
+		return ""
+	}
+	return spec.Codebases[0].Branch
+}
+
+// GetStatus returns the state of a queued, running or recently finished build
+func (s *BuildService) GetStatus(buildID string) (*BuildStatus, error) {
+	return s.getQueue().status(buildID)
+}
+
+// ListBuilds returns the builds known by the queue, oldest submission first
+func (s *BuildService) ListBuilds() []BuildStatus {
+	return s.getQueue().list()
+}
+
+// WaitBuild blocks until the build is finished or the context is done
+func (s *BuildService) WaitBuild(ctx context.Context, buildID string) (*BuildStatus, error) {
+	return s.getQueue().wait(ctx, buildID)
+}
+
+// CancelBuild removes a queued build from the queue or interrupts a running one. It returns
+// ErrBuildNotFound for an unknown build and ErrBuildFinished for a finished one.
+func (s *BuildService) CancelBuild(buildID string) (*BuildStatus, error) {
+	return s.getQueue().cancelBuild(buildID)
+}
+
+// QueuePosition returns the 1 based position of a queued build, false if the build is not waiting
+func (s *BuildService) QueuePosition(buildID string) (int, bool) {
+	status, err := s.GetStatus(buildID)
+	if err != nil || status.State != BuildStateQueued {
+		return 0, false
+	}
+	return status.Position, true
+}
+
+// StopQueue cancels the pending builds and waits for the running ones to return
+func (s *BuildService) StopQueue() {
+	s.mutex.Lock()
+	queue := s.queue
+	s.mutex.Unlock()
+	if queue != nil {
+		queue.stop()
+	}
+}
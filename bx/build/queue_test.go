@@ -0,0 +1,212 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/socket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildQueue_PriorityAndStatus(t *testing.T) {
+	q := newBuildQueue(1)
+	defer q.stop()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	job := func(id string, err error) func(ctx context.Context) (*BuildResult, error) {
+		return func(ctx context.Context) (*BuildResult, error) {
+			<-release
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+			return &BuildResult{Success: err == nil}, err
+		}
+	}
+
+	require.NoError(t, q.enqueue("first", "app", "1.0", "", 0, nil, nil, job("first", nil)))
+	// Wait for the single worker to pick the first build
+	require.Eventually(t, func() bool {
+		st, err := q.status("first")
+		return err == nil && st.State == BuildStateRunning
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, q.enqueue("low", "app", "1.0", "", 0, nil, nil, job("low", errors.New("boom"))))
+	require.NoError(t, q.enqueue("high", "app", "1.0", "", 10, nil, nil, job("high", nil)))
+	require.Error(t, q.enqueue("high", "app", "1.0", "", 10, nil, nil, job("high", nil)), "duplicated IDs must be rejected")
+
+	st, err := q.status("high")
+	require.NoError(t, err)
+	assert.Equal(t, BuildStateQueued, st.State)
+	assert.Equal(t, 1, st.Position)
+	st, err = q.status("low")
+	require.NoError(t, err)
+	assert.Equal(t, 2, st.Position)
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	final, err := q.wait(ctx, "low")
+	require.NoError(t, err)
+	assert.Equal(t, BuildStateFailure, final.State)
+	assert.Equal(t, "boom", final.Error)
+
+	assert.Equal(t, []string{"first", "high", "low"}, order)
+	assert.Len(t, q.list(), 3)
+
+	_, err = q.status("unknown")
+	assert.ErrorIs(t, err, ErrBuildNotFound)
+}
+
+func TestBuildQueue_Cancel(t *testing.T) {
+	q := newBuildQueue(1)
+	defer q.stop()
+
+	running := func(ctx context.Context) (*BuildResult, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	var canceled []BuildStatus
+	require.NoError(t, q.enqueue("running", "app", "1.0", "", 0, nil, nil, running))
+	require.Eventually(t, func() bool {
+		st, err := q.status("running")
+		return err == nil && st.State == BuildStateRunning
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, q.enqueue("pending", "app", "1.0", "", 0, nil, func(status BuildStatus) {
+		canceled = append(canceled, status)
+	}, func(ctx context.Context) (*BuildResult, error) {
+		t.Error("a canceled build must not run")
+		return nil, nil
+	}))
+	require.NoError(t, q.enqueue("next", "app", "1.0", "", 0, nil, nil, running))
+
+	st, err := q.cancelBuild("pending")
+	require.NoError(t, err)
+	assert.Equal(t, BuildStateCanceled, st.State)
+	require.Len(t, canceled, 1)
+	assert.Equal(t, "pending", canceled[0].BuildID)
+	st, err = q.status("next")
+	require.NoError(t, err)
+	assert.Equal(t, 1, st.Position, "the next builds move up")
+
+	st, err = q.cancelBuild("running")
+	require.NoError(t, err)
+	assert.Equal(t, BuildStateRunning, st.State, "a running build is canceled once its run returns")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	final, err := q.wait(ctx, "running")
+	require.NoError(t, err)
+	assert.Equal(t, BuildStateCanceled, final.State)
+	assert.Equal(t, ErrBuildCanceled.Error(), final.Error)
+
+	_, err = q.cancelBuild("running")
+	assert.ErrorIs(t, err, ErrBuildFinished)
+	_, err = q.cancelBuild("unknown")
+	assert.ErrorIs(t, err, ErrBuildNotFound)
+
+	// The other builds go on
+	st, err = q.status("next")
+	require.NoError(t, err)
+	assert.NotEqual(t, BuildStateCanceled, st.State)
+}
+
+func TestBuildQueue_PositionUpdates(t *testing.T) {
+	q := newBuildQueue(1)
+	defer q.stop()
+	q.recent = []time.Duration{10 * time.Second, 20 * time.Second}
+
+	release := make(chan struct{})
+	job := func(ctx context.Context) (*BuildResult, error) {
+		<-release
+		return &BuildResult{Success: true}, nil
+	}
+	type update struct {
+		position int
+		wait     time.Duration
+	}
+	var mu sync.Mutex
+	var updates []update
+	onQueued := func(position int, estimatedWait time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, update{position, estimatedWait})
+	}
+
+	// The build started by the idle worker is not reported
+	require.NoError(t, q.enqueue("first", "app", "1.0", "", 0, onQueued, nil, job))
+	require.Eventually(t, func() bool {
+		st, err := q.status("first")
+		return err == nil && st.State == BuildStateRunning
+	}, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, updates)
+	mu.Unlock()
+
+	require.NoError(t, q.enqueue("low", "app", "1.0", "", 0, onQueued, nil, job))
+	require.NoError(t, q.enqueue("high", "app", "1.0", "", 10, nil, nil, job))
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := q.wait(ctx, "low")
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, updates, 3)
+	assert.Equal(t, update{1, 15 * time.Second}, updates[0])
+	assert.Equal(t, update{2, 30 * time.Second}, updates[1])
+	assert.Equal(t, 1, updates[2].position, "moved up once the high priority build started")
+}
+
+func TestBuildService_HandleBuildQueries(t *testing.T) {
+	s := &BuildService{}
+	defer s.StopQueue()
+	queue := s.getQueue()
+	release := make(chan struct{})
+	job := func(err error) func(ctx context.Context) (*BuildResult, error) {
+		return func(ctx context.Context) (*BuildResult, error) {
+			<-release
+			return nil, err
+		}
+	}
+	require.NoError(t, queue.enqueue("api-1", "api", "1.0", "main", 0, nil, nil, job(nil)))
+	require.NoError(t, queue.enqueue("web-1", "web", "1.0", "", 0, nil, nil, job(newBuildError(CodeBuildStep, errors.New("step failed")))))
+	require.NoError(t, queue.enqueue("api-2", "api", "1.1", "main", 0, nil, nil, job(nil)))
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := queue.wait(ctx, "api-2")
+	require.NoError(t, err)
+
+	list, err := s.HandleBuildList(ctx, socket.BuildListPayload{Name: "api"})
+	require.NoError(t, err)
+	require.Len(t, list.Builds, 2)
+	assert.Equal(t, "api-2", list.Builds[0].BuildID, "newest first")
+	assert.Equal(t, "success", list.Builds[0].State)
+	assert.NotNil(t, list.Builds[0].DurationSec)
+
+	list, err = s.HandleBuildList(ctx, socket.BuildListPayload{Limit: 1})
+	require.NoError(t, err)
+	assert.Len(t, list.Builds, 1)
+	_, err = s.HandleBuildList(ctx, socket.BuildListPayload{State: "done"})
+	assert.Error(t, err)
+
+	failed, err := s.HandleBuildGet(ctx, socket.BuildGetPayload{BuildID: "web-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "failure", failed.Build.State)
+	assert.Equal(t, string(CodeBuildStep), failed.Build.ErrorCode)
+	_, err = s.HandleBuildGet(ctx, socket.BuildGetPayload{BuildID: "unknown"})
+	assert.ErrorIs(t, err, ErrBuildNotFound)
+	assert.Equal(t, socket.ErrCodeBuildNotFound, socket.CodeOf(err))
+
+	_, err = s.HandleBuildCancel(ctx, socket.BuildCancelPayload{BuildID: "web-1"})
+	assert.Equal(t, socket.ErrCodeBuildFinished, socket.CodeOf(err))
+	_, err = s.HandleBuildCancel(ctx, socket.BuildCancelPayload{BuildID: "unknown"})
+	assert.Equal(t, socket.ErrCodeBuildNotFound, socket.CodeOf(err))
+}
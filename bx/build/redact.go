@@ -0,0 +1,84 @@
+package build
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Replacement of the redacted values in the logs
+const redactedPlaceholder = "[REDACTED]"
+
+// Secrets shorter than this are not redacted, they would mask unrelated words of the logs
+const minRedactedSecretLength = 4
+
+// Common token formats redacted even when they are not known secrets
+var tokenPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),                               // AWS access key ID
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),                            // GitHub tokens
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`),                          // GitHub fine-grained tokens
+	regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}\b`),                             // GitLab tokens
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9\-]{10,}\b`),                         // Slack tokens
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`), // JWT
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// Assignments whose value is redacted, the key is kept (e.g. "password=xxx" -> "password=[REDACTED]")
+var secretAssignmentPattern = regexp.MustCompile(`(?i)\b([A-Za-z0-9_]*(?:password|passwd|secret|token|api[_-]?key)[A-Za-z0-9_]*)(\s*[=:]\s*|"\s*:\s*")([^\s"',;]+)`)
+
+// Authorization headers
+var bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]{8,}=*`)
+
+// Redactor scrubs the known secret values and the common token formats from the logs
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string // Longest first so a secret containing another one is fully masked
+}
+
+// NewRedactor returns a redactor with no known secret, only the token patterns are masked
+func NewRedactor() *Redactor {
+	return &Redactor{}
+}
+
+// AddSecret registers a value to mask in every redacted text
+func (r *Redactor) AddSecret(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minRedactedSecretLength {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, known := range r.secrets {
+		if known == value {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, value)
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+// Redact returns the text with the secrets and tokens replaced
+func (r *Redactor) Redact(text string) string {
+	if text == "" {
+		return text
+	}
+	r.mu.RLock()
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redactedPlaceholder)
+	}
+	r.mu.RUnlock()
+
+	for _, pattern := range tokenPatterns {
+		text = pattern.ReplaceAllString(text, redactedPlaceholder)
+	}
+	text = bearerPattern.ReplaceAllString(text, "$1 "+redactedPlaceholder)
+	text = secretAssignmentPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := secretAssignmentPattern.FindStringSubmatch(match)
+		if groups[3] == redactedPlaceholder {
+			return match
+		}
+		return groups[1] + groups[2] + redactedPlaceholder
+	})
+	return text
+}
@@ -0,0 +1,303 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// Host of the Docker Hub and the key of its credentials in the docker config and the daemon API
+const (
+	dockerHubHost       = "docker.io"
+	dockerHubAuthServer = "https://index.docker.io/v1/"
+)
+
+// Username returned by the credential helpers when the secret is an identity token
+const identityTokenUsername = "<token>"
+
+type registryAuthsContextKey struct{}
+
+// registryAuths holds the credentials used to pull the base images and the cache and to push the
+// built images: the registries block of the spec first, then the docker config of the user
+type registryAuths struct {
+	spec         map[string]registry.AuthConfig // By normalized host
+	dockerConfig *dockerConfigFile              // nil when the user has no docker config
+}
+
+// withRegistryAuths makes the registry credentials available to the image builds, pulls and pushes
+func withRegistryAuths(ctx context.Context, auths *registryAuths) context.Context {
+	return context.WithValue(ctx, registryAuthsContextKey{}, auths)
+}
+
+// registryAuthsFrom returns the registry credentials of the build running with ctx, the docker
+// config of the user alone when the build didn't set them
+func registryAuthsFrom(ctx context.Context) *registryAuths {
+	if auths, ok := ctx.Value(registryAuthsContextKey{}).(*registryAuths); ok {
+		return auths
+	}
+	return &registryAuths{dockerConfig: loadDockerConfig()}
+}
+
+// resolveRegistryAuths fetches the password or the token of each registry of the spec
+func resolveRegistryAuths(ctx context.Context, fetcher SecretFetcher, registries []RegistryConfig) (*registryAuths, error) {
+	auths := &registryAuths{spec: make(map[string]registry.AuthConfig, len(registries)), dockerConfig: loadDockerConfig()}
+	for _, config := range registries {
+		if fetcher == nil && (config.Password != "" || config.Token != "") {
+			return nil, fmt.Errorf("no secret fetcher configured for the credentials of the registry '%s'", config.Host)
+		}
+		host := normalizeRegistryHost(config.Host)
+		auth := registry.AuthConfig{Username: config.Username, ServerAddress: registryServerAddress(host)}
+		if config.Password != "" {
+			password, err := fetcher.GetSecret(ctx, config.Password)
+			if err != nil {
+				return nil, fmt.Errorf("cannot fetch the password of the registry '%s': %w", config.Host, err)
+			}
+			auth.Password = password
+		}
+		if config.Token != "" {
+			token, err := fetcher.GetSecret(ctx, config.Token)
+			if err != nil {
+				return nil, fmt.Errorf("cannot fetch the token of the registry '%s': %w", config.Host, err)
+			}
+			auth.RegistryToken = token
+		}
+		auths.spec[host] = auth
+	}
+	return auths, nil
+}
+
+// Secrets returns the credential values of the spec, to redact them from the logs
+func (a *registryAuths) Secrets() []string {
+	var secrets []string
+	for _, auth := range a.spec {
+		for _, secret := range []string{auth.Password, auth.RegistryToken} {
+			if secret != "" {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+	return secrets
+}
+
+// ForHost returns the credentials of a registry host
+func (a *registryAuths) ForHost(host string) (registry.AuthConfig, bool) {
+	host = normalizeRegistryHost(host)
+	if auth, ok := a.spec[host]; ok {
+		return auth, true
+	}
+	if a.dockerConfig == nil {
+		return registry.AuthConfig{}, false
+	}
+	return a.dockerConfig.auth(host)
+}
+
+// EncodedForImage returns the X-Registry-Auth header of the registry of an image reference,
+// an empty string when the registry has no credentials
+func (a *registryAuths) EncodedForImage(imageRef string) (string, error) {
+	auth, ok := a.ForHost(imageRegistryHost(imageRef))
+	if !ok {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(auth)
+}
+
+// All returns every known credential keyed as the daemon expects them in a build request, the
+// daemon picks the ones of the registries used by the Dockerfile
+func (a *registryAuths) All() map[string]registry.AuthConfig {
+	all := make(map[string]registry.AuthConfig)
+	if a.dockerConfig != nil {
+		for _, host := range a.dockerConfig.hosts() {
+			if auth, ok := a.dockerConfig.auth(host); ok {
+				all[registryServerAddress(host)] = auth
+			}
+		}
+	}
+	for host, auth := range a.spec {
+		all[registryServerAddress(host)] = auth
+	}
+	return all
+}
+
+// writeDockerConfig writes a docker config holding the credentials of the spec and of the user in
+// dir, for the docker CLI builds. It returns false when the spec has no registries, the CLI then
+// keeps using the config of the user.
+func (a *registryAuths) writeDockerConfig(dir string) (bool, error) {
+	if len(a.spec) == 0 {
+		return false, nil
+	}
+	config := dockerConfigFile{Auths: make(map[string]dockerConfigAuth)}
+	for server, auth := range a.All() {
+		entry := dockerConfigAuth{IdentityToken: auth.IdentityToken, RegistryToken: auth.RegistryToken}
+		if auth.Username != "" || auth.Password != "" {
+			entry.Auth = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		}
+		config.Auths[server] = entry
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0600); err != nil {
+		return false, fmt.Errorf("cannot write the docker config: %w", err)
+	}
+	return true, nil
+}
+
+// imageRegistryHost returns the registry host of an image reference, docker.io for the official
+// and the user images of the Docker Hub
+func imageRegistryHost(imageRef string) string {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return dockerHubHost
+	}
+	return reference.Domain(named)
+}
+
+// normalizeRegistryHost returns the host of a registry address ("https://ghcr.io/v2/" -> "ghcr.io"),
+// every alias of the Docker Hub is docker.io
+func normalizeRegistryHost(address string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubHost
+	}
+	return host
+}
+
+// registryServerAddress returns the key of the credentials of a host in the daemon API
+func registryServerAddress(host string) string {
+	if host == dockerHubHost {
+		return dockerHubAuthServer
+	}
+	return host
+}
+
+// dockerConfigFile is the part of ~/.docker/config.json holding the registry credentials
+type dockerConfigFile struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore,omitempty"`
+	CredHelpers map[string]string           `json:"credHelpers,omitempty"`
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth,omitempty"` // base64 of "username:password"
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// loadDockerConfig reads the docker config of the user ($DOCKER_CONFIG/config.json or
+// ~/.docker/config.json), nil when it is missing or invalid
+func loadDockerConfig() *dockerConfigFile {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil
+	}
+	var config dockerConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil
+	}
+	// The entries are keyed by URL or by host depending on the docker version which wrote them
+	auths := make(map[string]dockerConfigAuth, len(config.Auths))
+	for address, auth := range config.Auths {
+		auths[normalizeRegistryHost(address)] = auth
+	}
+	config.Auths = auths
+	helpers := make(map[string]string, len(config.CredHelpers))
+	for address, helper := range config.CredHelpers {
+		helpers[normalizeRegistryHost(address)] = helper
+	}
+	config.CredHelpers = helpers
+	return &config
+}
+
+// hosts returns the hosts with credentials in the config file or in a per-registry helper
+func (c *dockerConfigFile) hosts() []string {
+	hosts := make([]string, 0, len(c.Auths)+len(c.CredHelpers))
+	for host := range c.Auths {
+		hosts = append(hosts, host)
+	}
+	for host := range c.CredHelpers {
+		if _, ok := c.Auths[host]; !ok {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// auth returns the credentials of a host, from its credential helper, the credentials store or
+// the auths section, in the docker CLI order
+func (c *dockerConfigFile) auth(host string) (registry.AuthConfig, bool) {
+	if helper := c.CredHelpers[host]; helper != "" {
+		return credentialHelperAuth(helper, host)
+	}
+	if c.CredsStore != "" {
+		if auth, ok := credentialHelperAuth(c.CredsStore, host); ok {
+			return auth, true
+		}
+	}
+	entry, ok := c.Auths[host]
+	if !ok {
+		return registry.AuthConfig{}, false
+	}
+	auth := registry.AuthConfig{
+		Username:      entry.Username,
+		Password:      entry.Password,
+		IdentityToken: entry.IdentityToken,
+		RegistryToken: entry.RegistryToken,
+		ServerAddress: registryServerAddress(host),
+	}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return registry.AuthConfig{}, false
+		}
+		auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+	}
+	return auth, true
+}
+
+// credentialHelperAuth asks a docker credential helper (docker-credential-<helper>) for the
+// credentials of a host
+func credentialHelperAuth(helper, host string) (registry.AuthConfig, bool) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registryServerAddress(host))
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return registry.AuthConfig{}, false
+	}
+	var credentials struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil || credentials.Secret == "" {
+		return registry.AuthConfig{}, false
+	}
+	auth := registry.AuthConfig{ServerAddress: registryServerAddress(host)}
+	if credentials.Username == identityTokenUsername {
+		auth.IdentityToken = credentials.Secret
+	} else {
+		auth.Username, auth.Password = credentials.Username, credentials.Secret
+	}
+	return auth, true
+}
@@ -0,0 +1,93 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryAuths_SpecAndDockerConfig(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	userConfig := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hubuser:hubpass")) + `"},
+		"ghcr.io": {"username": "old", "password": "stale"}
+	}}`
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(userConfig), 0600))
+
+	fetcher := &MockSecretFetcher{Secrets: map[string]string{"vault://ghcr": "pat", "vault://internal": "bearer"}}
+	auths, err := resolveRegistryAuths(context.Background(), fetcher, []RegistryConfig{
+		{Host: "https://ghcr.io", Username: "ci", Password: "vault://ghcr"},
+		{Host: "registry.example.com:5000", Token: "vault://internal"},
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"pat", "bearer"}, auths.Secrets())
+
+	// The spec wins over the docker config, which still covers the other registries
+	auth, ok := auths.ForHost(imageRegistryHost("ghcr.io/acme/api:1.0"))
+	require.True(t, ok)
+	assert.Equal(t, registry.AuthConfig{Username: "ci", Password: "pat", ServerAddress: "ghcr.io"}, auth)
+	auth, ok = auths.ForHost(imageRegistryHost("postgres:16"))
+	require.True(t, ok)
+	assert.Equal(t, registry.AuthConfig{Username: "hubuser", Password: "hubpass", ServerAddress: dockerHubAuthServer}, auth)
+	_, ok = auths.ForHost("quay.io")
+	assert.False(t, ok)
+
+	encoded, err := auths.EncodedForImage("registry.example.com:5000/team/app")
+	require.NoError(t, err)
+	decoded, err := registry.DecodeAuthConfig(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "bearer", decoded.RegistryToken)
+	encoded, err = auths.EncodedForImage("quay.io/acme/app")
+	require.NoError(t, err)
+	assert.Empty(t, encoded)
+
+	all := auths.All()
+	assert.Len(t, all, 3)
+	assert.Equal(t, "hubuser", all[dockerHubAuthServer].Username)
+	assert.Equal(t, "ci", all["ghcr.io"].Username)
+
+	// The CLI builds get a docker config with every credential
+	buildDir := t.TempDir()
+	written, err := auths.writeDockerConfig(buildDir)
+	require.NoError(t, err)
+	assert.True(t, written)
+	data, err := os.ReadFile(filepath.Join(buildDir, "config.json"))
+	require.NoError(t, err)
+	var config dockerConfigFile
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("ci:pat")), config.Auths["ghcr.io"].Auth)
+	assert.Equal(t, "bearer", config.Auths["registry.example.com:5000"].RegistryToken)
+
+	// Without registries in the spec the CLI keeps the config of the user
+	written, err = registryAuthsFrom(context.Background()).writeDockerConfig(t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, written)
+}
+
+func TestResolveRegistryAuths_Errors(t *testing.T) {
+	_, err := resolveRegistryAuths(context.Background(), nil, []RegistryConfig{{Host: "ghcr.io", Username: "ci", Password: "vault://ghcr"}})
+	assert.Error(t, err)
+	_, err = resolveRegistryAuths(context.Background(), &MockSecretFetcher{}, []RegistryConfig{{Host: "ghcr.io", Token: "vault://missing"}})
+	assert.Error(t, err)
+}
+
+func TestLoadBuildSpec_Registries(t *testing.T) {
+	spec := "name: app\nversion: 1.0.0\nbuild_config:\n  dockerfile: Dockerfile\n  push: true\nregistries:\n"
+	loaded, err := LoadBuildSpecFromBytes([]byte(spec+"  - host: ghcr.io\n    username: ci\n    password: env://GHCR_TOKEN\n"), ".yml")
+	require.NoError(t, err)
+	assert.Equal(t, []RegistryConfig{{Host: "ghcr.io", Username: "ci", Password: "env://GHCR_TOKEN"}}, loaded.Registries)
+	assert.True(t, loaded.BuildConfig.Push)
+
+	_, err = LoadBuildSpecFromBytes([]byte(spec+"  - host: ghcr.io\n    password: env://GHCR_TOKEN\n"), ".yml")
+	assert.Error(t, err)
+	_, err = LoadBuildSpecFromBytes([]byte(spec+"  - username: ci\n"), ".yml")
+	assert.Error(t, err)
+}
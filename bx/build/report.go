@@ -0,0 +1,173 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Lockfiles pinning the dependencies, by language
+var lockfileLanguages = map[string]string{
+	"go.sum":             "Go",
+	"Cargo.lock":         "Rust",
+	"package-lock.json":  "JavaScript",
+	"yarn.lock":          "JavaScript",
+	"pnpm-lock.yaml":     "JavaScript",
+	"bun.lockb":          "JavaScript",
+	"bun.lock":           "JavaScript",
+	"deno.lock":          "JavaScript",
+	"poetry.lock":        "Python",
+	"Pipfile.lock":       "Python",
+	"uv.lock":            "Python",
+	"composer.lock":      "PHP",
+	"Gemfile.lock":       "Ruby",
+	"mix.lock":           "Elixir",
+	"packages.lock.json": "C#",
+	"gradle.lockfile":    "Java",
+	"Package.resolved":   "Swift",
+}
+
+// DetectionMarker is a file of the project identifying an ecosystem
+type DetectionMarker struct {
+	File           string `json:"file"`
+	Language       string `json:"language"`
+	Ecosystem      string `json:"ecosystem"`
+	PackageManager string `json:"package_manager"`
+	Priority       int    `json:"priority"`
+}
+
+// DetectionReport explains the ecosystem chosen for a project directory
+type DetectionReport struct {
+	Path         string             `json:"path"`
+	Ecosystem    *DetectedEcosystem `json:"ecosystem,omitempty"` // Chosen ecosystem, nil when the detection failed
+	Override     string             `json:"override,omitempty"`  // Ecosystem forced by the user, "Language" or "Language-PackageManager"
+	Markers      []DetectionMarker  `json:"markers"`
+	Lockfiles    []string           `json:"lockfiles,omitempty"`
+	Devcontainer string             `json:"devcontainer,omitempty"` // devcontainer.json seeding the versions and the build image
+	Scores       map[string]int     `json:"scores"`                 // Sum of the priorities of the markers of each language
+	Confidence   float64            `json:"confidence"`             // Share of the score of the chosen language, 1 when it is the only one
+	Warnings     []string           `json:"warnings,omitempty"`
+}
+
+// DetectEcosystemReport detects the ecosystem of a project directory like DetectEcosystem and reports the
+// markers, lockfiles and scores behind the choice. A non empty override ("Go", "JavaScript-pnpm") replaces
+// the guess, which also resolves ErrAmbiguousEcosystem. The report is returned with the detection errors.
+func DetectEcosystemReport(codebasePath, override string) (*DetectionReport, error) {
+	absPath, err := filepath.Abs(codebasePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve absolute path for %s: %w", codebasePath, err)
+	}
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory %s: %w", absPath, err)
+	}
+	primaryMarkers := loadPrimaryMarkers()
+	secondaryMarkers := loadSecondaryMarkers()
+
+	report := &DetectionReport{Path: absPath, Override: override, Scores: make(map[string]int)}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		key := name
+		if strings.Contains(name, ".csproj") {
+			key = "*.csproj"
+		}
+		if candidate, ok := primaryMarkers[key]; ok {
+			report.Markers = append(report.Markers, DetectionMarker{
+				File:           name,
+				Language:       candidate.ecosystem.Language,
+				Ecosystem:      candidate.ecosystem.Ecosystem,
+				PackageManager: candidate.ecosystem.PackageManager,
+				Priority:       candidate.priority,
+			})
+			report.Scores[candidate.ecosystem.Language] += candidate.priority
+		}
+		if _, ok := lockfileLanguages[name]; ok {
+			report.Lockfiles = append(report.Lockfiles, name)
+		}
+	}
+	sort.Slice(report.Markers, func(i, j int) bool { return report.Markers[i].Priority > report.Markers[j].Priority })
+
+	var detected *DetectedEcosystem
+	if override != "" {
+		detected, err = overrideEcosystem(absPath, entries, override, primaryMarkers, report)
+	} else {
+		detected, err = scanMarkers(absPath, entries, primaryMarkers)
+		if errors.Is(err, ErrAmbiguousEcosystem) {
+			report.Warnings = append(report.Warnings, "several languages found, choose one with an ecosystem override")
+		}
+	}
+	if err != nil {
+		return report, err
+	}
+	completeDetection(absPath, entries, detected, secondaryMarkers)
+	if _, packageManager, ok := strings.Cut(override, "-"); ok && packageManager != "" {
+		// The package manager of the override wins over the one of the lockfiles
+		detected.PackageManager = strings.ToLower(packageManager)
+		detected.Ecosystem = packageManager
+		detected.LanguageVersion = detectLanguageVersion(absPath, detected)
+		applyDevcontainer(absPath, detected)
+	}
+	report.Ecosystem = detected
+
+	total := 0
+	for _, score := range report.Scores {
+		total += score
+	}
+	if total > 0 {
+		report.Confidence = float64(report.Scores[detected.Language]) / float64(total)
+	}
+	hasLockfile := false
+	for _, lockfile := range report.Lockfiles {
+		hasLockfile = hasLockfile || lockfileLanguages[lockfile] == detected.Language
+	}
+	if !hasLockfile && detected.Language != "Go" {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("no %s lockfile, the versions of the dependencies are not pinned", detected.Language))
+	}
+	if _, file, err := readDevcontainer(absPath); err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("devcontainer ignored: %v", err))
+	} else {
+		report.Devcontainer = file
+	}
+	if _, err := DockerfileTemplateKey(detected); err != nil {
+		report.Warnings = append(report.Warnings, err.Error())
+	}
+	return report, nil
+}
+
+// overrideEcosystem returns the ecosystem of the language of an override, from the marker of the
+// language when there is one. The package manager of the override is set after the detection.
+func overrideEcosystem(path string, entries []os.DirEntry, override string, primary map[string]detectionCandidate, report *DetectionReport) (*DetectedEcosystem, error) {
+	language, _, _ := strings.Cut(override, "-")
+	var known *DetectedEcosystem
+	for _, candidate := range primary {
+		if strings.EqualFold(candidate.ecosystem.Language, language) {
+			ecosystem := candidate.ecosystem
+			known = &ecosystem
+			break
+		}
+	}
+	if known == nil {
+		return nil, fmt.Errorf("unknown language '%s' in the ecosystem override '%s'", language, override)
+	}
+
+	detected := &DetectedEcosystem{Language: known.Language, Ecosystem: known.Ecosystem, PackageManager: known.PackageManager, RootPath: path}
+	found := false
+	for _, marker := range report.Markers {
+		if marker.Language == detected.Language {
+			// The markers are sorted by priority, the first one is the main marker
+			detected.Ecosystem, detected.PackageManager, detected.MainMarkerFile = marker.Ecosystem, marker.PackageManager, marker.File
+			found = true
+			break
+		}
+	}
+	if !found {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("no %s marker file found, the override is used as is", detected.Language))
+	}
+	return detected, nil
+}
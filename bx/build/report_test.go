@@ -0,0 +1,59 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectEcosystemReport(t *testing.T) {
+	dir := writeProjectFiles(t, map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.22\n",
+		"go.sum":         "",
+		"package.json":   `{}`,
+		"pnpm-lock.yaml": "",
+	})
+
+	report, err := DetectEcosystemReport(dir, "")
+	assert.ErrorIs(t, err, ErrAmbiguousEcosystem)
+	require.NotNil(t, report)
+	assert.Nil(t, report.Ecosystem)
+	assert.Len(t, report.Markers, 2)
+	assert.Equal(t, "go.mod", report.Markers[0].File)
+	assert.ElementsMatch(t, []string{"go.sum", "pnpm-lock.yaml"}, report.Lockfiles)
+	assert.Equal(t, map[string]int{"Go": 9, "JavaScript": 8}, report.Scores)
+	assert.NotEmpty(t, report.Warnings)
+
+	// The override resolves the ambiguity
+	report, err = DetectEcosystemReport(dir, "go")
+	require.NoError(t, err)
+	assert.Equal(t, "Go", report.Ecosystem.Language)
+	assert.Equal(t, "1.22", report.Ecosystem.LanguageVersion)
+	assert.InDelta(t, 9.0/17.0, report.Confidence, 0.001)
+	assert.Empty(t, report.Warnings)
+
+	report, err = DetectEcosystemReport(dir, "JavaScript-yarn")
+	require.NoError(t, err)
+	assert.Equal(t, "yarn", report.Ecosystem.PackageManager)
+	assert.Equal(t, "package.json", report.Ecosystem.MainMarkerFile)
+	key, err := DockerfileTemplateKey(report.Ecosystem)
+	require.NoError(t, err)
+	assert.Equal(t, "JavaScript-yarn", key)
+
+	_, err = DetectEcosystemReport(dir, "Cobol")
+	assert.Error(t, err)
+}
+
+func TestDetectEcosystemReport_Warnings(t *testing.T) {
+	report, err := DetectEcosystemReport(writeProjectFiles(t, map[string]string{"requirements.txt": "flask\n"}), "")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, report.Confidence)
+	assert.Contains(t, report.Warnings, "no Python lockfile, the versions of the dependencies are not pinned")
+
+	// A language without marker is used as is
+	report, err = DetectEcosystemReport(writeProjectFiles(t, map[string]string{"main.go": "package main\n"}), "Go")
+	require.NoError(t, err)
+	assert.Equal(t, "go", report.Ecosystem.PackageManager)
+	assert.Contains(t, report.Warnings, "no Go marker file found, the override is used as is")
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// Labels set on the built images: the build ID, used by CollectGarbage, and the codebase revision
+const (
+	LabelBuild    = "io.anexis.build"
+	LabelRevision = "org.opencontainers.image.revision"
+	LabelSource   = "org.opencontainers.image.source"
+	LabelBranch   = "io.anexis.branch"
+)
+
+// CodebaseRevision is the git revision a codebase was built from
+type CodebaseRevision struct {
+	Commit string `json:"commit"`
+	Branch string `json:"branch,omitempty"`
+	Source string `json:"source,omitempty"` // Remote URL, without credentials
+}
+
+// Labels returns the image labels of the revision
+func (r CodebaseRevision) Labels() map[string]string {
+	labels := map[string]string{LabelRevision: r.Commit}
+	if r.Source != "" {
+		labels[LabelSource] = r.Source
+	}
+	if r.Branch != "" {
+		labels[LabelBranch] = r.Branch
+	}
+	return labels
+}
+
+// readCodebaseRevision resolves the commit checked out for a codebase. The git codebases are read
+// from their clone in dir, the local ones from their source directory. It returns nil for the
+// other source types and for the local directories outside of a git repository.
+func readCodebaseRevision(config CodebaseConfig, dir string) (*CodebaseRevision, error) {
+	var repo *git.Repository
+	var err error
+	switch config.SourceType {
+	case "git":
+		repo, err = git.PlainOpen(dir)
+	case "local":
+		repo, err = git.PlainOpenWithOptions(config.Source, &git.PlainOpenOptions{DetectDotGit: true})
+		if errors.Is(err, git.ErrRepositoryNotExists) {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the repository of the codebase '%s': %w", config.Name, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve the HEAD of the codebase '%s': %w", config.Name, err)
+	}
+	revision := &CodebaseRevision{Commit: head.Hash().String(), Branch: config.Branch}
+	if head.Name().IsBranch() {
+		revision.Branch = head.Name().Short()
+	}
+
+	if remote, err := repo.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+		revision.Source = remote.Config().URLs[0]
+	} else if config.SourceType == "git" {
+		revision.Source = config.Source
+	}
+	revision.Source = stripURLCredentials(revision.Source)
+	return revision, nil
+}
+
+// stripURLCredentials removes the user and the password of an http(s) URL, the tokens of the
+// private clones must not end in the image labels
+func stripURLCredentials(source string) string {
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.User == nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return source
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// codebaseForDir returns the name of the codebase whose directory contains dir, the deepest one
+// when the codebases are nested
+func codebaseForDir(codebaseDirs map[string]string, dir string) string {
+	var found, foundDir string
+	for name, codebaseDir := range codebaseDirs {
+		rel, err := filepath.Rel(codebaseDir, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if found == "" || len(codebaseDir) > len(foundDir) {
+			found, foundDir = name, codebaseDir
+		}
+	}
+	return found
+}
+
+// imageLabels returns the labels of an image built from contextDir by the build buildID: the build
+// label, the revision of the codebase holding the context (or of the only codebase with a revision)
+// and the labels set in the spec, which override the revision
+func imageLabels(buildID string, revisions map[string]CodebaseRevision, codebaseDirs map[string]string, contextDir string, labels map[string]string) map[string]string {
+	merged := map[string]string{LabelBuild: buildID}
+	revision, ok := revisions[codebaseForDir(codebaseDirs, contextDir)]
+	if !ok && len(revisions) == 1 {
+		for _, only := range revisions {
+			revision, ok = only, true
+		}
+	}
+	if ok {
+		for key, value := range revision.Labels() {
+			merged[key] = value
+		}
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,111 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSpecFile is the name of the specification written by the scaffolding
+const DefaultSpecFile = "anexis.build.yml"
+
+// ScaffoldCodebase is a codebase proposed for a detected ecosystem
+type ScaffoldCodebase struct {
+	Name      string            // Name of the codebase, from its directory
+	Path      string            // Directory relative to the project root, "." for the root
+	Ecosystem DetectedEcosystem // Ecosystem detected in the directory
+}
+
+// ProposeCodebases returns a codebase per ecosystem detected in a project directory and its
+// subdirectories, with a unique name. The first one is the project root when it has an ecosystem.
+func ProposeCodebases(root string) ([]ScaffoldCodebase, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve absolute path for %s: %w", root, err)
+	}
+	ecosystems, err := DetectEcosystems(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	var codebases []ScaffoldCodebase
+	used := make(map[string]bool)
+	for _, ecosystem := range ecosystems {
+		rel, err := filepath.Rel(absRoot, ecosystem.RootPath)
+		if err != nil {
+			rel = ecosystem.RootPath
+		}
+		name := ScaffoldName(filepath.Base(ecosystem.RootPath))
+		if used[name] {
+			// Several languages in a directory, or directories of the same name
+			name = name + "-" + ScaffoldName(ecosystem.Language)
+		}
+		for i, base := 2, name; used[name]; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		used[name] = true
+		codebases = append(codebases, ScaffoldCodebase{Name: name, Path: filepath.ToSlash(rel), Ecosystem: ecosystem})
+	}
+	return codebases, nil
+}
+
+var scaffoldNameInvalid = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// ScaffoldName turns a directory name into a name usable for a codebase and an image repository
+func ScaffoldName(name string) string {
+	name = scaffoldNameInvalid.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-_.")
+	if name == "" {
+		return "app"
+	}
+	return name
+}
+
+// StarterSpec returns the specification of a project building the image of its first codebase,
+// the others being copied next to it in the build directory. The local sources are relative to
+// the project root, where bx build is expected to run.
+func StarterSpec(name, version string, codebases []ScaffoldCodebase, tags []string) *BuildSpec {
+	spec := &BuildSpec{
+		Name:    name,
+		Version: version,
+		BuildConfig: BuildConfig{
+			OutputTarget: "docker",
+			Tags:         tags,
+		},
+		RunConfigDef: RunConfigDef{Generate: true, ArtifactStorage: "docker"},
+	}
+	for _, codebase := range codebases {
+		spec.Codebases = append(spec.Codebases, CodebaseConfig{
+			Name:       codebase.Name,
+			SourceType: "local",
+			Source:     codebase.Path,
+		})
+	}
+	return spec
+}
+
+// MarshalStarterSpec writes a specification as YAML, after a comment listing the detected ecosystems
+func MarshalStarterSpec(spec *BuildSpec, codebases []ScaffoldCodebase) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("# Generated by bx init from the detected ecosystems:\n")
+	for _, codebase := range codebases {
+		ecosystem := codebase.Ecosystem
+		fmt.Fprintf(&out, "# %s: %s (%s)", codebase.Path, ecosystem.Language, ecosystem.PackageManager)
+		if ecosystem.Framework != "" {
+			fmt.Fprintf(&out, ", %s", ecosystem.Framework)
+		}
+		out.WriteString("\n")
+	}
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(spec); err != nil {
+		return nil, fmt.Errorf("cannot encode the specification: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("cannot encode the specification: %w", err)
+	}
+	return out.Bytes(), nil
+}
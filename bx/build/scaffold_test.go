@@ -0,0 +1,74 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProposeCodebases(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "My Shop")
+	files := map[string]string{
+		"go.mod":                         "module example.com/shop\n\ngo 1.22\n",
+		"web/package.json":               `{}`,
+		"tools/api/package.json":         `{}`,
+		"services/api/Cargo.toml":        "[package]\nname = \"api\"\n",
+		"services/api/requirements.txt":  "",
+		"services/api/node_modules/x.js": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	codebases, err := ProposeCodebases(root)
+	require.NoError(t, err)
+	found := make(map[string]string)
+	for _, codebase := range codebases {
+		found[codebase.Path+":"+codebase.Ecosystem.Language] = codebase.Name
+	}
+	assert.Equal(t, "my-shop", found[".:Go"])
+	assert.Equal(t, "web", found["web:JavaScript"])
+	// The names stay unique
+	assert.Len(t, codebases, 5)
+	names := make(map[string]bool)
+	for _, codebase := range codebases {
+		names[codebase.Name] = true
+	}
+	assert.Len(t, names, 5)
+
+	_, err = ProposeCodebases(t.TempDir())
+	assert.ErrorIs(t, err, ErrNoEcosystemFound)
+}
+
+func TestStarterSpec(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "api"), 0755))
+	codebases := []ScaffoldCodebase{
+		{Name: "shop", Path: ".", Ecosystem: DetectedEcosystem{Language: "Go", PackageManager: "go"}},
+		{Name: "api", Path: "api", Ecosystem: DetectedEcosystem{Language: "JavaScript", PackageManager: "pnpm", Framework: "Next.js"}},
+	}
+	spec := StarterSpec("shop", "0.1.0", codebases, []string{"shop:latest"})
+	data, err := MarshalStarterSpec(spec, codebases)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# api: JavaScript (pnpm), Next.js\n")
+
+	// The written spec is valid and loads back
+	assert.Empty(t, ValidateBuildSpec(context.Background(), data, ".yml", ValidateOptions{Online: true, BaseDir: root}))
+	loaded, err := LoadBuildSpecFromBytes(data, ".yml")
+	require.NoError(t, err)
+	assert.Equal(t, spec.Codebases, loaded.Codebases)
+	assert.Equal(t, []string{"shop:latest"}, loaded.BuildConfig.Tags)
+	assert.Equal(t, "docker", loaded.BuildConfig.OutputTarget)
+}
+
+func TestScaffoldName(t *testing.T) {
+	assert.Equal(t, "my-shop", ScaffoldName("My Shop"))
+	assert.Equal(t, "api_v2", ScaffoldName("api_v2"))
+	assert.Equal(t, "app", ScaffoldName("__"))
+}
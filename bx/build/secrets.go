@@ -1,12 +1,208 @@
 package build
 
-import "context"
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Secret injection methods
+const (
+	InjectEnv   = "env"   // Runtime environment variable of the services (default)
+	InjectBuild = "build" // BuildKit secret mount, only readable by `RUN --mount=type=secret,id=<name>`
+	InjectFile  = "file"  // File mounted from a tmpfs when the services run, at the spec target
+)
+
+// Directory of the secret files without explicit target
+const defaultSecretFileDir = "/run/secrets"
 
 // Interface for an extern secrets service provider
 type SecretFetcher interface {
 	GetSecret(ctx context.Context, source string) (string, error) // Must return the secret value
 }
 
+// StructuredSecretFetcher also returns the binary secrets as is and the JSON secrets as maps.
+// The fetchers only able to return strings are adapted with AdaptSecretFetcher.
+type StructuredSecretFetcher interface {
+	SecretFetcher
+	GetSecretBytes(ctx context.Context, source string) ([]byte, error)
+	GetSecretMap(ctx context.Context, source string) (map[string]string, error)
+}
+
+// AdaptSecretFetcher returns the fetcher itself when it is structured, else an adapter deriving
+// the bytes and the map (decoded from a JSON object) from the string value
+func AdaptSecretFetcher(fetcher SecretFetcher) StructuredSecretFetcher {
+	if structured, ok := fetcher.(StructuredSecretFetcher); ok {
+		return structured
+	}
+	return stringSecretAdapter{fetcher}
+}
+
+type stringSecretAdapter struct {
+	SecretFetcher
+}
+
+func (a stringSecretAdapter) GetSecretBytes(ctx context.Context, source string) ([]byte, error) {
+	value, err := a.GetSecret(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func (a stringSecretAdapter) GetSecretMap(ctx context.Context, source string) (map[string]string, error) {
+	value, err := a.GetSecret(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeSecretMap([]byte(value))
+}
+
+// DecodeSecretMap decodes a JSON object secret. The values which are not strings are kept as JSON.
+func DecodeSecretMap(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("the secret is not a JSON object: %w", err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		values[key] = jsonLeaf(value)
+	}
+	return values, nil
+}
+
+// SecretKeyPath returns the value at a dotted key path ("db.password", "hosts.0") of a JSON secret
+func SecretKeyPath(data []byte, keyPath string) (string, error) {
+	current := json.RawMessage(data)
+	for _, key := range strings.Split(keyPath, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(current, &object); err == nil {
+			value, ok := object[key]
+			if !ok {
+				return "", fmt.Errorf("key '%s' not found in the secret (path '%s')", key, keyPath)
+			}
+			current = value
+			continue
+		}
+		var array []json.RawMessage
+		if err := json.Unmarshal(current, &array); err == nil {
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(array) {
+				return "", fmt.Errorf("invalid index '%s' in the secret (path '%s')", key, keyPath)
+			}
+			current = array[index]
+			continue
+		}
+		return "", fmt.Errorf("cannot select '%s' in the secret: not a JSON object or array (path '%s')", key, keyPath)
+	}
+	return jsonLeaf(current), nil
+}
+
+// jsonLeaf returns a JSON string unquoted, any other value as JSON
+func jsonLeaf(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// resolveSecret fetches the value of a secret spec, narrowed to its key path if any
+func resolveSecret(ctx context.Context, fetcher SecretFetcher, spec SecretSpec) ([]byte, error) {
+	data, err := AdaptSecretFetcher(fetcher).GetSecretBytes(ctx, spec.Source)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Key == "" {
+		return data, nil
+	}
+	value, err := SecretKeyPath(data, spec.Key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// SecretCheck is the outcome of the resolution of a secret of a spec, without its value
+type SecretCheck struct {
+	Name         string `json:"name"`
+	Source       string `json:"source"`
+	Key          string `json:"key,omitempty"`
+	InjectMethod string `json:"inject_method"`
+	Size         int    `json:"size"`              // Bytes of the resolved value
+	Error        string `json:"error,omitempty"`   // Why the secret would fail a build
+	Warning      string `json:"warning,omitempty"` // Resolved, but not injected by the builds
+}
+
+// CheckSecrets resolves the secrets of a spec, the project ones included, as a build does and
+// reports the ones which would fail it. The values are dropped once their size is known.
+func (s *BuildService) CheckSecrets(ctx context.Context, spec *BuildSpec) []SecretCheck {
+	s.mutex.Lock()
+	fetcher := s.secretFetcher
+	s.mutex.Unlock()
+
+	secretSpecs := s.effectiveSecrets(spec)
+	checks := make([]SecretCheck, 0, len(secretSpecs))
+	for _, secretSpec := range secretSpecs {
+		check := SecretCheck{Name: secretSpec.Name, Source: secretSpec.Source, Key: secretSpec.Key, InjectMethod: secretSpec.InjectMethod}
+		if check.InjectMethod == "" {
+			check.InjectMethod = InjectEnv
+		}
+		if fetcher == nil {
+			check.Error = "no secret provider configured, the builds skip the secrets"
+			checks = append(checks, check)
+			continue
+		}
+		value, err := resolveSecret(ctx, fetcher, secretSpec)
+		if err != nil {
+			check.Error = err.Error()
+		} else {
+			check.Size = len(value)
+		}
+		switch secretSpec.InjectMethod {
+		case "", InjectEnv, InjectBuild, InjectFile:
+		default:
+			check.Warning = fmt.Sprintf("injection method '%s' not supported, the builds skip the secret", secretSpec.InjectMethod)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// secretFileTarget returns the path of a file secret in the containers
+func secretFileTarget(spec SecretSpec) string {
+	if spec.Target != "" {
+		return spec.Target
+	}
+	return path.Join(defaultSecretFileDir, spec.Name)
+}
+
+// newRunSecretFile encodes a file secret for the run.yml
+func newRunSecretFile(spec SecretSpec, value []byte) RunSecretFile {
+	return RunSecretFile{
+		Name:    spec.Name,
+		Target:  secretFileTarget(spec),
+		Content: base64.StdEncoding.EncodeToString(value),
+	}
+}
+
+// attachSecretFiles adds the file secrets to every service of the run.yml
+func attachSecretFiles(runYAML *RunYAML, files []RunSecretFile) {
+	if len(files) == 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Target < files[j].Target })
+	for name, service := range runYAML.Services {
+		service.SecretFiles = append(service.SecretFiles, files...)
+		runYAML.Services[name] = service
+	}
+}
+
 func (s *BuildService) GetSecret(ctx context.Context, source string) (string, error) {
 	s.mutex.Lock()
 	fetcher := s.secretFetcher
@@ -17,4 +213,23 @@ func (s *BuildService) GetSecret(ctx context.Context, source string) (string, er
 		fetcher = &DummySecretFetcher{}
 	}
 	return fetcher.GetSecret(ctx, source)
-}
\ No newline at end of file
+}
+
+// GetSecretBytes returns a secret as is, binary secrets included
+func (s *BuildService) GetSecretBytes(ctx context.Context, source string) ([]byte, error) {
+	return AdaptSecretFetcher(s.fetcher()).GetSecretBytes(ctx, source)
+}
+
+// GetSecretMap returns a JSON object secret as a map
+func (s *BuildService) GetSecretMap(ctx context.Context, source string) (map[string]string, error) {
+	return AdaptSecretFetcher(s.fetcher()).GetSecretMap(ctx, source)
+}
+
+func (s *BuildService) fetcher() SecretFetcher {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.secretFetcher == nil {
+		return &DummySecretFetcher{}
+	}
+	return s.secretFetcher
+}
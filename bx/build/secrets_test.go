@@ -0,0 +1,79 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jsonSecret = `{"db":{"user":"app","password":"p4ss","port":5432},"hosts":["a","b"]}`
+
+func TestSecretKeyPath(t *testing.T) {
+	value, err := SecretKeyPath([]byte(jsonSecret), "db.password")
+	require.NoError(t, err)
+	assert.Equal(t, "p4ss", value)
+
+	value, err = SecretKeyPath([]byte(jsonSecret), "db.port")
+	require.NoError(t, err)
+	assert.Equal(t, "5432", value)
+
+	value, err = SecretKeyPath([]byte(jsonSecret), "hosts.1")
+	require.NoError(t, err)
+	assert.Equal(t, "b", value)
+
+	_, err = SecretKeyPath([]byte(jsonSecret), "db.missing")
+	assert.Error(t, err)
+	_, err = SecretKeyPath([]byte("plain"), "db")
+	assert.Error(t, err)
+}
+
+func TestAdaptSecretFetcher(t *testing.T) {
+	fetcher := AdaptSecretFetcher(&MockSecretFetcher{Secrets: map[string]string{"prod/db": jsonSecret}})
+	values, err := fetcher.GetSecretMap(context.Background(), "prod/db")
+	require.NoError(t, err)
+	assert.Equal(t, `{"user":"app","password":"p4ss","port":5432}`, values["db"])
+
+	data, err := fetcher.GetSecretBytes(context.Background(), "prod/db")
+	require.NoError(t, err)
+	assert.Equal(t, jsonSecret, string(data))
+	assert.Equal(t, fetcher, AdaptSecretFetcher(fetcher))
+}
+
+func TestResolveSecret_KeyAndFile(t *testing.T) {
+	fetcher := &MockSecretFetcher{Secrets: map[string]string{"prod/db": jsonSecret}}
+	spec := SecretSpec{Name: "DB_PASSWORD", Source: "prod/db", Key: "db.password", InjectMethod: InjectFile}
+	value, err := resolveSecret(context.Background(), fetcher, spec)
+	require.NoError(t, err)
+	assert.Equal(t, "p4ss", string(value))
+
+	runYAML := &RunYAML{Services: map[string]RunService{"api": {Image: "api:1"}}}
+	attachSecretFiles(runYAML, []RunSecretFile{newRunSecretFile(spec, value)})
+	files := runYAML.Services["api"].SecretFiles
+	require.Len(t, files, 1)
+	assert.Equal(t, "/run/secrets/DB_PASSWORD", files[0].Target)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("p4ss")), files[0].Content)
+}
+
+func TestBuildService_CheckSecrets(t *testing.T) {
+	fetcher := &MockSecretFetcher{Secrets: map[string]string{"prod/db": jsonSecret, "prod/token": "t0ken"}}
+	s := &BuildService{secretFetcher: fetcher}
+	spec := &BuildSpec{Secrets: []SecretSpec{
+		{Name: "DB_PASSWORD", Source: "prod/db", Key: "db.password"},
+		{Name: "DB_MISSING", Source: "prod/db", Key: "db.missing", InjectMethod: InjectFile},
+		{Name: "API_KEY", Source: "prod/api"},
+		{Name: "TOKEN", Source: "prod/token", InjectMethod: "vault"},
+	}}
+	checks := s.CheckSecrets(context.Background(), spec)
+	require.Len(t, checks, 4)
+	assert.Equal(t, SecretCheck{Name: "DB_PASSWORD", Source: "prod/db", Key: "db.password", InjectMethod: InjectEnv, Size: 4}, checks[0])
+	assert.Contains(t, checks[1].Error, "db.missing")
+	assert.Contains(t, checks[2].Error, "not found")
+	assert.Empty(t, checks[3].Error)
+	assert.Contains(t, checks[3].Warning, "not supported")
+
+	checks = (&BuildService{}).CheckSecrets(context.Background(), spec)
+	assert.Contains(t, checks[0].Error, "no secret provider")
+}
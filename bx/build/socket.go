@@ -3,6 +3,7 @@ package build
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log" // For internal logs
@@ -17,6 +18,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/moby/go-archive"
+	"gopkg.in/yaml.v3"
 	// ...
 )
 
@@ -28,14 +30,16 @@ type logNotifierWriter struct {
 	buildID  string
 	stream   string // "stdout" or "stderr"
 	notifier socket.BuildNotifier
+	redactor *Redactor // Masks the secrets before the logs leave the service
 	mu       sync.Mutex
 }
 
-func newLogNotifierWriter(buildID string, stream string, notifier socket.BuildNotifier) *logNotifierWriter {
+func newLogNotifierWriter(buildID string, stream string, notifier socket.BuildNotifier, redactor *Redactor) *logNotifierWriter {
 	return &logNotifierWriter{
 		buildID:  buildID,
 		stream:   stream,
 		notifier: notifier,
+		redactor: redactor,
 	}
 }
 
@@ -47,7 +51,7 @@ func (lnw *logNotifierWriter) Write(p []byte) (n int, err error) {
 	defer lnw.mu.Unlock()
 	// Envoyer le contenu comme un chunk de log
 	// Convertir les bytes en string. Peut être optimisé si de très gros chunks sont attendus.
-	content := string(p)
+	content := lnw.redactor.Redact(string(p))
 	lnw.notifier.NotifyLog(lnw.buildID, lnw.stream, content)
 	return len(p), nil
 }
@@ -55,38 +59,95 @@ func (lnw *logNotifierWriter) Write(p []byte) (n int, err error) {
 
 // StartBuildAsync lance un build en arrière-plan et notifie via le notifier.
 func (s *BuildService) StartBuildAsync(ctx context.Context, buildID string, buildSpecYAML string, notifier socket.BuildNotifier) error {
-	log.Printf("[BuildID: %s] Received async build request.\n", buildID)
+	logger := s.Logger().With(LogKeyBuildID, buildID)
+	logger.Info("Received async build request")
 
 	// 1. Parser le BuildSpec depuis le YAML reçu
 	// Utiliser le format .yaml par défaut car c'est ce qu'on a défini dans le payload
 	spec, err := LoadBuildSpecFromBytes([]byte(buildSpecYAML), ".yaml")
 	if err != nil {
-		log.Printf("[BuildID: %s] Error parsing BuildSpec YAML: %v\n", buildID, err)
-		// Notifier immédiatement l'échec de démarrage
-		go notifier.NotifyStatus(buildID, "failure", "", fmt.Errorf("invalid build spec: %w", err), nil)
-		return fmt.Errorf("invalid build spec: %w", err) // Retourner l'erreur au serveur socket
+		logger.Error("Error parsing BuildSpec YAML", "error", err)
+		return newBuildError(CodeInvalidSpec, fmt.Errorf("invalid build spec: %w", err)) // Le serveur socket renvoie l'erreur au client
 	}
-	log.Printf("[BuildID: %s] Parsed BuildSpec for '%s' version '%s'.\n", buildID, spec.Name, spec.Version)
+	logger.Info("Parsed BuildSpec", "name", spec.Name, "version", spec.Version)
 
-	// 2. Lancer la logique de build réelle dans une goroutine
-	go s.runBuildLogic(ctx, buildID, spec, notifier)
+	// 2. Mettre le build dans la queue, un worker lancera la logique de build réelle
+	var onQueued func(position int, estimatedWait time.Duration)
+	if progress, ok := notifier.(socket.ProgressNotifier); ok {
+		onQueued = func(position int, estimatedWait time.Duration) {
+			progress.NotifyQueued(buildID, position, estimatedWait)
+		}
+	}
+	// A build canceled before its start never runs, its clients receive its final status here
+	onCanceled := func(status BuildStatus) {
+		notifier.NotifyStatus(buildID, "failure", "", ErrBuildCanceled, nil)
+	}
+	err = s.getQueue().enqueue(buildID, spec.Name, spec.Version, specBranch(spec), 0, onQueued, onCanceled, func(queueCtx context.Context) (*BuildResult, error) {
+		return nil, s.runBuildLogic(queueCtx, buildID, spec, notifier)
+	})
+	if err != nil {
+		logger.Error("Cannot queue the build", "error", err)
+		return fmt.Errorf("cannot queue the build: %w", err)
+	}
 
 	// 3. Retourner nil immédiatement pour indiquer que la tâche a été acceptée
-	log.Printf("[BuildID: %s] Build logic started in background.\n", buildID)
+	logger.Info("Build queued")
 	return nil
 }
 
 
+// progressFromEvent converts the phase and progress events of a build to the progress sent to the clients
+func progressFromEvent(buildID string, event BuildEvent) (socket.BuildProgressPayload, bool) {
+	if event.Type != EventPhaseStarted && event.Type != EventProgress {
+		return socket.BuildProgressPayload{}, false
+	}
+	return socket.BuildProgressPayload{
+		BuildID: buildID,
+		Phase:   event.Phase,
+		Service: event.Service,
+		Current: event.Current,
+		Total:   event.Total,
+		Message: event.Message,
+	}, true
+}
+
+// newProgressStream returns the event stream of a build sent to the notifier as progress updates,
+// when it is a socket.ProgressNotifier. The returned function closes the stream once the updates are sent.
+func newProgressStream(buildID string, notifier socket.BuildNotifier, redactor *Redactor) (*eventStream, func()) {
+	progress, ok := notifier.(socket.ProgressNotifier)
+	if !ok {
+		events := newEventStream(nil)
+		events.redactor = redactor
+		return events, func() { events.Close("") }
+	}
+	ch := make(chan BuildEvent, 16)
+	events := newEventStream(ch)
+	events.redactor = redactor
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range ch {
+			if payload, ok := progressFromEvent(buildID, event); ok {
+				progress.NotifyProgress(payload)
+			}
+		}
+	}()
+	return events, func() {
+		events.Close("")
+		<-done
+	}
+}
+
 // runBuildLogic contient la logique de build principale, adaptée pour les notifications.
 // ATTENTION: Cette fonction est maintenant longue et complexe. Envisager de la découper.
-func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *BuildSpec, notifier socket.BuildNotifier) {
+func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *BuildSpec, notifier socket.BuildNotifier) (buildErr error) {
 	startTime := time.Now()
-	var buildErr error
 	var finalStatus string = "success" // Statut par défaut
 	var artifactRef string = ""        // Référence de l'artefact final
 
 	// Créer des writers pour capturer stdout/stderr et les envoyer au notifier
-	stdoutNotifier := newLogNotifierWriter(buildID, "stdout", notifier)
+	redactor := NewRedactor()
+	stdoutNotifier := newLogNotifierWriter(buildID, "stdout", notifier, redactor)
 	// stderrNotifier := newLogNotifierWriter(buildID, "stderr", notifier) // Peut être utile plus tard
 
 	// Créer un logger dédié pour ce build qui écrit vers le notifier
@@ -97,17 +158,31 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 		duration := time.Since(startTime).Seconds()
 		if r := recover(); r != nil {
 			buildLogger.Printf("PANIC recovered during build: %v\n", r)
-			buildErr = fmt.Errorf("panic during build: %v", r)
+			buildErr = newBuildError(CodeInternal, fmt.Errorf("panic during build: %v", r))
 			finalStatus = "failure"
 		}
 		buildLogger.Printf("Build finished with status: %s (Error: %v)\n", finalStatus, buildErr)
-		notifier.NotifyStatus(buildID, finalStatus, artifactRef, buildErr, &duration)
+		statusErr := buildErr
+		if statusErr != nil {
+			// The code of the failure is kept for the client, only the message is redacted
+			redacted := redactor.Redact(statusErr.Error())
+			if code := ErrorCodeOf(statusErr); code != "" {
+				statusErr = &BuildError{Code: code, Message: redacted}
+			} else {
+				statusErr = errors.New(redacted)
+			}
+		}
+		notifier.NotifyStatus(buildID, finalStatus, artifactRef, statusErr, &duration)
 	}()
+	// The progress of the phases reaches the clients before the final status
+	events, closeEvents := newProgressStream(buildID, notifier, redactor)
+	defer closeEvents()
 
 
 	// --- Logique de Build (adaptée de Build()) ---
 	buildLogger.Println("Starting build process...")
 	notifier.NotifyStatus(buildID, "starting", "", nil, nil) // Statut initial
+	events.StartPhase(PhaseSetup)
 
 	// Utiliser un lock spécifique au build si BuildService a des champs partagés modifiables (ici, juste pour l'exemple)
 	// s.mutex.Lock()
@@ -122,18 +197,36 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 	}
 
 	// --- 1. Setup Build Environment ---
+	// Deux builds du même nom et de la même version écriraient les mêmes sorties et tags
+	unlock, err := s.lockBuild(ctx, spec, buildLogger.Printf)
+	if err != nil {
+		code := CodeSetup
+		if errors.Is(err, ErrBuildInProgress) {
+			code = CodeBuildInProgress
+		}
+		buildErr = newBuildError(code, err)
+		finalStatus = "failure"
+		return
+	}
+	defer unlock()
 	// Utiliser buildID pour un chemin unique
 	buildDir := filepath.Join(s.workDir, buildID)
 	if err := os.MkdirAll(buildDir, 0755); err != nil {
-		buildErr = fmt.Errorf("cannot create build directory '%s': %w", buildDir, err)
+		buildErr = newBuildError(CodeSetup, fmt.Errorf("cannot create build directory '%s': %w", buildDir, err))
 		finalStatus = "failure"
 		return // Sortir après avoir mis à jour buildErr (defer s'occupera de notifier)
 	}
-	// Nettoyer seulement si succès et pas sortie locale SANS chemin spécifique
+	// Journal des ressources créées, pour RecoverOrphans en cas de crash du process
+	journal := s.openJournal(buildID)
+	defer journal.Close()
+	journal.Track(ResourceDir, buildDir)
+	ctx = withJournal(ctx, journal)
+
+	// Nettoyer seulement si succès et si les sorties locales ne sont pas dans le buildDir
 	shouldCleanup := true
 	defer func() {
 		if shouldCleanup && buildErr == nil { // Nettoyer si succès
-			if !(spec.BuildConfig.OutputTarget == "local" && spec.BuildConfig.LocalPath == "") {
+			if !(spec.BuildConfig.OutputTarget == "local" && s.outputBasePath(spec, buildDir) == buildDir) {
 				buildLogger.Printf("Cleaning up build directory: %s\n", buildDir)
 				os.RemoveAll(buildDir)
 			} else {
@@ -145,6 +238,7 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 	}()
 	buildLogger.Printf("Using build directory: %s\n", buildDir)
 	notifier.NotifyStatus(buildID, "preparing_env", "", nil, nil)
+	events.StartPhase(PhaseEnv)
 
 	// --- 2. Load Environment Variables ---
 	mergedEnv := make(map[string]string)
@@ -155,26 +249,45 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 	for k, v := range spec.Env { // Exemple simplifié
 		mergedEnv[k] = v
 	}
+	if added := s.mergeProjectEnv(spec, mergedEnv); added > 0 {
+		buildLogger.Printf("Added %d project environment variables.\n", added)
+	}
 	buildLogger.Printf("Loaded %d environment variables.\n", len(mergedEnv))
 
 
 	// --- 3. Fetch Secrets ---
 	runtimeSecrets := make(map[string]string)
-	if s.secretFetcher != nil && len(spec.Secrets) > 0 {
+	buildSecrets := make(map[string]string)
+	secretSpecs := s.effectiveSecrets(spec)
+	if s.secretFetcher != nil && len(secretSpecs) > 0 {
 		buildLogger.Println("Fetching secrets...")
 		notifier.NotifyStatus(buildID, "fetching_secrets", "", nil, nil)
-		for _, secretSpec := range spec.Secrets {
-			secretValue, err := s.GetSecret(ctx, secretSpec.Source) // Utilise la méthode locale
+		events.StartPhase(PhaseSecrets)
+		for _, secretSpec := range secretSpecs {
+			value, err := resolveSecret(ctx, s.fetcher(), secretSpec)
 			if err != nil {
-				buildErr = fmt.Errorf("failed to fetch secret '%s' (source: %s): %w", secretSpec.Name, secretSpec.Source, err)
+				buildErr = newBuildError(CodeSecretFetch, fmt.Errorf("failed to fetch secret '%s' (source: %s): %w", secretSpec.Name, secretSpec.Source, err))
 				finalStatus = "failure"
 				return
 			}
-			runtimeSecrets[secretSpec.Name] = secretValue
+			secretValue := string(value)
+			switch secretSpec.InjectMethod {
+			case InjectBuild:
+				buildSecrets[secretSpec.Name] = secretValue
+			case InjectFile:
+				// The file secrets are only written in the run.yml of the builds run by Build
+				buildLogger.Printf("Secret '%s' is a file secret, not injected in the environment.\n", secretSpec.Name)
+			default:
+				runtimeSecrets[secretSpec.Name] = secretValue
+			}
+			redactor.AddSecret(secretValue)
 			// Ne pas logger la valeur du secret !
 			buildLogger.Printf("Secret '%s' fetched successfully.\n", secretSpec.Name)
 		}
 	}
+	if len(buildSecrets) > 0 {
+		ctx = withBuildSecrets(ctx, buildSecrets)
+	}
 	finalRuntimeEnv := make(map[string]string)
 	for k, v := range mergedEnv { finalRuntimeEnv[k] = v }
 	for k, v := range runtimeSecrets { finalRuntimeEnv[k] = v }
@@ -183,6 +296,7 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 	// --- 4. Download Resources ---
 	// Adapter la logique de téléchargement ici... Utiliser buildLogger.
 	notifier.NotifyStatus(buildID, "downloading_resources", "", nil, nil)
+	events.StartPhase(PhaseResources)
 	buildLogger.Println("Downloading resources...")
 	// ... (boucle sur spec.Resources, appel s.downloadFile, s.extractArchive...) ...
 	// En cas d'erreur, assigner buildErr et retourner
@@ -190,14 +304,16 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 
 	// --- 5. Prepare Codebases ---
 	notifier.NotifyStatus(buildID, "fetching_codebases", "", nil, nil)
+	events.StartPhase(PhaseCodebases)
 	buildLogger.Println("Fetching codebases...")
 	codebaseMap := make(map[string]CodebaseConfig)
-	for _, codebase := range spec.Codebases {
+	for i, codebase := range spec.Codebases {
+		events.Progressf(codebase.Name, i+1, len(spec.Codebases), "Fetching codebase '%s'", codebase.Name)
 		// ... (logique pour déterminer destDir) ...
 		destDir := filepath.Join(buildDir, codebase.Name) // Simplifié
 		buildLogger.Printf("Fetching codebase '%s' into %s\n", codebase.Name, destDir)
 		if err := s.fetchCodebase(ctx, codebase, destDir); err != nil {
-			buildErr = fmt.Errorf("failed to fetch codebase '%s': %w", codebase.Name, err)
+			buildErr = newBuildError(CodeCodebaseFetch, fmt.Errorf("failed to fetch codebase '%s': %w", codebase.Name, err))
 			finalStatus = "failure"
 			return
 		}
@@ -211,6 +327,7 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 
 	// --- 7. Main Build Execution ---
 	notifier.NotifyStatus(buildID, "building_image", "", nil, nil)
+	events.StartPhase(PhaseBuild)
 	buildLogger.Println("Starting main build execution...")
 	// Ici, on doit passer le `stdoutNotifier` aux fonctions de build Docker
 
@@ -219,23 +336,24 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 		buildLogger.Printf("Building using Compose file: %s\n", spec.BuildConfig.ComposeFile)
 		// ... (charger le projet compose comme avant, mais passer stdoutNotifier aux appels build) ...
 		// buildErrs := s.buildComposeProject(ctx, buildDir, composeProject, spec, result, buildLogger) // Adapter buildComposeProject
-		buildErr = fmt.Errorf("compose build via socket not fully adapted yet") // Placeholder
+		buildErr = newBuildError(CodeComposeFile, fmt.Errorf("compose build via socket not fully adapted yet")) // Placeholder
 		finalStatus = "failure"
 		return
 	} else {
 		// --- 7b. Build using Dockerfile ---
 		dockerfilePath, buildContextDir, err := s.findDockerfile(buildDir, spec)
 		if err != nil {
-			buildErr = err
+			buildErr = newBuildError(CodeDockerBuild, err)
 			finalStatus = "failure"
 			return
 		}
 		buildLogger.Printf("Building with Dockerfile: %s (Context: %s)\n", dockerfilePath, buildContextDir)
+		events.Progressf(spec.Name, 1, 1, "Building service '%s'", spec.Name)
 
 		// *** Modifier buildSingleImage pour accepter un io.Writer pour les logs ***
 		imageID, err := s.buildSingleImageWithLogs(ctx, buildContextDir, dockerfilePath, spec, stdoutNotifier) // Nouvelle fonction
 		if err != nil {
-			buildErr = fmt.Errorf("docker build failed: %w", err)
+			buildErr = newBuildError(CodeDockerBuild, fmt.Errorf("docker build failed: %w", err))
 			finalStatus = "failure"
 			return
 		}
@@ -254,16 +372,20 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 
 	// --- 8. Handle Build Outputs ---
 	notifier.NotifyStatus(buildID, "saving_artifacts", "", nil, nil)
+	events.StartPhase(PhaseOutput)
 	buildLogger.Println("Handling build outputs...")
 	// ... (logique de tagging d'image comme avant) ...
 	finalImageTags := make(map[string][]string) // Recréer cette map pour le run.yml
 	// ... (appliquer les tags) ...
 
 	// Adapter la logique de OutputTarget
-	outputBasePath := buildDir // Base par défaut
-	if spec.BuildConfig.OutputTarget == "local" && spec.BuildConfig.LocalPath != "" {
-		outputBasePath = spec.BuildConfig.LocalPath // Logique inchangée
-		os.MkdirAll(outputBasePath, 0755) // Créer si besoin
+	outputBasePath := s.outputBasePath(spec, buildDir)
+	if outputBasePath != buildDir {
+		if err := s.OutputOptions().MkdirAll(outputBasePath); err != nil {
+			buildErr = newBuildError(CodeOutputUpload, fmt.Errorf("cannot create the output directory '%s': %w", outputBasePath, err))
+			finalStatus = "failure"
+			return
+		}
 	}
 
 	buildLogger.Printf("Output target: %s\n", spec.BuildConfig.OutputTarget)
@@ -274,12 +396,12 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 		artifactRef = "b2://not/implemented/yet" // Placeholder
 	case "local":
 		for serviceName, serviceOutput := range result.ServiceOutputs {
-			imageFileName := fmt.Sprintf("%s_%s.tar", spec.Name, serviceName)
+			imageFileName := fmt.Sprintf("%s_%s%s", spec.Name, serviceName, ImageArchiveExt(spec.BuildConfig.Compression))
 			localImagePath := filepath.Join(outputBasePath, imageFileName)
 			buildLogger.Printf("Saving image for service '%s' locally to %s...\n", serviceName, localImagePath)
-			err := s.saveImageLocally(ctx, serviceOutput.ImageID, localImagePath)
+			_, err := s.saveImageLocally(ctx, serviceOutput.ImageID, localImagePath, spec.BuildConfig)
 			if err != nil {
-				buildErr = fmt.Errorf("failed to save image '%s' locally: %w", serviceName, err)
+				buildErr = newBuildError(CodeOutputUpload, fmt.Errorf("failed to save image '%s' locally: %w", serviceName, err))
 				finalStatus = "failure"
 				return
 			}
@@ -288,8 +410,8 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 				artifactRef = localImagePath // Chemin absolu ici
 			}
 		}
-		// Si sortie locale sans chemin spécifique, ne pas nettoyer le buildDir
-		if spec.BuildConfig.LocalPath == "" {
+		// Si les sorties locales sont dans le buildDir, ne pas le nettoyer
+		if outputBasePath == buildDir {
 			shouldCleanup = false
 		}
 
@@ -305,21 +427,47 @@ func (s *BuildService) runBuildLogic(ctx context.Context, buildID string, spec *
 		buildLogger.Printf("Images available in local Docker daemon. Artifact ref: %s\n", artifactRef)
 
 	}
-	if buildErr != nil { return } // Vérifier après la gestion des sorties
+	if buildErr != nil { return buildErr } // Vérifier après la gestion des sorties
 
 
 	// --- 9. Generate *.run.yml (si demandé) ---
 	if spec.RunConfigDef.Generate {
+		events.StartPhase(PhaseRunConfig)
 		buildLogger.Println("Generating *.run.yml file...")
-		// ... (Logique de generateRunYAML comme avant, mais charger le projet compose ici si nécessaire) ...
-		// Le chemin de sortie doit être dans outputBasePath
+		// Les avertissements des fichiers écrits à côté du run.yml sont recopiés dans les logs du build
+		runEvents := newEventStream(nil)
+		runEvents.redactor = redactor
+		// Le chemin de sortie doit être dans outputBasePath. Les builds compose ont échoué plus haut,
+		// il n'y a pas de projet compose à charger ici.
 		runConfigPath := filepath.Join(outputBasePath, fmt.Sprintf("%s-%s.run.yml", spec.Name, spec.Version))
-		// ... (générer et écrire le fichier) ...
-		// Si succès, on pourrait ajouter le chemin run.yml à l'artifactRef ou un message de statut ?
+		runYAML, err := s.generateRunYAML(ctx, spec, result, finalRuntimeEnv, finalImageTags, nil)
+		if err != nil {
+			runEvents.Warnf("error during the run.yml generating: %v", err)
+		} else if runYAML != nil && len(runYAML.Services) > 0 {
+			if spec.RunConfigDef.SecretsEnvFile {
+				s.writeSecretsEnvFile(spec, runYAML, runtimeSecrets, outputBasePath, result, runEvents)
+			}
+			yamlData, err := yaml.Marshal(runYAML)
+			if err != nil {
+				runEvents.Warnf("Failed to parse run file for run.yml generation: %v", err)
+			} else if err := s.OutputOptions().WriteFile(runConfigPath, yamlData); err != nil {
+				runEvents.Warnf("Failed to write the run file '%s': %v", runConfigPath, err)
+			} else {
+				result.RunConfigPath = runConfigPath
+				runEvents.Logf("Run file written to %s", runConfigPath)
+			}
+			if spec.RunConfigDef.GenerateCompose {
+				s.writeGeneratedCompose(spec, runYAML, finalImageTags, nil, outputBasePath, result, runEvents)
+			}
+		} else {
+			runEvents.Log("Skipping writing run.yml as no services were generated.")
+		}
+		buildLogger.Print(runEvents.Render())
 	}
 
 	buildLogger.Println("Build process completed successfully.")
 	// Le defer s'occupera d'envoyer le statut final "success"
+	return nil
 }
 
 
@@ -390,7 +538,7 @@ func (s *BuildService) buildSingleImageWithLogs(ctx context.Context, buildContex
 	for k, v := range spec.BuildConfig.Args { value := v; buildOptions.BuildArgs[k] = &value }
 
 	fmt.Fprintf(logWriter, "Starting Docker build (Dockerfile: %s, Context: %s)...\n", buildOptions.Dockerfile, buildContextDir)
-	buildResponse, err := s.dockerClient.ImageBuild(ctx, buildContextTar, buildOptions)
+	buildResponse, err := s.backendFor(ctx).ImageBuild(ctx, buildContextTar, buildOptions)
 	// ... (gestion fallback legacy builder si besoin) ...
 	if err != nil {
 		fmt.Fprintf(logWriter, "ERROR starting Docker build: %v\n", err)
@@ -446,5 +594,142 @@ func (s *BuildService) buildSingleImageWithLogs(ctx context.Context, buildContex
 	}
 
 	fmt.Fprintf(logWriter, "Docker build finished. Image ID: %s\n", imageID)
+	journalFrom(ctx).Track(ResourceImage, imageID)
 	return imageID, nil
-}
\ No newline at end of file
+}
+// --- Implémentation de socket.ProjectConfigManager ---
+
+// HandleProjectConfig serves the project variables and secret references CRUD requests
+func (s *BuildService) HandleProjectConfig(ctx context.Context, req socket.ProjectConfigRequestPayload) (*socket.ProjectConfigResponsePayload, error) {
+	store := s.Projects()
+	if store == nil {
+		return nil, fmt.Errorf("no project store configured on the build service")
+	}
+	if req.Action != socket.ProjectActionList && req.Project == "" {
+		return nil, fmt.Errorf("the project name is required for the action '%s'", req.Action)
+	}
+
+	var err error
+	switch req.Action {
+	case socket.ProjectActionList:
+		resp := &socket.ProjectConfigResponsePayload{Projects: []socket.ProjectConfigPayload{}}
+		for _, project := range store.List() {
+			resp.Projects = append(resp.Projects, projectPayload(&project))
+		}
+		return resp, nil
+	case socket.ProjectActionGet:
+	case socket.ProjectActionSetVariable:
+		err = store.SetVariable(req.Project, req.Key, req.Value)
+	case socket.ProjectActionDeleteVariable:
+		err = store.DeleteVariable(req.Project, req.Key)
+	case socket.ProjectActionSetSecret:
+		err = store.SetSecret(req.Project, SecretSpec{Name: req.Key, Source: req.Source, InjectMethod: req.InjectMethod})
+	case socket.ProjectActionDeleteSecret:
+		err = store.DeleteSecret(req.Project, req.Key)
+	case socket.ProjectActionDelete:
+		if err := store.Delete(req.Project); err != nil {
+			return nil, err
+		}
+		return &socket.ProjectConfigResponsePayload{Projects: []socket.ProjectConfigPayload{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown project config action '%s'", req.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := store.Get(req.Project)
+	if err != nil {
+		return nil, err
+	}
+	return &socket.ProjectConfigResponsePayload{Projects: []socket.ProjectConfigPayload{projectPayload(project)}}, nil
+}
+
+func projectPayload(project *ProjectConfig) socket.ProjectConfigPayload {
+	payload := socket.ProjectConfigPayload{
+		Name:      project.Name,
+		Env:       project.Env,
+		UpdatedAt: project.UpdatedAt.Format(time.RFC3339),
+	}
+	for _, secret := range project.Secrets {
+		payload.Secrets = append(payload.Secrets, socket.ProjectSecretRef{Name: secret.Name, Source: secret.Source, InjectMethod: secret.InjectMethod})
+	}
+	return payload
+}
+
+// --- Implementation of socket.BuildLister ---
+
+// HandleBuildList lists the builds known by the queue, newest submission first
+func (s *BuildService) HandleBuildList(ctx context.Context, req socket.BuildListPayload) (*socket.BuildListResponsePayload, error) {
+	if req.State != "" {
+		switch BuildState(req.State) {
+		case BuildStateQueued, BuildStateRunning, BuildStateSuccess, BuildStateFailure, BuildStateCanceled:
+		default:
+			return nil, fmt.Errorf("unknown build state '%s'", req.State)
+		}
+	}
+	builds := s.ListBuilds()
+	resp := &socket.BuildListResponsePayload{Builds: []socket.BuildInfoPayload{}}
+	for i := len(builds) - 1; i >= 0; i-- {
+		if req.Limit > 0 && len(resp.Builds) == req.Limit {
+			break
+		}
+		if (req.State != "" && string(builds[i].State) != req.State) || (req.Name != "" && builds[i].Name != req.Name) {
+			continue
+		}
+		resp.Builds = append(resp.Builds, buildInfoPayload(&builds[i]))
+	}
+	return resp, nil
+}
+
+// HandleBuildGet returns the status of a queued, running or recently finished build
+func (s *BuildService) HandleBuildGet(ctx context.Context, req socket.BuildGetPayload) (*socket.BuildGetResponsePayload, error) {
+	status, err := s.GetStatus(req.BuildID)
+	if errors.Is(err, ErrBuildNotFound) {
+		return nil, socket.WithCode(socket.ErrCodeBuildNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &socket.BuildGetResponsePayload{Build: buildInfoPayload(status)}, nil
+}
+
+// HandleBuildCancel implements socket.BuildCanceler
+func (s *BuildService) HandleBuildCancel(ctx context.Context, req socket.BuildCancelPayload) (*socket.BuildCancelResponsePayload, error) {
+	status, err := s.CancelBuild(req.BuildID)
+	switch {
+	case errors.Is(err, ErrBuildNotFound):
+		return nil, socket.WithCode(socket.ErrCodeBuildNotFound, err)
+	case errors.Is(err, ErrBuildFinished):
+		return nil, socket.WithCode(socket.ErrCodeBuildFinished, err)
+	case err != nil:
+		return nil, err
+	}
+	return &socket.BuildCancelResponsePayload{Build: buildInfoPayload(status)}, nil
+}
+
+func buildInfoPayload(status *BuildStatus) socket.BuildInfoPayload {
+	payload := socket.BuildInfoPayload{
+		BuildID:     status.BuildID,
+		Name:        status.Name,
+		Version:     status.Version,
+		Branch:      status.Branch,
+		Priority:    status.Priority,
+		State:       string(status.State),
+		Position:    status.Position,
+		SubmittedAt: status.SubmittedAt.Format(time.RFC3339),
+		Error:       status.Error,
+		ErrorCode:   string(status.ErrorCode),
+	}
+	if status.StartedAt != nil {
+		payload.StartedAt = status.StartedAt.Format(time.RFC3339)
+		if status.FinishedAt != nil {
+			duration := status.FinishedAt.Sub(*status.StartedAt).Seconds()
+			payload.DurationSec = &duration
+		}
+	}
+	if status.FinishedAt != nil {
+		payload.FinishedAt = status.FinishedAt.Format(time.RFC3339)
+	}
+	return payload
+}
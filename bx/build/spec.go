@@ -1,9 +1,10 @@
 package build
 
 import (
+	"log/slog"
 	"sync"
 
-	"github.com/docker/docker/client"
+	"github.com/Treefle-labs/Anexis/bx/notify"
 )
 
 // --- Struct Definitions ---
@@ -21,6 +22,8 @@ type BuildSpec struct {
 	EnvFiles     []string          `json:"env_files,omitempty" yaml:"env_files,omitempty"`           // Used to load the Envs from the provided file path
 	Secrets      []SecretSpec      `json:"secrets,omitempty" yaml:"secrets,omitempty"`               // Secrets specifications. Secrets is like env vars but it's provided by a specific service and encrypted/decrypted during the usage. Use this to pass very sensible information to your different services
 	RunConfigDef RunConfigDef      `json:"run_config_def,omitempty" yaml:"run_config_def,omitempty"` // Configuration for the *.run.yml file. This file is used by the CLI to run your different services
+	Registries   []RegistryConfig  `json:"registries,omitempty" yaml:"registries,omitempty"`         // Credentials of the private registries, the docker config of the user is used for the others
+	DockerHost   *DockerHostConfig `json:"docker_host,omitempty" yaml:"docker_host,omitempty"`       // Remote docker daemon running the build, the backend of the service if empty
 }
 
 // Representation of any codebase in the services
@@ -54,45 +57,90 @@ type BuildStep struct {
 
 // BuildConfig is a Docker build config spec extended
 type BuildConfig struct {
-	BaseImage    string            `json:"base_image,omitempty" yaml:"base_image,omitempty"`     // The base image to use
-	Dockerfile   string            `json:"dockerfile,omitempty" yaml:"dockerfile,omitempty"`     // relative path of the Dockerfile or the inline content
-	ComposeFile  string            `json:"compose_file,omitempty" yaml:"compose_file,omitempty"` // the relative compose file path
-	Target       string            `json:"target,omitempty" yaml:"target,omitempty"`
-	Args         map[string]string `json:"args,omitempty" yaml:"args,omitempty"`             // Ens vars to inject in the build config
-	Tags         []string          `json:"tags,omitempty" yaml:"tags,omitempty"`             // Tags for the finale docker image (or the principal image in case of compose)
-	Platforms    []string          `json:"platforms,omitempty" yaml:"platforms,omitempty"`   // cross-platform support (experimental)
-	NoCache      bool              `json:"no_cache,omitempty" yaml:"no_cache,omitempty"`     // Specify if the cache will be used between the build
-	OutputTarget string            `json:"output_target" yaml:"output_target"`               // The storage target "b2", "local", "docker" (by default)
-	LocalPath    string            `json:"local_path,omitempty" yaml:"local_path,omitempty"` // Output path if OutputTarget="local"
-	Pull         bool              `json:"pull,omitempty" yaml:"pull,omitempty"`             // Trying to pull the based image
-	BuildKit     bool              `json:"buildkit,omitempty" yaml:"buildkit,omitempty"`     // Use BuildKit (if available)
+	BaseImage          string                  `json:"base_image,omitempty" yaml:"base_image,omitempty"`     // The base image to use
+	Dockerfile         string                  `json:"dockerfile,omitempty" yaml:"dockerfile,omitempty"`     // relative path of the Dockerfile or the inline content
+	ComposeFile        string                  `json:"compose_file,omitempty" yaml:"compose_file,omitempty"` // the relative compose file path
+	Target             string                  `json:"target,omitempty" yaml:"target,omitempty"`
+	Args               map[string]string       `json:"args,omitempty" yaml:"args,omitempty"`                               // Ens vars to inject in the build config
+	Tags               []string                `json:"tags,omitempty" yaml:"tags,omitempty"`                               // Tags for the finale docker image (or the principal image in case of compose)
+	Platforms          []string                `json:"platforms,omitempty" yaml:"platforms,omitempty"`                     // cross-platform support (experimental)
+	NoCache            bool                    `json:"no_cache,omitempty" yaml:"no_cache,omitempty"`                       // Specify if the cache will be used between the build
+	OutputTarget       string                  `json:"output_target" yaml:"output_target"`                                 // The storage target "b2", "local", "docker" (by default)
+	LocalPath          string                  `json:"local_path,omitempty" yaml:"local_path,omitempty"`                   // Output path if OutputTarget="local"
+	Pull               bool                    `json:"pull,omitempty" yaml:"pull,omitempty"`                               // Trying to pull the based image
+	BuildKit           bool                    `json:"buildkit,omitempty" yaml:"buildkit,omitempty"`                       // Use BuildKit (if available)
+	Labels             map[string]string       `json:"labels,omitempty" yaml:"labels,omitempty"`                           // Labels of the built images, they override the revision labels set from the codebases
+	SSHForward         []string                `json:"ssh_forward,omitempty" yaml:"ssh_forward,omitempty"`                 // SSH agents or keys exposed to `RUN --mount=type=ssh`, in the `docker build --ssh` syntax ("default", "github=~/.ssh/id_ed25519")
+	CacheFrom          []string                `json:"cache_from,omitempty" yaml:"cache_from,omitempty"`                   // Images used as cache sources, pulled with the registry credentials
+	Push               bool                    `json:"push,omitempty" yaml:"push,omitempty"`                               // Push the tags of the built images to their registries
+	KeepStepImages     bool                    `json:"keep_step_images,omitempty" yaml:"keep_step_images,omitempty"`       // Keep the images of the build steps, removed when the build ends by default
+	Compression        string                  `json:"compression,omitempty" yaml:"compression,omitempty"`                 // Compression of the image archives of the "local" and "b2" outputs: "none" (default), "gzip" or "zstd"
+	CompressionLevel   int                     `json:"compression_level,omitempty" yaml:"compression_level,omitempty"`     // Level of the compression (gzip 1-9, zstd 1-22), the default of the algorithm if 0
+	Ecosystem          string                  `json:"ecosystem,omitempty" yaml:"ecosystem,omitempty"`                     // Ecosystem of the Dockerfile generated for a codebase without one ("Go", "JavaScript-pnpm"), detected when empty
+	DockerfileTemplate *DockerfileTemplateData `json:"dockerfile_template,omitempty" yaml:"dockerfile_template,omitempty"` // Values of the template of the Dockerfile generated for a codebase without one
+	Concurrency        string                  `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`                 // Builds of the same name and version on the service: "allow" (default), "queue" or "reject"
+}
+
+// RegistryConfig gives the credentials of a private registry. The password and the token are
+// secret sources resolved with the secret fetcher of the build, never values.
+type RegistryConfig struct {
+	Host     string `json:"host" yaml:"host"`                             // "ghcr.io", "registry.example.com:5000", "docker.io"
+	Username string `json:"username,omitempty" yaml:"username,omitempty"` // Required with a password
+	Password string `json:"password,omitempty" yaml:"password,omitempty"` // Secret source of the password or of an access token used as password
+	Token    string `json:"token,omitempty" yaml:"token,omitempty"`       // Secret source of a registry bearer token, instead of a username and a password
+}
+
+// DockerHostConfig is a remote docker daemon dedicated to the builds. The TLS files are read on the
+// machine of the service, in the DOCKER_CERT_PATH layout (ca.pem, cert.pem, key.pem) or one by one.
+type DockerHostConfig struct {
+	Host      string `json:"host" yaml:"host"`                                 // "tcp://builder-1:2376", "unix:///var/run/docker.sock"
+	TLSVerify bool   `json:"tls_verify,omitempty" yaml:"tls_verify,omitempty"` // Verify the certificate of the daemon with the CA
+	CertPath  string `json:"cert_path,omitempty" yaml:"cert_path,omitempty"`   // Directory of ca.pem, cert.pem and key.pem
+	CACert    string `json:"ca_cert,omitempty" yaml:"ca_cert,omitempty"`       // Path of the CA certificate, overrides the one of cert_path
+	Cert      string `json:"cert,omitempty" yaml:"cert,omitempty"`             // Path of the client certificate, overrides the one of cert_path
+	Key       string `json:"key,omitempty" yaml:"key,omitempty"`               // Path of the client key, overrides the one of cert_path
 }
 
 // SecretSpec define the way to fetch the secrets
 type SecretSpec struct {
-	Name         string `json:"name" yaml:"name"`                   // The name of the env var that will receive the secret
-	Source       string `json:"source" yaml:"source"`               // The service ID for this secret
-	InjectMethod string `json:"inject_method" yaml:"inject_method"` // "env" (default), can be file later
+	Name         string `json:"name" yaml:"name"`                         // The name of the env var that will receive the secret
+	Source       string `json:"source" yaml:"source"`                     // The service ID for this secret
+	InjectMethod string `json:"inject_method" yaml:"inject_method"`       // "env" (default), "build" (BuildKit secret mount with the secret name as id, kept out of the run.yml) or "file"
+	Key          string `json:"key,omitempty" yaml:"key,omitempty"`       // Dotted path of the value in a JSON secret ("db.password"), the whole secret if empty
+	Target       string `json:"target,omitempty" yaml:"target,omitempty"` // Path of the file in the containers for the "file" method, /run/secrets/<name> by default
 }
 
 // RunConfigDef define the parameters for the *.run.yml generation
 type RunConfigDef struct {
-	Generate        bool     `json:"generate" yaml:"generate"`                     // Is the file will be generated ?
-	ArtifactStorage string   `json:"artifact_storage" yaml:"artifact_storage"`     // "docker" (use the tags), "local" (referencing .tar)
-	Commands        []string `json:"commands,omitempty" yaml:"commands,omitempty"` // The default commands (overriding if needed)
+	Generate        bool     `json:"generate" yaml:"generate"`                                     // Is the file will be generated ?
+	ArtifactStorage string   `json:"artifact_storage" yaml:"artifact_storage"`                     // "docker" (use the tags), "local" (referencing .tar), "b2" (referencing b2://<bucket>/<archive>)
+	Commands        []string `json:"commands,omitempty" yaml:"commands,omitempty"`                 // The default commands (overriding if needed)
+	GenerateCompose bool     `json:"generate_compose,omitempty" yaml:"generate_compose,omitempty"` // Also write a docker-compose file (<name>-<version>.compose.yml) using the image tags
+	SecretsEnvFile  bool     `json:"secrets_env_file,omitempty" yaml:"secrets_env_file,omitempty"` // Write the secret values to <name>-<version>.env (mode 0600), the run.yml only names them
 	// Some other options can be added after...
 }
 
 // RunService is any service representation in the *.run.yml
 type RunService struct {
-	Image       string            `yaml:"image"`                 // The name of the tar local image
-	Command     []string          `yaml:"command,omitempty"`     // The command to exec
-	Entrypoint  []string          `yaml:"entrypoint,omitempty"`  // The entry point
-	Environment map[string]string `yaml:"environment,omitempty"` // Environment variables (include secrets)
-	Ports       []string          `yaml:"ports,omitempty"`       // Format "host:container"
-	Volumes     []string          `yaml:"volumes,omitempty"`     // Format "host:container" ou "named:container"
-	Restart     string            `yaml:"restart,omitempty"`     // Reboot politic (e.g., "always", "on-failure")
-	DependsOn   []string          `yaml:"depends_on,omitempty"`  // The depending services
+	Image       string            `yaml:"image"`                  // The name of the tar local image
+	Command     []string          `yaml:"command,omitempty"`      // The command to exec
+	Entrypoint  []string          `yaml:"entrypoint,omitempty"`   // The entry point
+	Environment map[string]string `yaml:"environment,omitempty"`  // Environment variables (include secrets unless secrets_env_file is set)
+	SecretEnv   []string          `yaml:"secret_env,omitempty"`   // Environment variables read from the env_file of the run.yml
+	Ports       []string          `yaml:"ports,omitempty"`        // Format "host:container"
+	Volumes     []string          `yaml:"volumes,omitempty"`      // Format "host:container" ou "named:container"
+	Restart     string            `yaml:"restart,omitempty"`      // Reboot politic (e.g., "always", "on-failure")
+	DependsOn   Dependencies      `yaml:"depends_on,omitempty"`   // The services started first, with their condition
+	SecretFiles []RunSecretFile   `yaml:"secret_files,omitempty"` // Secrets mounted as files from a tmpfs at run time
+	HealthCheck *HealthCheck      `yaml:"healthcheck,omitempty"`  // Health check of the container
+	Networks    []string          `yaml:"networks,omitempty"`     // Networks of the project joined by the container, reachable by the service name
+	NetworkMode string            `yaml:"network_mode,omitempty"` // "host", "none", "container:<name>"..., exclusive with Networks
+	Labels      map[string]string `yaml:"labels,omitempty"`       // Container labels
+	User        string            `yaml:"user,omitempty"`         // User running the process, "uid[:gid]" or a name
+	WorkingDir  string            `yaml:"working_dir,omitempty"`  // Working directory of the process
+	Tmpfs       []string          `yaml:"tmpfs,omitempty"`        // Mount points of tmpfs filesystems
+	Resources   *ServiceResources `yaml:"resources,omitempty"`    // CPU and memory limits
+	Profiles    []string          `yaml:"profiles,omitempty"`     // Profiles starting the service, always started if empty
 	// Some other fields can be added later...
 }
 
@@ -100,24 +148,61 @@ type RunService struct {
 type RunYAML struct {
 	Version  string                `yaml:"version"` // The file version format
 	Services map[string]RunService `yaml:"services"`
-	// potentially other sections for volumes, networks, etc.
+	EnvFile  string                `yaml:"env_file,omitempty"` // Dotenv file holding the secret_env values, relative to the run.yml
+	Networks map[string]RunNetwork `yaml:"networks,omitempty"` // Networks of the project joined by the services
+	Volumes  map[string]RunVolume  `yaml:"volumes,omitempty"`  // Named volumes of the project mounted by the services
+}
+
+// RunNetwork is a network of the project, created on the engine as <project>_<name> when missing
+type RunNetwork struct {
+	Driver     string            `yaml:"driver,omitempty"`      // "bridge" by default
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"` // Options of the driver
+	Internal   bool              `yaml:"internal,omitempty"`    // No route to the outside
+	External   bool              `yaml:"external,omitempty"`    // Existing network, never created
+	Name       string            `yaml:"name,omitempty"`        // Name on the engine, instead of <project>_<name>
+	Labels     map[string]string `yaml:"labels,omitempty"`
+}
+
+// RunVolume is a named volume of the project, created on the engine as <project>_<name> when missing
+type RunVolume struct {
+	Driver     string            `yaml:"driver,omitempty"`      // "local" by default
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"` // Options of the driver
+	External   bool              `yaml:"external,omitempty"`    // Existing volume, never created
+	Name       string            `yaml:"name,omitempty"`        // Name on the engine, instead of <project>_<name>
+	Labels     map[string]string `yaml:"labels,omitempty"`
+}
+
+// RunSecretFile is a secret written in a tmpfs and mounted read-only in the container when it runs
+type RunSecretFile struct {
+	Name    string `yaml:"name"`
+	Target  string `yaml:"target"`  // Absolute path in the container
+	Content string `yaml:"content"` // Base64 encoded value, binary secrets included
 }
 
 // BuildResult is the struct representing a build result of each service
 type BuildResult struct {
-	Success         bool                     `json:"success"`
-	ImageID         string                   `json:"image_id,omitempty"`          // The docker image ID (if applicable)
-	ImageIDs        map[string]string        `json:"image_ids,omitempty"`         // Each service IDS (if compose)
-	ImageSize       int64                    `json:"image_size,omitempty"`        // The main docker image size
-	ImageSizes      map[string]int64         `json:"image_sizes,omitempty"`       // Image size by service
-	Artifacts       map[string][]byte        `json:"-"`                           // Memory artefact
-	BuildTime       float64                  `json:"build_time"`                  // Total Build time
-	ErrorMessage    string                   `json:"error_message,omitempty"`     // Build error message
-	Logs            string                   `json:"logs"`                        // Build logs
-	B2ObjectNames   []string                 `json:"b2_object_names,omitempty"`   // For OutputTarget="b2"
-	LocalImagePaths map[string]string        `json:"local_image_paths,omitempty"` // For OutputTarget="local"
-	RunConfigPath   string                   `json:"run_config_path,omitempty"`   // Path to the generated *.run.yml file
-	ServiceOutputs  map[string]ServiceOutput `json:"service_outputs,omitempty"`   // Specific information generated by service
+	BuildID         string                      `json:"build_id,omitempty"` // ID of the build, in its logs, labels and journal
+	Success         bool                        `json:"success"`
+	ImageID         string                      `json:"image_id,omitempty"`          // The docker image ID (if applicable)
+	ImageIDs        map[string]string           `json:"image_ids,omitempty"`         // Each service IDS (if compose)
+	ImageSize       int64                       `json:"image_size,omitempty"`        // The main docker image size
+	ImageSizes      map[string]int64            `json:"image_sizes,omitempty"`       // Image size by service
+	Artifacts       map[string][]byte           `json:"-"`                           // Memory artefact
+	BuildTime       float64                     `json:"build_time"`                  // Total Build time
+	ErrorMessage    string                      `json:"error_message,omitempty"`     // Build error message
+	ErrorCode       ErrorCode                   `json:"error_code,omitempty"`        // Machine readable code of the failure (Code* constants)
+	Logs            string                      `json:"logs"`                        // Build logs
+	B2ObjectNames   []string                    `json:"b2_object_names,omitempty"`   // For OutputTarget="b2"
+	LocalImagePaths map[string]string           `json:"local_image_paths,omitempty"` // For OutputTarget="local"
+	RunConfigPath   string                      `json:"run_config_path,omitempty"`   // Path to the generated *.run.yml file
+	ManifestPath    string                      `json:"manifest_path,omitempty"`     // Path to the artifact manifest (JSON) listing the images
+	ComposeFilePath string                      `json:"compose_file_path,omitempty"` // Path to the generated docker-compose file
+	EnvFilePath     string                      `json:"env_file_path,omitempty"`     // Path to the env file holding the secrets of the run.yml
+	ExposedPort     int                         `json:"exposed_port,omitempty"`      // Port exposed by the Dockerfile generated for a codebase without one, published in the run.yml
+	ServiceOutputs  map[string]ServiceOutput    `json:"service_outputs,omitempty"`   // Specific information generated by service
+	Codebases       map[string]CodebaseRevision `json:"codebases,omitempty"`         // Git revision of each codebase, by codebase name
+	ArchiveDigests  map[string]string           `json:"archive_digests,omitempty"`   // sha256 digest of the image archive of each service, for the "local" and "b2" outputs
+	Timings         map[string]float64          `json:"timings,omitempty"`           // Duration in seconds of each phase, and of each step and service within it ("build/api")
 }
 
 // ServiceOutput is the specific information for each builded service (e.g., image ID)
@@ -137,12 +222,19 @@ type B2Config struct {
 
 // The Main service to manage each build
 type BuildService struct {
-	dockerClient  *client.Client
+	backend       ContainerBackend // Docker daemon or Podman service building the images
 	workDir       string
 	b2Config      *B2Config
 	mutex         sync.Mutex
-	inMemory      bool          // if true minimizing the system disk usage
-	secretFetcher SecretFetcher // Interface for secrets fetching
+	inMemory      bool                  // if true minimizing the system disk usage
+	secretFetcher SecretFetcher         // Interface for secrets fetching
+	queue         *buildQueue           // Started on the first submission
+	queueWorkers  int                   // Number of builds running simultaneously in the queue
+	projects      *ProjectStore         // Project level variables and secrets, optional
+	notifier      *notify.Dispatcher    // Build notifications, optional
+	output        OutputOptions         // Location and permissions of the outputs
+	logger        *slog.Logger          // Structured logs of the builds, slog.Default() if nil
+	buildLocks    map[string]*buildLock // Locks of the name@version builds, see BuildConfig.Concurrency
 }
 
 type ComposeProject struct {
@@ -153,23 +245,54 @@ type ComposeProject struct {
 	Networks map[string]interface{} `yaml:"networks,omitempty"`
 }
 
-// A representation of a compose service (simplified)
+// A compose service, normalized by compose-go: the long syntaxes are converted to the short strings
+// of the docker CLI (ports "[ip:]published:target[/protocol]", volumes "source:target[:ro]")
 type ComposeService struct {
 	Image           string             `yaml:"image,omitempty"`
 	Build           *ComposeBuild      `yaml:"build,omitempty"`
 	Command         []string           `yaml:"command,omitempty"`
 	Entrypoint      []string           `yaml:"entrypoint,omitempty"`
 	Environment     map[string]*string `yaml:"environment,omitempty"`
+	EnvFile         ComposeEnvFiles    `yaml:"env_file,omitempty"` // Loaded relative to the compose file, environment wins over it
 	Ports           []string           `yaml:"ports,omitempty"`
 	Volumes         []string           `yaml:"volumes,omitempty"`
-	DependsOn       []string           `yaml:"depends_on,omitempty"`
+	DependsOn       Dependencies       `yaml:"depends_on,omitempty"`
 	Restart         string             `yaml:"restart,omitempty"`
 	HealthCheck     *HealthCheck       `yaml:"healthcheck,omitempty"`
 	Labels          map[string]string  `yaml:"labels,omitempty"`
 	Expose          []string           `yaml:"expose,omitempty"`
 	StopGracePeriod string             `yaml:"stop_grace_period,omitempty"`
+	Networks        []string           `yaml:"networks,omitempty"` // Networks joined by the service, the implicit "default" network excluded
+	NetworkMode     string             `yaml:"network_mode,omitempty"`
+	User            string             `yaml:"user,omitempty"`
+	WorkingDir      string             `yaml:"working_dir,omitempty"`
+	Tmpfs           []string           `yaml:"tmpfs,omitempty"` // From `tmpfs` and the tmpfs volumes
+	Deploy          *ComposeDeploy     `yaml:"deploy,omitempty"`
+	Profiles        []string           `yaml:"profiles,omitempty"`
 }
 
+// ComposeDeploy is the deploy section of a compose service, with the cpus and mem_limit attributes merged in
+type ComposeDeploy struct {
+	Replicas  *int              `yaml:"replicas,omitempty"`
+	Resources *ServiceResources `yaml:"resources,omitempty"`
+}
+
+// ServiceResources are the resource constraints of a service container
+type ServiceResources struct {
+	CPUs              float64 `yaml:"cpus,omitempty"`               // Limit in number of CPUs
+	Memory            int64   `yaml:"memory,omitempty"`             // Limit in bytes
+	MemoryReservation int64   `yaml:"memory_reservation,omitempty"` // Soft limit in bytes
+}
+
+// ComposeEnvFile is an env_file entry of a compose service, `env_file: .env` or `env_file: [{path: .env, required: false}]`
+type ComposeEnvFile struct {
+	Path     string `yaml:"path"`
+	Required bool   `yaml:"required"` // true unless set to false in the long syntax
+}
+
+// ComposeEnvFiles is the env_file of a compose service, written as a single entry or as a list
+type ComposeEnvFiles []ComposeEnvFile
+
 type ComposeBuild struct {
 	Context    string
 	Dockerfile string
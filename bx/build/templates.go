@@ -1,16 +1,15 @@
 package build
 
-
-// dockerfileTemplates mappe un identifiant d'écosystème à son template Dockerfile.
-// La clé est généralement "Language-PackageManager" ou "Language-Ecosystem".
+// DockerfileTemplates mappe un identifiant d'écosystème à son template Dockerfile, un document
+// text/template rendu avec un DockerfileTemplateData (voir RenderDockerfile).
+// La clé est généralement "Language-PackageManager" ou "Language-Ecosystem", ou le framework
+// ("Framework-PackageManager" ou "Framework") pour les templates spécialisés.
 var DockerfileTemplates = map[string]string{
 	// --- Go ---
 	"Go-go": `
 # --- Build Stage ---
-# Utiliser une image Go spécifique (ajuster la version au besoin)
-# ARG GOLANG_VERSION=1.21
-# FROM golang:${GOLANG_VERSION}-alpine AS builder
-FROM golang:1.21-alpine AS builder
+# Utiliser une image Go spécifique (language_version)
+FROM golang:{{.LanguageVersion}}-alpine AS builder
 
 # Définir le répertoire de travail
 WORKDIR /app
@@ -27,10 +26,10 @@ RUN go mod download
 # Copier le reste du code source
 COPY . .
 
-# Compiler l'application
+# Compiler l'application (build_command, qui doit produire /app/{{.BinaryName}})
 # Utiliser -ldflags="-w -s" pour réduire la taille du binaire final (optionnel)
 # Utiliser CGO_ENABLED=0 pour une compilation statique si possible (pas de dépendances C)
-RUN CGO_ENABLED=0 go build -ldflags="-w -s" -o /app/main .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN CGO_ENABLED=0 go build -ldflags="-w -s" -o /app/{{.BinaryName}} {{or .MainPackage "."}}{{end}}
 
 # --- Final Stage ---
 # Utiliser une image minimale (alpine est petite, distroless est encore plus minimal)
@@ -44,30 +43,28 @@ USER appuser
 WORKDIR /app
 
 # Copier le binaire compilé depuis l'étape de build
-COPY --from=builder /app/main .
+COPY --from=builder /app/{{.BinaryName}} .
 
 # Copier les assets statiques ou fichiers de configuration si nécessaire
 # COPY --from=builder /app/templates ./templates
 # COPY --from=builder /app/static ./static
 # COPY config.yaml .
 
-# Port exposé par l'application (ajuster si nécessaire)
-EXPOSE 8080
+# Port exposé par l'application (port)
+EXPOSE {{.Port}}
 
 # Commande pour lancer l'application
-CMD ["./main"]
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["./{{.BinaryName}}"]{{end}}
 
 # Note: N'oubliez pas de créer un fichier .dockerignore efficace !
-# Exclure .git, tmp/, *.log, .vscode/, etc. et potentiellement le binaire 'main' local.
+# Exclure .git, tmp/, *.log, .vscode/, etc. et potentiellement le binaire '{{.BinaryName}}' local.
 `,
 
 	// --- Node.js (NPM) ---
 	"JavaScript-npm": `
 # --- Build Stage ---
-# Utiliser une image Node spécifique (ajuster la version LTS ou autre)
-# ARG NODE_VERSION=18
-# FROM node:${NODE_VERSION}-alpine AS builder
-FROM node:18-alpine AS builder
+# Utiliser une image Node spécifique (language_version)
+FROM node:{{.LanguageVersion}}-alpine AS builder
 
 WORKDIR /app
 
@@ -86,10 +83,10 @@ COPY . .
 # Assurez-vous que les devDependencies sont installées si nécessaire pour le build
 # Si besoin de devDependencies:
 # RUN --mount=type=cache,target=/root/.npm npm ci --ignore-scripts --prefer-offline --no-audit
-# RUN npm run build
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}# RUN npm run build{{end}}
 
 # --- Final Stage ---
-FROM node:18-alpine AS final
+FROM node:{{.LanguageVersion}}-alpine AS final
 
 WORKDIR /app
 
@@ -102,11 +99,11 @@ COPY --from=builder --chown=appuser:appgroup /app /app
 
 USER appuser
 
-# Port exposé par l'application
-EXPOSE 3000
+# Port exposé par l'application (port)
+EXPOSE {{.Port}}
 
-# Commande pour lancer l'application (ajuster selon votre point d'entrée)
-CMD ["node", "votre-fichier-main.js"] # ou "server.js", "dist/main.js", etc.
+# Commande pour lancer l'application (entrypoint, le "main" du package.json par défaut)
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["node", "{{.Entrypoint}}"]{{end}}
 
 # Note: Utilisez un .dockerignore ! Excluez node_modules, .git, *.log, dist/, build/ etc.
 `,
@@ -114,9 +111,7 @@ CMD ["node", "votre-fichier-main.js"] # ou "server.js", "dist/main.js", etc.
 	// --- Node.js (Yarn) ---
 	"JavaScript-yarn": `
 # --- Build Stage ---
-# ARG NODE_VERSION=18
-# FROM node:${NODE_VERSION}-alpine AS builder
-FROM node:18-alpine AS builder
+FROM node:{{.LanguageVersion}}-alpine AS builder
 
 WORKDIR /app
 
@@ -137,25 +132,23 @@ COPY . .
 # Optionnel: Exécuter le script de build
 # Si besoin de devDependencies:
 # RUN --mount=type=cache,target=/usr/local/share/.cache/yarn/v6 yarn install --frozen-lockfile --ignore-scripts --prefer-offline
-# RUN yarn build
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}# RUN yarn build{{end}}
 
 # --- Final Stage ---
-FROM node:18-alpine AS final
+FROM node:{{.LanguageVersion}}-alpine AS final
 WORKDIR /app
 RUN addgroup -S appgroup && adduser -S appuser -G appgroup
 COPY --from=builder --chown=appuser:appgroup /app /app
 USER appuser
-EXPOSE 3000
-CMD ["node", "votre-fichier-main.js"]
+EXPOSE {{.Port}}
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["node", "{{.Entrypoint}}"]{{end}}
 # Note: Utilisez un .dockerignore ! (node_modules, .yarn/, .git, *.log, etc.)
 `,
 
 	// --- Node.js (PNPM) ---
 	"JavaScript-pnpm": `
 # --- Build Stage ---
-# ARG NODE_VERSION=18
-# FROM node:${NODE_VERSION}-alpine AS builder
-FROM node:18-alpine AS builder
+FROM node:{{.LanguageVersion}}-alpine AS builder
 
 # Installer pnpm globalement dans l'image de build
 RUN npm install -g pnpm
@@ -178,33 +171,33 @@ COPY . .
 # Optionnel: Exécuter le script de build
 # Si besoin de devDependencies:
 # RUN --mount=type=cache,target=/root/.pnpm-store pnpm install --prefer-offline --ignore-scripts
-# RUN pnpm build
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}# RUN pnpm build{{end}}
 
 # --- Final Stage ---
 # Il est crucial de copier correctement le store pnpm ou les node_modules
 # Stratégie 1: Copier tout le répertoire /app (simple mais peut être gros)
-FROM node:18-alpine AS final
+FROM node:{{.LanguageVersion}}-alpine AS final
 WORKDIR /app
 RUN addgroup -S appgroup && adduser -S appuser -G appgroup
 COPY --from=builder --chown=appuser:appgroup /app /app
 USER appuser
-EXPOSE 3000
-CMD ["node", "votre-fichier-main.js"]
+EXPOSE {{.Port}}
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["node", "{{.Entrypoint}}"]{{end}}
 
 # Stratégie 2 (plus complexe, pour optimiser la taille): Utiliser 'pnpm deploy'
-# FROM node:18-alpine AS builder
+# FROM node:{{.LanguageVersion}}-alpine AS builder
 # ... (installations comme avant) ...
 # RUN pnpm build # Si nécessaire
 # RUN pnpm prune --prod # Optionnel, supprime les devDeps si elles ont été installées
 # RUN pnpm deploy /prod_app --prod # Crée un répertoire avec seulement les deps de prod
 #
-# FROM node:18-alpine AS final
+# FROM node:{{.LanguageVersion}}-alpine AS final
 # WORKDIR /app
 # RUN addgroup -S appgroup && adduser -S appuser -G appgroup
 # COPY --from=builder --chown=appuser:appgroup /prod_app /app # Copier le résultat de deploy
 # USER appuser
-# EXPOSE 3000
-# CMD ["node", "votre-fichier-main.js"]
+# EXPOSE {{.Port}}
+# CMD ["node", "{{.Entrypoint}}"]
 
 # Note: Utilisez un .dockerignore ! (node_modules, .git, *.log, etc.)
 `,
@@ -212,9 +205,8 @@ CMD ["node", "votre-fichier-main.js"]
 	// --- Rust (Cargo) ---
 	"Rust-cargo": `
 # --- Build Stage (Planner) ---
-# Utiliser l'image Rust officielle (ajuster version/toolchain)
-# FROM rust:1.70-slim AS planner
-FROM rust:1.70-slim AS planner
+# Utiliser l'image Rust officielle (language_version)
+FROM rust:{{.LanguageVersion}}-slim AS planner
 
 WORKDIR /app
 
@@ -230,8 +222,7 @@ RUN mkdir src && echo "fn main() {}" > src/main.rs
 RUN cargo build --release --locked
 
 # --- Build Stage (Builder) ---
-# FROM rust:1.70-slim AS builder
-FROM rust:1.70-slim AS builder
+FROM rust:{{.LanguageVersion}}-slim AS builder
 WORKDIR /app
 
 # Copier les dépendances pré-compilées du planner
@@ -244,11 +235,10 @@ COPY Cargo.toml Cargo.lock* ./
 COPY src ./src
 # COPY members/*/src ./members/*/
 
-# Compiler le projet final
-# Utilisation du cache mount de BuildKit pour le cache de compilation incrémentale
-RUN --mount=type=cache,target=/app/target \
-    --mount=type=cache,target=/usr/local/cargo/registry \
-    cargo build --release --locked
+# Compiler le projet final (build_command, qui doit produire /app/target/release/{{.BinaryName}})
+# Le cache mount de BuildKit ne peut pas couvrir /app/target, le binaire est copié depuis ce répertoire
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN --mount=type=cache,target=/usr/local/cargo/registry \
+    cargo build --release --locked{{end}}
 
 # --- Final Stage ---
 # Utiliser une image minimale. Debian slim est un bon compromis.
@@ -264,13 +254,13 @@ RUN groupadd -r appgroup && useradd --no-log-init -r -g appgroup appuser
 USER appuser
 
 # Copier le binaire compilé
-COPY --from=builder /app/target/release/your_binary_name ./ # Remplacez your_binary_name !
+COPY --from=builder /app/target/release/{{.BinaryName}} ./
 
-# Port exposé (ajuster)
-EXPOSE 8000
+# Port exposé (port)
+EXPOSE {{.Port}}
 
 # Commande de lancement
-CMD ["./your_binary_name"]
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["./{{.BinaryName}}"]{{end}}
 
 # Note: .dockerignore est crucial ! (target/, .git, etc.)
 `,
@@ -278,10 +268,8 @@ CMD ["./your_binary_name"]
 	// --- Python (Pip) ---
 	"Python-Pip": `
 # --- Build Stage ---
-# Utiliser une image Python officielle (ajuster version)
-# ARG PYTHON_VERSION=3.11
-# FROM python:${PYTHON_VERSION}-slim AS builder
-FROM python:3.11-slim AS builder
+# Utiliser une image Python officielle (language_version)
+FROM python:{{.LanguageVersion}}-slim AS builder
 
 WORKDIR /app
 
@@ -307,10 +295,10 @@ RUN --mount=type=cache,target=/root/.cache/pip \
 
 # Copier le reste du code source
 COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{end}}
 
 # --- Final Stage ---
-# FROM python:${PYTHON_VERSION}-slim AS final
-FROM python:3.11-slim AS final
+FROM python:{{.LanguageVersion}}-slim AS final
 
 WORKDIR /app
 
@@ -332,12 +320,12 @@ ENV PYTHONUNBUFFERED 1
 
 USER appuser
 
-# Port exposé (ajuster)
-EXPOSE 8000
+# Port exposé (port)
+EXPOSE {{.Port}}
 
-# Commande de lancement (ajuster selon votre application: gunicorn, uvicorn, python main.py)
-# CMD ["gunicorn", "-b", "0.0.0.0:8000", "your_project.wsgi:application"]
-CMD ["python", "your_main_script.py"]
+# Commande de lancement (entrypoint, pour gunicorn ou uvicorn surcharger la commande au lancement)
+# CMD ["gunicorn", "-b", "0.0.0.0:{{.Port}}", "your_project.wsgi:application"]
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["python", "{{.Entrypoint}}"]{{end}}
 
 # Note: .dockerignore (venv/, __pycache__/, .git, *.log, *.db, etc.)
 `,
@@ -345,11 +333,8 @@ CMD ["python", "your_main_script.py"]
 	// --- Java (Maven) ---
 	"Java-Maven": `
 # --- Build Stage ---
-# Utiliser une image Maven avec un JDK spécifique (ajuster versions)
-# ARG MAVEN_VERSION=3.8
-# ARG JDK_VERSION=17
-# FROM maven:${MAVEN_VERSION}-eclipse-temurin-${JDK_VERSION}-alpine AS builder
-FROM maven:3.8-eclipse-temurin-17-alpine AS builder
+# Utiliser une image Maven avec un JDK spécifique (language_version)
+FROM maven:3.8-eclipse-temurin-{{.LanguageVersion}}-alpine AS builder
 
 WORKDIR /app
 
@@ -366,13 +351,12 @@ COPY src ./src
 
 # Compiler et packager l'application (ex: en JAR ou WAR)
 # Le cache mount ici accélère la compilation si les sources n'ont pas changé
-RUN --mount=type=cache,target=/root/.m2 \
-    mvn package -B -DskipTests
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN --mount=type=cache,target=/root/.m2 \
+    mvn package -B -DskipTests{{end}}
 
 # --- Final Stage ---
-# Utiliser une image JRE minimale (ajuster version et distribution)
-# FROM eclipse-temurin:${JDK_VERSION}-jre-alpine AS final
-FROM eclipse-temurin:17-jre-alpine AS final
+# Utiliser une image JRE minimale (language_version)
+FROM eclipse-temurin:{{.LanguageVersion}}-jre-alpine AS final
 
 WORKDIR /app
 
@@ -385,17 +369,547 @@ USER appuser
 COPY --from=builder /app/target/*.jar ./app.jar
 # COPY --from=builder /app/target/*.war ./app.war
 
-# Port exposé (ajuster)
-EXPOSE 8080
+# Port exposé (port)
+EXPOSE {{.Port}}
 
 # Commande de lancement (ajuster)
 # Pour un JAR exécutable:
-CMD ["java", "-jar", "app.jar"]
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["java", "-jar", "app.jar"]{{end}}
 # Pour un WAR (nécessite un serveur d'application comme Tomcat, non inclus ici)
 # CMD ["catalina.sh", "run"] # Si l'image de base était Tomcat
 
 # Note: .dockerignore (target/, .git, .mvn/, *.log, etc.)
 `,
 
-	// Ajouter d'autres templates ici (Gradle, PHP/Composer, Ruby/Bundler, etc.)
-}
\ No newline at end of file
+	// --- Next.js (sortie standalone, output: "standalone" dans next.config) ---
+	"Next.js": `
+# --- Dependencies Stage ---
+FROM node:{{.LanguageVersion}}-alpine AS deps
+# libc6-compat est requis par certaines dépendances natives sur alpine
+RUN apk add --no-cache libc6-compat
+WORKDIR /app
+
+# Installer les dépendances avec le gestionnaire du lockfile
+COPY package.json yarn.lock* package-lock.json* pnpm-lock.yaml* ./
+RUN \
+  if [ -f yarn.lock ]; then yarn install --frozen-lockfile; \
+  elif [ -f package-lock.json ]; then npm ci; \
+  elif [ -f pnpm-lock.yaml ]; then corepack enable pnpm && pnpm install --frozen-lockfile; \
+  else npm install; \
+  fi
+
+# --- Build Stage ---
+FROM node:{{.LanguageVersion}}-alpine AS builder
+WORKDIR /app
+COPY --from=deps /app/node_modules ./node_modules
+COPY . .
+# Le répertoire public est optionnel mais copié dans l'image finale
+RUN mkdir -p public
+ENV NEXT_TELEMETRY_DISABLED=1
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN \
+  if [ -f yarn.lock ]; then yarn run build; \
+  elif [ -f pnpm-lock.yaml ]; then corepack enable pnpm && pnpm run build; \
+  else npm run build; \
+  fi{{end}}
+
+# --- Final Stage ---
+# La sortie standalone contient le serveur et les seules dépendances utilisées
+FROM node:{{.LanguageVersion}}-alpine AS final
+WORKDIR /app
+ENV NODE_ENV=production
+ENV NEXT_TELEMETRY_DISABLED=1
+RUN addgroup -S appgroup && adduser -S appuser -G appgroup
+
+COPY --from=builder --chown=appuser:appgroup /app/public ./public
+COPY --from=builder --chown=appuser:appgroup /app/.next/standalone ./
+COPY --from=builder --chown=appuser:appgroup /app/.next/static ./.next/static
+
+USER appuser
+
+# Port exposé (port), lu par server.js
+EXPOSE {{.Port}}
+ENV PORT={{.Port}}
+ENV HOSTNAME=0.0.0.0
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["node", "server.js"]{{end}}
+
+# Note: .dockerignore (node_modules, .next, .git, *.log, etc.)
+`,
+
+	// --- Django (gunicorn) ---
+	"Django": `
+# --- Build Stage ---
+FROM python:{{.LanguageVersion}}-slim AS builder
+
+WORKDIR /app
+
+RUN python -m venv /opt/venv
+ENV PATH="/opt/venv/bin:$PATH"
+RUN pip install --upgrade pip wheel
+
+# Installer les dépendances, gunicorn sert l'application WSGI
+COPY requirements*.txt pyproject.toml* ./
+RUN --mount=type=cache,target=/root/.cache/pip \
+    if [ -f requirements.txt ]; then pip install -r requirements.txt; else pip install .; fi && \
+    pip install gunicorn
+
+COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{end}}
+
+# --- Final Stage ---
+FROM python:{{.LanguageVersion}}-slim AS final
+
+WORKDIR /app
+
+RUN groupadd -r appgroup && useradd --no-log-init -r -g appgroup appuser
+
+COPY --from=builder /opt/venv /opt/venv
+COPY --chown=appuser:appgroup . /app
+
+ENV PATH="/opt/venv/bin:$PATH"
+ENV PYTHONDONTWRITEBYTECODE=1
+ENV PYTHONUNBUFFERED=1
+
+# Collecter les fichiers statiques (ignoré si STATIC_ROOT n'est pas configuré)
+RUN python manage.py collectstatic --noinput || true
+
+USER appuser
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+
+# Application WSGI (entrypoint, détectée depuis le <projet>/wsgi.py)
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["gunicorn", "--bind", "0.0.0.0:{{.Port}}", "{{.Entrypoint}}"]{{end}}
+
+# Note: .dockerignore (venv/, __pycache__/, .git, *.log, db.sqlite3, etc.)
+`,
+
+	// --- Spring Boot (Maven) ---
+	"Spring Boot-mvn": `
+# --- Build Stage ---
+FROM maven:3.9-eclipse-temurin-{{.LanguageVersion}} AS builder
+
+WORKDIR /app
+
+COPY pom.xml .
+RUN --mount=type=cache,target=/root/.m2 \
+    mvn dependency:go-offline -B
+
+COPY src ./src
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN --mount=type=cache,target=/root/.m2 \
+    mvn package -B -DskipTests{{end}}
+# Le jar exécutable de spring-boot-maven-plugin, sans le jar .original
+RUN cp $(ls target/*.jar | grep -v '\.original$' | head -n 1) /app/app.jar
+
+# --- Final Stage ---
+FROM eclipse-temurin:{{.LanguageVersion}}-jre AS final
+
+WORKDIR /app
+
+RUN groupadd -r appgroup && useradd --no-log-init -r -g appgroup appuser
+USER appuser
+
+COPY --from=builder /app/app.jar ./app.jar
+
+# Port exposé (port), transmis à Spring Boot par SERVER_PORT
+EXPOSE {{.Port}}
+ENV SERVER_PORT={{.Port}}
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["java", "-jar", "app.jar"]{{end}}
+
+# Note: .dockerignore (target/, .git, .mvn/, *.log, etc.)
+`,
+
+	// --- Spring Boot (Gradle) ---
+	"Spring Boot-gradle": `
+# --- Build Stage ---
+FROM eclipse-temurin:{{.LanguageVersion}}-jdk AS builder
+
+WORKDIR /app
+
+# Le wrapper Gradle du projet fixe la version de Gradle
+COPY . .
+RUN chmod +x ./gradlew
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN --mount=type=cache,target=/root/.gradle \
+    ./gradlew bootJar --no-daemon -x test{{end}}
+# Le jar exécutable de bootJar, sans le jar -plain
+RUN cp $(ls build/libs/*.jar | grep -v -- '-plain\.jar$' | head -n 1) /app/app.jar
+
+# --- Final Stage ---
+FROM eclipse-temurin:{{.LanguageVersion}}-jre AS final
+
+WORKDIR /app
+
+RUN groupadd -r appgroup && useradd --no-log-init -r -g appgroup appuser
+USER appuser
+
+COPY --from=builder /app/app.jar ./app.jar
+
+# Port exposé (port), transmis à Spring Boot par SERVER_PORT
+EXPOSE {{.Port}}
+ENV SERVER_PORT={{.Port}}
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["java", "-jar", "app.jar"]{{end}}
+
+# Note: .dockerignore (build/, .gradle/, .git, *.log, etc.)
+`,
+
+	// --- Rails ---
+	"Rails": `
+# --- Build Stage ---
+FROM ruby:{{.LanguageVersion}}-slim AS builder
+
+WORKDIR /app
+
+# Paquets nécessaires à la compilation des gems natives
+RUN apt-get update -qq && \
+    apt-get install --no-install-recommends -y build-essential git libpq-dev libyaml-dev pkg-config && \
+    rm -rf /var/lib/apt/lists/*
+
+ENV RAILS_ENV=production \
+    BUNDLE_DEPLOYMENT=1 \
+    BUNDLE_PATH=/usr/local/bundle \
+    BUNDLE_WITHOUT=development:test
+
+COPY Gemfile Gemfile.lock ./
+RUN bundle install && \
+    rm -rf ~/.bundle/ "${BUNDLE_PATH}"/ruby/*/cache
+
+COPY . .
+
+# Précompiler les assets sans la vraie clé secrète
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN SECRET_KEY_BASE_DUMMY=1 ./bin/rails assets:precompile{{end}}
+
+# --- Final Stage ---
+FROM ruby:{{.LanguageVersion}}-slim AS final
+
+WORKDIR /app
+
+RUN apt-get update -qq && \
+    apt-get install --no-install-recommends -y curl libpq5 libyaml-0-2 && \
+    rm -rf /var/lib/apt/lists/*
+
+ENV RAILS_ENV=production \
+    BUNDLE_DEPLOYMENT=1 \
+    BUNDLE_PATH=/usr/local/bundle \
+    BUNDLE_WITHOUT=development:test \
+    RAILS_LOG_TO_STDOUT=1 \
+    RAILS_SERVE_STATIC_FILES=1
+
+RUN groupadd -r appgroup && useradd --no-log-init -r -m -g appgroup appuser
+
+COPY --from=builder /usr/local/bundle /usr/local/bundle
+COPY --from=builder --chown=appuser:appgroup /app /app
+
+USER appuser
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["./bin/rails", "server", "-b", "0.0.0.0", "-p", "{{.Port}}"]{{end}}
+
+# Note: .dockerignore (log/, tmp/, storage/, node_modules, .git, etc.)
+`,
+
+	// --- Java (Gradle) ---
+	"Java-gradle": `
+# --- Build Stage ---
+# Utiliser un JDK spécifique (language_version), le wrapper Gradle du projet fixe la version de Gradle
+FROM eclipse-temurin:{{.LanguageVersion}}-jdk AS builder
+
+WORKDIR /app
+
+# Copier le wrapper et les scripts de build pour mettre en cache le téléchargement des dépendances
+COPY gradlew settings.gradle* build.gradle* gradle.properties* ./
+COPY gradle ./gradle
+RUN chmod +x ./gradlew && \
+    ./gradlew dependencies --no-daemon > /dev/null 2>&1 || true
+
+COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN --mount=type=cache,target=/root/.gradle \
+    ./gradlew build --no-daemon -x test{{end}}
+# Le jar de l'application, sans le jar -plain
+RUN cp $(ls build/libs/*.jar | grep -v -- '-plain\.jar$' | head -n 1) /app/app.jar
+
+# --- Final Stage ---
+FROM eclipse-temurin:{{.LanguageVersion}}-jre AS final
+
+WORKDIR /app
+
+RUN groupadd -r appgroup && useradd --no-log-init -r -g appgroup appuser
+USER appuser
+
+COPY --from=builder /app/app.jar ./app.jar
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["java", "-jar", "app.jar"]{{end}}
+
+# Note: .dockerignore (build/, .gradle/, .git, *.log, etc.)
+`,
+
+	// --- .NET ---
+	"C#-dotnet": `
+# --- Build Stage ---
+# SDK .NET (language_version, lu dans le TargetFramework du .csproj)
+FROM mcr.microsoft.com/dotnet/sdk:{{.LanguageVersion}} AS builder
+
+WORKDIR /src
+
+# Restaurer les paquets NuGet séparément pour profiter du cache Docker
+COPY *.csproj ./
+RUN --mount=type=cache,target=/root/.nuget/packages \
+    dotnet restore
+
+COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN --mount=type=cache,target=/root/.nuget/packages \
+    dotnet publish -c Release -o /app/publish --no-restore{{end}}
+
+# --- Final Stage ---
+# Le runtime ASP.NET Core exécute aussi les applications console
+FROM mcr.microsoft.com/dotnet/aspnet:{{.LanguageVersion}} AS final
+
+WORKDIR /app
+
+COPY --from=builder /app/publish .
+
+# Utilisateur non-root fourni par les images .NET 8+
+USER $APP_UID
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+ENV ASPNETCORE_HTTP_PORTS={{.Port}}
+
+# Assembly de l'application (binary_name, le nom du .csproj ou son AssemblyName)
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["dotnet", "{{.BinaryName}}.dll"]{{end}}
+
+# Note: .dockerignore (bin/, obj/, .git, *.log, etc.)
+`,
+
+	// --- PHP (Composer) ---
+	"PHP-composer": `
+# --- Dependencies Stage ---
+FROM composer:2 AS vendor
+
+WORKDIR /app
+
+COPY composer.json composer.lock* ./
+RUN --mount=type=cache,target=/tmp/cache \
+    composer install --no-dev --no-interaction --no-scripts --no-autoloader --prefer-dist
+
+COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN composer dump-autoload --no-dev --optimize{{end}}
+
+# --- Final Stage ---
+# PHP avec Apache (language_version)
+FROM php:{{.LanguageVersion}}-apache AS final
+
+# Extensions courantes (ajuster selon composer.json)
+RUN docker-php-ext-install opcache pdo_mysql && a2enmod rewrite
+
+# Racine des documents configurable, le répertoire public/ s'il existe (Laravel, Symfony, Slim...)
+ENV APACHE_DOCUMENT_ROOT=/var/www/html
+RUN sed -ri -e 's!/var/www/html!${APACHE_DOCUMENT_ROOT}!g' /etc/apache2/sites-available/*.conf /etc/apache2/apache2.conf /etc/apache2/conf-available/*.conf
+
+# Port d'écoute d'Apache (port)
+RUN sed -i 's/^Listen 80$/Listen {{.Port}}/' /etc/apache2/ports.conf && \
+    sed -i 's/<VirtualHost \*:80>/<VirtualHost *:{{.Port}}>/' /etc/apache2/sites-available/000-default.conf
+
+COPY --from=vendor --chown=www-data:www-data /app /var/www/html
+RUN if [ -d /var/www/html/public ]; then echo "export APACHE_DOCUMENT_ROOT=/var/www/html/public" >> /etc/apache2/envvars; fi
+
+EXPOSE {{.Port}}
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["apache2-foreground"]{{end}}
+
+# Note: .dockerignore (vendor/, node_modules, .git, *.log, etc.)
+`,
+
+	// --- Ruby (Bundler) ---
+	"Ruby-bundle": `
+# --- Build Stage ---
+FROM ruby:{{.LanguageVersion}}-slim AS builder
+
+WORKDIR /app
+
+# Paquets nécessaires à la compilation des gems natives
+RUN apt-get update -qq && \
+    apt-get install --no-install-recommends -y build-essential git && \
+    rm -rf /var/lib/apt/lists/*
+
+ENV BUNDLE_DEPLOYMENT=1 \
+    BUNDLE_PATH=/usr/local/bundle \
+    BUNDLE_WITHOUT=development:test
+
+COPY Gemfile Gemfile.lock ./
+RUN bundle install && \
+    rm -rf ~/.bundle/ "${BUNDLE_PATH}"/ruby/*/cache
+
+COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{end}}
+
+# --- Final Stage ---
+FROM ruby:{{.LanguageVersion}}-slim AS final
+
+WORKDIR /app
+
+ENV BUNDLE_DEPLOYMENT=1 \
+    BUNDLE_PATH=/usr/local/bundle \
+    BUNDLE_WITHOUT=development:test
+
+RUN groupadd -r appgroup && useradd --no-log-init -r -m -g appgroup appuser
+
+COPY --from=builder /usr/local/bundle /usr/local/bundle
+COPY --from=builder --chown=appuser:appgroup /app /app
+
+USER appuser
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+
+# Application Rack (config.ru: Sinatra, Hanami, Roda...)
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["bundle", "exec", "rackup", "--host", "0.0.0.0", "--port", "{{.Port}}"]{{end}}
+
+# Note: .dockerignore (log/, tmp/, .bundle/, .git, etc.)
+`,
+
+	// --- Elixir (Mix release) ---
+	"Elixir-mix": `
+# --- Build Stage ---
+# Image Elixir officielle (language_version), basée sur Debian comme l'image finale
+FROM elixir:{{.LanguageVersion}} AS builder
+
+WORKDIR /app
+
+RUN mix local.hex --force && mix local.rebar --force
+ENV MIX_ENV=prod
+
+# Récupérer les dépendances séparément pour profiter du cache Docker
+COPY mix.exs mix.lock* ./
+RUN mix deps.get --only prod
+
+# La compilation des dépendances peut lire config/
+COPY . .
+RUN mix deps.compile
+# Les assets Phoenix sont construits s'il y en a
+RUN if [ -d assets ] && mix help assets.deploy > /dev/null 2>&1; then mix assets.deploy; fi
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN mix compile && mix release{{end}}
+
+# --- Final Stage ---
+FROM debian:bookworm-slim AS final
+
+RUN apt-get update -qq && \
+    apt-get install --no-install-recommends -y libstdc++6 openssl libncurses5 locales ca-certificates && \
+    rm -rf /var/lib/apt/lists/* && \
+    sed -i '/en_US.UTF-8/s/^# //g' /etc/locale.gen && locale-gen
+
+ENV LANG=en_US.UTF-8 \
+    LANGUAGE=en_US:en \
+    LC_ALL=en_US.UTF-8 \
+    MIX_ENV=prod \
+    PHX_SERVER=true
+
+WORKDIR /app
+RUN groupadd -r appgroup && useradd --no-log-init -r -g appgroup appuser
+
+# Release de l'application (binary_name, l'app du mix.exs)
+COPY --from=builder --chown=appuser:appgroup /app/_build/prod/rel/{{.BinaryName}} ./
+
+USER appuser
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+ENV PORT={{.Port}}
+
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["/app/bin/{{.BinaryName}}", "start"]{{end}}
+
+# Note: .dockerignore (_build/, deps/, .elixir_ls/, .git, etc.)
+`,
+
+	// --- Deno ---
+	"JavaScript-deno": `
+# Image Deno officielle (language_version)
+FROM denoland/deno:{{.LanguageVersion}}
+
+WORKDIR /app
+
+# Utilisateur non-root fourni par l'image, propriétaire du cache de Deno
+USER deno
+
+# Mettre en cache les dépendances du point d'entrée
+COPY --chown=deno:deno . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{else}}RUN deno cache {{.Entrypoint}}{{end}}
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+ENV PORT={{.Port}}
+
+# Point d'entrée (entrypoint), avec les permissions réseau, environnement et lecture
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["deno", "run", "--allow-net", "--allow-env", "--allow-read", "{{.Entrypoint}}"]{{end}}
+
+# Note: .dockerignore (node_modules, .git, *.log, etc.)
+`,
+
+	// --- Bun ---
+	"JavaScript-bun": `
+# --- Build Stage ---
+# Image Bun officielle (language_version)
+FROM oven/bun:{{.LanguageVersion}} AS builder
+
+WORKDIR /app
+
+COPY package.json bun.lock* bun.lockb* ./
+RUN --mount=type=cache,target=/root/.bun/install/cache \
+    bun install --frozen-lockfile --production
+
+COPY . .
+{{if .BuildCommand}}RUN {{.BuildCommand}}{{end}}
+
+# --- Final Stage ---
+FROM oven/bun:{{.LanguageVersion}}-slim AS final
+
+WORKDIR /app
+
+COPY --from=builder --chown=bun:bun /app /app
+
+# Utilisateur non-root fourni par l'image
+USER bun
+
+# Port exposé (port)
+EXPOSE {{.Port}}
+ENV PORT={{.Port}}
+
+# Point d'entrée (entrypoint, le "main" du package.json par défaut)
+{{if .StartCommand}}CMD {{json .StartCommand}}{{else}}CMD ["bun", "run", "{{.Entrypoint}}"]{{end}}
+
+# Note: .dockerignore (node_modules, .git, *.log, etc.)
+`,
+
+	// Ajouter d'autres templates ici (Swift, Kotlin, etc.)
+}
+
+// dockerfileTemplateDefaults donne les valeurs des templates quand ni la détection ni la spec ne les fixent
+var dockerfileTemplateDefaults = map[string]DockerfileTemplateData{
+	"Go-go":           {LanguageVersion: "1.21", BinaryName: "main", Port: 8080},
+	"JavaScript-npm":  {LanguageVersion: "18", Entrypoint: "index.js", Port: 3000},
+	"JavaScript-yarn": {LanguageVersion: "18", Entrypoint: "index.js", Port: 3000},
+	"JavaScript-pnpm": {LanguageVersion: "18", Entrypoint: "index.js", Port: 3000},
+	"Rust-cargo":      {LanguageVersion: "1.70", BinaryName: "app", Port: 8000},
+	"Python-Pip":      {LanguageVersion: "3.11", Entrypoint: "main.py", Port: 8000},
+	"Java-Maven":      {LanguageVersion: "17", Port: 8080},
+
+	"Next.js":            {LanguageVersion: "20", Port: 3000},
+	"Django":             {LanguageVersion: "3.12", Entrypoint: "app.wsgi:application", Port: 8000},
+	"Spring Boot-mvn":    {LanguageVersion: "21", Port: 8080},
+	"Spring Boot-gradle": {LanguageVersion: "21", Port: 8080},
+	"Rails":              {LanguageVersion: "3.3", Port: 3000},
+
+	"Java-gradle":     {LanguageVersion: "17", Port: 8080},
+	"C#-dotnet":       {LanguageVersion: "8.0", BinaryName: "app", Port: 8080},
+	"PHP-composer":    {LanguageVersion: "8.3", Port: 80},
+	"Ruby-bundle":     {LanguageVersion: "3.3", Port: 9292},
+	"Elixir-mix":      {LanguageVersion: "1.16", BinaryName: "app", Port: 4000},
+	"JavaScript-deno": {LanguageVersion: "2.1.4", Entrypoint: "main.ts", Port: 8000},
+	"JavaScript-bun":  {LanguageVersion: "1.1", Entrypoint: "index.ts", Port: 3000},
+}
@@ -0,0 +1,49 @@
+package build
+
+import "strings"
+
+// NetworkName returns the name on the engine of a network of the project: its own name when it
+// has one, <project>_<network> otherwise
+func (r *RunYAML) NetworkName(project, network string) string {
+	if r != nil {
+		if config, ok := r.Networks[network]; ok {
+			if config.Name != "" {
+				return config.Name
+			}
+			if config.External {
+				return network
+			}
+		}
+	}
+	return project + "_" + network
+}
+
+// VolumeName returns the name on the engine of a named volume of the project: its own name when
+// it has one, <project>_<volume> otherwise
+func (r *RunYAML) VolumeName(project, volume string) string {
+	if r != nil {
+		if config, ok := r.Volumes[volume]; ok {
+			if config.Name != "" {
+				return config.Name
+			}
+			if config.External {
+				return volume
+			}
+		}
+	}
+	return project + "_" + volume
+}
+
+// VolumeSpec returns a volume mapping of a service with the named volumes of the project replaced
+// by their name on the engine ("data:/var/lib/db" -> "shop_data:/var/lib/db"). The sources which
+// are not declared in the volumes section are kept, like in the run.yml written without it.
+func (r *RunYAML) VolumeSpec(project, mapping string) string {
+	source, rest, ok := strings.Cut(mapping, ":")
+	if !ok || r == nil {
+		return mapping
+	}
+	if _, declared := r.Volumes[source]; !declared {
+		return mapping
+	}
+	return r.VolumeName(project, source) + ":" + rest
+}
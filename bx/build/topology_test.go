@@ -0,0 +1,62 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeNetworksAndVolumes(t *testing.T) {
+	project, err := LoadComposeFile([]byte(`
+services:
+  db:
+    image: postgres:16
+    networks: [backend, shared]
+    volumes:
+      - data:/var/lib/postgresql/data
+networks:
+  backend:
+    driver: bridge
+    internal: true
+    labels:
+      tier: data
+  shared:
+    external: true
+    name: infra_shared
+volumes:
+  data:
+    driver_opts:
+      type: tmpfs
+  archive:
+    external: true
+`))
+	require.NoError(t, err)
+
+	networks := runNetworks(project)
+	assert.Equal(t, RunNetwork{Driver: "bridge", Internal: true, Labels: map[string]string{"tier": "data"}}, networks["backend"])
+	assert.Equal(t, RunNetwork{External: true, Name: "infra_shared"}, networks["shared"])
+	assert.NotContains(t, networks, "default")
+
+	volumes := runVolumes(project)
+	assert.Equal(t, RunVolume{DriverOpts: map[string]string{"type": "tmpfs"}}, volumes["data"])
+	assert.True(t, volumes["archive"].External)
+}
+
+func TestRunYAMLEngineNames(t *testing.T) {
+	runConfig := &RunYAML{
+		Networks: map[string]RunNetwork{"shared": {External: true, Name: "infra_shared"}, "edge": {External: true}},
+		Volumes:  map[string]RunVolume{"data": {}, "archive": {External: true}},
+	}
+	assert.Equal(t, "shop_backend", runConfig.NetworkName("shop", "backend"))
+	assert.Equal(t, "infra_shared", runConfig.NetworkName("shop", "shared"))
+	assert.Equal(t, "edge", runConfig.NetworkName("shop", "edge"))
+
+	assert.Equal(t, "shop_data:/var/lib/db:ro", runConfig.VolumeSpec("shop", "data:/var/lib/db:ro"))
+	assert.Equal(t, "archive:/archive", runConfig.VolumeSpec("shop", "archive:/archive"))
+	assert.Equal(t, "cache:/cache", runConfig.VolumeSpec("shop", "cache:/cache"))
+	assert.Equal(t, "/tmp", runConfig.VolumeSpec("shop", "/tmp"))
+
+	var empty *RunYAML
+	assert.Equal(t, "shop_data", empty.VolumeName("shop", "data"))
+}
@@ -0,0 +1,562 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationIssue is a problem of a specification found by ValidateBuildSpec
+type ValidationIssue struct {
+	Path    string `json:"path,omitempty"` // Field of the problem ("build_steps[1].codebase_name"), empty for the whole document
+	Line    int    `json:"line,omitempty"` // Line of the field in the file, 0 if unknown
+	Message string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	var b strings.Builder
+	if i.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", i.Line)
+	}
+	if i.Path != "" {
+		fmt.Fprintf(&b, "%s: ", i.Path)
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// ValidateOptions controls the checks of ValidateBuildSpec
+type ValidateOptions struct {
+	Online  bool          // Also check that the sources are reachable: git remotes, resource URLs, local paths
+	BaseDir string        // Directory of the relative local sources, the current directory if empty (like the builds)
+	Timeout time.Duration // Timeout of each online check, 10s if 0
+}
+
+// ValidateBuildSpecFile validates a specification file, see ValidateBuildSpec
+func ValidateBuildSpecFile(ctx context.Context, filename string, options ValidateOptions) ([]ValidationIssue, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the build file specification '%s': %w", filename, err)
+	}
+	return ValidateBuildSpec(ctx, data, filepath.Ext(filename), options), nil
+}
+
+// ValidateBuildSpec runs every check of a specification and returns all its problems with their lines,
+// sorted by line, rather than the first one like LoadBuildSpecFromBytes: the unknown fields and the
+// wrong types, the required fields, the references between the build steps and the codebases and the
+// enumerated values. The sources are only checked with options.Online. No issue means the spec is valid.
+func ValidateBuildSpec(ctx context.Context, data []byte, format string, options ValidateOptions) []ValidationIssue {
+	v := &specValidator{options: options}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err == nil && len(root.Content) > 0 {
+		v.root = root.Content[0]
+	}
+
+	var spec BuildSpec
+	if format == ".json" {
+		v.decodeJSON(data, &spec)
+	} else {
+		v.decodeYAML(data, &spec)
+	}
+	if v.fatal {
+		return v.sorted()
+	}
+
+	v.checkSpec(&spec)
+	if options.Online {
+		v.checkSources(ctx, &spec)
+	}
+	return v.sorted()
+}
+
+// specValidator collects the issues of a spec, the lines are looked up in its YAML tree
+type specValidator struct {
+	options ValidateOptions
+	root    *yaml.Node
+	issues  []ValidationIssue
+	fatal   bool // The document cannot be decoded, the other checks are skipped
+}
+
+// addf adds an issue on the field at path, made of map keys and sequence indexes
+func (v *specValidator) addf(path []any, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{
+		Path:    fieldPath(path),
+		Line:    v.line(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line returns the line of the field at path, or of its closest parent present in the document
+func (v *specValidator) line(path []any) int {
+	node := v.root
+	if node == nil || len(path) == 0 {
+		return 0
+	}
+	line := node.Line
+	for _, elem := range path {
+		var next *yaml.Node
+		switch key := elem.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == key {
+						next = node.Content[i+1]
+						line = node.Content[i].Line
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
+
+// sorted returns the issues by line, those of the whole document last
+func (v *specValidator) sorted() []ValidationIssue {
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		a, b := v.issues[i].Line, v.issues[j].Line
+		return a != 0 && (b == 0 || a < b)
+	})
+	return v.issues
+}
+
+func fieldPath(path []any) string {
+	var b strings.Builder
+	for _, elem := range path {
+		switch key := elem.(type) {
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(key)
+		case int:
+			fmt.Fprintf(&b, "[%d]", key)
+		}
+	}
+	return b.String()
+}
+
+// Errors of the yaml.v3 decoder, "line 12: field foo not found in type build.BuildStep"
+var yamlErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// decodeYAML decodes the spec rejecting the unknown fields, each decoding error is an issue
+func (v *specValidator) decodeYAML(data []byte, spec *BuildSpec) {
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	err := decoder.Decode(spec)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &typeErr):
+		// The fields without error are decoded, the other checks still run
+		for _, msg := range typeErr.Errors {
+			issue := ValidationIssue{Message: msg}
+			if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+				issue.Line, _ = strconv.Atoi(m[1])
+				issue.Message = m[2]
+			}
+			v.issues = append(v.issues, issue)
+		}
+	default:
+		v.fatal = true
+		issue := ValidationIssue{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlErrorLine.FindStringSubmatch(issue.Message); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			issue.Message = m[2]
+		}
+		if errors.Is(err, io.EOF) {
+			issue.Message = "the specification is empty"
+		}
+		v.issues = append(v.issues, issue)
+	}
+}
+
+// decodeJSON decodes the spec rejecting the unknown fields, the JSON decoder stops at the first error
+func (v *specValidator) decodeJSON(data []byte, spec *BuildSpec) {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(spec)
+	if err == nil {
+		return
+	}
+	issue := ValidationIssue{Message: err.Error()}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		v.fatal = true
+		issue.Line = lineAtOffset(data, syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		// The other fields are decoded, the other checks still run
+		issue.Path = typeErr.Field
+		issue.Line = lineAtOffset(data, typeErr.Offset)
+		issue.Message = fmt.Sprintf("cannot use a %s as %s", typeErr.Value, typeErr.Type)
+	default:
+		// Unknown field: the decoder reports it without position
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field, _ = strconv.Unquote(field)
+			issue.Message = fmt.Sprintf("unknown field '%s'", field)
+			issue.Line = v.fieldLine(field)
+		}
+		v.fatal = true
+	}
+	v.issues = append(v.issues, issue)
+}
+
+// fieldLine returns the line of the first key named field in the document
+func (v *specValidator) fieldLine(field string) int {
+	var find func(node *yaml.Node) int
+	find = func(node *yaml.Node) int {
+		for i, child := range node.Content {
+			if node.Kind == yaml.MappingNode && i%2 == 0 && child.Value == field {
+				return child.Line
+			}
+			if line := find(child); line > 0 {
+				return line
+			}
+		}
+		return 0
+	}
+	if v.root == nil {
+		return 0
+	}
+	return find(v.root)
+}
+
+func lineAtOffset(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return strings.Count(string(data[:offset]), "\n") + 1
+}
+
+// checkSpec runs the checks which don't need the network
+func (v *specValidator) checkSpec(spec *BuildSpec) {
+	if spec.Name == "" {
+		v.addf([]any{"name"}, "the field 'name' is required")
+	}
+	if spec.Version == "" {
+		v.addf([]any{"version"}, "the field 'version' is required")
+	}
+	if len(spec.Codebases) == 0 && len(spec.BuildSteps) == 0 && spec.BuildConfig.Dockerfile == "" && spec.BuildConfig.ComposeFile == "" {
+		v.addf(nil, "nothing to build: add a codebase, a build_step, a dockerfile or a compose_file")
+	}
+
+	codebases := make(map[string]bool)
+	var codebaseNames []string
+	for i, codebase := range spec.Codebases {
+		path := []any{"codebases", i}
+		if codebase.Name == "" {
+			v.addf(append(path, "name"), "the field 'name' is required")
+		} else if codebases[codebase.Name] {
+			v.addf(append(path, "name"), "the codebase '%s' is already defined", codebase.Name)
+		}
+		if codebase.Name != "" && !codebases[codebase.Name] {
+			codebaseNames = append(codebaseNames, codebase.Name)
+		}
+		codebases[codebase.Name] = true
+		switch codebase.SourceType {
+		case "git", "local", "archive":
+			if codebase.Source == "" {
+				v.addf(append(path, "source"), "the field 'source' is required for a '%s' codebase", codebase.SourceType)
+			}
+		case "buffer":
+		case "":
+			v.addf(append(path, "source_type"), "the field 'source_type' is required: 'git', 'local', 'archive' or 'buffer'")
+		default:
+			v.addf(append(path, "source_type"), "unknown source type '%s', expected 'git', 'local', 'archive' or 'buffer'", codebase.SourceType)
+		}
+		if codebase.SourceType != "git" && (codebase.Branch != "" || codebase.Commit != "") {
+			v.addf(append(path, "branch"), "'branch' and 'commit' only apply to a 'git' codebase")
+		}
+	}
+
+	steps := make(map[string]BuildStep)
+	var stepNames []string
+	for i, step := range spec.BuildSteps {
+		path := []any{"build_steps", i}
+		if step.Name == "" {
+			v.addf(append(path, "name"), "the field 'name' is required")
+		} else if _, exists := steps[step.Name]; exists {
+			v.addf(append(path, "name"), "the build step '%s' is already defined", step.Name)
+		}
+		switch {
+		case step.CodebaseName == "":
+			v.addf(append(path, "codebase_name"), "the field 'codebase_name' is required")
+		case !codebases[step.CodebaseName]:
+			v.addf(append(path, "codebase_name"), "unknown codebase '%s'%s", step.CodebaseName, suggestion(step.CodebaseName, codebaseNames))
+		}
+		if step.UseBinaryFromStep != "" {
+			from, ok := steps[step.UseBinaryFromStep]
+			switch {
+			case step.UseBinaryFromStep == step.Name:
+				v.addf(append(path, "use_binary_from_step"), "the step cannot use its own binary")
+			case !ok:
+				v.addf(append(path, "use_binary_from_step"), "no previous build step '%s', the steps run in their order%s", step.UseBinaryFromStep, suggestion(step.UseBinaryFromStep, stepNames))
+			case from.OutputsBinaryPath == "":
+				v.addf(append(path, "use_binary_from_step"), "the build step '%s' has no 'outputs_binary_path'", step.UseBinaryFromStep)
+			}
+			if step.BinaryTargetPath == "" {
+				v.addf(append(path, "binary_target_path"), "the field 'binary_target_path' is required with 'use_binary_from_step'")
+			}
+		} else if step.BinaryTargetPath != "" {
+			v.addf(append(path, "binary_target_path"), "'binary_target_path' requires a 'use_binary_from_step'")
+		}
+		if step.Name != "" {
+			if _, exists := steps[step.Name]; !exists {
+				steps[step.Name] = step
+				stepNames = append(stepNames, step.Name)
+			}
+		}
+	}
+
+	for i, resource := range spec.Resources {
+		path := []any{"resources", i}
+		if resource.URL == "" {
+			v.addf(append(path, "url"), "the field 'url' is required")
+		} else if !strings.HasPrefix(resource.URL, "http://") && !strings.HasPrefix(resource.URL, "https://") {
+			v.addf(append(path, "url"), "unsupported resource URL '%s', expected http:// or https://", resource.URL)
+		}
+		if resource.TargetPath == "" {
+			v.addf(append(path, "target_path"), "the field 'target_path' is required")
+		} else if filepath.IsAbs(resource.TargetPath) || strings.HasPrefix(filepath.Clean(resource.TargetPath), "..") {
+			v.addf(append(path, "target_path"), "'%s' must be a relative path in the build directory", resource.TargetPath)
+		}
+	}
+
+	v.checkBuildConfig(spec.BuildConfig)
+
+	secrets := make(map[string]bool)
+	for i, secret := range spec.Secrets {
+		path := []any{"secrets", i}
+		if secret.Name == "" {
+			v.addf(append(path, "name"), "the field 'name' is required")
+		} else if secrets[secret.Name] {
+			v.addf(append(path, "name"), "the secret '%s' is already defined", secret.Name)
+		}
+		secrets[secret.Name] = true
+		if secret.Source == "" {
+			v.addf(append(path, "source"), "the field 'source' is required")
+		}
+		switch secret.InjectMethod {
+		case "", InjectEnv, InjectBuild:
+			if secret.Target != "" {
+				v.addf(append(path, "target"), "'target' only applies to the '%s' inject_method", InjectFile)
+			}
+		case InjectFile:
+		default:
+			v.addf(append(path, "inject_method"), "unknown inject_method '%s', expected '%s', '%s' or '%s'", secret.InjectMethod, InjectEnv, InjectBuild, InjectFile)
+		}
+	}
+
+	switch spec.RunConfigDef.ArtifactStorage {
+	case "", "docker", "local", "b2":
+	default:
+		v.addf([]any{"run_config_def", "artifact_storage"}, "unknown artifact_storage '%s', expected 'docker', 'local' or 'b2'", spec.RunConfigDef.ArtifactStorage)
+	}
+
+	for i, registry := range spec.Registries {
+		path := []any{"registries", i}
+		if registry.Host == "" {
+			v.addf(append(path, "host"), "the field 'host' is required")
+		}
+		if registry.Password != "" && registry.Username == "" {
+			v.addf(append(path, "username"), "the field 'username' is required with a 'password'")
+		}
+		if registry.Password != "" && registry.Token != "" {
+			v.addf(append(path, "token"), "don't specify 'password' and 'token' for the same registry")
+		}
+	}
+
+	if err := validateDockerHost(spec.DockerHost); err != nil {
+		v.addf([]any{"docker_host"}, "%v", err)
+	}
+}
+
+func (v *specValidator) checkBuildConfig(config BuildConfig) {
+	path := []any{"build_config"}
+	if config.Dockerfile != "" && config.ComposeFile != "" {
+		v.addf(append(path, "compose_file"), "don't specify 'dockerfile' and 'compose_file' in the build_config")
+	}
+	switch config.OutputTarget {
+	case "", "docker", "b2":
+		if config.LocalPath != "" {
+			v.addf(append(path, "local_path"), "'local_path' only applies to the 'local' output_target")
+		}
+	case "local":
+	default:
+		v.addf(append(path, "output_target"), "unknown output_target '%s', expected 'docker', 'local' or 'b2'", config.OutputTarget)
+	}
+	if err := validateCompression(config); err != nil {
+		field := "compression"
+		if config.Compression == "" || config.Compression == CompressionNone || config.Compression == CompressionGzip || config.Compression == CompressionZstd {
+			field = "compression_level"
+		}
+		v.addf(append(path, field), "%v", err)
+	}
+	if err := validateConcurrency(config); err != nil {
+		v.addf(append(path, "concurrency"), "%v", err)
+	}
+	for i, tag := range config.Tags {
+		if _, err := reference.ParseNormalizedNamed(tag); err != nil {
+			v.addf(append(path, "tags", i), "invalid image tag '%s': %v", tag, err)
+		}
+	}
+}
+
+// checkSources checks that the codebases and the resources can be fetched
+func (v *specValidator) checkSources(ctx context.Context, spec *BuildSpec) {
+	timeout := v.options.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	for i, codebase := range spec.Codebases {
+		if codebase.Source == "" {
+			continue
+		}
+		path := []any{"codebases", i, "source"}
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		switch codebase.SourceType {
+		case "git":
+			if err := checkGitSource(checkCtx, codebase); err != nil {
+				v.addf(path, "%v", err)
+			}
+		case "local":
+			if info, err := os.Stat(v.localPath(codebase.Source)); err != nil {
+				v.addf(path, "the local source '%s' doesn't exist", codebase.Source)
+			} else if !info.IsDir() {
+				v.addf(path, "the local source '%s' is not a directory", codebase.Source)
+			}
+		case "archive":
+			if info, err := os.Stat(v.localPath(codebase.Source)); err != nil {
+				v.addf(path, "the archive '%s' doesn't exist", codebase.Source)
+			} else if info.IsDir() {
+				v.addf(path, "the archive '%s' is a directory", codebase.Source)
+			}
+		}
+		cancel()
+	}
+	for i, resource := range spec.Resources {
+		if !strings.HasPrefix(resource.URL, "http://") && !strings.HasPrefix(resource.URL, "https://") {
+			continue
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		if err := checkURL(checkCtx, resource.URL); err != nil {
+			v.addf([]any{"resources", i, "url"}, "%v", err)
+		}
+		cancel()
+	}
+}
+
+func (v *specValidator) localPath(source string) string {
+	if filepath.IsAbs(source) || v.options.BaseDir == "" {
+		return source
+	}
+	return filepath.Join(v.options.BaseDir, source)
+}
+
+// checkGitSource lists the references of the remote and looks for the branch of the codebase
+func checkGitSource(ctx context.Context, codebase CodebaseConfig) error {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{codebase.Source}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot reach the repository '%s': %w", codebase.Source, err)
+	}
+	if codebase.Branch == "" {
+		return nil
+	}
+	branch := plumbing.NewBranchReferenceName(codebase.Branch)
+	for _, ref := range refs {
+		if ref.Name() == branch {
+			return nil
+		}
+	}
+	return fmt.Errorf("no branch '%s' in the repository '%s'", codebase.Branch, codebase.Source)
+}
+
+// checkURL sends a HEAD request, the servers which don't support it are asked with a GET
+func checkURL(ctx context.Context, url string) error {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return fmt.Errorf("invalid URL '%s': %w", url, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("cannot reach '%s': %w", url, err)
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	if status >= 400 {
+		return fmt.Errorf("'%s' returned the status %d", url, status)
+	}
+	return nil
+}
+
+// suggestion proposes the closest name to a misspelled reference
+func suggestion(name string, names []string) string {
+	best, bestDistance := "", len(name)/2+1
+	for _, candidate := range names {
+		if d := levenshtein(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		if len(names) > 0 {
+			return fmt.Sprintf(" (defined: %s)", strings.Join(names, ", "))
+		}
+		return ""
+	}
+	return fmt.Sprintf(", did you mean '%s'?", best)
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
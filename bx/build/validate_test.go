@@ -0,0 +1,115 @@
+package build
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateBuildSpec(t *testing.T) {
+	spec := `name: app
+version: 1.0.0
+codebases:
+  - name: api
+    source_type: git
+    source: https://example.com/api.git
+  - name: api
+    source_type: svn
+    source: x
+build_steps:
+  - name: compile
+    codebase_name: api
+  - name: image
+    codebase_name: apy
+    use_binary_from_step: compile
+build_config:
+  output_target: disk
+  tags: ["App:latest"]
+secrets:
+  - name: TOKEN
+    source: vault://token
+    inject_method: volume
+`
+	issues := ValidateBuildSpec(context.Background(), []byte(spec), ".yml", ValidateOptions{})
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	assert.Equal(t, []string{
+		"line 7: codebases[1].name: the codebase 'api' is already defined",
+		"line 8: codebases[1].source_type: unknown source type 'svn', expected 'git', 'local', 'archive' or 'buffer'",
+		// The line of a missing field is the one of its parent
+		"line 13: build_steps[1].binary_target_path: the field 'binary_target_path' is required with 'use_binary_from_step'",
+		"line 14: build_steps[1].codebase_name: unknown codebase 'apy', did you mean 'api'?",
+		"line 15: build_steps[1].use_binary_from_step: the build step 'compile' has no 'outputs_binary_path'",
+		"line 17: build_config.output_target: unknown output_target 'disk', expected 'docker', 'local' or 'b2'",
+		"line 18: build_config.tags[0]: invalid image tag 'App:latest': invalid reference format: repository name (library/App) must be lowercase",
+		"line 22: secrets[0].inject_method: unknown inject_method 'volume', expected 'env', 'build' or 'file'",
+	}, got)
+}
+
+func TestValidateBuildSpec_Decoding(t *testing.T) {
+	ctx := context.Background()
+
+	// Every unknown field and wrong type is reported, then the other checks run
+	issues := ValidateBuildSpec(ctx, []byte("name: app\nversion: 1\ncodebase: []\nbuild_config:\n  no_cache: maybe\n"), ".yml", ValidateOptions{})
+	require.Len(t, issues, 3)
+	assert.Equal(t, 3, issues[0].Line)
+	assert.Contains(t, issues[0].Message, "field codebase not found")
+	assert.Equal(t, 5, issues[1].Line)
+	assert.Contains(t, issues[1].Message, "cannot unmarshal !!str `maybe` into bool")
+	assert.Equal(t, ValidationIssue{Message: "nothing to build: add a codebase, a build_step, a dockerfile or a compose_file"}, issues[2])
+
+	issues = ValidateBuildSpec(ctx, []byte("name: app\n  version: [\n"), ".yml", ValidateOptions{})
+	require.Len(t, issues, 1)
+	assert.Equal(t, 2, issues[0].Line)
+
+	issues = ValidateBuildSpec(ctx, []byte("{\n  \"name\": \"app\",\n  \"version\": \"1\",\n  \"dockerfil\": \"x\"\n}"), ".json", ValidateOptions{})
+	require.Len(t, issues, 1)
+	assert.Equal(t, ValidationIssue{Line: 4, Message: "unknown field 'dockerfil'"}, issues[0])
+
+	issues = ValidateBuildSpec(ctx, []byte("name: app\nversion: 1\nbuild_config:\n  dockerfile: Dockerfile\n"), ".yml", ValidateOptions{})
+	assert.Empty(t, issues)
+}
+
+func TestValidateBuildSpec_Online(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tool.tgz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "web"), 0755))
+
+	spec := `name: app
+version: 1.0.0
+codebases:
+  - name: web
+    source_type: local
+    source: web
+  - name: api
+    source_type: local
+    source: api
+resources:
+  - url: ` + server.URL + `/tool.tgz
+    target_path: tools/tool.tgz
+  - url: ` + server.URL + `/missing.tgz
+    target_path: tools/missing.tgz
+`
+	issues := ValidateBuildSpec(context.Background(), []byte(spec), ".yml", ValidateOptions{Online: true, BaseDir: dir})
+	require.Len(t, issues, 2)
+	assert.Equal(t, ValidationIssue{Path: "codebases[1].source", Line: 9, Message: "the local source 'api' doesn't exist"}, issues[0])
+	assert.Equal(t, "resources[1].url", issues[1].Path)
+	assert.Equal(t, 13, issues[1].Line)
+	assert.Contains(t, issues[1].Message, "returned the status 404")
+
+	// Offline, the sources are not checked
+	assert.Empty(t, ValidateBuildSpec(context.Background(), []byte(spec), ".yml", ValidateOptions{BaseDir: dir}))
+}
@@ -0,0 +1,233 @@
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Quiet period after the last change before a batch of changes is reported
+const DefaultWatchDebounce = 300 * time.Millisecond
+
+// CodebaseWatcher reports the changes in the files of the local codebases of a spec. The changes of
+// an editor saving several files are gathered in one batch, and the changes made while the previous
+// batch is not received yet are added to the next one.
+type CodebaseWatcher struct {
+	watcher  *fsnotify.Watcher
+	roots    map[string]string // Absolute directory of each watched codebase, by codebase name
+	debounce time.Duration
+	changes  chan []string
+	errors   chan error
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCodebaseWatcher watches the directories of the "local" codebases of the spec, with their
+// subdirectories except the VCS metadata and the dependencies (.git, node_modules...).
+// The relative sources are resolved against baseDir, the working directory if empty.
+func NewCodebaseWatcher(spec *BuildSpec, baseDir string, debounce time.Duration) (*CodebaseWatcher, error) {
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+	roots := make(map[string]string)
+	for _, codebase := range spec.Codebases {
+		if codebase.SourceType != "local" {
+			continue
+		}
+		source := codebase.Source
+		if !filepath.IsAbs(source) && baseDir != "" {
+			source = filepath.Join(baseDir, source)
+		}
+		root, err := filepath.Abs(source)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve the source of the codebase '%s': %w", codebase.Name, err)
+		}
+		roots[codebase.Name] = root
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no local codebase to watch in the spec '%s'", spec.Name)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the file watcher: %w", err)
+	}
+	w := &CodebaseWatcher{
+		watcher:  watcher,
+		roots:    roots,
+		debounce: debounce,
+		changes:  make(chan []string),
+		errors:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	for name, root := range roots {
+		if err := w.addTree(root); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("cannot watch the codebase '%s': %w", name, err)
+		}
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Roots returns the watched directory of each local codebase, by codebase name
+func (w *CodebaseWatcher) Roots() map[string]string {
+	return w.roots
+}
+
+// Changes returns the channel of the batches of changes, the sorted names of the changed codebases
+func (w *CodebaseWatcher) Changes() <-chan []string {
+	return w.changes
+}
+
+// Errors returns the channel of the errors of the file watcher, the watch goes on after them
+func (w *CodebaseWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Close stops the watch
+func (w *CodebaseWatcher) Close() error {
+	close(w.done)
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
+}
+
+func (w *CodebaseWatcher) run() {
+	defer w.wg.Done()
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	var ready bool // Quiet period over, the batch waits for its receiver
+	for {
+		var out chan []string
+		if ready && len(pending) > 0 {
+			out = w.changes
+		}
+		select {
+		case <-w.done:
+			timer.Stop()
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			name, changed := w.handle(event)
+			if !changed {
+				continue
+			}
+			pending[name] = true
+			ready = false
+			timer.Reset(w.debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case w.errors <- err:
+			default: // The previous error is not read yet
+			}
+		case <-timer.C:
+			ready = true
+		case out <- sortedKeys(pending):
+			pending = make(map[string]bool)
+			ready = false
+		}
+	}
+}
+
+// handle watches the created directories and returns the codebase of a change
+func (w *CodebaseWatcher) handle(event fsnotify.Event) (string, bool) {
+	if event.Op == fsnotify.Chmod {
+		return "", false // Touched by the indexers and the backup tools, the content is the same
+	}
+	name, ok := w.codebaseOf(event.Name)
+	if !ok || skippedWatchPath(w.roots[name], event.Name) {
+		return "", false
+	}
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			// The files created with the directory may come before the watch, the change is reported anyway
+			w.addTree(event.Name)
+		}
+	}
+	return name, true
+}
+
+// codebaseOf returns the codebase of a path, the deepest one for nested codebases
+func (w *CodebaseWatcher) codebaseOf(path string) (string, bool) {
+	best, bestLen := "", -1
+	for name, root := range w.roots {
+		if (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) && len(root) > bestLen {
+			best, bestLen = name, len(root)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// addTree watches a directory and its subdirectories
+func (w *CodebaseWatcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && os.IsNotExist(err) {
+				return nil // Removed meanwhile
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != root && skippedWatchDir(entry.Name()) {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+// Directories of the VCS metadata and of the installed dependencies, rewritten by the tools rather
+// than edited. The outputs (dist, build, target) are kept, they are sources in some projects.
+var skippedWatchDirs = map[string]bool{
+	".git":         true,
+	".hg":          true,
+	".svn":         true,
+	"node_modules": true,
+	"__pycache__":  true,
+	".venv":        true,
+	"venv":         true,
+}
+
+// skippedWatchDir reports whether the changes in a directory are ignored
+func skippedWatchDir(name string) bool {
+	return skippedWatchDirs[name]
+}
+
+// skippedWatchPath reports whether a path of a codebase is in a skipped directory
+func skippedWatchPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if skippedWatchDir(part) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
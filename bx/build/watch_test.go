@@ -0,0 +1,69 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodebaseWatcher(t *testing.T) {
+	base := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "api", "node_modules", "lib"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "web"), 0755))
+	spec := &BuildSpec{
+		Name: "app",
+		Codebases: []CodebaseConfig{
+			{Name: "api", SourceType: "local", Source: "api"},
+			{Name: "web", SourceType: "local", Source: filepath.Join(base, "web")},
+			{Name: "lib", SourceType: "git", Source: "https://example.com/lib.git"},
+		},
+	}
+	watcher, err := NewCodebaseWatcher(spec, base, 50*time.Millisecond)
+	require.NoError(t, err)
+	defer watcher.Close()
+	assert.Equal(t, map[string]string{"api": filepath.Join(base, "api"), "web": filepath.Join(base, "web")}, watcher.Roots())
+
+	nextBatch := func() []string {
+		select {
+		case batch := <-watcher.Changes():
+			return batch
+		case <-time.After(2 * time.Second):
+			return nil
+		}
+	}
+
+	// Several files saved at once give one batch
+	require.NoError(t, os.WriteFile(filepath.Join(base, "web", "index.js"), []byte("1"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "api", "main.go"), []byte("1"), 0644))
+	assert.Equal(t, []string{"api", "web"}, nextBatch())
+
+	// The dependencies are ignored, the new directories are watched
+	require.NoError(t, os.WriteFile(filepath.Join(base, "api", "node_modules", "lib", "index.js"), []byte("1"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(base, "web", "src"), 0755))
+	assert.Equal(t, []string{"web"}, nextBatch())
+	require.NoError(t, os.WriteFile(filepath.Join(base, "web", "src", "app.js"), []byte("1"), 0644))
+	assert.Equal(t, []string{"web"}, nextBatch())
+
+	// The changes made before the batch is received are kept
+	require.NoError(t, os.WriteFile(filepath.Join(base, "api", "main.go"), []byte("2"), 0644))
+	time.Sleep(200 * time.Millisecond)
+	require.NoError(t, os.Remove(filepath.Join(base, "web", "index.js")))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"api", "web"}, nextBatch())
+
+	select {
+	case batch := <-watcher.Changes():
+		t.Fatalf("unexpected batch %v", batch)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCodebaseWatcher_NoLocalCodebase(t *testing.T) {
+	spec := &BuildSpec{Name: "app", Codebases: []CodebaseConfig{{Name: "lib", SourceType: "git", Source: "https://example.com/lib.git"}}}
+	_, err := NewCodebaseWatcher(spec, "", 0)
+	assert.ErrorContains(t, err, "no local codebase to watch")
+}
@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/bx/secrets"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	buildSpecFile string
+	buildNoCache  bool
+	buildTags     []string
+	buildOutput   string
+	buildWorkDir  string
+	buildQuiet    bool
+	buildJSON     bool
+
+	buildCmd = &cobra.Command{
+		Use:   "build -f <spec.yml>",
+		Short: "Run a build specification with the local docker engine.",
+		Long: `Build loads a build specification (YAML or JSON), runs it with the docker engine of the
+environment and streams its logs to the terminal. A summary of the phases and of the built
+images is printed when the build ends, the command exits with an error if it failed.
+
+The --no-cache, --tag and --output flags override the build_config of the specification.
+The "aws-sm://" and "ssm://" secret sources use the default AWS credential chain, the "b2"
+output reads its configuration from B2_ACCOUNT_ID, B2_APPLICATION_KEY, B2_BUCKET and B2_BASE_PATH.
+
+With --json the result is written to stdout as a JSON report (build ID, images, artifact paths,
+digests and phases) instead of the summary, the logs of the build going to stderr.`,
+		Args: cobra.NoArgs,
+		RunE: runBuildCommand,
+	}
+)
+
+func init() {
+	buildCmd.Flags().StringVarP(&buildSpecFile, "file", "f", "", "Path to the build specification (required)")
+	buildCmd.Flags().BoolVar(&buildNoCache, "no-cache", false, "Do not use the cache of the docker engine")
+	buildCmd.Flags().StringArrayVarP(&buildTags, "tag", "t", nil, "Tag of the built image, replaces the tags of the spec (repeatable)")
+	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", `Output target: "docker", "local" or "b2"`)
+	buildCmd.Flags().StringVar(&buildWorkDir, "work-dir", "", "Working directory of the build (a temporary directory removed at the end by default)")
+	buildCmd.Flags().BoolVarP(&buildQuiet, "quiet", "q", false, "Only print the phases, the warnings and the summary")
+	buildCmd.Flags().BoolVar(&buildJSON, "json", false, "Print the result as JSON on stdout, the logs on stderr")
+	buildCmd.MarkFlagRequired("file")
+}
+
+func runBuildCommand(cmd *cobra.Command, args []string) error {
+	output, err := outputOptions()
+	if err != nil {
+		return err
+	}
+	spec, err := build.LoadBuildSpecFromFile(buildSpecFile)
+	if err != nil {
+		return err
+	}
+	if err := applyBuildOverrides(cmd, spec); err != nil {
+		return err
+	}
+	addConfigRegistries(spec)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fetcher, err := buildSecretFetcher(ctx, spec)
+	if err != nil {
+		return err
+	}
+	service, err := build.NewBuildService(buildWorkDir, buildWorkDir == "", fetcher)
+	if err != nil {
+		return fmt.Errorf("cannot create the build service: %w", err)
+	}
+	if buildWorkDir == "" {
+		defer service.Cleanup()
+	}
+	service.SetOutputOptions(output)
+	if spec.BuildConfig.OutputTarget == "b2" {
+		service.SetB2Config(&build.B2Config{
+			AccountID:      os.Getenv("B2_ACCOUNT_ID"),
+			ApplicationKey: os.Getenv("B2_APPLICATION_KEY"),
+			BucketName:     os.Getenv("B2_BUCKET"),
+			BasePath:       os.Getenv("B2_BASE_PATH"),
+		})
+	}
+
+	events := make(chan build.BuildEvent, 64)
+	phases := make(chan []phaseSummary, 1)
+	out := os.Stdout
+	if buildJSON {
+		out = os.Stderr
+	}
+	go func() { phases <- printBuildEvents(events, out) }()
+	result, buildErr := service.BuildWithEvents(ctx, spec, events)
+
+	if buildJSON {
+		if err := printJSON(newBuildReport(spec, result, <-phases)); err != nil {
+			return err
+		}
+	} else {
+		printBuildSummary(spec, result, <-phases)
+	}
+	if buildErr != nil {
+		return fmt.Errorf("build of '%s' failed: %w", spec.Name, buildErr)
+	}
+	if result != nil && !result.Success {
+		return fmt.Errorf("build of '%s' failed: %s", spec.Name, result.ErrorMessage)
+	}
+	return nil
+}
+
+// applyBuildOverrides replaces the build_config fields set by the flags
+func applyBuildOverrides(cmd *cobra.Command, spec *build.BuildSpec) error {
+	if cmd.Flags().Changed("no-cache") {
+		spec.BuildConfig.NoCache = buildNoCache
+	}
+	if len(buildTags) > 0 {
+		spec.BuildConfig.Tags = buildTags
+	}
+	switch buildOutput {
+	case "":
+	case "docker", "b2":
+		spec.BuildConfig.OutputTarget = buildOutput
+	case "local":
+		spec.BuildConfig.OutputTarget = buildOutput
+		if spec.BuildConfig.LocalPath == "" {
+			// Next to the spec rather than in the build directory, removed with the temporary working directory.
+			// The path is absolute so it is not resolved against --output-dir.
+			specDir, err := filepath.Abs(filepath.Dir(buildSpecFile))
+			if err != nil {
+				return fmt.Errorf("failed to resolve the directory of '%s': %w", buildSpecFile, err)
+			}
+			spec.BuildConfig.LocalPath = specDir
+		}
+	default:
+		return fmt.Errorf("unknown output target '%s' (available: docker, local, b2)", buildOutput)
+	}
+	return nil
+}
+
+// buildSecretFetcher registers the AWS fetchers used by the secrets of the spec, and the one of the
+// registries of the config file
+func buildSecretFetcher(ctx context.Context, spec *build.BuildSpec) (build.SecretFetcher, error) {
+	registry := secrets.NewRegistry()
+	registered := make(map[string]bool)
+	if len(configRegistries) > 0 {
+		registry.Register(configSecretScheme, configSecretFetcher{})
+	}
+	for _, secret := range spec.Secrets {
+		scheme, _, ok := secrets.ParseSource(secret.Source)
+		if !ok || registered[scheme] {
+			continue
+		}
+		var fetcher secrets.Fetcher
+		var err error
+		switch scheme {
+		case secrets.SchemeSecretsManager:
+			fetcher, err = secrets.NewSecretsManagerFetcher(ctx, secrets.AWSConfig{})
+		case secrets.SchemeSSM:
+			fetcher, err = secrets.NewSSMFetcher(ctx, secrets.AWSConfig{})
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot create the '%s' secret provider: %w", scheme, err)
+		}
+		registry.Register(scheme, fetcher)
+		registered[scheme] = true
+	}
+	return registry, nil
+}
+
+// phaseSummary is a row of the summary table
+type phaseSummary struct {
+	name     string
+	duration time.Duration
+	err      string
+}
+
+// printBuildEvents writes the events to out until the channel is closed and returns the finished phases.
+// The warnings and the failures go to stderr.
+func printBuildEvents(events <-chan build.BuildEvent, out io.Writer) []phaseSummary {
+	var phases []phaseSummary
+	for event := range events {
+		switch event.Type {
+		case build.EventPhaseStarted:
+			fmt.Fprintf(out, "==> %s\n", event.Phase)
+		case build.EventPhaseFinished:
+			phases = append(phases, phaseSummary{
+				name:     event.Phase,
+				duration: time.Duration(event.Duration * float64(time.Second)),
+				err:      event.Error,
+			})
+			if event.Error != "" {
+				fmt.Fprintf(os.Stderr, "==> %s failed: %s\n", event.Phase, event.Error)
+			}
+		case build.EventProgress:
+			if !buildQuiet {
+				fmt.Fprintf(out, "--> [%d/%d] %s\n", event.Current, event.Total, event.Service)
+			}
+		case build.EventLog:
+			if !buildQuiet {
+				fmt.Fprintln(out, event.Message)
+			}
+		case build.EventWarning:
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", event.Message)
+		case build.EventArtifact:
+			if !buildQuiet {
+				fmt.Fprintf(out, "--> %s %s\n", event.Artifact, event.Ref)
+			}
+		}
+	}
+	return phases
+}
+
+// printBuildSummary writes the table of the phases and of the built images
+func printBuildSummary(spec *build.BuildSpec, result *build.BuildResult, phases []phaseSummary) {
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PHASE\tSTATUS\tDURATION")
+	for _, phase := range phases {
+		status := "ok"
+		if phase.err != "" {
+			status = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", phase.name, status, phase.duration.Round(10*time.Millisecond))
+	}
+	w.Flush()
+	if result == nil {
+		return
+	}
+
+	if len(result.ServiceOutputs) > 0 || result.ImageID != "" {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tIMAGE\tSIZE")
+		if len(result.ServiceOutputs) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", spec.Name, shortImageID(result.ImageID), formatImageSize(result.ImageSize))
+		}
+		services := make([]string, 0, len(result.ServiceOutputs))
+		for name := range result.ServiceOutputs {
+			services = append(services, name)
+		}
+		sort.Strings(services)
+		for _, name := range services {
+			out := result.ServiceOutputs[name]
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, shortImageID(out.ImageID), formatImageSize(out.ImageSize))
+		}
+		w.Flush()
+	}
+
+	fmt.Println()
+	if result.Success {
+		fmt.Printf("Build of '%s' succeeded in %.1fs.\n", spec.Name, result.BuildTime)
+		if len(spec.BuildConfig.Tags) > 0 {
+			fmt.Printf("Tags: %v\n", spec.BuildConfig.Tags)
+		}
+		if result.RunConfigPath != "" {
+			fmt.Printf("Run config: %s\n", result.RunConfigPath)
+		}
+		return
+	}
+	code := string(result.ErrorCode)
+	if code == "" {
+		code = "unknown"
+	}
+	fmt.Fprintf(os.Stderr, "Build of '%s' failed after %.1fs (%s).\n", spec.Name, result.BuildTime, code)
+}
+
+// buildReport is the result of bx build --json
+type buildReport struct {
+	BuildID     string             `json:"build_id,omitempty"`
+	Name        string             `json:"name"`
+	Version     string             `json:"version"`
+	Success     bool               `json:"success"`
+	ErrorCode   string             `json:"error_code,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	Duration    float64            `json:"duration"` // Seconds
+	Tags        []string           `json:"tags,omitempty"`
+	Images      []buildImageReport `json:"images"`
+	B2Objects   []string           `json:"b2_objects,omitempty"`
+	RunConfig   string             `json:"run_config,omitempty"`
+	Manifest    string             `json:"manifest,omitempty"`
+	ComposeFile string             `json:"compose_file,omitempty"`
+	EnvFile     string             `json:"env_file,omitempty"`
+	Phases      []buildPhaseReport `json:"phases"`
+}
+
+type buildImageReport struct {
+	Service       string `json:"service"`
+	ImageID       string `json:"image_id"`
+	Size          int64  `json:"size"`
+	Archive       string `json:"archive,omitempty"`        // Image archive of the "local" output
+	ArchiveDigest string `json:"archive_digest,omitempty"` // sha256 of the archive of the "local" and "b2" outputs
+}
+
+type buildPhaseReport struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"` // Seconds
+	Error    string  `json:"error,omitempty"`
+}
+
+func newBuildReport(spec *build.BuildSpec, result *build.BuildResult, phases []phaseSummary) buildReport {
+	report := buildReport{
+		Name:    spec.Name,
+		Version: spec.Version,
+		Tags:    spec.BuildConfig.Tags,
+		Images:  []buildImageReport{},
+		Phases:  []buildPhaseReport{},
+	}
+	for _, phase := range phases {
+		report.Phases = append(report.Phases, buildPhaseReport{Name: phase.name, Duration: phase.duration.Seconds(), Error: phase.err})
+	}
+	if result == nil {
+		return report
+	}
+	report.BuildID = result.BuildID
+	report.Success = result.Success
+	report.ErrorCode = string(result.ErrorCode)
+	report.Error = result.ErrorMessage
+	report.Duration = result.BuildTime
+	report.B2Objects = result.B2ObjectNames
+	report.RunConfig = result.RunConfigPath
+	report.Manifest = result.ManifestPath
+	report.ComposeFile = result.ComposeFilePath
+	report.EnvFile = result.EnvFilePath
+	if len(result.ServiceOutputs) == 0 && result.ImageID != "" {
+		// Single Dockerfile build, its image is named after the spec as in the summary
+		report.Images = append(report.Images, buildImageReport{Service: spec.Name, ImageID: result.ImageID, Size: result.ImageSize})
+	}
+	services := make([]string, 0, len(result.ServiceOutputs))
+	for name := range result.ServiceOutputs {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	for _, name := range services {
+		out := result.ServiceOutputs[name]
+		report.Images = append(report.Images, buildImageReport{
+			Service:       name,
+			ImageID:       out.ImageID,
+			Size:          out.ImageSize,
+			Archive:       result.LocalImagePaths[name],
+			ArchiveDigest: result.ArchiveDigests[name],
+		})
+	}
+	return report
+}
+
+func shortImageID(id string) string {
+	if len(id) > 19 && id[:7] == "sha256:" {
+		return id[7:19]
+	}
+	return id
+}
+
+// formatImageSize returns the size in the docker CLI units
+func formatImageSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
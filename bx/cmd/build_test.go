@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBuildReport_Images(t *testing.T) {
+	spec := &build.BuildSpec{Name: "app", Version: "1.0"}
+	phases := []phaseSummary{{name: build.PhaseBuild, duration: 2 * time.Second}}
+
+	// A single Dockerfile build reports its image under the name of the spec
+	report := newBuildReport(spec, &build.BuildResult{Success: true, ImageID: "sha256:abc", ImageSize: 42}, phases)
+	require.Len(t, report.Images, 1)
+	assert.Equal(t, buildImageReport{Service: "app", ImageID: "sha256:abc", Size: 42}, report.Images[0])
+	assert.Equal(t, 2.0, report.Phases[0].Duration)
+
+	report = newBuildReport(spec, &build.BuildResult{
+		Success: true,
+		ImageID: "sha256:abc",
+		ServiceOutputs: map[string]build.ServiceOutput{
+			"worker": {ImageID: "sha256:def", ImageSize: 2},
+			"api":    {ImageID: "sha256:ghi", ImageSize: 1},
+		},
+	}, phases)
+	require.Len(t, report.Images, 2)
+	assert.Equal(t, "api", report.Images[0].Service)
+	assert.Equal(t, "worker", report.Images[1].Service)
+
+	report = newBuildReport(spec, nil, phases)
+	assert.Empty(t, report.Images)
+}
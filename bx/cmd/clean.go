@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cleanWorkDir   string
+	cleanOlderThan time.Duration
+	cleanDryRun    bool
+
+	cleanCmd = &cobra.Command{
+		Use:   "clean",
+		Short: "Remove the leftovers of the interrupted builds and runs.",
+		Long: `Clean removes what the builds and the runs leave behind when they are interrupted:
+
+  - the temporary working directories of the builds (buildservice-work-* in the temp directory),
+    and the build directories of --work-dir, those of the running builds kept;
+  - the temporary images of the build steps (<name>-<version>-step-<step>);
+  - the containers labelled by bx run and bx deploy which are not running.
+
+Only what is older than --older-than is removed: the directories whose content was last
+modified, the images created and the containers stopped before. --older-than 0 removes everything, including the
+working directories of the builds in progress. --dry-run lists what would be removed.`,
+		Args: cobra.NoArgs,
+		RunE: runCleanCommand,
+	}
+)
+
+func init() {
+	cleanCmd.Flags().StringVar(&cleanWorkDir, "work-dir", "", "Also remove the old build directories of this working directory")
+	cleanCmd.Flags().DurationVar(&cleanOlderThan, "older-than", time.Hour, "Only remove what is older than this")
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "List what would be removed without removing it")
+}
+
+func runCleanCommand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	policy := build.GCPolicy{MaxAge: cleanOlderThan, DryRun: cleanDryRun}
+	report := &build.GCReport{}
+	merge := func(r *build.GCReport) {
+		if r != nil {
+			report.Removed = append(report.Removed, r.Removed...)
+			report.Failed = append(report.Failed, r.Failed...)
+			report.FreedBytes += r.FreedBytes
+		}
+	}
+
+	r, err := build.CollectTempWorkDirs(policy)
+	merge(r)
+	if err != nil {
+		return err
+	}
+	if cleanWorkDir != "" {
+		if _, err := os.Stat(cleanWorkDir); err != nil {
+			return fmt.Errorf("cannot read the working directory '%s': %w", cleanWorkDir, err)
+		}
+	}
+	service, err := build.NewBuildService(cleanWorkDir, false, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create the build service: %w", err)
+	}
+	if cleanWorkDir != "" {
+		r, err := service.CollectWorkDirs(policy)
+		merge(r)
+		if err != nil {
+			return err
+		}
+	}
+	r, err = service.CollectStepImages(ctx, policy)
+	merge(r)
+	if err != nil {
+		return err
+	}
+
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+	containers, err := deploy.StaleContainers(ctx, docker, cleanOlderThan)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		resource := build.CollectedResource{
+			Kind:    build.ResourceContainer,
+			ID:      c.ID,
+			Created: time.Unix(c.Created, 0),
+		}
+		if !cleanDryRun {
+			if err := docker.ContainerRemove(ctx, c.ID, container.RemoveOptions{RemoveVolumes: true}); err != nil && !errdefs.IsNotFound(err) {
+				resource.Error = err.Error()
+				report.Failed = append(report.Failed, resource)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, resource)
+	}
+
+	printCleanReport(report, containers)
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("cannot remove %d resources", len(report.Failed))
+	}
+	return nil
+}
+
+// printCleanReport writes the removed and the failed resources, the containers named after their project
+func printCleanReport(report *build.GCReport, containers []container.Summary) {
+	if len(report.Removed) == 0 && len(report.Failed) == 0 {
+		fmt.Println("Nothing to clean.")
+		return
+	}
+	names := make(map[string]string, len(containers))
+	for _, c := range containers {
+		names[c.ID] = fmt.Sprintf("%s (%s/%s)", shortContainerID(c.ID), c.Labels[deploy.LabelProject], c.Labels[deploy.LabelService])
+	}
+	describe := func(resource build.CollectedResource) string {
+		switch resource.Kind {
+		case build.ResourceContainer:
+			return names[resource.ID]
+		case build.ResourceImage:
+			return shortImageID(resource.ID)
+		}
+		return resource.ID
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, resource := range report.Removed {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", resource.Kind, describe(resource), formatImageSize(resource.Size))
+	}
+	w.Flush()
+	for _, resource := range report.Failed {
+		fmt.Fprintf(os.Stderr, "Cannot remove the %s %s: %s\n", resource.Kind, describe(resource), resource.Error)
+	}
+	if cleanDryRun {
+		fmt.Printf("Would remove %d resources, %s.\n", len(report.Removed), formatImageSize(report.FreedBytes))
+	} else {
+		fmt.Printf("Removed %d resources, %s freed.\n", len(report.Removed), formatImageSize(report.FreedBytes))
+	}
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Print the shell completion script of bx.",
+	Long: `Completion prints the script completing the commands and the flags of bx in a shell.
+
+Bash (requires the bash-completion package):
+  source <(bx completion bash)
+  bx completion bash > /etc/bash_completion.d/bx
+
+Zsh (compinit must be enabled):
+  bx completion zsh > "${fpath[1]}/_bx"
+
+Fish:
+  bx completion fish > ~/.config/fish/completions/bx.fish`,
+	Args:                  cobra.ExactArgs(1),
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		default:
+			return fmt.Errorf("unknown shell '%s' (available: bash, zsh, fish)", args[0])
+		}
+	},
+}
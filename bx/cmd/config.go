@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	configFile string
+
+	// Registries of the config file, their credentials are served to the builds by the config scheme
+	configRegistries []configRegistry
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Show the bx config file.",
+		Long: `The config file gives the defaults of the flags and the credentials used by the commands,
+in YAML. It is read from $XDG_CONFIG_HOME/bx/config.yaml (~/.config/bx/config.yaml by default)
+or from --config:
+
+  server: wss://builds.example.com/ws   # bx remote build --server
+  token: <token>                         # bx remote build --token
+  output_dir: /srv/bx                    # --output-dir
+  file_mode: "0640"                      # --file-mode
+  dir_mode: "0750"                       # --dir-mode
+  chown: "1000:1000"                     # --chown
+  artifacts:
+    storage: b2                          # bx push/pull --storage
+    storage_dir: artifacts               # bx push/pull --storage-dir
+  b2:
+    account_id: <id>                     # B2_ACCOUNT_ID
+    application_key: <key>               # B2_APPLICATION_KEY
+    bucket: my-bucket                    # B2_BUCKET, --b2-bucket
+    base_path: builds                    # B2_BASE_PATH, --b2-base-path
+  registries:                            # Credentials of the registries of the builds
+    - host: ghcr.io
+      username: me
+      password: <token>
+
+The flags and the environment variables win over the config file. The registries of the
+config are used by the builds whose spec does not list them.`,
+	}
+
+	configPathCmd = &cobra.Command{
+		Use:   "path",
+		Short: "Print the path of the config file.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := configFilePath()
+			if err != nil {
+				return err
+			}
+			fmt.Println(path)
+			return nil
+		},
+	}
+
+	configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Print the values of the config file, the secrets masked.",
+		Args:  cobra.NoArgs,
+		RunE:  runConfigShowCommand,
+	}
+)
+
+func init() {
+	configCmd.AddCommand(configPathCmd)
+	configCmd.AddCommand(configShowCmd)
+}
+
+// configRegistry is a registry of the config file, with literal credentials
+type configRegistry struct {
+	Host     string `mapstructure:"host"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Token    string `mapstructure:"token"`
+}
+
+// configFlag is a flag whose default is read from a key of the config file. The flags reading an
+// environment variable keep its value when it is set.
+type configFlag struct {
+	key      string
+	flag     string
+	env      string
+	commands []string // Paths of the commands having the flag ("remote build"), all if empty
+}
+
+var configFlags = []configFlag{
+	{key: "output_dir", flag: "output-dir"},
+	{key: "file_mode", flag: "file-mode"},
+	{key: "dir_mode", flag: "dir-mode"},
+	{key: "chown", flag: "chown"},
+	{key: "server", flag: "server", commands: []string{"remote build", "tui"}},
+	{key: "token", flag: "token", env: "BX_TOKEN", commands: []string{"remote build", "tui"}},
+	{key: "artifacts.storage", flag: "storage", commands: []string{"push", "pull"}},
+	{key: "artifacts.storage_dir", flag: "storage-dir", commands: []string{"push", "pull"}},
+	{key: "b2.bucket", flag: "b2-bucket", env: "B2_BUCKET", commands: []string{"push", "pull", "registry serve"}},
+	{key: "b2.base_path", flag: "b2-base-path", env: "B2_BASE_PATH", commands: []string{"push", "pull"}},
+}
+
+// Environment variables read by the commands and the build service, set from the config file when unset
+var configEnv = map[string]string{
+	"b2.account_id":      "B2_ACCOUNT_ID",
+	"b2.application_key": "B2_APPLICATION_KEY",
+	"b2.bucket":          "B2_BUCKET",
+	"b2.base_path":       "B2_BASE_PATH",
+}
+
+// Keys of the config file masked by bx config show
+var configSecretKeys = []string{"token", "b2.application_key"}
+
+// configFilePath returns the config file given with --config, else the one of the user config directory
+func configFilePath() (string, error) {
+	if configFile != "" {
+		return configFile, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot find the config directory: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "bx", "config.yaml"), nil
+}
+
+// readConfig reads the config file, nil when the default file does not exist
+func readConfig() (*viper.Viper, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		if configFile == "" && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read the config file '%s': %w", path, err)
+	}
+	return v, nil
+}
+
+// loadConfig applies the config file to the flags left unset of the command and to the environment
+func loadConfig(cmd *cobra.Command, args []string) error {
+	v, err := readConfig()
+	if err != nil || v == nil {
+		return err
+	}
+	commandPath := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	for _, config := range configFlags {
+		if !v.IsSet(config.key) || (len(config.commands) > 0 && !slices.Contains(config.commands, commandPath)) {
+			continue
+		}
+		flag := cmd.Flags().Lookup(config.flag)
+		if flag == nil || flag.Changed || (config.env != "" && os.Getenv(config.env) != "") {
+			continue
+		}
+		if err := flag.Value.Set(v.GetString(config.key)); err != nil {
+			return fmt.Errorf("invalid value for '%s' in the config file: %w", config.key, err)
+		}
+	}
+	for key, env := range configEnv {
+		if v.IsSet(key) && os.Getenv(env) == "" {
+			os.Setenv(env, v.GetString(key))
+		}
+	}
+	if err := v.UnmarshalKey("registries", &configRegistries); err != nil {
+		return fmt.Errorf("invalid registries in the config file: %w", err)
+	}
+	for _, registry := range configRegistries {
+		if registry.Host == "" {
+			return fmt.Errorf("a registry of the config file has no host")
+		}
+	}
+	return nil
+}
+
+func runConfigShowCommand(cmd *cobra.Command, args []string) error {
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	v, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		fmt.Printf("No config file (%s).\n", path)
+		return nil
+	}
+	fmt.Printf("# %s\n", path)
+	keys := v.AllKeys()
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := v.Get(key)
+		if slices.Contains(configSecretKeys, key) {
+			value = "********"
+		}
+		if key == "registries" {
+			var registries []configRegistry
+			if err := v.UnmarshalKey(key, &registries); err != nil {
+				return fmt.Errorf("invalid registries in the config file: %w", err)
+			}
+			hosts := make([]string, 0, len(registries))
+			for _, registry := range registries {
+				hosts = append(hosts, registry.Host)
+			}
+			value = strings.Join(hosts, ", ")
+		}
+		fmt.Printf("%s: %v\n", key, value)
+	}
+	return nil
+}
+
+// Scheme of the secret sources served from the registries of the config file
+const configSecretScheme = "bx-config"
+
+// configSecretFetcher returns the credentials of the registries of the config file, the sources
+// are written "bx-config://<host>/password" and "bx-config://<host>/token"
+type configSecretFetcher struct{}
+
+func (configSecretFetcher) GetSecret(ctx context.Context, ref string) (string, error) {
+	host, field, _ := strings.Cut(ref, "/")
+	for _, registry := range configRegistries {
+		if registry.Host != host {
+			continue
+		}
+		switch field {
+		case "password":
+			return registry.Password, nil
+		case "token":
+			return registry.Token, nil
+		}
+	}
+	return "", fmt.Errorf("no %s for the registry '%s' in the config file", field, host)
+}
+
+// addConfigRegistries adds the registries of the config file the spec does not list
+func addConfigRegistries(spec *build.BuildSpec) {
+	for _, registry := range configRegistries {
+		if slices.ContainsFunc(spec.Registries, func(r build.RegistryConfig) bool { return r.Host == registry.Host }) {
+			continue
+		}
+		config := build.RegistryConfig{Host: registry.Host, Username: registry.Username}
+		if registry.Password != "" {
+			config.Password = configSecretScheme + "://" + registry.Host + "/password"
+		}
+		if registry.Token != "" {
+			config.Token = configSecretScheme + "://" + registry.Host + "/token"
+		}
+		spec.Registries = append(spec.Registries, config)
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Treefle-labs/Anexis/bx/convert"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	convertOutput  string
+	convertName    string
+	convertVersion string
+	convertSource  string
+
+	convertCmd = &cobra.Command{
+		Use:   "convert",
+		Short: "Generate a best-effort build spec from the files of other tools.",
+		Long: `Generate a best-effort build spec from a docker-compose file or a GitHub Actions workflow.
+The secrets get placeholder sources (todo://<name>) to replace before building,
+the approximations of the conversion are printed as warnings.`,
+	}
+
+	convertComposeCmd = &cobra.Command{
+		Use:   "compose <docker-compose.yml>",
+		Short: "Convert a docker-compose file to a build spec.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConvert(args[0], convert.FromCompose)
+		},
+	}
+
+	convertGHACmd = &cobra.Command{
+		Use:   "gha <workflow.yml>",
+		Short: "Convert the docker build of a GitHub Actions workflow to a build spec.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConvert(args[0], convert.FromGitHubActions)
+		},
+	}
+)
+
+func init() {
+	convertCmd.PersistentFlags().StringVarP(&convertOutput, "output", "o", "", "Write the spec to this file instead of the standard output")
+	convertCmd.PersistentFlags().StringVar(&convertName, "name", "", "Name of the spec (derived from the converted file by default)")
+	convertCmd.PersistentFlags().StringVar(&convertVersion, "version", convert.DefaultVersion, "Version of the spec")
+	convertCmd.PersistentFlags().StringVar(&convertSource, "source", "", "Codebase source, a local directory or a git URL (derived from the converted file by default)")
+	convertCmd.AddCommand(convertComposeCmd)
+	convertCmd.AddCommand(convertGHACmd)
+}
+
+func runConvert(path string, converter func([]byte, string, convert.Options) (*convert.Result, error)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %w", path, err)
+	}
+	result, err := converter(data, path, convert.Options{Name: convertName, Version: convertVersion, Source: convertSource})
+	if err != nil {
+		return fmt.Errorf("cannot convert '%s': %w", path, err)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	spec, err := result.YAML()
+	if err != nil {
+		return err
+	}
+
+	if convertOutput == "" {
+		_, err = os.Stdout.Write(spec)
+		return err
+	}
+	output, err := outputOptions()
+	if err != nil {
+		return err
+	}
+	target := output.Resolve(convertOutput)
+	if err := output.WriteFile(target, spec); err != nil {
+		return fmt.Errorf("cannot write the spec '%s': %w", target, err)
+	}
+	fmt.Fprintf(os.Stderr, "Build spec written to %s\n", target)
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	deployRunFile     string
+	deployTarget      string
+	deployTargetsFile string
+	deployHistoryFile string
+	deploySkipCanary  bool
+	deployBy          string
+
+	deployCmd = &cobra.Command{
+		Use:   "deploy -f <run.yml> --target <name>",
+		Short: "Ship and start the services of a .run.yml on a deployment target.",
+		Long: `Deploy reads a .run.yml file, connects to the target declared in the targets file
+(anexis.targets.yml by default), loads the local image archives on its docker engine
+and (re)starts every service in dependency order. The "ssh" driver only needs sshd
+and dockerd on the remote host.
+
+When the target declares a canary probe, the replaced containers are kept until the
+probe passes for the whole canary window and restored automatically if it fails.
+Every deployment is recorded in the history file.`,
+		Args: cobra.NoArgs,
+		RunE: runDeployCommand,
+	}
+)
+
+func init() {
+	deployCmd.Flags().StringVarP(&deployRunFile, "file", "f", "", "Path to the .run.yml file (required)")
+	deployCmd.Flags().StringVarP(&deployTarget, "target", "t", "", "Name of the deployment target (required)")
+	deployCmd.Flags().StringVar(&deployTargetsFile, "targets", deploy.DefaultTargetsFile, "Path to the targets file")
+	deployCmd.Flags().StringVar(&deployHistoryFile, "history", deploy.DefaultHistoryFile, "Path to the deployment history file")
+	deployCmd.Flags().BoolVar(&deploySkipCanary, "skip-canary", false, "Ignore the canary probe of the target")
+	deployCmd.Flags().StringVar(&deployBy, "by", "", "Author recorded in the deployment history ($USER by default)")
+	deployCmd.MarkFlagRequired("file")
+	deployCmd.MarkFlagRequired("target")
+}
+
+func runDeployCommand(cmd *cobra.Command, args []string) error {
+	output, err := outputOptions()
+	if err != nil {
+		return err
+	}
+	targets, err := deploy.LoadTargetsFile(deployTargetsFile)
+	if err != nil {
+		return err
+	}
+	target, ok := targets[deployTarget]
+	if !ok {
+		names := make([]string, 0, len(targets))
+		for name := range targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown target '%s' (available: %s)", deployTarget, strings.Join(names, ", "))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	history := deploy.NewHistory(output.Resolve(deployHistoryFile))
+	history.SetOutputOptions(output)
+
+	record, err := deploy.Deploy(ctx, target, deployRunFile, deploy.Options{
+		Out:        os.Stdout,
+		History:    history,
+		SkipCanary: deploySkipCanary,
+		DeployedBy: deployBy,
+	})
+	if err != nil {
+		return fmt.Errorf("deployment to '%s' failed: %w", target.Name, err)
+	}
+	fmt.Printf("Deployment %s to '%s' done, %d service(s) started.\n", record.ID, target.Name, len(record.Services))
+	return nil
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	deploymentsHistoryFile string
+	deploymentsTarget      string
+	deploymentsLimit       int
+	deploymentsJSON        bool
+
+	deploymentsCmd = &cobra.Command{
+		Use:   "deployments",
+		Short: "Inspect the deployment history.",
+	}
+
+	deploymentsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the latest deployments, newest first.",
+		Args:  cobra.NoArgs,
+		RunE:  runDeploymentsListCommand,
+	}
+
+	deploymentsShowCmd = &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a deployment: target, author, image digests, containers and outcome.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runDeploymentsShowCommand,
+	}
+)
+
+func init() {
+	deploymentsCmd.PersistentFlags().StringVar(&deploymentsHistoryFile, "history", deploy.DefaultHistoryFile, "Path to the deployment history file")
+	deploymentsCmd.PersistentFlags().BoolVar(&deploymentsJSON, "json", false, "Print the records as JSON")
+	deploymentsListCmd.Flags().StringVarP(&deploymentsTarget, "target", "t", "", "Only list the deployments of this target")
+	deploymentsListCmd.Flags().IntVarP(&deploymentsLimit, "limit", "n", 20, "Number of deployments to list (0 for all)")
+	deploymentsCmd.AddCommand(deploymentsListCmd)
+	deploymentsCmd.AddCommand(deploymentsShowCmd)
+}
+
+func deploymentsHistory() (*deploy.History, error) {
+	output, err := outputOptions()
+	if err != nil {
+		return nil, err
+	}
+	return deploy.NewHistory(output.Resolve(deploymentsHistoryFile)), nil
+}
+
+func runDeploymentsListCommand(cmd *cobra.Command, args []string) error {
+	history, err := deploymentsHistory()
+	if err != nil {
+		return err
+	}
+	records, err := history.Latest(deploymentsTarget, deploymentsLimit)
+	if err != nil {
+		return err
+	}
+	if deploymentsJSON {
+		return printJSON(records)
+	}
+	if len(records) == 0 {
+		fmt.Println("No deployment recorded.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTARGET\tOUTCOME\tBY\tSTARTED\tDURATION")
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", record.ID, record.Target, record.Outcome, record.DeployedBy,
+			record.StartedAt.Local().Format(time.DateTime), record.FinishedAt.Sub(record.StartedAt).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+func runDeploymentsShowCommand(cmd *cobra.Command, args []string) error {
+	history, err := deploymentsHistory()
+	if err != nil {
+		return err
+	}
+	record, err := history.Get(args[0])
+	if err != nil {
+		return err
+	}
+	if deploymentsJSON {
+		return printJSON(record)
+	}
+
+	fmt.Printf("ID:          %s\n", record.ID)
+	fmt.Printf("Target:      %s (%s)\n", record.Target, record.Host)
+	fmt.Printf("Project:     %s\n", record.Project)
+	fmt.Printf("Run file:    %s\n", record.RunFile)
+	fmt.Printf("Deployed by: %s\n", record.DeployedBy)
+	fmt.Printf("Started:     %s\n", record.StartedAt.Local().Format(time.RFC3339))
+	fmt.Printf("Finished:    %s\n", record.FinishedAt.Local().Format(time.RFC3339))
+	fmt.Printf("Outcome:     %s\n", record.Outcome)
+	if record.Error != "" {
+		fmt.Printf("Error:       %s\n", record.Error)
+	}
+	if record.Canary != nil {
+		fmt.Printf("Canary:      %s (%s)\n", record.Canary.Decision, record.Canary.Reason)
+	}
+
+	services := make([]string, 0, len(record.Images))
+	for name := range record.Images {
+		services = append(services, name)
+	}
+	for name := range record.Services {
+		if _, ok := record.Images[name]; !ok {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	if len(services) > 0 {
+		fmt.Println("Services:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, name := range services {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", name, record.Images[name], shortContainerID(record.Services[name]))
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	detectEcosystem  string
+	detectJSON       bool
+	detectDockerfile bool
+
+	detectCmd = &cobra.Command{
+		Use:   "detect [dir]",
+		Short: "Show the ecosystem detected in a project and why it was chosen.",
+		Long: `Show the ecosystem detected in a project directory (the current one by default):
+the marker files and lockfiles found, the score of each language and the warnings.
+When the guess is wrong, --ecosystem forces it ("Go", "JavaScript-pnpm"), as the
+'ecosystem' of the build_config does for the builds.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runDetectCommand,
+	}
+)
+
+func init() {
+	detectCmd.Flags().StringVar(&detectEcosystem, "ecosystem", "", `Force the ecosystem, as "Language" or "Language-PackageManager"`)
+	detectCmd.Flags().BoolVar(&detectJSON, "json", false, "Print the report as JSON")
+	detectCmd.Flags().BoolVar(&detectDockerfile, "dockerfile", false, "Print the Dockerfile generated for the ecosystem")
+}
+
+func runDetectCommand(cmd *cobra.Command, args []string) error {
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	report, err := build.DetectEcosystemReport(dir, detectEcosystem)
+	if report == nil {
+		return err
+	}
+	if detectJSON {
+		if jsonErr := printJSON(report); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	fmt.Printf("Path:        %s\n", report.Path)
+	if ecosystem := report.Ecosystem; ecosystem != nil {
+		fmt.Printf("Ecosystem:   %s (%s, %s)\n", ecosystem.Language, ecosystem.Ecosystem, ecosystem.PackageManager)
+		if ecosystem.LanguageVersion != "" {
+			fmt.Printf("Version:     %s\n", ecosystem.LanguageVersion)
+		}
+		if ecosystem.Framework != "" {
+			fmt.Printf("Framework:   %s\n", ecosystem.Framework)
+		}
+		if report.Override != "" {
+			fmt.Printf("Override:    %s\n", report.Override)
+		} else {
+			fmt.Printf("Confidence:  %.0f%%\n", report.Confidence*100)
+		}
+	}
+	if len(report.Markers) > 0 {
+		fmt.Println("Markers:")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, marker := range report.Markers {
+			fmt.Fprintf(w, "  %s\t%s\t%s\tpriority %d\n", marker.File, marker.Language, marker.PackageManager, marker.Priority)
+		}
+		if flushErr := w.Flush(); flushErr != nil {
+			return flushErr
+		}
+	}
+	for _, lockfile := range report.Lockfiles {
+		fmt.Printf("Lockfile:    %s\n", lockfile)
+	}
+	if report.Devcontainer != "" {
+		fmt.Printf("Devcontainer: %s\n", report.Devcontainer)
+		if report.Ecosystem != nil && report.Ecosystem.BuilderImage != "" {
+			fmt.Printf("Build image: %s\n", report.Ecosystem.BuilderImage)
+		}
+	}
+	for _, warning := range report.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if err != nil {
+		return err
+	}
+
+	if detectDockerfile {
+		dockerfile, err := build.RenderDockerfile(report.Ecosystem, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Print(dockerfile)
+	}
+	return nil
+}
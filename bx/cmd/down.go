@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	downRunFile string
+	downProject string
+	downVolumes bool
+
+	downCmd = &cobra.Command{
+		Use:   "down (-f <run.yml> | -p <project>)",
+		Short: "Remove the containers, networks and volumes started by bx run.",
+		Long: `Down stops and removes the containers of a project started by 'bx run', its networks
+and the secret files of its services. The named volumes hold data, they are only removed
+with --volumes. The external networks and volumes of the run file are never removed.`,
+		Args: cobra.NoArgs,
+		RunE: runDownCommand,
+	}
+)
+
+func init() {
+	downCmd.Flags().StringVarP(&downRunFile, "file", "f", "", "Remove the project of this .run.yml")
+	downCmd.Flags().StringVarP(&downProject, "project", "p", "", "Remove this project")
+	downCmd.Flags().BoolVarP(&downVolumes, "volumes", "v", false, "Remove the named volumes of the project too")
+	downCmd.MarkFlagsMutuallyExclusive("file", "project")
+}
+
+func runDownCommand(cmd *cobra.Command, args []string) error {
+	project, err := requiredProject(downRunFile, downProject)
+	if err != nil {
+		return err
+	}
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	// The secret directories are only known from the labels of the containers about to be removed
+	containers, err := deploy.ProjectContainers(cmd.Context(), docker, project, true)
+	if err != nil {
+		return err
+	}
+	if err := deploy.RemoveProject(cmd.Context(), docker, project, downVolumes, os.Stdout); err != nil {
+		return err
+	}
+	for _, c := range containers {
+		dir := c.Labels[runSecretsDirLabel]
+		// Only the directories created by mountSecretFiles, the labels may have been set by anyone
+		if dir == "" || !strings.HasPrefix(filepath.Base(dir), "bx-secrets-") {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Warning: cannot remove the secret files %s: %v\n", dir, err)
+		}
+	}
+	fmt.Printf("Project %s removed.\n", project)
+	return nil
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	initOutput     string
+	initName       string
+	initYes        bool
+	initForce      bool
+	initDockerfile bool
+
+	initCmd = &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Write a starter build specification for a project.",
+		Long: `Init detects the ecosystems of a project directory (the current one by default) and of its
+subdirectories, asks which ones are codebases of the build, the name and the tags of the image,
+and writes a starter build specification (anexis.build.yml) in the directory.
+
+The image is built from the first codebase, with its Dockerfile or the one generated for its
+ecosystem at build time. The generated Dockerfiles can also be written now, to be customized,
+with --dockerfile or by answering the question. --yes accepts every default without asking.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runInitCommand,
+	}
+)
+
+func init() {
+	initCmd.Flags().StringVarP(&initOutput, "output", "o", build.DefaultSpecFile, "Name of the specification, in the project directory")
+	initCmd.Flags().StringVar(&initName, "name", "", "Name of the project, the one of the directory by default")
+	initCmd.Flags().BoolVarP(&initYes, "yes", "y", false, "Accept the defaults without asking")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite an existing specification")
+	initCmd.Flags().BoolVar(&initDockerfile, "dockerfile", false, "Write the generated Dockerfile of the codebases without one")
+}
+
+func runInitCommand(cmd *cobra.Command, args []string) error {
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("cannot resolve the directory '%s': %w", dir, err)
+	}
+	specPath := initOutput
+	if !filepath.IsAbs(specPath) {
+		specPath = filepath.Join(absDir, specPath)
+	}
+	if _, err := os.Stat(specPath); err == nil && !initForce {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", specPath)
+	}
+
+	proposed, err := build.ProposeCodebases(absDir)
+	if errors.Is(err, build.ErrNoEcosystemFound) {
+		return fmt.Errorf("no project found in %s (go.mod, package.json, Cargo.toml...), cannot propose a codebase", absDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout, yes: initYes}
+	fmt.Printf("Detected in %s:\n", absDir)
+	for _, codebase := range proposed {
+		fmt.Printf("  %-20s %s\n", codebase.Path, describeEcosystem(codebase.Ecosystem))
+	}
+	fmt.Println()
+
+	var codebases []build.ScaffoldCodebase
+	for _, codebase := range proposed {
+		if p.confirm(fmt.Sprintf("Include %s (%s) as the codebase '%s'?", codebase.Path, codebase.Ecosystem.Language, codebase.Name), true) {
+			codebases = append(codebases, codebase)
+		}
+	}
+	if len(codebases) == 0 {
+		return fmt.Errorf("no codebase selected, nothing to write")
+	}
+	if len(codebases) > 1 {
+		fmt.Printf("The image is built from '%s', the other codebases are copied next to it.\n", codebases[0].Name)
+	}
+
+	name := initName
+	if name == "" {
+		name = p.ask("Project name", build.ScaffoldName(filepath.Base(absDir)))
+	}
+	version := p.ask("Version", "0.1.0")
+	var tags []string
+	for _, tag := range strings.Split(p.ask("Image tags (comma separated)", build.ScaffoldName(name)+":latest"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, codebase := range codebases {
+		dockerfile := filepath.Join(codebase.Ecosystem.RootPath, "Dockerfile")
+		if _, err := os.Stat(dockerfile); err == nil {
+			continue
+		}
+		if !p.confirm(fmt.Sprintf("Write the generated Dockerfile of '%s'?", codebase.Name), initDockerfile) {
+			continue
+		}
+		content, err := build.RenderDockerfile(&codebase.Ecosystem, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: no Dockerfile for '%s': %v\n", codebase.Name, err)
+			continue
+		}
+		if err := os.WriteFile(dockerfile, []byte(content), 0644); err != nil {
+			return fmt.Errorf("cannot write the Dockerfile '%s': %w", dockerfile, err)
+		}
+		fmt.Printf("Wrote %s\n", dockerfile)
+	}
+
+	spec := build.StarterSpec(name, version, codebases, tags)
+	data, err := build.MarshalStarterSpec(spec, codebases)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(specPath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write the specification '%s': %w", specPath, err)
+	}
+	fmt.Printf("Wrote %s\n", specPath)
+
+	// The answers may not make a valid spec (a tag with uppercase letters), better to say it now
+	options := build.ValidateOptions{Online: true, BaseDir: absDir}
+	for _, issue := range build.ValidateBuildSpec(cmd.Context(), data, filepath.Ext(specPath), options) {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", issue)
+	}
+	if rel, err := filepath.Rel(absDir, specPath); err == nil {
+		specPath = rel
+	}
+	fmt.Printf("\nBuild it with 'bx build -f %s' from %s.\n", specPath, absDir)
+	return nil
+}
+
+func describeEcosystem(ecosystem build.DetectedEcosystem) string {
+	description := fmt.Sprintf("%s (%s)", ecosystem.Language, ecosystem.PackageManager)
+	if ecosystem.LanguageVersion != "" {
+		description += " " + ecosystem.LanguageVersion
+	}
+	if ecosystem.Framework != "" {
+		description += ", " + ecosystem.Framework
+	}
+	return description
+}
+
+// prompter asks the questions of init on the terminal, the defaults are used with yes or at the end of the input
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	yes bool
+}
+
+// ask returns the answer to a question, def if it is empty
+func (p *prompter) ask(question, def string) string {
+	if p.yes {
+		return def
+	}
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(p.out)
+		p.yes = true // No more input
+		return def
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks a yes/no question
+func (p *prompter) confirm(question string, def bool) bool {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		switch strings.ToLower(p.ask(question+" ["+choices+"]", "")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
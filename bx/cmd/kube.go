@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+	"github.com/Treefle-labs/Anexis/bx/kube"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	kubeOutput    string
+	kubeName      string
+	kubeNamespace string
+	kubeImages    []string
+
+	kubeCmd = &cobra.Command{
+		Use:   "kube <run.yml>",
+		Short: "Generate Kubernetes manifests from a .run.yml.",
+		Long: `Generate a Deployment per service of a .run.yml, a Service for the services
+publishing ports and Secrets holding their environment and secret files, ready for
+` + "`kubectl apply -f`" + `. The images must be in a registry: the services referencing
+image archives need --image. The approximations are printed as warnings.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runKubeCommand,
+	}
+)
+
+func init() {
+	kubeCmd.Flags().StringVarP(&kubeOutput, "output", "o", "", "Write the manifests to this file instead of the standard output")
+	kubeCmd.Flags().StringVar(&kubeName, "name", "", "Application name, prefix of the object names and part-of label")
+	kubeCmd.Flags().StringVarP(&kubeNamespace, "namespace", "n", "", "Namespace of the objects")
+	kubeCmd.Flags().StringArrayVar(&kubeImages, "image", nil, "Registry image of a service, as <service>=<image> (repeatable)")
+}
+
+func runKubeCommand(cmd *cobra.Command, args []string) error {
+	runConfig, err := deploy.LoadRunFile(args[0])
+	if err != nil {
+		return err
+	}
+	images := make(map[string]string, len(kubeImages))
+	for _, entry := range kubeImages {
+		service, image, ok := strings.Cut(entry, "=")
+		if !ok || service == "" || image == "" {
+			return fmt.Errorf("invalid --image '%s', expected <service>=<image>", entry)
+		}
+		if _, exists := runConfig.Services[service]; !exists {
+			return fmt.Errorf("unknown service '%s' in --image", service)
+		}
+		images[service] = image
+	}
+
+	result, err := kube.Generate(runConfig, kube.Options{Name: kubeName, Namespace: kubeNamespace, Images: images})
+	if err != nil {
+		return fmt.Errorf("cannot generate the manifests of '%s': %w", args[0], err)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	manifests, err := result.YAML()
+	if err != nil {
+		return err
+	}
+
+	if kubeOutput == "" {
+		_, err = os.Stdout.Write(manifests)
+		return err
+	}
+	output, err := outputOptions()
+	if err != nil {
+		return err
+	}
+	target := output.Resolve(kubeOutput)
+	if err := output.WriteFile(target, manifests); err != nil {
+		return fmt.Errorf("cannot write the manifests '%s': %w", target, err)
+	}
+	fmt.Fprintf(os.Stderr, "Kubernetes manifests written to %s\n", target)
+	return nil
+}
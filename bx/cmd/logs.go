@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsRunFile    string
+	logsProject    string
+	logsFollow     bool
+	logsSince      string
+	logsTail       string
+	logsTimestamps bool
+	logsNoColor    bool
+
+	logsCmd = &cobra.Command{
+		Use:   "logs (--file <run.yml> | -p <project>) [service...]",
+		Short: "Show the logs of the containers started by bx run.",
+		Long: `Logs prints the output of the containers of a project started by 'bx run', only those
+of the given services if any. Each line is prefixed with its service, in a color per service
+when the output is a terminal (NO_COLOR or --no-color disable the colors).
+
+--since takes a timestamp (2026-01-02T15:04:05Z) or a duration relative to now (10m, 1h).`,
+		RunE: runLogsCommand,
+	}
+)
+
+func init() {
+	logsCmd.Flags().StringVar(&logsRunFile, "file", "", "Show the logs of the project of this .run.yml")
+	logsCmd.Flags().StringVarP(&logsProject, "project", "p", "", "Show the logs of this project")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Follow the output until the containers stop")
+	logsCmd.Flags().StringVar(&logsSince, "since", "", "Only the logs since a timestamp or a relative duration")
+	logsCmd.Flags().StringVarP(&logsTail, "tail", "n", "all", "Number of lines shown from the end of the logs of each container")
+	logsCmd.Flags().BoolVarP(&logsTimestamps, "timestamps", "t", false, "Show the timestamps of the lines")
+	logsCmd.Flags().BoolVar(&logsNoColor, "no-color", false, "Do not color the service prefixes")
+	logsCmd.MarkFlagsMutuallyExclusive("file", "project")
+}
+
+func runLogsCommand(cmd *cobra.Command, args []string) error {
+	project, err := requiredProject(logsRunFile, logsProject)
+	if err != nil {
+		return err
+	}
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	containers, err := deploy.ProjectContainers(cmd.Context(), docker, project, true)
+	if err != nil {
+		return err
+	}
+	var services []string
+	ids := make(map[string]string)
+	for _, c := range containers {
+		service := c.Labels[deploy.LabelService]
+		if _, seen := ids[service]; seen || (len(args) > 0 && !slices.Contains(args, service)) {
+			continue // A container set aside by a deployment, or another service
+		}
+		services = append(services, service)
+		ids[service] = c.ID
+	}
+	for _, service := range args {
+		if _, ok := ids[service]; !ok {
+			return fmt.Errorf("no container for the service '%s' in %s", service, project)
+		}
+	}
+	if len(services) == 0 {
+		fmt.Printf("No container in %s.\n", project)
+		return nil
+	}
+
+	mux := newLogMux(os.Stdout, services, !logsNoColor && colorOutput(os.Stdout))
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     logsFollow,
+		Since:      logsSince,
+		Tail:       logsTail,
+		Timestamps: logsTimestamps,
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(services))
+	for _, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := streamContainerLogs(cmd.Context(), docker, ids[service], options, mux.Writer(service)); err != nil {
+				errs <- fmt.Errorf("cannot read the logs of '%s': %w", service, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// streamContainerLogs copies the logs of a container to w until they end, or until the container
+// stops when they are followed
+func streamContainerLogs(ctx context.Context, docker client.ContainerAPIClient, containerID string, options container.LogsOptions, w io.WriteCloser) error {
+	defer w.Close()
+	inspect, err := docker.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	logs, err := docker.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	if inspect.Config != nil && inspect.Config.Tty {
+		_, err = io.Copy(w, logs)
+	} else {
+		_, err = stdcopy.StdCopy(w, w, logs)
+	}
+	if ctx.Err() != nil {
+		return nil // Interrupted
+	}
+	return err
+}
+
+// Colors of the service prefixes, picked in the order of the services
+var logColors = []string{"36", "33", "32", "35", "34", "96", "93", "92", "95", "94"}
+
+// logMux writes the output of several services to one writer, each line prefixed with its service
+// and written as a whole so the lines of the services don't mix
+type logMux struct {
+	mu       sync.Mutex
+	out      io.Writer
+	prefixes map[string]string
+}
+
+func newLogMux(out io.Writer, services []string, color bool) *logMux {
+	width := 0
+	for _, service := range services {
+		width = max(width, len(service))
+	}
+	prefixes := make(map[string]string, len(services))
+	for i, service := range services {
+		prefix := fmt.Sprintf("%-*s | ", width, service)
+		if color {
+			prefix = "\033[" + logColors[i%len(logColors)] + "m" + prefix + "\033[0m"
+		}
+		prefixes[service] = prefix
+	}
+	return &logMux{out: out, prefixes: prefixes}
+}
+
+// Writer returns the writer of the output of a service, Close writes its unterminated last line
+func (m *logMux) Writer(service string) io.WriteCloser {
+	prefix, ok := m.prefixes[service]
+	if !ok {
+		prefix = service + " | "
+	}
+	return &prefixWriter{mux: m, prefix: prefix}
+}
+
+func (m *logMux) writeLine(prefix string, line []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	io.WriteString(m.out, prefix)
+	m.out.Write(line)
+}
+
+// prefixWriter buffers the output of a service until the end of its lines
+type prefixWriter struct {
+	mux    *logMux
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.mux.writeLine(w.prefix, w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *prefixWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.mux.writeLine(w.prefix, append(w.buf, '\n'))
+		w.buf = nil
+	}
+	return nil
+}
+
+// colorOutput reports whether the ANSI colors can be written to a file: a terminal, without NO_COLOR
+func colorOutput(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || strings.EqualFold(os.Getenv("TERM"), "dumb") {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
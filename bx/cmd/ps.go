@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	psRunFile string
+	psProject string
+	psAll     bool
+
+	psCmd = &cobra.Command{
+		Use:   "ps [-f <run.yml> | -p <project>]",
+		Short: "List the containers started by bx run.",
+		Long: `Ps lists the containers started from a .run.yml on the local docker engine, those of
+every project by default. The project of a run file is named after it ("bx_app" for app.run.yml).`,
+		Args: cobra.NoArgs,
+		RunE: runPsCommand,
+	}
+)
+
+func init() {
+	psCmd.Flags().StringVarP(&psRunFile, "file", "f", "", "List the containers of this .run.yml")
+	psCmd.Flags().StringVarP(&psProject, "project", "p", "", "List the containers of this project")
+	psCmd.Flags().BoolVarP(&psAll, "all", "a", false, "Include the stopped containers")
+	psCmd.MarkFlagsMutuallyExclusive("file", "project")
+}
+
+func runPsCommand(cmd *cobra.Command, args []string) error {
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	containers, err := deploy.ProjectContainers(cmd.Context(), docker, selectedProject(psRunFile, psProject), psAll)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		fmt.Println("No container started by bx run.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tSERVICE\tCONTAINER\tIMAGE\tSTATUS\tRUN")
+	for _, c := range containers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Labels[deploy.LabelProject], c.Labels[deploy.LabelService],
+			shortContainerID(c.ID), c.Image, c.Status, c.Labels[deploy.LabelRun])
+	}
+	return w.Flush()
+}
+
+// selectedProject returns the project given by its name or by its run file, "" if neither is set
+func selectedProject(runFile, project string) string {
+	if project != "" {
+		return project
+	}
+	if runFile != "" {
+		return runProjectName(runFile)
+	}
+	return ""
+}
+
+// requiredProject is selectedProject for the commands acting on one project
+func requiredProject(runFile, project string) (string, error) {
+	if selected := selectedProject(runFile, project); selected != "" {
+		return selected, nil
+	}
+	return "", fmt.Errorf("a run file (--file) or a project (--project) is required")
+}
+
+// localDocker connects to the docker engine of the environment
+func localDocker() (*client.Client, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the docker client: %w", err)
+	}
+	return docker, nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+	"github.com/Treefle-labs/Anexis/bx/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pullDir    string
+	pullNoLoad bool
+
+	pullCmd = &cobra.Command{
+		Use:   "pull <name>:<version>",
+		Short: "Download an artifact pushed by bx push and load its images in docker.",
+		Long: `Pull downloads the image archives, the run.yml and the manifest of an artifact pushed
+by 'bx push' to a directory (the current one by default), checks the archives against their
+digests and loads them in the local docker engine. The run.yml can then be started with 'bx run'.
+
+Without a version, the versions of the artifact in the store are listed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runPullCommand,
+	}
+)
+
+func init() {
+	addArtifactStoreFlags(pullCmd)
+	pullCmd.Flags().StringVarP(&pullDir, "dir", "d", ".", "Directory receiving the files of the artifact")
+	pullCmd.Flags().BoolVar(&pullNoLoad, "no-load", false, "Only download the files, without loading the images")
+}
+
+func runPullCommand(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	store, err := artifactStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	name, version, ok := strings.Cut(args[0], ":")
+	if !ok || version == "" {
+		versions, err := storage.ArtifactVersions(ctx, store, name)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("no artifact named '%s'", name)
+		}
+		return fmt.Errorf("no version given for '%s', available: %s", name, strings.Join(versions, ", "))
+	}
+
+	manifest, err := storage.PullArtifact(ctx, store, name, version, pullDir, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if !pullNoLoad {
+		docker, err := localDocker()
+		if err != nil {
+			return err
+		}
+		defer docker.Close()
+		for _, img := range manifest.Images {
+			tags, err := deploy.LoadImageArchive(ctx, docker, img.ArchivePath)
+			if err != nil {
+				return fmt.Errorf("cannot load the image of the service '%s': %w", img.Service, err)
+			}
+			fmt.Printf("Loaded %s (%s)\n", strings.Join(tags, ", "), img.Service)
+		}
+	}
+	if manifest.RunConfigPath != "" {
+		fmt.Printf("Run it with 'bx run -f %s'.\n", manifest.RunConfigPath)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/bx/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	artifactStorage    string
+	artifactStorageDir string
+	artifactB2Bucket   string
+	artifactB2BasePath string
+
+	pushCmd = &cobra.Command{
+		Use:   "push <manifest.json | dir>",
+		Short: "Upload the image archives and the run.yml of a local build to the artifact store.",
+		Long: `Push uploads the outputs of a build made with the "local" output target: the image
+archives, the run.yml and the manifest (<name>-<version>.manifest.json, or the only manifest of
+the given directory). They are stored as <name>:<version>, 'bx pull' downloads them back.
+The archives are checked against the digests of the manifest, the env file of the secrets is
+never pushed.
+
+The artifact store is a local directory (--storage-dir, relative to --output-dir) or a B2
+bucket, with the credentials of B2_ACCOUNT_ID and B2_APPLICATION_KEY.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runPushCommand,
+	}
+)
+
+func init() {
+	addArtifactStoreFlags(pushCmd)
+}
+
+// addArtifactStoreFlags adds the flags of the artifact store shared by push and pull
+func addArtifactStoreFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&artifactStorage, "storage", "local", "Artifact store: local or b2")
+	cmd.Flags().StringVar(&artifactStorageDir, "storage-dir", "artifacts", "Directory of the local store (relative to --output-dir)")
+	cmd.Flags().StringVar(&artifactB2Bucket, "b2-bucket", os.Getenv("B2_BUCKET"), "B2 bucket of the b2 store")
+	cmd.Flags().StringVar(&artifactB2BasePath, "b2-base-path", os.Getenv("B2_BASE_PATH"), "Prefix of the objects in the B2 bucket")
+}
+
+// artifactStore opens the store selected by the flags
+func artifactStore(ctx context.Context) (storage.Store, error) {
+	switch artifactStorage {
+	case "local":
+		output, err := outputOptions()
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewFSStore(output.Resolve(artifactStorageDir))
+	case "b2":
+		return storage.NewB2Store(ctx, &build.B2Config{
+			AccountID:      os.Getenv("B2_ACCOUNT_ID"),
+			ApplicationKey: os.Getenv("B2_APPLICATION_KEY"),
+			BucketName:     artifactB2Bucket,
+			BasePath:       artifactB2BasePath,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage '%s' (available: local, b2)", artifactStorage)
+	}
+}
+
+func runPushCommand(cmd *cobra.Command, args []string) error {
+	manifestPath, err := findManifest(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	store, err := artifactStore(ctx)
+	if err != nil {
+		return err
+	}
+	manifest, err := storage.PushArtifact(ctx, store, manifestPath, os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %s:%s (%d images).\n", manifest.Name, manifest.Version, len(manifest.Images))
+	return nil
+}
+
+// findManifest returns the manifest given, or the only one of a directory
+func findManifest(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read '%s': %w", path, err)
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*.manifest.json"))
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no build manifest (*.manifest.json) in '%s'", path)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("several build manifests in '%s', give the one to push", path)
+	}
+}
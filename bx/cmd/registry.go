@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/bx/registry"
+	"github.com/Treefle-labs/Anexis/bx/storage"
+	"github.com/Treefle-labs/Anexis/socket"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	registryAddr       string
+	registryStorage    string
+	registryStorageDir string
+	registryB2Bucket   string
+	registryB2BasePath string
+	registryTokensFile string
+	registryNoAuth     bool
+
+	registryCmd = &cobra.Command{
+		Use:   "registry",
+		Short: "Built-in image registry.",
+	}
+
+	registryServeCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a minimal Docker registry (HTTP API v2) backed by the bx storage.",
+		Long: `Serve exposes the Docker Registry HTTP API v2 subset used by docker push and
+docker pull, storing the blobs and manifests in a local directory or a B2 bucket.
+
+The access is protected by the same tokens as the build server: a tokens file with
+one token per line ("name token" or "token"). Docker clients log in with any user
+name and a token as password:
+
+  docker login registry.example.com:5000 -u ci -p <token>
+
+The B2 credentials are read from B2_ACCOUNT_ID and B2_APPLICATION_KEY.
+Run it behind a TLS terminating proxy, docker refuses plain HTTP registries
+outside of localhost unless they are declared insecure.`,
+		Args: cobra.NoArgs,
+		RunE: runRegistryServeCommand,
+	}
+)
+
+func init() {
+	registryServeCmd.Flags().StringVar(&registryAddr, "addr", ":5000", "Listen address")
+	registryServeCmd.Flags().StringVar(&registryStorage, "storage", "local", "Storage backend: local or b2")
+	registryServeCmd.Flags().StringVar(&registryStorageDir, "storage-dir", "registry-data", "Directory of the local storage (relative to --output-dir)")
+	registryServeCmd.Flags().StringVar(&registryB2Bucket, "b2-bucket", "", "B2 bucket of the b2 storage")
+	registryServeCmd.Flags().StringVar(&registryB2BasePath, "b2-base-path", "registry", "Prefix of the objects in the B2 bucket")
+	registryServeCmd.Flags().StringVar(&registryTokensFile, "tokens-file", "", "File of the accepted tokens, shared with the build server")
+	registryServeCmd.Flags().BoolVar(&registryNoAuth, "no-auth", false, "Serve without authentication (local testing only)")
+	registryCmd.AddCommand(registryServeCmd)
+}
+
+func runRegistryServeCommand(cmd *cobra.Command, args []string) error {
+	if registryTokensFile == "" && !registryNoAuth {
+		return errors.New("--tokens-file is required (use --no-auth to serve an open registry)")
+	}
+	output, err := outputOptions()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var store storage.Store
+	switch registryStorage {
+	case "local":
+		store, err = storage.NewFSStore(output.Resolve(registryStorageDir))
+	case "b2":
+		store, err = storage.NewB2Store(ctx, &build.B2Config{
+			AccountID:      os.Getenv("B2_ACCOUNT_ID"),
+			ApplicationKey: os.Getenv("B2_APPLICATION_KEY"),
+			BucketName:     registryB2Bucket,
+			BasePath:       registryB2BasePath,
+		})
+	default:
+		return fmt.Errorf("unknown storage '%s' (available: local, b2)", registryStorage)
+	}
+	if err != nil {
+		return err
+	}
+
+	handler, err := registry.NewHandler(store, "")
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+	if registryTokensFile != "" {
+		auth, err := socket.LoadTokenFile(registryTokensFile)
+		if err != nil {
+			return err
+		}
+		if auth.Len() == 0 {
+			return fmt.Errorf("no token found in '%s'", registryTokensFile)
+		}
+		handler.SetAuthenticator(auth)
+	}
+
+	server := &http.Server{Addr: registryAddr, Handler: handler, ReadHeaderTimeout: 30 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Registry listening on %s (storage: %s)\n", registryAddr, registryStorage)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("registry server failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/socket"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	remoteServer   string
+	remoteToken    string
+	remoteSpecFile string
+	remoteArch     string
+	remoteLabels   map[string]string
+	remotePull     bool
+
+	remoteCmd = &cobra.Command{
+		Use:   "remote",
+		Short: "Run builds on a build server.",
+	}
+
+	remoteBuildCmd = &cobra.Command{
+		Use:   "build -f <spec.yml> --server <wss://...>",
+		Short: "Submit a build specification to a build server and follow it.",
+		Long: `Build sends a build specification to a build server over its websocket API and streams
+the build to the terminal until it ends: the position in the queue, the status changes, the
+progress and the logs. The command exits with an error if the build failed. The connection is
+restored after a network failure, the missed logs are replayed.
+
+Interrupting the command stops following the build, which goes on on the server.
+The token of the server is read from --token or BX_TOKEN. --arch and --label select the agents
+allowed to run the build when the server is a coordinator.
+
+With --pull the artifact of the build is loaded in the local docker engine: an image of a
+registry, or a b2:// archive read with B2_ACCOUNT_ID and B2_APPLICATION_KEY.`,
+		Args: cobra.NoArgs,
+		RunE: runRemoteBuildCommand,
+	}
+)
+
+func init() {
+	remoteBuildCmd.Flags().StringVarP(&remoteSpecFile, "file", "f", "", "Path to the build specification (required)")
+	remoteBuildCmd.Flags().StringVar(&remoteServer, "server", "", "Websocket URL of the build server, wss://host/ws (required)")
+	remoteBuildCmd.Flags().StringVar(&remoteToken, "token", os.Getenv("BX_TOKEN"), "Token of the build server")
+	remoteBuildCmd.Flags().StringVar(&remoteArch, "arch", "", "Architecture of the agent running the build (amd64, arm64)")
+	remoteBuildCmd.Flags().StringToStringVar(&remoteLabels, "label", nil, "Label the agent running the build must have, key=value (repeatable)")
+	remoteBuildCmd.Flags().BoolVar(&remotePull, "pull", false, "Load the artifact of the build in the local docker engine")
+	remoteBuildCmd.MarkFlagRequired("file")
+	remoteBuildCmd.MarkFlagRequired("server")
+	remoteCmd.AddCommand(remoteBuildCmd)
+}
+
+func runRemoteBuildCommand(cmd *cobra.Command, args []string) error {
+	data, err := os.ReadFile(remoteSpecFile)
+	if err != nil {
+		return fmt.Errorf("cannot read the build file specification '%s': %w", remoteSpecFile, err)
+	}
+	// Rejected here rather than after the round trip
+	spec, err := build.LoadBuildSpecFromBytes(data, filepath.Ext(remoteSpecFile))
+	if err != nil {
+		return err
+	}
+	var requirements *socket.BuildRequirements
+	if remoteArch != "" || len(remoteLabels) > 0 {
+		requirements = &socket.BuildRequirements{Arch: remoteArch, Labels: remoteLabels}
+	}
+
+	// The client traces every message with the standard logger, the terminal shows the build only
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := socket.NewClient()
+	client.OnDisconnect(func(err error) {
+		fmt.Fprintf(os.Stderr, "==> Connection lost (%v), reconnecting...\n", err)
+	})
+	headers := http.Header{}
+	if remoteToken != "" {
+		headers.Set("Authorization", "Bearer "+remoteToken)
+	}
+	if err := client.Connect(remoteServer, headers); err != nil {
+		return fmt.Errorf("cannot connect to %s: %w", remoteServer, err)
+	}
+	defer client.Close()
+	client.OnConnect(func() { fmt.Fprintln(os.Stderr, "==> Reconnected") })
+
+	queued, err := client.SubmitBuild(ctx, string(data), requirements)
+	if err != nil {
+		return fmt.Errorf("build of '%s' rejected by %s: %w", spec.Name, remoteServer, err)
+	}
+	fmt.Printf("==> Build %s of %s %s submitted\n", queued.BuildID, spec.Name, spec.Version)
+	printQueued(*queued)
+
+	status, err := client.WatchBuild(ctx, queued.BuildID, printRemoteEvent)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "\nStopped following the build %s, it goes on on the server.\n", queued.BuildID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	elapsed := ""
+	if status.DurationSec != nil {
+		elapsed = " in " + (time.Duration(*status.DurationSec * float64(time.Second))).Round(100*time.Millisecond).String()
+	}
+	if status.Status != "success" {
+		code := status.ErrorCode
+		if code == "" {
+			code = "unknown"
+		}
+		return fmt.Errorf("build %s failed%s (%s): %s", queued.BuildID, elapsed, code, status.Message)
+	}
+	fmt.Printf("==> Build %s succeeded%s\n", queued.BuildID, elapsed)
+	if status.ArtifactRef == "" {
+		return nil
+	}
+	fmt.Printf("Artifact: %s\n", status.ArtifactRef)
+	if remotePull {
+		return pullRemoteArtifact(ctx, status.ArtifactRef)
+	}
+	return nil
+}
+
+// printRemoteEvent writes an event of the followed build to the terminal
+func printRemoteEvent(event socket.Payload) {
+	switch event := event.(type) {
+	case socket.BuildQueuedPayload:
+		printQueued(event)
+	case socket.BuildStatusPayload:
+		if event.Status != "success" && event.Status != "failure" {
+			fmt.Printf("==> %s\n", event.Status)
+		}
+	case socket.BuildProgressPayload:
+		if event.Total > 0 {
+			fmt.Printf("--> [%d/%d] %s %s\n", event.Current, event.Total, event.Phase, event.Service)
+		} else if event.Message != "" {
+			fmt.Printf("--> %s: %s\n", event.Phase, event.Message)
+		}
+	case socket.LogChunkPayload:
+		out := os.Stdout
+		if event.Stream == "stderr" {
+			out = os.Stderr
+		}
+		content := event.Content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		fmt.Fprint(out, content)
+	}
+}
+
+func printQueued(queued socket.BuildQueuedPayload) {
+	if queued.Position == 0 {
+		return
+	}
+	if queued.EstimatedWaitSec > 0 {
+		wait := time.Duration(queued.EstimatedWaitSec * float64(time.Second)).Round(time.Second)
+		fmt.Printf("==> Queued at position %d, about %s before the start\n", queued.Position, wait)
+		return
+	}
+	fmt.Printf("==> Queued at position %d\n", queued.Position)
+}
+
+// pullRemoteArtifact loads the artifact of a remote build, the archives and the images kept on the
+// server cannot be reached
+func pullRemoteArtifact(ctx context.Context, ref string) error {
+	if filepath.IsAbs(ref) || strings.HasPrefix(ref, "sha256:") {
+		return fmt.Errorf("the artifact '%s' is only available on the server, push it to a registry or to B2", ref)
+	}
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+	var b2Config *build.B2Config
+	if strings.HasPrefix(ref, build.B2Scheme) {
+		b2Config = &build.B2Config{
+			AccountID:      os.Getenv("B2_ACCOUNT_ID"),
+			ApplicationKey: os.Getenv("B2_APPLICATION_KEY"),
+		}
+	}
+	tags, err := build.FetchImageArtifact(ctx, docker, b2Config, ref)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %s\n", strings.Join(tags, ", "))
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	outputDir      string
+	outputFileMode string
+	outputDirMode  string
+	outputChown    string
+
+	rootCmd = &cobra.Command{
+		Use:          "bx",
+		Short:        "Build, ship and run the Anexis artifacts.",
+		SilenceUsage: true,
+		// The config file gives the defaults of the flags left unset
+		PersistentPreRunE: loadConfig,
+	}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default ~/.config/bx/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&outputDir, "output-dir", "", "Directory receiving the files written by bx (relative output paths are resolved against it)")
+	rootCmd.PersistentFlags().StringVar(&outputFileMode, "file-mode", "", "Octal mode of the written files (default 0644)")
+	rootCmd.PersistentFlags().StringVar(&outputDirMode, "dir-mode", "", "Octal mode of the created directories (default 0755)")
+	rootCmd.PersistentFlags().StringVar(&outputChown, "chown", "", "Owner of the written files, as uid:gid")
+
+	rootCmd.AddCommand(buildCmd)
+	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(secretsCmd)
+	rootCmd.AddCommand(specCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(pullCmd)
+	rootCmd.AddCommand(remoteCmd)
+	rootCmd.AddCommand(tuiCmd)
+	rootCmd.AddCommand(psCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(downCmd)
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(deployCmd)
+	rootCmd.AddCommand(deploymentsCmd)
+	rootCmd.AddCommand(registryCmd)
+	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(kubeCmd)
+	rootCmd.AddCommand(detectCmd)
+	rootCmd.AddCommand(templateCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(completionCmd)
+}
+
+// Execute runs the bx root command
+func Execute() error {
+	return rootCmd.Execute()
+}
+
+// outputOptions builds the output configuration from the global flags
+func outputOptions() (build.OutputOptions, error) {
+	opts := build.OutputOptions{Dir: outputDir}
+	var err error
+	if opts.FileMode, err = build.ParseFileMode(outputFileMode); err != nil {
+		return opts, err
+	}
+	if opts.DirMode, err = build.ParseFileMode(outputDirMode); err != nil {
+		return opts, err
+	}
+	if opts.Owner, err = build.ParseOwner(outputChown); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
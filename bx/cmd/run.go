@@ -3,30 +3,84 @@ package cmd
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"strings"
-	"time" // Pour docker load
+	"sync"
+	"time"
 
 	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/bx/deploy"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 var (
 	runFile string
 	// servicesToRun []string // Pour exécuter seulement certains services
-	// detach bool            // Pour exécuter en arrière-plan
+	runDetach bool
+	runJSON   bool
+	runQuiet  bool
+	// Variables ajoutées à l'environnement des services
+	runEnvFiles []string
+	runSet      []string
+	runProfiles []string
+	// Ne pas vérifier les ports et les images avant de lancer les services
+	runNoPreflight bool
+
+	// Messages of bx run, on stderr with --json so stdout only holds the report
+	runOut io.Writer = os.Stdout
 
 	runCmd = &cobra.Command{
 		Use:   "run -f <run.yml>",
 		Short: "Lance les services définis dans un fichier .run.yml généré par un build.",
 		Long: `Cette commande lit un fichier .run.yml, interprète les définitions de service
-et lance les conteneurs correspondants en utilisant la commande 'docker run'.
-Elle gère le chargement des images locales si nécessaire.`,
+et lance les conteneurs correspondants avec l'API du moteur Docker, sans la CLI docker.
+Les services démarrent en même temps, chacun après ses dépendances (depends_on) en
+attendant qu'elles soient saines (service_healthy) ou terminées avec succès
+(service_completed_successfully) selon leur condition. Leurs sorties sont affichées
+ensemble, chaque ligne préfixée par son service.
+
+La commande se termine quand les services qui ne sont pas seulement des dépendances se
+sont arrêtés, ou sur Ctrl-C : les conteneurs sont alors arrêtés et supprimés. Elle gère
+le chargement des images locales si nécessaire.
+
+Les services sans réseau rejoignent le réseau bridge du projet (<projet>_default), où les
+autres services les joignent par leur nom. Les volumes nommés déclarés dans le .run.yml
+sont créés s'ils n'existent pas.
+
+Avec --detach (-d), tous les services sont lancés en arrière-plan et continuent de tourner
+après la commande : 'bx ps' les liste, 'bx stop' les arrête et 'bx down' les supprime.
+
+Avec --json, un rapport JSON (projet, conteneur, image et code de sortie de chaque service)
+est écrit sur stdout à la fin, les messages et les sorties des services passent sur stderr.
+--quiet n'affiche que les sorties des services.
+
+--env-file et --set modifient l'environnement des services pour ce lancement, sans changer le
+.run.yml : les variables d'un fichier .env sont ajoutées à tous les services, --set NOM=valeur
+aussi et --set service.NOM=valeur à un seul service. Les fichiers sont appliqués dans l'ordre,
+puis les --set, chacun remplaçant les valeurs précédentes :
+
+  bx run -f app.run.yml --env-file .env.local --set web.PORT=9000
+
+Les services ayant des profils (profiles) ne sont lancés qu'avec --profile pour l'un d'eux,
+"--profile '*'" activant tous les profils ; les services sans profil sont toujours lancés,
+ainsi que les dépendances des services lancés.
+
+Avant de lancer le premier conteneur, la commande vérifie que les ports de l'hôte publiés par
+les services sont libres et que leurs images sont dans le moteur Docker ou dans leur archive,
+et affiche tous les problèmes trouvés ; --no-preflight désactive cette vérification.`,
 		Args: cobra.NoArgs,
 		RunE: runRunCommand,
 	}
@@ -35,7 +89,13 @@ Elle gère le chargement des images locales si nécessaire.`,
 func init() {
 	runCmd.Flags().StringVarP(&runFile, "file", "f", "", "Chemin vers le fichier .run.yml (obligatoire)")
 	// runCmd.Flags().StringSliceVarP(&servicesToRun, "service", "", []string{}, "Spécifier les services à lancer (défaut: tous)")
-	// runCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Lancer les conteneurs en arrière-plan (détaché)")
+	runCmd.Flags().BoolVarP(&runDetach, "detach", "d", false, "Lancer les conteneurs en arrière-plan (détaché)")
+	runCmd.Flags().BoolVar(&runJSON, "json", false, "Écrire le rapport des services en JSON sur stdout")
+	runCmd.Flags().BoolVarP(&runQuiet, "quiet", "q", false, "N'afficher que les sorties des services")
+	runCmd.Flags().StringArrayVar(&runEnvFiles, "env-file", nil, "Fichier .env dont les variables sont ajoutées à tous les services (répétable)")
+	runCmd.Flags().StringSliceVar(&runProfiles, "profile", nil, "Profil dont les services sont aussi lancés (répétable)")
+	runCmd.Flags().BoolVar(&runNoPreflight, "no-preflight", false, "Ne pas vérifier les ports et les images avant le lancement")
+	runCmd.Flags().StringArrayVar(&runSet, "set", nil, "Variable [service.]NOM=valeur ajoutée à l'environnement des services (répétable)")
 	runCmd.MarkFlagRequired("file")
 }
 
@@ -46,144 +106,512 @@ func runRunCommand(cmd *cobra.Command, args []string) error {
 	if _, err := os.Stat(runFile); os.IsNotExist(err) {
 		return fmt.Errorf("le fichier .run.yml '%s' n'existe pas", runFile)
 	}
+	switch {
+	case runQuiet:
+		runOut = io.Discard
+	case runJSON:
+		runOut = os.Stderr
+	}
 
-	// 1. Lire et parser le fichier .run.yml
-	runData, err := os.ReadFile(runFile)
+	// 1. Lire et parser le fichier .run.yml, les secrets écrits à part (secrets_env_file) sont lus depuis son fichier .env
+	runConfig, err := deploy.LoadRunFile(runFile)
 	if err != nil {
-		return fmt.Errorf("erreur lors de la lecture de '%s': %w", runFile, err)
+		return err
 	}
-
-	var runConfig build.RunYAML
-	err = yaml.Unmarshal(runData, &runConfig)
+	if err := applyRunEnvOverrides(runConfig); err != nil {
+		return err
+	}
+	skipped, err := runConfig.SelectProfiles(runProfiles)
 	if err != nil {
-		return fmt.Errorf("erreur lors du parsing YAML de '%s': %w", runFile, err)
+		return err
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(runOut, "Services non lancés, leur profil n'est pas activé: %s\n", strings.Join(skipped, ", "))
 	}
-
 	if len(runConfig.Services) == 0 {
-		fmt.Println("Aucun service défini dans", runFile)
+		fmt.Fprintln(runOut, "Aucun service défini dans", runFile)
+		if runJSON {
+			return printJSON(runReport{Project: runProjectName(runFile), RunFile: runFile, Detached: runDetach, Services: []runServiceReport{}})
+		}
 		return nil
 	}
 
-	fmt.Printf("Lancement des services depuis '%s'...\n", runFile)
-	runFileDir := filepath.Dir(runFile) // Répertoire où se trouve le run.yml (pour les paths relatifs des .tar)
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("impossible de créer le client Docker: %w", err)
+	}
+	defer docker.Close()
+
+	fmt.Fprintf(runOut, "Lancement des services depuis '%s'...\n", runFile)
+	project := runProjectName(runFile)
+	runner := &deploy.Runner{
+		Docker:  docker,
+		Project: project,
+		BaseDir: filepath.Dir(runFile), // Répertoire du run.yml, pour les chemins relatifs des archives
+		Out:     runOut,
+		RunID:   fmt.Sprintf("%s-%d", project, time.Now().UnixNano()),
+		// Un réseau bridge par projet, les services s'y joignent par leur nom comme avec docker compose
+		DefaultNetwork: true,
+	}
+	if !runNoPreflight {
+		problems, err := runner.Preflight(ctx, runConfig)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "Erreur: %s\n", problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problème(s) empêchent le lancement des services, aucun conteneur n'a été lancé", len(problems))
+		}
+	}
+	if err := runner.Prepare(ctx, runConfig); err != nil {
+		return err
+	}
+
+	// 2. Lancer tous les services en même temps, chacun après ses dépendances
+	order, err := deploy.ServiceOrder(runConfig.Services)
+	if err != nil {
+		return err
+	}
+	run := newServiceRun(docker, runner, runConfig, order)
+	err = run.up(ctx)
+	if runJSON {
+		if jsonErr := printJSON(run.report(project, runFile)); jsonErr != nil {
+			return jsonErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if runDetach {
+		fmt.Fprintln(runOut, "Tous les services ont été lancés.")
+		fmt.Fprintf(runOut, "Ils tournent en arrière-plan dans le projet %s: 'bx ps -f %s' pour les lister, 'bx down -f %s' pour les supprimer.\n", project, runFile, runFile)
+	} else {
+		fmt.Fprintln(runOut, "Tous les services sont terminés.")
+	}
+	return nil
+}
+
+// applyRunEnvOverrides sets the variables of --env-file then of --set in the environment of the services
+func applyRunEnvOverrides(runConfig *build.RunYAML) error {
+	var overrides []build.EnvOverride
+	for _, path := range runEnvFiles {
+		fromFile, err := build.ReadEnvOverrides(path)
+		if err != nil {
+			return err
+		}
+		overrides = append(overrides, fromFile...)
+	}
+	for _, value := range runSet {
+		override, err := build.ParseEnvOverride(value)
+		if err != nil {
+			return err
+		}
+		overrides = append(overrides, override)
+	}
+	return runConfig.ApplyEnvOverrides(overrides)
+}
 
-	// 2. Itérer et lancer chaque service
-	// TODO: Gérer l'ordre basé sur depends_on si nécessaire (complexe avec docker run)
-	for serviceName, service := range runConfig.Services {
-		fmt.Printf("--- Lancement du service: %s ---\n", serviceName)
+// Label of the containers started with --detach, the host directory of their secret files removed by bx down
+const runSecretsDirLabel = "io.anexis.secrets-dir"
 
-		// Construire la commande docker run
-		dockerArgs := []string{"run"}
+// withLabel returns a copy of the labels with one more label
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	copied := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
 
-		// Détaché ?
-		// if detach { dockerArgs = append(dockerArgs, "-d") } else {
-		// Pour la simplicité, on ajoute --rm pour nettoyer après arrêt foreground
-		dockerArgs = append(dockerArgs, "--rm")
-		// }
-		// Ajouter -it pour interactivité si pas détaché ? Peut causer problèmes.
-		// dockerArgs = append(dockerArgs, "-it")
+// serviceRun starts the services of a run file at the same time, each one once its dependencies
+// reached their condition, and multiplexes their output with the service names as prefixes
+type serviceRun struct {
+	docker   client.APIClient
+	runner   *deploy.Runner
+	config   *build.RunYAML
+	order    []string
+	detached map[string]bool // Dependencies stopped once the other services have exited
+	states   map[string]*serviceState
+	mux      *logMux
+}
 
-		// Nom du conteneur (basé sur service)
-		containerName := fmt.Sprintf("bx_run_%s_%d", serviceName, time.Now().UnixNano())
-		dockerArgs = append(dockerArgs, "--name", containerName)
+// serviceState tracks the container of a service
+type serviceState struct {
+	started        chan struct{} // Closed once the container is started, or failed to
+	exited         chan struct{} // Closed once the started container has exited
+	id             string
+	image          string // Image run by the container
+	err            error  // Why the service did not start
+	exitErr        error  // Exit status of the container, nil for the code 0
+	exitCode       *int64 // Exit code of the container, nil until it exits
+	cleanupSecrets func()
+}
 
-		// Politique de redémarrage
-		if service.Restart != "" {
-			dockerArgs = append(dockerArgs, "--restart", service.Restart)
+func newServiceRun(docker client.APIClient, runner *deploy.Runner, config *build.RunYAML, order []string) *serviceRun {
+	// The outputs of the services go with the messages with --json
+	logsOut := os.Stdout
+	if runJSON {
+		logsOut = os.Stderr
+	}
+	states := make(map[string]*serviceState, len(order))
+	for _, name := range order {
+		states[name] = &serviceState{started: make(chan struct{}), exited: make(chan struct{}), cleanupSecrets: func() {}}
+	}
+	return &serviceRun{
+		docker:   docker,
+		runner:   runner,
+		config:   config,
+		order:    order,
+		detached: detachedRunServices(config.Services),
+		states:   states,
+		mux:      newLogMux(logsOut, order, colorOutput(logsOut)),
+	}
+}
+
+// up starts the services and, unless they are detached, waits until the services which are not only
+// dependencies have exited. A service failing to start and Ctrl-C stop the run, its containers are
+// then removed like when it ends.
+func (r *serviceRun) up(ctx context.Context) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	for _, name := range r.order {
+		go func() {
+			state := r.states[name]
+			if err := r.start(ctx, name, state); err != nil {
+				state.err = err
+				cancel(err)
+			}
+			close(state.started)
+		}()
+	}
+
+	if runDetach {
+		for _, name := range r.order {
+			<-r.states[name].started
 		}
+		return context.Cause(ctx)
+	}
 
-		// Variables d'environnement
-		for key, val := range service.Environment {
-			dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", key, val))
+	defer r.down()
+	for _, name := range r.order {
+		if r.detached[name] {
+			continue
 		}
+		state := r.states[name]
+		select {
+		case <-state.exited:
+		case <-ctx.Done():
+		}
+	}
+	if err := context.Cause(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(runOut, "Interruption, arrêt des services...")
+			return fmt.Errorf("lancement interrompu")
+		}
+		return err
+	}
 
-		// Ports
-		for _, portMapping := range service.Ports {
-			dockerArgs = append(dockerArgs, "-p", portMapping)
+	var failed []string
+	for _, name := range r.order {
+		if state := r.states[name]; !r.detached[name] && state.exitErr != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", name, state.exitErr))
 		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("services en échec: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
 
-		// Volumes
-		for _, volumeMapping := range service.Volumes {
-			// Attention: Interpréter les chemins relatifs pour les bind mounts
-			parts := strings.SplitN(volumeMapping, ":", 2)
-			if len(parts) == 2 && !filepath.IsAbs(parts[0]) && !strings.Contains(parts[0], "/") {
-				// Probablement un volume nommé, laisser tel quel
-				dockerArgs = append(dockerArgs, "-v", volumeMapping)
-			} else if len(parts) >= 2 && !filepath.IsAbs(parts[0]) {
-				// Chemin hôte relatif -> le rendre absolu par rapport à ?? CWD? run.yml dir?
-				// Soyons prudents, n'autorisons que les chemins absolus ou volumes nommés pour l'instant
-				fmt.Printf("WARN: Le chemin hôte relatif '%s' dans le volume mapping n'est pas supporté. Utilisez un chemin absolu ou un volume nommé.\n", parts[0])
-				// dockerArgs = append(dockerArgs, "-v", volumeMapping) // Ou skipper ?
-			} else {
-				dockerArgs = append(dockerArgs, "-v", volumeMapping) // Volume nommé ou chemin absolu
+// start creates and starts the container of a service once its dependencies are ready
+func (r *serviceRun) start(ctx context.Context, name string, state *serviceState) (err error) {
+	service := r.config.Services[name]
+	for _, dependency := range service.DependsOn {
+		if err := r.waitDependency(ctx, dependency); err != nil {
+			return fmt.Errorf("la dépendance '%s' du service '%s' n'est pas prête: %w", dependency.Service, name, err)
+		}
+	}
+	fmt.Fprintf(runOut, "--- Lancement du service: %s ---\n", name)
+
+	imageRef := service.Image
+	if strings.HasPrefix(imageRef, build.B2Scheme) {
+		fmt.Fprintf(runOut, "Téléchargement de l'image depuis B2: %s\n", imageRef)
+		imageRef, err = fetchB2Image(ctx, r.docker, imageRef)
+		if err != nil {
+			return fmt.Errorf("erreur lors du chargement de l'image du service '%s': %w", name, err)
+		}
+		fmt.Fprintf(runOut, "Image chargée: %s\n", imageRef)
+	} else if imageRef, err = r.runner.ResolveImage(ctx, name, imageRef); err != nil {
+		return err
+	}
+
+	state.image = imageRef
+
+	// Secret files, mounted read-only from a tmpfs and removed once the container stops
+	mounts, cleanupSecrets, err := mountSecretFiles(name, service.SecretFiles)
+	if err != nil {
+		return err
+	}
+	state.cleanupSecrets = cleanupSecrets
+	if runDetach && len(mounts) > 0 {
+		// Les fichiers restent sur l'hôte tant que le conteneur existe, 'bx down' les supprime
+		service.Labels = withLabel(service.Labels, runSecretsDirLabel, filepath.Dir(mounts[0].Source))
+	}
+	state.id, err = r.runner.CreateService(ctx, name, service, imageRef, mounts...)
+	if err != nil {
+		return fmt.Errorf("le conteneur du service '%s' n'a pas pu être créé: %w", name, err)
+	}
+
+	copied := make(chan struct{})
+	if runDetach {
+		close(copied)
+	} else {
+		attach, err := r.docker.ContainerAttach(ctx, state.id, container.AttachOptions{Stream: true, Stdout: true, Stderr: true})
+		if err != nil {
+			return fmt.Errorf("impossible de s'attacher au conteneur de '%s': %w", name, err)
+		}
+		go func() {
+			defer close(copied)
+			defer attach.Close()
+			out := r.mux.Writer(name)
+			defer out.Close()
+			stdcopy.StdCopy(out, out, attach.Reader)
+		}()
+	}
+
+	// Waiting before the start so a container exiting at once is not missed
+	waitCh, errCh := r.docker.ContainerWait(ctx, state.id, container.WaitConditionNextExit)
+	if err := r.docker.ContainerStart(ctx, state.id, container.StartOptions{}); err != nil {
+		return fmt.Errorf("le service '%s' n'a pas démarré: %w", name, err)
+	}
+	fmt.Fprintf(runOut, "--- Service '%s' démarré (conteneur %s) ---\n", name, shortContainerID(state.id))
+
+	go func() {
+		defer close(state.exited)
+		select {
+		case result := <-waitCh:
+			if result.Error != nil {
+				state.exitErr = fmt.Errorf("impossible d'attendre le conteneur: %s", result.Error.Message)
+				break
 			}
+			state.exitCode = &result.StatusCode
+			if result.StatusCode != 0 {
+				state.exitErr = fmt.Errorf("le conteneur s'est arrêté avec le code %d", result.StatusCode)
+			}
+		case err := <-errCh:
+			state.exitErr = fmt.Errorf("impossible d'attendre le conteneur: %w", err)
+		}
+		// The last lines may still be in flight, the stream ends with the container
+		select {
+		case <-copied:
+		case <-time.After(time.Second):
 		}
+		if runDetach || ctx.Err() != nil {
+			return
+		}
+		if state.exitErr != nil {
+			fmt.Fprintf(runOut, "--- Service '%s' en échec: %v ---\n", name, state.exitErr)
+		} else {
+			fmt.Fprintf(runOut, "--- Service '%s' terminé ---\n", name)
+		}
+	}()
+	return nil
+}
+
+// runReport is the report of bx run --json
+type runReport struct {
+	Project  string             `json:"project"`
+	RunFile  string             `json:"run_file"`
+	Detached bool               `json:"detached"`
+	Services []runServiceReport `json:"services"`
+}
+
+type runServiceReport struct {
+	Name        string `json:"name"`
+	ContainerID string `json:"container_id,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ExitCode    *int64 `json:"exit_code,omitempty"` // Set once the container has exited
+	Error       string `json:"error,omitempty"`     // Why the service did not start, or failed
+}
 
-		// Image
-		imageRef := service.Image
-		if strings.HasSuffix(imageRef, ".tar") {
-			// Assumer que c'est un fichier .tar local relatif au .run.yml
-			tarPath := imageRef
-			if !filepath.IsAbs(tarPath) {
-				tarPath = filepath.Join(runFileDir, tarPath)
+// report returns the state of the services once up returned
+func (r *serviceRun) report(project, runFile string) runReport {
+	report := runReport{Project: project, RunFile: runFile, Detached: runDetach, Services: []runServiceReport{}}
+	for _, name := range r.order {
+		state := r.states[name]
+		service := runServiceReport{Name: name}
+		select {
+		case <-state.started:
+			service.ContainerID, service.Image = state.id, state.image
+			if state.err != nil {
+				service.Error = state.err.Error()
 			}
-			fmt.Printf("Chargement de l'image depuis l'archive locale: %s\n", tarPath)
-			if _, err := os.Stat(tarPath); os.IsNotExist(err) {
-				return fmt.Errorf("l'archive image '%s' pour le service '%s' n'existe pas", tarPath, serviceName)
+		default: // Still waiting for its dependencies when the run stopped
+		}
+		select {
+		case <-state.exited:
+			service.ExitCode = state.exitCode
+			if state.exitErr != nil {
+				service.Error = state.exitErr.Error()
 			}
+		default:
+		}
+		report.Services = append(report.Services, service)
+	}
+	return report
+}
 
-			loadCmd := exec.Command("docker", "load", "-i", tarPath)
-			loadCmd.Stdout = os.Stdout
-			loadCmd.Stderr = os.Stderr
-			if err := loadCmd.Run(); err != nil {
-				return fmt.Errorf("erreur lors du chargement de l'image depuis '%s': %w", tarPath, err)
+// waitDependency waits until a dependency is started and reached its condition
+func (r *serviceRun) waitDependency(ctx context.Context, dependency build.Dependency) error {
+	state := r.states[dependency.Service]
+	select {
+	case <-state.started:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	if state.err != nil {
+		return fmt.Errorf("le service n'a pas démarré")
+	}
+
+	switch dependency.Condition {
+	case build.ConditionServiceHealthy:
+		fmt.Fprintf(runOut, "Attente de '%s' (%s)...\n", dependency.Service, dependency.Condition)
+		return deploy.WaitForCondition(ctx, r.docker, state.id, dependency.Condition, deploy.DefaultDependencyTimeout)
+	case build.ConditionServiceCompletedSuccessfully:
+		fmt.Fprintf(runOut, "Attente de '%s' (%s)...\n", dependency.Service, dependency.Condition)
+		select {
+		case <-state.exited:
+			return state.exitErr
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(deploy.DefaultDependencyTimeout):
+			return fmt.Errorf("délai dépassé après %s", deploy.DefaultDependencyTimeout)
+		}
+	}
+	return nil
+}
+
+// down stops and removes the containers of the run with the secret files of their services,
+// once the services being started gave up
+func (r *serviceRun) down() {
+	var wg sync.WaitGroup
+	for _, name := range r.order {
+		state := r.states[name]
+		<-state.started
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if state.id != "" {
+				select {
+				case <-state.exited:
+				default:
+					fmt.Fprintf(runOut, "Arrêt du service %s\n", name)
+				}
+				removeRunContainer(r.docker, state.id)
 			}
-			// Comment obtenir le tag/ID chargé ? docker load l'affiche. C'est compliqué.
-			// On suppose que le tar contient une image tagguée de manière prévisible.
-			// => Il FAUT que le build.go (lorsqu'il sauve en local) taggue l'image avant de la sauver.
-			// => Le run.yml doit référencer ce TAG, pas le .tar.
-			// ---> REVISION NECESSAIRE de la génération du run.yml pour storage "local" !
-			// Pour l'instant, on va supposer que le .tar contient l'image service.Image (sans le .tar)
-			// Ceci est une GROSSE supposition.
-			imageRef = strings.TrimSuffix(service.Image, ".tar") // Suppose que le tag est le nom du fichier sans .tar
-			fmt.Printf("Supposition : l'image chargée devrait être tagguée comme '%s'\n", imageRef)
-
-		} else if strings.HasPrefix(imageRef, "local:") {
-			// Gérer l'autre cas de fallback de getImageRefForRun
-			return fmt.Errorf("référence d'image locale non trouvée '%s' pour le service '%s'", imageRef, serviceName)
-		}
-		dockerArgs = append(dockerArgs, imageRef) // Ajouter l'image (tag ou ID)
-
-		// Entrypoint / Command
-		if len(service.Entrypoint) > 0 {
-			dockerArgs = append(dockerArgs, "--entrypoint", service.Entrypoint[0]) // docker run prend seulement le premier
-			// Ajouter les arguments d'entrypoint après l'image
-			//dockerArgs = append(dockerArgs, service.Entrypoint[1:]...) // Non, ça c'est la commande
-		}
-		if len(service.Command) > 0 {
-			// La commande vient après l'image (et après les args d'entrypoint s'il y en a)
-			dockerArgs = append(dockerArgs, service.Command...)
-		}
-
-		// Exécuter la commande docker run
-		fmt.Printf("Exécution: docker %s\n", strings.Join(dockerArgs, " "))
-		runCmd := exec.CommandContext(context.Background(), "docker", dockerArgs...) // Utiliser un contexte ?
-		runCmd.Stdout = os.Stdout
-		runCmd.Stderr = os.Stderr
-		// runCmd.Stdin = os.Stdin // Pour interactivité ?
-
-		err = runCmd.Run() // Bloque jusqu'à la fin du conteneur (car pas -d)
+			state.cleanupSecrets()
+		}()
+	}
+	wg.Wait()
+}
+
+// Delay given to the containers to stop on SIGTERM before they are killed
+const runStopTimeout = 10
+
+// removeRunContainer stops a container and removes it with its anonymous volumes, like `docker run --rm`.
+// It ignores the cancellation of the run so the containers are cleaned up after an interruption.
+func removeRunContainer(docker client.ContainerAPIClient, containerID string) {
+	ctx := context.Background()
+	timeout := runStopTimeout
+	if err := docker.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &timeout}); err != nil && !errdefs.IsNotFound(err) {
+		fmt.Fprintf(os.Stderr, "WARN: impossible d'arrêter le conteneur %s: %v\n", shortContainerID(containerID), err)
+	}
+	if err := docker.ContainerRemove(ctx, containerID, container.RemoveOptions{RemoveVolumes: true, Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		fmt.Fprintf(os.Stderr, "WARN: impossible de supprimer le conteneur %s: %v\n", shortContainerID(containerID), err)
+	}
+}
+
+// detachedRunServices returns the services some others depend on, except those only awaited until
+// they complete: they run until the other services have exited, then they are stopped
+func detachedRunServices(services map[string]build.RunService) map[string]bool {
+	detached := make(map[string]bool)
+	for _, service := range services {
+		for _, dependency := range service.DependsOn {
+			if dependency.Condition != build.ConditionServiceCompletedSuccessfully {
+				detached[dependency.Service] = true
+			}
+		}
+	}
+	return detached
+}
+
+// Directory backed by a tmpfs on Linux, the secret files never reach the disk
+const secretFilesTmpfs = "/dev/shm"
+
+// mountSecretFiles writes the secret files of a service in a private tmpfs directory and returns
+// the read-only mounts of the container, with the function removing them
+func mountSecretFiles(serviceName string, files []build.RunSecretFile) ([]mount.Mount, func(), error) {
+	if len(files) == 0 {
+		return nil, func() {}, nil
+	}
+	base := secretFilesTmpfs
+	if info, err := os.Stat(base); err != nil || !info.IsDir() {
+		base = os.TempDir()
+		fmt.Fprintf(os.Stderr, "WARN: %s n'est pas disponible, les fichiers secrets de '%s' sont écrits dans %s.\n", secretFilesTmpfs, serviceName, base)
+	}
+	dir, err := os.MkdirTemp(base, "bx-secrets-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("impossible de créer le répertoire des fichiers secrets de '%s': %w", serviceName, err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	var mounts []mount.Mount
+	for i, file := range files {
+		if !path.IsAbs(file.Target) {
+			cleanup()
+			return nil, nil, fmt.Errorf("la cible '%s' du secret '%s' doit être un chemin absolu", file.Target, file.Name)
+		}
+		content, err := base64.StdEncoding.DecodeString(file.Content)
 		if err != nil {
-			// Si le conteneur s'arrête avec un code non-nul, Run() retourne une erreur
-			fmt.Printf("Erreur lors de l'exécution du service '%s': %v\n", serviceName, err)
-			// Faut-il arrêter les autres services ? Pour l'instant, on continue.
-			// return fmt.Errorf("le service '%s' a échoué: %w", serviceName, err) // Arrêter tout
-		} else {
-			fmt.Printf("--- Service '%s' terminé ---\n", serviceName)
+			cleanup()
+			return nil, nil, fmt.Errorf("contenu invalide pour le fichier secret '%s': %w", file.Name, err)
+		}
+		hostPath := filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(file.Target)))
+		// Readable by the container users, the directory itself stays private to the host user
+		if err := os.WriteFile(hostPath, content, 0444); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("impossible d'écrire le fichier secret '%s': %w", file.Name, err)
 		}
-		fmt.Println() // Ligne vide entre les services
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: hostPath, Target: file.Target, ReadOnly: true})
 	}
+	return mounts, cleanup, nil
+}
 
-	fmt.Println("Tous les services ont été lancés.")
-	return nil
-}
\ No newline at end of file
+// runProjectName returns the prefix of the networks and volumes created for a run file ("app" for app.run.yml)
+func runProjectName(runFile string) string {
+	name := strings.TrimSuffix(filepath.Base(runFile), filepath.Ext(runFile))
+	return "bx_" + strings.TrimSuffix(name, ".run")
+}
+
+// fetchB2Image charge une archive b2://<bucket>/<objet> dans le docker local et retourne son tag.
+// Les identifiants B2 sont lus dans B2_ACCOUNT_ID et B2_APPLICATION_KEY.
+func fetchB2Image(ctx context.Context, docker client.ImageAPIClient, ref string) (string, error) {
+	tags, err := build.FetchImageArtifact(ctx, docker, &build.B2Config{
+		AccountID:      os.Getenv("B2_ACCOUNT_ID"),
+		ApplicationKey: os.Getenv("B2_APPLICATION_KEY"),
+	}, ref)
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("aucune image chargée depuis '%s'", ref)
+	}
+	return tags[0], nil
+}
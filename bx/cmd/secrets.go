@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretsCheckFile string
+	secretsCheckJSON bool
+
+	secretsCmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "Inspect the secrets of a build specification.",
+	}
+
+	secretsCheckCmd = &cobra.Command{
+		Use:   "check -f <spec.yml>",
+		Short: "Resolve the secrets of a build specification without printing them.",
+		Long: `Check fetches every secret of a specification from its source, as bx build does, and reports
+the ones which would fail the build: the providers not configured or not reachable, the missing
+secrets and the key paths not found in the JSON secrets. Only the size of the values is printed.
+
+The "aws-sm://" and "ssm://" sources use the default AWS credential chain. The command exits with
+an error if a secret cannot be resolved.`,
+		Args: cobra.NoArgs,
+		RunE: runSecretsCheckCommand,
+	}
+)
+
+func init() {
+	secretsCheckCmd.Flags().StringVarP(&secretsCheckFile, "file", "f", "", "Path to the build specification (required)")
+	secretsCheckCmd.Flags().BoolVar(&secretsCheckJSON, "json", false, "Print the checks as JSON")
+	secretsCheckCmd.MarkFlagRequired("file")
+	secretsCmd.AddCommand(secretsCheckCmd)
+}
+
+func runSecretsCheckCommand(cmd *cobra.Command, args []string) error {
+	spec, err := build.LoadBuildSpecFromFile(secretsCheckFile)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fetcher, err := buildSecretFetcher(ctx, spec)
+	if err != nil {
+		return err
+	}
+	service, err := build.NewBuildService("", false, fetcher)
+	if err != nil {
+		return fmt.Errorf("cannot create the build service: %w", err)
+	}
+	checks := service.CheckSecrets(ctx, spec)
+
+	failed := 0
+	for _, check := range checks {
+		if check.Error != "" {
+			failed++
+		}
+	}
+	if secretsCheckJSON {
+		if err := printJSON(checks); err != nil {
+			return err
+		}
+	} else {
+		printSecretChecks(checks)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d secrets of '%s' cannot be resolved", failed, len(checks), spec.Name)
+	}
+	return nil
+}
+
+// printSecretChecks writes a row by secret, the failures and the warnings after the table
+func printSecretChecks(checks []build.SecretCheck) {
+	if len(checks) == 0 {
+		fmt.Println("No secrets.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tINJECT\tSTATUS")
+	for _, check := range checks {
+		source := check.Source
+		if check.Key != "" {
+			source += " (" + check.Key + ")"
+		}
+		status := fmt.Sprintf("ok, %d bytes", check.Size)
+		if check.Error != "" {
+			status = "FAILED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Name, source, check.InjectMethod, status)
+	}
+	w.Flush()
+	for _, check := range checks {
+		if check.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", check.Name, check.Error)
+		}
+		if check.Warning != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", check.Name, check.Warning)
+		}
+	}
+}
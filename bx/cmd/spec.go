@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	specGraphFile string
+	specGraphDOT  bool
+	specGraphJSON bool
+
+	specCmd = &cobra.Command{
+		Use:   "spec",
+		Short: "Inspect a build specification.",
+	}
+
+	specGraphCmd = &cobra.Command{
+		Use:   "graph -f <spec.yml>",
+		Short: "Print the dependency graph of a build specification.",
+		Long: `Graph prints how the parts of a specification depend on each other, without building it:
+the codebases and the resources feeding the build steps, the binaries passed from a step to the
+next, the codebases holding the build context of the image or of each compose service, and the
+depends_on of the services.
+
+The compose file is read from its codebase when the codebase is local, relative to the current
+directory; the services of a compose file in a git or archive codebase are not shown.
+
+The graph is written as a tree, the nodes reached again marked "(see above)". With --dot it is
+written in the Graphviz language:
+
+  bx spec graph -f spec.yml --dot | dot -Tsvg > graph.svg`,
+		Args: cobra.NoArgs,
+		RunE: runSpecGraphCommand,
+	}
+)
+
+func init() {
+	specGraphCmd.Flags().StringVarP(&specGraphFile, "file", "f", "", "Path to the build specification (required)")
+	specGraphCmd.Flags().BoolVar(&specGraphDOT, "dot", false, "Print the graph in the Graphviz DOT language")
+	specGraphCmd.Flags().BoolVar(&specGraphJSON, "json", false, "Print the nodes and the edges as JSON")
+	specGraphCmd.MarkFlagsMutuallyExclusive("dot", "json")
+	specGraphCmd.MarkFlagRequired("file")
+	specCmd.AddCommand(specGraphCmd)
+}
+
+func runSpecGraphCommand(cmd *cobra.Command, args []string) error {
+	spec, err := build.LoadBuildSpecFromFile(specGraphFile)
+	if err != nil {
+		return err
+	}
+	graph := build.NewSpecGraph(spec, "")
+	switch {
+	case specGraphJSON:
+		return printJSON(graph)
+	case specGraphDOT:
+		fmt.Print(graph.DOT())
+	default:
+		fmt.Print(graph.Tree())
+	}
+	for _, warning := range graph.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	stopRunFile string
+	stopProject string
+	stopTimeout int
+
+	stopCmd = &cobra.Command{
+		Use:   "stop (-f <run.yml> | -p <project>) [service...]",
+		Short: "Stop the containers started by bx run -d.",
+		Long: `Stop stops the running containers of a project started with 'bx run -d', only those of
+the given services if any. The containers are kept, 'bx down' removes them.`,
+		RunE: runStopCommand,
+	}
+)
+
+func init() {
+	stopCmd.Flags().StringVarP(&stopRunFile, "file", "f", "", "Stop the containers of this .run.yml")
+	stopCmd.Flags().StringVarP(&stopProject, "project", "p", "", "Stop the containers of this project")
+	stopCmd.Flags().IntVarP(&stopTimeout, "timeout", "t", runStopTimeout, "Seconds to wait for the containers to stop before killing them")
+	stopCmd.MarkFlagsMutuallyExclusive("file", "project")
+}
+
+func runStopCommand(cmd *cobra.Command, args []string) error {
+	project, err := requiredProject(stopRunFile, stopProject)
+	if err != nil {
+		return err
+	}
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	stopped, err := deploy.StopProject(cmd.Context(), docker, project, args, &stopTimeout)
+	if len(stopped) > 0 {
+		fmt.Printf("Stopped in %s: %s\n", project, strings.Join(stopped, ", "))
+	} else if err == nil {
+		fmt.Printf("No running container in %s.\n", project)
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	templateListJSON bool
+	templateSet      []string
+
+	templateCmd = &cobra.Command{
+		Use:   "template",
+		Short: "Use the Dockerfile templates outside of a build.",
+	}
+
+	templateListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the Dockerfile templates and their default values.",
+		Args:  cobra.NoArgs,
+		RunE:  runTemplateListCommand,
+	}
+
+	templateRenderCmd = &cobra.Command{
+		Use:   "render <template>",
+		Short: "Print a Dockerfile rendered from a template.",
+		Long: `Render prints the Dockerfile of a template, as the builds generate it for a codebase without
+Dockerfile but without detecting anything: the values not given with --set are the defaults of the
+template (see bx template list).
+
+The template is named by its key ("Go-go", "Django"), or by its language ("go") or its package
+manager ("pnpm") when a single template has it. --set takes the fields of the dockerfile_template
+of a spec, "version" and "binary" being short for language_version and binary_name:
+
+  bx template render go --set version=1.22 --set main_package=./cmd/api > Dockerfile
+  bx template render npm --set start_command='["node", "server.js"]'`,
+		Args: cobra.ExactArgs(1),
+		RunE: runTemplateRenderCommand,
+	}
+)
+
+func init() {
+	templateListCmd.Flags().BoolVar(&templateListJSON, "json", false, "Print the templates and their defaults as JSON")
+	templateRenderCmd.Flags().StringArrayVar(&templateSet, "set", nil, "Value of the template, key=value (repeatable)")
+	templateCmd.AddCommand(templateListCmd)
+	templateCmd.AddCommand(templateRenderCmd)
+}
+
+func runTemplateListCommand(cmd *cobra.Command, args []string) error {
+	keys := build.DockerfileTemplateKeys()
+	if templateListJSON {
+		templates := make(map[string]build.DockerfileTemplateData, len(keys))
+		for _, key := range keys {
+			templates[key] = build.DockerfileTemplateDefaults(key)
+		}
+		return printJSON(templates)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tVERSION\tPORT\tBINARY/ENTRYPOINT")
+	for _, key := range keys {
+		defaults := build.DockerfileTemplateDefaults(key)
+		port := "-"
+		if defaults.Port != 0 {
+			port = fmt.Sprint(defaults.Port)
+		}
+		program := defaults.BinaryName
+		if program == "" {
+			program = defaults.Entrypoint
+		}
+		if program == "" {
+			program = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, defaults.LanguageVersion, port, program)
+	}
+	return w.Flush()
+}
+
+func runTemplateRenderCommand(cmd *cobra.Command, args []string) error {
+	key, err := build.FindDockerfileTemplate(args[0])
+	if err != nil {
+		return err
+	}
+	var overrides build.DockerfileTemplateData
+	for _, value := range templateSet {
+		name, value, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("invalid --set '%s', expected key=value", name)
+		}
+		if err := overrides.Set(strings.TrimSpace(name), value); err != nil {
+			return err
+		}
+	}
+	content, err := build.RenderDockerfileTemplate(key, &overrides)
+	if err != nil {
+		return err
+	}
+	fmt.Print(strings.TrimLeft(content, "\n"))
+	return nil
+}
@@ -0,0 +1,730 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/socket"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tuiSpecFiles []string
+	tuiWorkDir   string
+	tuiWorkers   int
+	tuiServer    string
+	tuiToken     string
+
+	tuiCmd = &cobra.Command{
+		Use:   "tui [-f <spec.yml>...] [--server <wss://...>]",
+		Short: "Follow the queued and running builds in an interactive terminal view.",
+		Long: `Tui shows the builds in a terminal view refreshed live: their state and their position in
+the queue, the progress of their phases and the logs of the selected build.
+
+Without --server the specifications given with -f are built with the local docker engine, --workers
+of them at the same time. With --server the view follows the builds of a build server, the
+specifications given with -f being submitted to it. The token of the server is read from --token
+or BX_TOKEN.
+
+Keys:
+  up/down, k/j    select a build
+  c               cancel the selected build, queued or running
+  r               run the specification of the selected build again
+  pgup/pgdown     scroll the logs, end to follow them again
+  q, ctrl+c       quit
+
+Only the builds submitted from the view can be run again, their specification being read again.
+Quitting the local view cancels its builds, the builds of a server go on.`,
+		Args: cobra.NoArgs,
+		RunE: runTUICommand,
+	}
+)
+
+func init() {
+	tuiCmd.Flags().StringArrayVarP(&tuiSpecFiles, "file", "f", nil, "Build specification to build (repeatable)")
+	tuiCmd.Flags().StringVar(&tuiWorkDir, "work-dir", "", "Working directory of the local builds (a temporary directory removed at the end by default)")
+	tuiCmd.Flags().IntVar(&tuiWorkers, "workers", 1, "Local builds run at the same time")
+	tuiCmd.Flags().StringVar(&tuiServer, "server", "", "Websocket URL of the build server to follow, wss://host/ws")
+	tuiCmd.Flags().StringVar(&tuiToken, "token", os.Getenv("BX_TOKEN"), "Token of the build server")
+}
+
+func runTUICommand(cmd *cobra.Command, args []string) error {
+	if tuiServer == "" && len(tuiSpecFiles) == 0 {
+		return fmt.Errorf("nothing to follow: give build specifications with -f or a build server with --server")
+	}
+	if tuiWorkers < 1 {
+		return fmt.Errorf("invalid --workers %d, at least one build must run", tuiWorkers)
+	}
+	// Rejected before the view takes the terminal
+	for _, file := range tuiSpecFiles {
+		if _, err := build.LoadBuildSpecFromFile(file); err != nil {
+			return err
+		}
+	}
+	// The client and the build service trace to the standard loggers, the view owns the terminal
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	var source tuiSource
+	if tuiServer != "" {
+		client := socket.NewClient()
+		headers := http.Header{}
+		if tuiToken != "" {
+			headers.Set("Authorization", "Bearer "+tuiToken)
+		}
+		if err := client.Connect(tuiServer, headers); err != nil {
+			return fmt.Errorf("cannot connect to %s: %w", tuiServer, err)
+		}
+		defer client.Close()
+		source = newRemoteTUISource(client)
+	} else {
+		service, err := build.NewBuildService(tuiWorkDir, tuiWorkDir == "", nil)
+		if err != nil {
+			return fmt.Errorf("cannot create the build service: %w", err)
+		}
+		if tuiWorkDir == "" {
+			defer service.Cleanup()
+		}
+		service.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		service.SetQueueWorkers(tuiWorkers)
+		defer service.StopQueue()
+		source = newLocalTUISource(service)
+	}
+
+	program := tea.NewProgram(newTUIModel(source), tea.WithAltScreen(), tea.WithContext(ctx))
+	go source.run(ctx, tuiSpecFiles, program.Send)
+	_, err := program.Run()
+	if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// tuiSource feeds the view with the builds and runs its actions
+type tuiSource interface {
+	// run submits the specification files and sends the builds and their events to the view until ctx is done
+	run(ctx context.Context, files []string, send func(tea.Msg))
+	cancel(buildID string) error
+	// rerun submits again the specification of a build and returns the ID of the new build
+	rerun(buildID string) (string, error)
+}
+
+// Messages sent to the view
+type (
+	// tuiBuildsMsg lists the known builds, newest submission first
+	tuiBuildsMsg []tuiBuildInfo
+
+	// tuiProgressMsg reports the start, the progress or the end of a phase of a build
+	tuiProgressMsg struct {
+		BuildID  string
+		Phase    string
+		Current  int
+		Total    int
+		Finished bool
+		Failed   bool
+		Duration float64
+	}
+
+	tuiLogMsg struct {
+		BuildID string
+		Lines   []string
+	}
+
+	// tuiActionMsg is the result of an action of the user or of the source, shown in the status line
+	tuiActionMsg struct {
+		Message string
+		Err     error
+	}
+)
+
+type tuiBuildInfo struct {
+	ID       string
+	Name     string
+	Version  string
+	State    string
+	Position int
+	Error    string
+}
+
+// tuiFiles keeps the specification file of the builds submitted from the view, to run them again
+type tuiFiles struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (f *tuiFiles) add(buildID, file string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files == nil {
+		f.files = make(map[string]string)
+	}
+	f.files[buildID] = file
+}
+
+func (f *tuiFiles) get(buildID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[buildID]
+	if !ok {
+		return "", fmt.Errorf("the build %s was not submitted from this view, it cannot be run again", buildID)
+	}
+	return file, nil
+}
+
+// submitTUIFiles submits the specification files given on the command line, the failures are shown in the status line
+func submitTUIFiles(files []string, submit func(file string) (string, error), send func(tea.Msg)) {
+	for _, file := range files {
+		if _, err := submit(file); err != nil {
+			send(tuiActionMsg{Err: fmt.Errorf("cannot submit '%s': %w", file, err)})
+		}
+	}
+}
+
+// pollTUIBuilds sends the builds to the view every interval until ctx is done
+func pollTUIBuilds(ctx context.Context, interval time.Duration, list func() (tuiBuildsMsg, error), send func(tea.Msg)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		builds, err := list()
+		if err != nil {
+			send(tuiActionMsg{Err: err})
+		} else {
+			send(builds)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// localTUISource runs the builds with a local build service
+type localTUISource struct {
+	service *build.BuildService
+	files   tuiFiles
+	send    func(tea.Msg)
+}
+
+func newLocalTUISource(service *build.BuildService) *localTUISource {
+	return &localTUISource{service: service}
+}
+
+func (s *localTUISource) run(ctx context.Context, files []string, send func(tea.Msg)) {
+	s.send = send
+	submitTUIFiles(files, s.submit, send)
+	pollTUIBuilds(ctx, 500*time.Millisecond, func() (tuiBuildsMsg, error) {
+		statuses := s.service.ListBuilds()
+		builds := make(tuiBuildsMsg, 0, len(statuses))
+		// ListBuilds returns the oldest submission first, the view lists the newest first
+		for i := len(statuses) - 1; i >= 0; i-- {
+			status := statuses[i]
+			builds = append(builds, tuiBuildInfo{
+				ID:       status.BuildID,
+				Name:     status.Name,
+				Version:  status.Version,
+				State:    string(status.State),
+				Position: status.Position,
+				Error:    status.Error,
+			})
+		}
+		return builds, nil
+	}, send)
+}
+
+func (s *localTUISource) submit(file string) (string, error) {
+	spec, err := build.LoadBuildSpecFromFile(file)
+	if err != nil {
+		return "", err
+	}
+	addConfigRegistries(spec)
+	events := make(chan build.BuildEvent, 64)
+	buildID, err := s.service.SubmitWithEvents(spec, 0, events)
+	if err != nil {
+		return "", err
+	}
+	s.files.add(buildID, file)
+	go func() {
+		for event := range events {
+			if msg, ok := tuiEventMsg(buildID, event); ok {
+				s.send(msg)
+			}
+		}
+	}()
+	return buildID, nil
+}
+
+func (s *localTUISource) cancel(buildID string) error {
+	_, err := s.service.CancelBuild(buildID)
+	return err
+}
+
+func (s *localTUISource) rerun(buildID string) (string, error) {
+	file, err := s.files.get(buildID)
+	if err != nil {
+		return "", err
+	}
+	return s.submit(file)
+}
+
+// tuiEventMsg converts an event of a local build to a message of the view
+func tuiEventMsg(buildID string, event build.BuildEvent) (tea.Msg, bool) {
+	switch event.Type {
+	case build.EventPhaseStarted:
+		return tuiProgressMsg{BuildID: buildID, Phase: event.Phase}, true
+	case build.EventProgress:
+		return tuiProgressMsg{BuildID: buildID, Phase: event.Phase, Current: event.Current, Total: event.Total}, true
+	case build.EventPhaseFinished:
+		return tuiProgressMsg{BuildID: buildID, Phase: event.Phase, Finished: true, Failed: event.Error != "", Duration: event.Duration}, true
+	case build.EventLog:
+		if event.Service != "" {
+			return tuiLogMsg{BuildID: buildID, Lines: []string{fmt.Sprintf("[%s] %s", event.Service, event.Message)}}, true
+		}
+		return tuiLogMsg{BuildID: buildID, Lines: []string{event.Message}}, true
+	case build.EventWarning:
+		return tuiLogMsg{BuildID: buildID, Lines: []string{"Warning: " + event.Message}}, true
+	case build.EventArtifact:
+		return tuiLogMsg{BuildID: buildID, Lines: []string{fmt.Sprintf("==> %s %s: %s", event.Artifact, event.Service, event.Ref)}}, true
+	}
+	return nil, false
+}
+
+// remoteTUISource follows the builds of a build server, attaching to the running ones
+type remoteTUISource struct {
+	client *socket.Client
+	files  tuiFiles
+	ctx    context.Context
+
+	mu       sync.Mutex
+	attached map[string]bool
+}
+
+func newRemoteTUISource(client *socket.Client) *remoteTUISource {
+	return &remoteTUISource{client: client, attached: make(map[string]bool)}
+}
+
+func (s *remoteTUISource) run(ctx context.Context, files []string, send func(tea.Msg)) {
+	s.ctx = ctx
+	go s.readEvents(ctx, send)
+	submitTUIFiles(files, s.submit, send)
+	pollTUIBuilds(ctx, time.Second, s.list, send)
+}
+
+func (s *remoteTUISource) list() (tuiBuildsMsg, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+	resp, err := s.client.SendRequest(ctx, socket.EvtBuildList, socket.BuildListPayload{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the builds: %w", err)
+	}
+	list, err := socket.Decode[socket.BuildListResponsePayload](resp)
+	if err != nil {
+		return nil, err
+	}
+	builds := make(tuiBuildsMsg, 0, len(list.Builds))
+	var attach []string
+	s.mu.Lock()
+	for _, info := range list.Builds {
+		builds = append(builds, tuiBuildInfo{
+			ID:       info.BuildID,
+			Name:     info.Name,
+			Version:  info.Version,
+			State:    info.State,
+			Position: info.Position,
+			Error:    info.Error,
+		})
+		if info.State == string(build.BuildStateRunning) && !s.attached[info.BuildID] {
+			s.attached[info.BuildID] = true
+			attach = append(attach, info.BuildID)
+		}
+	}
+	s.mu.Unlock()
+	// The logs of the running builds are replayed from their start
+	if len(attach) > 0 {
+		if _, err := s.client.Attach(ctx, attach...); err != nil {
+			return nil, fmt.Errorf("cannot attach to the running builds: %w", err)
+		}
+	}
+	return builds, nil
+}
+
+// readEvents sends the progress and the logs of the attached builds to the view
+func (s *remoteTUISource) readEvents(ctx context.Context, send func(tea.Msg)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-s.client.Incoming:
+			event, err := msg.Event()
+			if err != nil {
+				continue
+			}
+			switch event := event.(type) {
+			case socket.BuildProgressPayload:
+				send(tuiProgressMsg{BuildID: event.BuildID, Phase: event.Phase, Current: event.Current, Total: event.Total})
+			case socket.LogChunkPayload:
+				send(tuiLogMsg{BuildID: event.BuildID, Lines: strings.Split(strings.TrimSuffix(event.Content, "\n"), "\n")})
+			case socket.BuildStatusPayload:
+				send(tuiLogMsg{BuildID: event.BuildID, Lines: []string{"==> " + event.Status}})
+			}
+		}
+	}
+}
+
+func (s *remoteTUISource) submit(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the build file specification '%s': %w", file, err)
+	}
+	if _, err := build.LoadBuildSpecFromBytes(data, filepath.Ext(file)); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+	queued, err := s.client.SubmitBuild(ctx, string(data), nil)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.attached[queued.BuildID] = true
+	s.mu.Unlock()
+	s.files.add(queued.BuildID, file)
+	return queued.BuildID, nil
+}
+
+func (s *remoteTUISource) cancel(buildID string) error {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+	_, err := s.client.CancelBuild(ctx, buildID)
+	return err
+}
+
+func (s *remoteTUISource) rerun(buildID string) (string, error) {
+	file, err := s.files.get(buildID)
+	if err != nil {
+		return "", err
+	}
+	return s.submit(file)
+}
+
+// Lines of logs kept by build
+const tuiMaxLogLines = 2000
+
+type tuiPhase struct {
+	name     string
+	current  int
+	total    int
+	done     bool
+	failed   bool
+	duration float64
+}
+
+type tuiBuild struct {
+	tuiBuildInfo
+	phases []*tuiPhase // In their start order
+	logs   []string
+}
+
+func (b *tuiBuild) phase(name string) *tuiPhase {
+	for _, phase := range b.phases {
+		if phase.name == name {
+			return phase
+		}
+	}
+	// A phase starting ends the previous ones, the servers only report the progress
+	for _, phase := range b.phases {
+		phase.done = true
+	}
+	phase := &tuiPhase{name: name}
+	b.phases = append(b.phases, phase)
+	return phase
+}
+
+// tuiModel is the state of the view
+type tuiModel struct {
+	source   tuiSource
+	builds   map[string]*tuiBuild
+	order    []string // Newest submission first
+	selected string
+	scroll   int // Lines of logs scrolled back from the end
+	status   string
+	width    int
+	height   int
+}
+
+func newTUIModel(source tuiSource) *tuiModel {
+	return &tuiModel{source: source, builds: make(map[string]*tuiBuild)}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *tuiModel) build(buildID string) *tuiBuild {
+	b, ok := m.builds[buildID]
+	if !ok {
+		b = &tuiBuild{tuiBuildInfo: tuiBuildInfo{ID: buildID, State: string(build.BuildStateRunning)}}
+		m.builds[buildID] = b
+	}
+	return b
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tea.KeyMsg:
+		return m, m.handleKey(msg)
+	case tuiBuildsMsg:
+		m.order = m.order[:0]
+		for _, info := range msg {
+			m.build(info.ID).tuiBuildInfo = info
+			m.order = append(m.order, info.ID)
+		}
+		if _, ok := m.builds[m.selected]; !ok && len(m.order) > 0 {
+			m.selected = m.order[0]
+		}
+	case tuiProgressMsg:
+		phase := m.build(msg.BuildID).phase(msg.Phase)
+		if msg.Total > 0 {
+			phase.current, phase.total = msg.Current, msg.Total
+		}
+		if msg.Finished {
+			phase.done, phase.failed, phase.duration = true, msg.Failed, msg.Duration
+		}
+	case tuiLogMsg:
+		b := m.build(msg.BuildID)
+		b.logs = append(b.logs, msg.Lines...)
+		if len(b.logs) > tuiMaxLogLines {
+			b.logs = b.logs[len(b.logs)-tuiMaxLogLines:]
+		}
+		if msg.BuildID == m.selected && m.scroll > 0 {
+			m.scroll += len(msg.Lines) // The scrolled back lines stay in place
+		}
+	case tuiActionMsg:
+		if msg.Err != nil {
+			m.status = "Error: " + msg.Err.Error()
+		} else {
+			m.status = msg.Message
+		}
+	}
+	return m, nil
+}
+
+func (m *tuiModel) handleKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "up", "k":
+		m.moveSelection(-1)
+	case "down", "j":
+		m.moveSelection(1)
+	case "pgup":
+		m.scroll += m.logHeight() / 2
+	case "pgdown":
+		m.scroll = max(0, m.scroll-m.logHeight()/2)
+	case "end":
+		m.scroll = 0
+	case "c":
+		buildID := m.selected
+		if buildID == "" {
+			return nil
+		}
+		return func() tea.Msg {
+			if err := m.source.cancel(buildID); err != nil {
+				return tuiActionMsg{Err: err}
+			}
+			return tuiActionMsg{Message: fmt.Sprintf("Build %s canceled", buildID)}
+		}
+	case "r":
+		buildID := m.selected
+		if buildID == "" {
+			return nil
+		}
+		return func() tea.Msg {
+			newID, err := m.source.rerun(buildID)
+			if err != nil {
+				return tuiActionMsg{Err: err}
+			}
+			return tuiActionMsg{Message: fmt.Sprintf("Build %s submitted", newID)}
+		}
+	}
+	return nil
+}
+
+func (m *tuiModel) moveSelection(delta int) {
+	for i, buildID := range m.order {
+		if buildID != m.selected {
+			continue
+		}
+		next := i + delta
+		if next >= 0 && next < len(m.order) {
+			m.selected = m.order[next]
+			m.scroll = 0
+		}
+		return
+	}
+}
+
+var (
+	tuiHeaderStyle   = lipgloss.NewStyle().Bold(true)
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiHelpStyle     = lipgloss.NewStyle().Faint(true)
+	tuiStateStyles   = map[string]lipgloss.Style{
+		string(build.BuildStateQueued):   lipgloss.NewStyle().Faint(true),
+		string(build.BuildStateRunning):  lipgloss.NewStyle().Foreground(lipgloss.Color("3")),
+		string(build.BuildStateSuccess):  lipgloss.NewStyle().Foreground(lipgloss.Color("2")),
+		string(build.BuildStateFailure):  lipgloss.NewStyle().Foreground(lipgloss.Color("1")),
+		string(build.BuildStateCanceled): lipgloss.NewStyle().Faint(true),
+	}
+)
+
+// Lines of the view taken by the build list at most
+const tuiMaxListLines = 10
+
+func (m *tuiModel) listHeight() int {
+	return min(len(m.order), tuiMaxListLines)
+}
+
+// logHeight returns the lines left to the logs: below the header, the list, the phases and the
+// separators, above the status and the help lines
+func (m *tuiModel) logHeight() int {
+	return max(1, m.height-m.listHeight()-6)
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	var lines []string
+	lines = append(lines, tuiHeaderStyle.Render(m.fit(fmt.Sprintf("  %-10s %-24s %-32s %s", "STATE", "BUILD", "ID", "PHASE"))))
+
+	// The list scrolls to keep the selected build visible
+	first := 0
+	for i, buildID := range m.order {
+		if buildID == m.selected && i >= tuiMaxListLines {
+			first = i - tuiMaxListLines + 1
+		}
+	}
+	for _, buildID := range m.order[first : first+m.listHeight()] {
+		b := m.builds[buildID]
+		state := b.State
+		if b.Position > 0 {
+			state = fmt.Sprintf("%s #%d", state, b.Position)
+		}
+		line := m.fit(fmt.Sprintf("  %-10s %-24s %-32s %s", state, b.Name+" "+b.Version, b.ID, currentPhase(b)))
+		if buildID == m.selected {
+			lines = append(lines, tuiSelectedStyle.Render(line))
+		} else {
+			lines = append(lines, tuiStateStyles[b.State].Render(line))
+		}
+	}
+	if len(m.order) == 0 {
+		lines = append(lines, "  No builds yet.")
+	}
+	lines = append(lines, strings.Repeat("─", m.width))
+
+	selected, ok := m.builds[m.selected]
+	if !ok {
+		lines = append(lines, "")
+	} else {
+		lines = append(lines, m.fit(phaseSummaryLine(selected)))
+	}
+	lines = append(lines, strings.Repeat("─", m.width))
+
+	var logs []string
+	if ok {
+		logs = selected.logs
+		if selected.Error != "" {
+			logs = append(logs[:len(logs):len(logs)], "Error: "+selected.Error)
+		}
+	}
+	height := m.logHeight()
+	end := max(0, len(logs)-m.scroll)
+	start := max(0, end-height)
+	for _, line := range logs[start:end] {
+		lines = append(lines, m.fit(line))
+	}
+	for i := end - start; i < height; i++ {
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, m.fit(m.status))
+	help := "↑/↓ select  c cancel  r re-run  pgup/pgdown scroll  q quit"
+	if m.scroll > 0 {
+		help += fmt.Sprintf("  (%d lines back, end to follow)", m.scroll)
+	}
+	lines = append(lines, tuiHelpStyle.Render(m.fit(help)))
+	return strings.Join(lines, "\n")
+}
+
+// fit cuts a line to the width of the terminal
+func (m *tuiModel) fit(line string) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	if runes := []rune(line); len(runes) > m.width {
+		return string(runes[:m.width])
+	}
+	return line
+}
+
+// currentPhase returns the phase of a running build with its progress, [2/5] build_steps
+func currentPhase(b *tuiBuild) string {
+	if b.State != string(build.BuildStateRunning) || len(b.phases) == 0 {
+		return ""
+	}
+	phase := b.phases[len(b.phases)-1]
+	if phase.total > 0 {
+		return fmt.Sprintf("%s %s %d/%d", phase.name, progressBar(phase.current, phase.total, 10), phase.current, phase.total)
+	}
+	return phase.name
+}
+
+func progressBar(current, total, width int) string {
+	filled := min(width, current*width/total)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+// phaseSummaryLine lists the phases of a build: ✓ for the finished ones with their duration, ✗ for the failed one
+func phaseSummaryLine(b *tuiBuild) string {
+	if len(b.phases) == 0 {
+		return fmt.Sprintf("%s %s: no phase started", b.Name, b.ID)
+	}
+	// The servers do not report the end of the last phase, it ends with the build
+	finished := b.State != string(build.BuildStateQueued) && b.State != string(build.BuildStateRunning)
+	parts := make([]string, 0, len(b.phases))
+	for i, phase := range b.phases {
+		last := i == len(b.phases)-1
+		switch {
+		case phase.failed, finished && last && !phase.done && b.State == string(build.BuildStateFailure):
+			parts = append(parts, "✗ "+phase.name)
+		case finished && !phase.done:
+			parts = append(parts, "✓ "+phase.name)
+		case phase.done && phase.duration > 0:
+			parts = append(parts, fmt.Sprintf("✓ %s %s", phase.name, time.Duration(phase.duration*float64(time.Second)).Round(100*time.Millisecond)))
+		case phase.done:
+			parts = append(parts, "✓ "+phase.name)
+		case phase.total > 0:
+			parts = append(parts, fmt.Sprintf("▸ %s %d/%d", phase.name, phase.current, phase.total))
+		default:
+			parts = append(parts, "▸ "+phase.name)
+		}
+	}
+	return strings.Join(parts, "  ")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	validateSpecFile string
+	validateOnline   bool
+	validateJSON     bool
+	validateQuiet    bool
+
+	validateCmd = &cobra.Command{
+		Use:   "validate -f <spec.yml>",
+		Short: "Check a build specification without building it.",
+		Long: `Validate runs every check of a build specification and prints all its problems, each one
+with its line and field: the unknown fields and the wrong types, the required fields, the references
+of the build steps to the codebases and to the previous steps, the enumerated values.
+
+With --online the sources are also checked: the git repositories and their branches are listed,
+the resource URLs are requested and the local sources must exist, relative to the current directory.
+The command exits with an error when the specification has problems.
+
+With --json the problems are written to stdout as a JSON report, --quiet prints nothing: only the
+exit status tells whether the specification is valid.`,
+		Args: cobra.NoArgs,
+		RunE: runValidateCommand,
+	}
+)
+
+func init() {
+	validateCmd.Flags().StringVarP(&validateSpecFile, "file", "f", "", "Path to the build specification (required)")
+	validateCmd.Flags().BoolVar(&validateOnline, "online", false, "Also check that the sources are reachable")
+	validateCmd.Flags().BoolVar(&validateJSON, "json", false, "Print the problems as JSON")
+	validateCmd.Flags().BoolVarP(&validateQuiet, "quiet", "q", false, "Print nothing, only exit with an error on problems")
+	validateCmd.MarkFlagsMutuallyExclusive("json", "quiet")
+	validateCmd.MarkFlagRequired("file")
+}
+
+func runValidateCommand(cmd *cobra.Command, args []string) error {
+	issues, err := build.ValidateBuildSpecFile(cmd.Context(), validateSpecFile, build.ValidateOptions{Online: validateOnline})
+	if err != nil {
+		return err
+	}
+	switch {
+	case validateJSON:
+		report := validateReport{File: validateSpecFile, Valid: len(issues) == 0, Issues: issues}
+		if report.Issues == nil {
+			report.Issues = []build.ValidationIssue{}
+		}
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	case validateQuiet:
+	case len(issues) == 0:
+		fmt.Printf("%s is valid.\n", validateSpecFile)
+	default:
+		for _, issue := range issues {
+			location := validateSpecFile
+			if issue.Line > 0 {
+				location = fmt.Sprintf("%s:%d", validateSpecFile, issue.Line)
+			}
+			if issue.Path != "" {
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", location, issue.Path, issue.Message)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", location, issue.Message)
+			}
+		}
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	if len(issues) == 1 {
+		return fmt.Errorf("%s has 1 problem", validateSpecFile)
+	}
+	return fmt.Errorf("%s has %d problems", validateSpecFile, len(issues))
+}
+
+// validateReport is the report of bx validate --json
+type validateReport struct {
+	File   string                  `json:"file"`
+	Valid  bool                    `json:"valid"`
+	Issues []build.ValidationIssue `json:"issues"`
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/bx/deploy"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchSpecFile string
+	watchDebounce time.Duration
+	watchVerbose  bool
+	watchNoColor  bool
+
+	watchCmd = &cobra.Command{
+		Use:   "watch -f <spec.yml>",
+		Short: "Rebuild and restart the services when the local codebases change.",
+		Long: `Watch builds a specification with the local docker engine, starts the services of its
+run.yml and follows their output, like 'bx run'. The directories of the "local" codebases are
+then watched: on a change the specification is built again, the layers of the files left
+unchanged coming from the cache, and only the services whose image changed are restarted.
+A failed build is reported and the running services are kept until the next change.
+
+The images stay in the local engine whatever the output of the specification, and the run.yml
+is written to a temporary directory unless --output-dir is set. Ctrl-C stops and removes the
+containers.`,
+		Args: cobra.NoArgs,
+		RunE: runWatchCommand,
+	}
+)
+
+func init() {
+	watchCmd.Flags().StringVarP(&watchSpecFile, "file", "f", "", "Path to the build specification (required)")
+	watchCmd.Flags().DurationVar(&watchDebounce, "debounce", build.DefaultWatchDebounce, "Quiet period after a change before the build starts")
+	watchCmd.Flags().BoolVarP(&watchVerbose, "verbose", "v", false, "Print the logs of the builds")
+	watchCmd.Flags().BoolVar(&watchNoColor, "no-color", false, "Do not color the service prefixes")
+	watchCmd.MarkFlagRequired("file")
+}
+
+func runWatchCommand(cmd *cobra.Command, args []string) error {
+	spec, err := build.LoadBuildSpecFromFile(watchSpecFile)
+	if err != nil {
+		return err
+	}
+	// The images stay in the engine, the layers of a build are the cache of the next one
+	spec.BuildConfig.OutputTarget = "docker"
+	spec.BuildConfig.NoCache = false
+	spec.BuildConfig.Push = false
+	spec.RunConfigDef.Generate = true
+	spec.RunConfigDef.ArtifactStorage = "docker"
+	spec.RunConfigDef.GenerateCompose = false
+	spec.RunConfigDef.SecretsEnvFile = false
+	addConfigRegistries(spec)
+
+	// The local sources are resolved against the working directory, as by the builder
+	watcher, err := build.NewCodebaseWatcher(spec, "", watchDebounce)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	output, err := outputOptions()
+	if err != nil {
+		return err
+	}
+	if output.Dir == "" {
+		dir, err := os.MkdirTemp("", "bx-watch-")
+		if err != nil {
+			return fmt.Errorf("cannot create the output directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		output.Dir = dir
+	}
+	fetcher, err := buildSecretFetcher(ctx, spec)
+	if err != nil {
+		return err
+	}
+	service, err := build.NewBuildService("", true, fetcher)
+	if err != nil {
+		return fmt.Errorf("cannot create the build service: %w", err)
+	}
+	defer service.Cleanup()
+	service.SetOutputOptions(output)
+
+	docker, err := localDocker()
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	project := "bx_" + spec.Name
+	session := &watchSession{
+		docker:  docker,
+		service: service,
+		spec:    spec,
+		runner: &deploy.Runner{
+			Docker:         docker,
+			Project:        project,
+			Out:            os.Stdout,
+			RunID:          fmt.Sprintf("%s-%d", project, time.Now().UnixNano()),
+			DefaultNetwork: true,
+		},
+		containers: make(map[string]string),
+		logs:       make(map[string]context.CancelFunc),
+	}
+	defer session.down()
+
+	for name, root := range watcher.Roots() {
+		fmt.Printf("Watching %s (%s)\n", root, name)
+	}
+	session.rebuild(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Interrupted, stopping the services...")
+			return nil
+		case err := <-watcher.Errors():
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		case changed := <-watcher.Changes():
+			fmt.Printf("==> Changed: %s\n", strings.Join(changed, ", "))
+			session.rebuild(ctx)
+		}
+	}
+}
+
+// watchSession keeps the containers of the services started by bx watch, with their output followed
+type watchSession struct {
+	docker     client.APIClient
+	service    *build.BuildService
+	spec       *build.BuildSpec
+	runner     *deploy.Runner
+	mux        *logMux
+	images     map[string]string             // Image ID of each service in the last successful build
+	containers map[string]string             // Container of each running service
+	logs       map[string]context.CancelFunc // Stops following the output of a service
+	wg         sync.WaitGroup
+}
+
+// rebuild builds the spec and restarts the services whose image changed. The failures are printed,
+// the services keep running until the next change.
+func (s *watchSession) rebuild(ctx context.Context) {
+	started := time.Now()
+	fmt.Printf("==> Building %s %s\n", s.spec.Name, s.spec.Version)
+	events := make(chan build.BuildEvent, 64)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		printWatchEvents(events)
+	}()
+	result, err := s.service.BuildWithEvents(ctx, s.spec, events)
+	<-printed
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil && result != nil && !result.Success {
+		err = fmt.Errorf("%s", result.ErrorMessage)
+	}
+	if err == nil && result.RunConfigPath == "" {
+		err = fmt.Errorf("no run.yml generated, the build has no service to run")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "==> Build failed: %v\n", err)
+		fmt.Println("==> Waiting for changes...")
+		return
+	}
+	fmt.Printf("==> Built in %s\n", time.Since(started).Round(100*time.Millisecond))
+
+	runConfig, err := deploy.LoadRunFile(result.RunConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "==> %v\n", err)
+		return
+	}
+	// The services of the profiles are only started by bx run --profile
+	if _, err := runConfig.SelectProfiles(nil); err != nil {
+		fmt.Fprintf(os.Stderr, "==> %v\n", err)
+		return
+	}
+	s.runner.BaseDir = filepath.Dir(result.RunConfigPath)
+	if err := s.restart(ctx, runConfig, result.ImageIDs); err != nil {
+		fmt.Fprintf(os.Stderr, "==> %v\n", err)
+	}
+	fmt.Println("==> Waiting for changes...")
+}
+
+// restart replaces the containers of the services whose image changed, starts the new services
+// and removes the ones no longer in the run file
+func (s *watchSession) restart(ctx context.Context, runConfig *build.RunYAML, images map[string]string) error {
+	order, err := deploy.ServiceOrder(runConfig.Services)
+	if err != nil {
+		return err
+	}
+	if s.mux == nil {
+		s.mux = newLogMux(os.Stdout, order, !watchNoColor && colorOutput(os.Stdout))
+	}
+	if err := s.runner.Prepare(ctx, runConfig); err != nil {
+		return err
+	}
+
+	for name := range s.containers {
+		if _, ok := runConfig.Services[name]; !ok {
+			fmt.Printf("--- Service '%s' removed ---\n", name)
+			s.remove(name)
+		}
+	}
+	var restarted []string
+	for _, name := range order {
+		if _, running := s.containers[name]; running && images[name] == s.images[name] {
+			continue
+		}
+		if err := s.start(ctx, name, runConfig.Services[name]); err != nil {
+			return err
+		}
+		restarted = append(restarted, name)
+	}
+	s.images = images
+	if len(restarted) == 0 {
+		fmt.Println("==> No image changed")
+	}
+	return nil
+}
+
+// start replaces the container of a service with a new one and follows its output
+func (s *watchSession) start(ctx context.Context, name string, service build.RunService) error {
+	if _, running := s.containers[name]; running {
+		fmt.Printf("--- Restarting the service: %s ---\n", name)
+		s.remove(name)
+	} else {
+		fmt.Printf("--- Starting the service: %s ---\n", name)
+	}
+	if len(service.SecretFiles) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: the secret files of '%s' are not mounted by bx watch.\n", name)
+	}
+	imageRef, err := s.runner.ResolveImage(ctx, name, service.Image)
+	if err != nil {
+		return err
+	}
+	id, err := s.runner.CreateService(ctx, name, service, imageRef)
+	if err != nil {
+		return fmt.Errorf("cannot create the container of '%s': %w", name, err)
+	}
+	s.containers[name] = id
+	if err := s.docker.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		return fmt.Errorf("cannot start the service '%s': %w", name, err)
+	}
+
+	logsCtx, cancel := context.WithCancel(ctx)
+	s.logs[name] = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		options := container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true}
+		if err := streamContainerLogs(logsCtx, s.docker, id, options, s.mux.Writer(name)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot follow the output of '%s': %v\n", name, err)
+		}
+	}()
+	return nil
+}
+
+// remove stops following a service and removes its container
+func (s *watchSession) remove(name string) {
+	if cancel, ok := s.logs[name]; ok {
+		cancel()
+		delete(s.logs, name)
+	}
+	removeRunContainer(s.docker, s.containers[name])
+	delete(s.containers, name)
+}
+
+// down removes the containers of the session once the command ends
+func (s *watchSession) down() {
+	names := make([]string, 0, len(s.containers))
+	for name := range s.containers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("Stopping the service %s\n", name)
+		s.remove(name)
+	}
+	s.wg.Wait()
+}
+
+// printWatchEvents writes the failures and the warnings of a build, with its logs in verbose mode
+func printWatchEvents(events <-chan build.BuildEvent) {
+	for event := range events {
+		switch event.Type {
+		case build.EventPhaseFinished:
+			if event.Error != "" {
+				fmt.Fprintf(os.Stderr, "==> %s failed: %s\n", event.Phase, event.Error)
+			}
+		case build.EventWarning:
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", event.Message)
+		case build.EventLog:
+			if watchVerbose {
+				fmt.Println(event.Message)
+			}
+		}
+	}
+}
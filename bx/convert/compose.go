@@ -0,0 +1,94 @@
+package convert
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeSecrets is the top-level secrets section of a compose file, ignored by the build loader
+type composeSecrets struct {
+	Secrets map[string]struct {
+		File        string `yaml:"file"`
+		Environment string `yaml:"environment"`
+	} `yaml:"secrets"`
+}
+
+// FromCompose converts a docker-compose file to a spec building it. The codebase is the directory
+// of the compose file (or opts.Source), the variables referenced without value become env entries
+// and the secrets of the compose file, like the sensitive service variables, become placeholder secrets.
+func FromCompose(data []byte, composePath string, opts Options) (*Result, error) {
+	composeDir := filepath.Dir(composePath)
+	env, err := build.ComposeInterpolationEnv(nil, composeDir)
+	if err != nil {
+		return nil, err
+	}
+	project, missing, err := build.LoadComposeFileWithEnv(data, env)
+	if err != nil {
+		return nil, err
+	}
+	var secrets composeSecrets
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("error during the compose YAML file parsing: %w", err)
+	}
+
+	source := opts.Source
+	if source == "" {
+		source = composeDir
+	}
+	name := specName(opts.Name, project.Name, dirName(composePath))
+	result := &Result{Spec: newSpec(name, opts.Version, sourceCodebase(name, source))}
+	spec := result.Spec
+	spec.BuildConfig.ComposeFile = filepath.ToSlash(filepath.Base(composePath))
+
+	for _, variable := range missing {
+		if looksSensitive(variable) {
+			result.addSecret(variable, variable, build.InjectEnv, "")
+			result.warnf("variable '%s' is interpolated in the compose file but looks like a secret: the interpolation only sees the env, reference it from the service environment instead", variable)
+			continue
+		}
+		if spec.Env == nil {
+			spec.Env = make(map[string]string)
+		}
+		spec.Env[variable] = ""
+		result.warnf("variable '%s' is referenced by the compose file without value, set it in env", variable)
+	}
+
+	built := 0
+	for _, serviceName := range sortedKeys(project.Services) {
+		service := project.Services[serviceName]
+		if service.Build != nil {
+			built++
+			if service.Image != "" {
+				spec.BuildConfig.Tags = append(spec.BuildConfig.Tags, service.Image)
+			}
+		}
+		for _, variable := range sortedKeys(service.Environment) {
+			if !looksSensitive(variable) {
+				continue
+			}
+			result.addSecret(variable, variable, build.InjectEnv, "")
+			if value := service.Environment[variable]; value != nil && *value != "" {
+				result.warnf("service '%s' sets '%s' in clear in the compose file, remove it once the secret source is set", serviceName, variable)
+			}
+		}
+	}
+	if built == 0 {
+		result.warnf("no service of the compose file builds an image, the build only pulls the images")
+	}
+	if len(spec.BuildConfig.Tags) > 1 {
+		result.warnf("the compose images are tagged '%s_<service>:latest', the image names are only kept as tags of the principal image", name)
+	}
+
+	for _, secretName := range sortedKeys(secrets.Secrets) {
+		result.addSecret(secretName, secretName, build.InjectFile, path.Join("/run/secrets", secretName))
+		if secret := secrets.Secrets[secretName]; secret.File != "" {
+			result.warnf("secret '%s' was read from the file '%s', store it in a secret provider", secretName, secret.File)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+// Package convert produces best-effort build specs from the files of other tools
+// (docker-compose files, GitHub Actions workflows). The produced specs are a starting point:
+// the secrets are placeholders and every approximation is reported as a warning.
+package convert
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PlaceholderScheme is the scheme of the secret sources to replace before building,
+// no provider is registered for it so an unchanged spec fails instead of building without the secret.
+const PlaceholderScheme = "todo"
+
+// Version given to the converted specs without explicit version
+const DefaultVersion = "0.1.0"
+
+// Options of a conversion
+type Options struct {
+	Name    string // Name of the spec, derived from the converted file if empty
+	Version string // Version of the spec, DefaultVersion if empty
+	Source  string // Codebase source: a local directory or a git URL, derived from the converted file if empty
+}
+
+// Result is a converted spec and the approximations made to produce it
+type Result struct {
+	Spec     *build.BuildSpec
+	Warnings []string
+}
+
+// YAML encodes the converted spec
+func (r *Result) YAML() ([]byte, error) {
+	data, err := yaml.Marshal(r.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the converted spec: %w", err)
+	}
+	return data, nil
+}
+
+func (r *Result) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// addSecret adds a secret with the placeholder source of ref, once per name
+func (r *Result) addSecret(name, ref, method, target string) {
+	for _, secret := range r.Spec.Secrets {
+		if secret.Name == name {
+			return
+		}
+	}
+	r.Spec.Secrets = append(r.Spec.Secrets, build.SecretSpec{
+		Name:         name,
+		Source:       PlaceholderSource(ref),
+		InjectMethod: method,
+		Target:       target,
+	})
+}
+
+// PlaceholderSource returns the placeholder source of a secret
+func PlaceholderSource(ref string) string {
+	return PlaceholderScheme + "://" + ref
+}
+
+// newSpec returns a spec with the defaults of the loader and a single codebase
+func newSpec(name, version string, codebase build.CodebaseConfig) *build.BuildSpec {
+	if version == "" {
+		version = DefaultVersion
+	}
+	return &build.BuildSpec{
+		Name:      name,
+		Version:   version,
+		Codebases: []build.CodebaseConfig{codebase},
+		BuildConfig: build.BuildConfig{
+			OutputTarget: "docker",
+		},
+		RunConfigDef: build.RunConfigDef{
+			Generate:        true,
+			ArtifactStorage: "docker",
+		},
+	}
+}
+
+// sourceCodebase returns the codebase of a source, placed at the root of the build directory
+// so the paths of the converted files stay valid
+func sourceCodebase(name, source string) build.CodebaseConfig {
+	codebase := build.CodebaseConfig{
+		Name:         name,
+		SourceType:   "local",
+		Source:       source,
+		TargetInHost: ".",
+	}
+	if isGitURL(source) {
+		codebase.SourceType = "git"
+	}
+	return codebase
+}
+
+func isGitURL(source string) bool {
+	return strings.HasSuffix(source, ".git") || strings.HasPrefix(source, "git@") ||
+		strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "ssh://")
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// specName returns a name usable in the image tags
+func specName(candidates ...string) string {
+	for _, candidate := range candidates {
+		name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(candidate), "-"), "-._")
+		if name != "" {
+			return name
+		}
+	}
+	return "app"
+}
+
+// dirName returns the name of the directory containing a file
+func dirName(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(abs))
+}
+
+var sensitiveWords = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "API_KEY", "APIKEY", "PRIVATE_KEY", "CREDENTIAL", "ACCESS_KEY"}
+
+// looksSensitive reports whether a variable name looks like the name of a secret
+func looksSensitive(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(upper, word) {
+			return true
+		}
+	}
+	return strings.HasSuffix(upper, "_KEY")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
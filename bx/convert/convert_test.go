@@ -0,0 +1,185 @@
+package convert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCompose = `
+name: shop
+services:
+  api:
+    build:
+      context: ./api
+    image: registry.example.com/shop/api:1.2
+    environment:
+      LOG_LEVEL: ${LOG_LEVEL:-info}
+      DB_HOST: ${DB_HOST}
+      DB_PASSWORD: hunter2
+  web:
+    build: ./web
+    image: registry.example.com/shop/web:1.2
+  redis:
+    image: redis:7
+secrets:
+  stripe_key:
+    file: ./stripe.key
+`
+
+func TestFromCompose(t *testing.T) {
+	dir := t.TempDir()
+	composePath := filepath.Join(dir, "docker-compose.yml")
+
+	result, err := FromCompose([]byte(testCompose), composePath, Options{})
+	require.NoError(t, err)
+	spec := result.Spec
+
+	assert.Equal(t, "shop", spec.Name)
+	assert.Equal(t, DefaultVersion, spec.Version)
+	assert.Equal(t, "docker-compose.yml", spec.BuildConfig.ComposeFile)
+	require.Len(t, spec.Codebases, 1)
+	assert.Equal(t, build.CodebaseConfig{Name: "shop", SourceType: "local", Source: dir, TargetInHost: "."}, spec.Codebases[0])
+	assert.Equal(t, []string{"registry.example.com/shop/api:1.2", "registry.example.com/shop/web:1.2"}, spec.BuildConfig.Tags)
+	assert.Equal(t, map[string]string{"DB_HOST": ""}, spec.Env)
+	assert.Equal(t, []build.SecretSpec{
+		{Name: "DB_PASSWORD", Source: "todo://DB_PASSWORD", InjectMethod: build.InjectEnv},
+		{Name: "stripe_key", Source: "todo://stripe_key", InjectMethod: build.InjectFile, Target: "/run/secrets/stripe_key"},
+	}, spec.Secrets)
+	assert.Contains(t, result.Warnings, "service 'api' sets 'DB_PASSWORD' in clear in the compose file, remove it once the secret source is set")
+
+	// The converted spec is accepted by the loader
+	data, err := result.YAML()
+	require.NoError(t, err)
+	loaded, err := build.LoadBuildSpecFromBytes(data, ".yml")
+	require.NoError(t, err)
+	assert.Equal(t, spec.Secrets, loaded.Secrets)
+}
+
+func TestFromCompose_Options(t *testing.T) {
+	result, err := FromCompose([]byte(testCompose), "deploy/compose.yml", Options{Name: "My App", Version: "2.0.0", Source: "git@github.com:acme/shop.git"})
+	require.NoError(t, err)
+	assert.Equal(t, "my-app", result.Spec.Name)
+	assert.Equal(t, "2.0.0", result.Spec.Version)
+	assert.Equal(t, "git", result.Spec.Codebases[0].SourceType)
+	assert.Equal(t, "compose.yml", result.Spec.BuildConfig.ComposeFile)
+}
+
+const testWorkflow = `
+name: Release
+env:
+  REGISTRY: ghcr.io
+  IMAGE: ${{ github.repository }}
+jobs:
+  image:
+    runs-on: ubuntu-latest
+    env:
+      SENTRY_DSN: ${{ secrets.SENTRY_DSN }}
+    steps:
+      - uses: actions/checkout@v4
+        with:
+          repository: acme/shop
+          ref: main
+      - uses: docker/login-action@v3
+        with:
+          registry: ghcr.io
+          password: ${{ secrets.GITHUB_TOKEN }}
+      - name: Build
+        uses: docker/build-push-action@v5
+        with:
+          context: ./api
+          file: ./api/Dockerfile
+          target: runtime
+          platforms: linux/amd64,linux/arm64
+          push: true
+          tags: |
+            ghcr.io/acme/shop:latest
+            ghcr.io/acme/shop:${{ github.sha }}
+          build-args: |
+            GO_VERSION=1.22
+            NPM_TOKEN=${{ secrets.NPM_TOKEN }}
+            COMMIT=${{ github.sha }}
+          secrets: |
+            "netrc=${{ secrets.NETRC }}"
+  deploy:
+    runs-on: ubuntu-latest
+    steps:
+      - run: ./deploy.sh ${{ secrets.DEPLOY_KEY }}
+`
+
+func TestFromGitHubActions(t *testing.T) {
+	result, err := FromGitHubActions([]byte(testWorkflow), ".github/workflows/release.yml", Options{})
+	require.NoError(t, err)
+	spec := result.Spec
+
+	assert.Equal(t, "release", spec.Name)
+	assert.Equal(t, build.CodebaseConfig{Name: "release", SourceType: "git", Source: "https://github.com/acme/shop.git", Branch: "main", TargetInHost: "."}, spec.Codebases[0])
+	config := spec.BuildConfig
+	assert.Equal(t, "api/Dockerfile", config.Dockerfile)
+	assert.Equal(t, "runtime", config.Target)
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, config.Platforms)
+	assert.Equal(t, []string{"ghcr.io/acme/shop:latest"}, config.Tags)
+	assert.Equal(t, map[string]string{"GO_VERSION": "1.22"}, config.Args)
+	assert.True(t, config.BuildKit)
+	assert.Equal(t, map[string]string{"REGISTRY": "ghcr.io"}, spec.Env)
+	assert.Equal(t, []build.SecretSpec{
+		{Name: "SENTRY_DSN", Source: "todo://SENTRY_DSN", InjectMethod: build.InjectEnv},
+		{Name: "netrc", Source: "todo://NETRC", InjectMethod: build.InjectBuild},
+		{Name: "NPM_TOKEN", Source: "todo://NPM_TOKEN", InjectMethod: build.InjectBuild},
+	}, spec.Secrets)
+	assert.Contains(t, result.Warnings, "secret 'DEPLOY_KEY' is used by the workflow but not by the build, it is not converted")
+	assert.Contains(t, result.Warnings, "tag 'ghcr.io/acme/shop:${{ github.sha }}' of step 'Build' is an expression, it is not converted")
+
+	data, err := result.YAML()
+	require.NoError(t, err)
+	_, err = build.LoadBuildSpecFromBytes(data, ".yml")
+	require.NoError(t, err)
+}
+
+func TestFromGitHubActions_DockerBuildCommand(t *testing.T) {
+	workflow := `
+jobs:
+  build:
+    steps:
+      - uses: actions/checkout@v4
+      - name: Build image
+        run: |
+          docker buildx build \
+            -f docker/app.Dockerfile --target prod \
+            --build-arg "TOKEN=${{ secrets.API_TOKEN }}" \
+            --secret id=npmrc,src=.npmrc \
+            -t acme/app:latest --no-cache docker
+`
+	result, err := FromGitHubActions([]byte(workflow), "build.yml", Options{Name: "app"})
+	require.NoError(t, err)
+	config := result.Spec.BuildConfig
+
+	assert.Equal(t, "local", result.Spec.Codebases[0].SourceType)
+	assert.Equal(t, "docker/app.Dockerfile", config.Dockerfile)
+	assert.Equal(t, "prod", config.Target)
+	assert.Equal(t, []string{"acme/app:latest"}, config.Tags)
+	assert.True(t, config.NoCache)
+	assert.Equal(t, []build.SecretSpec{
+		{Name: "npmrc", Source: "todo://npmrc", InjectMethod: build.InjectBuild},
+		{Name: "TOKEN", Source: "todo://API_TOKEN", InjectMethod: build.InjectBuild},
+	}, result.Spec.Secrets)
+}
+
+func TestFromGitHubActions_NoBuild(t *testing.T) {
+	_, err := FromGitHubActions([]byte("jobs:\n  test:\n    steps:\n      - run: go test ./...\n"), "ci.yml", Options{})
+	assert.Error(t, err)
+}
+
+func TestFromCompose_DotEnv(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("DB_HOST=db\n"), 0644))
+
+	result, err := FromCompose([]byte(testCompose), filepath.Join(dir, "docker-compose.yml"), Options{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Spec.Env)
+}
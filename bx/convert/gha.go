@@ -0,0 +1,398 @@
+package convert
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"gopkg.in/yaml.v3"
+)
+
+// workflow is the part of a GitHub Actions workflow used by the conversion
+type workflow struct {
+	Name string            `yaml:"name"`
+	Env  map[string]string `yaml:"env"`
+	Jobs map[string]struct {
+		Env   map[string]string `yaml:"env"`
+		Steps []workflowStep    `yaml:"steps"`
+	} `yaml:"jobs"`
+}
+
+type workflowStep struct {
+	Name string            `yaml:"name"`
+	Uses string            `yaml:"uses"`
+	Run  string            `yaml:"run"`
+	With map[string]string `yaml:"with"`
+	Env  map[string]string `yaml:"env"`
+}
+
+func (s workflowStep) label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if s.Uses != "" {
+		return s.Uses
+	}
+	return strings.SplitN(strings.TrimSpace(s.Run), "\n", 2)[0]
+}
+
+// action returns the action of a step without its version ("docker/build-push-action")
+func (s workflowStep) action() string {
+	action, _, _ := strings.Cut(s.Uses, "@")
+	return strings.ToLower(action)
+}
+
+// imageBuild is a docker build found in a workflow
+type imageBuild struct {
+	step      string
+	context   string
+	file      string
+	tags      []string
+	args      map[string]string
+	target    string
+	platforms []string
+	secrets   []string // BuildKit secret ids
+	ssh       []string
+	noCache   bool
+	pull      bool
+}
+
+var (
+	expressionPattern = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+	secretRefPattern  = regexp.MustCompile(`^secrets\.([A-Za-z_][A-Za-z0-9_]*)$`)
+
+	// Flags of `docker build` followed by a value
+	buildValueFlags = map[string]bool{"-t": true, "--tag": true, "-f": true, "--file": true, "--build-arg": true,
+		"--target": true, "--platform": true, "--secret": true, "--ssh": true}
+)
+
+// FromGitHubActions converts a GitHub Actions workflow to a spec. The first docker build of the
+// workflow (docker/build-push-action or a `docker build` command) gives the build config,
+// actions/checkout gives the codebase and the `${{ secrets.X }}` references become placeholder secrets.
+func FromGitHubActions(data []byte, workflowPath string, opts Options) (*Result, error) {
+	var wf workflow
+	if err := yaml.Unmarshal(data, &wf); err != nil {
+		return nil, fmt.Errorf("error during the workflow YAML file parsing: %w", err)
+	}
+	if len(wf.Jobs) == 0 {
+		return nil, fmt.Errorf("no job found in the workflow '%s'", workflowPath)
+	}
+
+	repoDir := workflowRepoDir(workflowPath)
+	name := specName(opts.Name, filepath.Base(repoDir), wf.Name)
+	source := opts.Source
+	if source == "" {
+		source = repoDir
+	}
+	result := &Result{Spec: newSpec(name, opts.Version, sourceCodebase(name, source))}
+	spec := result.Spec
+
+	result.addEnv(wf.Env, "the workflow")
+	var builds []imageBuild
+	for _, jobName := range sortedKeys(wf.Jobs) {
+		job := wf.Jobs[jobName]
+		result.addEnv(job.Env, fmt.Sprintf("job '%s'", jobName))
+		for _, step := range job.Steps {
+			switch action := step.action(); {
+			case action == "actions/checkout":
+				if repository := step.With["repository"]; repository != "" && opts.Source == "" && !hasExpression(repository) {
+					spec.Codebases[0] = sourceCodebase(name, fmt.Sprintf("https://github.com/%s.git", repository))
+				}
+				if ref := step.With["ref"]; ref != "" && spec.Codebases[0].SourceType == "git" && !hasExpression(ref) {
+					spec.Codebases[0].Branch = ref
+				}
+			case action == "docker/build-push-action":
+				result.addEnv(step.Env, fmt.Sprintf("step '%s'", step.label()))
+				builds = append(builds, result.buildPushAction(step))
+			case action == "docker/login-action":
+				result.warnf("step '%s' logs in to '%s', the registry login is not converted", step.label(), step.With["registry"])
+			case strings.HasPrefix(action, "docker/"):
+				result.warnf("step '%s' (%s) is not converted", step.label(), step.Uses)
+			case step.Run != "":
+				for _, command := range dockerBuildCommands(step.Run) {
+					result.addEnv(step.Env, fmt.Sprintf("step '%s'", step.label()))
+					builds = append(builds, result.dockerBuildCommand(step.label(), command))
+				}
+			}
+		}
+	}
+	if spec.Codebases[0].SourceType == "local" && opts.Source == "" {
+		result.warnf("the codebase is the local directory '%s', set a git source to build from the repository", source)
+	}
+
+	if len(builds) == 0 {
+		return nil, fmt.Errorf("no docker build found in the workflow '%s'", workflowPath)
+	}
+	result.applyBuild(builds[0])
+	for _, other := range builds[1:] {
+		result.warnf("step '%s' builds another image, only the first build ('%s') is converted", other.step, builds[0].step)
+	}
+
+	// The secrets referenced elsewhere (deployments, notifications...) are reported, not converted
+	for _, ref := range secretRefs(string(data)) {
+		if ref == "GITHUB_TOKEN" || result.hasSecretRef(ref) {
+			continue
+		}
+		result.warnf("secret '%s' is used by the workflow but not by the build, it is not converted", ref)
+	}
+	return result, nil
+}
+
+// workflowRepoDir returns the repository of a workflow stored in .github/workflows, "." otherwise
+func workflowRepoDir(workflowPath string) string {
+	dir := filepath.Dir(workflowPath)
+	if filepath.Base(dir) == "workflows" && filepath.Base(filepath.Dir(dir)) == ".github" {
+		return filepath.Dir(filepath.Dir(dir))
+	}
+	return "."
+}
+
+// addEnv adds the workflow variables to the spec env, or as secrets when they hold a secret
+func (r *Result) addEnv(env map[string]string, scope string) {
+	for _, key := range sortedKeys(env) {
+		value := env[key]
+		if ref, ok := secretRef(value); ok {
+			r.addSecret(key, ref, build.InjectEnv, "")
+			continue
+		}
+		if hasExpression(value) {
+			r.warnf("variable '%s' of %s is an expression (%s), it is not converted", key, scope, value)
+			continue
+		}
+		if r.Spec.Env == nil {
+			r.Spec.Env = make(map[string]string)
+		}
+		r.Spec.Env[key] = value
+	}
+}
+
+func (r *Result) hasSecretRef(ref string) bool {
+	for _, secret := range r.Spec.Secrets {
+		if secret.Source == PlaceholderSource(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPushAction reads the inputs of a docker/build-push-action step
+func (r *Result) buildPushAction(step workflowStep) imageBuild {
+	label := step.label()
+	b := imageBuild{
+		step:      label,
+		context:   step.With["context"],
+		file:      step.With["file"],
+		target:    step.With["target"],
+		platforms: inputList(step.With["platforms"]),
+		ssh:       inputList(step.With["ssh"]),
+		noCache:   step.With["no-cache"] == "true",
+		pull:      step.With["pull"] == "true",
+		args:      make(map[string]string),
+	}
+	b.tags = inputList(step.With["tags"])
+	for _, arg := range inputLines(step.With["build-args"]) {
+		key, value, _ := strings.Cut(arg, "=")
+		b.args[key] = value
+	}
+	for _, secret := range append(inputLines(step.With["secrets"]), inputLines(step.With["secret-files"])...) {
+		id, value, _ := strings.Cut(strings.Trim(secret, `"`), "=")
+		ref, ok := secretRef(value)
+		if !ok {
+			ref = id
+			r.warnf("secret '%s' of step '%s' is not a repository secret, fill its source", id, label)
+		}
+		r.addSecret(id, ref, build.InjectBuild, "")
+		b.secrets = append(b.secrets, id)
+	}
+	if step.With["push"] == "true" {
+		r.warnf("step '%s' pushes the image, the push is not converted", label)
+	}
+	return b
+}
+
+// dockerBuildCommand reads the flags of a `docker build` command
+func (r *Result) dockerBuildCommand(label string, args []string) imageBuild {
+	b := imageBuild{step: label, args: make(map[string]string)}
+	for i := 0; i < len(args); i++ {
+		flag, value, inline := strings.Cut(args[i], "=")
+		if !strings.HasPrefix(flag, "-") {
+			b.context = args[i]
+			continue
+		}
+		if buildValueFlags[flag] && !inline {
+			if i+1 == len(args) {
+				break
+			}
+			i++
+			value = args[i]
+		}
+		switch flag {
+		case "-t", "--tag":
+			b.tags = append(b.tags, value)
+		case "-f", "--file":
+			b.file = value
+		case "--build-arg":
+			key, argValue, _ := strings.Cut(value, "=")
+			b.args[key] = argValue
+		case "--target":
+			b.target = value
+		case "--platform":
+			b.platforms = append(b.platforms, inputList(value)...)
+		case "--ssh":
+			b.ssh = append(b.ssh, value)
+		case "--secret":
+			for _, field := range strings.Split(value, ",") {
+				if id, ok := strings.CutPrefix(field, "id="); ok {
+					r.addSecret(id, id, build.InjectBuild, "")
+					b.secrets = append(b.secrets, id)
+				}
+			}
+		case "--no-cache":
+			b.noCache = true
+		case "--pull":
+			b.pull = true
+		case "--push":
+			r.warnf("step '%s' pushes the image, the push is not converted", label)
+		}
+	}
+	return b
+}
+
+// applyBuild sets the build config of the spec from a workflow build
+func (r *Result) applyBuild(b imageBuild) {
+	config := &r.Spec.BuildConfig
+	context := b.context
+	if context == "" || hasExpression(context) {
+		context = "."
+	}
+	context = path.Clean(strings.TrimPrefix(context, "./"))
+	file := b.file
+	if file == "" {
+		file = path.Join(context, "Dockerfile")
+	}
+	file = path.Clean(file)
+	if file != "Dockerfile" {
+		config.Dockerfile = file
+	}
+	if path.Dir(file) != context {
+		r.warnf("the build context of step '%s' is '%s' but bx builds in the directory of the Dockerfile ('%s')", b.step, context, path.Dir(file))
+	}
+	config.Target = b.target
+	config.Platforms = b.platforms
+	config.NoCache = b.noCache
+	config.Pull = b.pull
+	config.SSHForward = b.ssh
+	if len(b.secrets) > 0 || len(b.ssh) > 0 {
+		config.BuildKit = true
+	}
+
+	for _, tag := range b.tags {
+		if hasExpression(tag) {
+			r.warnf("tag '%s' of step '%s' is an expression, it is not converted", tag, b.step)
+			continue
+		}
+		config.Tags = append(config.Tags, tag)
+	}
+	for _, key := range sortedKeys(b.args) {
+		value := b.args[key]
+		if ref, ok := secretRef(value); ok {
+			r.addSecret(key, ref, build.InjectBuild, "")
+			config.BuildKit = true
+			r.warnf("build argument '%s' of step '%s' holds a secret, it is converted to a build secret: read it with `RUN --mount=type=secret,id=%s`", key, b.step, key)
+			continue
+		}
+		if hasExpression(value) {
+			r.warnf("build argument '%s' of step '%s' is an expression (%s), it is not converted", key, b.step, value)
+			continue
+		}
+		if config.Args == nil {
+			config.Args = make(map[string]string)
+		}
+		config.Args[key] = value
+	}
+}
+
+func hasExpression(value string) bool {
+	return expressionPattern.MatchString(value)
+}
+
+// secretRef returns X when the value is exactly `${{ secrets.X }}`
+func secretRef(value string) (string, bool) {
+	match := expressionPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || match[0] != strings.TrimSpace(value) {
+		return "", false
+	}
+	ref := secretRefPattern.FindStringSubmatch(match[1])
+	if ref == nil {
+		return "", false
+	}
+	return ref[1], true
+}
+
+// secretRefs returns the names of the secrets referenced in a text, in order of appearance
+func secretRefs(text string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, match := range expressionPattern.FindAllStringSubmatch(text, -1) {
+		ref := secretRefPattern.FindStringSubmatch(match[1])
+		if ref != nil && !seen[ref[1]] {
+			seen[ref[1]] = true
+			refs = append(refs, ref[1])
+		}
+	}
+	return refs
+}
+
+// inputList splits an action list input, written one entry per line or comma separated
+func inputList(value string) []string {
+	var entries []string
+	for _, line := range inputLines(value) {
+		for _, entry := range strings.Split(line, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// inputLines splits a multiline action input, ignoring the empty lines
+func inputLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// dockerBuildCommands returns the arguments of the `docker build` and `docker buildx build`
+// commands of a run script. Quotes are removed, the line continuations joined.
+func dockerBuildCommands(script string) [][]string {
+	var commands [][]string
+	script = strings.ReplaceAll(script, "\\\n", " ")
+	// Keep the expressions in a single field
+	script = expressionPattern.ReplaceAllStringFunc(script, func(expression string) string {
+		return strings.Join(strings.Fields(expression), "")
+	})
+	for _, line := range strings.Split(script, "\n") {
+		for _, command := range strings.FieldsFunc(line, func(r rune) bool { return r == ';' || r == '&' || r == '|' }) {
+			fields := strings.Fields(command)
+			for i := range fields {
+				fields[i] = strings.Trim(fields[i], `"'`)
+			}
+			switch {
+			case len(fields) >= 2 && fields[0] == "docker" && fields[1] == "build":
+				commands = append(commands, fields[2:])
+			case len(fields) >= 3 && fields[0] == "docker" && fields[1] == "buildx" && fields[2] == "build":
+				commands = append(commands, fields[3:])
+			}
+		}
+	}
+	return commands
+}
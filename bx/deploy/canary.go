@@ -0,0 +1,266 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canary decisions recorded in the deployment history
+const (
+	CanaryPromote  = "promote"
+	CanaryRollback = "rollback"
+)
+
+// CanaryConfig describes the metrics probe evaluated during the canary window of a deployment
+type CanaryConfig struct {
+	Window           string      `json:"window,omitempty" yaml:"window,omitempty"`                       // Total observation time, "5m" by default
+	Interval         string      `json:"interval,omitempty" yaml:"interval,omitempty"`                   // Time between two checks, "30s" by default
+	FailureThreshold int         `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty"` // Failed checks triggering the rollback, 1 by default
+	Probe            ProbeConfig `json:"probe" yaml:"probe"`
+
+	window   time.Duration
+	interval time.Duration
+}
+
+// ProbeConfig is either a Prometheus instant query compared to thresholds or an HTTP endpoint
+type ProbeConfig struct {
+	Type         string   `json:"type" yaml:"type"`                                       // "prometheus" or "http"
+	URL          string   `json:"url" yaml:"url"`                                         // Prometheus base URL or the HTTP endpoint
+	Query        string   `json:"query,omitempty" yaml:"query,omitempty"`                 // PromQL query, its first sample is compared to Min/Max
+	Min          *float64 `json:"min,omitempty" yaml:"min,omitempty"`                     // The check fails below this value
+	Max          *float64 `json:"max,omitempty" yaml:"max,omitempty"`                     // The check fails above this value
+	ExpectStatus int      `json:"expect_status,omitempty" yaml:"expect_status,omitempty"` // HTTP probe: expected status, any 2xx if empty
+	MaxLatency   string   `json:"max_latency,omitempty" yaml:"max_latency,omitempty"`     // HTTP probe: the check fails above this response time
+	Timeout      string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`             // Timeout of a single check, "10s" by default
+
+	maxLatency time.Duration
+	timeout    time.Duration
+}
+
+// ProbeResult is the outcome of one check of the canary window
+type ProbeResult struct {
+	At      time.Time `json:"at"`
+	Healthy bool      `json:"healthy"`
+	Value   *float64  `json:"value,omitempty"` // Prometheus sample, or latency in seconds for the HTTP probe
+	Message string    `json:"message,omitempty"`
+}
+
+// CanaryReport is the decision taken at the end of (or during) the canary window
+type CanaryReport struct {
+	Decision string        `json:"decision"`
+	Reason   string        `json:"reason"`
+	Failures int           `json:"failures"`
+	Checks   []ProbeResult `json:"checks"`
+}
+
+// applyDefaults parses the durations and validates the probe
+func (c *CanaryConfig) applyDefaults() error {
+	var err error
+	if c.window, err = parseDuration(c.Window, 5*time.Minute); err != nil {
+		return fmt.Errorf("invalid canary window: %w", err)
+	}
+	if c.interval, err = parseDuration(c.Interval, 30*time.Second); err != nil {
+		return fmt.Errorf("invalid canary interval: %w", err)
+	}
+	if c.interval <= 0 || c.interval > c.window {
+		return fmt.Errorf("the canary interval must be positive and shorter than the window")
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 1
+	}
+
+	p := &c.Probe
+	if p.URL == "" {
+		return fmt.Errorf("the field 'canary.probe.url' is required")
+	}
+	switch p.Type {
+	case "prometheus":
+		if p.Query == "" {
+			return fmt.Errorf("the field 'canary.probe.query' is required for a prometheus probe")
+		}
+		if p.Min == nil && p.Max == nil {
+			return fmt.Errorf("a prometheus probe needs at least one of 'min' or 'max'")
+		}
+	case "http":
+	default:
+		return fmt.Errorf("unsupported canary probe type '%s' (expected prometheus or http)", p.Type)
+	}
+	if p.maxLatency, err = parseDuration(p.MaxLatency, 0); err != nil {
+		return fmt.Errorf("invalid canary probe max_latency: %w", err)
+	}
+	if p.timeout, err = parseDuration(p.Timeout, 10*time.Second); err != nil {
+		return fmt.Errorf("invalid canary probe timeout: %w", err)
+	}
+	return nil
+}
+
+func parseDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// RunCanary checks the probe every interval until the window ends or the failure threshold is reached
+func RunCanary(ctx context.Context, config *CanaryConfig, out io.Writer) (*CanaryReport, error) {
+	report := &CanaryReport{}
+	deadline := time.Now().Add(config.window)
+	httpClient := &http.Client{Timeout: config.Probe.timeout}
+
+	for {
+		result := config.Probe.check(ctx, httpClient)
+		report.Checks = append(report.Checks, result)
+		if result.Healthy {
+			fmt.Fprintf(out, "Canary check passed: %s\n", result.Message)
+		} else {
+			report.Failures++
+			fmt.Fprintf(out, "Canary check failed (%d/%d): %s\n", report.Failures, config.FailureThreshold, result.Message)
+		}
+		if report.Failures >= config.FailureThreshold {
+			report.Decision = CanaryRollback
+			report.Reason = fmt.Sprintf("%d failed check(s): %s", report.Failures, result.Message)
+			return report, nil
+		}
+		if !time.Now().Add(config.interval).Before(deadline) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		case <-time.After(config.interval):
+		}
+	}
+	report.Decision = CanaryPromote
+	report.Reason = fmt.Sprintf("%d check(s), %d failure(s) during the canary window", len(report.Checks), report.Failures)
+	return report, nil
+}
+
+// check runs a single evaluation of the probe
+func (p *ProbeConfig) check(ctx context.Context, httpClient *http.Client) ProbeResult {
+	result := ProbeResult{At: time.Now()}
+	var err error
+	switch p.Type {
+	case "prometheus":
+		err = p.checkPrometheus(ctx, httpClient, &result)
+	default:
+		err = p.checkHTTP(ctx, httpClient, &result)
+	}
+	if err != nil {
+		result.Healthy = false
+		result.Message = err.Error()
+	}
+	return result
+}
+
+func (p *ProbeConfig) checkHTTP(ctx context.Context, httpClient *http.Client, result *ProbeResult) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid probe request: %w", err)
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe request failed: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+	seconds := latency.Seconds()
+	result.Value = &seconds
+
+	if p.ExpectStatus != 0 && resp.StatusCode != p.ExpectStatus {
+		return fmt.Errorf("status %d, expected %d", resp.StatusCode, p.ExpectStatus)
+	}
+	if p.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if p.maxLatency > 0 && latency > p.maxLatency {
+		return fmt.Errorf("latency %s above %s", latency.Round(time.Millisecond), p.maxLatency)
+	}
+	result.Healthy = true
+	result.Message = fmt.Sprintf("status %d in %s", resp.StatusCode, latency.Round(time.Millisecond))
+	return nil
+}
+
+// prometheusResponse is the subset of the /api/v1/query answer used by the probe
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+func (p *ProbeConfig) checkPrometheus(ctx context.Context, httpClient *http.Client, result *ProbeResult) error {
+	endpoint := fmt.Sprintf("%s/api/v1/query?query=%s", strings.TrimRight(p.URL, "/"), url.QueryEscape(p.Query))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("invalid prometheus request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("prometheus query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body prometheusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("cannot decode the prometheus response (status %d): %w", resp.StatusCode, err)
+	}
+	if body.Status != "success" {
+		return fmt.Errorf("prometheus query error: %s", body.Error)
+	}
+	value, err := firstSample(body.Data.ResultType, body.Data.Result)
+	if err != nil {
+		return err
+	}
+	result.Value = &value
+
+	if p.Min != nil && value < *p.Min {
+		return fmt.Errorf("value %g below the minimum %g", value, *p.Min)
+	}
+	if p.Max != nil && value > *p.Max {
+		return fmt.Errorf("value %g above the maximum %g", value, *p.Max)
+	}
+	result.Healthy = true
+	result.Message = fmt.Sprintf("value %g within the thresholds", value)
+	return nil
+}
+
+// firstSample extracts the value of a scalar result or of the first series of a vector
+func firstSample(resultType string, raw json.RawMessage) (float64, error) {
+	var sample [2]any
+	switch resultType {
+	case "scalar":
+		if err := json.Unmarshal(raw, &sample); err != nil {
+			return 0, fmt.Errorf("cannot decode the prometheus scalar: %w", err)
+		}
+	case "vector":
+		var series []struct {
+			Value [2]any `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &series); err != nil {
+			return 0, fmt.Errorf("cannot decode the prometheus vector: %w", err)
+		}
+		if len(series) == 0 {
+			return 0, fmt.Errorf("the prometheus query returned no data")
+		}
+		sample = series[0].Value
+	default:
+		return 0, fmt.Errorf("unsupported prometheus result type '%s'", resultType)
+	}
+	text, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected prometheus sample %v", sample[1])
+	}
+	return strconv.ParseFloat(text, 64)
+}
@@ -0,0 +1,53 @@
+package deploy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCanary_PrometheusThresholds(t *testing.T) {
+	var errorRate atomic.Value
+	errorRate.Store("0.01")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query", r.URL.Path)
+		assert.Equal(t, `sum(rate(http_errors[1m]))`, r.URL.Query().Get("query"))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"` + errorRate.Load().(string) + `"]}]}}`))
+	}))
+	defer server.Close()
+
+	maxRate := 0.05
+	config := &CanaryConfig{
+		Window:   "20ms",
+		Interval: "10ms",
+		Probe:    ProbeConfig{Type: "prometheus", URL: server.URL + "/", Query: `sum(rate(http_errors[1m]))`, Max: &maxRate},
+	}
+	require.NoError(t, config.applyDefaults())
+
+	report, err := RunCanary(context.Background(), config, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, CanaryPromote, report.Decision)
+	assert.Equal(t, 0, report.Failures)
+	require.NotEmpty(t, report.Checks)
+	assert.InDelta(t, 0.01, *report.Checks[0].Value, 1e-9)
+
+	errorRate.Store("0.2")
+	report, err = RunCanary(context.Background(), config, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, CanaryRollback, report.Decision)
+	assert.Contains(t, report.Reason, "above the maximum")
+}
+
+func TestCanaryConfig_Validation(t *testing.T) {
+	config := &CanaryConfig{Probe: ProbeConfig{Type: "prometheus", URL: "http://prom:9090"}}
+	assert.ErrorContains(t, config.applyDefaults(), "canary.probe.query")
+
+	config = &CanaryConfig{Window: "10s", Interval: "1m", Probe: ProbeConfig{Type: "http", URL: "http://app/health"}}
+	assert.ErrorContains(t, config.applyDefaults(), "shorter than the window")
+}
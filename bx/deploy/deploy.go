@@ -0,0 +1,146 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadRunFile reads and parses a *.run.yml file
+func LoadRunFile(filename string) (*build.RunYAML, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the run file '%s': %w", filename, err)
+	}
+	var runConfig build.RunYAML
+	if err := yaml.Unmarshal(data, &runConfig); err != nil {
+		return nil, fmt.Errorf("run file parsing failed '%s': %w", filename, err)
+	}
+	if err := runConfig.LoadEnvFile(filepath.Dir(filename)); err != nil {
+		return nil, err
+	}
+	return &runConfig, nil
+}
+
+// Options tunes a deployment
+type Options struct {
+	Out        io.Writer // Progress output, os.Stdout if nil
+	History    *History  // The deployment is recorded in it when set
+	SkipCanary bool      // Ignore the canary configuration of the target
+	DeployedBy string    // Recorded author of the deployment, $USER if empty
+}
+
+// Deploy ships the artifacts referenced by a run.yml to the target and starts its services.
+// When the target has a canary probe, the previous containers are kept until the probe passes
+// and restored if it fails. The returned record is also appended to the history.
+func Deploy(ctx context.Context, target *Target, runFile string, opts Options) (*Record, error) {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	deployedBy := opts.DeployedBy
+	if deployedBy == "" {
+		deployedBy = os.Getenv("USER")
+	}
+	record := &Record{
+		ID:         fmt.Sprintf("%s-%d", target.Name, time.Now().UnixNano()),
+		Target:     target.Name,
+		Host:       target.Host(),
+		Project:    target.Project,
+		RunFile:    runFile,
+		DeployedBy: deployedBy,
+		StartedAt:  time.Now(),
+	}
+	err := deploy(ctx, target, runFile, opts, record)
+	record.FinishedAt = time.Now()
+	switch {
+	case err != nil:
+		record.Outcome = OutcomeFailed
+		record.Error = err.Error()
+	case record.Canary != nil && record.Canary.Decision == CanaryRollback:
+		record.Outcome = OutcomeRolledBack
+		err = fmt.Errorf("canary probe failed, deployment rolled back: %s", record.Canary.Reason)
+	default:
+		record.Outcome = OutcomeSuccess
+	}
+
+	if opts.History != nil {
+		if historyErr := opts.History.Append(record); historyErr != nil {
+			fmt.Fprintf(opts.Out, "Warning: %v\n", historyErr)
+		}
+	}
+	return record, err
+}
+
+func deploy(ctx context.Context, target *Target, runFile string, opts Options, record *Record) error {
+	runConfig, err := LoadRunFile(runFile)
+	if err != nil {
+		return err
+	}
+	if _, err := runConfig.SelectProfiles(target.Profiles); err != nil {
+		return err
+	}
+	if len(runConfig.Services) == 0 {
+		return fmt.Errorf("no service defined in '%s'", runFile)
+	}
+
+	driver, err := NewDriver(target)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+
+	fmt.Fprintf(opts.Out, "Connecting to the target '%s' (%s)...\n", target.Name, target.Driver)
+	docker, err := driver.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot connect to the target '%s': %w", target.Name, err)
+	}
+
+	canary := target.Canary
+	if opts.SkipCanary {
+		canary = nil
+	}
+	runner := &Runner{
+		Docker:       docker,
+		Project:      target.Project,
+		BaseDir:      filepath.Dir(runFile),
+		Env:          target.Env,
+		RunID:        record.ID,
+		Out:          opts.Out,
+		KeepPrevious: canary != nil,
+	}
+	record.Services, err = runner.Up(ctx, runConfig)
+	record.Images = runner.Images()
+	if err != nil {
+		if canary != nil {
+			if rollbackErr := runner.Rollback(context.WithoutCancel(ctx)); rollbackErr != nil {
+				return fmt.Errorf("%w (%v)", err, rollbackErr)
+			}
+		}
+		return err
+	}
+	if canary == nil {
+		return nil
+	}
+
+	fmt.Fprintf(opts.Out, "Canary window of %s started (%s probe).\n", canary.window, canary.Probe.Type)
+	report, err := RunCanary(ctx, canary, opts.Out)
+	if err != nil {
+		// Interrupted: the new version is not validated, restore the previous one
+		report.Decision = CanaryRollback
+		report.Reason = fmt.Sprintf("canary window interrupted: %v", err)
+	}
+	record.Canary = report
+	if report.Decision == CanaryRollback {
+		fmt.Fprintf(opts.Out, "Rolling back: %s\n", report.Reason)
+		return runner.Rollback(context.WithoutCancel(ctx))
+	}
+	fmt.Fprintln(opts.Out, "Canary passed, promoting the new containers.")
+	return runner.Commit(ctx)
+}
@@ -0,0 +1,360 @@
+package deploy
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/socket"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTargetsFile_Defaults(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, DefaultTargetsFile)
+	content := `
+targets:
+  prod-vm:
+    env:
+      APP_ENV: production
+    ssh:
+      host: 10.0.0.12
+      user: deployer
+      identity_file: keys/deploy_ed25519
+  staging:
+    driver: ssh
+    project: shop-staging
+    ssh:
+      host: staging.internal
+      port: 2222
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	targets, err := LoadTargetsFile(path)
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+
+	prod := targets["prod-vm"]
+	assert.Equal(t, "prod-vm", prod.Name)
+	assert.Equal(t, "ssh", prod.Driver)
+	assert.Equal(t, "prod-vm", prod.Project)
+	assert.Equal(t, 22, prod.SSH.Port)
+	assert.Equal(t, "/var/run/docker.sock", prod.SSH.DockerSocket)
+	assert.Equal(t, filepath.Join(dir, "keys/deploy_ed25519"), prod.SSH.IdentityFile)
+	assert.Equal(t, "production", prod.Env["APP_ENV"])
+
+	staging := targets["staging"]
+	assert.Equal(t, "shop-staging", staging.Project)
+	assert.Equal(t, 2222, staging.SSH.Port)
+}
+
+func TestLoadTargetsFile_MissingHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultTargetsFile)
+	require.NoError(t, os.WriteFile(path, []byte("targets:\n  prod:\n    driver: ssh\n"), 0644))
+
+	_, err := LoadTargetsFile(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ssh.host")
+}
+
+func TestServiceOrder(t *testing.T) {
+	services := map[string]build.RunService{
+		"web":    {DependsOn: build.Dependencies{{Service: "api"}}},
+		"api":    {DependsOn: build.Dependencies{{Service: "db"}, {Service: "cache"}}},
+		"db":     {},
+		"cache":  {},
+		"worker": {DependsOn: build.Dependencies{{Service: "db"}}},
+	}
+	order, err := ServiceOrder(services)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cache", "db", "api", "web", "worker"}, order)
+
+	services["db"] = build.RunService{DependsOn: build.Dependencies{{Service: "web"}}}
+	_, err = ServiceOrder(services)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dependency cycle")
+
+	_, err = ServiceOrder(map[string]build.RunService{"web": {DependsOn: build.Dependencies{{Service: "missing"}}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "undefined service")
+}
+
+func TestRunner_ContainerConfig(t *testing.T) {
+	runner := &Runner{Project: "shop", Out: io.Discard}
+	retries := 3
+	service := build.RunService{
+		Ports:       []string{"127.0.0.1:80:8080"},
+		Labels:      map[string]string{"team": "web", LabelProject: "spoofed"},
+		User:        "1000",
+		WorkingDir:  "/app",
+		Tmpfs:       []string{"/tmp"},
+		NetworkMode: "host",
+		Resources:   &build.ServiceResources{CPUs: 0.5, Memory: 512 << 20},
+		HealthCheck: &build.HealthCheck{Test: []string{"CMD-SHELL", "curl -f localhost"}, Interval: "30s", Retries: &retries},
+	}
+	config, hostConfig, err := runner.containerConfig("api", service, "api:1.0", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"team": "web", LabelProject: "shop", LabelService: "api"}, config.Labels)
+	assert.Equal(t, "1000", config.User)
+	assert.Equal(t, "/app", config.WorkingDir)
+	require.NotNil(t, config.Healthcheck)
+	assert.Equal(t, []string{"CMD-SHELL", "curl -f localhost"}, config.Healthcheck.Test)
+	assert.Equal(t, 30*time.Second, config.Healthcheck.Interval)
+	assert.Equal(t, 3, config.Healthcheck.Retries)
+	assert.Equal(t, map[string]string{"/tmp": ""}, hostConfig.Tmpfs)
+	assert.Equal(t, int64(5e8), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(512<<20), hostConfig.Memory)
+	assert.Equal(t, "host", string(hostConfig.NetworkMode))
+
+	secret := mount.Mount{Type: mount.TypeBind, Source: "/dev/shm/bx-secrets-1/0-key", Target: "/run/secrets/key", ReadOnly: true}
+	_, hostConfig, err = runner.containerConfig("api", service, "api:1.0", []mount.Mount{secret})
+	require.NoError(t, err)
+	assert.Equal(t, []mount.Mount{secret}, hostConfig.Mounts)
+
+	service.HealthCheck = &build.HealthCheck{Interval: "soon"}
+	_, _, err = runner.containerConfig("api", service, "api:1.0", nil)
+	assert.Error(t, err)
+}
+
+func TestHistory_LatestGetAndHandleDeployments(t *testing.T) {
+	history := NewHistory(filepath.Join(t.TempDir(), "deployments.jsonl"))
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, target := range []string{"staging", "prod", "staging"} {
+		require.NoError(t, history.Append(&Record{
+			ID:         fmt.Sprintf("%s-%d", target, i),
+			Target:     target,
+			DeployedBy: "alice",
+			StartedAt:  start.Add(time.Duration(i) * time.Hour),
+			FinishedAt: start.Add(time.Duration(i)*time.Hour + time.Minute),
+			Outcome:    OutcomeSuccess,
+			Images:     map[string]string{"api": "registry.local/api@sha256:abc"},
+		}))
+	}
+
+	latest, err := history.Latest("staging", 0)
+	require.NoError(t, err)
+	require.Len(t, latest, 2)
+	assert.Equal(t, "staging-2", latest[0].ID)
+
+	latest, err = history.Latest("", 1)
+	require.NoError(t, err)
+	require.Len(t, latest, 1)
+
+	record, err := history.Get("prod")
+	require.NoError(t, err)
+	assert.Equal(t, "prod-1", record.ID)
+	_, err = history.Get("staging")
+	assert.ErrorContains(t, err, "ambiguous")
+	_, err = history.Get("missing")
+	assert.ErrorIs(t, err, ErrDeploymentNotFound)
+
+	resp, err := history.HandleDeployments(context.Background(), socket.DeploymentsRequestPayload{Action: socket.DeploymentActionShow, ID: "prod-1"})
+	require.NoError(t, err)
+	require.Len(t, resp.Deployments, 1)
+	assert.Equal(t, "alice", resp.Deployments[0].DeployedBy)
+	assert.Equal(t, "registry.local/api@sha256:abc", resp.Deployments[0].Images["api"])
+	assert.Equal(t, "2026-03-01T11:00:00Z", resp.Deployments[0].StartedAt)
+}
+
+// inspectClient returns the states of a container in turn, the last one repeated
+type inspectClient struct {
+	client.ContainerAPIClient
+	states []*container.State
+}
+
+func (c *inspectClient) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	state := c.states[0]
+	if len(c.states) > 1 {
+		c.states = c.states[1:]
+	}
+	return container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{State: state}}, nil
+}
+
+func TestWaitForCondition(t *testing.T) {
+	dependencyPollInterval = time.Millisecond
+	starting := &container.State{Status: "running", Running: true, Health: &container.Health{Status: container.Starting}}
+	healthy := &container.State{Status: "running", Running: true, Health: &container.Health{Status: container.Healthy}}
+	unhealthy := &container.State{Status: "running", Running: true, Health: &container.Health{Status: container.Unhealthy}}
+	docker := &inspectClient{states: []*container.State{starting, starting, healthy}}
+	require.NoError(t, WaitForCondition(context.Background(), docker, "db", build.ConditionServiceHealthy, time.Second))
+
+	docker = &inspectClient{states: []*container.State{starting, unhealthy}}
+	err := WaitForCondition(context.Background(), docker, "db", build.ConditionServiceHealthy, time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unhealthy")
+
+	docker = &inspectClient{states: []*container.State{{Status: "running", Running: true}}}
+	err = WaitForCondition(context.Background(), docker, "db", build.ConditionServiceHealthy, time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no healthcheck")
+
+	docker = &inspectClient{states: []*container.State{starting}}
+	err = WaitForCondition(context.Background(), docker, "db", build.ConditionServiceHealthy, 10*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout")
+
+	docker = &inspectClient{states: []*container.State{{Status: "running", Running: true}, {Status: "exited", ExitCode: 0}}}
+	require.NoError(t, WaitForCondition(context.Background(), docker, "migrate", build.ConditionServiceCompletedSuccessfully, time.Second))
+
+	docker = &inspectClient{states: []*container.State{{Status: "exited", ExitCode: 3}}}
+	err = WaitForCondition(context.Background(), docker, "migrate", build.ConditionServiceCompletedSuccessfully, time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "code 3")
+}
+
+// idLoadClient loads the archives like an engine only reporting the image IDs
+type idLoadClient struct {
+	client.ImageAPIClient
+}
+
+func (idLoadClient) ImageLoad(ctx context.Context, input io.Reader, _ ...client.ImageLoadOption) (image.LoadResponse, error) {
+	io.Copy(io.Discard, input)
+	body := `{"stream":"Loaded image ID: sha256:abc\n"}`
+	return image.LoadResponse{Body: io.NopCloser(strings.NewReader(body)), JSON: true}, nil
+}
+
+func TestLoadImageArchive_TagsFromManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-1.0.tar")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	tw := tar.NewWriter(file)
+	manifest := `[{"Config":"blobs/sha256/abc","RepoTags":["shop/api:1.0"]}]`
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))}))
+	_, err = tw.Write([]byte(manifest))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, file.Close())
+
+	tags, err := LoadImageArchive(context.Background(), idLoadClient{}, path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop/api:1.0", "sha256:abc"}, tags)
+}
+
+// projectClient holds the resources of one project and records the removals
+type projectClient struct {
+	client.APIClient
+	removed []string
+}
+
+func (c *projectClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	if !options.Filters.ExactMatch("label", LabelProject+"=bx_shop") || !options.All {
+		return nil, fmt.Errorf("unexpected list options")
+	}
+	return []container.Summary{
+		{ID: "2", Names: []string{"/bx_shop_web"}, Labels: map[string]string{LabelProject: "bx_shop", LabelService: "web"}},
+		{ID: "1", Names: []string{"/bx_shop_db"}, Labels: map[string]string{LabelProject: "bx_shop", LabelService: "db"}},
+	}, nil
+}
+
+func (c *projectClient) ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error {
+	c.removed = append(c.removed, "container "+id)
+	return nil
+}
+
+func (c *projectClient) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	return []network.Summary{{ID: "n1", Name: "bx_shop_backend"}}, nil
+}
+
+func (c *projectClient) NetworkRemove(ctx context.Context, id string) error {
+	c.removed = append(c.removed, "network "+id)
+	return nil
+}
+
+func (c *projectClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	return volume.ListResponse{Volumes: []*volume.Volume{{Name: "bx_shop_data"}}}, nil
+}
+
+func (c *projectClient) VolumeRemove(ctx context.Context, name string, force bool) error {
+	c.removed = append(c.removed, "volume "+name)
+	return nil
+}
+
+func TestRemoveProject(t *testing.T) {
+	docker := &projectClient{}
+	require.NoError(t, RemoveProject(context.Background(), docker, "bx_shop", false, io.Discard))
+	// The containers in service order, then the networks, the volumes are kept
+	assert.Equal(t, []string{"container 1", "container 2", "network n1"}, docker.removed)
+
+	docker = &projectClient{}
+	require.NoError(t, RemoveProject(context.Background(), docker, "bx_shop", true, io.Discard))
+	assert.Equal(t, "volume bx_shop_data", docker.removed[len(docker.removed)-1])
+
+	assert.Error(t, RemoveProject(context.Background(), docker, "", true, io.Discard))
+}
+
+func TestRunner_ServiceNetworks(t *testing.T) {
+	runner := &Runner{Project: "bx_shop"}
+	assert.Empty(t, runner.serviceNetworks(build.RunService{}))
+
+	runner.DefaultNetwork = true
+	assert.Equal(t, []string{DefaultNetwork}, runner.serviceNetworks(build.RunService{}))
+	assert.Equal(t, []string{"backend"}, runner.serviceNetworks(build.RunService{Networks: []string{"backend"}}))
+	assert.Empty(t, runner.serviceNetworks(build.RunService{NetworkMode: "host"}))
+	assert.Equal(t, "bx_shop_default", runner.ProjectNetwork(DefaultNetwork))
+}
+
+// preflightClient is a remote engine running a container of another project and one of the project
+type preflightClient struct {
+	client.APIClient
+}
+
+func (preflightClient) DaemonHost() string {
+	return "tcp://engine:2376"
+}
+
+func (preflightClient) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	return []container.Summary{
+		{Names: []string{"/other_proxy"}, Labels: map[string]string{}, Ports: []container.Port{{IP: "0.0.0.0", PublicPort: 443, PrivatePort: 443, Type: "tcp"}}},
+		{Names: []string{"/bx_shop_web"}, Labels: map[string]string{LabelProject: "bx_shop"}, Ports: []container.Port{{IP: "0.0.0.0", PublicPort: 8080, PrivatePort: 80, Type: "tcp"}}},
+	}, nil
+}
+
+func (preflightClient) ImageInspect(ctx context.Context, ref string, _ ...client.ImageInspectOption) (image.InspectResponse, error) {
+	if ref == "shop/web:1.0" {
+		return image.InspectResponse{ID: "sha256:abc"}, nil
+	}
+	return image.InspectResponse{}, errdefs.NotFound(fmt.Errorf("no such image"))
+}
+
+func TestRunner_Preflight(t *testing.T) {
+	runConfig := &build.RunYAML{Services: map[string]build.RunService{
+		"web":   {Image: "shop/web:1.0", Ports: []string{"8080:80"}},
+		"admin": {Image: "shop/admin:1.0", Ports: []string{"127.0.0.1:8080:8000", "9000-9001:9000-9001/udp"}},
+		"proxy": {Image: "missing.tar", Ports: []string{"443:443"}},
+		"jobs":  {Image: "local:jobs", Ports: []string{"9001:9001/udp"}},
+	}}
+	runner := &Runner{Docker: preflightClient{}, Project: "bx_shop", BaseDir: t.TempDir()}
+	problems, err := runner.Preflight(context.Background(), runConfig)
+	require.NoError(t, err)
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	assert.Equal(t, []string{
+		"jobs: the host port 9001/udp is also published by the service 'admin'",
+		"web: the host port 8080/tcp is also published by the service 'admin'",
+		"proxy: the host port 443/tcp is used by the container 'other_proxy'",
+		"admin: the image 'shop/admin:1.0' is not in the docker engine",
+		"jobs: unresolved image reference 'local:jobs'",
+	}, messages[:5])
+	require.Len(t, messages, 6)
+	assert.Contains(t, messages[5], "proxy: cannot read the image archive")
+}
@@ -0,0 +1,26 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/client"
+)
+
+// Driver gives access to the docker engine of a deployment target
+type Driver interface {
+	// Connect opens the transport to the target and returns a docker client using it
+	Connect(ctx context.Context) (client.APIClient, error)
+	// Close releases the docker client and the underlying transport
+	Close() error
+}
+
+// NewDriver creates the driver declared by the target
+func NewDriver(target *Target) (Driver, error) {
+	switch target.Driver {
+	case "ssh", "":
+		return newSSHDriver(target.SSH), nil
+	default:
+		return nil, fmt.Errorf("deployment driver not supported '%s' for the target '%s'", target.Driver, target.Name)
+	}
+}
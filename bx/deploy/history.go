@@ -0,0 +1,205 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+	"github.com/Treefle-labs/Anexis/socket"
+)
+
+// DefaultHistoryFile is where the deployments are recorded, relative to the working directory
+const DefaultHistoryFile = ".anexis/deployments.jsonl"
+
+// Deployment outcomes
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFailed     = "failed"
+	OutcomeRolledBack = "rolled_back"
+)
+
+var ErrDeploymentNotFound = errors.New("deployment not found")
+
+// Record is one deployment kept in the history: what was deployed, where, when, by whom and the outcome
+type Record struct {
+	ID         string            `json:"id"`
+	Target     string            `json:"target"`
+	Host       string            `json:"host,omitempty"`    // Docker host of the target
+	Project    string            `json:"project,omitempty"` // Containers prefix on the target
+	RunFile    string            `json:"run_file"`
+	DeployedBy string            `json:"deployed_by,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Outcome    string            `json:"outcome"`
+	Error      string            `json:"error,omitempty"`
+	Images     map[string]string `json:"images,omitempty"`   // Service name -> image digest (repo digest, else image ID)
+	Services   map[string]string `json:"services,omitempty"` // Service name -> container ID
+	Canary     *CanaryReport     `json:"canary,omitempty"`
+}
+
+// History is an append-only JSON lines log of the deployments
+type History struct {
+	path   string
+	output build.OutputOptions // Mode and owner of the history file
+	mu     sync.Mutex
+}
+
+// NewHistory returns a history stored in the given file
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+// SetOutputOptions sets the mode and owner applied to the history file and its directory
+func (h *History) SetOutputOptions(opts build.OutputOptions) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.output = opts
+}
+
+// Append adds a record at the end of the history file
+func (h *History) Append(record *Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot encode the deployment record: %w", err)
+	}
+	f, err := h.output.OpenAppend(h.path)
+	if err != nil {
+		return fmt.Errorf("cannot open the history file '%s': %w", h.path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("cannot write the history file '%s': %w", h.path, err)
+	}
+	return nil
+}
+
+// List returns the recorded deployments, oldest first
+func (h *History) List() ([]Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the history file '%s': %w", h.path, err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("corrupted history file '%s' at line %d: %w", h.path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Get returns a recorded deployment by ID, a unique ID prefix is accepted
+func (h *History) Get(id string) (*Record, error) {
+	records, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+	var found *Record
+	for i := range records {
+		if records[i].ID == id {
+			return &records[i], nil
+		}
+		if strings.HasPrefix(records[i].ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("ambiguous deployment ID '%s'", id)
+			}
+			found = &records[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeploymentNotFound, id)
+	}
+	return found, nil
+}
+
+// Latest returns the most recent deployments first, filtered by target when not empty. A limit of 0 returns all of them.
+func (h *History) Latest(target string, limit int) ([]Record, error) {
+	records, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+	var latest []Record
+	for i := len(records) - 1; i >= 0; i-- {
+		if target != "" && records[i].Target != target {
+			continue
+		}
+		latest = append(latest, records[i])
+		if limit > 0 && len(latest) == limit {
+			break
+		}
+	}
+	return latest, nil
+}
+
+// HandleDeployments implements socket.DeploymentHistory
+func (h *History) HandleDeployments(ctx context.Context, req socket.DeploymentsRequestPayload) (*socket.DeploymentsResponsePayload, error) {
+	var records []Record
+	switch req.Action {
+	case socket.DeploymentActionList, "":
+		latest, err := h.Latest(req.Target, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+		records = latest
+	case socket.DeploymentActionShow:
+		if req.ID == "" {
+			return nil, fmt.Errorf("the deployment ID is required")
+		}
+		record, err := h.Get(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		records = []Record{*record}
+	default:
+		return nil, fmt.Errorf("unknown deployments action '%s'", req.Action)
+	}
+
+	resp := &socket.DeploymentsResponsePayload{Deployments: make([]socket.DeploymentPayload, 0, len(records))}
+	for _, record := range records {
+		resp.Deployments = append(resp.Deployments, record.Payload())
+	}
+	return resp, nil
+}
+
+// Payload converts the record for the socket API
+func (r Record) Payload() socket.DeploymentPayload {
+	return socket.DeploymentPayload{
+		ID:         r.ID,
+		Target:     r.Target,
+		Host:       r.Host,
+		Project:    r.Project,
+		RunFile:    r.RunFile,
+		DeployedBy: r.DeployedBy,
+		StartedAt:  r.StartedAt.Format(time.RFC3339),
+		FinishedAt: r.FinishedAt.Format(time.RFC3339),
+		Outcome:    r.Outcome,
+		Error:      r.Error,
+		Images:     r.Images,
+		Services:   r.Services,
+	}
+}
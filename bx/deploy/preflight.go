@@ -0,0 +1,204 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// PreflightProblem is a reason why a service of a run.yml would fail to start
+type PreflightProblem struct {
+	Service string
+	Message string
+}
+
+func (p PreflightProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Service, p.Message)
+}
+
+// hostPort is a port of the host published by a service or by a container
+type hostPort struct {
+	ip       string // Empty for every interface
+	port     string
+	protocol string
+	owner    string // Service or container publishing the port
+}
+
+// overlaps tells whether two published ports cannot be bound together
+func (p hostPort) overlaps(other hostPort) bool {
+	if p.port != other.port || p.protocol != other.protocol {
+		return false
+	}
+	return p.ip == "" || other.ip == "" || p.ip == other.ip
+}
+
+func (p hostPort) String() string {
+	if p.ip == "" {
+		return p.port + "/" + p.protocol
+	}
+	return net.JoinHostPort(p.ip, p.port) + "/" + p.protocol
+}
+
+// Preflight checks, before any container is started, that the services of the run.yml can start:
+// their host ports are not published twice nor used by another container or, with a local engine,
+// by another process, and their images are in the engine or in an archive. Every problem found is
+// returned, the containers of the project itself being replaced by the run.
+func (r *Runner) Preflight(ctx context.Context, runConfig *build.RunYAML) ([]PreflightProblem, error) {
+	names := make([]string, 0, len(runConfig.Services))
+	for name := range runConfig.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []PreflightProblem
+	var claimed []hostPort
+	for _, name := range names {
+		ports, err := servicePorts(name, runConfig.Services[name].Ports)
+		if err != nil {
+			problems = append(problems, PreflightProblem{Service: name, Message: err.Error()})
+			continue
+		}
+		for _, port := range ports {
+			for _, other := range claimed {
+				if port.overlaps(other) {
+					problems = append(problems, PreflightProblem{Service: name, Message: fmt.Sprintf("the host port %s is also published by the service '%s'", port, other.owner)})
+				}
+			}
+			claimed = append(claimed, port)
+		}
+	}
+
+	containers, err := r.Docker.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the containers: %w", err)
+	}
+	var used, own []hostPort
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			port := hostPort{ip: p.IP, port: fmt.Sprint(p.PublicPort), protocol: p.Type, owner: strings.TrimPrefix(firstName(c.Names), "/")}
+			if port.ip == "0.0.0.0" || port.ip == "::" {
+				port.ip = ""
+			}
+			if c.Labels[LabelProject] == r.Project {
+				own = append(own, port)
+			} else {
+				used = append(used, port)
+			}
+		}
+	}
+	localEngine := strings.HasPrefix(r.Docker.DaemonHost(), "unix://") || strings.HasPrefix(r.Docker.DaemonHost(), "npipe://")
+	for _, port := range claimed {
+		conflict := false
+		for _, other := range used {
+			if port.overlaps(other) {
+				problems = append(problems, PreflightProblem{Service: port.owner, Message: fmt.Sprintf("the host port %s is used by the container '%s'", port, other.owner)})
+				conflict = true
+				break
+			}
+		}
+		if conflict || !localEngine || overlapsAny(port, own) {
+			continue
+		}
+		if err := checkPortFree(port); err != nil {
+			problems = append(problems, PreflightProblem{Service: port.owner, Message: fmt.Sprintf("the host port %s is in use: %v", port, err)})
+		}
+	}
+
+	for _, name := range names {
+		if message := r.checkImage(ctx, runConfig.Services[name].Image); message != "" {
+			problems = append(problems, PreflightProblem{Service: name, Message: message})
+		}
+	}
+	return problems, nil
+}
+
+// servicePorts returns the host ports published by a service, the ranges expanded
+func servicePorts(service string, specs []string) ([]hostPort, error) {
+	_, bindings, err := nat.ParsePortSpecs(specs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ports: %w", err)
+	}
+	var ports []hostPort
+	for containerPort, portBindings := range bindings {
+		for _, binding := range portBindings {
+			if binding.HostPort == "" {
+				continue // Random port chosen by the engine
+			}
+			ip := binding.HostIP
+			if ip == "0.0.0.0" || ip == "::" {
+				ip = ""
+			}
+			ports = append(ports, hostPort{ip: ip, port: binding.HostPort, protocol: containerPort.Proto(), owner: service})
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].String() < ports[j].String() })
+	return ports, nil
+}
+
+func overlapsAny(port hostPort, ports []hostPort) bool {
+	for _, other := range ports {
+		if port.overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPortFree binds the port on the host and releases it at once
+func checkPortFree(port hostPort) error {
+	address := net.JoinHostPort(port.ip, port.port)
+	if port.protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// checkImage returns why the image of a service cannot be resolved, empty when it can. The B2
+// archives are downloaded when the service starts and are not checked.
+func (r *Runner) checkImage(ctx context.Context, imageRef string) string {
+	switch {
+	case imageRef == "":
+		return "no image"
+	case strings.HasPrefix(imageRef, "local:") || strings.Contains(imageRef, "_not_found"):
+		return fmt.Sprintf("unresolved image reference '%s'", imageRef)
+	case strings.HasPrefix(imageRef, build.B2Scheme):
+		return ""
+	case build.IsImageArchive(imageRef):
+		path := imageRef
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(r.BaseDir, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Sprintf("cannot read the image archive: %v", err)
+		}
+		return ""
+	}
+	if _, err := r.Docker.ImageInspect(ctx, imageRef); err != nil {
+		if errdefs.IsNotFound(err) {
+			return fmt.Sprintf("the image '%s' is not in the docker engine", imageRef)
+		}
+		return fmt.Sprintf("cannot inspect the image '%s': %v", imageRef, err)
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// projectFilter selects the resources labelled with a project, every project if empty
+func projectFilter(project string) filters.Args {
+	if project == "" {
+		return filters.NewArgs(filters.Arg("label", LabelProject))
+	}
+	return filters.NewArgs(filters.Arg("label", LabelProject+"="+project))
+}
+
+// ProjectContainers returns the containers started from a run.yml for a project, those of every project
+// if empty, sorted by project and service. The stopped containers are only listed with all.
+func ProjectContainers(ctx context.Context, docker client.ContainerAPIClient, project string, all bool) ([]container.Summary, error) {
+	containers, err := docker.ContainerList(ctx, container.ListOptions{All: all, Filters: projectFilter(project)})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the containers: %w", err)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		a, b := containers[i].Labels, containers[j].Labels
+		if a[LabelProject] != b[LabelProject] {
+			return a[LabelProject] < b[LabelProject]
+		}
+		return a[LabelService] < b[LabelService]
+	})
+	return containers, nil
+}
+
+// StopProject stops the running containers of a project, only those of the services when some are given,
+// and returns the stopped services. timeout is the grace period in seconds, the engine default if nil.
+func StopProject(ctx context.Context, docker client.ContainerAPIClient, project string, services []string, timeout *int) ([]string, error) {
+	containers, err := ProjectContainers(ctx, docker, project, false)
+	if err != nil {
+		return nil, err
+	}
+	var stopped []string
+	for _, c := range containers {
+		service := c.Labels[LabelService]
+		if len(services) > 0 && !slices.Contains(services, service) {
+			continue
+		}
+		if err := docker.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: timeout}); err != nil && !errdefs.IsNotFound(err) {
+			return stopped, fmt.Errorf("cannot stop the service '%s': %w", service, err)
+		}
+		stopped = append(stopped, service)
+	}
+	return stopped, nil
+}
+
+// RemoveProject removes the containers and the networks of a project, and its volumes with removeVolumes.
+// Only the resources labelled with the project are removed, the external networks and volumes are kept.
+func RemoveProject(ctx context.Context, docker client.APIClient, project string, removeVolumes bool, out io.Writer) error {
+	if project == "" {
+		return fmt.Errorf("no project to remove")
+	}
+	var errs []string
+	containers, err := ProjectContainers(ctx, docker, project, true)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		name := strings.TrimPrefix(firstName(c.Names), "/")
+		fmt.Fprintf(out, "Removing the container %s\n", name)
+		if err := docker.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("container '%s': %v", name, err))
+		}
+	}
+
+	networks, err := docker.NetworkList(ctx, network.ListOptions{Filters: projectFilter(project)})
+	if err != nil {
+		return fmt.Errorf("cannot list the networks: %w", err)
+	}
+	for _, n := range networks {
+		fmt.Fprintf(out, "Removing the network %s\n", n.Name)
+		if err := docker.NetworkRemove(ctx, n.ID); err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("network '%s': %v", n.Name, err))
+		}
+	}
+
+	if removeVolumes {
+		volumes, err := docker.VolumeList(ctx, volume.ListOptions{Filters: projectFilter(project)})
+		if err != nil {
+			return fmt.Errorf("cannot list the volumes: %w", err)
+		}
+		for _, v := range volumes.Volumes {
+			fmt.Fprintf(out, "Removing the volume %s\n", v.Name)
+			if err := docker.VolumeRemove(ctx, v.Name, false); err != nil && !errdefs.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("volume '%s': %v", v.Name, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot remove the project '%s': %s", project, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// StaleContainers returns the containers of every project which are not running (created, exited or
+// dead) and were stopped for longer than minAge, or created for longer when they never ran.
+func StaleContainers(ctx context.Context, docker client.ContainerAPIClient, minAge time.Duration) ([]container.Summary, error) {
+	containers, err := ProjectContainers(ctx, docker, "", true)
+	if err != nil {
+		return nil, err
+	}
+	var stale []container.Summary
+	for _, c := range containers {
+		if c.State != "created" && c.State != "exited" && c.State != "dead" {
+			continue
+		}
+		since := time.Unix(c.Created, 0)
+		if inspect, err := docker.ContainerInspect(ctx, c.ID); err == nil && inspect.State != nil {
+			if finished, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt); err == nil && finished.After(since) {
+				since = finished
+			}
+		} else if errdefs.IsNotFound(err) {
+			continue // Removed meanwhile
+		}
+		if time.Since(since) > minAge {
+			stale = append(stale, c)
+		}
+	}
+	return stale, nil
+}
+
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
@@ -0,0 +1,638 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+// Suffix of the containers kept aside during a deployment with rollback
+const previousSuffix = "_previous"
+
+// DefaultDependencyTimeout bounds the wait for a dependency to become healthy or to complete
+const DefaultDependencyTimeout = 5 * time.Minute
+
+// Interval between two inspections of a dependency
+var dependencyPollInterval = time.Second
+
+// DefaultNetwork is the network of the project joined by the services without networks nor
+// network_mode when Runner.DefaultNetwork is set, created as <project>_default like with docker compose.
+// Declaring it in the networks of the run.yml changes its configuration.
+const DefaultNetwork = "default"
+
+// Labels set on every container started from a run.yml
+const (
+	LabelProject = "io.anexis.project"
+	LabelService = "io.anexis.service"
+	LabelRun     = "io.anexis.run" // ID of the bx run or of the deployment which created the container
+)
+
+// Runner starts the services of a RunYAML on a docker engine, local or remote
+type Runner struct {
+	Docker  client.APIClient
+	Project string            // Prefix of the containers names
+	BaseDir string            // Directory of the run.yml, used to resolve the local image archives
+	Env     map[string]string // Extra env vars merged in every service (the service env wins)
+	Out     io.Writer         // Progress output, os.Stdout if nil
+	RunID   string            // Set as the LabelRun label of the containers when not empty
+
+	// KeepPrevious stops and renames the replaced containers instead of removing them,
+	// so they can be restored with Rollback or dropped with Commit.
+	KeepPrevious bool
+
+	// DefaultNetwork attaches the services without networks to the DefaultNetwork of the project,
+	// where the other services reach them by their service name
+	DefaultNetwork bool
+
+	// DependencyTimeout bounds the wait for the service_healthy and service_completed_successfully
+	// dependencies, DefaultDependencyTimeout if zero
+	DependencyTimeout time.Duration
+
+	networkMu sync.Mutex        // Serializes the creation of the networks by services created concurrently
+	replaced  []string          // Services whose container was set aside by Up
+	images    map[string]string // Image digest started by Up for each service
+	runConfig *build.RunYAML    // Networks and volumes of the project, set by Up
+}
+
+// Up loads the images and (re)creates the containers of every service in dependency order.
+// The containers are started detached and the IDs are returned by service name.
+func (r *Runner) Up(ctx context.Context, runConfig *build.RunYAML) (map[string]string, error) {
+	order, err := ServiceOrder(runConfig.Services)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Prepare(ctx, runConfig); err != nil {
+		return nil, err
+	}
+
+	started := make(map[string]string)
+	for _, serviceName := range order {
+		service := runConfig.Services[serviceName]
+		r.printf("--- Starting service: %s ---\n", serviceName)
+		if err := r.waitForDependencies(ctx, serviceName, service.DependsOn, started); err != nil {
+			return started, err
+		}
+
+		imageRef, err := r.ResolveImage(ctx, serviceName, service.Image)
+		if err != nil {
+			return started, err
+		}
+
+		r.recordImage(ctx, serviceName, imageRef)
+
+		containerID, err := r.startService(ctx, serviceName, service, imageRef)
+		if err != nil {
+			return started, fmt.Errorf("failed to start the service '%s': %w", serviceName, err)
+		}
+		started[serviceName] = containerID
+		r.printf("Service '%s' started (container %s)\n", serviceName, shortID(containerID))
+	}
+	return started, nil
+}
+
+// Prepare sets the run file of the project and creates its missing volumes, Up calls it
+func (r *Runner) Prepare(ctx context.Context, runConfig *build.RunYAML) error {
+	r.runConfig = runConfig
+	return r.ensureVolumes(ctx)
+}
+
+// Images returns the image digest of each service started by Up
+func (r *Runner) Images() map[string]string {
+	return r.images
+}
+
+// recordImage keeps the digest of the image run by a service: its repository digest when
+// it was pulled from a registry, else its image ID
+func (r *Runner) recordImage(ctx context.Context, serviceName, imageRef string) {
+	if r.images == nil {
+		r.images = make(map[string]string)
+	}
+	inspect, err := r.Docker.ImageInspect(ctx, imageRef)
+	if err != nil {
+		r.images[serviceName] = imageRef
+		return
+	}
+	if len(inspect.RepoDigests) > 0 {
+		r.images[serviceName] = inspect.RepoDigests[0]
+		return
+	}
+	r.images[serviceName] = inspect.ID
+}
+
+// ContainerName returns the name of the container of a service in this project
+func (r *Runner) ContainerName(serviceName string) string {
+	return fmt.Sprintf("%s_%s", r.Project, serviceName)
+}
+
+// ResolveImage loads the local image archives on the engine and returns the reference to run
+func (r *Runner) ResolveImage(ctx context.Context, serviceName, imageRef string) (string, error) {
+	if strings.HasPrefix(imageRef, "local:") || strings.Contains(imageRef, "_not_found") {
+		return "", fmt.Errorf("unresolved image reference '%s' for the service '%s'", imageRef, serviceName)
+	}
+	if !build.IsImageArchive(imageRef) {
+		return imageRef, nil
+	}
+
+	tarPath := imageRef
+	if !filepath.IsAbs(tarPath) {
+		tarPath = filepath.Join(r.BaseDir, tarPath)
+	}
+	r.printf("Loading the image archive %s...\n", tarPath)
+	tags, err := LoadImageArchive(ctx, r.Docker, tarPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to load the image of the service '%s': %w", serviceName, err)
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("no image loaded from the archive '%s'", tarPath)
+	}
+	r.printf("Loaded image %s\n", tags[0])
+	return tags[0], nil
+}
+
+// startService replaces the existing container of the service with a new one
+func (r *Runner) startService(ctx context.Context, serviceName string, service build.RunService, imageRef string) (string, error) {
+	containerID, err := r.CreateService(ctx, serviceName, service, imageRef)
+	if err != nil {
+		return "", err
+	}
+	if err := r.Docker.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return containerID, fmt.Errorf("container start failed: %w", err)
+	}
+	return containerID, nil
+}
+
+// CreateService replaces the existing container of the service with a new one, created but not started.
+// The mounts are added to the volumes of the service, e.g. the secret files written on the host.
+// Prepare must have been called with the run file of the service. The services can be created
+// concurrently, unless KeepPrevious is set.
+func (r *Runner) CreateService(ctx context.Context, serviceName string, service build.RunService, imageRef string, mounts ...mount.Mount) (string, error) {
+	name := r.ContainerName(serviceName)
+	if r.KeepPrevious {
+		if err := r.setPreviousAside(ctx, name); err != nil {
+			return "", err
+		}
+		r.replaced = append(r.replaced, serviceName)
+	} else if err := r.Docker.ContainerRemove(ctx, name, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		return "", fmt.Errorf("cannot remove the previous container '%s': %w", name, err)
+	}
+
+	config, hostConfig, err := r.containerConfig(serviceName, service, imageRef, mounts)
+	if err != nil {
+		return "", err
+	}
+	networkingConfig, err := r.networkingConfig(ctx, serviceName, service)
+	if err != nil {
+		return "", err
+	}
+	if networks := r.serviceNetworks(service); len(networks) > 0 && hostConfig.NetworkMode == "" {
+		// The first network replaces the default bridge of the engine, like with `docker run --network`
+		hostConfig.NetworkMode = container.NetworkMode(r.ProjectNetwork(networks[0]))
+	}
+
+	resp, err := r.Docker.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("container creation failed: %w", err)
+	}
+	for _, warning := range resp.Warnings {
+		r.printf("Warning: %s\n", warning)
+	}
+	return resp.ID, nil
+}
+
+// setPreviousAside stops the running container of a service and renames it with the previous suffix
+func (r *Runner) setPreviousAside(ctx context.Context, name string) error {
+	previousName := name + previousSuffix
+	if err := r.Docker.ContainerRemove(ctx, previousName, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("cannot remove the stale container '%s': %w", previousName, err)
+	}
+	if err := r.Docker.ContainerStop(ctx, name, container.StopOptions{}); err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil // First deployment of this service
+		}
+		return fmt.Errorf("cannot stop the previous container '%s': %w", name, err)
+	}
+	if err := r.Docker.ContainerRename(ctx, name, previousName); err != nil {
+		return fmt.Errorf("cannot rename the previous container '%s': %w", name, err)
+	}
+	return nil
+}
+
+// Rollback removes the containers created by Up and restarts the ones it set aside
+func (r *Runner) Rollback(ctx context.Context) error {
+	var errs []string
+	for _, serviceName := range r.replaced {
+		name := r.ContainerName(serviceName)
+		if err := r.Docker.ContainerRemove(ctx, name, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("remove '%s': %v", name, err))
+			continue
+		}
+		previousName := name + previousSuffix
+		if err := r.Docker.ContainerRename(ctx, previousName, name); err != nil {
+			if errdefs.IsNotFound(err) {
+				r.printf("Service '%s' had no previous container, removed.\n", serviceName)
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("rename '%s': %v", previousName, err))
+			continue
+		}
+		if err := r.Docker.ContainerStart(ctx, name, container.StartOptions{}); err != nil {
+			errs = append(errs, fmt.Sprintf("start '%s': %v", name, err))
+			continue
+		}
+		r.printf("Service '%s' rolled back to its previous container.\n", serviceName)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("rollback incomplete: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Commit removes the containers set aside by Up once the new ones are validated
+func (r *Runner) Commit(ctx context.Context) error {
+	var errs []string
+	for _, serviceName := range r.replaced {
+		previousName := r.ContainerName(serviceName) + previousSuffix
+		if err := r.Docker.ContainerRemove(ctx, previousName, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("remove '%s': %v", previousName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot remove the previous containers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// containerConfig translates a RunService into the docker API configuration
+func (r *Runner) containerConfig(serviceName string, service build.RunService, imageRef string, mounts []mount.Mount) (*container.Config, *container.HostConfig, error) {
+	env := make(map[string]string)
+	for k, v := range r.Env {
+		env[k] = v
+	}
+	for k, v := range service.Environment {
+		env[k] = v
+	}
+	envList := make([]string, 0, len(env))
+	for k, v := range env {
+		envList = append(envList, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(envList)
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(service.Ports)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ports for the service '%s': %w", serviceName, err)
+	}
+
+	var binds []string
+	for _, volumeMapping := range service.Volumes {
+		parts := strings.SplitN(volumeMapping, ":", 2)
+		if len(parts) == 2 && !filepath.IsAbs(parts[0]) && strings.Contains(parts[0], "/") {
+			r.printf("Warning: relative host path '%s' in the volume mapping is not supported, use an absolute path or a named volume.\n", parts[0])
+			continue
+		}
+		binds = append(binds, r.runConfig.VolumeSpec(r.Project, volumeMapping))
+	}
+
+	if len(service.SecretFiles) > 0 && len(mounts) == 0 {
+		// The engine API cannot populate a tmpfs before the container starts
+		r.printf("Warning: the secret files of the service '%s' are not supported on deployment targets, use env secrets.\n", serviceName)
+	}
+
+	labels := make(map[string]string, len(service.Labels)+2)
+	for k, v := range service.Labels {
+		labels[k] = v
+	}
+	labels[LabelProject] = r.Project
+	labels[LabelService] = serviceName
+	if r.RunID != "" {
+		labels[LabelRun] = r.RunID
+	}
+
+	healthcheck, err := healthConfig(service.HealthCheck)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid healthcheck for the service '%s': %w", serviceName, err)
+	}
+
+	config := &container.Config{
+		Image:        imageRef,
+		Env:          envList,
+		Cmd:          service.Command,
+		Entrypoint:   service.Entrypoint,
+		ExposedPorts: exposedPorts,
+		Labels:       labels,
+		Healthcheck:  healthcheck,
+		User:         service.User,
+		WorkingDir:   service.WorkingDir,
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings:  portBindings,
+		Binds:         binds,
+		Mounts:        mounts,
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyMode(service.Restart)},
+		NetworkMode:   container.NetworkMode(service.NetworkMode),
+	}
+	if len(service.Tmpfs) > 0 {
+		hostConfig.Tmpfs = make(map[string]string, len(service.Tmpfs))
+		for _, target := range service.Tmpfs {
+			hostConfig.Tmpfs[target] = ""
+		}
+	}
+	if resources := service.Resources; resources != nil {
+		hostConfig.NanoCPUs = int64(resources.CPUs * 1e9)
+		hostConfig.Memory = resources.Memory
+		hostConfig.MemoryReservation = resources.MemoryReservation
+	}
+	return config, hostConfig, nil
+}
+
+// healthConfig translates a run.yml healthcheck into the docker API configuration
+func healthConfig(check *build.HealthCheck) (*container.HealthConfig, error) {
+	if check == nil {
+		return nil, nil
+	}
+	if check.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+	config := &container.HealthConfig{Test: check.Test}
+	durations := []struct {
+		value  string
+		target *time.Duration
+	}{
+		{check.Interval, &config.Interval},
+		{check.Timeout, &config.Timeout},
+		{check.StartPeriod, &config.StartPeriod},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, err
+		}
+		*d.target = parsed
+	}
+	if check.Retries != nil {
+		config.Retries = *check.Retries
+	}
+	return config, nil
+}
+
+// waitForDependencies waits until the dependencies of a service reach their condition
+func (r *Runner) waitForDependencies(ctx context.Context, serviceName string, dependencies build.Dependencies, started map[string]string) error {
+	timeout := r.DependencyTimeout
+	if timeout <= 0 {
+		timeout = DefaultDependencyTimeout
+	}
+	for _, dependency := range dependencies {
+		if !dependency.Waits() {
+			continue
+		}
+		r.printf("Waiting for '%s' (%s)...\n", dependency.Service, dependency.Condition)
+		if err := WaitForCondition(ctx, r.Docker, started[dependency.Service], dependency.Condition, timeout); err != nil {
+			return fmt.Errorf("the dependency '%s' of the service '%s' is not ready: %w", dependency.Service, serviceName, err)
+		}
+	}
+	return nil
+}
+
+// WaitForCondition polls a container until it is healthy or has exited with the code 0
+func WaitForCondition(ctx context.Context, docker client.ContainerAPIClient, containerID, condition string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		inspect, err := docker.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("cannot inspect the container: %w", err)
+		}
+		if inspect.ContainerJSONBase == nil || inspect.State == nil {
+			return fmt.Errorf("the container has no state")
+		}
+		state := inspect.State
+		switch condition {
+		case build.ConditionServiceHealthy:
+			if state.Health == nil {
+				return fmt.Errorf("the container has no healthcheck")
+			}
+			switch state.Health.Status {
+			case container.Healthy:
+				return nil
+			case container.Unhealthy:
+				return fmt.Errorf("the container is unhealthy")
+			}
+			if !state.Running {
+				return fmt.Errorf("the container exited with the code %d", state.ExitCode)
+			}
+		case build.ConditionServiceCompletedSuccessfully:
+			if !state.Running && state.Status == "exited" {
+				if state.ExitCode != 0 {
+					return fmt.Errorf("the container exited with the code %d", state.ExitCode)
+				}
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout after %s", timeout)
+		case <-time.After(dependencyPollInterval):
+		}
+	}
+}
+
+// ProjectNetwork returns the name of a network of the project on the engine
+func (r *Runner) ProjectNetwork(network string) string {
+	return r.runConfig.NetworkName(r.Project, network)
+}
+
+// ensureVolumes creates the missing named volumes of the project, the external ones must exist
+func (r *Runner) ensureVolumes(ctx context.Context) error {
+	names := make([]string, 0, len(r.runConfig.Volumes))
+	for name := range r.runConfig.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		config := r.runConfig.Volumes[name]
+		volumeName := r.runConfig.VolumeName(r.Project, name)
+		if _, err := r.Docker.VolumeInspect(ctx, volumeName); err == nil {
+			continue
+		} else if !errdefs.IsNotFound(err) {
+			return fmt.Errorf("cannot inspect the volume '%s': %w", volumeName, err)
+		}
+		if config.External {
+			return fmt.Errorf("the external volume '%s' does not exist", volumeName)
+		}
+		r.printf("Creating the volume %s\n", volumeName)
+		_, err := r.Docker.VolumeCreate(ctx, volume.CreateOptions{
+			Name:       volumeName,
+			Driver:     config.Driver,
+			DriverOpts: config.DriverOpts,
+			Labels:     projectLabels(config.Labels, r.Project),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot create the volume '%s': %w", volumeName, err)
+		}
+	}
+	return nil
+}
+
+// projectLabels returns the labels of a network or a volume with the project label
+func projectLabels(labels map[string]string, project string) map[string]string {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[LabelProject] = project
+	return merged
+}
+
+// networkingConfig creates the missing networks of a service and returns its endpoints,
+// the service name is its alias on each network
+func (r *Runner) networkingConfig(ctx context.Context, serviceName string, service build.RunService) (*network.NetworkingConfig, error) {
+	networks := r.serviceNetworks(service)
+	if len(networks) == 0 {
+		return nil, nil
+	}
+	r.networkMu.Lock()
+	defer r.networkMu.Unlock()
+	endpoints := make(map[string]*network.EndpointSettings, len(networks))
+	for _, name := range networks {
+		networkName := r.ProjectNetwork(name)
+		if _, err := r.Docker.NetworkInspect(ctx, networkName, network.InspectOptions{}); err != nil {
+			if !errdefs.IsNotFound(err) {
+				return nil, fmt.Errorf("cannot inspect the network '%s': %w", networkName, err)
+			}
+			config := r.runConfig.Networks[name]
+			if config.External {
+				return nil, fmt.Errorf("the external network '%s' does not exist", networkName)
+			}
+			r.printf("Creating the network %s\n", networkName)
+			_, err := r.Docker.NetworkCreate(ctx, networkName, network.CreateOptions{
+				Driver:   config.Driver,
+				Options:  config.DriverOpts,
+				Internal: config.Internal,
+				Labels:   projectLabels(config.Labels, r.Project),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot create the network '%s': %w", networkName, err)
+			}
+		}
+		endpoints[networkName] = &network.EndpointSettings{Aliases: []string{serviceName}}
+	}
+	return &network.NetworkingConfig{EndpointsConfig: endpoints}, nil
+}
+
+// serviceNetworks returns the networks of the project joined by a service
+func (r *Runner) serviceNetworks(service build.RunService) []string {
+	if len(service.Networks) == 0 && r.DefaultNetwork && service.NetworkMode == "" {
+		return []string{DefaultNetwork}
+	}
+	return service.Networks
+}
+
+func (r *Runner) printf(format string, args ...any) {
+	out := r.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format, args...)
+}
+
+// LoadImageArchive sends an image tar to the engine and returns the loaded references, the tags first.
+// The tags missing from the response of the engine are read from the manifest of the archive.
+func LoadImageArchive(ctx context.Context, docker client.ImageAPIClient, tarPath string) ([]string, error) {
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the image archive '%s': %w", tarPath, err)
+	}
+	defer file.Close()
+
+	tags, err := build.LoadImage(ctx, docker, file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load '%s': %w", tarPath, err)
+	}
+	if len(tags) > 0 && !strings.HasPrefix(tags[0], "sha256:") {
+		return tags, nil
+	}
+
+	// The engine only reported image IDs (or nothing), the tags are read from the archive manifest
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("cannot read the image archive '%s': %w", tarPath, err)
+	}
+	repoTags, err := build.ArchiveRepoTags(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the tags of '%s': %w", tarPath, err)
+	}
+	return append(repoTags, tags...), nil
+}
+
+// ServiceOrder sorts the services so that each one comes after its dependencies
+func ServiceOrder(services map[string]build.RunService) ([]string, error) {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic order between independent services
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	order := make([]string, 0, len(services))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle between services: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		deps := services[name].DependsOn.Services()
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := services[dep]; !ok {
+				return fmt.Errorf("service '%s' depends on an undefined service '%s'", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,170 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultDialTimeout = 15 * time.Second
+
+// sshDriver talks to the remote docker daemon through a SSH tunnel to its unix socket.
+// Nothing but sshd and dockerd is required on the remote host.
+type sshDriver struct {
+	config SSHConfig
+
+	mu        sync.Mutex
+	agentConn net.Conn // ssh-agent used to authenticate, nil with an identity file
+	sshClient *ssh.Client
+	docker    *client.Client
+}
+
+func newSSHDriver(config SSHConfig) *sshDriver {
+	return &sshDriver{config: config}
+}
+
+func (d *sshDriver) Connect(ctx context.Context) (client.APIClient, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.docker != nil {
+		return d.docker, nil
+	}
+
+	clientConfig, err := d.clientConfig(ctx)
+	if err != nil {
+		d.closeAgent()
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(d.config.Host, strconv.Itoa(d.config.Port))
+	dialer := net.Dialer{Timeout: clientConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		d.closeAgent()
+		return nil, fmt.Errorf("cannot reach the SSH host '%s': %w", addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		d.closeAgent()
+		return nil, fmt.Errorf("SSH handshake failed with '%s': %w", addr, err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	// Every HTTP connection of the docker client is a new channel forwarded to the remote socket
+	socketPath := d.config.DockerSocket
+	dockerClient, err := client.NewClientWithOpts(
+		client.WithHost("http://docker.ssh"),
+		client.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return sshClient.DialContext(ctx, "unix", socketPath)
+		}),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		sshClient.Close()
+		d.closeAgent()
+		return nil, fmt.Errorf("error during the remote Docker client initialization: %w", err)
+	}
+
+	if _, err := dockerClient.Ping(ctx); err != nil {
+		dockerClient.Close()
+		sshClient.Close()
+		d.closeAgent()
+		return nil, fmt.Errorf("remote docker daemon not reachable through '%s' on %s: %w", socketPath, addr, err)
+	}
+
+	d.sshClient = sshClient
+	d.docker = dockerClient
+	return dockerClient, nil
+}
+
+func (d *sshDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var firstErr error
+	if d.docker != nil {
+		firstErr = d.docker.Close()
+		d.docker = nil
+	}
+	if d.sshClient != nil {
+		if err := d.sshClient.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		d.sshClient = nil
+	}
+	d.closeAgent()
+	return firstErr
+}
+
+// closeAgent closes the connection to the ssh-agent, d.mu held
+func (d *sshDriver) closeAgent() {
+	if d.agentConn != nil {
+		d.agentConn.Close()
+		d.agentConn = nil
+	}
+}
+
+// clientConfig builds the SSH authentication and host key verification settings.
+// The connection to the ssh-agent is kept on the driver until Close.
+func (d *sshDriver) clientConfig(ctx context.Context) (*ssh.ClientConfig, error) {
+	var auths []ssh.AuthMethod
+	if d.config.IdentityFile != "" {
+		keyData, err := os.ReadFile(d.config.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the SSH identity file '%s': %w", d.config.IdentityFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the SSH identity file '%s': %w", d.config.IdentityFile, err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	} else if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		var dialer net.Dialer
+		agentConn, err := dialer.DialContext(ctx, "unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to the ssh-agent '%s': %w", sock, err)
+		}
+		d.agentConn = agentConn
+		auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+	} else {
+		return nil, fmt.Errorf("no SSH identity file configured and no ssh-agent available (SSH_AUTH_SOCK)")
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if d.config.InsecureIgnoreHostKey {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		knownHostsFile := d.config.KnownHostsFile
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve the default known_hosts file: %w", err)
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the known hosts file '%s': %w", knownHostsFile, err)
+		}
+		hostKeyCallback = callback
+	}
+
+	return &ssh.ClientConfig{
+		User:            d.config.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         defaultDialTimeout,
+	}, nil
+}
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTargetsFile is the file looked up when no targets file is provided
+const DefaultTargetsFile = "anexis.targets.yml"
+
+// Target is a deployment environment on which a *.run.yml can be started
+type Target struct {
+	Name     string            `json:"-" yaml:"-"`                                   // Filled from the key in the targets file
+	Driver   string            `json:"driver" yaml:"driver"`                         // "ssh" (default)
+	Project  string            `json:"project,omitempty" yaml:"project,omitempty"`   // Prefix for the containers names, defaults to the target name
+	Env      map[string]string `json:"env,omitempty" yaml:"env,omitempty"`           // Extra env vars injected in every service of this environment
+	SSH      SSHConfig         `json:"ssh,omitempty" yaml:"ssh,omitempty"`           // Used by the "ssh" driver
+	Canary   *CanaryConfig     `json:"canary,omitempty" yaml:"canary,omitempty"`     // Metrics probe evaluated after the deployment, rollback on failure
+	Profiles []string          `json:"profiles,omitempty" yaml:"profiles,omitempty"` // Profiles of the run.yml services deployed with the services without profiles
+}
+
+// SSHConfig holds the connection parameters of a remote docker host reachable over SSH
+type SSHConfig struct {
+	Host                  string `json:"host" yaml:"host"`                                                             // The remote host name or IP
+	Port                  int    `json:"port,omitempty" yaml:"port,omitempty"`                                         // 22 by default
+	User                  string `json:"user,omitempty" yaml:"user,omitempty"`                                         // $USER by default
+	IdentityFile          string `json:"identity_file,omitempty" yaml:"identity_file,omitempty"`                       // Private key path. The ssh-agent is used if empty
+	KnownHostsFile        string `json:"known_hosts_file,omitempty" yaml:"known_hosts_file,omitempty"`                 // ~/.ssh/known_hosts by default
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key,omitempty" yaml:"insecure_ignore_host_key,omitempty"` // Skip the host key verification (testing only)
+	DockerSocket          string `json:"docker_socket,omitempty" yaml:"docker_socket,omitempty"`                       // Remote docker socket, /var/run/docker.sock by default
+}
+
+// targetsFile is the on disk layout of the targets file
+type targetsFile struct {
+	Targets map[string]*Target `yaml:"targets"`
+}
+
+// LoadTargetsFile reads the deployment targets declared in a YAML file
+func LoadTargetsFile(filename string) (map[string]*Target, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the targets file '%s': %w", filename, err)
+	}
+	var file targetsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("targets file parsing failed '%s': %w", filename, err)
+	}
+	if len(file.Targets) == 0 {
+		return nil, fmt.Errorf("no target defined in '%s'", filename)
+	}
+
+	baseDir := filepath.Dir(filename)
+	for name, target := range file.Targets {
+		if target == nil {
+			return nil, fmt.Errorf("target '%s' is empty", name)
+		}
+		target.Name = name
+		if err := target.applyDefaults(baseDir); err != nil {
+			return nil, err
+		}
+	}
+	return file.Targets, nil
+}
+
+// applyDefaults fills the optional fields and validates the target
+func (t *Target) applyDefaults(baseDir string) error {
+	if t.Driver == "" {
+		t.Driver = "ssh"
+	}
+	if t.Project == "" {
+		t.Project = t.Name
+	}
+
+	if t.Driver == "ssh" {
+		if t.SSH.Host == "" {
+			return fmt.Errorf("target '%s': the field 'ssh.host' is required", t.Name)
+		}
+		if t.SSH.Port == 0 {
+			t.SSH.Port = 22
+		}
+		if t.SSH.User == "" {
+			t.SSH.User = os.Getenv("USER")
+		}
+		if t.SSH.DockerSocket == "" {
+			t.SSH.DockerSocket = "/var/run/docker.sock"
+		}
+		t.SSH.IdentityFile = expandPath(baseDir, t.SSH.IdentityFile)
+		t.SSH.KnownHostsFile = expandPath(baseDir, t.SSH.KnownHostsFile)
+	}
+	if t.Canary != nil {
+		if err := t.Canary.applyDefaults(); err != nil {
+			return fmt.Errorf("target '%s': %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// Host describes where the target runs its containers, for the deployment records
+func (t *Target) Host() string {
+	if t.Driver == "ssh" {
+		return fmt.Sprintf("%s@%s:%d", t.SSH.User, t.SSH.Host, t.SSH.Port)
+	}
+	return t.Driver
+}
+
+// expandPath resolves "~/" and paths relative to the targets file
+func expandPath(baseDir, path string) string {
+	if path == "" {
+		return ""
+	}
+	if len(path) > 1 && path[:2] == "~/" {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(baseDir, path)
+	}
+	return path
+}
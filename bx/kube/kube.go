@@ -0,0 +1,470 @@
+// Package kube generates Kubernetes manifests from a run.yml: a Deployment per service, a Service
+// for the services publishing ports and Secrets for their environment and secret files. The
+// manifests are a starting point, every approximation of the run.yml semantics is reported as a warning.
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+)
+
+// Labels set on every generated object
+const (
+	LabelName      = "app.kubernetes.io/name"
+	LabelPartOf    = "app.kubernetes.io/part-of"
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+)
+
+// Options of a generation
+type Options struct {
+	Name      string            // Application name, the part-of label and the prefix of the object names if set
+	Namespace string            // Namespace of the objects, the one of the kubectl context if empty
+	Images    map[string]string // Registry image of a service, for the run.yml referencing image archives
+}
+
+// Result is the generated objects and the approximations made to produce them
+type Result struct {
+	Objects  []Object
+	Warnings []string
+}
+
+// Object is a generated Kubernetes object
+type Object interface {
+	GetKind() string
+	GetName() string
+}
+
+// YAML encodes the objects as a multi-document YAML file, ready for `kubectl apply -f`
+func (r *Result) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	for i, object := range r.Objects {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(object); err != nil {
+			return nil, fmt.Errorf("cannot encode the %s '%s': %w", object.GetKind(), object.GetName(), err)
+		}
+		encoder.Close()
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *Result) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ObjectMeta is the metadata of an object
+type ObjectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// TypeMeta is the kind of an object
+type TypeMeta struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+func (t TypeMeta) GetKind() string { return t.Kind }
+
+// Secret holds the environment or the secret files of a service
+type Secret struct {
+	TypeMeta   `yaml:",inline"`
+	Metadata   ObjectMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data,omitempty"`       // Base64 encoded values
+	StringData map[string]string `yaml:"stringData,omitempty"` // Clear values, encoded by the API server
+}
+
+func (s *Secret) GetName() string { return s.Metadata.Name }
+
+// Service exposes the ports of a service under its name, like the networks of the run.yml
+type Service struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta  `yaml:"metadata"`
+	Spec     ServiceSpec `yaml:"spec"`
+}
+
+func (s *Service) GetName() string { return s.Metadata.Name }
+
+type ServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []ServicePort     `yaml:"ports"`
+}
+
+type ServicePort struct {
+	Name       string `yaml:"name"`
+	Protocol   string `yaml:"protocol"`
+	Port       int    `yaml:"port"`
+	TargetPort int    `yaml:"targetPort"`
+}
+
+// Deployment runs the container of a service
+type Deployment struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta     `yaml:"metadata"`
+	Spec     DeploymentSpec `yaml:"spec"`
+}
+
+func (d *Deployment) GetName() string { return d.Metadata.Name }
+
+type DeploymentSpec struct {
+	Replicas int             `yaml:"replicas"`
+	Selector LabelSelector   `yaml:"selector"`
+	Template PodTemplateSpec `yaml:"template"`
+}
+
+type LabelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type PodTemplateSpec struct {
+	Metadata ObjectMeta `yaml:"metadata"`
+	Spec     PodSpec    `yaml:"spec"`
+}
+
+type PodSpec struct {
+	HostNetwork bool        `yaml:"hostNetwork,omitempty"`
+	Containers  []Container `yaml:"containers"`
+	Volumes     []Volume    `yaml:"volumes,omitempty"`
+}
+
+type Container struct {
+	Name            string                `yaml:"name"`
+	Image           string                `yaml:"image"`
+	Command         []string              `yaml:"command,omitempty"` // The entrypoint of the image
+	Args            []string              `yaml:"args,omitempty"`    // The command of the image
+	WorkingDir      string                `yaml:"workingDir,omitempty"`
+	EnvFrom         []EnvFromSource       `yaml:"envFrom,omitempty"`
+	Ports           []ContainerPort       `yaml:"ports,omitempty"`
+	Resources       *ResourceRequirements `yaml:"resources,omitempty"`
+	VolumeMounts    []VolumeMount         `yaml:"volumeMounts,omitempty"`
+	ReadinessProbe  *Probe                `yaml:"readinessProbe,omitempty"`
+	SecurityContext *SecurityContext      `yaml:"securityContext,omitempty"`
+}
+
+type EnvFromSource struct {
+	SecretRef struct {
+		Name string `yaml:"name"`
+	} `yaml:"secretRef"`
+}
+
+type ContainerPort struct {
+	ContainerPort int    `yaml:"containerPort"`
+	Protocol      string `yaml:"protocol"`
+}
+
+type ResourceRequirements struct {
+	Limits   map[string]string `yaml:"limits,omitempty"`
+	Requests map[string]string `yaml:"requests,omitempty"`
+}
+
+type VolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type Volume struct {
+	Name     string          `yaml:"name"`
+	EmptyDir *EmptyDirSource `yaml:"emptyDir,omitempty"`
+	Secret   *SecretSource   `yaml:"secret,omitempty"`
+}
+
+type EmptyDirSource struct {
+	Medium string `yaml:"medium,omitempty"`
+}
+
+type SecretSource struct {
+	SecretName  string `yaml:"secretName"`
+	DefaultMode int    `yaml:"defaultMode,omitempty"`
+}
+
+type Probe struct {
+	Exec struct {
+		Command []string `yaml:"command"`
+	} `yaml:"exec"`
+	InitialDelaySeconds int `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int `yaml:"failureThreshold,omitempty"`
+}
+
+type SecurityContext struct {
+	RunAsUser  *int64 `yaml:"runAsUser,omitempty"`
+	RunAsGroup *int64 `yaml:"runAsGroup,omitempty"`
+}
+
+// Generate converts the services of a run.yml to Kubernetes objects, sorted by service
+func Generate(runConfig *build.RunYAML, opts Options) (*Result, error) {
+	result := &Result{}
+	names := make([]string, 0, len(runConfig.Services))
+	for name := range runConfig.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := result.addService(name, runConfig.Services[name], opts); err != nil {
+			return nil, fmt.Errorf("cannot convert the service '%s': %w", name, err)
+		}
+	}
+	return result, nil
+}
+
+func (r *Result) addService(serviceName string, service build.RunService, opts Options) error {
+	name := objectName(opts.Name, serviceName)
+	labels := map[string]string{LabelName: objectName("", serviceName), LabelManagedBy: "bx"}
+	if opts.Name != "" {
+		labels[LabelPartOf] = objectName("", opts.Name)
+	}
+	meta := func(objectName string) ObjectMeta {
+		return ObjectMeta{Name: objectName, Namespace: opts.Namespace, Labels: labels}
+	}
+
+	image := service.Image
+	if override, ok := opts.Images[serviceName]; ok {
+		image = override
+	} else if build.IsImageArchive(image) || strings.HasPrefix(image, build.B2Scheme) {
+		r.warnf("service '%s' references the image archive '%s', push the image to a registry and set it with the images option", serviceName, image)
+	}
+
+	container := Container{
+		Name:       objectName("", serviceName),
+		Image:      image,
+		Command:    service.Entrypoint,
+		Args:       service.Command,
+		WorkingDir: service.WorkingDir,
+	}
+	pod := PodSpec{}
+
+	// The environment holds the runtime secrets, it is kept in a Secret
+	if len(service.Environment) > 0 {
+		secret := &Secret{TypeMeta: TypeMeta{"v1", "Secret"}, Metadata: meta(name + "-env"), Type: "Opaque", StringData: service.Environment}
+		r.Objects = append(r.Objects, secret)
+		var envFrom EnvFromSource
+		envFrom.SecretRef.Name = secret.Metadata.Name
+		container.EnvFrom = append(container.EnvFrom, envFrom)
+	}
+
+	if len(service.SecretFiles) > 0 {
+		secret := &Secret{TypeMeta: TypeMeta{"v1", "Secret"}, Metadata: meta(name + "-files"), Type: "Opaque", Data: make(map[string]string)}
+		for _, file := range service.SecretFiles {
+			key := objectName("", file.Name)
+			secret.Data[key] = file.Content
+			container.VolumeMounts = append(container.VolumeMounts, VolumeMount{Name: "secret-files", MountPath: file.Target, SubPath: key, ReadOnly: true})
+		}
+		r.Objects = append(r.Objects, secret)
+		pod.Volumes = append(pod.Volumes, Volume{Name: "secret-files", Secret: &SecretSource{SecretName: secret.Metadata.Name, DefaultMode: 0400}})
+	}
+
+	var servicePorts []ServicePort
+	for _, spec := range service.Ports {
+		mappings, err := nat.ParsePortSpec(spec)
+		if err != nil {
+			return fmt.Errorf("invalid port '%s': %w", spec, err)
+		}
+		for _, mapping := range mappings {
+			protocol := strings.ToUpper(mapping.Port.Proto())
+			containerPort := mapping.Port.Int()
+			container.Ports = append(container.Ports, ContainerPort{ContainerPort: containerPort, Protocol: protocol})
+			// The service listens on the published port, the other pods reach it by the service name
+			port := containerPort
+			if hostPort, err := strconv.Atoi(mapping.Binding.HostPort); err == nil && hostPort > 0 {
+				port = hostPort
+			}
+			servicePorts = append(servicePorts, ServicePort{
+				Name:       fmt.Sprintf("%s-%d", strings.ToLower(protocol), port),
+				Protocol:   protocol,
+				Port:       port,
+				TargetPort: containerPort,
+			})
+		}
+	}
+
+	for i, volume := range service.Volumes {
+		source, target, ok := strings.Cut(volume, ":")
+		if !ok {
+			source, target = "", volume
+		}
+		target, _, _ = strings.Cut(target, ":")
+		if source != "" && (path.IsAbs(source) || strings.HasPrefix(source, ".")) {
+			r.warnf("service '%s': the bind mount '%s' is skipped, host paths are not portable in a cluster", serviceName, volume)
+			continue
+		}
+		r.warnf("service '%s': the volume '%s' becomes an emptyDir, replace it with a PersistentVolumeClaim to keep its data", serviceName, volume)
+		volumeName := fmt.Sprintf("volume-%d", i)
+		if source != "" {
+			volumeName = objectName("", source)
+		}
+		pod.Volumes = append(pod.Volumes, Volume{Name: volumeName, EmptyDir: &EmptyDirSource{}})
+		container.VolumeMounts = append(container.VolumeMounts, VolumeMount{Name: volumeName, MountPath: target})
+	}
+	for i, target := range service.Tmpfs {
+		volumeName := fmt.Sprintf("tmpfs-%d", i)
+		pod.Volumes = append(pod.Volumes, Volume{Name: volumeName, EmptyDir: &EmptyDirSource{Medium: "Memory"}})
+		container.VolumeMounts = append(container.VolumeMounts, VolumeMount{Name: volumeName, MountPath: target})
+	}
+
+	if resources := service.Resources; resources != nil {
+		requirements := &ResourceRequirements{}
+		if resources.CPUs > 0 || resources.Memory > 0 {
+			requirements.Limits = make(map[string]string)
+			if resources.CPUs > 0 {
+				requirements.Limits["cpu"] = fmt.Sprintf("%dm", int64(resources.CPUs*1000))
+			}
+			if resources.Memory > 0 {
+				requirements.Limits["memory"] = strconv.FormatInt(resources.Memory, 10)
+			}
+		}
+		if resources.MemoryReservation > 0 {
+			requirements.Requests = map[string]string{"memory": strconv.FormatInt(resources.MemoryReservation, 10)}
+		}
+		container.Resources = requirements
+	}
+
+	probe, err := readinessProbe(service.HealthCheck)
+	if err != nil {
+		return fmt.Errorf("invalid healthcheck: %w", err)
+	}
+	container.ReadinessProbe = probe
+
+	if service.User != "" {
+		securityContext, ok := runAsUser(service.User)
+		if ok {
+			container.SecurityContext = securityContext
+		} else {
+			r.warnf("service '%s': the user '%s' is not numeric, Kubernetes only runs as a uid[:gid]", serviceName, service.User)
+		}
+	}
+
+	switch service.NetworkMode {
+	case "", "bridge":
+	case "host":
+		pod.HostNetwork = true
+	default:
+		r.warnf("service '%s': the network mode '%s' has no Kubernetes equivalent", serviceName, service.NetworkMode)
+	}
+	switch service.Restart {
+	case "", "always", "unless-stopped":
+	default:
+		r.warnf("service '%s': the restart policy '%s' becomes 'Always', a Deployment restarts its containers", serviceName, service.Restart)
+	}
+	if len(service.DependsOn) > 0 {
+		r.warnf("service '%s': depends_on is ignored, the pods start together and must retry their dependencies", serviceName)
+	}
+
+	pod.Containers = []Container{container}
+	selector := map[string]string{LabelName: labels[LabelName]}
+	if partOf, ok := labels[LabelPartOf]; ok {
+		selector[LabelPartOf] = partOf
+	}
+	deployment := &Deployment{
+		TypeMeta: TypeMeta{"apps/v1", "Deployment"},
+		Metadata: meta(name),
+		Spec: DeploymentSpec{
+			Replicas: 1,
+			Selector: LabelSelector{MatchLabels: selector},
+			Template: PodTemplateSpec{Metadata: ObjectMeta{Labels: labels, Annotations: service.Labels}, Spec: pod},
+		},
+	}
+	r.Objects = append(r.Objects, deployment)
+
+	if len(servicePorts) > 0 {
+		r.Objects = append(r.Objects, &Service{
+			TypeMeta: TypeMeta{"v1", "Service"},
+			Metadata: meta(objectName("", serviceName)),
+			Spec:     ServiceSpec{Selector: selector, Ports: servicePorts},
+		})
+	}
+	return nil
+}
+
+// readinessProbe translates a run.yml healthcheck, the container is out of the Service while it is unhealthy
+func readinessProbe(check *build.HealthCheck) (*Probe, error) {
+	if check == nil || check.Disable || len(check.Test) == 0 {
+		return nil, nil
+	}
+	probe := &Probe{}
+	switch check.Test[0] {
+	case "NONE":
+		return nil, nil
+	case "CMD":
+		probe.Exec.Command = check.Test[1:]
+	case "CMD-SHELL":
+		probe.Exec.Command = []string{"/bin/sh", "-c", strings.Join(check.Test[1:], " ")}
+	default:
+		probe.Exec.Command = []string{"/bin/sh", "-c", strings.Join(check.Test, " ")}
+	}
+	durations := []struct {
+		value  string
+		target *int
+	}{
+		{check.Interval, &probe.PeriodSeconds},
+		{check.Timeout, &probe.TimeoutSeconds},
+		{check.StartPeriod, &probe.InitialDelaySeconds},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, err
+		}
+		// Kubernetes counts in whole seconds, at least one
+		*d.target = max(1, int((parsed+time.Second-1)/time.Second))
+	}
+	if check.Retries != nil {
+		probe.FailureThreshold = *check.Retries
+	}
+	return probe, nil
+}
+
+// runAsUser parses a numeric "uid[:gid]" user
+func runAsUser(user string) (*SecurityContext, bool) {
+	uidValue, gidValue, hasGID := strings.Cut(user, ":")
+	uid, err := strconv.ParseInt(uidValue, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	securityContext := &SecurityContext{RunAsUser: &uid}
+	if hasGID {
+		gid, err := strconv.ParseInt(gidValue, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		securityContext.RunAsGroup = &gid
+	}
+	return securityContext, true
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// objectName returns a DNS-1123 name ("my_app", "api" -> "my-app-api")
+func objectName(prefix, name string) string {
+	if prefix != "" {
+		name = prefix + "-" + name
+	}
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
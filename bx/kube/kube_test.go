@@ -0,0 +1,112 @@
+package kube
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGenerate(t *testing.T) {
+	retries := 3
+	runConfig := &build.RunYAML{
+		Version: "1.0",
+		Services: map[string]build.RunService{
+			"api": {
+				Image:       "registry.example.com/shop/api:1.2",
+				Entrypoint:  []string{"/app/api"},
+				Command:     []string{"--verbose"},
+				Environment: map[string]string{"DB_PASSWORD": "hunter2"},
+				Ports:       []string{"8080:80", "9090/udp"},
+				Volumes:     []string{"data:/var/lib/api", "/srv/logs:/logs"},
+				DependsOn:   build.Dependencies{{Service: "db"}},
+				SecretFiles: []build.RunSecretFile{{Name: "tls_key", Target: "/run/secrets/tls.key", Content: "a2V5"}},
+				HealthCheck: &build.HealthCheck{Test: []string{"CMD-SHELL", "curl -f localhost"}, Interval: "30s", Timeout: "500ms", Retries: &retries},
+				User:        "1000:1000",
+				Resources:   &build.ServiceResources{CPUs: 0.5, Memory: 256 << 20},
+			},
+			"worker_jobs": {Image: "/out/shop-worker-1.2.tar", Tmpfs: []string{"/tmp"}},
+		},
+	}
+
+	result, err := Generate(runConfig, Options{Name: "shop", Namespace: "prod"})
+	require.NoError(t, err)
+
+	kinds := make([]string, 0, len(result.Objects))
+	for _, object := range result.Objects {
+		kinds = append(kinds, object.GetKind()+"/"+object.GetName())
+	}
+	assert.Equal(t, []string{
+		"Secret/shop-api-env", "Secret/shop-api-files", "Deployment/shop-api", "Service/api", "Deployment/shop-worker-jobs",
+	}, kinds)
+
+	deployment := result.Objects[2].(*Deployment)
+	assert.Equal(t, "prod", deployment.Metadata.Namespace)
+	assert.Equal(t, map[string]string{LabelName: "api", LabelPartOf: "shop"}, deployment.Spec.Selector.MatchLabels)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"/app/api"}, container.Command)
+	assert.Equal(t, []string{"--verbose"}, container.Args)
+	assert.Equal(t, "shop-api-env", container.EnvFrom[0].SecretRef.Name)
+	assert.Equal(t, []ContainerPort{{ContainerPort: 80, Protocol: "TCP"}, {ContainerPort: 9090, Protocol: "UDP"}}, container.Ports)
+	assert.Equal(t, map[string]string{"cpu": "500m", "memory": "268435456"}, container.Resources.Limits)
+	assert.Equal(t, []string{"/bin/sh", "-c", "curl -f localhost"}, container.ReadinessProbe.Exec.Command)
+	assert.Equal(t, 30, container.ReadinessProbe.PeriodSeconds)
+	assert.Equal(t, 1, container.ReadinessProbe.TimeoutSeconds)
+	assert.Equal(t, 3, container.ReadinessProbe.FailureThreshold)
+	assert.Equal(t, int64(1000), *container.SecurityContext.RunAsUser)
+	assert.Equal(t, []VolumeMount{
+		{Name: "secret-files", MountPath: "/run/secrets/tls.key", SubPath: "tls-key", ReadOnly: true},
+		{Name: "data", MountPath: "/var/lib/api"},
+	}, container.VolumeMounts)
+
+	service := result.Objects[3].(*Service)
+	assert.Equal(t, []ServicePort{
+		{Name: "tcp-8080", Protocol: "TCP", Port: 8080, TargetPort: 80},
+		{Name: "udp-9090", Protocol: "UDP", Port: 9090, TargetPort: 9090},
+	}, service.Spec.Ports)
+
+	worker := result.Objects[4].(*Deployment).Spec.Template.Spec
+	assert.Equal(t, "Memory", worker.Volumes[0].EmptyDir.Medium)
+
+	warnings := strings.Join(result.Warnings, "\n")
+	assert.Contains(t, warnings, "bind mount '/srv/logs:/logs'")
+	assert.Contains(t, warnings, "volume 'data:/var/lib/api' becomes an emptyDir")
+	assert.Contains(t, warnings, "depends_on")
+	assert.Contains(t, warnings, "image archive '/out/shop-worker-1.2.tar'")
+}
+
+func TestGenerateImageOverride(t *testing.T) {
+	runConfig := &build.RunYAML{Services: map[string]build.RunService{"web": {Image: "b2://bucket/web.tar.zst"}}}
+	result, err := Generate(runConfig, Options{Images: map[string]string{"web": "ghcr.io/acme/web:1.0"}})
+	require.NoError(t, err)
+	assert.Empty(t, result.Warnings)
+	assert.Equal(t, "ghcr.io/acme/web:1.0", result.Objects[0].(*Deployment).Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestResultYAML(t *testing.T) {
+	runConfig := &build.RunYAML{Services: map[string]build.RunService{
+		"web": {Image: "nginx:1.27", Ports: []string{"80"}, Environment: map[string]string{"MODE": "prod"}},
+	}}
+	result, err := Generate(runConfig, Options{})
+	require.NoError(t, err)
+	data, err := result.YAML()
+	require.NoError(t, err)
+
+	documents := strings.Split(string(data), "---\n")
+	require.Len(t, documents, 3)
+	var deployment map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(documents[1]), &deployment))
+	assert.Equal(t, "apps/v1", deployment["apiVersion"])
+	assert.Equal(t, "Deployment", deployment["kind"])
+	assert.Contains(t, documents[0], "stringData:\n  MODE: prod")
+}
+
+func TestObjectName(t *testing.T) {
+	assert.Equal(t, "my-app-api", objectName("my_app", "API"))
+	assert.Equal(t, "web", objectName("", "-web."))
+	assert.Len(t, objectName("", strings.Repeat("a", 80)), 63)
+}
@@ -0,0 +1,274 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Notification is a build event sent to the channels
+type Notification struct {
+	BuildID  string    `json:"build_id"`
+	Project  string    `json:"project"`
+	Branch   string    `json:"branch,omitempty"`
+	Status   string    `json:"status"` // "success", "failure", "canceled"
+	Message  string    `json:"message,omitempty"`
+	Time     time.Time `json:"time"`
+	Repeated int       `json:"repeated,omitempty"` // Identical consecutive failures suppressed before this one
+}
+
+// Sender delivers notifications to an external service. A batch holds more than one
+// notification only when the channel is in digest mode.
+type Sender interface {
+	Send(ctx context.Context, batch []Notification) error
+}
+
+// MuteRule silences the notifications of a project and/or branch. Empty fields match everything,
+// the others are path.Match patterns (e.g. "feature/*").
+type MuteRule struct {
+	Project string `yaml:"project,omitempty"`
+	Branch  string `yaml:"branch,omitempty"`
+}
+
+// ChannelConfig describes one notification channel
+type ChannelConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`              // "webhook"
+	URL     string            `yaml:"url"`               // Webhook endpoint
+	On      []string          `yaml:"on,omitempty"`      // Statuses to notify, all by default
+	Digest  string            `yaml:"digest,omitempty"`  // Summary interval (e.g. "10m"), notifications are sent one by one if empty
+	Dedup   bool              `yaml:"dedup,omitempty"`   // Drop the identical consecutive failures of a project/branch
+	Mute    []MuteRule        `yaml:"mute,omitempty"`    // Muted projects/branches
+	Headers map[string]string `yaml:"headers,omitempty"` // Extra HTTP headers (e.g. an auth token)
+}
+
+// Config is the layout of the notifications file
+type Config struct {
+	Channels []ChannelConfig `yaml:"channels"`
+}
+
+// LoadConfig reads the notification channels declared in a YAML file
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the notifications file '%s': %w", filename, err)
+	}
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("notifications file parsing failed '%s': %w", filename, err)
+	}
+	return &config, nil
+}
+
+// channel applies the filtering, dedup and digest rules before calling its sender
+type channel struct {
+	config ChannelConfig
+	sender Sender
+	digest time.Duration
+
+	mu           sync.Mutex
+	pending      []Notification
+	lastFailures map[string]string // project/branch -> last failure message
+	suppressed   map[string]int    // project/branch -> failures dropped since the last sent one
+}
+
+// Dispatcher fans the notifications out to the configured channels
+type Dispatcher struct {
+	channels []*channel
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewDispatcher creates the channels of the config and starts the digest timers
+func NewDispatcher(config *Config) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for _, channelConfig := range config.Channels {
+		sender, err := newSender(channelConfig)
+		if err != nil {
+			return nil, fmt.Errorf("channel '%s': %w", channelConfig.Name, err)
+		}
+		if err := d.AddChannel(channelConfig, sender); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// AddChannel registers a channel with a custom sender
+func (d *Dispatcher) AddChannel(config ChannelConfig, sender Sender) error {
+	var digest time.Duration
+	if config.Digest != "" {
+		var err error
+		if digest, err = time.ParseDuration(config.Digest); err != nil || digest <= 0 {
+			return fmt.Errorf("channel '%s': invalid digest interval '%s'", config.Name, config.Digest)
+		}
+	}
+	for _, rule := range config.Mute {
+		if _, err := path.Match(rule.Project, ""); err != nil {
+			return fmt.Errorf("channel '%s': invalid mute pattern '%s': %w", config.Name, rule.Project, err)
+		}
+		if _, err := path.Match(rule.Branch, ""); err != nil {
+			return fmt.Errorf("channel '%s': invalid mute pattern '%s': %w", config.Name, rule.Branch, err)
+		}
+	}
+	if d.ctx == nil {
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+	}
+
+	c := &channel{
+		config:       config,
+		sender:       sender,
+		digest:       digest,
+		lastFailures: make(map[string]string),
+		suppressed:   make(map[string]int),
+	}
+	d.channels = append(d.channels, c)
+	if digest > 0 {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			c.runDigest(d.ctx)
+		}()
+	}
+	return nil
+}
+
+// Notify sends the notification to every channel accepting it
+func (d *Dispatcher) Notify(ctx context.Context, n Notification) {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	for _, c := range d.channels {
+		c.notify(ctx, n)
+	}
+}
+
+// Close stops the digest timers and flushes the pending digests
+func (d *Dispatcher) Close() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (c *channel) notify(ctx context.Context, n Notification) {
+	if c.muted(n) {
+		return
+	}
+
+	c.mu.Lock()
+	// The dedup sees every status so a filtered out success still ends a failure streak
+	if c.config.Dedup && c.isRepeatedLocked(&n) || !c.wanted(n) {
+		c.mu.Unlock()
+		return
+	}
+	if c.digest > 0 {
+		c.pending = append(c.pending, n)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	c.send(ctx, []Notification{n})
+}
+
+// muted checks the mute rules
+func (c *channel) muted(n Notification) bool {
+	for _, rule := range c.config.Mute {
+		if matchPattern(rule.Project, n.Project) && matchPattern(rule.Branch, n.Branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// wanted checks the status filter
+func (c *channel) wanted(n Notification) bool {
+	if len(c.config.On) == 0 {
+		return true
+	}
+	for _, status := range c.config.On {
+		if status == n.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// isRepeatedLocked reports if n repeats the last failure of its project/branch, and records it.
+// c.mu must be held.
+func (c *channel) isRepeatedLocked(n *Notification) bool {
+	key := n.Project + "@" + n.Branch
+	if n.Status != "failure" {
+		delete(c.lastFailures, key) // A success (or cancel) ends the failure streak
+		delete(c.suppressed, key)
+		return false
+	}
+	if last, ok := c.lastFailures[key]; ok && last == n.Message {
+		c.suppressed[key]++
+		return true
+	}
+	c.lastFailures[key] = n.Message
+	n.Repeated = c.suppressed[key]
+	delete(c.suppressed, key)
+	return false
+}
+
+func (c *channel) runDigest(ctx context.Context) {
+	ticker := time.NewTicker(c.digest)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush(ctx)
+		case <-ctx.Done():
+			c.flush(context.Background())
+			return
+		}
+	}
+}
+
+// flush sends the pending digest, if any
+func (c *channel) flush(ctx context.Context) {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(batch) > 0 {
+		c.send(ctx, batch)
+	}
+}
+
+func (c *channel) send(ctx context.Context, batch []Notification) {
+	if err := c.sender.Send(ctx, batch); err != nil {
+		log.Printf("Notify: channel '%s' failed to send %d notification(s): %v\n", c.config.Name, len(batch), err)
+	}
+}
+
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+func newSender(config ChannelConfig) (Sender, error) {
+	switch config.Type {
+	case "webhook", "":
+		if config.URL == "" {
+			return nil, fmt.Errorf("the field 'url' is required")
+		}
+		return &WebhookSender{URL: config.URL, Headers: config.Headers}, nil
+	default:
+		return nil, fmt.Errorf("unsupported channel type '%s'", config.Type)
+	}
+}
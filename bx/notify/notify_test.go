@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSender struct {
+	mu      sync.Mutex
+	batches [][]Notification
+}
+
+func (r *recordingSender) Send(ctx context.Context, batch []Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, batch)
+	return nil
+}
+
+func TestDispatcher_DedupMuteAndDigest(t *testing.T) {
+	ctx := context.Background()
+	direct := &recordingSender{}
+	digest := &recordingSender{}
+
+	d := &Dispatcher{}
+	require.NoError(t, d.AddChannel(ChannelConfig{
+		Name:  "alerts",
+		On:    []string{"failure"},
+		Dedup: true,
+		Mute:  []MuteRule{{Project: "sandbox"}, {Project: "shop", Branch: "feature/*"}},
+	}, direct))
+	require.NoError(t, d.AddChannel(ChannelConfig{Name: "daily", Digest: "1h"}, digest))
+
+	d.Notify(ctx, Notification{BuildID: "1", Project: "shop", Branch: "main", Status: "failure", Message: "tests failed"})
+	d.Notify(ctx, Notification{BuildID: "2", Project: "shop", Branch: "main", Status: "failure", Message: "tests failed"})
+	d.Notify(ctx, Notification{BuildID: "3", Project: "shop", Branch: "main", Status: "failure", Message: "tests failed"})
+	d.Notify(ctx, Notification{BuildID: "4", Project: "shop", Branch: "main", Status: "failure", Message: "lint failed"})
+	d.Notify(ctx, Notification{BuildID: "5", Project: "shop", Branch: "feature/cart", Status: "failure", Message: "x"})
+	d.Notify(ctx, Notification{BuildID: "6", Project: "sandbox", Branch: "main", Status: "failure", Message: "x"})
+	d.Notify(ctx, Notification{BuildID: "7", Project: "shop", Branch: "main", Status: "success"})
+	d.Notify(ctx, Notification{BuildID: "8", Project: "shop", Branch: "main", Status: "failure", Message: "lint failed"})
+
+	require.Len(t, direct.batches, 3)
+	assert.Equal(t, "1", direct.batches[0][0].BuildID)
+	assert.Equal(t, "4", direct.batches[1][0].BuildID)
+	assert.Equal(t, 2, direct.batches[1][0].Repeated, "the two suppressed failures are reported with the next one")
+	assert.Equal(t, "8", direct.batches[2][0].BuildID, "a success resets the dedup")
+
+	assert.Empty(t, digest.batches, "the digest is only sent on its interval")
+	d.Close()
+	require.Len(t, digest.batches, 1)
+	assert.Len(t, digest.batches[0], 8)
+	assert.Equal(t, "8 builds: 7 failure, 1 success", Summary(digest.batches[0]))
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookSender posts the notifications as JSON to an HTTP endpoint
+type WebhookSender struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client // http.Client with a 10s timeout if nil
+}
+
+// webhookPayload is the posted body. Digest is true when the batch comes from a digest.
+type webhookPayload struct {
+	Digest        bool           `json:"digest"`
+	Summary       string         `json:"summary"`
+	Notifications []Notification `json:"notifications"`
+}
+
+func (w *WebhookSender) Send(ctx context.Context, batch []Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		Digest:        len(batch) > 1,
+		Summary:       Summary(batch),
+		Notifications: batch,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot encode the notifications: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Summary describes a batch in one line, e.g. "3 builds: 2 success, 1 failure"
+func Summary(batch []Notification) string {
+	if len(batch) == 1 {
+		n := batch[0]
+		summary := fmt.Sprintf("Build %s of %s: %s", n.BuildID, n.Project, n.Status)
+		if n.Repeated > 0 {
+			summary += fmt.Sprintf(" (%d identical failure(s) suppressed)", n.Repeated)
+		}
+		return summary
+	}
+	counts := make(map[string]int)
+	var order []string
+	for _, n := range batch {
+		if counts[n.Status] == 0 {
+			order = append(order, n.Status)
+		}
+		counts[n.Status]++
+	}
+	summary := fmt.Sprintf("%d builds:", len(batch))
+	for i, status := range order {
+		if i > 0 {
+			summary += ","
+		}
+		summary += fmt.Sprintf(" %d %s", counts[status], status)
+	}
+	return summary
+}
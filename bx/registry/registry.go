@@ -0,0 +1,381 @@
+// Package registry implements a minimal Docker Registry HTTP API v2 over an object storage,
+// enough for `docker push` and `docker pull` of the images built by bx.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Treefle-labs/Anexis/bx/storage"
+	"github.com/Treefle-labs/Anexis/socket"
+)
+
+// Largest manifest accepted by PUT
+const maxManifestSize = 4 << 20
+
+// Media type of the manifests which do not declare one
+const defaultManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+var (
+	nameRegexp   = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+	tagRegexp    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Handler serves the /v2/ API. Blobs are shared between repositories, manifests and tags are per repository:
+//
+//	blobs/sha256/<hex>
+//	repositories/<name>/manifests/sha256/<hex>
+//	repositories/<name>/tags/<tag>            (content: the manifest digest)
+type Handler struct {
+	store     storage.Store
+	auth      socket.Authenticator
+	uploadDir string
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+// NewHandler serves the registry from the store. The uploads in progress are kept in uploadDir,
+// a temporary directory is used if empty.
+func NewHandler(store storage.Store, uploadDir string) (*Handler, error) {
+	var err error
+	if uploadDir == "" {
+		uploadDir, err = os.MkdirTemp("", "bx-registry-uploads-")
+	} else {
+		err = os.MkdirAll(uploadDir, 0700)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the upload directory: %w", err)
+	}
+	return &Handler{store: store, uploadDir: uploadDir, uploads: make(map[string]*upload)}, nil
+}
+
+// SetAuthenticator requires valid credentials on every request, nil leaves the registry open
+func (h *Handler) SetAuthenticator(auth socket.Authenticator) {
+	h.auth = auth
+}
+
+// Close discards the uploads in progress
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.uploads = make(map[string]*upload)
+	return os.RemoveAll(h.uploadDir)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if h.auth != nil {
+		if _, err := h.auth.Authenticate(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bx registry"`)
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+			return
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == r.URL.Path {
+		writeError(w, http.StatusNotFound, "UNSUPPORTED", "not a registry v2 path")
+		return
+	}
+	if path == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+
+	name, kind, ref, ok := splitPath(path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "UNSUPPORTED", "unknown endpoint")
+		return
+	}
+	if !nameRegexp.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "NAME_INVALID", fmt.Sprintf("invalid repository name '%s'", name))
+		return
+	}
+
+	switch {
+	case kind == "tags" && r.Method == http.MethodGet:
+		h.listTags(w, r, name)
+	case kind == "manifests" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.getManifest(w, r, name, ref)
+	case kind == "manifests" && r.Method == http.MethodPut:
+		h.putManifest(w, r, name, ref)
+	case kind == "manifests" && r.Method == http.MethodDelete:
+		h.deleteManifest(w, r, name, ref)
+	case kind == "blobs" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.getBlob(w, r, ref)
+	case kind == "uploads" && ref == "" && r.Method == http.MethodPost:
+		h.startUpload(w, r, name)
+	case kind == "uploads" && ref != "" && r.Method == http.MethodPatch:
+		h.patchUpload(w, r, name, ref)
+	case kind == "uploads" && ref != "" && r.Method == http.MethodPut:
+		h.finishUpload(w, r, name, ref)
+	case kind == "uploads" && ref != "" && r.Method == http.MethodGet:
+		h.uploadStatus(w, r, name, ref)
+	case kind == "uploads" && ref != "" && r.Method == http.MethodDelete:
+		h.cancelUpload(w, r, ref)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", fmt.Sprintf("%s is not supported on this endpoint", r.Method))
+	}
+}
+
+// splitPath splits "<name>/<endpoint>/<ref>", the repository name may contain slashes
+func splitPath(path string) (name, kind, ref string, ok bool) {
+	if name, ok = strings.CutSuffix(path, "/tags/list"); ok {
+		return name, "tags", "", true
+	}
+	if i := strings.LastIndex(path, "/blobs/uploads"); i > 0 {
+		ref = strings.Trim(path[i+len("/blobs/uploads"):], "/")
+		return path[:i], "uploads", ref, !strings.Contains(ref, "/")
+	}
+	for _, kind := range []string{"blobs", "manifests"} {
+		if i := strings.LastIndex(path, "/"+kind+"/"); i > 0 {
+			ref = path[i+len(kind)+2:]
+			return path[:i], kind, ref, ref != "" && !strings.Contains(ref, "/")
+		}
+	}
+	return "", "", "", false
+}
+
+func blobKey(digest string) string {
+	return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+func manifestKey(name, digest string) string {
+	return "repositories/" + name + "/manifests/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+func tagKey(name, tag string) string {
+	return "repositories/" + name + "/tags/" + tag
+}
+
+// --- Blobs ---
+
+func (h *Handler) getBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	if !digestRegexp.MatchString(digest) {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("unsupported digest '%s'", digest))
+		return
+	}
+	info, err := h.store.Stat(r.Context(), blobKey(digest))
+	if err != nil {
+		h.storageError(w, err, "BLOB_UNKNOWN", digest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(info.Size))
+	w.Header().Set("Docker-Content-Digest", digest)
+	if r.Method == http.MethodHead {
+		return
+	}
+	h.copyObject(w, r.Context(), blobKey(digest))
+}
+
+// --- Manifests ---
+
+// resolveManifest returns the digest designated by a tag or a digest reference
+func (h *Handler) resolveManifest(ctx context.Context, name, ref string) (string, error) {
+	if digestRegexp.MatchString(ref) {
+		return ref, nil
+	}
+	if !tagRegexp.MatchString(ref) {
+		return "", fmt.Errorf("%w: invalid reference '%s'", storage.ErrNotFound, ref)
+	}
+	rc, err := h.store.Get(ctx, tagKey(name, ref))
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, 256))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (h *Handler) getManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	digest, err := h.resolveManifest(r.Context(), name, ref)
+	if err != nil {
+		h.storageError(w, err, "MANIFEST_UNKNOWN", ref)
+		return
+	}
+	rc, err := h.store.Get(r.Context(), manifestKey(name, digest))
+	if err != nil {
+		h.storageError(w, err, "MANIFEST_UNKNOWN", ref)
+		return
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", manifestMediaType(data))
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	w.Header().Set("Docker-Content-Digest", digest)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (h *Handler) putManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	if len(data) > maxManifestSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "SIZE_INVALID", "manifest too large")
+		return
+	}
+	if !json.Valid(data) {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", "the manifest is not valid JSON")
+		return
+	}
+	digest := digestOf(data)
+	isDigest := digestRegexp.MatchString(ref)
+	if isDigest && ref != digest {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("the manifest digest is %s, not %s", digest, ref))
+		return
+	}
+	if !isDigest && !tagRegexp.MatchString(ref) {
+		writeError(w, http.StatusBadRequest, "TAG_INVALID", fmt.Sprintf("invalid tag '%s'", ref))
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.store.Put(ctx, manifestKey(name, digest), bytes.NewReader(data)); err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if !isDigest {
+		if err := h.store.Put(ctx, tagKey(name, ref), strings.NewReader(digest)); err != nil {
+			writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+	}
+	log.Printf("registry: pushed %s:%s (%s)\n", name, ref, digest)
+	w.Header().Set("Location", "/v2/"+name+"/manifests/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// deleteManifest removes a manifest by digest and the tags pointing to it, or a single tag
+func (h *Handler) deleteManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	ctx := r.Context()
+	if !digestRegexp.MatchString(ref) {
+		if _, err := h.store.Stat(ctx, tagKey(name, ref)); err != nil {
+			h.storageError(w, err, "MANIFEST_UNKNOWN", ref)
+			return
+		}
+		if err := h.store.Delete(ctx, tagKey(name, ref)); err != nil {
+			writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if _, err := h.store.Stat(ctx, manifestKey(name, ref)); err != nil {
+		h.storageError(w, err, "MANIFEST_UNKNOWN", ref)
+		return
+	}
+	tags, err := h.tags(ctx, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	for _, tag := range tags {
+		if digest, err := h.resolveManifest(ctx, name, tag); err == nil && digest == ref {
+			h.store.Delete(ctx, tagKey(name, tag))
+		}
+	}
+	if err := h.store.Delete(ctx, manifestKey(name, ref)); err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// manifestMediaType reads the mediaType field of a manifest
+func manifestMediaType(data []byte) string {
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(data, &manifest) == nil && manifest.MediaType != "" {
+		return manifest.MediaType
+	}
+	return defaultManifestMediaType
+}
+
+// --- Tags ---
+
+func (h *Handler) tags(ctx context.Context, name string) ([]string, error) {
+	prefix := tagKey(name, "")
+	keys, err := h.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, strings.TrimPrefix(key, prefix))
+	}
+	return tags, nil
+}
+
+func (h *Handler) listTags(w http.ResponseWriter, r *http.Request, name string) {
+	tags, err := h.tags(r.Context(), name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if len(tags) == 0 {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", fmt.Sprintf("repository '%s' not known", name))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"name": name, "tags": tags})
+}
+
+// --- Helpers ---
+
+func (h *Handler) copyObject(w http.ResponseWriter, ctx context.Context, key string) {
+	rc, err := h.store.Get(ctx, key)
+	if err != nil {
+		log.Printf("registry: cannot read '%s': %v\n", key, err)
+		return
+	}
+	defer rc.Close()
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("registry: cannot send '%s': %v\n", key, err)
+	}
+}
+
+// storageError answers 404 with the given code for a missing object, 500 otherwise
+func (h *Handler) storageError(w http.ResponseWriter, err error, code, ref string) {
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, code, fmt.Sprintf("'%s' not found", ref))
+		return
+	}
+	log.Printf("registry: storage error on '%s': %v\n", ref, err)
+	writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+}
+
+// writeError writes an error in the format of the registry API
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
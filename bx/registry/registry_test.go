@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Treefle-labs/Anexis/bx/storage"
+	"github.com/Treefle-labs/Anexis/socket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) (*httptest.Server, *Handler) {
+	store, err := storage.NewFSStore(t.TempDir())
+	require.NoError(t, err)
+	handler, err := NewHandler(store, t.TempDir())
+	require.NoError(t, err)
+	handler.SetAuthenticator(socket.NewTokenAuth("push-token"))
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, handler
+}
+
+func doRequest(t *testing.T, method, url string, body []byte, contentType string) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.SetBasicAuth("ci", "push-token")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRegistry_PushPull(t *testing.T) {
+	server, _ := newTestRegistry(t)
+
+	layer := []byte("layer content")
+	layerDigest := digestOf(layer)
+
+	// Chunked upload: POST, PATCH, PUT
+	resp := doRequest(t, http.MethodPost, server.URL+"/v2/team/app/blobs/uploads/", nil, "")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	resp = doRequest(t, http.MethodPatch, server.URL+location, layer[:5], "application/octet-stream")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "0-4", resp.Header.Get("Range"))
+	resp = doRequest(t, http.MethodPut, server.URL+location+"?digest="+layerDigest, layer[5:], "application/octet-stream")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, layerDigest, resp.Header.Get("Docker-Content-Digest"))
+
+	// Monolithic upload of the config with a wrong then a right digest
+	config := []byte(`{"architecture":"amd64"}`)
+	resp = doRequest(t, http.MethodPost, server.URL+"/v2/team/app/blobs/uploads/?digest="+layerDigest, config, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doRequest(t, http.MethodPost, server.URL+"/v2/team/app/blobs/uploads/?digest="+digestOf(config), config, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","layers":[]}`)
+	resp = doRequest(t, http.MethodPut, server.URL+"/v2/team/app/manifests/1.0", manifest, "application/vnd.docker.distribution.manifest.v2+json")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	manifestDigest := resp.Header.Get("Docker-Content-Digest")
+	assert.Equal(t, digestOf(manifest), manifestDigest)
+
+	// Pull
+	resp = doRequest(t, http.MethodGet, server.URL+"/v2/team/app/manifests/1.0", nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/vnd.docker.distribution.manifest.v2+json", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, manifest, body)
+
+	resp = doRequest(t, http.MethodHead, server.URL+"/v2/team/app/manifests/"+manifestDigest, nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/v2/team/app/blobs/"+layerDigest, nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, layer, body)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/v2/team/app/tags/list", nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tags struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tags))
+	assert.Equal(t, []string{"1.0"}, tags.Tags)
+
+	// Blobs are shared, another repository can mount them
+	resp = doRequest(t, http.MethodPost, server.URL+"/v2/other/blobs/uploads/?mount="+layerDigest+"&from=team/app", nil, "")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/v2/team/app/manifests/missing", nil, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRegistry_RequiresToken(t *testing.T) {
+	server, _ := newTestRegistry(t)
+
+	resp, err := http.Get(server.URL + "/v2/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/v2/", nil, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSplitPath(t *testing.T) {
+	name, kind, ref, ok := splitPath("team/app/blobs/uploads/abc")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"team/app", "uploads", "abc"}, []string{name, kind, ref})
+
+	name, kind, ref, ok = splitPath("app/manifests/latest")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"app", "manifests", "latest"}, []string{name, kind, ref})
+
+	_, _, _, ok = splitPath("app/unknown")
+	assert.False(t, ok)
+}
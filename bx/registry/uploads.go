@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// upload is a blob being pushed, its chunks are appended to a local file until the final PUT
+type upload struct {
+	name string
+	path string
+	size int64
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (h *Handler) getUpload(name, id string) *upload {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u := h.uploads[id]
+	if u == nil || u.name != name {
+		return nil
+	}
+	return u
+}
+
+func (h *Handler) removeUpload(id string) {
+	h.mu.Lock()
+	u := h.uploads[id]
+	delete(h.uploads, id)
+	h.mu.Unlock()
+	if u != nil {
+		os.Remove(u.path)
+	}
+}
+
+// startUpload opens an upload session. With "mount" the blob is already known (blobs are shared
+// between repositories) and with "digest" the body is the whole blob (monolithic upload).
+func (h *Handler) startUpload(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	if mount := query.Get("mount"); digestRegexp.MatchString(mount) {
+		if _, err := h.store.Stat(r.Context(), blobKey(mount)); err == nil {
+			w.Header().Set("Location", "/v2/"+name+"/blobs/"+mount)
+			w.Header().Set("Docker-Content-Digest", mount)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+
+	id := newUploadID()
+	u := &upload{name: name, path: filepath.Join(h.uploadDir, id)}
+	f, err := os.Create(u.path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	f.Close()
+	h.mu.Lock()
+	h.uploads[id] = u
+	h.mu.Unlock()
+
+	if query.Get("digest") != "" {
+		h.finishUpload(w, r, name, id)
+		return
+	}
+	h.writeUploadStatus(w, u, id, http.StatusAccepted)
+}
+
+func (h *Handler) patchUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	u := h.getUpload(name, id)
+	if u == nil {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", fmt.Sprintf("upload '%s' not found", id))
+		return
+	}
+	if err := h.appendChunk(u, r.Body); err != nil {
+		writeError(w, http.StatusInternalServerError, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+	h.writeUploadStatus(w, u, id, http.StatusAccepted)
+}
+
+// finishUpload appends the last chunk, checks the digest and moves the blob to the store
+func (h *Handler) finishUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	u := h.getUpload(name, id)
+	if u == nil {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", fmt.Sprintf("upload '%s' not found", id))
+		return
+	}
+	expected := r.URL.Query().Get("digest")
+	if !digestRegexp.MatchString(expected) {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("unsupported digest '%s'", expected))
+		return
+	}
+	if err := h.appendChunk(u, r.Body); err != nil {
+		writeError(w, http.StatusInternalServerError, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+	defer h.removeUpload(id)
+
+	digest, err := fileDigest(u.path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	if digest != expected {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("the uploaded content digest is %s, not %s", digest, expected))
+		return
+	}
+	f, err := os.Open(u.path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	defer f.Close()
+	if err := h.store.Put(r.Context(), blobKey(digest), f); err != nil {
+		log.Printf("registry: cannot store the blob %s: %v\n", digest, err)
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.Header().Set("Location", "/v2/"+name+"/blobs/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) uploadStatus(w http.ResponseWriter, r *http.Request, name, id string) {
+	u := h.getUpload(name, id)
+	if u == nil {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", fmt.Sprintf("upload '%s' not found", id))
+		return
+	}
+	h.writeUploadStatus(w, u, id, http.StatusNoContent)
+}
+
+func (h *Handler) cancelUpload(w http.ResponseWriter, r *http.Request, id string) {
+	h.removeUpload(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) appendChunk(u *upload, body io.Reader) error {
+	f, err := os.OpenFile(u.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open the upload: %w", err)
+	}
+	defer f.Close()
+	n, err := io.Copy(f, body)
+	h.mu.Lock()
+	u.size += n
+	h.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot write the upload: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) writeUploadStatus(w http.ResponseWriter, u *upload, id string, status int) {
+	h.mu.Lock()
+	size := u.size
+	h.mu.Unlock()
+	w.Header().Set("Location", "/v2/"+u.name+"/blobs/uploads/"+id)
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
@@ -0,0 +1,226 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Schemes of the AWS providers
+const (
+	SchemeSecretsManager = "aws-sm"
+	SchemeSSM            = "ssm"
+)
+
+// Session name used when assuming a role without an explicit one
+const defaultRoleSessionName = "anexis-bx"
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// AWSConfig holds the credentials used by the AWS fetchers.
+// Without static keys nor profile, the default chain is used (env vars, shared files, instance/task role).
+type AWSConfig struct {
+	Region          string `json:"region,omitempty" yaml:"region,omitempty"`
+	Profile         string `json:"profile,omitempty" yaml:"profile,omitempty"`                     // Shared config profile
+	AccessKeyID     string `json:"access_key_id,omitempty" yaml:"access_key_id,omitempty"`         // Static credentials
+	SecretAccessKey string `json:"secret_access_key,omitempty" yaml:"secret_access_key,omitempty"` // Static credentials
+	SessionToken    string `json:"session_token,omitempty" yaml:"session_token,omitempty"`         // Static credentials, optional
+	RoleARN         string `json:"role_arn,omitempty" yaml:"role_arn,omitempty"`                   // IAM role assumed with the base credentials
+	ExternalID      string `json:"external_id,omitempty" yaml:"external_id,omitempty"`             // External ID required by the role trust policy
+	SessionName     string `json:"session_name,omitempty" yaml:"session_name,omitempty"`           // Name of the assumed role session
+	Endpoint        string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`                   // Custom endpoint (e.g. localstack)
+}
+
+// LoadAWSConfig resolves the SDK configuration: base credentials, then the assumed role if any
+func LoadAWSConfig(ctx context.Context, config AWSConfig) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if config.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(config.Region))
+	}
+	if config.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(config.Profile))
+	}
+	if config.AccessKeyID != "" || config.SecretAccessKey != "" {
+		if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+			return aws.Config{}, fmt.Errorf("both access_key_id and secret_access_key are required for static AWS credentials")
+		}
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("cannot load the AWS configuration: %w", err)
+	}
+	if config.RoleARN != "" {
+		sessionName := config.SessionName
+		if sessionName == "" {
+			sessionName = defaultRoleSessionName
+		}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), config.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if config.ExternalID != "" {
+				o.ExternalID = aws.String(config.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg, nil
+}
+
+// secretsManagerAPI is the part of the Secrets Manager client used by the fetcher
+type secretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretsManagerFetcher reads the secrets of AWS Secrets Manager.
+// The reference is the secret name or ARN, with optional "version_id" or "version_stage" query parameters:
+// aws-sm://prod/db-password?version_stage=AWSPREVIOUS
+type SecretsManagerFetcher struct {
+	client secretsManagerAPI
+}
+
+// NewSecretsManagerFetcher creates a fetcher using the given configuration
+func NewSecretsManagerFetcher(ctx context.Context, config AWSConfig) (*SecretsManagerFetcher, error) {
+	cfg, err := LoadAWSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return secretsManagerFromConfig(cfg, config.Endpoint), nil
+}
+
+func secretsManagerFromConfig(cfg aws.Config, endpoint string) *SecretsManagerFetcher {
+	client := secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &SecretsManagerFetcher{client: client}
+}
+
+func (f *SecretsManagerFetcher) GetSecret(ctx context.Context, ref string) (string, error) {
+	data, err := f.GetSecretBytes(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetSecretBytes returns the secret string, or the raw value of a binary secret
+func (f *SecretsManagerFetcher) GetSecretBytes(ctx context.Context, ref string) ([]byte, error) {
+	secretID, query, err := splitQuery(ref)
+	if err != nil {
+		return nil, err
+	}
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)}
+	if versionID := query.Get("version_id"); versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	if versionStage := query.Get("version_stage"); versionStage != "" {
+		input.VersionStage = aws.String(versionStage)
+	}
+
+	output, err := f.client.GetSecretValue(ctx, input)
+	if err != nil {
+		var notFound *smtypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, secretID)
+		}
+		return nil, fmt.Errorf("AWS Secrets Manager request failed for '%s': %w", secretID, err)
+	}
+	if output.SecretString != nil {
+		return []byte(*output.SecretString), nil
+	}
+	if output.SecretBinary != nil {
+		return output.SecretBinary, nil
+	}
+	return nil, fmt.Errorf("the secret '%s' has no value", secretID)
+}
+
+// ssmAPI is the part of the SSM client used by the fetcher
+type ssmAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SSMFetcher reads the parameters of the SSM Parameter Store, SecureString parameters are decrypted.
+// The reference is the parameter name, ssm:///prod/db/password and ssm://prod/db/password both
+// read "/prod/db/password". A version or label selector can be appended: ssm:///prod/db/password:3
+type SSMFetcher struct {
+	client ssmAPI
+}
+
+// NewSSMFetcher creates a fetcher using the given configuration
+func NewSSMFetcher(ctx context.Context, config AWSConfig) (*SSMFetcher, error) {
+	cfg, err := LoadAWSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return ssmFromConfig(cfg, config.Endpoint), nil
+}
+
+func ssmFromConfig(cfg aws.Config, endpoint string) *SSMFetcher {
+	client := ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &SSMFetcher{client: client}
+}
+
+func (f *SSMFetcher) GetSecret(ctx context.Context, ref string) (string, error) {
+	name := ref
+	if !strings.HasPrefix(name, "/") && strings.Contains(name, "/") {
+		name = "/" + name // Hierarchical name written without its leading slash
+	}
+	output, err := f.client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		var notFound *ssmtypes.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		return "", fmt.Errorf("AWS SSM request failed for '%s': %w", name, err)
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", fmt.Errorf("the parameter '%s' has no value", name)
+	}
+	return *output.Parameter.Value, nil
+}
+
+// RegisterAWS adds the Secrets Manager ("aws-sm://") and SSM ("ssm://") providers to the registry
+func RegisterAWS(ctx context.Context, registry *Registry, config AWSConfig) error {
+	cfg, err := LoadAWSConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	registry.Register(SchemeSecretsManager, secretsManagerFromConfig(cfg, config.Endpoint))
+	registry.Register(SchemeSSM, ssmFromConfig(cfg, config.Endpoint))
+	return nil
+}
+
+// splitQuery separates the optional "?key=value" parameters of a reference
+func splitQuery(ref string) (string, url.Values, error) {
+	base, rawQuery, _ := strings.Cut(ref, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid parameters in the secret reference '%s': %w", ref, err)
+	}
+	if base == "" {
+		return "", nil, fmt.Errorf("empty secret reference '%s'", ref)
+	}
+	return base, query, nil
+}
@@ -0,0 +1,134 @@
+// Package secrets provides the SecretFetcher implementations of the build service
+// and a registry routing the secret sources to them by prefix (e.g. "aws-sm://", "ssm://").
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+)
+
+// Separator between the provider scheme and the reference in a secret source
+const schemeSeparator = "://"
+
+var ErrUnknownProvider = errors.New("no secret provider registered for the source")
+
+// Fetcher returns the value of a secret from its reference (the source without the scheme).
+// It has the same shape as build.SecretFetcher so both can be used in place of the other.
+type Fetcher interface {
+	GetSecret(ctx context.Context, source string) (string, error)
+}
+
+// Registry dispatches the secret sources to the fetcher registered for their scheme.
+// A source without scheme goes to the default fetcher, if any.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Fetcher
+	fallback  Fetcher
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Fetcher)}
+}
+
+// Register sets the fetcher of a scheme, written without "://" (e.g. "aws-sm")
+func (r *Registry) Register(scheme string, fetcher Fetcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[strings.ToLower(scheme)] = fetcher
+}
+
+// SetDefault sets the fetcher of the sources without scheme
+func (r *Registry) SetDefault(fetcher Fetcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = fetcher
+}
+
+// Schemes returns the registered schemes, sorted
+func (r *Registry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schemes := make([]string, 0, len(r.providers))
+	for scheme := range r.providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// GetSecret implements build.SecretFetcher
+func (r *Registry) GetSecret(ctx context.Context, source string) (string, error) {
+	scheme, ref, hasScheme := ParseSource(source)
+	r.mu.RLock()
+	fetcher := r.fallback
+	if hasScheme {
+		fetcher = r.providers[scheme]
+	} else {
+		ref = source
+	}
+	r.mu.RUnlock()
+
+	if fetcher == nil {
+		if hasScheme {
+			return "", fmt.Errorf("%w '%s' (scheme '%s')", ErrUnknownProvider, source, scheme)
+		}
+		return "", fmt.Errorf("%w '%s' (no scheme and no default provider)", ErrUnknownProvider, source)
+	}
+	value, err := fetcher.GetSecret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch the secret '%s': %w", source, err)
+	}
+	return value, nil
+}
+
+// GetSecretBytes implements build.StructuredSecretFetcher, the binary secrets are returned as is
+// by the providers supporting them
+func (r *Registry) GetSecretBytes(ctx context.Context, source string) ([]byte, error) {
+	scheme, ref, hasScheme := ParseSource(source)
+	r.mu.RLock()
+	fetcher := r.fallback
+	if hasScheme {
+		fetcher = r.providers[scheme]
+	} else {
+		ref = source
+	}
+	r.mu.RUnlock()
+
+	if fetcher == nil {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownProvider, source)
+	}
+	data, err := build.AdaptSecretFetcher(fetcher).GetSecretBytes(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch the secret '%s': %w", source, err)
+	}
+	return data, nil
+}
+
+// GetSecretMap implements build.StructuredSecretFetcher for the JSON object secrets
+func (r *Registry) GetSecretMap(ctx context.Context, source string) (map[string]string, error) {
+	data, err := r.GetSecretBytes(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	values, err := build.DecodeSecretMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the secret '%s': %w", source, err)
+	}
+	return values, nil
+}
+
+// ParseSource splits "scheme://ref" in its parts, the scheme is lower cased
+func ParseSource(source string) (scheme, ref string, ok bool) {
+	scheme, ref, ok = strings.Cut(source, schemeSeparator)
+	if !ok || scheme == "" {
+		return "", source, false
+	}
+	return strings.ToLower(scheme), ref, true
+}
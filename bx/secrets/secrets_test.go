@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticFetcher map[string]string
+
+func (f staticFetcher) GetSecret(ctx context.Context, ref string) (string, error) {
+	value, ok := f[ref]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func TestRegistry_RoutesBySchemeAndDefault(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("vault", staticFetcher{"db/password": "from-vault"})
+	registry.SetDefault(staticFetcher{"API_KEY": "from-default"})
+
+	value, err := registry.GetSecret(context.Background(), "VAULT://db/password")
+	require.NoError(t, err)
+	assert.Equal(t, "from-vault", value)
+
+	value, err = registry.GetSecret(context.Background(), "API_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "from-default", value)
+
+	_, err = registry.GetSecret(context.Background(), "gcp://x")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+	_, err = registry.GetSecret(context.Background(), "vault://missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+	assert.Equal(t, []string{"vault"}, registry.Schemes())
+}
+
+type fakeSecretsManager struct {
+	input *secretsmanager.GetSecretValueInput
+}
+
+func (f *fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.input = params
+	if aws.ToString(params.SecretId) == "missing" {
+		return nil, &smtypes.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	if aws.ToString(params.SecretId) == "tls/key" {
+		return &secretsmanager.GetSecretValueOutput{SecretBinary: []byte{0x00, 0xff, 0x10}}, nil
+	}
+	if aws.ToString(params.SecretId) == "prod/db-json" {
+		return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(`{"user":"app","password":"p4ss"}`)}, nil
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String("s3cr3t")}, nil
+}
+
+func TestSecretsManagerFetcher(t *testing.T) {
+	api := &fakeSecretsManager{}
+	registry := NewRegistry()
+	registry.Register(SchemeSecretsManager, &SecretsManagerFetcher{client: api})
+
+	value, err := registry.GetSecret(context.Background(), "aws-sm://prod/db?version_stage=AWSPREVIOUS")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+	assert.Equal(t, "prod/db", aws.ToString(api.input.SecretId))
+	assert.Equal(t, "AWSPREVIOUS", aws.ToString(api.input.VersionStage))
+
+	_, err = registry.GetSecret(context.Background(), "aws-sm://missing")
+	assert.True(t, errors.Is(err, ErrSecretNotFound))
+
+	data, err := registry.GetSecretBytes(context.Background(), "aws-sm://tls/key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xff, 0x10}, data)
+
+	values, err := registry.GetSecretMap(context.Background(), "aws-sm://prod/db-json")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user": "app", "password": "p4ss"}, values)
+}
+
+type fakeSSM struct {
+	input *ssm.GetParameterInput
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	f.input = params
+	if aws.ToString(params.Name) == "/missing/param" {
+		return nil, &ssmtypes.ParameterNotFound{}
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Value: aws.String("p4ram")}}, nil
+}
+
+func TestSSMFetcher(t *testing.T) {
+	api := &fakeSSM{}
+	fetcher := &SSMFetcher{client: api}
+
+	value, err := fetcher.GetSecret(context.Background(), "prod/db/password")
+	require.NoError(t, err)
+	assert.Equal(t, "p4ram", value)
+	assert.Equal(t, "/prod/db/password", aws.ToString(api.input.Name))
+	assert.True(t, aws.ToBool(api.input.WithDecryption))
+
+	_, err = fetcher.GetSecret(context.Background(), "/missing/param")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestLoadAWSConfig_RejectsPartialStaticCredentials(t *testing.T) {
+	_, err := LoadAWSConfig(context.Background(), AWSConfig{Region: "eu-west-1", AccessKeyID: "AKIAEXAMPLE"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+)
+
+// Prefix of the artifacts pushed by PushArtifact: artifacts/<name>/<version>/<file>
+const artifactsPrefix = "artifacts/"
+
+// Name of the manifest of a pushed artifact, written after its files
+const artifactManifest = "manifest.json"
+
+// ArtifactKey returns the key of a file of an artifact
+func ArtifactKey(name, version, file string) string {
+	return artifactsPrefix + name + "/" + version + "/" + file
+}
+
+// PushArtifact uploads the outputs of a local build described by its manifest (<name>-<version>.manifest.json):
+// the image archives, the run.yml and the manifest, pointing to the uploaded files. The manifest is written
+// last, an artifact is only visible once complete. The archives are checked against their digests.
+func PushArtifact(ctx context.Context, store Store, manifestPath string, out io.Writer) (*build.BuildManifest, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the manifest '%s': %w", manifestPath, err)
+	}
+	var manifest build.BuildManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest '%s': %w", manifestPath, err)
+	}
+	if err := checkArtifactName(manifest.Name, manifest.Version); err != nil {
+		return nil, err
+	}
+	baseDir := filepath.Dir(manifestPath)
+
+	for i, img := range manifest.Images {
+		if img.ArchivePath == "" {
+			return nil, fmt.Errorf("the image of the service '%s' has no archive, build it with the 'local' output target", img.Service)
+		}
+		file := filepath.Base(img.ArchivePath)
+		fmt.Fprintf(out, "Pushing %s (%s)\n", file, img.Service)
+		digest, err := putFile(ctx, store, ArtifactKey(manifest.Name, manifest.Version, file), localOutput(baseDir, img.ArchivePath))
+		if err != nil {
+			return nil, err
+		}
+		if img.ArchiveDigest != "" && digest != img.ArchiveDigest {
+			return nil, fmt.Errorf("digest mismatch for the archive '%s': expected %s, got %s", img.ArchivePath, img.ArchiveDigest, digest)
+		}
+		manifest.Images[i].ArchivePath = file
+		manifest.Images[i].ArchiveDigest = digest
+	}
+	if manifest.RunConfigPath != "" {
+		file := filepath.Base(manifest.RunConfigPath)
+		fmt.Fprintf(out, "Pushing %s\n", file)
+		if _, err := putFile(ctx, store, ArtifactKey(manifest.Name, manifest.Version, file), localOutput(baseDir, manifest.RunConfigPath)); err != nil {
+			return nil, err
+		}
+		manifest.RunConfigPath = file
+	}
+
+	data, err = json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the manifest: %w", err)
+	}
+	if err := store.Put(ctx, ArtifactKey(manifest.Name, manifest.Version, artifactManifest), bytes.NewReader(append(data, '\n'))); err != nil {
+		return nil, fmt.Errorf("cannot push the manifest of %s:%s: %w", manifest.Name, manifest.Version, err)
+	}
+	return &manifest, nil
+}
+
+// PullArtifact downloads an artifact pushed by PushArtifact in dir: the image archives, checked against
+// their digests, the run.yml and the manifest (<name>-<version>.manifest.json). The returned manifest
+// points to the downloaded files.
+func PullArtifact(ctx context.Context, store Store, name, version, dir string, out io.Writer) (*build.BuildManifest, error) {
+	if err := checkArtifactName(name, version); err != nil {
+		return nil, err
+	}
+	r, err := store.Get(ctx, ArtifactKey(name, version, artifactManifest))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("no artifact %s:%s: %w", name, version, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the manifest of %s:%s: %w", name, version, err)
+	}
+	var manifest build.BuildManifest
+	err = json.NewDecoder(r).Decode(&manifest)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s:%s: %w", name, version, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create the directory '%s': %w", dir, err)
+	}
+
+	for i, img := range manifest.Images {
+		file, err := artifactFile(img.ArchivePath)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Pulling %s (%s)\n", file, img.Service)
+		target := filepath.Join(dir, file)
+		digest, err := getFile(ctx, store, ArtifactKey(name, version, file), target)
+		if err != nil {
+			return nil, err
+		}
+		if img.ArchiveDigest != "" && digest != img.ArchiveDigest {
+			os.Remove(target)
+			return nil, fmt.Errorf("digest mismatch for the archive '%s': expected %s, got %s", file, img.ArchiveDigest, digest)
+		}
+		manifest.Images[i].ArchivePath = target
+	}
+	if manifest.RunConfigPath != "" {
+		file, err := artifactFile(manifest.RunConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Pulling %s\n", file)
+		target := filepath.Join(dir, file)
+		if _, err := getFile(ctx, store, ArtifactKey(name, version, file), target); err != nil {
+			return nil, err
+		}
+		manifest.RunConfigPath = target
+	}
+
+	data, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the manifest: %w", err)
+	}
+	manifestPath := filepath.Join(dir, fmt.Sprintf("%s-%s.manifest.json", name, version))
+	if err := os.WriteFile(manifestPath, append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("cannot write the manifest '%s': %w", manifestPath, err)
+	}
+	return &manifest, nil
+}
+
+// ArtifactVersions returns the versions of an artifact, the complete ones only, sorted
+func ArtifactVersions(ctx context.Context, store Store, name string) ([]string, error) {
+	keys, err := store.List(ctx, artifactsPrefix+name+"/")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, key := range keys {
+		version, file, ok := strings.Cut(strings.TrimPrefix(key, artifactsPrefix+name+"/"), "/")
+		if ok && file == artifactManifest {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// checkArtifactName rejects the names and versions which are not a single key segment
+func checkArtifactName(name, version string) error {
+	for _, part := range []string{name, version} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return fmt.Errorf("invalid artifact %s:%s", name, version)
+		}
+	}
+	return nil
+}
+
+// artifactFile returns the file of an artifact named in a pulled manifest, never a path
+func artifactFile(name string) (string, error) {
+	if name == "" || name != path.Base(name) || name == "." || name == ".." || strings.Contains(name, `\`) || name == artifactManifest {
+		return "", fmt.Errorf("invalid file '%s' in the artifact manifest", name)
+	}
+	return name, nil
+}
+
+// localOutput returns the path of an output of a build, as written in the manifest (relative to the
+// directory of the build) or next to the manifest
+func localOutput(baseDir, file string) string {
+	if _, err := os.Stat(file); err == nil || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(baseDir, filepath.Base(file))
+}
+
+// putFile uploads a file and returns its digest
+func putFile(ctx context.Context, store Store, key, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot open '%s': %w", file, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if err := store.Put(ctx, key, io.TeeReader(f, hash)); err != nil {
+		return "", fmt.Errorf("cannot push '%s': %w", file, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// getFile downloads an object to a file and returns its digest
+func getFile(ctx context.Context, store Store, key, file string) (string, error) {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("cannot pull '%s': %w", key, err)
+	}
+	defer r.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot create '%s': %w", file, err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		f.Close()
+		os.Remove(file)
+		return "", fmt.Errorf("cannot pull '%s': %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("cannot write '%s': %w", file, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
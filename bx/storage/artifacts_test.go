@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLocalBuild(t *testing.T, dir string) string {
+	t.Helper()
+	archive := []byte("image archive")
+	sum := sha256.Sum256(archive)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app_web.tar"), archive, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app-1.0.run.yml"), []byte("version: \"1\"\n"), 0644))
+	manifest := build.BuildManifest{
+		Name:          "app",
+		Version:       "1.0",
+		OutputTarget:  "local",
+		RunConfigPath: filepath.Join(dir, "app-1.0.run.yml"),
+		Images: []build.ManifestImage{{
+			Service:       "web",
+			ImageID:       "sha256:abc",
+			Tags:          []string{"app:1.0"},
+			ArchivePath:   "out/app_web.tar", // Relative to the directory of the build
+			ArchiveDigest: "sha256:" + hex.EncodeToString(sum[:]),
+		}},
+	}
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	manifestPath := filepath.Join(dir, "app-1.0.manifest.json")
+	require.NoError(t, os.WriteFile(manifestPath, data, 0644))
+	return manifestPath
+}
+
+func TestPushPullArtifact(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFSStore(t.TempDir())
+	require.NoError(t, err)
+	manifestPath := writeLocalBuild(t, t.TempDir())
+
+	pushed, err := PushArtifact(ctx, store, manifestPath, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "app_web.tar", pushed.Images[0].ArchivePath)
+	assert.Equal(t, "app-1.0.run.yml", pushed.RunConfigPath)
+	keys, err := store.List(ctx, "artifacts/app/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"artifacts/app/1.0/app-1.0.run.yml",
+		"artifacts/app/1.0/app_web.tar",
+		"artifacts/app/1.0/manifest.json",
+	}, keys)
+	versions, err := ArtifactVersions(ctx, store, "app")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0"}, versions)
+
+	dir := filepath.Join(t.TempDir(), "pulled")
+	pulled, err := PullArtifact(ctx, store, "app", "1.0", dir, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "app_web.tar"), pulled.Images[0].ArchivePath)
+	assert.Equal(t, []string{"app:1.0"}, pulled.Images[0].Tags)
+	data, err := os.ReadFile(pulled.Images[0].ArchivePath)
+	require.NoError(t, err)
+	assert.Equal(t, "image archive", string(data))
+	assert.FileExists(t, filepath.Join(dir, "app-1.0.run.yml"))
+	assert.FileExists(t, filepath.Join(dir, "app-1.0.manifest.json"))
+
+	// A corrupted archive is rejected
+	require.NoError(t, store.Put(ctx, "artifacts/app/1.0/app_web.tar", strings.NewReader("tampered")))
+	_, err = PullArtifact(ctx, store, "app", "1.0", t.TempDir(), io.Discard)
+	assert.ErrorContains(t, err, "digest mismatch")
+
+	_, err = PullArtifact(ctx, store, "app", "2.0", t.TempDir(), io.Discard)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = PullArtifact(ctx, store, "app", "../x", t.TempDir(), io.Discard)
+	assert.Error(t, err)
+}
+
+func TestPushArtifact_ModifiedArchive(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFSStore(t.TempDir())
+	require.NoError(t, err)
+	dir := t.TempDir()
+	manifestPath := writeLocalBuild(t, dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app_web.tar"), []byte("rebuilt"), 0644))
+
+	_, err = PushArtifact(ctx, store, manifestPath, io.Discard)
+	assert.ErrorContains(t, err, "digest mismatch")
+	// The manifest is not written, the artifact is not visible
+	versions, err := ArtifactVersions(ctx, store, "app")
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+}
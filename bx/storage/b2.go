@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Treefle-labs/Anexis/bx/build"
+
+	"github.com/Backblaze/blazer/b2"
+)
+
+// B2Store keeps the objects in a Backblaze B2 bucket, under the base path of the configuration
+type B2Store struct {
+	bucket   *b2.Bucket
+	basePath string
+}
+
+// NewB2Store connects to the bucket of the configuration
+func NewB2Store(ctx context.Context, config *build.B2Config) (*B2Store, error) {
+	if config == nil || config.AccountID == "" || config.ApplicationKey == "" || config.BucketName == "" {
+		return nil, fmt.Errorf("incomplete B2 configuration: account_id, application_key and bucket_name are required")
+	}
+	client, err := b2.NewClient(ctx, config.AccountID, config.ApplicationKey, b2.UserAgent("build-service"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the B2 client: %w", err)
+	}
+	bucket, err := client.Bucket(ctx, config.BucketName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access the B2 bucket '%s': %w", config.BucketName, err)
+	}
+	return &B2Store{bucket: bucket, basePath: strings.Trim(config.BasePath, "/")}, nil
+}
+
+func (s *B2Store) objectName(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if s.basePath == "" {
+		return key, nil
+	}
+	return path.Join(s.basePath, key), nil
+}
+
+func (s *B2Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+	return s.bucket.Object(name).NewReader(ctx), nil
+}
+
+func (s *B2Store) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	writer := s.bucket.Object(name).NewWriter(ctx)
+	if _, err := io.Copy(writer, r); err != nil {
+		writer.Close()
+		return fmt.Errorf("cannot upload the object '%s': %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("cannot finalize the upload of '%s': %w", name, err)
+	}
+	return nil
+}
+
+func (s *B2Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	attrs, err := s.bucket.Object(name).Attrs(ctx)
+	if b2.IsNotExist(err) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("cannot read the attributes of '%s': %w", name, err)
+	}
+	return ObjectInfo{Key: key, Size: attrs.Size, ModTime: attrs.UploadTimestamp}, nil
+}
+
+func (s *B2Store) Delete(ctx context.Context, key string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	if err := s.bucket.Object(name).Delete(ctx); err != nil && !b2.IsNotExist(err) {
+		return fmt.Errorf("cannot delete the object '%s': %w", name, err)
+	}
+	return nil
+}
+
+func (s *B2Store) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := prefix
+	if s.basePath != "" {
+		fullPrefix = s.basePath + "/" + prefix
+	}
+	var keys []string
+	iter := s.bucket.List(ctx, b2.ListPrefix(fullPrefix))
+	for iter.Next() {
+		name := iter.Object().Name()
+		if s.basePath != "" {
+			name = strings.TrimPrefix(name, s.basePath+"/")
+		}
+		keys = append(keys, name)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list the objects of '%s': %w", fullPrefix, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,48 @@
+// Package storage provides the object storage used to keep the artifacts of bx:
+// a local directory or a Backblaze B2 bucket behind the same interface.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is a flat key/value object storage. Keys are slash separated paths ("blobs/sha256/ab12...").
+type Store interface {
+	// Get opens an object for reading, ErrNotFound if it does not exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put writes an object, replacing the existing one. The object is visible only once fully written.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Stat returns the information of an object, ErrNotFound if it does not exist
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes an object, deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the keys starting with the prefix, sorted
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// cleanKey normalizes a key and rejects the ones escaping the store
+func cleanKey(key string) (string, error) {
+	key = strings.Trim(key, "/")
+	if key == "" {
+		return "", errors.New("empty object key")
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errors.New("invalid object key '" + key + "'")
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFSStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "blobs/sha256/abc", strings.NewReader("hello")))
+	require.NoError(t, store.Put(ctx, "repositories/app/tags/latest", strings.NewReader("sha256:abc")))
+
+	info, err := store.Stat(ctx, "blobs/sha256/abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+
+	r, err := store.Get(ctx, "blobs/sha256/abc")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "hello", string(data))
+
+	keys, err := store.List(ctx, "repositories/app/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"repositories/app/tags/latest"}, keys)
+
+	require.NoError(t, store.Delete(ctx, "blobs/sha256/abc"))
+	require.NoError(t, store.Delete(ctx, "blobs/sha256/abc"))
+	_, err = store.Stat(ctx, "blobs/sha256/abc")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get(ctx, "blobs/sha256/abc")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Error(t, store.Put(ctx, "../escape", strings.NewReader("x")))
+}
@@ -0,0 +1,222 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Agent runs the builds a coordinator schedules on this host with a local build service, and sends
+// their log chunks and status back to the coordinator:
+//
+//	agent := socket.NewAgent(socket.AgentInfo{Name: "builder-1", Arch: runtime.GOARCH, OS: runtime.GOOS}, buildService)
+//	err := agent.Run(ctx, "wss://coordinator.example.com/ws", headers)
+//
+// The agent reconnects without limit and registers again, its builds go on meanwhile. Their log
+// chunks are dropped while disconnected, their final status is sent after the registration.
+type Agent struct {
+	info   AgentInfo
+	builds BuildTriggerer
+	client *Client
+
+	mu         sync.Mutex // Held during the registration, the jobs and reports wait for it
+	registered bool       // The coordinator accepts the reports of the connection
+	running    map[string]bool
+	unsent     map[string]*Message // Final statuses not delivered, by build
+}
+
+func NewAgent(info AgentInfo, builds BuildTriggerer) *Agent {
+	policy := DefaultReconnectPolicy
+	policy.MaxAttempts = 0 // An agent waits for its coordinator
+	client := NewClient()
+	client.SetReconnectPolicy(&policy)
+	agent := &Agent{
+		info:    info,
+		builds:  builds,
+		client:  client,
+		running: make(map[string]bool),
+		unsent:  make(map[string]*Message),
+	}
+	client.onConnState = agent.connectionChanged
+	return agent
+}
+
+// Client returns the client of the agent, to configure it before Run
+func (a *Agent) Client() *Client {
+	return a.client
+}
+
+// Run connects to the coordinator, registers the agent and runs the builds scheduled on it until ctx is done
+func (a *Agent) Run(ctx context.Context, coordinatorURL string, headers http.Header) error {
+	if err := a.client.Connect(coordinatorURL, headers); err != nil {
+		return fmt.Errorf("cannot connect agent '%s' to '%s': %w", a.info.Name, coordinatorURL, err)
+	}
+	defer a.client.Close()
+	if err := a.register(ctx); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-a.client.Incoming:
+			a.handleMessage(ctx, msg)
+		}
+	}
+}
+
+// register sends the capabilities and running builds of the agent, then the final statuses not delivered
+func (a *Agent) register(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	payload := AgentRegisterPayload{Agent: a.info}
+	for buildID := range a.running {
+		payload.Running = append(payload.Running, buildID)
+	}
+	sort.Strings(payload.Running)
+
+	ctx, cancel := context.WithTimeout(ctx, writeWait)
+	defer cancel()
+	resp, err := a.client.SendRequest(ctx, EvtAgentRegister, payload)
+	if err != nil {
+		return fmt.Errorf("cannot register agent '%s': %w", a.info.Name, err)
+	}
+	registered, err := Decode[AgentRegisteredPayload](resp)
+	if err != nil {
+		return fmt.Errorf("cannot register agent '%s': %w", a.info.Name, err)
+	}
+	for _, buildID := range registered.Lost {
+		log.Printf("Agent: Coordinator forgot build %s, its reports are dropped\n", buildID)
+		delete(a.running, buildID)
+		delete(a.unsent, buildID)
+	}
+	a.registered = true
+	log.Printf("Agent: Registered as %s with %d builds running\n", a.info.Name, len(a.running))
+	a.flush()
+	return nil
+}
+
+// connectionChanged registers the agent again after a reconnection
+func (a *Agent) connectionChanged(connected bool) {
+	if !connected {
+		a.mu.Lock()
+		a.registered = false
+		a.mu.Unlock()
+		return
+	}
+	if err := a.register(context.Background()); err != nil {
+		log.Printf("Agent: %v\n", err)
+	}
+}
+
+func (a *Agent) handleMessage(ctx context.Context, msg *Message) {
+	switch msg.Type {
+	case EvtAgentJob:
+		job, err := Decode[AgentJobPayload](msg)
+		if err != nil {
+			log.Printf("Agent: Invalid job: %v\n", err)
+			return
+		}
+		a.mu.Lock()
+		a.running[job.BuildID] = true
+		a.mu.Unlock()
+		log.Printf("Agent: Starting build %s\n", job.BuildID)
+		if err := a.builds.StartBuildAsync(ctx, job.BuildID, job.BuildSpecYAML, a); err != nil {
+			a.NotifyStatus(job.BuildID, "failure", "", fmt.Errorf("agent '%s' cannot start the build: %w", a.info.Name, err), nil)
+		}
+	case EvtError:
+		log.Printf("Agent: Coordinator error: %s\n", msg.Error)
+	default:
+		log.Printf("Agent: Ignored message type %s\n", msg.Type)
+	}
+}
+
+// NotifyLog sends a log chunk of a build to the coordinator, it is dropped while disconnected
+func (a *Agent) NotifyLog(buildID string, stream string, content string) {
+	msg, err := NewPayloadMessage("", LogChunkPayload{BuildID: buildID, Stream: stream, Content: content})
+	if err != nil {
+		log.Printf("Agent: Error creating log chunk payload for build %s: %v\n", buildID, err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.registered {
+		log.Printf("Agent: Dropped a log chunk of build %s, not registered\n", buildID)
+		return
+	}
+	if err := a.client.Send(msg); err != nil {
+		log.Printf("Agent: Dropped a log chunk of build %s: %v\n", buildID, err)
+	}
+}
+
+// NotifyStatus sends the status of a build to the coordinator, the final one is kept until delivered
+func (a *Agent) NotifyStatus(buildID, status, artifactRef string, buildErr error, duration *float64) {
+	msg, err := NewPayloadMessage("", newStatusPayload(buildID, status, artifactRef, buildErr, duration))
+	if err != nil {
+		log.Printf("Agent: Error creating build status payload for build %s: %v\n", buildID, err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if finalStatus(status) {
+		a.unsent[buildID] = msg
+		if a.registered {
+			a.flush()
+		}
+		return
+	}
+	if !a.registered {
+		return
+	}
+	if err := a.client.Send(msg); err != nil {
+		log.Printf("Agent: Dropped status %s of build %s: %v\n", status, buildID, err)
+	}
+}
+
+// NotifyQueued sends the position of a build in the queue of the agent to the coordinator
+func (a *Agent) NotifyQueued(buildID string, position int, estimatedWait time.Duration) {
+	a.report(BuildQueuedPayload{
+		BuildID:          buildID,
+		Message:          fmt.Sprintf("Build job queued at position %d", position),
+		Position:         position,
+		EstimatedWaitSec: estimatedWait.Seconds(),
+	})
+}
+
+// NotifyProgress sends the progress of a build to the coordinator
+func (a *Agent) NotifyProgress(progress BuildProgressPayload) {
+	a.report(progress)
+}
+
+// report sends an update of a build which is dropped while disconnected
+func (a *Agent) report(payload Payload) {
+	msg, err := NewPayloadMessage("", payload)
+	if err != nil {
+		log.Printf("Agent: Error creating %s payload: %v\n", payload.EventType(), err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.registered {
+		return
+	}
+	if err := a.client.Send(msg); err != nil {
+		log.Printf("Agent: Dropped %s: %v\n", payload.EventType(), err)
+	}
+}
+
+// flush sends the final statuses not delivered yet. a.mu must be held.
+func (a *Agent) flush() {
+	for buildID, msg := range a.unsent {
+		if err := a.client.Send(msg); err != nil {
+			log.Printf("Agent: Final status of build %s not delivered: %v\n", buildID, err)
+			return
+		}
+		delete(a.unsent, buildID)
+		delete(a.running, buildID)
+	}
+}
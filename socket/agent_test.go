@@ -0,0 +1,238 @@
+package socket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startCoordinator serves a coordinator and returns its websocket URL
+func startCoordinator(t *testing.T) (*Coordinator, string) {
+	t.Helper()
+	coordinator := NewCoordinator()
+	coordinator.SetAgentCallers("") // No authentication
+	server := NewServer(coordinator, nil, func(r *http.Request) bool { return true })
+	coordinator.Register(server)
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return coordinator, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+// runAgent runs an agent until the end of the test, its builds log the agent name once released
+func runAgent(t *testing.T, wsURL string, info AgentInfo, release chan struct{}) (*Agent, context.CancelFunc) {
+	t.Helper()
+	builds := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				<-release
+				notifier.NotifyLog(buildID, "stdout", "built on "+info.Name)
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+	agent := NewAgent(info, builds)
+	agent.Client().SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go agent.Run(ctx, wsURL, nil)
+	return agent, cancel
+}
+
+func waitAgents(t *testing.T, coordinator *Coordinator, count int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(coordinator.Agents()) == count }, time.Second, 5*time.Millisecond)
+}
+
+func requestBuild(t *testing.T, client *Client, requirements *BuildRequirements) BuildQueuedPayload {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app", Requirements: requirements})
+	require.NoError(t, err)
+	queued, err := Decode[BuildQueuedPayload](resp)
+	require.NoError(t, err)
+	return queued
+}
+
+func TestCoordinator_SchedulesOnMatchingAgent(t *testing.T) {
+	coordinator, wsURL := startCoordinator(t)
+	release := make(chan struct{})
+	close(release)
+	runAgent(t, wsURL, AgentInfo{Name: "amd", Arch: "amd64", OS: "linux", Labels: map[string]string{"docker": "27"}}, release)
+	runAgent(t, wsURL, AgentInfo{Name: "arm", Arch: "arm64", OS: "linux", Labels: map[string]string{"docker": "27", "gpu": "true"}}, release)
+	waitAgents(t, coordinator, 2)
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	for _, requirements := range []*BuildRequirements{{Arch: "arm64"}, {Labels: map[string]string{"gpu": "true"}}} {
+		requestBuild(t, client, requirements)
+		chunk, status := receiveBuildMessages(t, client)
+		assert.Equal(t, "built on arm", chunk.Content)
+		assert.Equal(t, "success", status.Status)
+		assert.Equal(t, "app:1.0", status.ArtifactRef)
+	}
+	requestBuild(t, client, &BuildRequirements{Arch: "amd64"})
+	chunk, _ := receiveBuildMessages(t, client)
+	assert.Equal(t, "built on amd", chunk.Content)
+}
+
+func TestCoordinator_QueuesUntilAgentAvailable(t *testing.T) {
+	coordinator, wsURL := startCoordinator(t)
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+
+	queued := requestBuild(t, client, &BuildRequirements{Arch: "arm64"})
+	assert.Equal(t, 1, queued.Position)
+	release := make(chan struct{})
+	close(release)
+	runAgent(t, wsURL, AgentInfo{Name: "amd", Arch: "amd64"}, release)
+	waitAgents(t, coordinator, 1)
+	position, ok := coordinator.QueuePosition(queued.BuildID)
+	assert.True(t, ok)
+	assert.Equal(t, 1, position)
+
+	runAgent(t, wsURL, AgentInfo{Name: "arm", Arch: "arm64"}, release)
+	chunk, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "built on arm", chunk.Content)
+	assert.Equal(t, "success", status.Status)
+	_, ok = coordinator.QueuePosition(queued.BuildID)
+	assert.False(t, ok)
+}
+
+func TestCoordinator_AgentCapacity(t *testing.T) {
+	coordinator, wsURL := startCoordinator(t)
+	release := make(chan struct{})
+	runAgent(t, wsURL, AgentInfo{Name: "solo", Arch: "amd64", Capacity: 1}, release)
+	waitAgents(t, coordinator, 1)
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	assert.Zero(t, requestBuild(t, client, nil).Position)
+	assert.Equal(t, 1, requestBuild(t, client, nil).Position)
+
+	close(release)
+	for range 2 {
+		_, status := receiveBuildMessages(t, client)
+		assert.Equal(t, "success", status.Status)
+	}
+}
+
+func TestCoordinator_AgentReconnects(t *testing.T) {
+	coordinator, wsURL := startCoordinator(t)
+	release := make(chan struct{})
+	agent, _ := runAgent(t, wsURL, AgentInfo{Name: "builder", Arch: "amd64"}, release)
+	waitAgents(t, coordinator, 1)
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	buildID := requestBuild(t, client, nil).BuildID
+
+	// Network failure during the build, the final status is sent after the new registration
+	agent.client.mu.Lock()
+	oldConn := agent.client.conn
+	agent.client.mu.Unlock()
+	require.NoError(t, oldConn.ws.Close())
+	require.Eventually(t, func() bool {
+		agent.client.mu.Lock()
+		reconnected := agent.client.conn != nil && agent.client.conn != oldConn
+		agent.client.mu.Unlock()
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		return reconnected && agent.registered
+	}, 2*time.Second, 10*time.Millisecond)
+	close(release)
+
+	_, status := receiveBuildMessages(t, client)
+	assert.Equal(t, buildID, status.BuildID)
+	assert.Equal(t, "success", status.Status)
+}
+
+func TestCoordinator_AgentLost(t *testing.T) {
+	coordinator, wsURL := startCoordinator(t)
+	coordinator.SetLostTimeout(50 * time.Millisecond)
+	_, stop := runAgent(t, wsURL, AgentInfo{Name: "builder", Arch: "amd64"}, make(chan struct{}))
+	waitAgents(t, coordinator, 1)
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	requestBuild(t, client, nil)
+
+	stop()
+	_, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "failure", status.Status)
+	assert.Equal(t, "agent 'builder' disconnected during the build", status.Message)
+	assert.Empty(t, coordinator.Agents())
+}
+
+func TestCoordinator_RejectsReportsOfOtherConnections(t *testing.T) {
+	_, wsURL := startCoordinator(t)
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := client.SendRequest(ctx, EvtBuildStatus, BuildStatusPayload{BuildID: "build-1", Status: "success"})
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeUnauthorized, respErr.Code)
+
+	_, err = client.SendRequest(ctx, EvtAgentRegister, AgentRegisterPayload{Agent: AgentInfo{Name: "builder"}})
+	require.NoError(t, err)
+	_, err = client.SendRequest(ctx, EvtLogChunk, LogChunkPayload{BuildID: "build-1", Content: "forged"})
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeBuildNotFound, respErr.Code)
+}
+
+func TestCoordinator_RestrictsAgentCallers(t *testing.T) {
+	coordinator := NewCoordinator()
+	coordinator.SetAgentCallers("agent-a", "agent-b")
+	server := NewServer(coordinator, nil, func(r *http.Request) bool { return true })
+	auth := NewTokenAuth()
+	auth.Add("agent-a", "token-a")
+	auth.Add("agent-b", "token-b")
+	auth.Add("client", "token-client")
+	server.SetAuthenticator(auth)
+	coordinator.Register(server)
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	register := func(token, name string) error {
+		client := NewClient()
+		require.NoError(t, client.Connect(wsURL, http.Header{"Authorization": []string{"Bearer " + token}}))
+		t.Cleanup(client.Close)
+		_, err := client.SendRequest(ctx, EvtAgentRegister, AgentRegisterPayload{Agent: AgentInfo{Name: name}})
+		return err
+	}
+
+	var respErr *ResponseError
+	require.ErrorAs(t, register("token-client", "builder"), &respErr)
+	assert.Equal(t, ErrCodeUnauthorized, respErr.Code)
+	assert.Empty(t, coordinator.Agents())
+
+	require.NoError(t, register("token-a", "builder"))
+	// Another agent caller cannot take the name
+	require.ErrorAs(t, register("token-b", "builder"), &respErr)
+	assert.Equal(t, ErrCodeUnauthorized, respErr.Code)
+	require.NoError(t, register("token-b", "builder-b"))
+	// The same caller registers the agent again from a new connection
+	require.NoError(t, register("token-a", "builder"))
+	waitAgents(t, coordinator, 2)
+}
@@ -0,0 +1,111 @@
+package socket
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator validates the credentials of an HTTP request and returns the name of the caller.
+// The same authenticator can protect the websocket endpoint and the other HTTP services of a deployment.
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// TokenAuth accepts the requests carrying one of its tokens, either as a bearer token
+// or as the password of a basic authorization (as sent by `docker login`).
+type TokenAuth struct {
+	mu     sync.RWMutex
+	tokens map[string]string // Token -> name
+}
+
+// NewTokenAuth returns an authenticator accepting the given tokens, named by their position
+func NewTokenAuth(tokens ...string) *TokenAuth {
+	auth := &TokenAuth{tokens: make(map[string]string)}
+	for i, token := range tokens {
+		auth.Add(fmt.Sprintf("token-%d", i+1), token)
+	}
+	return auth
+}
+
+// LoadTokenFile reads a token file: one token per line, optionally preceded by its name and a
+// space ("name token"). The token itself may contain any character but whitespace.
+// Empty lines and lines starting with '#' are ignored.
+func LoadTokenFile(path string) (*TokenAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the token file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	auth := NewTokenAuth()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var name, token string
+		switch fields := strings.Fields(text); len(fields) {
+		case 1:
+			name, token = fmt.Sprintf("token-%d", line), fields[0]
+		case 2:
+			name, token = fields[0], fields[1]
+		default:
+			return nil, fmt.Errorf("invalid line %d of '%s', expected \"token\" or \"name token\"", line, path)
+		}
+		auth.Add(name, token)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read the token file '%s': %w", path, err)
+	}
+	return auth, nil
+}
+
+// Add registers a token under a name
+func (a *TokenAuth) Add(name, token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens[token] = name
+}
+
+// Len returns the number of registered tokens
+func (a *TokenAuth) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.tokens)
+}
+
+func (a *TokenAuth) Authenticate(r *http.Request) (string, error) {
+	token := RequestToken(r)
+	if token == "" {
+		return "", fmt.Errorf("%w: no token provided", ErrUnauthorized)
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for known, name := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: invalid token", ErrUnauthorized)
+}
+
+// RequestToken extracts the token of a request: bearer token, basic auth password or "token" query parameter
+func RequestToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if scheme, value, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(value)
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,163 @@
+package socket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectionPair returns a server connection whose writePump is not started and the raw client websocket
+func connectionPair(t *testing.T, policy SendPolicy) (*connection, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *connection, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		conns <- newConnection(ws, policy)
+	}))
+	t.Cleanup(httpServer.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ws.Close() })
+	return <-conns, ws
+}
+
+func TestConnection_SendWaitsForRoom(t *testing.T) {
+	conn, _ := connectionPair(t, SendPolicy{BufferSize: 1, Timeout: time.Second})
+	require.True(t, conn.sendMsg(NewMessage(EvtPing, "1")))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-conn.send
+	}()
+	start := time.Now()
+	assert.True(t, conn.sendMsg(NewMessage(EvtPing, "2")))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	assert.False(t, conn.slow.Load())
+}
+
+func TestConnection_ClosesSlowConsumer(t *testing.T) {
+	conn, ws := connectionPair(t, SendPolicy{BufferSize: 1, Timeout: 20 * time.Millisecond})
+	require.True(t, conn.sendMsg(NewMessage(EvtPing, "1")))
+	assert.False(t, conn.sendMsg(NewMessage(EvtPing, "2")))
+	assert.True(t, conn.slow.Load())
+
+	// The next messages are dropped without waiting
+	start := time.Now()
+	assert.False(t, conn.sendMsg(NewMessage(EvtPing, "3")))
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+
+	go conn.writePump()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var err error
+	for err == nil {
+		// The queued message may be written before the close frame
+		_, _, err = ws.ReadMessage()
+	}
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), "unexpected error: %v", err)
+}
+
+func TestConnection_CloseFlushesQueue(t *testing.T) {
+	conn, ws := connectionPair(t, DefaultSendPolicy)
+	for _, id := range []string{"1", "2"} {
+		require.True(t, conn.sendMsg(NewMessage(EvtPing, id)))
+	}
+	conn.closeSend()
+	conn.closeSend()
+	assert.False(t, conn.sendMsg(NewMessage(EvtPing, "3")))
+
+	go conn.writePump()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ids []string
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error: %v", err)
+			break
+		}
+		var msg Message
+		require.NoError(t, JSONCodec.Unmarshal(data, &msg))
+		ids = append(ids, msg.RequestID)
+	}
+	assert.Equal(t, []string{"1", "2"}, ids)
+}
+
+// blockingPeer holds every message until release is closed
+type blockingPeer struct {
+	release chan struct{}
+}
+
+func (p *blockingPeer) sendMsg(msg *Message) bool {
+	<-p.release
+	return true
+}
+
+func TestNotifier_SlowSubscriberHoldsOnlyItsBuild(t *testing.T) {
+	notifier := newServerBuildNotifier(nil)
+	slow := &blockingPeer{release: make(chan struct{})}
+	defer close(slow.release)
+	fast := newStreamPeer()
+	notifier.registerBuildClient("build-slow", slow, "")
+	notifier.registerBuildClient("build-fast", fast, "")
+
+	go notifier.NotifyLog("build-slow", "stdout", "held")
+	require.Eventually(t, func() bool {
+		notifier.mu.RLock()
+		defer notifier.mu.RUnlock()
+		return notifier.buildToClient["build-slow"].sequence == 1
+	}, time.Second, 5*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		notifier.NotifyLog("build-fast", "stdout", "sent")
+		notifier.NotifyStatus("build-fast", "success", "", nil, nil)
+		assert.True(t, notifier.attachClient("build-fast", newStreamPeer(), 0))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the slow subscriber of another build held the notifier")
+	}
+	assert.Len(t, fast.messages, 2)
+}
+
+func TestCoordinator_SlowSubscriberDoesNotHoldTheLock(t *testing.T) {
+	notifier := newServerBuildNotifier(nil)
+	slow := &blockingPeer{release: make(chan struct{})}
+	defer close(slow.release)
+	fast := newStreamPeer()
+	notifier.registerBuildClient("build-slow", slow, "")
+	notifier.registerBuildClient("build-next", fast, "")
+	coordinator := NewCoordinator()
+
+	// Without agent, the queue position of the build is sent to its held subscriber
+	go coordinator.StartBuildAsync(context.Background(), "build-slow", "name: app", notifier)
+	require.Eventually(t, func() bool {
+		notifier.mu.RLock()
+		defer notifier.mu.RUnlock()
+		return notifier.buildToClient["build-slow"].progress != nil
+	}, time.Second, 5*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, coordinator.StartBuildAsync(context.Background(), "build-next", "name: app", notifier))
+		position, queued := coordinator.QueuePosition("build-next")
+		assert.True(t, queued)
+		assert.Equal(t, 2, position)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the slow subscriber of a build held the coordinator")
+	}
+	assert.Len(t, fast.messages, 1)
+}
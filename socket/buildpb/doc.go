@@ -0,0 +1,5 @@
+// Package buildpb holds the messages and the gRPC service of the build protocol, generated from
+// build.proto. The socket server implements the service with Server.GRPCService.
+package buildpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative build.proto
@@ -0,0 +1,125 @@
+package socket
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Bytes of the encoded message carried by each chunk, the base64 of the data must fit in maxMessageSize
+	chunkDataSize = 4096
+	// Max size of a reassembled message
+	maxTransferSize = 16 << 20
+)
+
+// incomingTransfer is a chunked message being received
+type incomingTransfer struct {
+	ChunkBeginPayload
+	received int
+	data     []byte
+}
+
+// writeChunked sends an encoded message larger than maxMessageSize as a chunk_begin, its chunk_data
+// and a chunk_end, the peer reassembles it before handling it
+func (c *connection) writeChunked(message *Message, encoded []byte) error {
+	sum := sha256.Sum256(encoded)
+	begin := ChunkBeginPayload{
+		TransferID: uuid.NewString(),
+		Size:       len(encoded),
+		Chunks:     (len(encoded) + chunkDataSize - 1) / chunkDataSize,
+		Checksum:   hex.EncodeToString(sum[:]),
+	}
+	if err := c.writeFrame(message.RequestID, begin); err != nil {
+		return err
+	}
+	for index := 0; index < begin.Chunks; index++ {
+		data := encoded[index*chunkDataSize : min((index+1)*chunkDataSize, len(encoded))]
+		if err := c.writeFrame(message.RequestID, ChunkDataPayload{TransferID: begin.TransferID, Index: index, Data: data}); err != nil {
+			return err
+		}
+	}
+	return c.writeFrame(message.RequestID, ChunkEndPayload{TransferID: begin.TransferID})
+}
+
+func (c *connection) writeFrame(requestID string, payload Payload) error {
+	msg, err := NewPayloadMessage(requestID, payload)
+	if err != nil {
+		return err
+	}
+	frame, err := c.codec.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("cannot encode the %s frame: %w", msg.Type, err)
+	}
+	return c.write(c.codec.FrameType(), frame)
+}
+
+// isChunkEvent reports whether the event belongs to a chunked transfer
+func isChunkEvent(eventType EventType) bool {
+	return eventType == EvtChunkBegin || eventType == EvtChunkData || eventType == EvtChunkEnd
+}
+
+// receiveChunk handles a message of a chunked transfer. It returns the reassembled message on the
+// chunk_end, nil before. Only one transfer is received at a time, a connection writes its messages in order.
+func (c *connection) receiveChunk(msg *Message) (*Message, error) {
+	switch msg.Type {
+	case EvtChunkBegin:
+		begin, err := Decode[ChunkBeginPayload](msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk begin payload: %w", err)
+		}
+		if begin.Size <= 0 || begin.Size > maxTransferSize {
+			return nil, fmt.Errorf("transfer %s of %d bytes exceeds the limit of %d bytes", begin.TransferID, begin.Size, maxTransferSize)
+		}
+		c.transfer = &incomingTransfer{ChunkBeginPayload: begin, data: make([]byte, 0, begin.Size)}
+		return nil, nil
+
+	case EvtChunkData:
+		chunk, err := Decode[ChunkDataPayload](msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk data payload: %w", err)
+		}
+		transfer := c.transfer
+		if transfer == nil || transfer.TransferID != chunk.TransferID {
+			return nil, fmt.Errorf("chunk of the unknown transfer %s", chunk.TransferID)
+		}
+		if chunk.Index != transfer.received {
+			c.transfer = nil
+			return nil, fmt.Errorf("chunk %d of transfer %s received instead of chunk %d", chunk.Index, chunk.TransferID, transfer.received)
+		}
+		if len(transfer.data)+len(chunk.Data) > transfer.Size {
+			c.transfer = nil
+			return nil, fmt.Errorf("transfer %s exceeds its announced size of %d bytes", chunk.TransferID, transfer.Size)
+		}
+		transfer.data = append(transfer.data, chunk.Data...)
+		transfer.received++
+		return nil, nil
+
+	case EvtChunkEnd:
+		end, err := Decode[ChunkEndPayload](msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk end payload: %w", err)
+		}
+		transfer := c.transfer
+		c.transfer = nil
+		if transfer == nil || transfer.TransferID != end.TransferID {
+			return nil, fmt.Errorf("end of the unknown transfer %s", end.TransferID)
+		}
+		if transfer.received != transfer.Chunks || len(transfer.data) != transfer.Size {
+			return nil, fmt.Errorf("transfer %s incomplete: %d of %d chunks, %d of %d bytes",
+				end.TransferID, transfer.received, transfer.Chunks, len(transfer.data), transfer.Size)
+		}
+		sum := sha256.Sum256(transfer.data)
+		if hex.EncodeToString(sum[:]) != transfer.Checksum {
+			return nil, fmt.Errorf("checksum mismatch for transfer %s", end.TransferID)
+		}
+		var reassembled Message
+		if err := c.codec.Unmarshal(transfer.data, &reassembled); err != nil {
+			return nil, fmt.Errorf("invalid message in transfer %s: %w", end.TransferID, err)
+		}
+		return &reassembled, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnexpectedEvent, msg.Type)
+}
@@ -0,0 +1,81 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedTransfer_LargeMessages(t *testing.T) {
+	spec := "name: app\n" + strings.Repeat("# padding of the build spec\n", 2000)
+	bigLog := strings.Repeat("x", 3*maxMessageSize)
+	received := make(chan string, 1)
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			received <- buildSpecYAML
+			go func() {
+				notifier.NotifyLog(buildID, "stdout", bigLog)
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: spec})
+	require.NoError(t, err)
+	_, err = Decode[BuildQueuedPayload](resp)
+	require.NoError(t, err)
+	assert.Equal(t, spec, <-received)
+
+	chunk, status := receiveBuildMessages(t, client)
+	assert.Equal(t, bigLog, chunk.Content)
+	assert.Equal(t, "success", status.Status)
+}
+
+func TestConnection_ReceiveChunkErrors(t *testing.T) {
+	frame := func(payload Payload) *Message {
+		msg, err := NewPayloadMessage("req-1", payload)
+		require.NoError(t, err)
+		return msg
+	}
+	encoded, err := json.Marshal(NewMessage(EvtPing, ""))
+	require.NoError(t, err)
+	begin := ChunkBeginPayload{TransferID: "t1", Size: len(encoded), Chunks: 1, Checksum: strings.Repeat("0", 64)}
+
+	conn := &connection{codec: JSONCodec}
+	msg, err := conn.receiveChunk(frame(begin))
+	require.NoError(t, err)
+	assert.Nil(t, msg)
+	_, err = conn.receiveChunk(frame(ChunkDataPayload{TransferID: "t1", Index: 0, Data: encoded}))
+	require.NoError(t, err)
+	_, err = conn.receiveChunk(frame(ChunkEndPayload{TransferID: "t1"}))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	// Chunks out of order
+	_, err = conn.receiveChunk(frame(begin))
+	require.NoError(t, err)
+	_, err = conn.receiveChunk(frame(ChunkDataPayload{TransferID: "t1", Index: 1, Data: encoded}))
+	assert.ErrorContains(t, err, "instead of chunk 0")
+	_, err = conn.receiveChunk(frame(ChunkEndPayload{TransferID: "t1"}))
+	assert.ErrorContains(t, err, "unknown transfer")
+
+	begin.Size = maxTransferSize + 1
+	_, err = conn.receiveChunk(frame(begin))
+	assert.ErrorContains(t, err, "exceeds the limit")
+}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -21,26 +25,124 @@ type Client struct {
 
 	mu          sync.Mutex
 	isConnected bool
+	closed      bool          // Close was called, the client does not reconnect
+	stop        chan struct{} // Closed by Close, stops the reconnection
 	dialer      *websocket.Dialer
+	codec       Codec // Codec offered at the handshake, JSON when nil
+	compression bool  // permessage-deflate offered at the handshake
+	sendPolicy  SendPolicy
+	heartbeat   HeartbeatPolicy
 	connUrl     string
-	headers     http.Header // For authentication or other headers
-
-	// pendingRequests holds channels for requests that are waiting for a response.
+	headers     http.Header          // For authentication or other headers
+	reconnect   *ReconnectPolicy     // nil disables the reconnection
+	watched     map[string]int64     // Builds the client receives the logs and status of, with the sequence of their last log chunk
+	onConnState func(connected bool) // Called when the connection is lost and after a reconnection, set by Agent
+	secrets     secretCache          // Values returned by FetchSecret, see SetSecretCache
+
+	// Connection hooks of the embedding application, see OnConnect, OnDisconnect and OnSlow
+	onConnect    func()
+	onDisconnect func(err error)
+	onSlow       func(rtt time.Duration)
+
+	// pendingRequests holds the requests that are waiting for a response.
 	// Keyed by RequestID, so we can correlate responses.
 	// This allows us to handle responses to specific requests.
-	pendingRequests map[string]chan *Message
+	pendingRequests map[string]*pendingRequest
 	pendingMu       sync.RWMutex
 }
 
+// pendingRequest is a request waiting for its response, sent again after a reconnection when it is replayable
+type pendingRequest struct {
+	msg  *Message
+	resp chan *Message
+}
+
+// ReconnectPolicy configures the reconnection of the client after an unexpected disconnect
+type ReconnectPolicy struct {
+	InitialDelay time.Duration // Delay before the first attempt
+	MaxDelay     time.Duration // Cap of the delay, doubled after each failed attempt
+	MaxAttempts  int           // Attempts before giving up, 0 retries forever
+}
+
+// DefaultReconnectPolicy is the reconnection policy of the new clients
+var DefaultReconnectPolicy = ReconnectPolicy{InitialDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second, MaxAttempts: 10}
+
 // Creating a new client for a websocket connection.
 func NewClient() *Client {
+	policy := DefaultReconnectPolicy
 	return &Client{
 		Incoming:        make(chan *Message, 100), // Buffer for incoming messages
 		dialer:          websocket.DefaultDialer,
-		pendingRequests: make(map[string]chan *Message),
+		reconnect:       &policy,
+		compression:     true,
+		sendPolicy:      DefaultSendPolicy,
+		heartbeat:       DefaultHeartbeatPolicy,
+		watched:         make(map[string]int64),
+		pendingRequests: make(map[string]*pendingRequest),
 	}
 }
 
+// SetReconnectPolicy configures the reconnection after an unexpected disconnect, nil disables it
+func (c *Client) SetReconnectPolicy(policy *ReconnectPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnect = policy
+}
+
+// SetSendPolicy bounds the messages queued for the server at the next connections
+func (c *Client) SetSendPolicy(policy SendPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendPolicy = policy
+}
+
+// SetCompression offers permessage-deflate at the next connections (the default), the server may still refuse it
+func (c *Client) SetCompression(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compression = enabled
+}
+
+// SetHeartbeat configures the pings of the next connections, the zero durations keep their default
+func (c *Client) SetHeartbeat(policy HeartbeatPolicy) error {
+	if policy.PingInterval <= 0 {
+		policy.PingInterval = DefaultHeartbeatPolicy.PingInterval
+	}
+	if policy.PongTimeout <= 0 {
+		policy.PongTimeout = DefaultHeartbeatPolicy.PongTimeout
+	}
+	if policy.PingInterval >= policy.PongTimeout {
+		return fmt.Errorf("ping interval %s must be shorter than the pong timeout %s", policy.PingInterval, policy.PongTimeout)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.heartbeat = policy
+	return nil
+}
+
+// OnConnect sets the function called after each connection to the server, the reconnections included
+func (c *Client) OnConnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnect = fn
+}
+
+// OnDisconnect sets the function called when a connection ends, with the error which ended it.
+// The client reconnects afterwards unless it was closed or its reconnection is disabled.
+func (c *Client) OnDisconnect(fn func(err error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDisconnect = fn
+}
+
+// OnSlow sets the function called when a ping takes longer than HeartbeatPolicy.SlowThreshold to
+// be answered. It is called by the read loop of the connection and must return quickly.
+func (c *Client) OnSlow(fn func(rtt time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onSlow = fn
+}
+
 // Connect to the given server url websocket with the provided headers.
 func (c *Client) Connect(serverUrl string, headers http.Header) error {
 	c.mu.Lock()
@@ -50,12 +152,36 @@ func (c *Client) Connect(serverUrl string, headers http.Header) error {
 	}
 	c.connUrl = serverUrl
 	c.headers = headers
+	c.closed = false
+	c.stop = make(chan struct{})
+	c.mu.Unlock()
+
+	if u, err := url.Parse(serverUrl); err == nil && u.Scheme == "ws" && headers.Get("Authorization") != "" {
+		log.Printf("Warning: Client: Sending credentials to %s without TLS, use a wss:// URL\n", u.Host)
+	}
+	if err := c.dial(); err != nil {
+		return err
+	}
+	// The builds watched before a previous connection was lost or closed finish meanwhile
+	c.resume()
+	c.connected()
+	return nil
+}
+
+// dial opens the connection to the URL of Connect and starts its pumps
+func (c *Client) dial() error {
+	c.mu.Lock()
+	dialer, connUrl, headers := *c.dialer, c.connUrl, c.headers
+	dialer.EnableCompression = c.compression
+	if c.codec != nil && c.codec != JSONCodec {
+		dialer.Subprotocols = []string{c.codec.Subprotocol(), JSONCodec.Subprotocol()}
+	}
 	c.mu.Unlock()
 
-	log.Printf("Client: Attempting to connect to %s...\n", serverUrl)
-	ws, resp, err := c.dialer.Dial(c.connUrl, c.headers)
+	log.Printf("Client: Attempting to connect to %s...\n", connUrl)
+	ws, resp, err := dialer.Dial(connUrl, headers)
 	if err != nil {
-		errMsg := fmt.Sprintf("Client: Failed to connect to %s: %v", c.connUrl, err)
+		errMsg := fmt.Sprintf("Client: Failed to connect to %s: %v", connUrl, err)
 		if resp != nil {
 			errMsg = fmt.Sprintf("%s (Status: %s)", errMsg, resp.Status)
 			body, _ := io.ReadAll(resp.Body)
@@ -66,15 +192,24 @@ func (c *Client) Connect(serverUrl string, headers http.Header) error {
 		}
 		return fmt.Errorf("an error occurred %s", errMsg)
 	}
-	log.Printf("Client: Successfully connected to %s\n", c.connUrl)
+	log.Printf("Client: Successfully connected to %s\n", connUrl)
 
 	c.mu.Lock()
-	c.conn = newConnection(ws)
+	if c.closed {
+		// Closed while reconnecting
+		c.mu.Unlock()
+		ws.Close()
+		return fmt.Errorf("client closed")
+	}
+	c.conn = newConnection(ws, c.sendPolicy)
+	c.conn.heartbeat = c.heartbeat
+	c.conn.onSlow = c.slow
 	c.isConnected = true
+	conn := c.conn
 	c.mu.Unlock()
 
-	go c.conn.writePump()
-	go c.conn.readPump(c.handleIncomingMessage, c.handleDisconnect)
+	go conn.writePump()
+	go conn.readPump(c.handleIncomingMessage, c.handleDisconnect)
 
 	return nil
 }
@@ -87,14 +222,22 @@ func (c *Client) IsConnected() bool {
 
 func (c *Client) handleIncomingMessage(msg *Message, conn *connection) error {
 	log.Printf("Client: Received message type %s (ReqID: %s)\n", msg.Type, msg.RequestID) // Debug
+	if msg.Type == EvtSecretInvalidate {
+		c.invalidateSecrets(msg)
+		return nil
+	}
+	if !c.trackBuild(msg) {
+		log.Println("Client: Dropped a log chunk already received")
+		return nil
+	}
 
 	// Check if it's a pending request
 	c.pendingMu.Lock()
 	if msg.RequestID != "" {
-		if respChan, ok := c.pendingRequests[msg.RequestID]; ok {
+		if pending, ok := c.pendingRequests[msg.RequestID]; ok {
 			log.Printf("Client: Correlated response for RequestID %s\n", msg.RequestID)
 			select {
-			case respChan <- msg:
+			case pending.resp <- msg:
 			default:
 				log.Printf("Warning: No listener for response channel of RequestID %s\n", msg.RequestID)
 			}
@@ -113,6 +256,44 @@ func (c *Client) handleIncomingMessage(msg *Message, conn *connection) error {
 	return nil
 }
 
+// trackBuild records the builds started by the client until their final status, with the sequence of
+// their last log chunk, to attach to them again after a reconnection. It returns false for the log
+// chunks already received, replayed by the server.
+func (c *Client) trackBuild(msg *Message) bool {
+	switch msg.Type {
+	case EvtBuildQueued:
+		if payload, err := Decode[BuildQueuedPayload](msg); err == nil && payload.BuildID != "" {
+			c.mu.Lock()
+			if _, ok := c.watched[payload.BuildID]; !ok {
+				c.watched[payload.BuildID] = 0
+			}
+			c.mu.Unlock()
+		}
+	case EvtLogChunk:
+		payload, err := Decode[LogChunkPayload](msg)
+		if err != nil || payload.Sequence == 0 {
+			break
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		last, ok := c.watched[payload.BuildID]
+		if !ok {
+			break
+		}
+		if payload.Sequence <= last {
+			return false
+		}
+		c.watched[payload.BuildID] = payload.Sequence
+	case EvtBuildStatus:
+		if payload, err := Decode[BuildStatusPayload](msg); err == nil && finalStatus(payload.Status) {
+			c.mu.Lock()
+			delete(c.watched, payload.BuildID)
+			c.mu.Unlock()
+		}
+	}
+	return true
+}
+
 func (c *Client) handleDisconnect(conn *connection) {
 	c.mu.Lock()
 	if c.conn != conn {
@@ -122,19 +303,191 @@ func (c *Client) handleDisconnect(conn *connection) {
 	}
 	c.isConnected = false
 	c.conn = nil
+	conn.closeSend()
 	log.Println("Client: Connection lost.")
+	var policy *ReconnectPolicy
+	if c.reconnect != nil && !c.closed {
+		copied := *c.reconnect
+		policy = &copied
+	}
+	stop := c.stop
+	onConnState, onDisconnect := c.onConnState, c.onDisconnect
 	c.mu.Unlock()
 
-	// Clean the pending request for this connection
+	// The invalidations sent while disconnected are missed
+	c.secrets.invalidate(nil)
+	if onConnState != nil {
+		onConnState(false)
+	}
+	if onDisconnect != nil {
+		onDisconnect(conn.readErr)
+	}
+	if policy == nil {
+		c.failPending(func(*Message) bool { return true })
+		return
+	}
+	// The responses of the requests which are not replayable may be lost
+	c.failPending(func(msg *Message) bool { return !replayable(msg) })
+	go c.reconnectLoop(*policy, stop)
+}
+
+// connected calls the OnConnect hook
+func (c *Client) connected() {
+	c.mu.Lock()
+	onConnect := c.onConnect
+	c.mu.Unlock()
+	if onConnect != nil {
+		onConnect()
+	}
+}
+
+// slow calls the OnSlow hook, from the readPump
+func (c *Client) slow(rtt time.Duration) {
+	log.Printf("Client: Slow connection, ping answered in %s\n", rtt)
+	c.mu.Lock()
+	onSlow := c.onSlow
+	c.mu.Unlock()
+	if onSlow != nil {
+		onSlow(rtt)
+	}
+}
+
+// failPending fails the pending requests matching drop, their SendRequest returns an error
+func (c *Client) failPending(drop func(msg *Message) bool) {
 	c.pendingMu.Lock()
-	if len(c.pendingRequests) > 0 {
-		log.Printf("Client: Cleaning up %d pending requests due to disconnect.\n", len(c.pendingRequests))
-		for reqID, respChan := range c.pendingRequests {
-			close(respChan)
+	defer c.pendingMu.Unlock()
+	for reqID, pending := range c.pendingRequests {
+		if drop(pending.msg) {
+			close(pending.resp)
 			delete(c.pendingRequests, reqID)
 		}
 	}
-	c.pendingMu.Unlock()
+}
+
+// replayable reports whether a request can be sent again when its response may have been lost:
+// the read only requests. A build or a configuration change could be applied twice.
+func replayable(msg *Message) bool {
+	switch msg.Type {
+	case EvtSecretRequest, EvtBuildAttach, EvtBuildList, EvtBuildGet, EvtLogHistory:
+		return true
+	case EvtDeploymentsRequest:
+		return true // Every deployments action is a query
+	case EvtProjectConfigRequest:
+		payload, err := Decode[ProjectConfigRequestPayload](msg)
+		return err == nil && (payload.Action == ProjectActionList || payload.Action == ProjectActionGet)
+	}
+	return false
+}
+
+// reconnectLoop reconnects with an exponential backoff, then sends the pending requests again and
+// attaches to the builds of the client. It gives up after the attempts of the policy or when Close is called.
+func (c *Client) reconnectLoop(policy ReconnectPolicy, stop chan struct{}) {
+	delay := policy.InitialDelay
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		// Up to 20% of jitter, so the clients of a restarted server do not reconnect together
+		wait := delay + time.Duration(rand.Int64N(int64(delay)/5+1))
+		select {
+		case <-stop:
+			c.failPending(func(*Message) bool { return true })
+			return
+		case <-time.After(wait):
+		}
+
+		log.Printf("Client: Reconnection attempt %d\n", attempt)
+		err := c.dial()
+		if err == nil {
+			c.resume()
+			c.mu.Lock()
+			onConnState := c.onConnState
+			c.mu.Unlock()
+			if onConnState != nil {
+				onConnState(true)
+			}
+			c.connected()
+			return
+		}
+		log.Printf("Client: Reconnection attempt %d failed: %v\n", attempt, err)
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+	log.Printf("Client: Giving up the reconnection after %d attempts\n", policy.MaxAttempts)
+	c.failPending(func(*Message) bool { return true })
+}
+
+// resume sends the pending requests on the new connection and attaches to the builds of the client again
+func (c *Client) resume() {
+	c.mu.Lock()
+	conn := c.conn
+	buildIDs := make([]string, 0, len(c.watched))
+	for buildID := range c.watched {
+		buildIDs = append(buildIDs, buildID)
+	}
+	c.mu.Unlock()
+	if conn == nil {
+		return
+	}
+
+	c.pendingMu.RLock()
+	for reqID, pending := range c.pendingRequests {
+		log.Printf("Client: Replaying request %s (Type: %s)\n", reqID, pending.msg.Type)
+		conn.sendMsg(pending.msg)
+	}
+	c.pendingMu.RUnlock()
+
+	if len(buildIDs) == 0 {
+		return
+	}
+	sort.Strings(buildIDs)
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+	if _, err := c.Attach(ctx, buildIDs...); err != nil {
+		log.Printf("Client: Failed to attach to the builds %v again: %v\n", buildIDs, err)
+	}
+}
+
+// Attach attaches the client to builds: their log chunks and status are pushed to Incoming, starting
+// with the chunks kept by the server which the client did not receive yet. The builds unknown to the
+// server (forgotten, or started on another server) are returned in the response and not watched.
+func (c *Client) Attach(ctx context.Context, buildIDs ...string) (*BuildAttachedPayload, error) {
+	offsets := make(map[string]int64)
+	var added []string
+	c.mu.Lock()
+	for _, buildID := range buildIDs {
+		sequence, ok := c.watched[buildID]
+		switch {
+		case !ok:
+			// Watched before the request, the replayed chunks come before the response
+			c.watched[buildID] = 0
+			added = append(added, buildID)
+		case sequence > 0:
+			offsets[buildID] = sequence
+		}
+	}
+	c.mu.Unlock()
+	resp, err := c.SendRequest(ctx, EvtBuildAttach, BuildAttachPayload{BuildIDs: buildIDs, Offsets: offsets})
+	if err != nil {
+		c.mu.Lock()
+		for _, buildID := range added {
+			delete(c.watched, buildID)
+		}
+		c.mu.Unlock()
+		return nil, err
+	}
+	payload, err := Decode[BuildAttachedPayload](resp)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	for _, buildID := range payload.Unknown {
+		delete(c.watched, buildID)
+	}
+	c.mu.Unlock()
+	if len(payload.Unknown) > 0 {
+		log.Printf("Client: The server does not know the builds %v anymore\n", payload.Unknown)
+	}
+	return &payload, nil
 }
 
 // sending message to the server asynchronously.
@@ -148,7 +501,9 @@ func (c *Client) Send(msg *Message) error {
 		return fmt.Errorf("client not connected")
 	}
 	log.Printf("Client: Sending message type %s async\n", msg.Type) // Debug
-	conn.sendMsg(msg)
+	if !conn.sendMsg(msg) {
+		return fmt.Errorf("connection closed before sending message type %s", msg.Type)
+	}
 	return nil
 }
 
@@ -174,7 +529,7 @@ func (c *Client) SendRequest(ctx context.Context, msgType EventType, payload any
 	respChan := make(chan *Message, 1)
 
 	c.pendingMu.Lock()
-	c.pendingRequests[requestID] = respChan
+	c.pendingRequests[requestID] = &pendingRequest{msg: msg, resp: respChan}
 	c.pendingMu.Unlock()
 
 	// Cleaning the request before the response (success, error, timeout)
@@ -186,22 +541,26 @@ func (c *Client) SendRequest(ctx context.Context, msgType EventType, payload any
 
 	// Send the request
 	log.Printf("Client: Sending request %s (Type: %s)\n", requestID, msg.Type)
-	conn.sendMsg(msg)
+	if !conn.sendMsg(msg) && !replayable(msg) {
+		return nil, fmt.Errorf("connection closed before sending request %s", requestID)
+	}
 
 	// Waiting for the response
 	select {
-	case resp := <-respChan:
+	case resp, ok := <-respChan:
+		if !ok {
+			return nil, fmt.Errorf("connection lost before the response to request %s", requestID)
+		}
 		log.Printf("Client: Received response for request %s (Type: %s, Error: '%s')\n", requestID, resp.Type, resp.Error)
 		if resp.Error != "" || resp.Type == EvtError {
-			errMsg := resp.Error
-			if errMsg == "" {
-				errMsg = "received error event"
+			respErr := &ResponseError{RequestID: requestID, Message: resp.Error}
+			if respErr.Message == "" {
+				respErr.Message = "received error event"
 			}
-			var errPayload ErrorPayload
-			if resp.DecodePayload(&errPayload) == nil && errPayload.Details != "" {
-				errMsg = fmt.Sprintf("%s: %s", errMsg, errPayload.Details)
+			if errPayload, err := Decode[ErrorPayload](resp); err == nil {
+				respErr.Code, respErr.Details = errPayload.Code, errPayload.Details
 			}
-			return nil, fmt.Errorf("server error response for request %s: %s", requestID, errMsg)
+			return nil, respErr
 		}
 		return resp, nil
 
@@ -218,6 +577,10 @@ func (c *Client) Close() {
 
 	log.Println("Client: Close called.")
 
+	if !c.closed && c.stop != nil {
+		close(c.stop)
+	}
+	c.closed = true
 	if c.conn != nil && c.isConnected {
 		c.conn.closeSend()
 	}
@@ -0,0 +1,237 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec encodes the messages on the wire. It is negotiated at the handshake with the websocket
+// subprotocol, the connections without subprotocol use JSON.
+type Codec interface {
+	Subprotocol() string
+	FrameType() int // websocket.TextMessage or websocket.BinaryMessage
+	Marshal(msg *Message) ([]byte, error)
+	Unmarshal(data []byte, msg *Message) error
+}
+
+var (
+	// JSONCodec sends each message as a JSON text frame, the default
+	JSONCodec Codec = jsonCodec{}
+	// ProtobufCodec sends each message as a protobuf binary frame. The payloads stay in JSON, except the
+	// data of the chunked messages sent as raw bytes.
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// codecs are the codecs accepted by the server, by order of preference
+var codecs = []Codec{ProtobufCodec, JSONCodec}
+
+func subprotocols(codecs []Codec) []string {
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.Subprotocol())
+	}
+	return names
+}
+
+// codecFor returns the codec of a negotiated subprotocol, JSON when none was negotiated
+func codecFor(subprotocol string) Codec {
+	for _, codec := range codecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return "anexis.json.v1" }
+func (jsonCodec) FrameType() int      { return websocket.TextMessage }
+
+func (jsonCodec) Marshal(msg *Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Unmarshal(data []byte, msg *Message) error {
+	return json.Unmarshal(data, msg)
+}
+
+// protobufCodec encodes the messages as:
+//
+//	message Message {
+//	  string type = 1;
+//	  string request_id = 2;
+//	  bytes payload = 3;        // JSON of the payload, except for chunk_data
+//	  string error = 4;
+//	  ChunkData chunk_data = 5; // Payload of the chunk_data messages
+//	}
+//
+//	message ChunkData {
+//	  string transfer_id = 1;
+//	  int64 index = 2;
+//	  bytes data = 3;
+//	}
+//
+// The chunks of the large messages carry their data as raw bytes instead of the base64 of the JSON.
+type protobufCodec struct{}
+
+const (
+	protoFieldType protowire.Number = iota + 1
+	protoFieldRequestID
+	protoFieldPayload
+	protoFieldError
+	protoFieldChunkData
+)
+
+// Fields of the ChunkData message
+const (
+	protoChunkTransferID protowire.Number = iota + 1
+	protoChunkIndex
+	protoChunkData
+)
+
+func (protobufCodec) Subprotocol() string { return "anexis.protobuf.v1" }
+func (protobufCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (protobufCodec) Marshal(msg *Message) ([]byte, error) {
+	var data []byte
+	appendString := func(field protowire.Number, value string) {
+		if value != "" {
+			data = protowire.AppendTag(data, field, protowire.BytesType)
+			data = protowire.AppendString(data, value)
+		}
+	}
+	appendString(protoFieldType, string(msg.Type))
+	appendString(protoFieldRequestID, msg.RequestID)
+	switch {
+	case msg.Type == EvtChunkData && len(msg.Payload) > 0:
+		chunk, err := Decode[ChunkDataPayload](msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk data payload: %w", err)
+		}
+		data = protowire.AppendTag(data, protoFieldChunkData, protowire.BytesType)
+		data = protowire.AppendBytes(data, marshalChunkData(chunk))
+	case len(msg.Payload) > 0:
+		data = protowire.AppendTag(data, protoFieldPayload, protowire.BytesType)
+		data = protowire.AppendBytes(data, msg.Payload)
+	}
+	appendString(protoFieldError, msg.Error)
+	return data, nil
+}
+
+func marshalChunkData(chunk ChunkDataPayload) []byte {
+	var data []byte
+	data = protowire.AppendTag(data, protoChunkTransferID, protowire.BytesType)
+	data = protowire.AppendString(data, chunk.TransferID)
+	data = protowire.AppendTag(data, protoChunkIndex, protowire.VarintType)
+	data = protowire.AppendVarint(data, uint64(chunk.Index))
+	data = protowire.AppendTag(data, protoChunkData, protowire.BytesType)
+	return protowire.AppendBytes(data, chunk.Data)
+}
+
+func (protobufCodec) Unmarshal(data []byte, msg *Message) error {
+	*msg = Message{}
+	for len(data) > 0 {
+		field, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf message: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if wireType != protowire.BytesType || field < protoFieldType || field > protoFieldChunkData {
+			// Unknown field of a newer peer
+			n = protowire.ConsumeFieldValue(field, wireType, data)
+			if n < 0 {
+				return fmt.Errorf("invalid protobuf field %d: %w", field, protowire.ParseError(n))
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", field, protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch field {
+		case protoFieldType:
+			msg.Type = EventType(value)
+		case protoFieldRequestID:
+			msg.RequestID = string(value)
+		case protoFieldPayload:
+			msg.Payload = append(json.RawMessage(nil), value...)
+		case protoFieldError:
+			msg.Error = string(value)
+		case protoFieldChunkData:
+			chunk, err := unmarshalChunkData(value)
+			if err != nil {
+				return err
+			}
+			// The handlers decode the payloads from JSON
+			if err := msg.AddPayload(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	if msg.Type == "" {
+		return fmt.Errorf("invalid protobuf message: no type")
+	}
+	return nil
+}
+
+func unmarshalChunkData(data []byte) (ChunkDataPayload, error) {
+	var chunk ChunkDataPayload
+	for len(data) > 0 {
+		field, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return chunk, fmt.Errorf("invalid protobuf chunk data: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch {
+		case field == protoChunkIndex && wireType == protowire.VarintType:
+			index, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return chunk, fmt.Errorf("invalid protobuf chunk index: %w", protowire.ParseError(n))
+			}
+			chunk.Index = int(index)
+			data = data[n:]
+		case (field == protoChunkTransferID || field == protoChunkData) && wireType == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return chunk, fmt.Errorf("invalid protobuf chunk field %d: %w", field, protowire.ParseError(n))
+			}
+			if field == protoChunkTransferID {
+				chunk.TransferID = string(value)
+			} else {
+				chunk.Data = append([]byte(nil), value...)
+			}
+			data = data[n:]
+		default:
+			n = protowire.ConsumeFieldValue(field, wireType, data)
+			if n < 0 {
+				return chunk, fmt.Errorf("invalid protobuf chunk field %d: %w", field, protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+	return chunk, nil
+}
+
+// SetCodec selects the codec offered to the server at the next connections, JSON is used when the
+// server does not support it. nil restores JSON.
+func (c *Client) SetCodec(codec Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.codec = codec
+}
+
+// Codec returns the codec negotiated with the server, nil when not connected
+func (c *Client) Codec() Codec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil || !c.isConnected {
+		return nil
+	}
+	return c.conn.codec
+}
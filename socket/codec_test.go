@@ -0,0 +1,117 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestProtobufCodec_RoundTrip(t *testing.T) {
+	msg, err := NewPayloadMessage("req-1", LogChunkPayload{BuildID: "build-1", Stream: "stdout", Content: "line\n", Sequence: 3})
+	require.NoError(t, err)
+	msg.Error = "boom"
+
+	data, err := ProtobufCodec.Marshal(msg)
+	require.NoError(t, err)
+	// Field of a newer peer
+	data = protowire.AppendTag(data, 9, protowire.VarintType)
+	data = protowire.AppendVarint(data, 42)
+
+	var decoded Message
+	require.NoError(t, ProtobufCodec.Unmarshal(data, &decoded))
+	assert.Equal(t, *msg, decoded)
+	chunk, err := Decode[LogChunkPayload](&decoded)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), chunk.Sequence)
+
+	assert.Error(t, ProtobufCodec.Unmarshal([]byte{0x0a, 0x05, 'a'}, &decoded))
+	assert.ErrorContains(t, ProtobufCodec.Unmarshal(nil, &decoded), "no type")
+}
+
+func TestProtobufCodec_ChunkDataAsBytes(t *testing.T) {
+	raw := make([]byte, chunkDataSize)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	msg, err := NewPayloadMessage("req-1", ChunkDataPayload{TransferID: "transfer-1", Index: 2, Data: raw})
+	require.NoError(t, err)
+
+	// The data is sent as is, not as the base64 of the JSON payload
+	data, err := ProtobufCodec.Marshal(msg)
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(data, raw))
+	assert.Less(t, len(data), len(raw)+64)
+
+	var decoded Message
+	require.NoError(t, ProtobufCodec.Unmarshal(data, &decoded))
+	assert.Equal(t, EvtChunkData, decoded.Type)
+	assert.Equal(t, "req-1", decoded.RequestID)
+	chunk, err := Decode[ChunkDataPayload](&decoded)
+	require.NoError(t, err)
+	assert.Equal(t, ChunkDataPayload{TransferID: "transfer-1", Index: 2, Data: raw}, chunk)
+}
+
+func TestClient_NegotiatesCodec(t *testing.T) {
+	spec := "name: app\n" + strings.Repeat("# padding\n", 2000)
+	received := make(chan string, 1)
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			received <- buildSpecYAML
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	client := NewClient()
+	assert.Nil(t, client.Codec())
+	require.NoError(t, client.Connect(wsURL, nil))
+	assert.Equal(t, JSONCodec, client.Codec())
+	client.Close()
+
+	client = NewClient()
+	client.SetCodec(ProtobufCodec)
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	assert.Equal(t, ProtobufCodec, client.Codec())
+
+	// Requests, responses and chunked messages use the negotiated codec
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: spec})
+	require.NoError(t, err)
+	_, err = Decode[BuildQueuedPayload](resp)
+	require.NoError(t, err)
+	assert.Equal(t, spec, <-received)
+}
+
+func TestClient_CodecFallsBackToJSON(t *testing.T) {
+	// A server without subprotocols only speaks JSON
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.upgrader.Subprotocols = nil
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	client.SetCodec(ProtobufCodec)
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+	assert.Equal(t, JSONCodec, client.Codec())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app"})
+	assert.ErrorContains(t, err, "StartBuildFunc not implemented")
+}
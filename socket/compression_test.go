@@ -0,0 +1,63 @@
+package socket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_NegotiatesCompression(t *testing.T) {
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	extensions := func() string {
+		ws, resp, err := (&websocket.Dialer{EnableCompression: true}).Dial(wsURL, nil)
+		require.NoError(t, err)
+		ws.Close()
+		return resp.Header.Get("Sec-Websocket-Extensions")
+	}
+	assert.Contains(t, extensions(), "permessage-deflate")
+	server.SetCompression(false)
+	assert.Empty(t, extensions())
+}
+
+func TestClient_CompressedLogs(t *testing.T) {
+	logs := strings.Repeat("Step 3/12 : RUN go build ./...\n", 200)
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				notifier.NotifyLog(buildID, "stdout", logs)
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	for _, compression := range []bool{true, false} {
+		client := NewClient()
+		client.SetCompression(compression)
+		require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app"})
+		cancel()
+		require.NoError(t, err)
+		chunk, status := receiveBuildMessages(t, client)
+		assert.Equal(t, logs, chunk.Content)
+		assert.Equal(t, "success", status.Status)
+		client.Close()
+	}
+}
@@ -1,8 +1,11 @@
 package socket
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,32 +18,76 @@ const (
 	pongWait = 60 * time.Second
 	// Sending ping to the server after this period. Must be low than pongWait.
 	pingPeriod = (pongWait * 9) / 10
-	// Max message body.
-	maxMessageSize = 8192 // Adjust if the body size can be consequent (ex: build spec)
+	// Max message body, the larger messages are sent in chunks (chunking.go)
+	maxMessageSize = 8192
+	// Smaller messages are not worth compressing when permessage-deflate was negotiated
+	compressionThreshold = 512
 )
 
+// SendPolicy bounds the messages queued for a peer which reads slower than they are sent
+type SendPolicy struct {
+	BufferSize int           // Messages queued for the writePump
+	Timeout    time.Duration // Wait for room in a full queue before closing the connection as a slow consumer
+}
+
+// HeartbeatPolicy configures the pings detecting the dead and the slow connections
+type HeartbeatPolicy struct {
+	PingInterval  time.Duration // Period of the pings
+	PongTimeout   time.Duration // Silence of the peer after which the connection is closed, longer than PingInterval
+	SlowThreshold time.Duration // Round trip of a ping above which the connection is reported slow, 0 never reports
+}
+
+// DefaultHeartbeatPolicy is the heartbeat policy of the servers and of the new clients
+var DefaultHeartbeatPolicy = HeartbeatPolicy{PingInterval: pingPeriod, PongTimeout: pongWait}
+
+// DefaultSendPolicy is the send policy of the new servers and clients. The notifier sends the messages
+// of a build under the send lock of the build, Timeout bounds how long a slow client delays the other
+// subscribers of the same build.
+var DefaultSendPolicy = SendPolicy{BufferSize: 256, Timeout: 2 * time.Second}
+
 type connection struct {
-	ws   *websocket.Conn
-	send chan *Message // Channel for writing the i/o message
+	ws       *websocket.Conn
+	send     chan *Message     // Channel for writing the i/o message
+	done     chan struct{}     // Closed by closeSend, stops the writePump once the queue is flushed
+	once     sync.Once         // Closing done
+	closeMsg []byte            // Close frame sent by the writePump, set before done is closed
+	slow     atomic.Bool       // Closed as a slow consumer, the queue is not flushed
+	timeout  time.Duration     // SendPolicy.Timeout
+	codec    Codec             // Codec negotiated at the handshake
+	caller   string            // Name of the authenticated peer, server side
+	metrics  *Metrics          // Server side, nil without instrumentation
+	transfer *incomingTransfer // Chunked message being received, only used by readPump
+
+	heartbeat HeartbeatPolicy
+	onSlow    func(rtt time.Duration) // Called by readPump for the slow pongs, nil to ignore them
+	readErr   error                   // Error ending the readPump, set before its disconnect callback
 }
 
 // creating a new connection struct.
-func newConnection(ws *websocket.Conn) *connection {
+func newConnection(ws *websocket.Conn, policy SendPolicy) *connection {
+	if policy.BufferSize <= 0 {
+		policy.BufferSize = DefaultSendPolicy.BufferSize
+	}
 	return &connection{
-		ws:   ws,
-		send: make(chan *Message, 256),
+		ws:        ws,
+		send:      make(chan *Message, policy.BufferSize),
+		done:      make(chan struct{}),
+		timeout:   policy.Timeout,
+		codec:     codecFor(ws.Subprotocol()),
+		heartbeat: DefaultHeartbeatPolicy,
 	}
 }
 
 // fetching message from the channels 'send' to the WebSocket connection.
 func (c *connection) write(msgType int, payload []byte) error {
 	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	c.ws.EnableWriteCompression(len(payload) >= compressionThreshold)
 	return c.ws.WriteMessage(msgType, payload)
 }
 
 // Handling sorting and periodical ping messages to the server
 func (c *connection) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.heartbeat.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.ws.Close()
@@ -49,43 +96,32 @@ func (c *connection) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				log.Println("writePump: Send channel closed, closing connection.")
-				c.write(websocket.CloseMessage, []byte{})
+		case message := <-c.send:
+			if err := c.writeMessage(message); err != nil {
+				log.Printf("writePump: %v\n", err)
 				return
 			}
 
-			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
-			w, err := c.ws.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Printf("writePump: Error getting next writer: %v\n", err)
+		case <-c.done:
+			if c.slow.Load() {
+				log.Println("writePump: Closing the connection of a slow consumer.")
+				c.write(websocket.CloseMessage, c.closeMsg)
 				return
 			}
-			jsonBytes, err := json.Marshal(message)
-			if err != nil {
-				log.Printf("writePump: Error marshaling message type %s: %v\n", message.Type, err)
-				// Don't return try to send the next message
-				w.Close() // Close the actual writer
-				continue
-			}
-
-			_, err = w.Write(jsonBytes)
-			if err != nil {
-				log.Printf("writePump: Error writing JSON: %v\n", err)
-				w.Close()
+			log.Println("writePump: Send channel closed, closing connection.")
+			for len(c.send) > 0 {
+				if err := c.writeMessage(<-c.send); err != nil {
+					log.Printf("writePump: %v\n", err)
+					return
+				}
 			}
-
-			if err := w.Close(); err != nil {
-				log.Printf("writePump: Error closing writer: %v\n", err)
-				return
-			}
-			log.Printf("writePump: Sent message type %s", message.Type) // Debug
+			c.write(websocket.CloseMessage, c.closeMsg)
+			return
 
 		case <-ticker.C:
-			// Sending a periodical ping message
+			// Sending a periodical ping message, with its time to measure the round trip on the pong
 			log.Println("writePump: Sending ping") // Debug
-			if err := c.write(websocket.PingMessage, nil); err != nil {
+			if err := c.write(websocket.PingMessage, []byte(strconv.FormatInt(time.Now().UnixNano(), 10))); err != nil {
 				log.Printf("writePump: Error sending ping: %v\n", err)
 				return
 			}
@@ -93,6 +129,41 @@ func (c *connection) writePump() {
 	}
 }
 
+// writeMessage encodes and writes a message, it returns an error when the connection is unusable
+func (c *connection) writeMessage(message *Message) error {
+	encoded, err := c.codec.Marshal(message)
+	if err != nil {
+		log.Printf("writePump: Error marshaling message type %s: %v\n", message.Type, err)
+		// Don't return try to send the next message
+		return nil
+	}
+	if len(encoded) > maxMessageSize {
+		if err := c.writeChunked(message, encoded); err != nil {
+			return fmt.Errorf("error writing chunked message type %s: %w", message.Type, err)
+		}
+		log.Printf("writePump: Sent message type %s in chunks (%d bytes)", message.Type, len(encoded)) // Debug
+		return nil
+	}
+
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	c.ws.EnableWriteCompression(len(encoded) >= compressionThreshold)
+	w, err := c.ws.NextWriter(c.codec.FrameType())
+	if err != nil {
+		return fmt.Errorf("error getting next writer: %w", err)
+	}
+
+	_, err = w.Write(encoded)
+	if err != nil {
+		log.Printf("writePump: Error writing message: %v\n", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error closing writer: %w", err)
+	}
+	log.Printf("writePump: Sent message type %s", message.Type) // Debug
+	return nil
+}
+
 // Handling entering message
 func (c *connection) readPump(handler func(msg *Message, conn *connection) error, disconnect func(conn *connection)) {
 	defer func() {
@@ -102,10 +173,18 @@ func (c *connection) readPump(handler func(msg *Message, conn *connection) error
 	}()
 
 	c.ws.SetReadLimit(maxMessageSize)
-	c.ws.SetReadDeadline(time.Now().Add(pongWait))
-	c.ws.SetPongHandler(func(string) error {
+	pongTimeout := c.heartbeat.PongTimeout
+	c.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.ws.SetPongHandler(func(data string) error {
 		log.Println("readPump: Received pong") // Debug
-		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		c.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+		sent, err := strconv.ParseInt(data, 10, 64)
+		if err != nil || c.onSlow == nil || c.heartbeat.SlowThreshold <= 0 {
+			return nil
+		}
+		if rtt := time.Since(time.Unix(0, sent)); rtt > c.heartbeat.SlowThreshold {
+			c.onSlow(rtt)
+		}
 		return nil
 	})
 
@@ -119,45 +198,101 @@ func (c *connection) readPump(handler func(msg *Message, conn *connection) error
 			} else {
 				log.Printf("readPump: Unhandled WebSocket read error: %v\n", err)
 			}
+			c.readErr = err
 			break
 		}
 
-		// Ignore non text message
-		if msgType != websocket.TextMessage {
-			log.Printf("readPump: Received non-text message type: %d\n", msgType)
+		// Ignore the frames of another codec
+		if msgType != c.codec.FrameType() {
+			log.Printf("readPump: Received unexpected frame type %d for codec %s\n", msgType, c.codec.Subprotocol())
 			continue
 		}
 
-		log.Printf("readPump: Received raw message: %s", string(messageBytes)) // Debug
+		log.Printf("readPump: Received raw message: %q", messageBytes) // Debug
 
 		var msg Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("readPump: Error unmarshaling message: %v --- Raw: %s\n", err, string(messageBytes))
-			errMsg := NewErrorMessage("", "Invalid message format", err.Error())
-			c.send <- errMsg
+		if err := c.codec.Unmarshal(messageBytes, &msg); err != nil {
+			log.Printf("readPump: Error unmarshaling message: %v --- Raw: %q\n", err, messageBytes)
+			errMsg := NewErrorMessage("", ErrCodeInvalidPayload, "Invalid message format", err.Error())
+			c.sendMsg(errMsg)
 			continue
 		}
 
-		if err := handler(&msg, c); err != nil {
-			log.Printf("readPump: Error handling message type %s: %v\n", msg.Type, err)
-			errMsg := NewErrorMessage(msg.RequestID, "Failed to handle request", err.Error())
-			c.send <- errMsg
+		handled := &msg
+		if isChunkEvent(msg.Type) {
+			handled, err = c.receiveChunk(&msg)
+			if err != nil {
+				log.Printf("readPump: Invalid chunked transfer: %v\n", err)
+				c.sendMsg(NewErrorMessage(msg.RequestID, ErrCodeInvalidPayload, "Invalid chunked transfer", err.Error()))
+			}
+			if handled == nil {
+				c.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+				continue
+			}
 		}
 
-		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		if err := handler(handled, c); err != nil {
+			log.Printf("readPump: Error handling message type %s: %v\n", handled.Type, err)
+			errMsg := NewErrorMessage(handled.RequestID, CodeOf(err), "Failed to handle request", err.Error())
+			c.sendMsg(errMsg)
+		}
+
+		c.ws.SetReadDeadline(time.Now().Add(pongTimeout))
 	}
 }
 
-// sending message asynchronously via the websocket.
-func (c *connection) sendMsg(msg *Message) {
+// sending message asynchronously via the websocket, see queue
+func (c *connection) sendMsg(msg *Message) bool {
+	queued := c.queue(msg)
+	c.metrics.messageSent(msg, queued)
+	return queued
+}
+
+// queue adds a message to the queue of the writePump. When the queue is full, it waits up to the
+// timeout of the send policy, then closes the connection as a slow consumer (the clients reconnect
+// and attach again to their builds). It returns false when the message was not queued.
+func (c *connection) queue(msg *Message) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
 	select {
 	case c.send <- msg:
+		return true
 	default:
-		log.Printf("Warning: Send channel full for connection %p. Message type %s dropped.\n", c.ws, msg.Type)
 	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return false
+	case <-timer.C:
+		log.Printf("Warning: Send channel full for connection %p for %s. Message type %s dropped, closing the slow connection.\n", c.ws, c.timeout, msg.Type)
+		c.closeSlow()
+		return false
+	}
+}
+
+// closeSlow stops the writePump of a connection which does not read its messages, it sends the
+// try again later close code once its pending write is done
+func (c *connection) closeSlow() {
+	c.slow.Store(true)
+	c.closeSendWith(websocket.CloseTryAgainLater, "slow consumer")
 }
 
-// closing the send channel and stopping the writePump function.
+// stopping the writePump function once the queued messages are sent, the next messages are dropped.
 func (c *connection) closeSend() {
-	close(c.send)
+	c.closeSendWith(websocket.CloseNormalClosure, "")
+}
+
+// closeSendWith is closeSend with the code and reason of the close frame, only the first call counts
+func (c *connection) closeSendWith(code int, reason string) {
+	c.once.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, reason)
+		close(c.done)
+	})
 }
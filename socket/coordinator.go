@@ -0,0 +1,464 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Coordinator schedules the builds of its server on the build agents connected to it (see Agent),
+// and relays the log chunks and status sent by the agents to the subscribers of the builds. It is
+// the build service of the server:
+//
+//	coordinator := socket.NewCoordinator()
+//	coordinator.SetAgentCallers("agent-1", "agent-2")
+//	server := socket.NewServer(coordinator, secrets, originChecker)
+//	coordinator.Register(server)
+//
+// Only the callers set with SetAgentCallers register as agents, an agent name is kept by the caller
+// which registered it until the agent is forgotten.
+type Coordinator struct {
+	mu           sync.Mutex
+	agentCallers map[string]bool              // Callers allowed to register as agents
+	agents       map[string]*remoteAgent      // Registered agents by name, connected or not
+	conns        map[*connection]*remoteAgent // Connected agents
+	builds       map[string]*coordinatedBuild // Builds queued or running on an agent
+	queue        []*coordinatedBuild          // Builds waiting for an agent, oldest first
+	pending      []func()                     // Notifier calls collected under mu, made by unlock
+	lostTimeout  time.Duration
+}
+
+// Time the builds of a disconnected agent wait for it to register again before failing
+const defaultAgentLostTimeout = time.Minute
+
+// remoteAgent is an agent registered with the coordinator
+type remoteAgent struct {
+	info    AgentInfo
+	caller  string          // Caller which registered the agent
+	conn    *connection     // nil while the agent is disconnected
+	running map[string]bool // Builds scheduled on the agent without final status
+	lost    *time.Timer     // Fails the builds of the agent, started by its disconnect
+}
+
+// coordinatedBuild is a build waiting for an agent or running on one
+type coordinatedBuild struct {
+	id           string
+	spec         string
+	requirements BuildRequirements
+	notifier     BuildNotifier
+	agent        *remoteAgent // nil while queued
+	position     int          // Last position in the queue sent to the subscribers
+}
+
+// agentBuildError is a build failure reported by an agent, with its code
+type agentBuildError struct {
+	message string
+	code    string
+}
+
+func (e *agentBuildError) Error() string     { return e.message }
+func (e *agentBuildError) ErrorCode() string { return e.code }
+
+type buildRequirementsKey struct{}
+
+// WithBuildRequirements returns a context carrying the requirements of a build, the server passes
+// it to StartBuildAsync when the build request has requirements
+func WithBuildRequirements(ctx context.Context, requirements BuildRequirements) context.Context {
+	return context.WithValue(ctx, buildRequirementsKey{}, requirements)
+}
+
+// BuildRequirementsFrom returns the requirements carried by the context, the zero value without any
+func BuildRequirementsFrom(ctx context.Context) BuildRequirements {
+	requirements, _ := ctx.Value(buildRequirementsKey{}).(BuildRequirements)
+	return requirements
+}
+
+// matches reports whether an agent has the capabilities required
+func (r BuildRequirements) matches(info AgentInfo) bool {
+	if r.Arch != "" && r.Arch != info.Arch {
+		return false
+	}
+	for key, value := range r.Labels {
+		if label, ok := info.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
+// capacity returns the builds the agent runs at the same time
+func (info AgentInfo) capacity() int {
+	return max(info.Capacity, 1)
+}
+
+func NewCoordinator() *Coordinator {
+	return &Coordinator{
+		agentCallers: make(map[string]bool),
+		agents:       make(map[string]*remoteAgent),
+		conns:        make(map[*connection]*remoteAgent),
+		builds:       make(map[string]*coordinatedBuild),
+		lostTimeout:  defaultAgentLostTimeout,
+	}
+}
+
+// SetLostTimeout sets the time the builds of a disconnected agent wait for it to register again
+// before failing (one minute by default)
+func (c *Coordinator) SetLostTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lostTimeout = timeout
+}
+
+// SetAgentCallers sets the callers allowed to register as agents, the names returned by the
+// Authenticator of the server. Without Authenticator every caller is empty, the empty name allows
+// any connection. No caller is allowed by default.
+func (c *Coordinator) SetAgentCallers(callers ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agentCallers = make(map[string]bool, len(callers))
+	for _, caller := range callers {
+		c.agentCallers[caller] = true
+	}
+}
+
+// Register handles the events of the agents on the server, created with the coordinator as build service
+func (c *Coordinator) Register(server *Server) {
+	server.Handle(EvtAgentRegister, c.handleRegister)
+	server.Handle(EvtLogChunk, c.handleLogChunk)
+	server.Handle(EvtBuildStatus, c.handleBuildStatus)
+	server.Handle(EvtBuildQueued, c.handleProgress)
+	server.Handle(EvtBuildProgress, c.handleProgress)
+	server.handlersMu.Lock()
+	defer server.handlersMu.Unlock()
+	server.onClose = append(server.onClose, c.agentDisconnected)
+}
+
+// Agents returns the registered agents sorted by name, the disconnected ones included until their builds fail
+func (c *Coordinator) Agents() []AgentInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agents := make([]AgentInfo, 0, len(c.agents))
+	for _, agent := range c.agents {
+		agents = append(agents, agent.info)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return agents
+}
+
+// notify defers a call to the notifier of a build until c.mu is released. c.mu must be held.
+func (c *Coordinator) notify(call func()) {
+	c.pending = append(c.pending, call)
+}
+
+// unlock releases c.mu, then makes the notifier calls collected meanwhile: a slow subscriber
+// does not hold the agents and the other builds
+func (c *Coordinator) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, call := range pending {
+		call()
+	}
+}
+
+// StartBuildAsync queues the build until an agent matching its requirements has room for it
+func (c *Coordinator) StartBuildAsync(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+	c.mu.Lock()
+	defer c.unlock()
+	build := &coordinatedBuild{
+		id:           buildID,
+		spec:         buildSpecYAML,
+		requirements: BuildRequirementsFrom(ctx),
+		notifier:     notifier,
+	}
+	c.builds[buildID] = build
+	c.queue = append(c.queue, build)
+	c.schedule()
+	return nil
+}
+
+// QueuePosition returns the 1 based position of a build waiting for an agent
+func (c *Coordinator) QueuePosition(buildID string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, build := range c.queue {
+		if build.id == buildID {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// schedule assigns the queued builds to the agents, a build without matching agent does not hold
+// the next ones. The builds left in the queue are sent their new position once c.mu is released.
+// c.mu must be held.
+func (c *Coordinator) schedule() {
+	var queue []*coordinatedBuild
+	for _, build := range c.queue {
+		if !c.assign(build) {
+			queue = append(queue, build)
+		}
+	}
+	c.queue = queue
+	for i, build := range queue {
+		if build.position == i+1 {
+			continue
+		}
+		build.position = i + 1
+		if notifier, ok := build.notifier.(ProgressNotifier); ok {
+			buildID, position := build.id, build.position
+			c.notify(func() { notifier.NotifyQueued(buildID, position, 0) })
+		}
+	}
+}
+
+// assign sends a build to the least loaded agent matching its requirements with room for it. It
+// returns false when there is none. c.mu must be held.
+func (c *Coordinator) assign(build *coordinatedBuild) bool {
+	var best *remoteAgent
+	for _, agent := range c.agents {
+		if agent.conn == nil || len(agent.running) >= agent.info.capacity() || !build.requirements.matches(agent.info) {
+			continue
+		}
+		if best == nil || len(agent.running) < len(best.running) ||
+			(len(agent.running) == len(best.running) && agent.info.Name < best.info.Name) {
+			best = agent
+		}
+	}
+	if best == nil {
+		return false
+	}
+
+	msg, err := NewPayloadMessage("", AgentJobPayload{BuildID: build.id, BuildSpecYAML: build.spec})
+	if err != nil {
+		log.Printf("Coordinator: Failed to create the job of build %s: %v\n", build.id, err)
+		return false
+	}
+	if !best.conn.sendMsg(msg) {
+		// Closing, the disconnect of the agent follows
+		log.Printf("Coordinator: Failed to send build %s to agent %s\n", build.id, best.info.Name)
+		return false
+	}
+	build.agent = best
+	best.running[build.id] = true
+	log.Printf("Coordinator: Build %s scheduled on agent %s\n", build.id, best.info.Name)
+	content := fmt.Sprintf("Build scheduled on agent %s\n", best.info.Name)
+	c.notify(func() { build.notifier.NotifyLog(build.id, "system", content) })
+	return true
+}
+
+// fail ends a build with a failure reported by the coordinator, sent once c.mu is released. c.mu must be held.
+func (c *Coordinator) fail(build *coordinatedBuild, err error) {
+	c.remove(build)
+	c.notify(func() { build.notifier.NotifyStatus(build.id, "failure", "", err, nil) })
+}
+
+// remove forgets a finished build. c.mu must be held.
+func (c *Coordinator) remove(build *coordinatedBuild) {
+	delete(c.builds, build.id)
+	if build.agent != nil {
+		delete(build.agent.running, build.id)
+	}
+}
+
+func (c *Coordinator) handleRegister(ctx context.Context, req *Request) error {
+	payload, err := Decode[AgentRegisterPayload](req.Message)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid agent register payload: %w", err)
+	}
+	info := payload.Agent
+	if info.Name == "" {
+		return codedErrorf(ErrCodeInvalidPayload, "agent name cannot be empty")
+	}
+
+	conn, ok := req.conn.(*connection)
+	if !ok {
+		return codedErrorf(ErrCodeUnsupported, "agents register over a websocket connection")
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	if !c.agentCallers[req.Caller] {
+		log.Printf("Coordinator: Caller '%s' refused as agent %s\n", req.Caller, info.Name)
+		return codedErrorf(ErrCodeUnauthorized, "caller '%s' is not allowed to register as an agent", req.Caller)
+	}
+	if other, ok := c.conns[conn]; ok && other.info.Name != info.Name {
+		return codedErrorf(ErrCodeInvalidPayload, "connection already registered as agent '%s'", other.info.Name)
+	}
+	agent, ok := c.agents[info.Name]
+	if ok && agent.caller != req.Caller {
+		// Connected or waiting for its reconnection, the agent keeps its builds
+		return codedErrorf(ErrCodeUnauthorized, "agent '%s' is registered by another caller", info.Name)
+	}
+	if !ok {
+		agent = &remoteAgent{caller: req.Caller, running: make(map[string]bool)}
+		c.agents[info.Name] = agent
+	}
+	if agent.lost != nil {
+		agent.lost.Stop()
+		agent.lost = nil
+	}
+	if agent.conn != nil && agent.conn != conn {
+		// The agent reconnected before the disconnect of its previous connection was noticed
+		log.Printf("Coordinator: Agent %s registered again from another connection\n", info.Name)
+		delete(c.conns, agent.conn)
+		agent.conn.closeSend()
+	}
+	agent.info, agent.conn = info, conn
+	c.conns[conn] = agent
+
+	// The builds the agent does not run anymore were lost with its previous process
+	reported := make(map[string]bool, len(payload.Running))
+	for _, buildID := range payload.Running {
+		reported[buildID] = true
+	}
+	for buildID := range agent.running {
+		if !reported[buildID] {
+			c.fail(c.builds[buildID], fmt.Errorf("build lost by agent '%s'", info.Name))
+		}
+	}
+	var lost []string
+	for _, buildID := range payload.Running {
+		if !agent.running[buildID] {
+			lost = append(lost, buildID)
+		}
+	}
+	log.Printf("Coordinator: Agent %s registered (%s/%s, capacity %d, %d builds running)\n",
+		info.Name, info.OS, info.Arch, info.capacity(), len(agent.running))
+
+	// The agent receives its jobs after the response
+	err = req.Reply(AgentRegisteredPayload{Name: info.Name, Lost: lost})
+	c.schedule()
+	return err
+}
+
+// agentBuild returns a build scheduled on the agent of a connection. c.mu must be held.
+func (c *Coordinator) agentBuild(client peer, buildID string) (*coordinatedBuild, error) {
+	conn, _ := client.(*connection)
+	agent, ok := c.conns[conn]
+	if !ok {
+		return nil, codedErrorf(ErrCodeUnauthorized, "connection not registered as an agent")
+	}
+	build, ok := c.builds[buildID]
+	if !ok || build.agent != agent {
+		return nil, codedErrorf(ErrCodeBuildNotFound, "build '%s' not scheduled on agent '%s'", buildID, agent.info.Name)
+	}
+	return build, nil
+}
+
+func (c *Coordinator) handleLogChunk(ctx context.Context, req *Request) error {
+	payload, err := Decode[LogChunkPayload](req.Message)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid log chunk payload: %w", err)
+	}
+	c.mu.Lock()
+	build, err := c.agentBuild(req.conn, payload.BuildID)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// The messages of an agent are handled in order, the notifier is called without c.mu
+	build.notifier.NotifyLog(build.id, payload.Stream, payload.Content)
+	return nil
+}
+
+func (c *Coordinator) handleBuildStatus(ctx context.Context, req *Request) error {
+	payload, err := Decode[BuildStatusPayload](req.Message)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid build status payload: %w", err)
+	}
+	c.mu.Lock()
+	build, err := c.agentBuild(req.conn, payload.BuildID)
+	final := err == nil && finalStatus(payload.Status)
+	if final {
+		c.remove(build)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var buildErr error
+	if payload.Message != "" {
+		buildErr = &agentBuildError{message: payload.Message, code: payload.ErrorCode}
+	}
+	build.notifier.NotifyStatus(build.id, payload.Status, payload.ArtifactRef, buildErr, payload.DurationSec)
+	if final {
+		// The room left by the build goes to the queued ones
+		c.mu.Lock()
+		c.schedule()
+		c.unlock()
+	}
+	return nil
+}
+
+// handleProgress relays the queue position or progress of a build reported by its agent
+func (c *Coordinator) handleProgress(ctx context.Context, req *Request) error {
+	event, err := req.Message.Event()
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid %s payload: %w", req.Message.Type, err)
+	}
+	var buildID string
+	switch event := event.(type) {
+	case BuildQueuedPayload:
+		buildID = event.BuildID
+	case BuildProgressPayload:
+		buildID = event.BuildID
+	}
+	c.mu.Lock()
+	build, err := c.agentBuild(req.conn, buildID)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	notifier, ok := build.notifier.(ProgressNotifier)
+	if !ok {
+		return nil
+	}
+	switch event := event.(type) {
+	case BuildQueuedPayload:
+		notifier.NotifyQueued(build.id, event.Position, time.Duration(event.EstimatedWaitSec*float64(time.Second)))
+	case BuildProgressPayload:
+		notifier.NotifyProgress(event)
+	}
+	return nil
+}
+
+// agentDisconnected waits for the agent of a closed connection to register again, its builds
+// fail after the lost timeout
+func (c *Coordinator) agentDisconnected(conn *connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agent, ok := c.conns[conn]
+	if !ok {
+		return
+	}
+	delete(c.conns, conn)
+	agent.conn = nil
+	if len(agent.running) == 0 {
+		delete(c.agents, agent.info.Name)
+		log.Printf("Coordinator: Agent %s disconnected\n", agent.info.Name)
+		return
+	}
+	log.Printf("Coordinator: Agent %s disconnected with %d builds running, waiting %s for it\n", agent.info.Name, len(agent.running), c.lostTimeout)
+	var timer *time.Timer
+	timer = time.AfterFunc(c.lostTimeout, func() {
+		c.mu.Lock()
+		defer c.unlock()
+		if agent.lost == timer {
+			c.agentLost(agent)
+		}
+	})
+	agent.lost = timer
+}
+
+// agentLost fails the builds of an agent which did not register again in time. c.mu must be held.
+func (c *Coordinator) agentLost(agent *remoteAgent) {
+	agent.lost = nil
+	delete(c.agents, agent.info.Name)
+	for buildID := range agent.running {
+		c.fail(c.builds[buildID], fmt.Errorf("agent '%s' disconnected during the build", agent.info.Name))
+	}
+}
@@ -0,0 +1,71 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is the machine readable code of the error messages, the clients branch on it
+type ErrorCode string
+
+const (
+	ErrCodeInvalidPayload ErrorCode = "invalid_payload" // Malformed message or payload, missing field
+	ErrCodeUnauthorized   ErrorCode = "unauthorized"    // Caller not allowed to send the event
+	ErrCodeBuildNotFound  ErrorCode = "build_not_found" // Unknown or forgotten build
+	ErrCodeBuildFinished  ErrorCode = "build_finished"  // Build already finished, it cannot be canceled
+	ErrCodeRateLimited    ErrorCode = "rate_limited"    // Too many requests, try again later
+	ErrCodeUnsupported    ErrorCode = "unsupported"     // Event or feature not handled or not configured on the server
+	ErrCodeUnavailable    ErrorCode = "unavailable"     // Server shutting down
+	ErrCodeInternal       ErrorCode = "internal"        // Any other failure
+)
+
+// CodedError is an error sent to the client with its code
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *CodedError) Error() string { return e.Err.Error() }
+func (e *CodedError) Unwrap() error { return e.Err }
+
+// WithCode attaches the code sent to the client to an error, the handlers return it
+func WithCode(code ErrorCode, err error) error {
+	return &CodedError{Code: code, Err: err}
+}
+
+func codedErrorf(code ErrorCode, format string, args ...any) error {
+	return WithCode(code, fmt.Errorf(format, args...))
+}
+
+// CodeOf returns the code of an error: the one attached by WithCode, the code of the sentinel
+// errors of the package or ErrCodeInternal
+func CodeOf(err error) ErrorCode {
+	var coded *CodedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.Is(err, ErrUnauthorized):
+		return ErrCodeUnauthorized
+	case errors.Is(err, ErrNoLogs):
+		return ErrCodeBuildNotFound
+	case errors.Is(err, ErrInvalidBuildID), errors.Is(err, ErrUnexpectedEvent), errors.Is(err, ErrUnknownEvent):
+		return ErrCodeInvalidPayload
+	}
+	return ErrCodeInternal
+}
+
+// ResponseError is returned by SendRequest when the server answers with an error message
+type ResponseError struct {
+	RequestID string
+	Code      ErrorCode // Empty for the servers sending no code
+	Message   string
+	Details   string
+}
+
+func (e *ResponseError) Error() string {
+	errMsg := e.Message
+	if e.Details != "" {
+		errMsg = fmt.Sprintf("%s: %s", errMsg, e.Details)
+	}
+	return fmt.Sprintf("server error response for request %s: %s", e.RequestID, errMsg)
+}
@@ -0,0 +1,52 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeOf(t *testing.T) {
+	assert.Equal(t, ErrCodeRateLimited, CodeOf(fmt.Errorf("wrapped: %w", WithCode(ErrCodeRateLimited, errors.New("slow down")))))
+	assert.Equal(t, ErrCodeUnauthorized, CodeOf(fmt.Errorf("%w: invalid token", ErrUnauthorized)))
+	assert.Equal(t, ErrCodeBuildNotFound, CodeOf(fmt.Errorf("%w: build-1", ErrNoLogs)))
+	assert.Equal(t, ErrCodeInvalidPayload, CodeOf(fmt.Errorf("%w: got x", ErrUnexpectedEvent)))
+	assert.Equal(t, ErrCodeInternal, CodeOf(errors.New("disk full")))
+}
+
+func TestServer_ErrorCodes(t *testing.T) {
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		eventType EventType
+		payload   any
+		code      ErrorCode
+	}{
+		{EvtBuildRequest, BuildRequestPayload{}, ErrCodeInvalidPayload},
+		{EvtBuildGet, map[string]int{"build_id": 1}, ErrCodeInvalidPayload},
+		{EvtSecretRequest, SecretRequestPayload{Source: "env:TOKEN"}, ErrCodeUnsupported},
+		{"unknown_event", nil, ErrCodeUnsupported},
+		{EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app"}, ErrCodeInternal},
+	} {
+		_, err := client.SendRequest(ctx, test.eventType, test.payload)
+		var respErr *ResponseError
+		require.ErrorAs(t, err, &respErr, test.eventType)
+		assert.Equal(t, test.code, respErr.Code, "%s: %v", test.eventType, err)
+	}
+}
@@ -0,0 +1,123 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnexpectedEvent = errors.New("unexpected event type")
+	ErrUnknownEvent    = errors.New("unknown event type")
+)
+
+// Payload is implemented by the payload structs, each one is paired with the event type carrying it
+type Payload interface {
+	EventType() EventType
+}
+
+func (BuildRequestPayload) EventType() EventType          { return EvtBuildRequest }
+func (BuildQueuedPayload) EventType() EventType           { return EvtBuildQueued }
+func (LogChunkPayload) EventType() EventType              { return EvtLogChunk }
+func (BuildStatusPayload) EventType() EventType           { return EvtBuildStatus }
+func (BuildProgressPayload) EventType() EventType         { return EvtBuildProgress }
+func (SecretRequestPayload) EventType() EventType         { return EvtSecretRequest }
+func (SecretResponsePayload) EventType() EventType        { return EvtSecretResponse }
+func (SecretInvalidatePayload) EventType() EventType      { return EvtSecretInvalidate }
+func (ProjectConfigRequestPayload) EventType() EventType  { return EvtProjectConfigRequest }
+func (ProjectConfigResponsePayload) EventType() EventType { return EvtProjectConfigResponse }
+func (DeploymentsRequestPayload) EventType() EventType    { return EvtDeploymentsRequest }
+func (DeploymentsResponsePayload) EventType() EventType   { return EvtDeploymentsResponse }
+func (BuildAttachPayload) EventType() EventType           { return EvtBuildAttach }
+func (BuildAttachedPayload) EventType() EventType         { return EvtBuildAttached }
+func (BuildListPayload) EventType() EventType             { return EvtBuildList }
+func (BuildListResponsePayload) EventType() EventType     { return EvtBuildListResponse }
+func (BuildGetPayload) EventType() EventType              { return EvtBuildGet }
+func (BuildGetResponsePayload) EventType() EventType      { return EvtBuildGetResponse }
+func (BuildCancelPayload) EventType() EventType           { return EvtBuildCancel }
+func (BuildCancelResponsePayload) EventType() EventType   { return EvtBuildCancelResponse }
+func (LogHistoryPayload) EventType() EventType            { return EvtLogHistory }
+func (LogHistoryResponsePayload) EventType() EventType    { return EvtLogHistoryResponse }
+func (AgentRegisterPayload) EventType() EventType         { return EvtAgentRegister }
+func (AgentRegisteredPayload) EventType() EventType       { return EvtAgentRegistered }
+func (AgentJobPayload) EventType() EventType              { return EvtAgentJob }
+func (ChunkBeginPayload) EventType() EventType            { return EvtChunkBegin }
+func (ChunkDataPayload) EventType() EventType             { return EvtChunkData }
+func (ChunkEndPayload) EventType() EventType              { return EvtChunkEnd }
+func (ErrorPayload) EventType() EventType                 { return EvtError }
+
+// payloadTypes decodes the payload of each event type carrying one (ping and pong have none)
+var payloadTypes = map[EventType]func(*Message) (Payload, error){
+	EvtBuildRequest:          decodeAs[BuildRequestPayload],
+	EvtBuildQueued:           decodeAs[BuildQueuedPayload],
+	EvtLogChunk:              decodeAs[LogChunkPayload],
+	EvtBuildStatus:           decodeAs[BuildStatusPayload],
+	EvtBuildProgress:         decodeAs[BuildProgressPayload],
+	EvtSecretRequest:         decodeAs[SecretRequestPayload],
+	EvtSecretResponse:        decodeAs[SecretResponsePayload],
+	EvtSecretInvalidate:      decodeAs[SecretInvalidatePayload],
+	EvtProjectConfigRequest:  decodeAs[ProjectConfigRequestPayload],
+	EvtProjectConfigResponse: decodeAs[ProjectConfigResponsePayload],
+	EvtDeploymentsRequest:    decodeAs[DeploymentsRequestPayload],
+	EvtDeploymentsResponse:   decodeAs[DeploymentsResponsePayload],
+	EvtBuildAttach:           decodeAs[BuildAttachPayload],
+	EvtBuildAttached:         decodeAs[BuildAttachedPayload],
+	EvtBuildList:             decodeAs[BuildListPayload],
+	EvtBuildListResponse:     decodeAs[BuildListResponsePayload],
+	EvtBuildGet:              decodeAs[BuildGetPayload],
+	EvtBuildGetResponse:      decodeAs[BuildGetResponsePayload],
+	EvtBuildCancel:           decodeAs[BuildCancelPayload],
+	EvtBuildCancelResponse:   decodeAs[BuildCancelResponsePayload],
+	EvtLogHistory:            decodeAs[LogHistoryPayload],
+	EvtLogHistoryResponse:    decodeAs[LogHistoryResponsePayload],
+	EvtAgentRegister:         decodeAs[AgentRegisterPayload],
+	EvtAgentRegistered:       decodeAs[AgentRegisteredPayload],
+	EvtAgentJob:              decodeAs[AgentJobPayload],
+	EvtChunkBegin:            decodeAs[ChunkBeginPayload],
+	EvtChunkData:             decodeAs[ChunkDataPayload],
+	EvtChunkEnd:              decodeAs[ChunkEndPayload],
+	EvtError:                 decodeAs[ErrorPayload],
+}
+
+// Decode returns the payload of a message as T, the message type must be the one paired with T:
+//
+//	status, err := socket.Decode[socket.BuildStatusPayload](msg)
+func Decode[T Payload](msg *Message) (T, error) {
+	var payload T
+	if msg.Type != payload.EventType() {
+		return payload, fmt.Errorf("%w: got %s, expected %s", ErrUnexpectedEvent, msg.Type, payload.EventType())
+	}
+	err := msg.DecodePayload(&payload)
+	return payload, err
+}
+
+func decodeAs[T Payload](msg *Message) (Payload, error) {
+	return Decode[T](msg)
+}
+
+// Event decodes the payload of a message according to its type, for a typed switch:
+//
+//	switch event := event.(type) {
+//	case socket.LogChunkPayload:
+//	case socket.BuildStatusPayload:
+//	}
+//
+// The events without payload (ping, pong) return a nil payload.
+func (m *Message) Event() (Payload, error) {
+	if m.Type == EvtPing || m.Type == EvtPong {
+		return nil, nil
+	}
+	decode, ok := payloadTypes[m.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownEvent, m.Type)
+	}
+	return decode(m)
+}
+
+// NewPayloadMessage returns a message of the event type paired with the payload
+func NewPayloadMessage[T Payload](requestID string, payload T) (*Message, error) {
+	msg := NewMessage(payload.EventType(), requestID)
+	if err := msg.AddPayload(payload); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,250 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/Treefle-labs/Anexis/socket/buildpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService serves the build protocol over gRPC, see buildpb/build.proto
+type grpcService struct {
+	buildpb.UnimplementedBuildServiceServer
+	server *Server
+}
+
+// GRPCService returns the gRPC service of the build protocol, to register on a gRPC server:
+//
+//	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+//	buildpb.RegisterBuildServiceServer(grpcServer, server.GRPCService())
+//
+// The calls go through the Authenticator, with their metadata as headers and the certificate of
+// the client, then through the handlers and the middlewares of the events: the ones of EvtBuildAttach
+// for StreamLogs and GetStatus. StreamLogs resumes after the sequence of the last log chunk received,
+// like the attachment of the websocket clients.
+func (s *Server) GRPCService() buildpb.BuildServiceServer {
+	return &grpcService{server: s}
+}
+
+func (g *grpcService) SubmitBuild(ctx context.Context, req *buildpb.SubmitBuildRequest) (*buildpb.SubmitBuildResponse, error) {
+	payload := BuildRequestPayload{BuildSpecYAML: req.GetBuildSpecYaml()}
+	if requirements := req.GetRequirements(); requirements != nil {
+		payload.Requirements = &BuildRequirements{Arch: requirements.GetArch(), Labels: requirements.GetLabels()}
+	}
+	queued, err := grpcCall[BuildQueuedPayload](ctx, g, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &buildpb.SubmitBuildResponse{BuildId: queued.BuildID, Message: queued.Message, Position: int32(queued.Position)}, nil
+}
+
+func (g *grpcService) StreamLogs(req *buildpb.StreamLogsRequest, stream grpc.ServerStreamingServer[buildpb.BuildEvent]) error {
+	caller, err := g.caller(stream.Context())
+	if err != nil {
+		return err
+	}
+	buildID := req.GetBuildId()
+	updates := newStreamPeer()
+	detach, failure := g.server.attachStream(caller, buildID, req.GetAfterSequence(), updates)
+	if failure != nil {
+		return grpcError(failure)
+	}
+	defer detach()
+	for {
+		select {
+		case msg := <-updates.messages:
+			event, err := grpcBuildEvent(msg)
+			if err != nil {
+				log.Printf("gRPC: Dropped %s of build %s: %v\n", msg.Type, buildID, err)
+				continue
+			}
+			if event == nil {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			if buildStatus := event.GetStatus(); buildStatus != nil && finalStatus(buildStatus.GetStatus()) {
+				return nil
+			}
+		case <-updates.overflow:
+			return status.Error(codes.ResourceExhausted, "log stream too slow, resume it after the last sequence received")
+		case <-g.server.hub.quit:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (g *grpcService) GetStatus(ctx context.Context, req *buildpb.GetStatusRequest) (*buildpb.BuildStatus, error) {
+	caller, err := g.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Attached after the last log chunk, the build sends its latest progress and status only
+	updates := newStreamPeer()
+	detach, failure := g.server.attachStream(caller, req.GetBuildId(), math.MaxInt64, updates)
+	if failure != nil {
+		return nil, grpcError(failure)
+	}
+	detach()
+	var msg *Message
+	for len(updates.messages) > 0 {
+		if update := <-updates.messages; update.Type == EvtBuildStatus {
+			msg = update
+		}
+	}
+	if msg == nil {
+		// Accepted, no status reported yet
+		return &buildpb.BuildStatus{BuildId: req.GetBuildId(), Status: "queued"}, nil
+	}
+	buildStatus, err := Decode[BuildStatusPayload](msg)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid status of build '%s': %v", req.GetBuildId(), err)
+	}
+	return grpcBuildStatus(buildStatus), nil
+}
+
+func (g *grpcService) FetchSecret(ctx context.Context, req *buildpb.FetchSecretRequest) (*buildpb.FetchSecretResponse, error) {
+	secret, err := grpcCall[SecretResponsePayload](ctx, g, SecretRequestPayload{Source: req.GetSource()})
+	if err != nil {
+		return nil, err
+	}
+	return &buildpb.FetchSecretResponse{Source: secret.Source, Value: secret.Value}, nil
+}
+
+// caller authenticates a gRPC call
+func (g *grpcService) caller(ctx context.Context) (string, error) {
+	if g.server.auth == nil {
+		return "", nil
+	}
+	r := grpcRequest(ctx)
+	caller, err := g.server.auth.Authenticate(r)
+	if err != nil {
+		log.Printf("gRPC: Rejected call from %s: %v\n", r.RemoteAddr, err)
+		return "", status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return caller, nil
+}
+
+// grpcCall dispatches an event for a gRPC call and decodes the reply of its handler
+func grpcCall[T Payload](ctx context.Context, g *grpcService, payload Payload) (T, error) {
+	var result T
+	caller, err := g.caller(ctx)
+	if err != nil {
+		return result, err
+	}
+	resp := g.server.request(caller, payload)
+	if _, failed := replyError(resp); failed {
+		return result, grpcError(resp)
+	}
+	if result, err = Decode[T](resp); err != nil {
+		return result, status.Errorf(codes.Internal, "invalid %s reply: %v", resp.Type, err)
+	}
+	return result, nil
+}
+
+// grpcError returns the gRPC status of an error reply
+func grpcError(resp *Message) error {
+	errPayload, _ := replyError(resp)
+	errMsg := resp.Error
+	if errPayload.Details != "" {
+		errMsg = fmt.Sprintf("%s: %s", errMsg, errPayload.Details)
+	}
+	return status.Error(grpcCode(errPayload.Code), errMsg)
+}
+
+// grpcRequest returns an HTTP request for the Authenticator, with the metadata of a gRPC call as
+// headers and the TLS state of its connection
+func grpcRequest(ctx context.Context) *http.Request {
+	method, _ := grpc.Method(ctx)
+	r := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: method}, Header: make(http.Header)}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		r.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	if client, ok := grpcpeer.FromContext(ctx); ok {
+		r.RemoteAddr = client.Addr.String()
+		if tlsInfo, ok := client.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &tlsInfo.State
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// grpcBuildEvent converts a build update, nil for the messages without gRPC counterpart
+func grpcBuildEvent(msg *Message) (*buildpb.BuildEvent, error) {
+	payload, err := msg.Event()
+	if err != nil {
+		return nil, err
+	}
+	switch payload := payload.(type) {
+	case LogChunkPayload:
+		return &buildpb.BuildEvent{Event: &buildpb.BuildEvent_LogChunk{LogChunk: &buildpb.LogChunk{
+			BuildId:  payload.BuildID,
+			Stream:   payload.Stream,
+			Content:  payload.Content,
+			Sequence: payload.Sequence,
+		}}}, nil
+	case BuildStatusPayload:
+		return &buildpb.BuildEvent{Event: &buildpb.BuildEvent_Status{Status: grpcBuildStatus(payload)}}, nil
+	case BuildQueuedPayload:
+		return &buildpb.BuildEvent{Event: &buildpb.BuildEvent_Queued{Queued: &buildpb.BuildQueued{
+			BuildId:          payload.BuildID,
+			Message:          payload.Message,
+			Position:         int32(payload.Position),
+			EstimatedWaitSec: payload.EstimatedWaitSec,
+		}}}, nil
+	case BuildProgressPayload:
+		return &buildpb.BuildEvent{Event: &buildpb.BuildEvent_Progress{Progress: &buildpb.BuildProgress{
+			BuildId: payload.BuildID,
+			Phase:   payload.Phase,
+			Service: payload.Service,
+			Current: int32(payload.Current),
+			Total:   int32(payload.Total),
+			Message: payload.Message,
+		}}}, nil
+	}
+	return nil, nil
+}
+
+func grpcBuildStatus(payload BuildStatusPayload) *buildpb.BuildStatus {
+	return &buildpb.BuildStatus{
+		BuildId:     payload.BuildID,
+		Status:      payload.Status,
+		Message:     payload.Message,
+		ErrorCode:   payload.ErrorCode,
+		ArtifactRef: payload.ArtifactRef,
+		DurationSec: payload.DurationSec,
+	}
+}
+
+// grpcCode returns the gRPC code of an error code
+func grpcCode(code ErrorCode) codes.Code {
+	switch code {
+	case ErrCodeInvalidPayload:
+		return codes.InvalidArgument
+	case ErrCodeUnauthorized:
+		return codes.PermissionDenied
+	case ErrCodeBuildNotFound:
+		return codes.NotFound
+	case ErrCodeRateLimited:
+		return codes.ResourceExhausted
+	case ErrCodeUnsupported:
+		return codes.Unimplemented
+	case ErrCodeUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
@@ -0,0 +1,171 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Treefle-labs/Anexis/socket/buildpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) GetSecret(ctx context.Context, source string) (string, error) {
+	return f[source], nil
+}
+
+// startGRPCServer serves the gRPC service of a server in memory and returns a client of it
+func startGRPCServer(t *testing.T, buildSvc BuildTriggerer, auth Authenticator, middlewares ...Middleware) buildpb.BuildServiceClient {
+	t.Helper()
+	server := NewServer(buildSvc, fakeSecrets{"vault://db": "hunter2"}, func(r *http.Request) bool { return true })
+	if auth != nil {
+		server.SetAuthenticator(auth)
+	}
+	server.Use(middlewares...)
+	server.Run()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	buildpb.RegisterBuildServiceServer(grpcServer, server.GRPCService())
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return buildpb.NewBuildServiceClient(conn)
+}
+
+// receiveEvents reads the events of a log stream until the server ends it
+func receiveEvents(t *testing.T, stream grpc.ServerStreamingClient[buildpb.BuildEvent]) []*buildpb.BuildEvent {
+	t.Helper()
+	var events []*buildpb.BuildEvent
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return events
+		}
+		require.NoError(t, err)
+		events = append(events, event)
+	}
+}
+
+func TestGRPC_BuildAndLogStream(t *testing.T) {
+	release := make(chan struct{})
+	client := startGRPCServer(t, &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				<-release
+				notifier.NotifyLog(buildID, "stdout", "step 1")
+				notifier.NotifyLog(buildID, "stdout", "step 2")
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	submitted, err := client.SubmitBuild(ctx, &buildpb.SubmitBuildRequest{BuildSpecYaml: "name: app"})
+	require.NoError(t, err)
+	require.NotEmpty(t, submitted.BuildId)
+	buildStatus, err := client.GetStatus(ctx, &buildpb.GetStatusRequest{BuildId: submitted.BuildId})
+	require.NoError(t, err)
+	assert.Equal(t, "queued", buildStatus.Status)
+
+	stream, err := client.StreamLogs(ctx, &buildpb.StreamLogsRequest{BuildId: submitted.BuildId})
+	require.NoError(t, err)
+	close(release)
+	events := receiveEvents(t, stream)
+	require.Len(t, events, 3)
+	assert.Equal(t, "step 1", events[0].GetLogChunk().GetContent())
+	assert.Equal(t, int64(2), events[1].GetLogChunk().GetSequence())
+	assert.Equal(t, "app:1.0", events[2].GetStatus().GetArtifactRef())
+
+	// Resumed after the first chunk
+	stream, err = client.StreamLogs(ctx, &buildpb.StreamLogsRequest{BuildId: submitted.BuildId, AfterSequence: 1})
+	require.NoError(t, err)
+	events = receiveEvents(t, stream)
+	require.Len(t, events, 2)
+	assert.Equal(t, "step 2", events[0].GetLogChunk().GetContent())
+
+	buildStatus, err = client.GetStatus(ctx, &buildpb.GetStatusRequest{BuildId: submitted.BuildId})
+	require.NoError(t, err)
+	assert.Equal(t, "success", buildStatus.Status)
+}
+
+func TestGRPC_Errors(t *testing.T) {
+	client := startGRPCServer(t, &MockBuildTriggerer{}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := client.SubmitBuild(ctx, &buildpb.SubmitBuildRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.GetStatus(ctx, &buildpb.GetStatusRequest{BuildId: "build-9"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	stream, err := client.StreamLogs(ctx, &buildpb.StreamLogsRequest{BuildId: "build-9"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	secret, err := client.FetchSecret(ctx, &buildpb.FetchSecretRequest{Source: "vault://db"})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret.Value)
+}
+
+func TestGRPC_LogsGoThroughMiddlewares(t *testing.T) {
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			return nil
+		},
+	}
+	refused := 0
+	client := startGRPCServer(t, buildSvc, nil, func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			if req.Message.Type == EvtBuildAttach {
+				refused++
+				return WithCode(ErrCodeUnauthorized, errors.New("logs reserved to the operators"))
+			}
+			return next(ctx, req)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	submitted, err := client.SubmitBuild(ctx, &buildpb.SubmitBuildRequest{BuildSpecYaml: "name: app"})
+	require.NoError(t, err)
+	_, err = client.GetStatus(ctx, &buildpb.GetStatusRequest{BuildId: submitted.BuildId})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	stream, err := client.StreamLogs(ctx, &buildpb.StreamLogsRequest{BuildId: submitted.BuildId})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, 2, refused)
+}
+
+func TestGRPC_Authentication(t *testing.T) {
+	client := startGRPCServer(t, &MockBuildTriggerer{}, NewTokenAuth("secret"))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := client.FetchSecret(ctx, &buildpb.FetchSecretRequest{Source: "vault://db"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	secret, err := client.FetchSecret(ctx, &buildpb.FetchSecretRequest{Source: "vault://db"})
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret.Value)
+}
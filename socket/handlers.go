@@ -0,0 +1,183 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// HandlerFunc handles the messages of an event type. It answers with req.Reply, a returned error
+// is sent to the client as an error message.
+type HandlerFunc func(ctx context.Context, req *Request) error
+
+// Middleware wraps the handlers, to check the caller, log or measure the requests
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Request is a message received from a client
+type Request struct {
+	Message *Message
+	Caller  string // Name returned by the Authenticator, empty without authentication
+	conn    peer
+}
+
+// peer receives the replies and the build updates sent to a client: its websocket connection, or
+// the requests and streams of the REST and gRPC APIs
+type peer interface {
+	sendMsg(msg *Message) bool
+}
+
+// Messages waiting for a slow stream reader, the stream ends beyond them
+const streamPeerBuffer = maxBufferedLogChunks + 64
+
+// replyPeer is the client of a request received outside of a websocket connection, it keeps the reply
+// to the request and passes the build updates to its stream, if any
+type replyPeer struct {
+	requestID string
+	updates   peer // Receives the build updates, nil to drop them
+	mu        sync.Mutex
+	reply     *Message
+}
+
+func (r *replyPeer) sendMsg(msg *Message) bool {
+	r.mu.Lock()
+	if msg.RequestID == r.requestID && r.reply == nil {
+		r.reply = msg
+		r.mu.Unlock()
+		return true
+	}
+	r.mu.Unlock()
+	if msg.RequestID != "" || r.updates == nil {
+		return false
+	}
+	return r.updates.sendMsg(msg)
+}
+
+// streamPeer is the client of a build update stream outside of a websocket connection, the messages
+// wait in a buffer for the writer
+type streamPeer struct {
+	messages chan *Message
+	overflow chan struct{} // Closed when the buffer is full, the stream ends
+	once     sync.Once
+}
+
+func newStreamPeer() *streamPeer {
+	return &streamPeer{messages: make(chan *Message, streamPeerBuffer), overflow: make(chan struct{})}
+}
+
+// sendMsg never blocks, it is called with the send lock of the build held
+func (s *streamPeer) sendMsg(msg *Message) bool {
+	select {
+	case s.messages <- msg:
+		return true
+	default:
+		s.once.Do(func() { close(s.overflow) })
+		return false
+	}
+}
+
+// request dispatches an event received outside of a websocket connection and returns the reply of
+// its handler, an error message when it failed
+func (s *Server) request(caller string, payload Payload) *Message {
+	reply, resp := s.requestTo(caller, payload, nil)
+	// The client of a started build does not receive its updates, it streams them
+	s.notifier.detachClient(reply)
+	return resp
+}
+
+// requestTo dispatches an event received outside of a websocket connection, the build updates sent
+// to the client go to updates. It returns the client, subscribed to the builds the handler attached
+// it to, and the reply of the handler.
+func (s *Server) requestTo(caller string, payload Payload, updates peer) (*replyPeer, *Message) {
+	requestID := uuid.NewString()
+	reply := &replyPeer{requestID: requestID, updates: updates}
+	msg, err := NewPayloadMessage(requestID, payload)
+	if err != nil {
+		return reply, NewErrorMessage(requestID, ErrCodeInvalidPayload, "Invalid payload", err.Error())
+	}
+	err = s.dispatch(msg, reply, caller)
+	if err != nil {
+		return reply, NewErrorMessage(requestID, CodeOf(err), "Failed to handle request", err.Error())
+	}
+	reply.mu.Lock()
+	defer reply.mu.Unlock()
+	if reply.reply == nil {
+		return reply, NewErrorMessage(requestID, ErrCodeInternal, "Failed to handle request", "no reply from the handler")
+	}
+	return reply, reply.reply
+}
+
+// attachStream attaches a stream to a build through the EvtBuildAttach handler and the middlewares,
+// as a websocket client. The kept updates of the build are sent to the stream first. It returns the
+// function detaching the stream, or the error message of the refused or unknown build.
+func (s *Server) attachStream(caller, buildID string, after int64, stream peer) (func(), *Message) {
+	reply, resp := s.requestTo(caller, BuildAttachPayload{BuildIDs: []string{buildID}, Offsets: map[string]int64{buildID: after}}, stream)
+	detach := func() { s.notifier.detachClient(reply) }
+	if _, failed := replyError(resp); failed {
+		detach()
+		return nil, resp
+	}
+	attached, err := Decode[BuildAttachedPayload](resp)
+	if err != nil || len(attached.Attached) == 0 {
+		detach()
+		return nil, NewErrorMessage(resp.RequestID, ErrCodeBuildNotFound, "Build not found", fmt.Sprintf("build '%s' is unknown or forgotten", buildID))
+	}
+	return detach, nil
+}
+
+// replyError returns the error of a reply, false when the request succeeded
+func replyError(resp *Message) (ErrorPayload, bool) {
+	if resp.Type != EvtError && resp.Error == "" {
+		return ErrorPayload{}, false
+	}
+	errPayload, _ := Decode[ErrorPayload](resp)
+	return errPayload, true
+}
+
+// Reply sends a payload answering the request
+func (r *Request) Reply(payload Payload) error {
+	msg, err := NewPayloadMessage(r.Message.RequestID, payload)
+	if err != nil {
+		return err
+	}
+	if !r.conn.sendMsg(msg) {
+		return errors.New("connection closed before the reply")
+	}
+	return nil
+}
+
+// ReplyError sends an error message answering the request
+func (r *Request) ReplyError(code ErrorCode, errMsg, details string) {
+	r.conn.sendMsg(NewErrorMessage(r.Message.RequestID, code, errMsg, details))
+}
+
+// Handle registers the handler of an event type, replacing the built-in one if any
+func (s *Server) Handle(eventType EventType, handler HandlerFunc) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.handlers[eventType] = handler
+}
+
+// Use adds middlewares wrapping every handler, the first one added runs first
+func (s *Server) Use(middlewares ...Middleware) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// registerBuiltinHandlers registers the handlers of the events of the package
+func (s *Server) registerBuiltinHandlers() {
+	s.handlers = map[EventType]HandlerFunc{
+		EvtBuildRequest:         s.handleBuildRequest,
+		EvtSecretRequest:        s.handleSecretRequest,
+		EvtProjectConfigRequest: s.handleProjectConfig,
+		EvtDeploymentsRequest:   s.handleDeployments,
+		EvtBuildList:            s.handleBuildList,
+		EvtBuildGet:             s.handleBuildGet,
+		EvtBuildCancel:          s.handleBuildCancel,
+		EvtLogHistory:           s.handleLogHistory,
+		EvtBuildAttach:          s.handleBuildAttach,
+	}
+}
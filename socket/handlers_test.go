@@ -0,0 +1,116 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	evtEcho         EventType = "echo"
+	evtEchoResponse EventType = "echo_response"
+)
+
+type echoPayload struct {
+	Text string `json:"text"`
+}
+
+type echoResponsePayload struct {
+	Text   string `json:"text"`
+	Caller string `json:"caller"`
+}
+
+func (echoPayload) EventType() EventType         { return evtEcho }
+func (echoResponsePayload) EventType() EventType { return evtEchoResponse }
+
+func TestServer_HandleCustomEvents(t *testing.T) {
+	auth := NewTokenAuth()
+	auth.Add("alice", "secret-a")
+	auth.Add("bob", "secret-b")
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.SetAuthenticator(auth)
+
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, req *Request) error {
+				mu.Lock()
+				calls = append(calls, name+":"+string(req.Message.Type))
+				mu.Unlock()
+				return next(ctx, req)
+			}
+		}
+	}
+	// Only alice may start builds
+	onlyAlice := func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			if req.Message.Type == EvtBuildRequest && req.Caller != "alice" {
+				return WithCode(ErrCodeUnauthorized, fmt.Errorf("%s is not allowed to start builds", req.Caller))
+			}
+			return next(ctx, req)
+		}
+	}
+	server.Use(trace("first"), trace("second"), onlyAlice)
+	server.Handle(evtEcho, func(ctx context.Context, req *Request) error {
+		payload, err := Decode[echoPayload](req.Message)
+		if err != nil {
+			return err
+		}
+		return req.Reply(echoResponsePayload{Text: strings.ToUpper(payload.Text), Caller: req.Caller})
+	})
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), http.Header{"Authorization": {"Bearer secret-b"}}))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := client.SendRequest(ctx, evtEcho, echoPayload{Text: "hello"})
+	require.NoError(t, err)
+	echo, err := Decode[echoResponsePayload](resp)
+	require.NoError(t, err)
+	assert.Equal(t, echoResponsePayload{Text: "HELLO", Caller: "bob"}, echo)
+
+	_, err = client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app"})
+	assert.ErrorContains(t, err, "bob is not allowed to start builds")
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeUnauthorized, respErr.Code)
+	_, err = client.SendRequest(ctx, "unknown_event", nil)
+	assert.ErrorContains(t, err, "not supported by server")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"first:echo", "second:echo", "first:build_request", "second:build_request"}, calls)
+}
+
+func TestServer_HandleReplacesBuiltin(t *testing.T) {
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.Handle(EvtSecretRequest, func(ctx context.Context, req *Request) error {
+		req.ReplyError(ErrCodeUnsupported, "Secrets disabled", "no secret on this server")
+		return nil
+	})
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.SendRequest(ctx, EvtSecretRequest, SecretRequestPayload{Source: "env:TOKEN"})
+	assert.ErrorContains(t, err, "no secret on this server")
+}
@@ -0,0 +1,107 @@
+package socket
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ConnectionHooks(t *testing.T) {
+	_, wsURL := startHeldBuildServer(t, make(chan struct{}))
+	client := NewClient()
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	connects := make(chan struct{}, 2)
+	disconnects := make(chan error, 2)
+	client.OnConnect(func() { connects <- struct{}{} })
+	client.OnDisconnect(func(err error) { disconnects <- err })
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	<-connects
+
+	// Network failure, the client reconnects
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+	require.NoError(t, conn.ws.Close())
+	select {
+	case err := <-disconnects:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+	select {
+	case <-connects:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called after the reconnection")
+	}
+}
+
+func TestClient_HeartbeatDetectsDeadServer(t *testing.T) {
+	// A server which never reads, its pongs are never sent
+	hold := make(chan struct{})
+	defer close(hold)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		<-hold
+	}))
+	defer httpServer.Close()
+
+	client := NewClient()
+	client.SetReconnectPolicy(nil)
+	require.NoError(t, client.SetHeartbeat(HeartbeatPolicy{PingInterval: 20 * time.Millisecond, PongTimeout: 100 * time.Millisecond}))
+	disconnects := make(chan error, 1)
+	client.OnDisconnect(func(err error) { disconnects <- err })
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+
+	select {
+	case err := <-disconnects:
+		var netErr net.Error
+		require.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead server not detected")
+	}
+	assert.False(t, client.IsConnected())
+}
+
+func TestClient_OnSlow(t *testing.T) {
+	_, wsURL := startHeldBuildServer(t, make(chan struct{}))
+	client := NewClient()
+	// Every round trip is above the threshold
+	require.NoError(t, client.SetHeartbeat(HeartbeatPolicy{PingInterval: 20 * time.Millisecond, SlowThreshold: time.Nanosecond}))
+	slow := make(chan time.Duration, 10)
+	client.OnSlow(func(rtt time.Duration) {
+		select {
+		case slow <- rtt:
+		default:
+		}
+	})
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+
+	select {
+	case rtt := <-slow:
+		assert.Positive(t, rtt)
+	case <-time.After(time.Second):
+		t.Fatal("OnSlow not called")
+	}
+}
+
+func TestClient_SetHeartbeatValidation(t *testing.T) {
+	client := NewClient()
+	assert.Error(t, client.SetHeartbeat(HeartbeatPolicy{PingInterval: time.Minute, PongTimeout: time.Second}))
+	require.NoError(t, client.SetHeartbeat(HeartbeatPolicy{PongTimeout: 2 * time.Minute}))
+	assert.Equal(t, DefaultHeartbeatPolicy.PingInterval, client.heartbeat.PingInterval)
+}
@@ -10,6 +10,8 @@ type Hub struct {
 	register   chan *connection     // Channel for connection registration
 	unregister chan *connection     // Channel for connection removing
 	broadcast  chan *Message        // Diffusing message for all registered instance
+	quit       chan struct{}        // Closed by stop, ends the run loop
+	stopOnce   sync.Once
 
 	mu sync.RWMutex
 
@@ -23,6 +25,7 @@ func newHub(handler func(msg *Message, client *connection) error) *Hub {
 		clients:    make(map[*connection]bool),
 		register:   make(chan *connection),
 		unregister: make(chan *connection),
+		quit:       make(chan struct{}),
 		// broadcast:  make(chan *Message),
 		messageHandler: handler,
 	}
@@ -50,6 +53,10 @@ func (h *Hub) run() {
 			}
 			h.mu.Unlock()
 
+		case <-h.quit:
+			log.Println("Hub: Stopping run loop")
+			return
+
 		case message := <-h.broadcast:
 			h.mu.RLock()
 			for conn := range h.clients {
@@ -57,7 +64,7 @@ func (h *Hub) run() {
 				case conn.send <- message:
 				default:
 					log.Printf("Hub: Broadcast failed for client %p, closing its send channel.\n", conn.ws)
-					close(conn.send)       
+					conn.closeSend()
 					delete(h.clients, conn)
 				}
 			}
@@ -67,9 +74,51 @@ func (h *Hub) run() {
 	}
 }
 
+// registerConn registers a new connection, it returns false once the hub is stopped
+func (h *Hub) registerConn(conn *connection) bool {
+	select {
+	case h.register <- conn:
+		return true
+	case <-h.quit:
+		return false
+	}
+}
+
 // Calling this handler if a connection is disconnected
 func (h *Hub) handleDisconnect(conn *connection) {
-	h.unregister <- conn
+	select {
+	case h.unregister <- conn:
+	case <-h.quit:
+		conn.closeSend()
+	}
+}
+
+// closeAll closes the registered connections once their queued messages are sent
+func (h *Hub) closeAll(code int, reason string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for conn := range h.clients {
+		conn.closeSendWith(code, reason)
+	}
+}
+
+// sendAll queues a message for every registered connection and returns their count
+func (h *Hub) sendAll(msg *Message) int {
+	h.mu.RLock()
+	conns := make([]*connection, 0, len(h.clients))
+	for conn := range h.clients {
+		conns = append(conns, conn)
+	}
+	h.mu.RUnlock()
+	for _, conn := range conns {
+		conn.sendMsg(msg)
+	}
+	return len(conns)
+}
+
+// stop ends the run loop, the next registrations are refused
+func (h *Hub) stop() {
+	h.stopOnce.Do(func() { close(h.quit) })
 }
 
 // handler passed to readPump for incoming message.
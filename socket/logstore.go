@@ -0,0 +1,174 @@
+package socket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Page sizes of the log history requests
+const (
+	defaultLogHistoryLimit = 500
+	maxLogHistoryLimit     = 5000
+)
+
+var (
+	ErrNoLogs         = errors.New("no logs for the build")
+	ErrInvalidBuildID = errors.New("invalid build ID")
+)
+
+var buildIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// LogStore persists the log chunks of the builds, so they can be read after the end of the build
+// or when the client was disconnected (EvtLogHistory). AppendLog is called for several builds at the
+// same time, the chunks of a build in their order.
+type LogStore interface {
+	AppendLog(chunk LogChunkPayload) error
+	// ReadLogs returns up to limit chunks of a build following the sequence after, and whether more follow
+	ReadLogs(buildID string, after int64, limit int) ([]LogChunkPayload, bool, error)
+}
+
+// LogOwnerStore is optionally implemented by a LogStore which keeps the caller which requested each build,
+// so its log history stays restricted (see BuildAccessFunc) once the server forgot the build or restarted
+type LogOwnerStore interface {
+	SetLogOwner(buildID, owner string) error
+	// LogOwner returns the caller which requested a build, empty when it is not known
+	LogOwner(buildID string) (string, error)
+}
+
+// FileLogStore writes the log chunks of each build to <dir>/<build ID>.jsonl, one chunk per line,
+// and the caller which requested it to <dir>/<build ID>.owner
+type FileLogStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileLogStore returns a log store writing to dir, created if needed
+func NewFileLogStore(dir string) (*FileLogStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("cannot create the log directory '%s': %w", dir, err)
+	}
+	return &FileLogStore{dir: dir}, nil
+}
+
+func (s *FileLogStore) path(buildID, ext string) (string, error) {
+	if !buildIDPattern.MatchString(buildID) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidBuildID, buildID)
+	}
+	return filepath.Join(s.dir, buildID+ext), nil
+}
+
+func (s *FileLogStore) AppendLog(chunk LogChunkPayload) error {
+	path, err := s.path(chunk.BuildID, ".jsonl")
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("cannot encode the log chunk of build %s: %w", chunk.BuildID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("cannot open the log file '%s': %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write the log file '%s': %w", path, err)
+	}
+	return nil
+}
+
+func (s *FileLogStore) ReadLogs(buildID string, after int64, limit int) ([]LogChunkPayload, bool, error) {
+	path, err := s.path(buildID, ".jsonl")
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("%w: %s", ErrNoLogs, buildID)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot open the log file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	chunks := []LogChunkPayload{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var chunk LogChunkPayload
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return nil, false, fmt.Errorf("invalid line in the log file '%s': %w", path, err)
+		}
+		if chunk.Sequence <= after {
+			continue
+		}
+		if len(chunks) == limit {
+			return chunks, true, nil
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("cannot read the log file '%s': %w", path, err)
+	}
+	return chunks, false, nil
+}
+
+func (s *FileLogStore) SetLogOwner(buildID, owner string) error {
+	path, err := s.path(buildID, ".owner")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(owner), 0640); err != nil {
+		return fmt.Errorf("cannot write the owner file '%s': %w", path, err)
+	}
+	return nil
+}
+
+func (s *FileLogStore) LogOwner(buildID string) (string, error) {
+	path, err := s.path(buildID, ".owner")
+	if err != nil {
+		return "", err
+	}
+	owner, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read the owner file '%s': %w", path, err)
+	}
+	return string(owner), nil
+}
+
+// logHistory answers a log history request with a page of the persisted chunks
+func (s *Server) logHistory(req LogHistoryPayload) (*LogHistoryResponsePayload, error) {
+	s.notifier.mu.RLock()
+	store := s.notifier.logStore
+	s.notifier.mu.RUnlock()
+	if store == nil {
+		return nil, codedErrorf(ErrCodeUnsupported, "log history is not configured on the server")
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultLogHistoryLimit
+	}
+	limit = min(limit, maxLogHistoryLimit)
+
+	chunks, more, err := store.ReadLogs(req.BuildID, req.After, limit)
+	if err != nil {
+		return nil, err
+	}
+	resp := &LogHistoryResponsePayload{BuildID: req.BuildID, Chunks: chunks, HasMore: more}
+	if len(chunks) > 0 {
+		resp.Next = chunks[len(chunks)-1].Sequence
+	}
+	return resp, nil
+}
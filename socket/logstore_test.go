@@ -0,0 +1,176 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLogStore(t *testing.T) {
+	store, err := NewFileLogStore(t.TempDir())
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, store.AppendLog(LogChunkPayload{BuildID: "build-1", Stream: "stdout", Content: fmt.Sprintf("line %d", i), Sequence: int64(i)}))
+	}
+
+	chunks, more, err := store.ReadLogs("build-1", 0, 2)
+	require.NoError(t, err)
+	assert.True(t, more)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "line 1", chunks[0].Content)
+
+	chunks, more, err = store.ReadLogs("build-1", 3, 2)
+	require.NoError(t, err)
+	assert.False(t, more)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, int64(5), chunks[1].Sequence)
+
+	_, _, err = store.ReadLogs("build-9", 0, 10)
+	assert.ErrorIs(t, err, ErrNoLogs)
+	_, _, err = store.ReadLogs("../secrets", 0, 10)
+	assert.ErrorIs(t, err, ErrInvalidBuildID)
+	assert.ErrorIs(t, store.AppendLog(LogChunkPayload{BuildID: "a/b"}), ErrInvalidBuildID)
+}
+
+func TestServer_LogHistory(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+	store, err := NewFileLogStore(t.TempDir())
+	require.NoError(t, err)
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Not configured
+	_, err = client.SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: "build-1"})
+	assert.ErrorContains(t, err, "not configured")
+
+	server.SetLogStore(store)
+	buildID := startBuild(t, client)
+	close(release)
+	receiveBuildMessages(t, client)
+
+	resp, err := client.SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: buildID})
+	require.NoError(t, err)
+	history, err := Decode[LogHistoryResponsePayload](resp)
+	require.NoError(t, err)
+	require.Len(t, history.Chunks, 1)
+	assert.Equal(t, "built", history.Chunks[0].Content)
+	assert.Equal(t, int64(1), history.Next)
+	assert.False(t, history.HasMore)
+
+	resp, err = client.SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: buildID, After: history.Next})
+	require.NoError(t, err)
+	history, err = Decode[LogHistoryResponsePayload](resp)
+	require.NoError(t, err)
+	assert.Empty(t, history.Chunks)
+
+	_, err = client.SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: "build-unknown"})
+	assert.ErrorContains(t, err, "no logs")
+}
+
+func TestServer_LogHistoryPages(t *testing.T) {
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				for i := 1; i <= 7; i++ {
+					notifier.NotifyLog(buildID, "stdout", fmt.Sprintf("line %d", i))
+				}
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	store, err := NewFileLogStore(t.TempDir())
+	require.NoError(t, err)
+	server.SetLogStore(store)
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+	buildID := startBuild(t, client)
+	receiveBuildMessages(t, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var lines []string
+	req := LogHistoryPayload{BuildID: buildID, Limit: 3}
+	for pages := 1; ; pages++ {
+		resp, err := client.SendRequest(ctx, EvtLogHistory, req)
+		require.NoError(t, err)
+		history, err := Decode[LogHistoryResponsePayload](resp)
+		require.NoError(t, err)
+		for _, chunk := range history.Chunks {
+			lines = append(lines, chunk.Content)
+		}
+		if !history.HasMore {
+			assert.Equal(t, 3, pages)
+			break
+		}
+		req.After = history.Next
+	}
+	assert.Equal(t, []string{"line 1", "line 2", "line 3", "line 4", "line 5", "line 6", "line 7"}, lines)
+}
+
+func TestServer_LogHistoryRestrictedAfterPurge(t *testing.T) {
+	dir := t.TempDir()
+	start := func() (*Server, string) {
+		release := make(chan struct{})
+		close(release)
+		server, wsURL := startHeldBuildServer(t, release)
+		server.SetAuthenticator(NewTokenAuth("owner-token", "other-token"))
+		store, err := NewFileLogStore(dir)
+		require.NoError(t, err)
+		server.SetLogStore(store)
+		return server, wsURL
+	}
+	connect := func(wsURL, token string) *Client {
+		client := NewClient()
+		require.NoError(t, client.Connect(wsURL, http.Header{"Authorization": []string{"Bearer " + token}}))
+		t.Cleanup(client.Close)
+		return client
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	checkHistory := func(wsURL, buildID string) {
+		t.Helper()
+		_, err := connect(wsURL, "owner-token").SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: buildID})
+		require.NoError(t, err)
+		_, err = connect(wsURL, "other-token").SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: buildID})
+		var respErr *ResponseError
+		require.ErrorAs(t, err, &respErr)
+		assert.Equal(t, ErrCodeBuildNotFound, respErr.Code)
+	}
+
+	server, wsURL := start()
+	owner := connect(wsURL, "owner-token")
+	buildID := startBuild(t, owner)
+	receiveBuildMessages(t, owner)
+
+	// The purged build is restricted to the owner kept by the log store
+	server.notifier.mu.Lock()
+	server.notifier.buildToClient[buildID].finishedAt = time.Now().Add(-finishedBuildTTL - time.Minute)
+	server.notifier.purgeFinished()
+	_, kept := server.notifier.buildToClient[buildID]
+	server.notifier.mu.Unlock()
+	require.False(t, kept)
+	checkHistory(wsURL, buildID)
+
+	// So is the build of a server restarted on the same log directory
+	_, wsURL = start()
+	checkHistory(wsURL, buildID)
+}
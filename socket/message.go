@@ -9,15 +9,41 @@ type EventType string
 
 const (
 	// Client -> Server
-	EvtBuildRequest  EventType = "build_request"  // Build request
-	EvtSecretRequest EventType = "secret_request" // Secret fetching request
+	EvtBuildRequest         EventType = "build_request"          // Build request
+	EvtSecretRequest        EventType = "secret_request"         // Secret fetching request
+	EvtProjectConfigRequest EventType = "project_config_request" // Project variables and secret references management
+	EvtDeploymentsRequest   EventType = "deployments_request"    // Deployment history queries
+	EvtBuildAttach          EventType = "build_attach"           // Attaching to the logs and status of running builds
+	EvtBuildList            EventType = "build_list"             // Listing the queued, running and finished builds
+	EvtBuildGet             EventType = "build_get"              // Status of one build
+	EvtBuildCancel          EventType = "build_cancel"           // Canceling a queued or running build
+	EvtLogHistory           EventType = "log_history"            // Persisted log chunks of a build, page by page
+
+	// Agent -> Coordinator, the agents also send the log_chunk and build_status of their builds
+	EvtAgentRegister EventType = "agent_register" // Registration of a build agent with its capabilities
 
 	// Server -> Client
-	EvtBuildQueued    EventType = "build_queued"    // Queued build response message
-	EvtLogChunk       EventType = "log_chunk"       // A build part log result
-	EvtBuildStatus    EventType = "build_status"    // Updating the build status (running, success, failure)
-	EvtSecretResponse EventType = "secret_response" // Secret request response
-	EvtError          EventType = "error"           // A standard error message for any event
+	EvtBuildQueued           EventType = "build_queued"            // Queued build response message, then its position updates
+	EvtBuildProgress         EventType = "build_progress"          // Current phase of a running build and its progress
+	EvtLogChunk              EventType = "log_chunk"               // A build part log result
+	EvtBuildStatus           EventType = "build_status"            // Updating the build status (running, success, failure)
+	EvtSecretResponse        EventType = "secret_response"         // Secret request response
+	EvtSecretInvalidate      EventType = "secret_invalidate"       // Rotated secrets, the clients drop their cached values
+	EvtProjectConfigResponse EventType = "project_config_response" // Project configuration request response
+	EvtDeploymentsResponse   EventType = "deployments_response"    // Deployment history request response
+	EvtBuildAttached         EventType = "build_attached"          // Builds the client is attached to
+	EvtBuildListResponse     EventType = "build_list_response"     // Build listing response
+	EvtBuildGetResponse      EventType = "build_get_response"      // Build status query response
+	EvtBuildCancelResponse   EventType = "build_cancel_response"   // Build cancellation response
+	EvtLogHistoryResponse    EventType = "log_history_response"    // Log history page
+	EvtAgentRegistered       EventType = "agent_registered"        // Agent registration response
+	EvtAgentJob              EventType = "agent_job"               // Build scheduled on an agent
+	EvtError                 EventType = "error"                   // A standard error message for any event
+
+	// Both directions, the messages larger than maxMessageSize are split in chunks
+	EvtChunkBegin EventType = "chunk_begin" // Start of a chunked message
+	EvtChunkData  EventType = "chunk_data"  // Part of a chunked message
+	EvtChunkEnd   EventType = "chunk_end"   // End of a chunked message, reassembled and handled once checked
 
 	EvtPing EventType = "ping"
 	EvtPong EventType = "pong"
@@ -31,21 +57,35 @@ type Message struct {
 }
 
 type BuildRequestPayload struct {
-	BuildSpecYAML string `json:"build_spec_yaml"`
+	BuildSpecYAML string             `json:"build_spec_yaml"`
+	Requirements  *BuildRequirements `json:"requirements,omitempty"` // Agents allowed to run the build, with a coordinator
 	// BuildSpec build.BuildSpec `json:"build_spec"`
 }
 
 type BuildQueuedPayload struct {
-	BuildID string `json:"build_id"` // UID for this build assigned by the server
-	Message string `json:"message"`  // e.g., "Build job accepted and queued"
+	BuildID  string `json:"build_id"`           // UID for this build assigned by the server
+	Message  string `json:"message"`            // e.g., "Build job accepted and queued"
+	Position int    `json:"position,omitempty"` // 1 based position in the build queue, 0 if the build started immediately
+	// Estimated seconds before the start of the build, 0 when unknown. Sent in the position updates.
+	EstimatedWaitSec float64 `json:"estimated_wait_sec,omitempty"`
+}
+
+// The progress of a running build within its current phase, e.g. fetching the codebase 2 of 3
+type BuildProgressPayload struct {
+	BuildID string `json:"build_id"`
+	Phase   string `json:"phase"`             // e.g. "codebases", "build"
+	Service string `json:"service,omitempty"` // Codebase, step or service being processed
+	Current int    `json:"current,omitempty"` // 1 based index of the item in the phase
+	Total   int    `json:"total,omitempty"`   // Items of the phase, 0 when unknown
+	Message string `json:"message,omitempty"`
 }
 
 // The log message chunk.
 type LogChunkPayload struct {
-	BuildID string `json:"build_id"`
-	Stream  string `json:"stream"` // "stdout" or "stderr" (or "system")
-	Content string `json:"content"`
-	// Sequence int    `json:"sequence,omitempty"` // The log sequence
+	BuildID  string `json:"build_id"`
+	Stream   string `json:"stream"` // "stdout" or "stderr" (or "system")
+	Content  string `json:"content"`
+	Sequence int64  `json:"sequence,omitempty"` // 1 based and increasing for each build, to replay the missed chunks
 }
 
 // The actual build status.
@@ -53,10 +93,100 @@ type BuildStatusPayload struct {
 	BuildID     string   `json:"build_id"`
 	Status      string   `json:"status"`                 // e.g., "queued", "fetching", "building", "success", "failure"
 	Message     string   `json:"message,omitempty"`      // additional Message (e.g., failure reason)
+	ErrorCode   string   `json:"error_code,omitempty"`   // Machine readable code of the failure, see ErrorCoder
 	ArtifactRef string   `json:"artifact_ref,omitempty"` // The ref of the actual completed build (URL, path B2, tag Docker, etc.)
 	DurationSec *float64 `json:"duration_sec,omitempty"`
 }
 
+// Subscribes the connection to running builds, next to their other subscribers. The log chunks kept
+// by the server are sent again first, from the offset of the build, then the latest status.
+type BuildAttachPayload struct {
+	BuildIDs []string         `json:"build_ids"`
+	Offsets  map[string]int64 `json:"offsets,omitempty"` // Sequence of the last chunk received, by build ID, 0 replays every kept chunk
+}
+
+type BuildAttachedPayload struct {
+	Attached []string `json:"attached,omitempty"` // Builds the connection is attached to
+	Unknown  []string `json:"unknown,omitempty"`  // Builds forgotten or never started on the server
+}
+
+// Lists the builds known by the server, filtered by State and Name when set
+type BuildListPayload struct {
+	State string `json:"state,omitempty"` // "queued", "running", "success", "failure" or "canceled"
+	Name  string `json:"name,omitempty"`  // Name of the build spec
+	Limit int    `json:"limit,omitempty"` // 0 for every build
+}
+
+type BuildGetPayload struct {
+	BuildID string `json:"build_id"`
+}
+
+// A build known by the server, queued, running or finished
+type BuildInfoPayload struct {
+	BuildID     string   `json:"build_id"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Branch      string   `json:"branch,omitempty"`
+	Priority    int      `json:"priority"`
+	State       string   `json:"state"`
+	Position    int      `json:"position,omitempty"`    // 1 based position in the queue while the build is queued
+	SubmittedAt string   `json:"submitted_at"`          // RFC 3339
+	StartedAt   string   `json:"started_at,omitempty"`  // RFC 3339
+	FinishedAt  string   `json:"finished_at,omitempty"` // RFC 3339
+	DurationSec *float64 `json:"duration_sec,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	ErrorCode   string   `json:"error_code,omitempty"`
+}
+
+type BuildListResponsePayload struct {
+	Builds []BuildInfoPayload `json:"builds"` // Newest submission first
+}
+
+type BuildGetResponsePayload struct {
+	Build BuildInfoPayload `json:"build"`
+}
+
+// Cancels a queued build or interrupts a running one
+type BuildCancelPayload struct {
+	BuildID string `json:"build_id"`
+}
+
+type BuildCancelResponsePayload struct {
+	Build BuildInfoPayload `json:"build"` // "canceled" for a queued build, still "running" until a running one stops
+}
+
+// Reads a page of the persisted log chunks of a build, running or finished
+type LogHistoryPayload struct {
+	BuildID string `json:"build_id"`
+	After   int64  `json:"after,omitempty"` // Sequence of the last chunk already read, 0 from the start
+	Limit   int    `json:"limit,omitempty"` // Maximum chunks of the page, 0 for the server default
+}
+
+type LogHistoryResponsePayload struct {
+	BuildID string            `json:"build_id"`
+	Chunks  []LogChunkPayload `json:"chunks"`
+	Next    int64             `json:"next,omitempty"`     // After of the next page
+	HasMore bool              `json:"has_more,omitempty"` // More chunks follow the page
+}
+
+// Announces a chunked message, the chunks carry the JSON encoding of the message
+type ChunkBeginPayload struct {
+	TransferID string `json:"transfer_id"`
+	Size       int    `json:"size"`     // Bytes of the encoded message
+	Chunks     int    `json:"chunks"`   // Number of chunk_data messages
+	Checksum   string `json:"checksum"` // Hex SHA-256 of the encoded message
+}
+
+type ChunkDataPayload struct {
+	TransferID string `json:"transfer_id"`
+	Index      int    `json:"index"` // 0 based, the chunks are sent in order
+	Data       []byte `json:"data"`
+}
+
+type ChunkEndPayload struct {
+	TransferID string `json:"transfer_id"`
+}
+
 type SecretRequestPayload struct {
 	Source string `json:"source"`
 }
@@ -66,17 +196,92 @@ type SecretResponsePayload struct {
 	Value  string `json:"value"`
 }
 
+type SecretInvalidatePayload struct {
+	Sources []string `json:"sources,omitempty"` // Rotated sources, empty for every source
+}
+
+// Actions of a project configuration request
+const (
+	ProjectActionList           = "list"            // Every project
+	ProjectActionGet            = "get"             // One project
+	ProjectActionSetVariable    = "set_variable"    // Needs Key and Value
+	ProjectActionDeleteVariable = "delete_variable" // Needs Key
+	ProjectActionSetSecret      = "set_secret"      // Needs Key (the env var name) and Source
+	ProjectActionDeleteSecret   = "delete_secret"   // Needs Key
+	ProjectActionDelete         = "delete"          // The whole project
+)
+
+type ProjectConfigRequestPayload struct {
+	Action       string `json:"action"`
+	Project      string `json:"project,omitempty"`
+	Key          string `json:"key,omitempty"`
+	Value        string `json:"value,omitempty"`
+	Source       string `json:"source,omitempty"`
+	InjectMethod string `json:"inject_method,omitempty"`
+}
+
+// A secret reference of a project, the value is never sent
+type ProjectSecretRef struct {
+	Name         string `json:"name"`
+	Source       string `json:"source"`
+	InjectMethod string `json:"inject_method,omitempty"`
+}
+
+type ProjectConfigPayload struct {
+	Name      string             `json:"name"`
+	Env       map[string]string  `json:"env,omitempty"`
+	Secrets   []ProjectSecretRef `json:"secrets,omitempty"`
+	UpdatedAt string             `json:"updated_at,omitempty"` // RFC 3339
+}
+
+type ProjectConfigResponsePayload struct {
+	Projects []ProjectConfigPayload `json:"projects"` // The listed project(s), or the updated one
+}
+
+// Actions of a deployments request
+const (
+	DeploymentActionList = "list" // The latest deployments, optionally filtered by Target
+	DeploymentActionShow = "show" // One deployment, needs ID
+)
+
+type DeploymentsRequestPayload struct {
+	Action string `json:"action"`
+	ID     string `json:"id,omitempty"`
+	Target string `json:"target,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // 0 for every deployment
+}
+
+// A deployment of a run.yml on a target
+type DeploymentPayload struct {
+	ID         string            `json:"id"`
+	Target     string            `json:"target"`
+	Host       string            `json:"host,omitempty"`
+	Project    string            `json:"project,omitempty"`
+	RunFile    string            `json:"run_file"`
+	DeployedBy string            `json:"deployed_by,omitempty"`
+	StartedAt  string            `json:"started_at"`  // RFC 3339
+	FinishedAt string            `json:"finished_at"` // RFC 3339
+	Outcome    string            `json:"outcome"`     // "success", "failed", "rolled_back"
+	Error      string            `json:"error,omitempty"`
+	Images     map[string]string `json:"images,omitempty"`   // Service name -> image digest
+	Services   map[string]string `json:"services,omitempty"` // Service name -> container ID
+}
+
+type DeploymentsResponsePayload struct {
+	Deployments []DeploymentPayload `json:"deployments"` // Newest first
+}
+
 type ErrorPayload struct {
-	Code    int    `json:"code,omitempty"`
-	Details string `json:"details"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Details string    `json:"details"`
 }
 
 func NewMessage(eventType EventType, requestID string) *Message {
 	return &Message{Type: eventType, RequestID: requestID}
 }
 
-func NewErrorMessage(requestID, errMsg, details string) *Message {
-	payloadBytes, _ := json.Marshal(ErrorPayload{Details: details})
+func NewErrorMessage(requestID string, code ErrorCode, errMsg, details string) *Message {
+	payloadBytes, _ := json.Marshal(ErrorPayload{Code: code, Details: details})
 	return &Message{
 		Type:      EvtError,
 		RequestID: requestID,
@@ -103,3 +308,37 @@ func (m *Message) DecodePayload(target interface{}) error {
 	}
 	return nil
 }
+
+// Capabilities of the agents required by a build, the zero values match any agent
+type BuildRequirements struct {
+	Arch   string            `json:"arch,omitempty"`   // e.g. "amd64", "arm64"
+	Labels map[string]string `json:"labels,omitempty"` // Labels the agent must have, with the same values
+}
+
+// Capabilities advertised by a build agent
+type AgentInfo struct {
+	Name          string            `json:"name"` // Unique among the agents of the coordinator
+	Arch          string            `json:"arch"`
+	OS            string            `json:"os"`
+	DockerVersion string            `json:"docker_version,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Capacity      int               `json:"capacity,omitempty"` // Builds run at the same time, 1 when 0
+}
+
+// Registers the connection as a build agent. Running lists the builds still running on the agent
+// when it registers again after a reconnection.
+type AgentRegisterPayload struct {
+	Agent   AgentInfo `json:"agent"`
+	Running []string  `json:"running,omitempty"`
+}
+
+type AgentRegisteredPayload struct {
+	Name string   `json:"name"`
+	Lost []string `json:"lost,omitempty"` // Builds of Running the coordinator failed or forgot, not to report anymore
+}
+
+// A build to run on the agent, which sends its log chunks and status back
+type AgentJobPayload struct {
+	BuildID       string `json:"build_id"`
+	BuildSpecYAML string `json:"build_spec_yaml"`
+}
@@ -0,0 +1,136 @@
+package socket
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics instruments the socket server. It is a prometheus collector, registered by the
+// application which serves it (promhttp.HandlerFor on /metrics):
+//
+//	metrics := socket.NewMetrics()
+//	registry.MustRegister(metrics)
+//	server.SetMetrics(metrics)
+type Metrics struct {
+	connectionsOpened prometheus.Counter
+	connectionsClosed prometheus.Counter
+	activeConnections prometheus.Gauge
+	messagesReceived  *prometheus.CounterVec
+	messagesSent      *prometheus.CounterVec
+	messagesDropped   *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	activeBuilds      *prometheus.Desc
+
+	runningBuilds func() int // Set by SetMetrics
+}
+
+// NewMetrics returns the metrics of a server, named anexis_socket_*
+func NewMetrics() *Metrics {
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{Namespace: "anexis", Subsystem: "socket", Name: name, Help: help}
+	}
+	return &Metrics{
+		connectionsOpened: prometheus.NewCounter(prometheus.CounterOpts(opts("connections_opened_total", "Websocket connections accepted."))),
+		connectionsClosed: prometheus.NewCounter(prometheus.CounterOpts(opts("connections_closed_total", "Websocket connections closed."))),
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts(opts("active_connections", "Websocket connections open."))),
+		messagesReceived:  prometheus.NewCounterVec(prometheus.CounterOpts(opts("messages_received_total", "Messages received from the clients, by event type (unknown for the types without handler).")), []string{"type"}),
+		messagesSent:      prometheus.NewCounterVec(prometheus.CounterOpts(opts("messages_sent_total", "Messages queued for the clients, by event type.")), []string{"type"}),
+		messagesDropped:   prometheus.NewCounterVec(prometheus.CounterOpts(opts("messages_dropped_total", "Messages dropped for closed or slow clients, by event type.")), []string{"type"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "anexis",
+			Subsystem: "socket",
+			Name:      "request_duration_seconds",
+			Help:      "Time spent handling the client messages, by event type and error code (ok on success).",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type", "code"}),
+		activeBuilds: prometheus.NewDesc("anexis_socket_active_builds", "Builds queued or running, without final status.", nil, nil),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.connectionsOpened, m.connectionsClosed, m.activeConnections,
+		m.messagesReceived, m.messagesSent, m.messagesDropped, m.requestDuration,
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range m.collectors() {
+		collector.Describe(ch)
+	}
+	ch <- m.activeBuilds
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range m.collectors() {
+		collector.Collect(ch)
+	}
+	running := 0
+	if m.runningBuilds != nil {
+		running = m.runningBuilds()
+	}
+	ch <- prometheus.MustNewConstMetric(m.activeBuilds, prometheus.GaugeValue, float64(running))
+}
+
+// The methods below are no-ops on a nil *Metrics, the connections of the clients have none
+
+func (m *Metrics) connectionOpened() {
+	if m != nil {
+		m.connectionsOpened.Inc()
+		m.activeConnections.Inc()
+	}
+}
+
+func (m *Metrics) connectionClosed() {
+	if m != nil {
+		m.connectionsClosed.Inc()
+		m.activeConnections.Dec()
+	}
+}
+
+func (m *Metrics) messageSent(msg *Message, queued bool) {
+	if m == nil {
+		return
+	}
+	if queued {
+		m.messagesSent.WithLabelValues(string(msg.Type)).Inc()
+	} else {
+		m.messagesDropped.WithLabelValues(string(msg.Type)).Inc()
+	}
+}
+
+// unknownEventLabel labels the messages without handler, their type is chosen by the client and
+// would create a series for each value
+const unknownEventLabel = "unknown"
+
+func (m *Metrics) messageReceived(msg *Message, handled bool) {
+	if m == nil {
+		return
+	}
+	eventType := string(msg.Type)
+	if !handled {
+		eventType = unknownEventLabel
+	}
+	m.messagesReceived.WithLabelValues(eventType).Inc()
+}
+
+// requestHandled measures the handling of a message, middlewares included. Only the messages with a
+// handler are measured.
+func (m *Metrics) requestHandled(msg *Message, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	code := "ok"
+	if err != nil {
+		code = string(CodeOf(err))
+	}
+	m.requestDuration.WithLabelValues(string(msg.Type), code).Observe(duration.Seconds())
+}
+
+// SetMetrics instruments the server, the connections opened before are not counted. It is called
+// before serving.
+func (s *Server) SetMetrics(metrics *Metrics) {
+	metrics.runningBuilds = s.notifier.runningBuilds
+	s.metrics = metrics
+}
@@ -0,0 +1,94 @@
+package socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metricValue returns the value of a gathered counter, gauge or histogram count matching the labels
+func metricValue(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metrics
+				}
+			}
+			switch {
+			case metric.Counter != nil:
+				return metric.GetCounter().GetValue()
+			case metric.Gauge != nil:
+				return metric.GetGauge().GetValue()
+			case metric.Histogram != nil:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func TestServer_Metrics(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+	metrics := NewMetrics()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(metrics))
+	server.SetMetrics(metrics)
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	startBuild(t, client)
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_active_connections", nil))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_active_builds", nil))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_messages_received_total", map[string]string{"type": "build_request"}))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_request_duration_seconds", map[string]string{"type": "build_request", "code": "ok"}))
+
+	close(release)
+	receiveBuildMessages(t, client)
+	assert.Equal(t, 0.0, metricValue(t, registry, "anexis_socket_active_builds", nil))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_messages_sent_total", map[string]string{"type": "log_chunk"}))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_messages_sent_total", map[string]string{"type": "build_status"}))
+
+	_, err := client.SendRequest(t.Context(), EvtBuildGet, BuildGetPayload{})
+	require.Error(t, err)
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_request_duration_seconds", map[string]string{"type": "build_get", "code": "invalid_payload"}))
+
+	// The types without handler share a single series
+	for _, eventType := range []EventType{"random-1", "random-2"} {
+		_, err = client.SendRequest(t.Context(), eventType, nil)
+		require.Error(t, err)
+	}
+	assert.Equal(t, 2.0, metricValue(t, registry, "anexis_socket_messages_received_total", map[string]string{"type": "unknown"}))
+	assert.Equal(t, 0.0, metricValue(t, registry, "anexis_socket_messages_received_total", map[string]string{"type": "random-1"}))
+
+	client.Close()
+	assert.Eventually(t, func() bool {
+		return metricValue(t, registry, "anexis_socket_connections_closed_total", nil) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0.0, metricValue(t, registry, "anexis_socket_active_connections", nil))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_connections_opened_total", nil))
+}
+
+func TestMetrics_DroppedMessages(t *testing.T) {
+	metrics := NewMetrics()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(metrics))
+
+	conn, _ := connectionPair(t, SendPolicy{BufferSize: 1, Timeout: time.Millisecond})
+	conn.metrics = metrics
+	conn.sendMsg(NewMessage(EvtLogChunk, ""))
+	conn.sendMsg(NewMessage(EvtLogChunk, ""))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_messages_sent_total", map[string]string{"type": "log_chunk"}))
+	assert.Equal(t, 1.0, metricValue(t, registry, "anexis_socket_messages_dropped_total", map[string]string{"type": "log_chunk"}))
+}
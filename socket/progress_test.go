@@ -0,0 +1,112 @@
+package socket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// progressBuilds reports a queue position then the progress of the builds, their status once released
+func progressBuilds(release chan struct{}) *MockBuildTriggerer {
+	return &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			progress := notifier.(ProgressNotifier)
+			progress.NotifyQueued(buildID, 2, 30*time.Second)
+			go func() {
+				progress.NotifyProgress(BuildProgressPayload{BuildID: buildID, Phase: "codebases", Service: "api", Current: 2, Total: 3})
+				<-release
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+}
+
+// receiveProgress reads the queue position update and the progress of a build from Incoming
+func receiveProgress(t *testing.T, client *Client) (BuildQueuedPayload, BuildProgressPayload) {
+	t.Helper()
+	var queued BuildQueuedPayload
+	for {
+		select {
+		case msg := <-client.Incoming:
+			switch event, _ := msg.Event(); event := event.(type) {
+			case BuildQueuedPayload:
+				queued = event
+			case BuildProgressPayload:
+				return queued, event
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no build progress received")
+		}
+	}
+}
+
+func TestServer_BuildProgress(t *testing.T) {
+	release := make(chan struct{})
+	server := NewServer(progressBuilds(release), nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	buildID := startBuild(t, client)
+	queued, progress := receiveProgress(t, client)
+	assert.Equal(t, BuildQueuedPayload{BuildID: buildID, Message: "Build job queued at position 2", Position: 2, EstimatedWaitSec: 30}, queued)
+	assert.Equal(t, BuildProgressPayload{BuildID: buildID, Phase: "codebases", Service: "api", Current: 2, Total: 3}, progress)
+
+	// The latest progress is sent to the clients attaching to the build
+	late := NewClient()
+	require.NoError(t, late.Connect(wsURL, nil))
+	defer late.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := late.Attach(ctx, buildID)
+	require.NoError(t, err)
+	_, progress = receiveProgress(t, late)
+	assert.Equal(t, "api", progress.Service)
+
+	close(release)
+	_, status := receiveBuildMessages(t, late)
+	assert.Equal(t, "success", status.Status)
+}
+
+func TestCoordinator_RelaysProgress(t *testing.T) {
+	coordinator, wsURL := startCoordinator(t)
+	client := NewClient()
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+
+	// Position in the queue of the coordinator until an agent registers
+	buildID := requestBuild(t, client, nil).BuildID
+	select {
+	case msg := <-client.Incoming:
+		queued, err := Decode[BuildQueuedPayload](msg)
+		require.NoError(t, err)
+		assert.Equal(t, 1, queued.Position)
+	case <-time.After(time.Second):
+		t.Fatal("no queue position received")
+	}
+
+	release := make(chan struct{})
+	agent := NewAgent(AgentInfo{Name: "builder", Arch: "amd64"}, progressBuilds(release))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx, wsURL, nil)
+	waitAgents(t, coordinator, 1)
+
+	queued, progress := receiveProgress(t, client)
+	assert.Equal(t, BuildQueuedPayload{BuildID: buildID, Message: "Build job queued at position 2", Position: 2, EstimatedWaitSec: 30}, queued)
+	assert.Equal(t, BuildProgressPayload{BuildID: buildID, Phase: "codebases", Service: "api", Current: 2, Total: 3}, progress)
+	close(release)
+	_, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "success", status.Status)
+}
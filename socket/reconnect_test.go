@@ -0,0 +1,363 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startHeldBuildServer starts a server whose builds send a log chunk and their final status once release is closed
+func startHeldBuildServer(t *testing.T, release chan struct{}) (*Server, string) {
+	t.Helper()
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				<-release
+				notifier.NotifyLog(buildID, "stdout", "built")
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return server, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+func startBuild(t *testing.T, client *Client) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app"})
+	require.NoError(t, err)
+	queued, err := Decode[BuildQueuedPayload](resp)
+	require.NoError(t, err)
+	return queued.BuildID
+}
+
+// buildSubscribers returns the clients the server sends the messages of a build to
+func buildSubscribers(server *Server, buildID string) []peer {
+	server.notifier.mu.RLock()
+	defer server.notifier.mu.RUnlock()
+	var subscribers []peer
+	if build, ok := server.notifier.buildToClient[buildID]; ok {
+		for conn := range build.subscribers {
+			subscribers = append(subscribers, conn)
+		}
+	}
+	return subscribers
+}
+
+// receiveBuildMessages reads the log chunk and the final status of a build from Incoming
+func receiveBuildMessages(t *testing.T, client *Client) (LogChunkPayload, BuildStatusPayload) {
+	t.Helper()
+	var chunk LogChunkPayload
+	for {
+		select {
+		case msg := <-client.Incoming:
+			switch event, _ := msg.Event(); event := event.(type) {
+			case LogChunkPayload:
+				chunk = event
+			case BuildStatusPayload:
+				return chunk, event
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no build status received")
+		}
+	}
+}
+
+func TestClient_ReconnectsAndWatchesItsBuilds(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+
+	client := NewClient()
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, MaxAttempts: 20})
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	buildID := startBuild(t, client)
+
+	// Network failure
+	client.mu.Lock()
+	oldConn := client.conn
+	client.mu.Unlock()
+	oldConn.ws.Close()
+
+	require.Eventually(t, func() bool {
+		subscribers := buildSubscribers(server, buildID)
+		return client.IsConnected() && len(subscribers) == 1 && subscribers[0].(*connection).ws.RemoteAddr().String() != oldConn.ws.LocalAddr().String()
+	}, 2*time.Second, 10*time.Millisecond, "the build is not watched by the new connection")
+
+	close(release)
+	chunk, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "built", chunk.Content)
+	assert.Equal(t, "success", status.Status)
+	assert.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.watched) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_KeepsBuildMessagesForAnotherClient(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+
+	first := NewClient()
+	require.NoError(t, first.Connect(wsURL, nil))
+	buildID := startBuild(t, first)
+	first.Close()
+	require.Eventually(t, func() bool { return len(buildSubscribers(server, buildID)) == 0 }, time.Second, 10*time.Millisecond)
+
+	// The messages sent without client are kept for the next one
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	second := NewClient()
+	require.NoError(t, second.Connect(wsURL, nil))
+	defer second.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	watch, err := second.Attach(ctx, buildID, "build-unknown")
+	require.NoError(t, err)
+	assert.Equal(t, []string{buildID}, watch.Attached)
+	assert.Equal(t, []string{"build-unknown"}, watch.Unknown)
+
+	chunk, status := receiveBuildMessages(t, second)
+	assert.Equal(t, "built", chunk.Content)
+	assert.Equal(t, "success", status.Status)
+
+	// The finished build is kept for the late clients
+	watch, err = second.Attach(ctx, buildID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{buildID}, watch.Attached)
+	chunk, status = receiveBuildMessages(t, second)
+	assert.Equal(t, int64(1), chunk.Sequence)
+	assert.Equal(t, "success", status.Status)
+}
+
+func TestClient_ReplaysMissedLogChunks(t *testing.T) {
+	steps := []chan struct{}{make(chan struct{}), make(chan struct{}), make(chan struct{})}
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				<-steps[0]
+				for i := 1; i <= 3; i++ {
+					notifier.NotifyLog(buildID, "stdout", fmt.Sprintf("line %d", i))
+				}
+				<-steps[1]
+				for i := 4; i <= 5; i++ {
+					notifier.NotifyLog(buildID, "stdout", fmt.Sprintf("line %d", i))
+				}
+				<-steps[2]
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	client.SetReconnectPolicy(&ReconnectPolicy{InitialDelay: 50 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+	buildID := startBuild(t, client)
+
+	var lines []string
+	readLines := func(n int) {
+		for len(lines) < n {
+			select {
+			case msg := <-client.Incoming:
+				if chunk, err := Decode[LogChunkPayload](msg); err == nil {
+					assert.Equal(t, int64(len(lines)+1), chunk.Sequence)
+					lines = append(lines, chunk.Content)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("only %d log lines received", len(lines))
+			}
+		}
+	}
+	close(steps[0])
+	readLines(3)
+
+	// The chunks 4 and 5 are sent while the client is disconnected
+	client.mu.Lock()
+	client.conn.ws.Close()
+	client.mu.Unlock()
+	require.Eventually(t, func() bool { return len(buildSubscribers(server, buildID)) == 0 }, time.Second, 5*time.Millisecond)
+	close(steps[1])
+	readLines(5)
+	close(steps[2])
+	_, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "success", status.Status)
+	assert.Equal(t, []string{"line 1", "line 2", "line 3", "line 4", "line 5"}, lines)
+}
+
+func TestClient_DropsReplayedChunks(t *testing.T) {
+	client := NewClient()
+	client.watched["build-1"] = 3
+	old, err := NewPayloadMessage("", LogChunkPayload{BuildID: "build-1", Content: "line 3", Sequence: 3})
+	require.NoError(t, err)
+	next, err := NewPayloadMessage("", LogChunkPayload{BuildID: "build-1", Content: "line 4", Sequence: 4})
+	require.NoError(t, err)
+
+	assert.False(t, client.trackBuild(old))
+	assert.True(t, client.trackBuild(next))
+	assert.Equal(t, int64(4), client.watched["build-1"])
+}
+
+func TestServer_MultipleBuildSubscribers(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+
+	owner := NewClient()
+	require.NoError(t, owner.Connect(wsURL, nil))
+	defer owner.Close()
+	buildID := startBuild(t, owner)
+
+	var watchers []*Client
+	for i := 0; i < 2; i++ {
+		watcher := NewClient()
+		require.NoError(t, watcher.Connect(wsURL, nil))
+		defer watcher.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		attached, err := watcher.Attach(ctx, buildID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{buildID}, attached.Attached)
+		watchers = append(watchers, watcher)
+	}
+	assert.Len(t, buildSubscribers(server, buildID), 3)
+
+	// A disconnected subscriber is removed, the others still receive the messages
+	watchers[1].Close()
+	require.Eventually(t, func() bool { return len(buildSubscribers(server, buildID)) == 2 }, time.Second, 10*time.Millisecond)
+	close(release)
+	for _, client := range []*Client{owner, watchers[0]} {
+		chunk, status := receiveBuildMessages(t, client)
+		assert.Equal(t, "built", chunk.Content)
+		assert.Equal(t, "success", status.Status)
+	}
+	assert.Empty(t, buildSubscribers(server, buildID))
+}
+
+func TestServer_AttachRestrictedToOwner(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server, wsURL := startHeldBuildServer(t, release)
+	server.SetAuthenticator(NewTokenAuth("owner-token", "other-token"))
+
+	connect := func(token string) *Client {
+		client := NewClient()
+		require.NoError(t, client.Connect(wsURL, http.Header{"Authorization": []string{"Bearer " + token}}))
+		t.Cleanup(client.Close)
+		return client
+	}
+	owner, other := connect("owner-token"), connect("other-token")
+	buildID := startBuild(t, owner)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	attached, err := other.Attach(ctx, buildID)
+	require.NoError(t, err)
+	assert.Empty(t, attached.Attached)
+	assert.Equal(t, []string{buildID}, attached.Unknown)
+	_, err = other.SendRequest(ctx, EvtLogHistory, LogHistoryPayload{BuildID: buildID})
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeBuildNotFound, respErr.Code)
+	assert.Len(t, buildSubscribers(server, buildID), 1)
+
+	// An access function lets the other callers follow the build
+	server.SetBuildAccess(func(caller, owner, buildID string) bool { return caller == "token-2" })
+	attached, err = other.Attach(ctx, buildID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{buildID}, attached.Attached)
+	assert.Len(t, buildSubscribers(server, buildID), 2)
+}
+
+func TestReplayable(t *testing.T) {
+	list, err := NewPayloadMessage("1", ProjectConfigRequestPayload{Action: ProjectActionList})
+	require.NoError(t, err)
+	set, err := NewPayloadMessage("2", ProjectConfigRequestPayload{Action: ProjectActionSetVariable, Key: "A", Value: "1"})
+	require.NoError(t, err)
+	build, err := NewPayloadMessage("3", BuildRequestPayload{BuildSpecYAML: "name: app"})
+	require.NoError(t, err)
+
+	assert.True(t, replayable(list))
+	assert.True(t, replayable(NewMessage(EvtSecretRequest, "4")))
+	assert.False(t, replayable(set))
+	assert.False(t, replayable(build))
+}
+
+func TestClient_ConnectAttachesItsBuildsAgain(t *testing.T) {
+	release := make(chan struct{})
+	_, wsURL := startHeldBuildServer(t, release)
+
+	client := NewClient()
+	client.SetReconnectPolicy(nil)
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	startBuild(t, client)
+
+	// The connection is lost without reconnection, the build finishes meanwhile
+	client.mu.Lock()
+	client.conn.ws.Close()
+	client.mu.Unlock()
+	require.Eventually(t, func() bool { return !client.IsConnected() }, time.Second, 10*time.Millisecond)
+	close(release)
+
+	require.NoError(t, client.Connect(wsURL, nil))
+	chunk, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "built", chunk.Content)
+	assert.Equal(t, "success", status.Status)
+}
+
+func TestServer_KeepsUndeliveredStatus(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+	age := func(buildID string, finished time.Duration) {
+		server.notifier.mu.Lock()
+		defer server.notifier.mu.Unlock()
+		server.notifier.buildToClient[buildID].finishedAt = time.Now().Add(-finished)
+	}
+
+	first := NewClient()
+	require.NoError(t, first.Connect(wsURL, nil))
+	buildID := startBuild(t, first)
+	first.Close()
+	require.Eventually(t, func() bool { return len(buildSubscribers(server, buildID)) == 0 }, time.Second, 10*time.Millisecond)
+	close(release)
+	require.Eventually(t, func() bool { return server.notifier.runningBuilds() == 0 }, time.Second, 10*time.Millisecond)
+
+	// The status sent to nobody outlives the other finished builds, without its log chunks
+	age(buildID, finishedBuildTTL+time.Minute)
+	second := NewClient()
+	require.NoError(t, second.Connect(wsURL, nil))
+	defer second.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	watch, err := second.Attach(ctx, buildID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{buildID}, watch.Attached)
+	chunk, status := receiveBuildMessages(t, second)
+	assert.Empty(t, chunk.Content)
+	assert.Equal(t, "success", status.Status)
+
+	// Delivered, it is forgotten like the others
+	age(buildID, finishedBuildTTL+time.Minute)
+	watch, err = second.Attach(ctx, buildID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{buildID}, watch.Unknown)
+}
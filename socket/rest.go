@@ -0,0 +1,193 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Period of the comments keeping the idle event streams open through the proxies
+const sseKeepAlive = 30 * time.Second
+
+// restError is the body of the REST error responses
+type restError struct {
+	Error   string    `json:"error"`
+	Code    ErrorCode `json:"code"`
+	Details string    `json:"details,omitempty"`
+}
+
+// RESTHandler serves the build protocol over HTTP, for the clients which cannot hold a websocket:
+//
+//	POST /api/builds            starts a build from a BuildRequestPayload, answers a BuildQueuedPayload
+//	GET  /api/builds/{id}       returns the BuildInfoPayload of a build, with a BuildLister
+//	GET  /api/builds/{id}/logs  streams the log chunks, progress and status of a build as server-sent events
+//
+// The requests go through the Authenticator, the handlers and the middlewares of the events, the log
+// stream through the ones of EvtBuildAttach. The log chunks carry their sequence as event ID, a client
+// reconnecting with Last-Event-ID (or ?after=) receives the chunks it missed. The errors are JSON
+// objects with the code of the failure.
+func (s *Server) RESTHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/builds", s.restStartBuild)
+	mux.HandleFunc("GET /api/builds/{id}", s.restGetBuild)
+	mux.HandleFunc("GET /api/builds/{id}/logs", s.restStreamLogs)
+	return mux
+}
+
+// restCaller authenticates a REST request, it writes the error response and returns false when refused
+func (s *Server) restCaller(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if s.auth == nil {
+		return "", true
+	}
+	caller, err := s.auth.Authenticate(r)
+	if err != nil {
+		log.Printf("REST: Rejected request from %s: %v\n", r.RemoteAddr, err)
+		writeJSON(w, http.StatusUnauthorized, restError{Error: "unauthorized", Code: ErrCodeUnauthorized})
+		return "", false
+	}
+	return caller, true
+}
+
+// restDispatch handles an event sent over REST and writes the reply of its handler
+func (s *Server) restDispatch(w http.ResponseWriter, caller string, payload Payload, status int) {
+	resp := s.request(caller, payload)
+	if errPayload, failed := replyError(resp); failed {
+		writeRESTError(w, restError{Error: resp.Error, Code: errPayload.Code, Details: errPayload.Details})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp.Payload)
+}
+
+func (s *Server) restStartBuild(w http.ResponseWriter, r *http.Request) {
+	caller, ok := s.restCaller(w, r)
+	if !ok {
+		return
+	}
+	var payload BuildRequestPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeRESTError(w, restError{Error: "invalid build request body", Code: ErrCodeInvalidPayload, Details: err.Error()})
+		return
+	}
+	s.restDispatch(w, caller, payload, http.StatusAccepted)
+}
+
+func (s *Server) restGetBuild(w http.ResponseWriter, r *http.Request) {
+	caller, ok := s.restCaller(w, r)
+	if !ok {
+		return
+	}
+	s.restDispatch(w, caller, BuildGetPayload{BuildID: r.PathValue("id")}, http.StatusOK)
+}
+
+func (s *Server) restStreamLogs(w http.ResponseWriter, r *http.Request) {
+	caller, ok := s.restCaller(w, r)
+	if !ok {
+		return
+	}
+	buildID := r.PathValue("id")
+	after := r.Header.Get("Last-Event-ID")
+	if query := r.URL.Query().Get("after"); query != "" {
+		after = query
+	}
+	var sequence int64
+	if after != "" {
+		var err error
+		if sequence, err = strconv.ParseInt(after, 10, 64); err != nil {
+			writeRESTError(w, restError{Error: "invalid log sequence", Code: ErrCodeInvalidPayload, Details: err.Error()})
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeRESTError(w, restError{Error: "streaming not supported by the connection", Code: ErrCodeUnsupported})
+		return
+	}
+
+	stream := newStreamPeer()
+	detach, failure := s.attachStream(caller, buildID, sequence, stream)
+	if failure != nil {
+		errPayload, _ := replyError(failure)
+		writeRESTError(w, restError{Error: failure.Error, Code: errPayload.Code, Details: errPayload.Details})
+		return
+	}
+	defer detach()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg := <-stream.messages:
+			if err := writeSSE(w, msg); err != nil {
+				log.Printf("REST: Event stream of build %s closed: %v\n", buildID, err)
+				return
+			}
+			flusher.Flush()
+			if msg.Type == EvtBuildStatus {
+				if status, err := Decode[BuildStatusPayload](msg); err == nil && finalStatus(status.Status) {
+					return
+				}
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-stream.overflow:
+			log.Printf("REST: Event stream of build %s too slow, closed\n", buildID)
+			return
+		case <-s.hub.quit:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes a message as a server-sent event named after its type, the log chunks with their sequence as ID
+func writeSSE(w http.ResponseWriter, msg *Message) error {
+	if msg.Type == EvtLogChunk {
+		if chunk, err := Decode[LogChunkPayload](msg); err == nil && chunk.Sequence > 0 {
+			if _, err := fmt.Fprintf(w, "id: %d\n", chunk.Sequence); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, msg.Payload)
+	return err
+}
+
+// restStatus returns the HTTP status of an error code
+func restStatus(code ErrorCode) int {
+	switch code {
+	case ErrCodeInvalidPayload:
+		return http.StatusBadRequest
+	case ErrCodeUnauthorized:
+		return http.StatusForbidden
+	case ErrCodeBuildNotFound:
+		return http.StatusNotFound
+	case ErrCodeRateLimited:
+		return http.StatusTooManyRequests
+	case ErrCodeUnsupported:
+		return http.StatusNotImplemented
+	case ErrCodeUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeRESTError(w http.ResponseWriter, body restError) {
+	writeJSON(w, restStatus(body.Code), body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,195 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is a server-sent event read by readSSE
+type sseEvent struct {
+	id, event, data string
+}
+
+// readSSE reads the events of a stream until the server closes it
+func readSSE(t *testing.T, body io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			current.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			current.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func startRESTServer(t *testing.T, buildSvc BuildTriggerer, auth Authenticator) string {
+	t.Helper()
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	if auth != nil {
+		server.SetAuthenticator(auth)
+	}
+	server.Run()
+	httpServer := httptest.NewServer(server.RESTHandler())
+	t.Cleanup(httpServer.Close)
+	return httpServer.URL
+}
+
+func TestREST_BuildAndLogStream(t *testing.T) {
+	release := make(chan struct{})
+	url := startRESTServer(t, &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			go func() {
+				<-release
+				notifier.NotifyLog(buildID, "stdout", "step 1")
+				notifier.NotifyLog(buildID, "stdout", "step 2")
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, nil)
+			}()
+			return nil
+		},
+	}, nil)
+
+	resp, err := http.Post(url+"/api/builds", "application/json", strings.NewReader(`{"build_spec_yaml": "name: app"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var queued BuildQueuedPayload
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&queued))
+	require.NotEmpty(t, queued.BuildID)
+
+	stream, err := http.Get(url + "/api/builds/" + queued.BuildID + "/logs")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+	close(release)
+	events := readSSE(t, stream.Body)
+	require.Len(t, events, 3)
+	assert.Equal(t, sseEvent{id: "1", event: "log_chunk", data: `{"build_id":"` + queued.BuildID + `","stream":"stdout","content":"step 1","sequence":1}`}, events[0])
+	assert.Equal(t, "2", events[1].id)
+	assert.Equal(t, "build_status", events[2].event)
+	assert.Contains(t, events[2].data, `"status":"success"`)
+
+	// A client resuming the stream receives the chunks it missed and the final status
+	req, err := http.NewRequest(http.MethodGet, url+"/api/builds/"+queued.BuildID+"/logs", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resumed, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resumed.Body.Close()
+	events = readSSE(t, resumed.Body)
+	require.Len(t, events, 2)
+	assert.Equal(t, "2", events[0].id)
+	assert.Equal(t, "build_status", events[1].event)
+}
+
+func TestREST_LogStreamGoesThroughMiddlewares(t *testing.T) {
+	buildSvc := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			return nil
+		},
+	}
+	server := NewServer(buildSvc, nil, func(r *http.Request) bool { return true })
+	var attached []string
+	server.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			if req.Message.Type != EvtBuildAttach {
+				return next(ctx, req)
+			}
+			payload, err := Decode[BuildAttachPayload](req.Message)
+			require.NoError(t, err)
+			attached = append(attached, payload.BuildIDs...)
+			return WithCode(ErrCodeUnauthorized, errors.New("logs reserved to the operators"))
+		}
+	})
+	server.Run()
+	httpServer := httptest.NewServer(server.RESTHandler())
+	defer httpServer.Close()
+
+	resp, err := http.Post(httpServer.URL+"/api/builds", "application/json", strings.NewReader(`{"build_spec_yaml": "name: app"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var queued BuildQueuedPayload
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&queued))
+
+	stream, err := http.Get(httpServer.URL + "/api/builds/" + queued.BuildID + "/logs")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	assert.Equal(t, http.StatusForbidden, stream.StatusCode)
+	var body restError
+	require.NoError(t, json.NewDecoder(stream.Body).Decode(&body))
+	assert.Equal(t, restError{Error: "Failed to handle request", Code: ErrCodeUnauthorized, Details: "logs reserved to the operators"}, body)
+	assert.Equal(t, []string{queued.BuildID}, attached)
+	assert.Empty(t, buildSubscribers(server, queued.BuildID))
+}
+
+func TestREST_GetBuild(t *testing.T) {
+	url := startRESTServer(t, &fakeBuildLister{}, nil)
+
+	resp, err := http.Get(url + "/api/builds/build-1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var build BuildGetResponsePayload
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&build))
+	assert.Equal(t, "running", build.Build.State)
+
+	resp, err = http.Get(url + "/api/builds/build-9")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var body restError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, ErrCodeBuildNotFound, body.Code)
+
+	resp, err = http.Get(url + "/api/builds/build-9/logs")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestREST_Errors(t *testing.T) {
+	url := startRESTServer(t, &MockBuildTriggerer{}, nil)
+
+	resp, err := http.Post(url+"/api/builds", "application/json", strings.NewReader(`{"build_spec_yaml": ""}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var body restError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, restError{Error: "Failed to handle request", Code: ErrCodeInvalidPayload, Details: "build spec YAML cannot be empty"}, body)
+
+	// The build queries need a BuildLister
+	resp, err = http.Get(url + "/api/builds/build-1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	secured := startRESTServer(t, &MockBuildTriggerer{}, NewTokenAuth("secret"))
+	resp, err = http.Post(secured+"/api/builds", "application/json", strings.NewReader(`{"build_spec_yaml": "name: app"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,104 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// secretCache keeps the secret values fetched by a client for a TTL
+type secretCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration // 0 disables the cache
+	entries    map[string]cachedSecret
+	generation uint64 // Incremented by each invalidation, the values fetched meanwhile are not kept
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+func (sc *secretCache) get(source string) (string, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	entry, ok := sc.entries[source]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.value, true
+}
+
+// put keeps a value fetched during the generation, unless an invalidation happened since
+func (sc *secretCache) put(source, value string, generation uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.ttl <= 0 || generation != sc.generation {
+		return
+	}
+	if sc.entries == nil {
+		sc.entries = make(map[string]cachedSecret)
+	}
+	sc.entries[source] = cachedSecret{value: value, expires: time.Now().Add(sc.ttl)}
+}
+
+func (sc *secretCache) currentGeneration() uint64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.generation
+}
+
+// invalidate drops the values of the sources, every value without source
+func (sc *secretCache) invalidate(sources []string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.generation++
+	if len(sources) == 0 {
+		sc.entries = nil
+		return
+	}
+	for _, source := range sources {
+		delete(sc.entries, source)
+	}
+}
+
+// SetSecretCache keeps the values returned by FetchSecret for ttl, 0 disables the cache (the default).
+// The values are dropped when the server invalidates their source and when the connection is lost,
+// the invalidations sent meanwhile being missed.
+func (c *Client) SetSecretCache(ttl time.Duration) {
+	c.secrets.mu.Lock()
+	defer c.secrets.mu.Unlock()
+	c.secrets.ttl = ttl
+	c.secrets.entries = nil
+}
+
+// FetchSecret returns the value of a secret source, from the cache when it is enabled
+func (c *Client) FetchSecret(ctx context.Context, source string) (string, error) {
+	if value, ok := c.secrets.get(source); ok {
+		return value, nil
+	}
+	generation := c.secrets.currentGeneration()
+	resp, err := c.SendRequest(ctx, EvtSecretRequest, SecretRequestPayload{Source: source})
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch secret '%s': %w", source, err)
+	}
+	secret, err := Decode[SecretResponsePayload](resp)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch secret '%s': %w", source, err)
+	}
+	c.secrets.put(source, secret.Value, generation)
+	return secret.Value, nil
+}
+
+// invalidateSecrets handles the invalidation sent by the server
+func (c *Client) invalidateSecrets(msg *Message) {
+	payload, err := Decode[SecretInvalidatePayload](msg)
+	if err != nil {
+		log.Printf("Client: Invalid secret invalidation: %v\n", err)
+		return
+	}
+	log.Printf("Client: Secrets %v invalidated by the server\n", payload.Sources)
+	c.secrets.invalidate(payload.Sources)
+}
@@ -0,0 +1,117 @@
+package socket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rotatingSecrets counts the fetches of its secrets, their values change with rotate
+type rotatingSecrets struct {
+	mu      sync.Mutex
+	values  map[string]string
+	fetches atomic.Int32
+}
+
+func (r *rotatingSecrets) GetSecret(ctx context.Context, source string) (string, error) {
+	r.fetches.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[source], nil
+}
+
+func (r *rotatingSecrets) rotate(source, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[source] = value
+}
+
+func startSecretServer(t *testing.T, secrets *rotatingSecrets) (*Server, *Client) {
+	t.Helper()
+	server := NewServer(&MockBuildTriggerer{}, secrets, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	t.Cleanup(client.Close)
+	return server, client
+}
+
+func TestClient_SecretCache(t *testing.T) {
+	secrets := &rotatingSecrets{values: map[string]string{"vault://db": "v1", "vault://api": "key"}}
+	server, client := startSecretServer(t, secrets)
+	client.SetSecretCache(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for range 3 {
+		value, err := client.FetchSecret(ctx, "vault://db")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", value)
+	}
+	_, err := client.FetchSecret(ctx, "vault://api")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), secrets.fetches.Load())
+
+	// The rotated source is fetched again, the other one stays cached
+	secrets.rotate("vault://db", "v2")
+	server.InvalidateSecrets("vault://db")
+	require.Eventually(t, func() bool {
+		value, err := client.FetchSecret(ctx, "vault://db")
+		return err == nil && value == "v2"
+	}, time.Second, 10*time.Millisecond)
+	fetches := secrets.fetches.Load()
+	_, err = client.FetchSecret(ctx, "vault://api")
+	require.NoError(t, err)
+	assert.Equal(t, fetches, secrets.fetches.Load())
+
+	// Every source
+	server.InvalidateSecrets()
+	require.Eventually(t, func() bool {
+		_, err := client.FetchSecret(ctx, "vault://api")
+		return err == nil && secrets.fetches.Load() > fetches
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClient_SecretCacheExpires(t *testing.T) {
+	secrets := &rotatingSecrets{values: map[string]string{"vault://db": "v1"}}
+	_, client := startSecretServer(t, secrets)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Disabled by default
+	for range 2 {
+		_, err := client.FetchSecret(ctx, "vault://db")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), secrets.fetches.Load())
+
+	client.SetSecretCache(20 * time.Millisecond)
+	for range 2 {
+		_, err := client.FetchSecret(ctx, "vault://db")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), secrets.fetches.Load())
+	time.Sleep(30 * time.Millisecond)
+	_, err := client.FetchSecret(ctx, "vault://db")
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), secrets.fetches.Load())
+}
+
+func TestSecretCache_InvalidationDuringFetch(t *testing.T) {
+	cache := secretCache{ttl: time.Minute}
+	generation := cache.currentGeneration()
+	cache.invalidate([]string{"vault://db"})
+	cache.put("vault://db", "stale", generation)
+	_, ok := cache.get("vault://db")
+	assert.False(t, ok)
+}
@@ -2,10 +2,13 @@ package socket
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -16,12 +19,56 @@ type Server struct {
 	upgrader      websocket.Upgrader
 	buildService  BuildTriggerer // Interface implementing a build process
 	secretFetcher SecretFetcher  // Interface implementing the secret service fetcher
+	auth          Authenticator  // Checks the connection requests, optional
+	deployments   DeploymentHistory
+	notifier      *serverBuildNotifier // Subscribers of the running builds
+	buildAccess   BuildAccessFunc      // Callers allowed to follow a build, SameCaller by default
+	projectAccess ProjectAccessFunc    // Callers allowed to change a project, UnauthenticatedOnly by default
+	sendPolicy    SendPolicy           // Bounds of the messages queued for each client
+	closing       atomic.Bool          // Shutdown was called, the connections and builds are refused
+
+	handlers    map[EventType]HandlerFunc // Handlers of the client events, see Handle
+	middlewares []Middleware
+	onClose     []func(conn *connection) // Called when a connection closes, after the notifier
+	handlersMu  sync.RWMutex
+	metrics     *Metrics // nil without instrumentation
 }
 
 type BuildTriggerer interface {
 	StartBuildAsync(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error
 }
 
+// BuildQueueInspector is optionally implemented by a BuildTriggerer which queues the builds,
+// so the server can report the queue position in the EvtBuildQueued acknowledgement.
+type BuildQueueInspector interface {
+	QueuePosition(buildID string) (int, bool)
+}
+
+// ProjectConfigManager is optionally implemented by a BuildTriggerer which supports
+// project level variables and secret references (EvtProjectConfigRequest).
+type ProjectConfigManager interface {
+	HandleProjectConfig(ctx context.Context, req ProjectConfigRequestPayload) (*ProjectConfigResponsePayload, error)
+}
+
+// BuildLister is optionally implemented by a BuildTriggerer which keeps the status of its builds,
+// so the clients can list them (EvtBuildList) and query one of them (EvtBuildGet). It returns every build,
+// the server keeps those the caller may access (see BuildAccessFunc).
+type BuildLister interface {
+	HandleBuildList(ctx context.Context, req BuildListPayload) (*BuildListResponsePayload, error)
+	HandleBuildGet(ctx context.Context, req BuildGetPayload) (*BuildGetResponsePayload, error)
+}
+
+// BuildCanceler is optionally implemented by a BuildTriggerer which can stop its builds (EvtBuildCancel).
+// It returns an error coded ErrCodeBuildNotFound or ErrCodeBuildFinished when the build cannot be canceled.
+type BuildCanceler interface {
+	HandleBuildCancel(ctx context.Context, req BuildCancelPayload) (*BuildCancelResponsePayload, error)
+}
+
+// DeploymentHistory answers the deployment history queries (EvtDeploymentsRequest)
+type DeploymentHistory interface {
+	HandleDeployments(ctx context.Context, req DeploymentsRequestPayload) (*DeploymentsResponsePayload, error)
+}
+
 type SecretFetcher interface {
 	GetSecret(ctx context.Context, source string) (string, error)
 }
@@ -31,24 +78,139 @@ type BuildNotifier interface {
 	NotifyStatus(buildID, status, artifactRef string, buildErr error, duration *float64)
 }
 
+// ProgressNotifier is implemented by the notifiers of the server and of the agents. The build
+// services report the queue position and the progress of the builds with it when available.
+type ProgressNotifier interface {
+	NotifyQueued(buildID string, position int, estimatedWait time.Duration)
+	NotifyProgress(progress BuildProgressPayload)
+}
+
+// BuildAccessFunc reports whether a caller may list a build, query, attach to or cancel it, or read its log history.
+// The owner is the caller which requested the build. Once the server forgot the build it is read from
+// the LogStore if it is a LogOwnerStore, else it is empty.
+type BuildAccessFunc func(caller, owner, buildID string) bool
+
+// SameCaller is the default BuildAccessFunc, only the caller which requested a build follows it. Without
+// Authenticator every caller is empty and follows every build.
+func SameCaller(caller, owner, buildID string) bool {
+	return caller == owner
+}
+
+// ProjectAccessFunc reports whether a caller may change the variables and secret references of a project,
+// merged into every later build of the project whoever requests it. Listing and reading are not checked.
+type ProjectAccessFunc func(caller, project string) bool
+
+// UnauthenticatedOnly is the default ProjectAccessFunc. Without Authenticator every caller is empty and
+// changes every project, with one the changes are refused until SetProjectAccess allows them.
+func UnauthenticatedOnly(caller, project string) bool {
+	return caller == ""
+}
+
+// ErrorCoder is implemented by the build errors carrying a machine readable code, sent in
+// the error_code of the status payloads
+type ErrorCoder interface {
+	ErrorCode() string
+}
+
+const (
+	// Log chunks of a build kept for the clients attaching late or reconnecting, the oldest are dropped
+	maxBufferedLogChunks = 1000
+	// Time a finished build is kept for the clients reconnecting after its final status
+	finishedBuildTTL = 10 * time.Minute
+	// Time the final status of a build finished without subscriber waits for its client to attach again
+	undeliveredStatusTTL = 24 * time.Hour
+	// Period of the checks of the running builds during the shutdown
+	shutdownPollInterval = 100 * time.Millisecond
+)
+
+// bufferedChunk is a log chunk kept for the replays
+type bufferedChunk struct {
+	sequence int64
+	msg      *Message
+}
+
+// watchedBuild holds the subscribers of a build and its recent messages. Its fields are read and
+// written under the lock of the notifier, its messages are sent under sendMu only: a slow subscriber
+// holds the updates of its builds, not the ones of the whole server.
+type watchedBuild struct {
+	sendMu      sync.Mutex      // Orders the messages sent to the subscribers, taken before the lock of the notifier
+	owner       string          // Caller which requested the build
+	subscribers map[peer]bool   // Clients receiving the log chunks and status of the build
+	chunks      []bufferedChunk // Latest log chunks, oldest first
+	sequence    int64           // Sequence of the last log chunk, 0 before the first one
+	progress    *Message        // Latest queue position or progress, sent before the status
+	status      *Message        // Latest status, sent after the replayed chunks
+	finishedAt  time.Time       // Zero while the build runs
+	delivered   bool            // The final status was sent to a subscriber
+}
+
 type serverBuildNotifier struct {
 	hub           *Hub
-	buildToClient map[string]*connection
+	buildToClient map[string]*watchedBuild
+	logStore      LogStore // Persisted log chunks, nil when disabled
 	mu            sync.RWMutex
 }
 
 func newServerBuildNotifier(hub *Hub) *serverBuildNotifier {
 	return &serverBuildNotifier{
 		hub:           hub,
-		buildToClient: make(map[string]*connection),
+		buildToClient: make(map[string]*watchedBuild),
 	}
 }
 
-func (sbn *serverBuildNotifier) registerBuildClient(buildID string, clientConn *connection) {
+// registerBuildClient registers a build with the connection which requested it as first subscriber
+func (sbn *serverBuildNotifier) registerBuildClient(buildID string, clientConn peer, owner string) {
 	sbn.mu.Lock()
 	defer sbn.mu.Unlock()
-	sbn.buildToClient[buildID] = clientConn
-	log.Printf("Notifier: Registered client %p for build %s\n", clientConn.ws, buildID)
+	sbn.purgeFinished()
+	sbn.buildToClient[buildID] = &watchedBuild{owner: owner, subscribers: map[peer]bool{clientConn: true}}
+	log.Printf("Notifier: Registered client %p for build %s\n", clientConn, buildID)
+}
+
+// persistOwner keeps the caller which requested a build in the log store, if it supports it
+func (sbn *serverBuildNotifier) persistOwner(buildID, owner string) {
+	sbn.mu.RLock()
+	owners, ok := sbn.logStore.(LogOwnerStore)
+	sbn.mu.RUnlock()
+	if !ok || owner == "" {
+		return
+	}
+	if err := owners.SetLogOwner(buildID, owner); err != nil {
+		log.Printf("Notifier: Error persisting the owner of build %s: %v\n", buildID, err)
+	}
+}
+
+// buildOwner returns the caller which requested a build, read from the log store once the build is
+// forgotten. It is empty when the build is unknown.
+func (sbn *serverBuildNotifier) buildOwner(buildID string) string {
+	sbn.mu.RLock()
+	build, ok := sbn.buildToClient[buildID]
+	owners, persisted := sbn.logStore.(LogOwnerStore)
+	sbn.mu.RUnlock()
+	if ok {
+		return build.owner
+	}
+	if !persisted {
+		return ""
+	}
+	owner, err := owners.LogOwner(buildID)
+	if err != nil {
+		log.Printf("Notifier: Error reading the owner of build %s: %v\n", buildID, err)
+	}
+	return owner
+}
+
+// runningBuilds counts the builds without final status, queued ones included
+func (sbn *serverBuildNotifier) runningBuilds() int {
+	sbn.mu.RLock()
+	defer sbn.mu.RUnlock()
+	running := 0
+	for _, build := range sbn.buildToClient {
+		if build.finishedAt.IsZero() {
+			running++
+		}
+	}
+	return running
 }
 
 func (sbn *serverBuildNotifier) unregisterBuild(buildID string) {
@@ -58,41 +220,226 @@ func (sbn *serverBuildNotifier) unregisterBuild(buildID string) {
 	log.Printf("Notifier: Unregistered build %s\n", buildID)
 }
 
-func (sbn *serverBuildNotifier) getClientForBuild(buildID string) *connection {
+// purgeFinished forgets the builds finished for longer than finishedBuildTTL. The final status sent to
+// no subscriber is kept until undeliveredStatusTTL for the client attaching again. sbn.mu must be held.
+func (sbn *serverBuildNotifier) purgeFinished() {
+	for buildID, build := range sbn.buildToClient {
+		if build.finishedAt.IsZero() {
+			continue
+		}
+		age := time.Since(build.finishedAt)
+		switch {
+		case age > undeliveredStatusTTL || (build.delivered && age > finishedBuildTTL):
+			delete(sbn.buildToClient, buildID)
+		case age > finishedBuildTTL && build.chunks != nil:
+			// Only the status waits, the log history keeps the chunks when a log store is set
+			build.chunks, build.progress = nil, nil
+		}
+	}
+}
+
+// detachClient removes a disconnected connection from the subscribers of the builds
+func (sbn *serverBuildNotifier) detachClient(clientConn peer) {
+	sbn.mu.Lock()
+	defer sbn.mu.Unlock()
+	for buildID, build := range sbn.buildToClient {
+		if build.subscribers[clientConn] {
+			delete(build.subscribers, clientConn)
+			log.Printf("Notifier: Client %p of build %s disconnected\n", clientConn, buildID)
+		}
+	}
+}
+
+// lockBuild returns a build with its send lock held, nil when the build is unknown
+func (sbn *serverBuildNotifier) lockBuild(buildID string) *watchedBuild {
 	sbn.mu.RLock()
-	defer sbn.mu.RUnlock()
-	return sbn.buildToClient[buildID]
+	build, ok := sbn.buildToClient[buildID]
+	sbn.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	build.sendMu.Lock()
+	return build
+}
+
+// subscriberList returns the subscribers of a build, to send them a message once sbn.mu is released.
+// sbn.mu must be held.
+func (build *watchedBuild) subscriberList() []peer {
+	subscribers := make([]peer, 0, len(build.subscribers))
+	for conn := range build.subscribers {
+		subscribers = append(subscribers, conn)
+	}
+	return subscribers
+}
+
+// attachClient subscribes a connection to a build. The kept log chunks following the sequence
+// after are sent first, then the latest status. It returns false when the build is unknown.
+func (sbn *serverBuildNotifier) attachClient(buildID string, clientConn peer, after int64) bool {
+	sbn.mu.Lock()
+	sbn.purgeFinished()
+	sbn.mu.Unlock()
+	build := sbn.lockBuild(buildID)
+	if build == nil {
+		return false
+	}
+	defer build.sendMu.Unlock()
+
+	// The updates of the build wait for the replay, they are sent under the send lock
+	sbn.mu.Lock()
+	var replay []*Message
+	for _, chunk := range build.chunks {
+		if chunk.sequence > after {
+			replay = append(replay, chunk.msg)
+		}
+	}
+	replayed := len(replay)
+	running := build.finishedAt.IsZero()
+	if build.progress != nil && running {
+		replay = append(replay, build.progress)
+	}
+	status := build.status
+	if running {
+		build.subscribers[clientConn] = true
+	}
+	sbn.mu.Unlock()
+
+	for _, msg := range replay {
+		clientConn.sendMsg(msg)
+	}
+	if status != nil && clientConn.sendMsg(status) && !running {
+		sbn.mu.Lock()
+		build.delivered = true
+		sbn.mu.Unlock()
+	}
+	log.Printf("Notifier: Attached client %p to build %s after sequence %d (%d chunks replayed)\n", clientConn, buildID, after, replayed)
+	return true
 }
 
 func (sbn *serverBuildNotifier) NotifyLog(buildID string, stream string, content string) {
-	clientConn := sbn.getClientForBuild(buildID)
-	if clientConn == nil {
+	build := sbn.lockBuild(buildID)
+	if build == nil {
 		log.Printf("Notifier: No client found for build %s to send log chunk.\n", buildID)
 		return
 	}
+	defer build.sendMu.Unlock()
 
-	msg := NewMessage(EvtLogChunk, "")
+	sbn.mu.Lock()
+	build.sequence++
 	payload := LogChunkPayload{
-		BuildID: buildID,
-		Stream:  stream,
-		Content: content,
+		BuildID:  buildID,
+		Stream:   stream,
+		Content:  content,
+		Sequence: build.sequence,
 	}
-	if err := msg.AddPayload(payload); err == nil {
-		clientConn.sendMsg(msg)
-	} else {
+	msg := NewMessage(EvtLogChunk, "")
+	if err := msg.AddPayload(payload); err != nil {
+		sbn.mu.Unlock()
 		log.Printf("Notifier: Error creating log chunk payload for build %s: %v\n", buildID, err)
+		return
+	}
+	if len(build.chunks) == maxBufferedLogChunks {
+		build.chunks = build.chunks[1:]
+	}
+	build.chunks = append(build.chunks, bufferedChunk{sequence: build.sequence, msg: msg})
+	subscribers := build.subscriberList()
+	store := sbn.logStore
+	sbn.mu.Unlock()
+
+	if store != nil {
+		if err := store.AppendLog(payload); err != nil {
+			log.Printf("Notifier: Error persisting log chunk %d of build %s: %v\n", payload.Sequence, buildID, err)
+		}
+	}
+	for _, conn := range subscribers {
+		conn.sendMsg(msg)
 	}
 }
 
 func (sbn *serverBuildNotifier) NotifyStatus(buildID string, status string, artifactRef string, buildErr error, duration *float64) {
-	clientConn := sbn.getClientForBuild(buildID)
-	if clientConn == nil {
+	build := sbn.lockBuild(buildID)
+	if build == nil {
 		log.Printf("Notifier: No client found for build %s to send status update.\n", buildID)
-		sbn.unregisterBuild(buildID)
 		return
 	}
+	defer build.sendMu.Unlock()
+
+	msg, err := NewPayloadMessage("", newStatusPayload(buildID, status, artifactRef, buildErr, duration))
+	if err != nil {
+		log.Printf("Notifier: Error creating build status payload for build %s: %v\n", buildID, err)
+		return
+	}
+	sbn.mu.Lock()
+	build.status = msg
+	subscribers := build.subscriberList()
+	// The finished build stays for the replays of the reconnecting clients
+	final := finalStatus(status)
+	if final {
+		build.finishedAt = time.Now()
+		build.subscribers = make(map[peer]bool)
+	}
+	sbn.mu.Unlock()
+
+	delivered := false
+	for _, conn := range subscribers {
+		if conn.sendMsg(msg) {
+			delivered = true
+		}
+	}
+	if final {
+		sbn.mu.Lock()
+		build.delivered = delivered
+		sbn.mu.Unlock()
+		if !delivered {
+			log.Printf("Notifier: Build %s finished without subscriber, its status waits for its client\n", buildID)
+		}
+	}
+}
+
+// NotifyQueued sends the new queue position of a build to its subscribers
+func (sbn *serverBuildNotifier) NotifyQueued(buildID string, position int, estimatedWait time.Duration) {
+	sbn.notifyProgress(buildID, BuildQueuedPayload{
+		BuildID:          buildID,
+		Message:          fmt.Sprintf("Build job queued at position %d", position),
+		Position:         position,
+		EstimatedWaitSec: estimatedWait.Seconds(),
+	})
+}
+
+// NotifyProgress sends the progress of a running build to its subscribers
+func (sbn *serverBuildNotifier) NotifyProgress(progress BuildProgressPayload) {
+	sbn.notifyProgress(progress.BuildID, progress)
+}
+
+// notifyProgress sends a queue position or progress update, the latest one is kept for the replays
+func (sbn *serverBuildNotifier) notifyProgress(buildID string, payload Payload) {
+	build := sbn.lockBuild(buildID)
+	if build == nil {
+		log.Printf("Notifier: No client found for build %s to send %s.\n", buildID, payload.EventType())
+		return
+	}
+	defer build.sendMu.Unlock()
+
+	msg, err := NewPayloadMessage("", payload)
+	if err != nil {
+		log.Printf("Notifier: Error creating %s payload for build %s: %v\n", payload.EventType(), buildID, err)
+		return
+	}
+	sbn.mu.Lock()
+	build.progress = msg
+	subscribers := build.subscriberList()
+	sbn.mu.Unlock()
+	for _, conn := range subscribers {
+		conn.sendMsg(msg)
+	}
+}
 
-	msg := NewMessage(EvtBuildStatus, "")
+// finalStatus reports whether a build status ends the build
+func finalStatus(status string) bool {
+	return status == "success" || status == "failure"
+}
+
+// newStatusPayload returns the status of a build, with the message and code of its error if any
+func newStatusPayload(buildID, status, artifactRef string, buildErr error, duration *float64) BuildStatusPayload {
 	payload := BuildStatusPayload{
 		BuildID:     buildID,
 		Status:      status,
@@ -101,17 +448,12 @@ func (sbn *serverBuildNotifier) NotifyStatus(buildID string, status string, arti
 	}
 	if buildErr != nil {
 		payload.Message = buildErr.Error()
+		var coder ErrorCoder
+		if errors.As(buildErr, &coder) {
+			payload.ErrorCode = coder.ErrorCode()
+		}
 	}
-
-	if err := msg.AddPayload(payload); err == nil {
-		clientConn.sendMsg(msg)
-	} else {
-		log.Printf("Notifier: Error creating build status payload for build %s: %v\n", buildID, err)
-	}
-
-	if status == "success" || status == "failure" {
-		sbn.unregisterBuild(buildID)
-	}
+	return payload
 }
 
 // Creating a new Websocket server and upgrading connection
@@ -120,6 +462,9 @@ func NewServer(buildSvc BuildTriggerer, secretF SecretFetcher, originChecker fun
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			Subprotocols:    subprotocols(codecs),
+			// permessage-deflate for the clients offering it, the verbose logs compress well
+			EnableCompression: true,
 			CheckOrigin: func(r *http.Request) bool {
 				log.Printf("CheckOrigin: Checking origin %s\n", r.Header.Get("Origin"))
 				return originChecker(r)
@@ -127,18 +472,115 @@ func NewServer(buildSvc BuildTriggerer, secretF SecretFetcher, originChecker fun
 		},
 		buildService:  buildSvc,
 		secretFetcher: secretF,
+		buildAccess:   SameCaller,
+		projectAccess: UnauthenticatedOnly,
+		sendPolicy:    DefaultSendPolicy,
 	}
 	server.hub = newHub(server.handleMessage)
+	server.registerBuiltinHandlers()
+	server.notifier = newServerBuildNotifier(server.hub)
 	return server
 }
 
+// SetAuthenticator requires valid credentials before upgrading a connection, nil disables the check.
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.auth = auth
+}
+
+// SetBuildAccess sets the callers allowed to follow the builds of the others, nil restores SameCaller.
+// It is called before serving.
+func (s *Server) SetBuildAccess(access BuildAccessFunc) {
+	if access == nil {
+		access = SameCaller
+	}
+	s.buildAccess = access
+}
+
+// SetProjectAccess sets the callers allowed to change the projects, nil restores UnauthenticatedOnly.
+// It is called before serving.
+func (s *Server) SetProjectAccess(access ProjectAccessFunc) {
+	if access == nil {
+		access = UnauthenticatedOnly
+	}
+	s.projectAccess = access
+}
+
+// canAccessBuild reports whether a caller may follow a build, see BuildAccessFunc
+func (s *Server) canAccessBuild(caller, buildID string) bool {
+	return s.buildAccess(caller, s.notifier.buildOwner(buildID), buildID)
+}
+
+// SetDeploymentHistory enables the deployment history queries
+func (s *Server) SetDeploymentHistory(history DeploymentHistory) {
+	s.deployments = history
+}
+
+// SetSendPolicy bounds the messages queued for each client at the next connections
+func (s *Server) SetSendPolicy(policy SendPolicy) {
+	s.sendPolicy = policy
+}
+
+// SetCompression accepts permessage-deflate for the next connections (the default)
+func (s *Server) SetCompression(enabled bool) {
+	s.upgrader.EnableCompression = enabled
+}
+
+// SetLogStore persists the log chunks of the builds and enables the log history queries, nil disables both
+func (s *Server) SetLogStore(store LogStore) {
+	s.notifier.mu.Lock()
+	defer s.notifier.mu.Unlock()
+	s.notifier.logStore = store
+}
+
 // Launching the Hub in a goroutine.
 func (s *Server) Run() {
 	go s.hub.run()
 }
 
+// Shutdown refuses the new connections and builds, waits for the final status of the running builds
+// until ctx is done, then closes the connections with the going away code and stops the hub. The
+// builds still running at the deadline go on, their log chunks are kept by the LogStore if any.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.closing.CompareAndSwap(false, true) {
+		return errors.New("server already shut down")
+	}
+	log.Println("Server: Shutting down, waiting for the running builds")
+
+	var err error
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for running := s.notifier.runningBuilds(); running > 0; running = s.notifier.runningBuilds() {
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("%d builds still running at shutdown: %w", running, ctx.Err())
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	s.hub.closeAll(websocket.CloseGoingAway, "server shutting down")
+	s.hub.stop()
+	log.Println("Server: Shut down")
+	return err
+}
+
 // Handling http request and trying to upgrade it to a websocket connection.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var caller string
+	if s.auth != nil {
+		name, err := s.auth.Authenticate(r)
+		if err != nil {
+			log.Printf("ServeHTTP: Rejected connection from %s: %v\n", r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		caller = name
+	}
+	if s.closing.Load() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("ServeHTTP: Failed to upgrade connection: %v\n", err)
@@ -146,92 +588,362 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("ServeHTTP: Client connected from %s\n", ws.RemoteAddr())
 
-	conn := newConnection(ws)
+	conn := newConnection(ws, s.sendPolicy)
+	conn.caller = caller
+	conn.metrics = s.metrics
 
-	s.hub.register <- conn
+	if !s.hub.registerConn(conn) {
+		conn.closeSendWith(websocket.CloseGoingAway, "server shutting down")
+		go conn.writePump()
+		return
+	}
+	s.metrics.connectionOpened()
 
 	go conn.writePump()
-	go conn.readPump(s.hub.handleIncomingMessage, s.hub.handleDisconnect)
+	go conn.readPump(s.hub.handleIncomingMessage, s.handleDisconnect)
+}
+
+// handleDisconnect unsubscribes the connection from its builds before closing it
+func (s *Server) handleDisconnect(conn *connection) {
+	s.metrics.connectionClosed()
+	s.notifier.detachClient(conn)
+	s.handlersMu.RLock()
+	hooks := s.onClose
+	s.handlersMu.RUnlock()
+	for _, hook := range hooks {
+		hook(conn)
+	}
+	s.hub.handleDisconnect(conn)
 }
 
 // The main entry point for all incoming Message.
 func (s *Server) handleMessage(msg *Message, client *connection) error {
-	ctx := context.Background()
 	log.Printf("Server: Handling message type '%s' from %p (ReqID: %s)\n", msg.Type, client.ws, msg.RequestID)
+	return s.dispatch(msg, client, client.caller)
+}
 
-	switch msg.Type {
-	case EvtBuildRequest:
-		var payload BuildRequestPayload
-		if err := msg.DecodePayload(&payload); err != nil {
-			return fmt.Errorf("invalid build request payload: %w", err)
-		}
-		if payload.BuildSpecYAML == "" {
-			return fmt.Errorf("build spec YAML cannot be empty")
-		}
+// dispatch runs the handler of a message with the middlewares, the messages of the websocket
+// connections and of the REST API go through it
+func (s *Server) dispatch(msg *Message, client peer, caller string) error {
+	s.handlersMu.RLock()
+	handler, ok := s.handlers[msg.Type]
+	middlewares := s.middlewares
+	s.handlersMu.RUnlock()
+	s.metrics.messageReceived(msg, ok)
+	if !ok {
+		log.Printf("Server: Received unhandled message type '%s'\n", msg.Type)
+		errMsg := NewErrorMessage(msg.RequestID, ErrCodeUnsupported, "Unhandled message type", fmt.Sprintf("Type '%s' not supported by server", msg.Type))
+		client.sendMsg(errMsg)
+		return nil
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	start := time.Now()
+	err := handler(context.Background(), &Request{Message: msg, Caller: caller, conn: client})
+	s.metrics.requestHandled(msg, err, time.Since(start))
+	return err
+}
 
-		uuid := uuid.NewString()
-		buildID := fmt.Sprintf("build-%s", uuid)
+func (s *Server) handleBuildRequest(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[BuildRequestPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid build request payload: %w", err)
+	}
+	if payload.BuildSpecYAML == "" {
+		return codedErrorf(ErrCodeInvalidPayload, "build spec YAML cannot be empty")
+	}
 
-		// immediately acknowledge the build request
-		ackPayload := BuildQueuedPayload{BuildID: buildID, Message: "Build job accepted"}
-		ackMsg := NewMessage(EvtBuildQueued, msg.RequestID) // Utilise le RequestID original
-		if err := ackMsg.AddPayload(ackPayload); err != nil {
-			log.Printf("Server: Failed to create build queued payload: %v\n", err)
-		}
-		client.sendMsg(ackMsg)
-
-		// Create and register the notifier for this build
-		notifier := newServerBuildNotifier(s.hub) 
-		notifier.registerBuildClient(buildID, client)
-
-		// Start the build asynchronously via the interface
-		go func() {
-			log.Printf("Server: Starting build %s asynchronously\n", buildID)
-			// The context can be used for eventual cancellation
-			err := s.buildService.StartBuildAsync(context.Background(), buildID, payload.BuildSpecYAML, notifier)
-			if err != nil {
-				// If StartBuildAsync fails immediately (rare), notify the failure
-				log.Printf("Server: Failed to start build %s: %v\n", buildID, err)
-				notifier.NotifyStatus(buildID, "failure", "", err, nil)
-				// The notifier will unregister the build
-			}
-			// If StartBuildAsync succeeds, the build runs and the notifier will handle logs/status
-		}()
-
-		return nil // Success in processing the request (the build is started asynchronously)
-
-	case EvtSecretRequest:
-		var payload SecretRequestPayload
-		if err := msg.DecodePayload(&payload); err != nil {
-			return fmt.Errorf("invalid secret request payload: %w", err)
+	if s.closing.Load() {
+		return codedErrorf(ErrCodeUnavailable, "build request rejected: server shutting down")
+	}
+	uuid := uuid.NewString()
+	buildID := fmt.Sprintf("build-%s", uuid)
+
+	// Register the client of this build
+	notifier := s.notifier
+	notifier.registerBuildClient(buildID, client, req.Caller)
+	notifier.persistOwner(buildID, req.Caller)
+
+	// Queue the build via the interface, StartBuildAsync returns as soon as the job is accepted
+	log.Printf("Server: Starting build %s asynchronously\n", buildID)
+	buildCtx := context.Background()
+	if payload.Requirements != nil {
+		buildCtx = WithBuildRequirements(buildCtx, *payload.Requirements)
+	}
+	if err := s.buildService.StartBuildAsync(buildCtx, buildID, payload.BuildSpecYAML, notifier); err != nil {
+		log.Printf("Server: Failed to start build %s: %v\n", buildID, err)
+		notifier.unregisterBuild(buildID)
+		return fmt.Errorf("build request rejected: %w", err)
+	}
+
+	// Acknowledge the build request with its position if the build service has a queue
+	ackPayload := BuildQueuedPayload{BuildID: buildID, Message: "Build job accepted"}
+	if inspector, ok := s.buildService.(BuildQueueInspector); ok {
+		if position, queued := inspector.QueuePosition(buildID); queued {
+			ackPayload.Position = position
+			ackPayload.Message = fmt.Sprintf("Build job queued at position %d", position)
 		}
-		if payload.Source == "" {
-			return fmt.Errorf("secret source cannot be empty")
+	}
+	ackMsg, err := NewPayloadMessage(msg.RequestID, ackPayload) // Utilise le RequestID original
+	if err != nil {
+		log.Printf("Server: Failed to create build queued payload: %v\n", err)
+		return nil
+	}
+	client.sendMsg(ackMsg)
+
+	return nil // Success in processing the request (the build is started asynchronously)
+}
+
+func (s *Server) handleSecretRequest(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[SecretRequestPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid secret request payload: %w", err)
+	}
+	if payload.Source == "" {
+		return codedErrorf(ErrCodeInvalidPayload, "secret source cannot be empty")
+	}
+	if s.secretFetcher == nil {
+		return codedErrorf(ErrCodeUnsupported, "secret fetcher service is not configured on the server")
+	}
+
+	// Fetch the secret using the secret fetcher service
+	secretValue, err := s.secretFetcher.GetSecret(ctx, payload.Source)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Failed to fetch secret", err.Error())
+		client.sendMsg(errMsg)
+		return nil
+	}
+
+	respMsg, err := NewPayloadMessage(msg.RequestID, SecretResponsePayload{Source: payload.Source, Value: secretValue})
+	if err != nil {
+		return fmt.Errorf("failed to create secret response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
+
+// InvalidateSecrets tells the connected clients that secret sources were rotated, they drop their
+// cached values and fetch them again. Without source, every cached value is dropped.
+func (s *Server) InvalidateSecrets(sources ...string) {
+	msg, err := NewPayloadMessage("", SecretInvalidatePayload{Sources: sources})
+	if err != nil {
+		log.Printf("Server: Failed to create secret invalidation payload: %v\n", err)
+		return
+	}
+	count := s.hub.sendAll(msg)
+	log.Printf("Server: Invalidated secrets %v on %d connections\n", sources, count)
+}
+
+func (s *Server) handleProjectConfig(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[ProjectConfigRequestPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid project config request payload: %w", err)
+	}
+	manager, ok := s.buildService.(ProjectConfigManager)
+	if !ok {
+		return codedErrorf(ErrCodeUnsupported, "project configuration is not supported by the build service")
+	}
+	if payload.Action != ProjectActionList && payload.Action != ProjectActionGet && !s.projectAccess(req.Caller, payload.Project) {
+		return codedErrorf(ErrCodeUnauthorized, "caller '%s' is not allowed to change the project '%s'", req.Caller, payload.Project)
+	}
+
+	respPayload, err := manager.HandleProjectConfig(ctx, payload)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Project config request failed", err.Error())
+		client.sendMsg(errMsg)
+		return nil
+	}
+
+	respMsg, err := NewPayloadMessage(msg.RequestID, *respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create project config response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
+
+func (s *Server) handleDeployments(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[DeploymentsRequestPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid deployments request payload: %w", err)
+	}
+	if s.deployments == nil {
+		return codedErrorf(ErrCodeUnsupported, "deployment history is not configured on the server")
+	}
+
+	respPayload, err := s.deployments.HandleDeployments(ctx, payload)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Deployments request failed", err.Error())
+		client.sendMsg(errMsg)
+		return nil
+	}
+
+	respMsg, err := NewPayloadMessage(msg.RequestID, *respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create deployments response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
+
+func (s *Server) handleBuildList(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[BuildListPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid build list payload: %w", err)
+	}
+	lister, ok := s.buildService.(BuildLister)
+	if !ok {
+		return codedErrorf(ErrCodeUnsupported, "build queries are not supported by the build service")
+	}
+
+	// The limit applies to the builds the caller may access
+	limit := payload.Limit
+	payload.Limit = 0
+	respPayload, err := lister.HandleBuildList(ctx, payload)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Build list request failed", err.Error())
+		client.sendMsg(errMsg)
+		return nil
+	}
+	builds := make([]BuildInfoPayload, 0, len(respPayload.Builds))
+	for _, info := range respPayload.Builds {
+		if limit > 0 && len(builds) == limit {
+			break
 		}
-		if s.secretFetcher == nil {
-			return fmt.Errorf("secret fetcher service is not configured on the server")
+		if s.canAccessBuild(req.Caller, info.BuildID) {
+			builds = append(builds, info)
 		}
+	}
+	respPayload.Builds = builds
 
-		// Fetch the secret using the secret fetcher service
-		secretValue, err := s.secretFetcher.GetSecret(ctx, payload.Source)
-		if err != nil {
-			errMsg := NewErrorMessage(msg.RequestID, "Failed to fetch secret", err.Error())
-			client.sendMsg(errMsg)
-			return nil
-		}
+	respMsg, err := NewPayloadMessage(msg.RequestID, *respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create build list response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
 
-		respPayload := SecretResponsePayload{Source: payload.Source, Value: secretValue}
-		respMsg := NewMessage(EvtSecretResponse, msg.RequestID)
-		if err := respMsg.AddPayload(respPayload); err != nil {
-			return fmt.Errorf("failed to create secret response payload: %w", err)
-		}
-		client.sendMsg(respMsg)
+func (s *Server) handleBuildGet(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[BuildGetPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid build get payload: %w", err)
+	}
+	if payload.BuildID == "" {
+		return codedErrorf(ErrCodeInvalidPayload, "build ID cannot be empty")
+	}
+	lister, ok := s.buildService.(BuildLister)
+	if !ok {
+		return codedErrorf(ErrCodeUnsupported, "build queries are not supported by the build service")
+	}
+	// The callers not allowed to follow the build are answered as for an unknown build
+	if !s.canAccessBuild(req.Caller, payload.BuildID) {
+		return codedErrorf(ErrCodeBuildNotFound, "build '%s' not found", payload.BuildID)
+	}
+
+	respPayload, err := lister.HandleBuildGet(ctx, payload)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Build get request failed", err.Error())
+		client.sendMsg(errMsg)
 		return nil
+	}
 
-	default:
-		log.Printf("Server: Received unhandled message type '%s'\n", msg.Type)
-		errMsg := NewErrorMessage(msg.RequestID, "Unhandled message type", fmt.Sprintf("Type '%s' not supported by server", msg.Type))
+	respMsg, err := NewPayloadMessage(msg.RequestID, *respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create build get response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
+
+func (s *Server) handleBuildCancel(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[BuildCancelPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid build cancel payload: %w", err)
+	}
+	if payload.BuildID == "" {
+		return codedErrorf(ErrCodeInvalidPayload, "build ID cannot be empty")
+	}
+	canceler, ok := s.buildService.(BuildCanceler)
+	if !ok {
+		return codedErrorf(ErrCodeUnsupported, "build cancellation is not supported by the build service")
+	}
+	if !s.canAccessBuild(req.Caller, payload.BuildID) {
+		return codedErrorf(ErrCodeBuildNotFound, "build '%s' not found", payload.BuildID)
+	}
+
+	respPayload, err := canceler.HandleBuildCancel(ctx, payload)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Build cancel request failed", err.Error())
 		client.sendMsg(errMsg)
 		return nil
 	}
+
+	respMsg, err := NewPayloadMessage(msg.RequestID, *respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create build cancel response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
+
+func (s *Server) handleLogHistory(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[LogHistoryPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid log history payload: %w", err)
+	}
+	if payload.BuildID == "" {
+		return codedErrorf(ErrCodeInvalidPayload, "build ID cannot be empty")
+	}
+	// The callers not allowed to follow the build are answered as for an unknown build
+	if !s.canAccessBuild(req.Caller, payload.BuildID) {
+		return fmt.Errorf("%w: %s", ErrNoLogs, payload.BuildID)
+	}
+
+	respPayload, err := s.logHistory(payload)
+	if err != nil {
+		errMsg := NewErrorMessage(msg.RequestID, CodeOf(err), "Log history request failed", err.Error())
+		client.sendMsg(errMsg)
+		return nil
+	}
+
+	respMsg, err := NewPayloadMessage(msg.RequestID, *respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create log history response payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
+}
+
+func (s *Server) handleBuildAttach(ctx context.Context, req *Request) error {
+	msg, client := req.Message, req.conn
+	payload, err := Decode[BuildAttachPayload](msg)
+	if err != nil {
+		return codedErrorf(ErrCodeInvalidPayload, "invalid build attach payload: %w", err)
+	}
+
+	var respPayload BuildAttachedPayload
+	for _, buildID := range payload.BuildIDs {
+		// The builds the caller is not allowed to follow are reported unknown
+		if s.canAccessBuild(req.Caller, buildID) && s.notifier.attachClient(buildID, client, payload.Offsets[buildID]) {
+			respPayload.Attached = append(respPayload.Attached, buildID)
+		} else {
+			respPayload.Unknown = append(respPayload.Unknown, buildID)
+		}
+	}
+	respMsg, err := NewPayloadMessage(msg.RequestID, respPayload)
+	if err != nil {
+		return fmt.Errorf("failed to create build attached payload: %w", err)
+	}
+	client.sendMsg(respMsg)
+	return nil
 }
@@ -0,0 +1,68 @@
+package socket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ShutdownWaitsForBuilds(t *testing.T) {
+	release := make(chan struct{})
+	server, wsURL := startHeldBuildServer(t, release)
+	client := NewClient()
+	client.SetReconnectPolicy(nil)
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	startBuild(t, client)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	require.Eventually(t, server.closing.Load, time.Second, 5*time.Millisecond)
+
+	// New connections and builds are refused while the build runs
+	late := NewClient()
+	err := late.Connect(wsURL, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: "name: app"})
+	assert.ErrorContains(t, err, "server shutting down")
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before the end of the build: %v", err)
+	default:
+	}
+
+	// The final status is delivered before the connections are closed
+	close(release)
+	_, status := receiveBuildMessages(t, client)
+	assert.Equal(t, "success", status.Status)
+	select {
+	case err := <-shutdown:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	assert.Eventually(t, func() bool { return !client.IsConnected() }, time.Second, 10*time.Millisecond)
+	assert.Error(t, server.Shutdown(context.Background()))
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	server, wsURL := startHeldBuildServer(t, make(chan struct{}))
+	client := NewClient()
+	client.SetReconnectPolicy(nil)
+	require.NoError(t, client.Connect(wsURL, nil))
+	defer client.Close()
+	startBuild(t, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "1 builds still running")
+	assert.Eventually(t, func() bool { return !client.IsConnected() }, time.Second, 10*time.Millisecond)
+}
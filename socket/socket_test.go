@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -215,3 +216,335 @@ func TestSocket_ClientServerCommunication(t *testing.T) {
 	<-time.After(100 * time.Millisecond)
 
 }
+
+func TestServer_RejectsInvalidToken(t *testing.T) {
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.SetAuthenticator(NewTokenAuth("s3cret"))
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	client := NewClient()
+	err := client.Connect(wsURL, http.Header{"Authorization": []string{"Bearer wrong"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+
+	client = NewClient()
+	require.NoError(t, client.Connect(wsURL, http.Header{"Authorization": []string{"Bearer s3cret"}}))
+	client.Close()
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	path := t.TempDir() + "/tokens"
+	require.NoError(t, os.WriteFile(path, []byte("# CI tokens\nci abc123\n\nraw:token\n"), 0600))
+	auth, err := LoadTokenFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, auth.Len())
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.SetBasicAuth("anyone", "abc123")
+	name, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ci", name)
+
+	// An unnamed token containing ':' is kept whole
+	req = httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.Header.Set("Authorization", "Bearer raw:token")
+	name, err = auth.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "token-4", name)
+
+	req = httptest.NewRequest(http.MethodGet, "/v2/?token=nope", nil)
+	_, err = auth.Authenticate(req)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestLoadTokenFileInvalidLine(t *testing.T) {
+	path := t.TempDir() + "/tokens"
+	require.NoError(t, os.WriteFile(path, []byte("ci abc123 extra\n"), 0600))
+	_, err := LoadTokenFile(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+}
+
+func TestPayloadEventPairing(t *testing.T) {
+	// Every event type carrying a payload must decode to the struct paired with it
+	eventTypes := []EventType{
+		EvtBuildRequest, EvtSecretRequest, EvtProjectConfigRequest,
+		EvtDeploymentsRequest, EvtBuildAttach, EvtBuildList, EvtBuildGet, EvtBuildQueued, EvtLogChunk, EvtBuildStatus,
+		EvtBuildProgress,
+		EvtSecretResponse, EvtSecretInvalidate, EvtProjectConfigResponse, EvtDeploymentsResponse, EvtBuildAttached, EvtBuildListResponse,
+		EvtBuildGetResponse, EvtBuildCancel, EvtBuildCancelResponse, EvtLogHistory, EvtLogHistoryResponse,
+		EvtAgentRegister, EvtAgentRegistered, EvtAgentJob,
+		EvtChunkBegin, EvtChunkData, EvtChunkEnd, EvtError,
+	}
+	assert.Len(t, payloadTypes, len(eventTypes))
+	for _, eventType := range eventTypes {
+		decode, ok := payloadTypes[eventType]
+		require.True(t, ok, "no payload registered for %s", eventType)
+		payload, err := decode(&Message{Type: eventType, Payload: []byte(`{}`)})
+		require.NoError(t, err, eventType)
+		assert.Equal(t, eventType, payload.EventType())
+	}
+}
+
+func TestDecodeAndEvent(t *testing.T) {
+	msg, err := NewPayloadMessage("req-1", LogChunkPayload{BuildID: "b1", Stream: "stdout", Content: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, EvtLogChunk, msg.Type)
+
+	chunk, err := Decode[LogChunkPayload](msg)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", chunk.Content)
+
+	_, err = Decode[BuildStatusPayload](msg)
+	assert.ErrorIs(t, err, ErrUnexpectedEvent)
+
+	event, err := msg.Event()
+	require.NoError(t, err)
+	switch event := event.(type) {
+	case LogChunkPayload:
+		assert.Equal(t, "b1", event.BuildID)
+	default:
+		t.Fatalf("unexpected event %T", event)
+	}
+
+	event, err = NewMessage(EvtPing, "").Event()
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	_, err = NewMessage("unknown", "").Event()
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+type fakeDeploymentHistory struct{}
+
+func (fakeDeploymentHistory) HandleDeployments(ctx context.Context, req DeploymentsRequestPayload) (*DeploymentsResponsePayload, error) {
+	if req.Action != DeploymentActionList {
+		return nil, fmt.Errorf("unknown deployments action '%s'", req.Action)
+	}
+	return &DeploymentsResponsePayload{Deployments: []DeploymentPayload{{ID: "prod-1", Target: "prod", Outcome: "success"}}}, nil
+}
+
+func TestServer_DeploymentsRequest(t *testing.T) {
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.SetDeploymentHistory(fakeDeploymentHistory{})
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.SendRequest(ctx, EvtDeploymentsRequest, DeploymentsRequestPayload{Action: DeploymentActionList})
+	require.NoError(t, err)
+	deployments, err := Decode[DeploymentsResponsePayload](resp)
+	require.NoError(t, err)
+	require.Len(t, deployments.Deployments, 1)
+	assert.Equal(t, "prod-1", deployments.Deployments[0].ID)
+
+	_, err = client.SendRequest(ctx, EvtDeploymentsRequest, DeploymentsRequestPayload{Action: "purge"})
+	assert.ErrorContains(t, err, "unknown deployments action")
+}
+
+type fakeProjectConfig struct {
+	MockBuildTriggerer
+}
+
+func (fakeProjectConfig) HandleProjectConfig(ctx context.Context, req ProjectConfigRequestPayload) (*ProjectConfigResponsePayload, error) {
+	return &ProjectConfigResponsePayload{Projects: []ProjectConfigPayload{{Name: req.Project}}}, nil
+}
+
+func TestServer_ProjectChangesRestricted(t *testing.T) {
+	server := NewServer(&fakeProjectConfig{}, nil, func(r *http.Request) bool { return true })
+	server.SetAuthenticator(NewTokenAuth("admin-token", "user-token"))
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	connect := func(token string) *Client {
+		client := NewClient()
+		require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), http.Header{"Authorization": []string{"Bearer " + token}}))
+		t.Cleanup(client.Close)
+		return client
+	}
+	admin, user := connect("admin-token"), connect("user-token")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	set := ProjectConfigRequestPayload{Action: ProjectActionSetVariable, Project: "shop", Key: "A", Value: "1"}
+
+	// With an Authenticator the changes need an access function, the reads do not
+	_, err := admin.SendRequest(ctx, EvtProjectConfigRequest, set)
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeUnauthorized, respErr.Code)
+	_, err = user.SendRequest(ctx, EvtProjectConfigRequest, ProjectConfigRequestPayload{Action: ProjectActionGet, Project: "shop"})
+	require.NoError(t, err)
+
+	server.SetProjectAccess(func(caller, project string) bool { return caller == "token-1" })
+	_, err = admin.SendRequest(ctx, EvtProjectConfigRequest, set)
+	require.NoError(t, err)
+	for _, action := range []string{ProjectActionSetSecret, ProjectActionDelete} {
+		_, err = user.SendRequest(ctx, EvtProjectConfigRequest, ProjectConfigRequestPayload{Action: action, Project: "shop", Key: "DB"})
+		require.ErrorAs(t, err, &respErr, action)
+		assert.Equal(t, ErrCodeUnauthorized, respErr.Code, action)
+	}
+}
+
+type fakeBuildLister struct {
+	MockBuildTriggerer
+}
+
+func (fakeBuildLister) HandleBuildList(ctx context.Context, req BuildListPayload) (*BuildListResponsePayload, error) {
+	return &BuildListResponsePayload{Builds: []BuildInfoPayload{{BuildID: "build-2", State: req.State}, {BuildID: "build-1", State: req.State}}}, nil
+}
+
+func (fakeBuildLister) HandleBuildGet(ctx context.Context, req BuildGetPayload) (*BuildGetResponsePayload, error) {
+	if req.BuildID != "build-1" {
+		return nil, WithCode(ErrCodeBuildNotFound, fmt.Errorf("build not found: %s", req.BuildID))
+	}
+	return &BuildGetResponsePayload{Build: BuildInfoPayload{BuildID: "build-1", State: "running"}}, nil
+}
+
+func (fakeBuildLister) HandleBuildCancel(ctx context.Context, req BuildCancelPayload) (*BuildCancelResponsePayload, error) {
+	switch req.BuildID {
+	case "build-1":
+		return &BuildCancelResponsePayload{Build: BuildInfoPayload{BuildID: "build-1", State: "canceled"}}, nil
+	case "build-2":
+		return nil, WithCode(ErrCodeBuildFinished, fmt.Errorf("the build is already finished: %s", req.BuildID))
+	}
+	return nil, WithCode(ErrCodeBuildNotFound, fmt.Errorf("build not found: %s", req.BuildID))
+}
+
+func TestServer_BuildQueries(t *testing.T) {
+	server := NewServer(&fakeBuildLister{}, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := client.SendRequest(ctx, EvtBuildList, BuildListPayload{State: "success"})
+	require.NoError(t, err)
+	list, err := Decode[BuildListResponsePayload](resp)
+	require.NoError(t, err)
+	require.Len(t, list.Builds, 2)
+	assert.Equal(t, "success", list.Builds[0].State)
+
+	resp, err = client.SendRequest(ctx, EvtBuildGet, BuildGetPayload{BuildID: "build-1"})
+	require.NoError(t, err)
+	build, err := Decode[BuildGetResponsePayload](resp)
+	require.NoError(t, err)
+	assert.Equal(t, "running", build.Build.State)
+
+	_, err = client.SendRequest(ctx, EvtBuildGet, BuildGetPayload{BuildID: "build-9"})
+	assert.ErrorContains(t, err, "build not found")
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeBuildNotFound, respErr.Code)
+
+	// Without BuildLister the queries are rejected
+	plain := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	plain.Run()
+	plainServer := httptest.NewServer(plain)
+	defer plainServer.Close()
+	other := NewClient()
+	require.NoError(t, other.Connect("ws"+strings.TrimPrefix(plainServer.URL, "http"), nil))
+	defer other.Close()
+	_, err = other.SendRequest(ctx, EvtBuildList, BuildListPayload{})
+	assert.ErrorContains(t, err, "not supported")
+}
+
+func TestServer_BuildQueriesRestrictedToOwner(t *testing.T) {
+	server := NewServer(&fakeBuildLister{}, nil, func(r *http.Request) bool { return true })
+	server.SetAuthenticator(NewTokenAuth("first-token", "second-token"))
+	server.notifier.registerBuildClient("build-1", newStreamPeer(), "token-1")
+	server.notifier.registerBuildClient("build-2", newStreamPeer(), "token-2")
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	restServer := httptest.NewServer(server.RESTHandler())
+	defer restServer.Close()
+
+	connect := func(token string) *Client {
+		client := NewClient()
+		require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), http.Header{"Authorization": []string{"Bearer " + token}}))
+		t.Cleanup(client.Close)
+		return client
+	}
+	first, second := connect("first-token"), connect("second-token")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for client, buildID := range map[*Client]string{first: "build-1", second: "build-2"} {
+		resp, err := client.SendRequest(ctx, EvtBuildList, BuildListPayload{})
+		require.NoError(t, err)
+		list, err := Decode[BuildListResponsePayload](resp)
+		require.NoError(t, err)
+		require.Len(t, list.Builds, 1)
+		assert.Equal(t, buildID, list.Builds[0].BuildID)
+	}
+
+	resp, err := first.SendRequest(ctx, EvtBuildGet, BuildGetPayload{BuildID: "build-1"})
+	require.NoError(t, err)
+	build, err := Decode[BuildGetResponsePayload](resp)
+	require.NoError(t, err)
+	assert.Equal(t, "build-1", build.Build.BuildID)
+
+	// The build of another caller is answered as an unknown one, over REST too
+	_, err = second.SendRequest(ctx, EvtBuildGet, BuildGetPayload{BuildID: "build-1"})
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeBuildNotFound, respErr.Code)
+	req, err := http.NewRequest(http.MethodGet, restServer.URL+"/api/builds/build-1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer second-token")
+	httpResp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, httpResp.StatusCode)
+}
+
+func TestServer_BuildCancel(t *testing.T) {
+	server := NewServer(&fakeBuildLister{}, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	canceled, err := client.CancelBuild(ctx, "build-1")
+	require.NoError(t, err)
+	assert.Equal(t, "canceled", canceled.State)
+
+	for buildID, code := range map[string]ErrorCode{"build-2": ErrCodeBuildFinished, "build-9": ErrCodeBuildNotFound, "": ErrCodeInvalidPayload} {
+		_, err = client.CancelBuild(ctx, buildID)
+		var respErr *ResponseError
+		require.ErrorAs(t, err, &respErr, buildID)
+		assert.Equal(t, code, respErr.Code, buildID)
+	}
+
+	// Without BuildCanceler the cancellation is rejected
+	plain := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	plain.Run()
+	plainServer := httptest.NewServer(plain)
+	defer plainServer.Close()
+	other := NewClient()
+	require.NoError(t, other.Connect("ws"+strings.TrimPrefix(plainServer.URL, "http"), nil))
+	defer other.Close()
+	_, err = other.SendRequest(ctx, EvtBuildCancel, BuildCancelPayload{BuildID: "build-1"})
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Equal(t, ErrCodeUnsupported, respErr.Code)
+}
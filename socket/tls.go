@@ -0,0 +1,163 @@
+package socket
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ServerTLSConfig configures the TLS of the server, which then accepts wss:// connections only
+type ServerTLSConfig struct {
+	CertFile string // PEM certificate of the server, with its intermediates
+	KeyFile  string // PEM private key of the certificate
+
+	// ClientCAFile is the PEM bundle of the CAs of the client certificates. When set, the clients
+	// presenting a certificate must present one signed by these CAs (mutual TLS).
+	ClientCAFile string
+	// RequireClientCert rejects the handshakes without client certificate, ClientCAFile is then required
+	RequireClientCert bool
+}
+
+// ClientTLSConfig configures the TLS of the client for the wss:// URLs
+type ClientTLSConfig struct {
+	CAFile             string // PEM bundle of the CAs of the server, the system roots when empty
+	CertFile           string // PEM client certificate, for the servers requiring mutual TLS
+	KeyFile            string // PEM private key of the client certificate
+	ServerName         string // Name checked in the server certificate, the host of the URL when empty
+	InsecureSkipVerify bool   // Accepts any server certificate (local testing only)
+}
+
+// TLSConfig loads the certificates of the server configuration
+func (c ServerTLSConfig) TLSConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("the TLS certificate and key of the server are required")
+	}
+	keyPair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the server certificate '%s': %w", c.CertFile, err)
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{keyPair}}
+	switch {
+	case c.ClientCAFile != "":
+		pool, err := loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	case c.RequireClientCert:
+		return nil, errors.New("a client CA bundle is required to verify the client certificates")
+	}
+	return config, nil
+}
+
+// TLSConfig loads the CA bundle and the client certificate of the client configuration
+func (c ClientTLSConfig) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		keyPair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the client certificate '%s': %w", c.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{keyPair}
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the CA bundle '%s': %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in '%s'", path)
+	}
+	return pool, nil
+}
+
+// Time given to the running builds to finish when the context of ListenAndServe is done
+const shutdownTimeout = time.Minute
+
+// ListenAndServe serves the websocket endpoint on addr until ctx is done, over TLS when tlsConfig
+// is not nil, then shuts the server down. The hub must be started with Run.
+func (s *Server) ListenAndServe(ctx context.Context, addr string, tlsConfig *ServerTLSConfig) error {
+	server := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 30 * time.Second}
+	if tlsConfig != nil {
+		config, err := tlsConfig.TLSConfig()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = config
+	}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Server: %v\n", err)
+		}
+	}()
+
+	var err error
+	if server.TLSConfig != nil {
+		log.Printf("Server: Listening on wss://%s\n", addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Server: Listening on ws://%s without TLS\n", addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("websocket server failed: %w", err)
+	}
+	<-shutdown
+	return nil
+}
+
+// ClientCertAuth accepts the requests presenting a client certificate verified by the TLS handshake
+// (ServerTLSConfig.ClientCAFile) and names the caller by the common name of the certificate
+type ClientCertAuth struct{}
+
+func (ClientCertAuth) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", fmt.Errorf("%w: no verified client certificate", ErrUnauthorized)
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+}
+
+// SetTLSConfig configures the TLS of the next connections to wss:// URLs, nil restores the defaults
+func (c *Client) SetTLSConfig(config *ClientTLSConfig) error {
+	dialer := *websocket.DefaultDialer
+	if config != nil {
+		tlsConfig, err := config.TLSConfig()
+		if err != nil {
+			return err
+		}
+		dialer.TLSClientConfig = tlsConfig
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialer = &dialer
+	return nil
+}
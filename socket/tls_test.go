@@ -0,0 +1,110 @@
+package socket
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// writeTestCert writes a certificate and its key signed by parent (self-signed when nil) to dir
+func writeTestCert(t *testing.T, dir, name string, parent *testCert, isCA bool) (*testCert, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         isCA,
+
+		BasicConstraintsValid: true,
+	}
+	signer := &testCert{cert: template, key: key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return &testCert{cert: cert, key: key}, certPath, keyPath
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caPath, _ := writeTestCert(t, dir, "ca", nil, true)
+	_, serverCert, serverKey := writeTestCert(t, dir, "server", ca, false)
+	_, clientCert, clientKey := writeTestCert(t, dir, "builder-1", ca, false)
+
+	tlsConfig, err := ServerTLSConfig{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: caPath}.TLSConfig()
+	require.NoError(t, err)
+	server := NewServer(&MockBuildTriggerer{}, nil, func(r *http.Request) bool { return true })
+	server.SetAuthenticator(ClientCertAuth{})
+	server.Run()
+	httpServer := httptest.NewUnstartedServer(server)
+	httpServer.TLS = tlsConfig
+	httpServer.StartTLS()
+	defer httpServer.Close()
+	wssURL := "wss" + strings.TrimPrefix(httpServer.URL, "https")
+
+	// The server certificate is not trusted without the CA bundle
+	client := NewClient()
+	assert.Error(t, client.Connect(wssURL, nil))
+
+	// Trusted server, but no client certificate
+	require.NoError(t, client.SetTLSConfig(&ClientTLSConfig{CAFile: caPath}))
+	err = client.Connect(wssURL, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+
+	require.NoError(t, client.SetTLSConfig(&ClientTLSConfig{CAFile: caPath, CertFile: clientCert, KeyFile: clientKey}))
+	require.NoError(t, client.Connect(wssURL, nil))
+	client.Close()
+
+	insecure := NewClient()
+	require.NoError(t, insecure.SetTLSConfig(&ClientTLSConfig{InsecureSkipVerify: true, CertFile: clientCert, KeyFile: clientKey}))
+	require.NoError(t, insecure.Connect(wssURL, nil))
+	insecure.Close()
+}
+
+func TestServerTLSConfig_Errors(t *testing.T) {
+	_, err := ServerTLSConfig{}.TLSConfig()
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	_, cert, key := writeTestCert(t, dir, "server", nil, false)
+	_, err = ServerTLSConfig{CertFile: cert, KeyFile: key, RequireClientCert: true}.TLSConfig()
+	assert.ErrorContains(t, err, "client CA bundle")
+	_, err = ClientTLSConfig{CAFile: key}.TLSConfig()
+	assert.ErrorContains(t, err, "no certificate found")
+}
@@ -0,0 +1,82 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// SubmitBuild sends a build specification to the server and returns its acknowledgement with the ID of
+// the build. The client is attached to the build, its messages are pushed to Incoming, see WatchBuild.
+func (c *Client) SubmitBuild(ctx context.Context, buildSpecYAML string, requirements *BuildRequirements) (*BuildQueuedPayload, error) {
+	resp, err := c.SendRequest(ctx, EvtBuildRequest, BuildRequestPayload{BuildSpecYAML: buildSpecYAML, Requirements: requirements})
+	if err != nil {
+		return nil, err
+	}
+	queued, err := Decode[BuildQueuedPayload](resp)
+	if err != nil {
+		return nil, err
+	}
+	if queued.BuildID == "" {
+		return nil, fmt.Errorf("no build ID in the acknowledgement of the build request")
+	}
+	return &queued, nil
+}
+
+// CancelBuild asks the server to cancel a queued build or to interrupt a running one and returns the
+// build as the server saw it. A running build sends its final status once it is stopped.
+func (c *Client) CancelBuild(ctx context.Context, buildID string) (*BuildInfoPayload, error) {
+	resp, err := c.SendRequest(ctx, EvtBuildCancel, BuildCancelPayload{BuildID: buildID})
+	if err != nil {
+		return nil, err
+	}
+	canceled, err := Decode[BuildCancelResponsePayload](resp)
+	if err != nil {
+		return nil, err
+	}
+	return &canceled.Build, nil
+}
+
+// WatchBuild reads Incoming until the final status of a build and returns it. handle, if not nil,
+// receives the payloads of the build in their order: BuildQueuedPayload (the position updates),
+// LogChunkPayload, BuildProgressPayload and BuildStatusPayload, the final one included. The messages
+// of the other builds are dropped, Incoming must not be read by another goroutine meanwhile.
+// After a reconnection the client attaches to the build again, the missed log chunks are replayed.
+func (c *Client) WatchBuild(ctx context.Context, buildID string, handle func(Payload)) (*BuildStatusPayload, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case msg := <-c.Incoming:
+			event, err := msg.Event()
+			if err != nil {
+				log.Printf("Client: Invalid message type %s while watching the build %s: %v\n", msg.Type, buildID, err)
+				continue
+			}
+			if eventBuildID(event) != buildID {
+				continue
+			}
+			if handle != nil {
+				handle(event)
+			}
+			if status, ok := event.(BuildStatusPayload); ok && finalStatus(status.Status) {
+				return &status, nil
+			}
+		}
+	}
+}
+
+// eventBuildID returns the build of the payloads sent while a build runs, empty for the others
+func eventBuildID(event Payload) string {
+	switch event := event.(type) {
+	case BuildQueuedPayload:
+		return event.BuildID
+	case LogChunkPayload:
+		return event.BuildID
+	case BuildProgressPayload:
+		return event.BuildID
+	case BuildStatusPayload:
+		return event.BuildID
+	}
+	return ""
+}
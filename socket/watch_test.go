@@ -0,0 +1,75 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SubmitAndWatchBuild(t *testing.T) {
+	builds := &MockBuildTriggerer{
+		StartBuildFunc: func(ctx context.Context, buildID string, buildSpecYAML string, notifier BuildNotifier) error {
+			if buildSpecYAML != "name: app" {
+				return errors.New("unexpected spec")
+			}
+			go func() {
+				notifier.NotifyStatus(buildID, "building", "", nil, nil)
+				notifier.NotifyLog(buildID, "stdout", "Step 1/2")
+				notifier.(ProgressNotifier).NotifyProgress(BuildProgressPayload{BuildID: buildID, Phase: "build", Current: 1, Total: 2})
+				notifier.NotifyLog(buildID, "stderr", "warning")
+				notifier.NotifyLog("build-other", "stdout", "not mine")
+				duration := 1.5
+				notifier.NotifyStatus(buildID, "success", "app:1.0", nil, &duration)
+			}()
+			return nil
+		},
+	}
+	server := NewServer(builds, nil, func(r *http.Request) bool { return true })
+	server.Run()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient()
+	require.NoError(t, client.Connect("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	queued, err := client.SubmitBuild(ctx, "name: app", nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, queued.BuildID)
+
+	var events []string
+	status, err := client.WatchBuild(ctx, queued.BuildID, func(event Payload) {
+		switch event := event.(type) {
+		case LogChunkPayload:
+			events = append(events, event.Stream+":"+event.Content)
+		case BuildProgressPayload:
+			events = append(events, "progress:"+event.Phase)
+		case BuildStatusPayload:
+			events = append(events, "status:"+event.Status)
+		}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "success", status.Status)
+	assert.Equal(t, "app:1.0", status.ArtifactRef)
+	assert.Equal(t, []string{"status:building", "stdout:Step 1/2", "progress:build", "stderr:warning", "status:success"}, events)
+
+	// A rejected build
+	_, err = client.SubmitBuild(ctx, "name: other", nil)
+	var respErr *ResponseError
+	require.ErrorAs(t, err, &respErr)
+	assert.Contains(t, respErr.Details, "unexpected spec")
+
+	// Nothing more for the build, the wait ends with the context
+	shortCtx, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	_, err = client.WatchBuild(shortCtx, queued.BuildID, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}